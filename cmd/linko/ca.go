@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/monsterxx03/linko/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+const macSystemKeychain = "/Library/Keychains/System.keychain"
+
+var (
+	caCertFlag string
+
+	// 以下变量便于测试替换
+	runCACommand = func(name string, args ...string) ([]byte, error) {
+		return exec.Command(name, args...).CombinedOutput()
+	}
+	caLookPath      = exec.LookPath
+	caUseSudo       = os.Geteuid() != 0
+	debianAnchorDir = "/usr/local/share/ca-certificates"
+)
+
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Manage the MITM CA certificate in the system trust store",
+}
+
+var caInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Add the MITM CA certificate to the system trust store",
+	Long: `Install adds the CA certificate generated by 'linko gen-ca' to the system
+trust store:
+
+  Linux   update-ca-certificates (Debian/Ubuntu) or trust (p11-kit, Fedora/Arch)
+  macOS   security add-trusted-cert (System keychain)
+
+Running it again when the certificate is already trusted does nothing.
+Browsers with their own certificate store (e.g. Firefox) still need a manual
+import; instructions are printed afterwards.
+
+Commands that modify the trust store are run through sudo.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runCAInstall(runtime.GOOS, caCertFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var caUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Remove the MITM CA certificate from the system trust store",
+	Long: `Uninstall reverses 'linko ca install'. Running it when the certificate is
+not installed does nothing.
+
+Commands that modify the trust store are run through sudo.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runCAUninstall(runtime.GOOS, caCertFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	caCmd.PersistentFlags().StringVar(&caCertFlag, "cert", config.DefaultConfig().MITM.CACertPath, "CA certificate path")
+	caCmd.AddCommand(caInstallCmd)
+	caCmd.AddCommand(caUninstallCmd)
+}
+
+// caCert is the CA certificate being installed
+type caCert struct {
+	path       string
+	pem        []byte
+	commonName string
+	sha1       string // 大写十六进制，与 security 输出一致
+}
+
+func loadCACert(path string) (*caCert, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate (run 'linko gen-ca' first): %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM certificate found in %s", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum(cert.Raw)
+	return &caCert{
+		path:       abs,
+		pem:        data,
+		commonName: cert.Subject.CommonName,
+		sha1:       strings.ToUpper(hex.EncodeToString(sum[:])),
+	}, nil
+}
+
+// caCommand is a single command run against a trust store
+type caCommand struct {
+	name string
+	args []string
+}
+
+func newCACommand(name string, args ...string) caCommand {
+	if caUseSudo {
+		return caCommand{name: "sudo", args: append([]string{name}, args...)}
+	}
+	return caCommand{name: name, args: args}
+}
+
+func (c caCommand) String() string {
+	return strings.Join(append([]string{c.name}, c.args...), " ")
+}
+
+// trustStore builds the commands that add or remove the CA for one OS trust store
+type trustStore interface {
+	Name() string
+	Installed(cert *caCert) (bool, error)
+	InstallCommands(cert *caCert) []caCommand
+	UninstallCommands(cert *caCert) []caCommand
+}
+
+func detectTrustStore(goos string) (trustStore, error) {
+	switch goos {
+	case "darwin":
+		return macKeychainStore{}, nil
+	case "linux":
+		if _, err := caLookPath("update-ca-certificates"); err == nil {
+			return debianStore{}, nil
+		}
+		if _, err := caLookPath("trust"); err == nil {
+			return p11KitStore{}, nil
+		}
+		return nil, errors.New("neither update-ca-certificates nor trust found, install the certificate manually")
+	default:
+		return nil, fmt.Errorf("unsupported OS %q, install the certificate manually", goos)
+	}
+}
+
+// debianStore uses update-ca-certificates (Debian/Ubuntu/Alpine)
+type debianStore struct{}
+
+func (debianStore) Name() string { return "update-ca-certificates" }
+
+func (debianStore) anchorPath() string {
+	return filepath.Join(debianAnchorDir, "linko.crt")
+}
+
+func (s debianStore) Installed(cert *caCert) (bool, error) {
+	data, err := os.ReadFile(s.anchorPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(data, cert.pem), nil
+}
+
+func (s debianStore) InstallCommands(cert *caCert) []caCommand {
+	return []caCommand{
+		newCACommand("mkdir", "-p", debianAnchorDir),
+		newCACommand("cp", cert.path, s.anchorPath()),
+		newCACommand("update-ca-certificates"),
+	}
+}
+
+func (s debianStore) UninstallCommands(cert *caCert) []caCommand {
+	return []caCommand{
+		newCACommand("rm", "-f", s.anchorPath()),
+		newCACommand("update-ca-certificates", "--fresh"),
+	}
+}
+
+// p11KitStore uses the p11-kit trust tool (Fedora/RHEL/Arch)
+type p11KitStore struct{}
+
+func (p11KitStore) Name() string { return "trust" }
+
+func (p11KitStore) Installed(cert *caCert) (bool, error) {
+	// trust list only shows labels, which stay the same when gen-ca regenerates the CA,
+	// dump includes each anchor as PEM so the fingerprint can be compared
+	out, err := runCACommand("trust", "dump", "--filter=ca-anchors")
+	if err != nil {
+		return false, fmt.Errorf("trust dump: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	for rest := out; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return false, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		sum := sha1.Sum(block.Bytes)
+		if strings.ToUpper(hex.EncodeToString(sum[:])) == cert.sha1 {
+			return true, nil
+		}
+	}
+}
+
+func (p11KitStore) InstallCommands(cert *caCert) []caCommand {
+	return []caCommand{newCACommand("trust", "anchor", "--store", cert.path)}
+}
+
+func (p11KitStore) UninstallCommands(cert *caCert) []caCommand {
+	return []caCommand{newCACommand("trust", "anchor", "--remove", cert.path)}
+}
+
+// macKeychainStore uses security with the System keychain
+type macKeychainStore struct{}
+
+func (macKeychainStore) Name() string { return "macOS System keychain" }
+
+func (macKeychainStore) Installed(cert *caCert) (bool, error) {
+	out, err := runCACommand("security", "find-certificate", "-a", "-Z", "-c", cert.commonName, macSystemKeychain)
+	if err != nil {
+		// 找不到证书时 security 以非零状态退出
+		return false, nil
+	}
+	return strings.Contains(string(out), "SHA-1 hash: "+cert.sha1), nil
+}
+
+func (macKeychainStore) InstallCommands(cert *caCert) []caCommand {
+	return []caCommand{
+		newCACommand("security", "add-trusted-cert", "-d", "-r", "trustRoot", "-k", macSystemKeychain, cert.path),
+	}
+}
+
+func (macKeychainStore) UninstallCommands(cert *caCert) []caCommand {
+	return []caCommand{
+		newCACommand("security", "remove-trusted-cert", "-d", cert.path),
+		newCACommand("security", "delete-certificate", "-Z", cert.sha1, macSystemKeychain),
+	}
+}
+
+func runCACommands(cmds []caCommand) error {
+	for _, c := range cmds {
+		fmt.Printf("  $ %s\n", c)
+		if out, err := runCACommand(c.name, c.args...); err != nil {
+			return fmt.Errorf("%s: %w: %s", c, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+func runCAInstall(goos, certPath string) error {
+	cert, err := loadCACert(certPath)
+	if err != nil {
+		return err
+	}
+	store, err := detectTrustStore(goos)
+	if err != nil {
+		printManualCAInstructions(goos, cert)
+		return err
+	}
+
+	installed, err := store.Installed(cert)
+	if err != nil {
+		return err
+	}
+	if installed {
+		fmt.Printf("CA certificate already installed in %s.\n", store.Name())
+	} else {
+		fmt.Printf("Installing CA certificate into %s...\n", store.Name())
+		if err := runCACommands(store.InstallCommands(cert)); err != nil {
+			return err
+		}
+		fmt.Println("  OK: CA certificate installed")
+	}
+	printManualCAInstructions(goos, cert)
+	return nil
+}
+
+func runCAUninstall(goos, certPath string) error {
+	cert, err := loadCACert(certPath)
+	if err != nil {
+		return err
+	}
+	store, err := detectTrustStore(goos)
+	if err != nil {
+		return err
+	}
+
+	installed, err := store.Installed(cert)
+	if err != nil {
+		return err
+	}
+	if !installed {
+		fmt.Printf("CA certificate not installed in %s.\n", store.Name())
+		return nil
+	}
+	fmt.Printf("Removing CA certificate from %s...\n", store.Name())
+	if err := runCACommands(store.UninstallCommands(cert)); err != nil {
+		return err
+	}
+	fmt.Println("  OK: CA certificate removed")
+	return nil
+}
+
+func printManualCAInstructions(goos string, cert *caCert) {
+	fmt.Println()
+	fmt.Println("Browsers with their own certificate store need a manual import:")
+	fmt.Println("  Firefox: Settings > Privacy & Security > Certificates > View Certificates >")
+	fmt.Printf("           Authorities > Import, select %s and trust it for websites.\n", cert.path)
+	if goos == "linux" {
+		fmt.Println("  Chrome/Chromium (NSS database):")
+		fmt.Printf("           certutil -d sql:$HOME/.pki/nssdb -A -t C,, -n %q -i %s\n", cert.commonName, cert.path)
+	}
+	fmt.Println("Restart the browser afterwards.")
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monsterxx03/linko/pkg/mitm"
+)
+
+// fakeCARunner records commands and answers them from outputs keyed by command line
+type fakeCARunner struct {
+	calls   []string
+	outputs map[string]string
+	fail    map[string]bool
+}
+
+func (f *fakeCARunner) run(name string, args ...string) ([]byte, error) {
+	line := strings.Join(append([]string{name}, args...), " ")
+	f.calls = append(f.calls, line)
+	if f.fail[line] {
+		return nil, errors.New("exit status 1")
+	}
+	return []byte(f.outputs[line]), nil
+}
+
+// setupCATest generates a CA and stubs the trust store tools
+func setupCATest(t *testing.T, tools ...string) (*caCert, *fakeCARunner) {
+	t.Helper()
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "ca.crt")
+	if err := mitm.CreateCAOnly(certPath, filepath.Join(dir, "ca.key"), time.Hour); err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	cert, err := loadCACert(certPath)
+	if err != nil {
+		t.Fatalf("Failed to load CA: %v", err)
+	}
+
+	runner := stubCATools(t, tools...)
+	debianAnchorDir = filepath.Join(dir, "anchors")
+	return cert, runner
+}
+
+// stubCATools swaps in a fake runner and a PATH lookup that only finds tools
+func stubCATools(t *testing.T, tools ...string) *fakeCARunner {
+	t.Helper()
+	runner := &fakeCARunner{outputs: map[string]string{}, fail: map[string]bool{}}
+	origRun, origLook, origSudo, origDir := runCACommand, caLookPath, caUseSudo, debianAnchorDir
+	t.Cleanup(func() {
+		runCACommand, caLookPath, caUseSudo, debianAnchorDir = origRun, origLook, origSudo, origDir
+	})
+	runCACommand = runner.run
+	caLookPath = func(file string) (string, error) {
+		for _, tool := range tools {
+			if tool == file {
+				return "/usr/bin/" + file, nil
+			}
+		}
+		return "", errors.New("not found")
+	}
+	caUseSudo = false
+	return runner
+}
+
+func TestDetectTrustStore(t *testing.T) {
+	tests := []struct {
+		goos  string
+		tools []string
+		want  string
+	}{
+		{"darwin", nil, "macOS System keychain"},
+		{"linux", []string{"update-ca-certificates", "trust"}, "update-ca-certificates"},
+		{"linux", []string{"trust"}, "trust"},
+		{"linux", nil, ""},
+		{"windows", nil, ""},
+	}
+	for _, tt := range tests {
+		stubCATools(t, tt.tools...)
+		store, err := detectTrustStore(tt.goos)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("detectTrustStore(%s, %v): expected error, got %s", tt.goos, tt.tools, store.Name())
+			}
+			continue
+		}
+		if err != nil || store.Name() != tt.want {
+			t.Errorf("detectTrustStore(%s, %v) = %v, %v, want %s", tt.goos, tt.tools, store, err, tt.want)
+		}
+	}
+}
+
+func TestCAInstall_Debian(t *testing.T) {
+	cert, runner := setupCATest(t, "update-ca-certificates")
+	anchor := filepath.Join(debianAnchorDir, "linko.crt")
+
+	if err := runCAInstall("linux", cert.path); err != nil {
+		t.Fatalf("install failed: %v", err)
+	}
+	want := []string{
+		"mkdir -p " + debianAnchorDir,
+		"cp " + cert.path + " " + anchor,
+		"update-ca-certificates",
+	}
+	if !reflect.DeepEqual(runner.calls, want) {
+		t.Errorf("Expected commands %v, got %v", want, runner.calls)
+	}
+
+	// The fake runner does not copy, so place the anchor as cp would have
+	if err := os.MkdirAll(debianAnchorDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(anchor, cert.pem, 0644); err != nil {
+		t.Fatal(err)
+	}
+	runner.calls = nil
+	if err := runCAInstall("linux", cert.path); err != nil {
+		t.Fatalf("second install failed: %v", err)
+	}
+	if len(runner.calls) != 0 {
+		t.Errorf("Expected no commands when already installed, got %v", runner.calls)
+	}
+
+	if err := runCAUninstall("linux", cert.path); err != nil {
+		t.Fatalf("uninstall failed: %v", err)
+	}
+	want = []string{"rm -f " + anchor, "update-ca-certificates --fresh"}
+	if !reflect.DeepEqual(runner.calls, want) {
+		t.Errorf("Expected commands %v, got %v", want, runner.calls)
+	}
+}
+
+func TestCAInstall_P11Kit(t *testing.T) {
+	// An anchor of an earlier CA carries the same label but isn't the current certificate
+	other, _ := setupCATest(t, "trust")
+	cert, runner := setupCATest(t, "trust")
+	const list = "trust dump --filter=ca-anchors"
+	anchor := func(c *caCert) string {
+		return "[p11-kit-object-v1]\nlabel: \"Linko MITM CA\"\ntrusted: true\n" + string(c.pem)
+	}
+	runner.outputs[list] = anchor(other)
+
+	if err := runCAUninstall("linux", cert.path); err != nil {
+		t.Fatalf("uninstall failed: %v", err)
+	}
+	if !reflect.DeepEqual(runner.calls, []string{list}) {
+		t.Errorf("Expected only the list command when not installed, got %v", runner.calls)
+	}
+
+	runner.calls = nil
+	if err := runCAInstall("linux", cert.path); err != nil {
+		t.Fatalf("install failed: %v", err)
+	}
+	want := []string{list, "trust anchor --store " + cert.path}
+	if !reflect.DeepEqual(runner.calls, want) {
+		t.Errorf("Expected commands %v, got %v", want, runner.calls)
+	}
+
+	runner.outputs[list] = anchor(other) + anchor(cert)
+	runner.calls = nil
+	if err := runCAInstall("linux", cert.path); err != nil {
+		t.Fatalf("second install failed: %v", err)
+	}
+	if !reflect.DeepEqual(runner.calls, []string{list}) {
+		t.Errorf("Expected no install commands when already installed, got %v", runner.calls)
+	}
+
+	runner.calls = nil
+	if err := runCAUninstall("linux", cert.path); err != nil {
+		t.Fatalf("uninstall failed: %v", err)
+	}
+	want = []string{list, "trust anchor --remove " + cert.path}
+	if !reflect.DeepEqual(runner.calls, want) {
+		t.Errorf("Expected commands %v, got %v", want, runner.calls)
+	}
+}
+
+func TestCAInstall_MacOS(t *testing.T) {
+	cert, runner := setupCATest(t)
+	caUseSudo = true
+	find := "security find-certificate -a -Z -c Linko MITM CA " + macSystemKeychain
+	runner.fail[find] = true
+
+	if err := runCAInstall("darwin", cert.path); err != nil {
+		t.Fatalf("install failed: %v", err)
+	}
+	want := []string{find, "sudo security add-trusted-cert -d -r trustRoot -k " + macSystemKeychain + " " + cert.path}
+	if !reflect.DeepEqual(runner.calls, want) {
+		t.Errorf("Expected commands %v, got %v", want, runner.calls)
+	}
+
+	// A different certificate with the same name does not count as installed
+	delete(runner.fail, find)
+	runner.outputs[find] = "SHA-1 hash: 0000000000000000000000000000000000000000\n"
+	runner.calls = nil
+	if err := runCAUninstall("darwin", cert.path); err != nil {
+		t.Fatalf("uninstall failed: %v", err)
+	}
+	if !reflect.DeepEqual(runner.calls, []string{find}) {
+		t.Errorf("Expected only the lookup for a foreign certificate, got %v", runner.calls)
+	}
+
+	runner.outputs[find] += "SHA-1 hash: " + cert.sha1 + "\n"
+	runner.calls = nil
+	if err := runCAUninstall("darwin", cert.path); err != nil {
+		t.Fatalf("uninstall failed: %v", err)
+	}
+	want = []string{
+		find,
+		"sudo security remove-trusted-cert -d " + cert.path,
+		"sudo security delete-certificate -Z " + cert.sha1 + " " + macSystemKeychain,
+	}
+	if !reflect.DeepEqual(runner.calls, want) {
+		t.Errorf("Expected commands %v, got %v", want, runner.calls)
+	}
+}
+
+func TestCAInstall_CommandFailure(t *testing.T) {
+	cert, runner := setupCATest(t, "trust")
+	runner.fail["trust anchor --store "+cert.path] = true
+
+	err := runCAInstall("linux", cert.path)
+	if err == nil || !strings.Contains(err.Error(), "trust anchor --store") {
+		t.Errorf("Expected the failing command in the error, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log/slog"
 	"os"
 
+	"github.com/monsterxx03/linko/pkg/version"
 	"github.com/spf13/cobra"
 )
 
@@ -27,26 +28,16 @@ func main() {
 	rootCmd.AddCommand(updateCnIPCmd)
 	rootCmd.AddCommand(isCnIPCmd)
 	rootCmd.AddCommand(genCaCmd)
+	rootCmd.AddCommand(caCmd)
 	rootCmd.AddCommand(cleanupCmd)
 	rootCmd.AddCommand(versionCmd)
 
+	// linko --version 与 linko version 输出一致
+	rootCmd.Version = version.Version
+	rootCmd.SetVersionTemplate(version.Get().String())
+
 	if err := rootCmd.Execute(); err != nil {
 		slog.Error("failed to execute command", "error", err)
 		os.Exit(1)
 	}
 }
-
-func parseLogLevel(level string) slog.Level {
-	switch level {
-	case "debug":
-		return slog.LevelDebug
-	case "info":
-		return slog.LevelInfo
-	case "warn":
-		return slog.LevelWarn
-	case "error":
-		return slog.LevelError
-	default:
-		return slog.LevelInfo
-	}
-}
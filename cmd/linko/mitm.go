@@ -8,6 +8,7 @@ import (
 	"syscall"
 
 	"github.com/monsterxx03/linko/pkg/config"
+	"github.com/monsterxx03/linko/pkg/logging"
 	"github.com/monsterxx03/linko/pkg/proxy"
 	"github.com/spf13/cobra"
 )
@@ -67,9 +68,12 @@ func runMITM(cmd *cobra.Command, args []string) {
 		cfg.MITM.CustomOpenAIMatches = strings.Split(mitmOpenAIMatch, ",")
 	}
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: parseLogLevel(mitmLogLevel),
-	}))
+	logger, logCloser, err := logging.NewLogger(cfg.Server)
+	if err != nil {
+		slog.Error("failed to create logger", "error", err)
+		os.Exit(1)
+	}
+	defer logCloser.Close()
 	slog.SetDefault(logger)
 
 	// 直连模式：禁用上游代理
@@ -8,6 +8,7 @@ import (
 
 	"github.com/monsterxx03/linko/pkg/config"
 	"github.com/monsterxx03/linko/pkg/dns"
+	"github.com/monsterxx03/linko/pkg/logging"
 	"github.com/monsterxx03/linko/pkg/proxy"
 	"github.com/spf13/cobra"
 )
@@ -41,9 +42,12 @@ func runServer(cmd *cobra.Command, args []string) {
 		cfg.Server.LogLevel = logLevel
 	}
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: parseLogLevel(cfg.Server.LogLevel),
-	}))
+	logger, logCloser, err := logging.NewLogger(cfg.Server)
+	if err != nil {
+		slog.Error("failed to create logger", "error", err)
+		os.Exit(1)
+	}
+	defer logCloser.Close()
 	slog.SetDefault(logger)
 
 	// 创建 DNS 组件
@@ -55,6 +59,16 @@ func runServer(cmd *cobra.Command, args []string) {
 		cfg.DNS.TCPForForeign,
 		upstreamClient,
 	)
+	if err := dnsSplitter.SetProtocols(cfg.DNS.DomesticProtocol, cfg.DNS.ForeignDNSProtocol()); err != nil {
+		slog.Error("invalid DNS config", "error", err)
+		os.Exit(1)
+	}
+	if err := dnsSplitter.SetBindAddrs(cfg.DNS.DomesticBind, cfg.DNS.ForeignBind); err != nil {
+		slog.Error("invalid DNS config", "error", err)
+		os.Exit(1)
+	}
+	dnsSplitter.SetEDNS(cfg.DNS.EDNSBufferSize, cfg.DNS.EDNSDNSSECOK)
+	dnsSplitter.SetFallback(cfg.DNS.Fallback)
 
 	sc := &ServerConfig{
 		DNSSplitter: dnsSplitter,
@@ -77,6 +91,6 @@ func runServer(cmd *cobra.Command, args []string) {
 
 func init() {
 	defaultConfigPath := filepath.Join(config.GetConfigDir(), "linko.yaml")
-	serveCmd.Flags().StringVarP(&configPath, "config", "c", defaultConfigPath, "Configuration file path")
+	serveCmd.Flags().StringVarP(&configPath, "config", "c", defaultConfigPath, "Configuration file path (- to read from stdin)")
 	serveCmd.Flags().StringVar(&logLevel, "log-level", "info", "Log level (debug, info, warn, error)")
 }
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/monsterxx03/linko/pkg/admin"
 	"github.com/monsterxx03/linko/pkg/config"
 	"github.com/monsterxx03/linko/pkg/dns"
+	"github.com/monsterxx03/linko/pkg/ipdb"
 	"github.com/monsterxx03/linko/pkg/mitm"
 	"github.com/monsterxx03/linko/pkg/proxy"
+	"github.com/monsterxx03/linko/pkg/version"
 )
 
 // ServerConfig 通用服务器配置
@@ -29,22 +33,60 @@ func RunServer(cfg *config.Config, sc *ServerConfig, logger *slog.Logger) error
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	info := version.Get()
+	slog.Info("linko starting", "version", info.Version, "commit", info.Commit, "date", info.Date, "go", info.GoVersion)
+
 	if err := config.EnsureDirectories(cfg); err != nil {
 		return err
 	}
 
+	// 检查中国 IP 数据库，缺失时分流会退化为全部走国外 DNS
+	if sc.DNSSplitter != nil || sc.SkipCN {
+		checkChinaIPDatabase(cfg.DNS.ChinaIPMaxAge)
+	}
+
 	var transparentProxy *proxy.TransparentProxy
 	var dnsServer *dns.DNSServer
 	var mitmManager *mitm.Manager
 	var adminServer *admin.AdminServer
 	var firewallManager *proxy.FirewallManager
 
+	if !proxy.ValidGeoIPErrorPolicy(cfg.Firewall.OnGeoIPError) {
+		return fmt.Errorf("unsupported firewall on_geoip_error %q, expected upstream or direct", cfg.Firewall.OnGeoIPError)
+	}
+
 	// 创建 upstream client
+	if !proxy.ValidProxyProtocol(cfg.Upstream.ProxyProtocol) {
+		return fmt.Errorf("unsupported upstream proxy_protocol %q, expected v1 or v2", cfg.Upstream.ProxyProtocol)
+	}
 	upstreamClient := proxy.NewUpstreamClient(cfg.Upstream)
 
 	// 启动透明代理
 	slog.Info("starting transparent proxy", "address", "127.0.0.1:"+cfg.ProxyPort())
 	transparentProxy = proxy.NewTransparentProxy("127.0.0.1:"+cfg.ProxyPort(), upstreamClient)
+	transparentProxy.SetListenFamily(cfg.Server.ListenFamily)
+	transparentProxy.SetConnectionLimit(cfg.Server.MaxConnections, cfg.Server.ConnectionQueueTimeout)
+	if cfg.Server.AcceptProxyProtocol {
+		if len(cfg.Server.ProxyProtocolTrusted) == 0 {
+			return fmt.Errorf("accept_proxy_protocol requires proxy_protocol_trusted")
+		}
+		if err := transparentProxy.SetProxyProtocol(cfg.Server.ProxyProtocolTrusted); err != nil {
+			return err
+		}
+	}
+	if cfg.Upstream.ForceUpstream {
+		if !cfg.Upstream.Enable {
+			slog.Warn("force_upstream is set but upstream is disabled, all proxied connections will fail")
+		}
+		transparentProxy.SetForceUpstream(true)
+	}
+	if len(cfg.Server.AllowedClients) > 0 || len(cfg.Server.DeniedClients) > 0 {
+		acl, err := proxy.NewACL(cfg.Server.AllowedClients, cfg.Server.DeniedClients)
+		if err != nil {
+			return err
+		}
+		transparentProxy.SetACL(acl)
+	}
 	transparentProxy.SetOnPanic(func(recovered interface{}) {
 		slog.Error("proxy goroutine panicked, triggering shutdown", "panic", recovered)
 		// 向 sigChan 发送信号触发优雅关闭（非阻塞）
@@ -62,6 +104,44 @@ func RunServer(cfg *config.Config, sc *ServerConfig, logger *slog.Logger) error
 	if sc.EnableDNS {
 		slog.Info("starting DNS server", "address", cfg.DNS.ListenAddr)
 		dnsServer = dns.NewDNSServer(cfg.DNS.ListenAddr, sc.DNSSplitter, sc.DNSCache)
+		dnsServer.SetProtocols(cfg.DNS.ListenUDP, cfg.DNS.ListenTCP)
+		dnsServer.SetStatsMaxDomains(cfg.DNS.StatsMaxDomains)
+		dnsServer.SetMinimizeResponses(cfg.DNS.MinimizeResponses)
+		if len(cfg.DNS.Rewrite) > 0 {
+			rules := make(map[string][]string, len(cfg.DNS.Rewrite))
+			for _, rule := range cfg.DNS.Rewrite {
+				rules[rule.Domain] = append(rules[rule.Domain], rule.IPs...)
+			}
+			rewriter, err := dns.NewAnswerRewriter(rules)
+			if err != nil {
+				return err
+			}
+			dnsServer.SetRewriter(rewriter)
+			slog.Info("DNS answer rewrite enabled", "domains", len(cfg.DNS.Rewrite))
+		}
+		if len(cfg.DNS.Allowlist) > 0 {
+			allowlist, err := dns.NewDomainAllowlist(cfg.DNS.Allowlist)
+			if err != nil {
+				return err
+			}
+			dnsServer.SetAllowlist(allowlist)
+			slog.Info("DNS allowlist enabled, other domains get NXDOMAIN", "entries", len(cfg.DNS.Allowlist))
+		}
+		if cfg.DNS.CachePersist && sc.DNSCache != nil {
+			if n, err := sc.DNSCache.LoadFromFile(cfg.DNS.CacheFile); err != nil {
+				slog.Warn("failed to load DNS cache", "path", cfg.DNS.CacheFile, "error", err)
+			} else {
+				slog.Info("DNS cache loaded", "path", cfg.DNS.CacheFile, "entries", n)
+			}
+			// 在 DNS 服务器停止后保存，避免与进行中的查询竞争
+			defer func() {
+				if n, err := sc.DNSCache.SaveToFile(cfg.DNS.CacheFile); err != nil {
+					slog.Warn("failed to save DNS cache", "path", cfg.DNS.CacheFile, "error", err)
+				} else {
+					slog.Info("DNS cache saved", "path", cfg.DNS.CacheFile, "entries", n)
+				}
+			}()
+		}
 		if err := dnsServer.Start(); err != nil {
 			return err
 		}
@@ -75,30 +155,100 @@ func RunServer(cfg *config.Config, sc *ServerConfig, logger *slog.Logger) error
 			"cert_cache_dir", cfg.MITM.CertCacheDir,
 		)
 
+		var chaos *mitm.ChaosConfig
+		if cfg.MITM.Chaos.Enable {
+			chaos = &mitm.ChaosConfig{
+				Hosts:        cfg.MITM.Chaos.Hosts,
+				DropRate:     cfg.MITM.Chaos.DropRate,
+				TruncateRate: cfg.MITM.Chaos.TruncateRate,
+				DelayRate:    cfg.MITM.Chaos.DelayRate,
+				Delay:        cfg.MITM.Chaos.Delay,
+			}
+		}
+
+		var webhook *mitm.WebhookConfig
+		if cfg.MITM.Webhook.Enable {
+			webhook = &mitm.WebhookConfig{
+				URL:        cfg.MITM.Webhook.URL,
+				SampleRate: cfg.MITM.Webhook.SampleRate,
+				QueueSize:  cfg.MITM.Webhook.QueueSize,
+				MaxRetries: cfg.MITM.Webhook.MaxRetries,
+				Backoff:    cfg.MITM.Webhook.Backoff,
+				Timeout:    cfg.MITM.Webhook.Timeout,
+			}
+		}
+
+		var responseCache *mitm.ResponseCacheConfig
+		if cfg.MITM.ResponseCache.Enable {
+			responseCache = &mitm.ResponseCacheConfig{
+				MaxEntries:   cfg.MITM.ResponseCache.MaxEntries,
+				MaxEntrySize: cfg.MITM.ResponseCache.MaxEntrySize,
+			}
+		}
+
+		var forwardedFor string
+		if cfg.MITM.ForwardedFor.Enable {
+			forwardedFor = cfg.MITM.ForwardedFor.Mode
+		}
+
+		profiles := make([]mitm.InspectionProfile, 0, len(cfg.MITM.Profiles))
+		for _, p := range cfg.MITM.Profiles {
+			profiles = append(profiles, mitm.InspectionProfile{Hosts: p.Hosts, Inspectors: p.Inspectors})
+		}
+
 		var err error
 		mitmManager, err = mitm.NewManager(mitm.ManagerConfig{
 			CACertPath:             cfg.MITM.CACertPath,
 			CAKeyPath:              cfg.MITM.CAKeyPath,
 			CertCacheDir:           cfg.MITM.CertCacheDir,
 			SiteCertValidity:       cfg.MITM.SiteCertValidity,
+			CertRenewWindow:        cfg.MITM.CertRenewWindow,
 			CACertValidity:         cfg.MITM.CACertValidity,
 			Enabled:                true,
 			MaxBodySize:            cfg.MITM.MaxBodySize,
+			MaxRequestBodySize:     cfg.MITM.MaxRequestBodySize,
+			MaxResponseBodySize:    cfg.MITM.MaxResponseBodySize,
+			MaxHeaderSize:          cfg.MITM.MaxHeaderSize,
+			MaxChunkedBodySize:     cfg.MITM.MaxChunkedBodySize,
+			MaxStreamDuration:      cfg.MITM.MaxStreamDuration,
+			SkipRequestBody:        cfg.MITM.SkipRequestBody,
+			SkipResponseBody:       cfg.MITM.SkipResponseBody,
+			MetadataOnly:           cfg.MITM.MetadataOnly,
+			RawBody:                cfg.MITM.CaptureRawBody,
+			SizeBuckets:            cfg.MITM.SizeBuckets,
 			EventHistorySize:       cfg.MITM.EventHistorySize,
 			LLMEventHistorySize:    cfg.MITM.LLMEventHistorySize,
+			EventBufferSize:        cfg.MITM.EventBufferSize,
+			LLMEventBufferSize:     cfg.MITM.LLMEventBufferSize,
 			CustomAnthropicMatches: cfg.MITM.CustomAnthropicMatches,
 			CustomOpenAIMatches:    cfg.MITM.CustomOpenAIMatches,
+			ConversationIDStrategy: cfg.MITM.ConversationIDStrategy,
+			ConversationIDHeader:   cfg.MITM.ConversationIDHeader,
+			SlowTokenRate:          cfg.MITM.SlowTokenRate,
+			SlowTokenWindow:        cfg.MITM.SlowTokenWindow,
+			GRPCHosts:              cfg.MITM.GRPCHosts,
+			KeyLogFile:             cfg.MITM.KeyLogFile,
+			Chaos:                  chaos,
+			Webhook:                webhook,
+			ResponseCache:          responseCache,
+			ForwardedFor:           forwardedFor,
+			Profiles:               profiles,
 		}, logger)
 		if err != nil {
 			slog.Error("failed to initialize MITM manager", "error", err)
 		} else {
 			slog.Info("MITM enabled", "ca_certificate", mitmManager.GetCACertificatePath())
+			defer mitmManager.Close()
 
-			mitmHandler := proxy.NewMITMHandler(transparentProxy, mitmManager, cfg.MITM.Whitelist, logger)
+			mitmHandler := proxy.NewMITMHandler(transparentProxy, mitmManager, cfg.MITM.Whitelist, cfg.MITM.Bypass, logger)
+			mitmHandler.SetAutoBypass(cfg.MITM.AutoBypass)
 			transparentProxy.SetMITMHandler(mitmHandler)
 			if len(cfg.MITM.Whitelist) > 0 {
 				slog.Info("MITM whitelist configured", "domains", cfg.MITM.Whitelist)
 			}
+			if len(cfg.MITM.Bypass) > 0 {
+				slog.Info("MITM bypass configured", "patterns", cfg.MITM.Bypass)
+			}
 		}
 	}
 
@@ -112,10 +262,22 @@ func RunServer(cfg *config.Config, sc *ServerConfig, logger *slog.Logger) error
 			llmEventBus = mitmManager.GetLLMEventBus()
 		}
 		adminServer = admin.NewAdminServer(cfg.Admin.ListenAddr, cfg.Admin.UIPath, cfg.Admin.UIEmbed, dnsServer, eventBus, llmEventBus)
+		adminServer.SetAutoPort(cfg.Admin.AutoPort)
+		adminServer.SetCORSOrigins(cfg.Admin.CORSOrigins)
+		adminServer.SetPprof(cfg.Admin.Pprof, cfg.Admin.PprofToken)
+		adminServer.SetConfig(cfg, cfg.Admin.ConfigToken)
+		adminServer.SetUpstream(upstreamClient)
+		if mitmManager != nil {
+			adminServer.SetTrafficStats(mitmManager.GetTrafficStats())
+			adminServer.SetSiteCertManager(mitmManager.GetSiteCertManager())
+		}
+		addHealthChecks(adminServer, transparentProxy, dnsServer)
 		if err := adminServer.Start(); err != nil {
-			return err
+			// Admin 服务器不影响代理功能，启动失败时降级运行
+			slog.Warn("admin server failed to start, continuing without it", "address", cfg.Admin.ListenAddr, "error", err)
+		} else {
+			defer adminServer.Stop()
 		}
-		defer adminServer.Stop()
 	}
 
 	// 设置防火墙规则
@@ -135,6 +297,24 @@ func RunServer(cfg *config.Config, sc *ServerConfig, logger *slog.Logger) error
 	return nil
 }
 
+// checkChinaIPDatabase loads the China IP database and warns if it is missing or stale
+func checkChinaIPDatabase(maxAge time.Duration) {
+	if err := ipdb.LoadChinaIPRanges(); err != nil {
+		slog.Warn("China IP database unavailable, geo-based routing disabled", "error", err)
+		slog.Info("Run 'linko update-cn-ip' to download China IP data")
+		return
+	}
+
+	status := ipdb.GetStatus()
+	if status.IsStale(maxAge) {
+		slog.Warn("China IP database is stale, run 'linko update-cn-ip' to refresh",
+			"updated_at", status.UpdatedAt,
+			"age", status.Age.Round(time.Hour).String(),
+			"max_age", maxAge.String(),
+		)
+	}
+}
+
 func setupFirewall(cfg *config.Config, sc *ServerConfig) *proxy.FirewallManager {
 	slog.Info("setting up firewall rules")
 
@@ -153,8 +333,10 @@ func setupFirewall(cfg *config.Config, sc *ServerConfig) *proxy.FirewallManager
 		forceProxyIPs,
 		cfg.Firewall.ReservedDomains,
 		cfg.MITM.GID,
-		sc.SkipCN,
+		// 强制走上游时 China IP 也需重定向到代理
+		sc.SkipCN && !cfg.Upstream.ForceUpstream,
 	)
+	firewallManager.SetGeoIPErrorPolicy(cfg.Firewall.OnGeoIPError)
 
 	if err := firewallManager.SetupFirewallRules(); err != nil {
 		slog.Warn("failed to setup firewall rules", "error", err)
@@ -176,3 +358,32 @@ func deferFunc(firewallManager *proxy.FirewallManager) {
 		slog.Info("firewall rules removed successfully")
 	}
 }
+
+// addHealthChecks registers component readiness reported by /health
+func addHealthChecks(adminServer *admin.AdminServer, transparentProxy *proxy.TransparentProxy, dnsServer *dns.DNSServer) {
+	adminServer.AddHealthCheck("proxy", true, func() error {
+		if !transparentProxy.IsListening() {
+			return fmt.Errorf("proxy is not listening on %s", transparentProxy.GetListenAddr())
+		}
+		return nil
+	})
+	if dnsServer != nil {
+		adminServer.AddHealthCheck("dns", true, func() error {
+			if !dnsServer.IsListening() {
+				return fmt.Errorf("DNS server is not listening on %s", dnsServer.GetAddr())
+			}
+			return nil
+		})
+	}
+	// 缺少 GeoIP 时分流退化但代理仍可用
+	adminServer.AddHealthCheck("geoip", false, func() error {
+		status := ipdb.GetStatus()
+		if status.Error != nil {
+			return status.Error
+		}
+		if !status.Initialized {
+			return fmt.Errorf("China IP database not initialized")
+		}
+		return nil
+	})
+}
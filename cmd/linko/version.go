@@ -11,12 +11,6 @@ var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print version information",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Printf("linko version %s\n", version.Version)
-		if version.Commit != "" {
-			fmt.Printf("commit: %s\n", version.Commit)
-		}
-		if version.Date != "" {
-			fmt.Printf("built: %s\n", version.Date)
-		}
+		fmt.Fprint(cmd.OutOrStdout(), version.Get().String())
 	},
 }
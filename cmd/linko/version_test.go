@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/monsterxx03/linko/pkg/version"
+)
+
+func TestVersionCmd_PrintsBuildMetadata(t *testing.T) {
+	origVersion, origCommit, origDate := version.Version, version.Commit, version.Date
+	defer func() {
+		version.Version, version.Commit, version.Date = origVersion, origCommit, origDate
+	}()
+
+	// Simulate values injected via -ldflags -X
+	version.Version = "v1.2.3"
+	version.Commit = "abc1234"
+	version.Date = "2026-01-02T03:04:05Z"
+
+	var out bytes.Buffer
+	versionCmd.SetOut(&out)
+	defer versionCmd.SetOut(nil)
+	versionCmd.Run(versionCmd, nil)
+
+	output := out.String()
+	for _, want := range []string{
+		"linko version v1.2.3",
+		"commit: abc1234",
+		"built: 2026-01-02T03:04:05Z",
+		"go: " + runtime.Version(),
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, output)
+		}
+	}
+}
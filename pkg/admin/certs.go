@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"net/http"
+)
+
+// handleMITMCerts lists the cached site certificates with their expiry
+func (s *AdminServer) handleMITMCerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, StatsResponse{Code: 405, Message: "Method not allowed"})
+		return
+	}
+	if s.siteCerts == nil {
+		s.writeServiceUnavailable(w, "MITM not available")
+		return
+	}
+
+	certs := s.siteCerts.CachedCerts()
+	writeJSON(w, http.StatusOK, StatsResponse{
+		Code:    0,
+		Message: "success",
+		Data:    map[string]any{"certs": certs, "count": len(certs)},
+	})
+}
+
+// handleMITMCertsClear drops cached site certificates from memory and disk
+func (s *AdminServer) handleMITMCertsClear(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, StatsResponse{Code: 405, Message: "Method not allowed"})
+		return
+	}
+	if s.siteCerts == nil {
+		s.writeServiceUnavailable(w, "MITM not available")
+		return
+	}
+
+	s.siteCerts.ClearCache()
+	if err := s.siteCerts.ClearDiskCache(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, StatsResponse{Code: 500, Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, StatsResponse{Code: 0, Message: "success"})
+}
@@ -0,0 +1,93 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/monsterxx03/linko/pkg/mitm"
+)
+
+func newTestSiteCertManager(t *testing.T) (*mitm.SiteCertManager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cm, err := mitm.NewCertManager(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"), 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	cacheDir := filepath.Join(dir, "certs")
+	scm, err := mitm.NewSiteCertManager(cm.GetCACertificate(), cm.GetCAPrivateKey(), cacheDir, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create site cert manager: %v", err)
+	}
+	return scm, cacheDir
+}
+
+func getCerts(t *testing.T, url string) []mitm.CachedCertInfo {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	var body struct {
+		Data struct {
+			Certs []mitm.CachedCertInfo `json:"certs"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return body.Data.Certs
+}
+
+func TestAdminServer_MITMCerts(t *testing.T) {
+	scm, cacheDir := newTestSiteCertManager(t)
+	for _, host := range []string{"b.example.com", "a.example.com"} {
+		if _, err := scm.GetCertificate(host); err != nil {
+			t.Fatalf("Failed to generate certificate for %s: %v", host, err)
+		}
+	}
+
+	server := NewAdminServer("127.0.0.1:0", "", false, nil, nil, nil)
+	server.SetSiteCertManager(scm)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start admin server: %v", err)
+	}
+	defer server.Stop()
+	base := "http://" + server.GetAddr()
+
+	certs := getCerts(t, base+"/api/mitm/certs")
+	if len(certs) != 2 || certs[0].Hostname != "a.example.com" || certs[1].Hostname != "b.example.com" {
+		t.Fatalf("Expected a.example.com and b.example.com, got %+v", certs)
+	}
+	if !certs[0].ExpiresAt.After(time.Now()) {
+		t.Errorf("Expected expiry in the future, got %v", certs[0].ExpiresAt)
+	}
+
+	resp, err := http.Post(base+"/api/mitm/certs/clear", "application/json", nil)
+	if err != nil {
+		t.Fatalf("Clear request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from clear, got %d", resp.StatusCode)
+	}
+
+	if certs := getCerts(t, base+"/api/mitm/certs"); len(certs) != 0 {
+		t.Errorf("Expected empty cache after clear, got %+v", certs)
+	}
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatalf("Failed to read cache dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected disk cache to be cleared, %d files left", len(entries))
+	}
+}
@@ -0,0 +1,18 @@
+package admin
+
+import (
+	"net/http"
+)
+
+// handleConfig serves the running configuration with secrets masked
+func (s *AdminServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, StatsResponse{Code: 405, Message: "Method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, StatsResponse{
+		Code:    0,
+		Message: "success",
+		Data:    s.config.Redacted(),
+	})
+}
@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/monsterxx03/linko/pkg/config"
+)
+
+func TestAdminServer_Config(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Upstream.Username = "alice"
+	cfg.Upstream.Password = "s3cret"
+	cfg.Admin.ConfigToken = "config-token"
+
+	server := NewAdminServer("127.0.0.1:0", "", false, nil, nil, nil)
+	server.SetConfig(cfg, cfg.Admin.ConfigToken)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start admin server: %v", err)
+	}
+	defer server.Stop()
+	url := "http://" + server.GetAddr() + "/api/config"
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without token, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Authorization", "Bearer config-token")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	var body struct {
+		Data struct {
+			Upstream map[string]any `json:"upstream"`
+			Admin    map[string]any `json:"admin"`
+			DNS      map[string]any `json:"dns"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if got := body.Data.Upstream["password"]; got != config.RedactedValue {
+		t.Errorf("Expected password to be redacted, got %v", got)
+	}
+	if got := body.Data.Admin["config_token"]; got != config.RedactedValue {
+		t.Errorf("Expected config token to be redacted, got %v", got)
+	}
+	if got := body.Data.Upstream["username"]; got != "alice" {
+		t.Errorf("Expected username alice, got %v", got)
+	}
+	if got := body.Data.Upstream["addr"]; got != cfg.Upstream.Addr {
+		t.Errorf("Expected upstream addr %s, got %v", cfg.Upstream.Addr, got)
+	}
+	if got := body.Data.DNS["cache_ttl"]; got != "5m0s" {
+		t.Errorf("Expected cache_ttl 5m0s, got %v", got)
+	}
+	// Empty secrets stay empty so it is visible they are unset
+	if got := body.Data.Admin["pprof_token"]; got != "" {
+		t.Errorf("Expected empty pprof token, got %v", got)
+	}
+}
+
+func TestAdminServer_ConfigNotMountedWithoutToken(t *testing.T) {
+	server := NewAdminServer("127.0.0.1:0", "", false, nil, nil, nil)
+	server.SetConfig(config.DefaultConfig(), "")
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start admin server: %v", err)
+	}
+	defer server.Stop()
+
+	resp, err := http.Get("http://" + server.GetAddr() + "/api/config")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 without a config token, got %d", resp.StatusCode)
+	}
+}
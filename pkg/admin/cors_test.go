@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newCORSTestHandler(origins []string) http.Handler {
+	return corsMiddleware(origins, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func doCORSRequest(handler http.Handler, method, origin string, preflight bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://127.0.0.1:9810/stats/dns", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if preflight {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCORSMiddleware_AllowedOriginReflected(t *testing.T) {
+	handler := newCORSTestHandler([]string{"http://localhost:5173"})
+
+	rec := doCORSRequest(handler, http.MethodGet, "http://localhost:5173", false)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "http://localhost:5173" {
+		t.Errorf("Expected origin to be reflected, got %q", got)
+	}
+	if got := rec.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("Expected Vary: Origin, got %q", got)
+	}
+}
+
+func TestCORSMiddleware_DisallowedOriginRejected(t *testing.T) {
+	handler := newCORSTestHandler([]string{"http://localhost:5173"})
+
+	for _, preflight := range []bool{false, true} {
+		method := http.MethodGet
+		if preflight {
+			method = http.MethodOptions
+		}
+		rec := doCORSRequest(handler, method, "http://evil.example.com", preflight)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("preflight=%v: expected 403, got %d", preflight, rec.Code)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("preflight=%v: expected no Access-Control-Allow-Origin, got %q", preflight, got)
+		}
+	}
+}
+
+func TestCORSMiddleware_Preflight(t *testing.T) {
+	handler := newCORSTestHandler([]string{"http://localhost:5173"})
+
+	rec := doCORSRequest(handler, http.MethodOptions, "http://localhost:5173", true)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got == "" {
+		t.Error("Expected Access-Control-Allow-Methods to be set")
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type" {
+		t.Errorf("Expected requested headers to be allowed, got %q", got)
+	}
+}
+
+func TestCORSMiddleware_Wildcard(t *testing.T) {
+	handler := newCORSTestHandler([]string{"*"})
+
+	rec := doCORSRequest(handler, http.MethodGet, "http://anything.example.com", false)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Expected *, got %q", got)
+	}
+}
+
+func TestCORSMiddleware_SameOriginAndNoOrigin(t *testing.T) {
+	handler := newCORSTestHandler(nil)
+
+	for _, origin := range []string{"", "http://127.0.0.1:9810"} {
+		rec := doCORSRequest(handler, http.MethodPost, origin, false)
+		if rec.Code != http.StatusOK {
+			t.Errorf("origin %q: expected 200, got %d", origin, rec.Code)
+		}
+	}
+}
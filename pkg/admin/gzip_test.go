@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGzipMiddleware_LargeJSON(t *testing.T) {
+	domains := make(map[string]any)
+	for i := range 2000 {
+		domains[fmt.Sprintf("domain-%d.example.com", i)] = i
+	}
+	handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(StatsResponse{Code: 200, Message: "OK", Data: domains})
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/stats/dns", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Expected gzip Content-Encoding, got %q", got)
+	}
+	gr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Failed to create gzip reader: %v", err)
+	}
+	var resp StatsResponse
+	if err := json.NewDecoder(gr).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Data) != len(domains) {
+		t.Errorf("Expected %d entries, got %d", len(domains), len(resp.Data))
+	}
+}
+
+func TestGzipMiddleware_NotAccepted(t *testing.T) {
+	handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"code":200}`))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Expected no Content-Encoding, got %q", got)
+	}
+	if rec.Body.String() != `{"code":200}` {
+		t.Errorf("Unexpected body: %q", rec.Body.String())
+	}
+}
+
+func TestGzipMiddleware_SkipsSSE(t *testing.T) {
+	handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("Expected response writer to support flushing")
+		}
+		io.WriteString(w, "data: hello\n\n")
+		flusher.Flush()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/mitm/traffic/sse", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Expected SSE to be uncompressed, got %q", got)
+	}
+	if !rec.Flushed {
+		t.Error("Expected SSE response to be flushed")
+	}
+	if rec.Body.String() != "data: hello\n\n" {
+		t.Errorf("Unexpected body: %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,45 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// healthCheck reports the readiness of one component, a nil error means healthy
+type healthCheck struct {
+	name     string
+	critical bool // An unhealthy critical component makes /health return 503, others only degrade it
+	check    func() error
+}
+
+// AddHealthCheck registers a component whose readiness is reported by /health
+func (s *AdminServer) AddHealthCheck(name string, critical bool, check func() error) {
+	s.healthChecks = append(s.healthChecks, healthCheck{name: name, critical: critical, check: check})
+}
+
+func (s *AdminServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	code := http.StatusOK
+	components := make(map[string]any, len(s.healthChecks))
+	for _, hc := range s.healthChecks {
+		if err := hc.check(); err != nil {
+			components[hc.name] = map[string]any{"status": "unhealthy", "critical": hc.critical, "error": err.Error()}
+			if hc.critical {
+				status = "unhealthy"
+				code = http.StatusServiceUnavailable
+			} else if status == "ok" {
+				status = "degraded"
+			}
+			continue
+		}
+		components[hc.name] = map[string]any{"status": "ok", "critical": hc.critical}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":     status,
+		"components": components,
+	})
+}
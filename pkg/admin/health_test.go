@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func doHealthRequest(t *testing.T, s *AdminServer) (int, map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleHealth(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode health response: %v", err)
+	}
+	return rec.Code, body
+}
+
+func componentStatus(t *testing.T, body map[string]any, name string) string {
+	t.Helper()
+	components, _ := body["components"].(map[string]any)
+	component, ok := components[name].(map[string]any)
+	if !ok {
+		t.Fatalf("Expected component %q in response, got %v", name, body["components"])
+	}
+	status, _ := component["status"].(string)
+	return status
+}
+
+func TestHandleHealth_Healthy(t *testing.T) {
+	s := &AdminServer{}
+	s.AddHealthCheck("proxy", true, func() error { return nil })
+	s.AddHealthCheck("dns", true, func() error { return nil })
+	s.AddHealthCheck("geoip", false, func() error { return nil })
+
+	code, body := doHealthRequest(t, s)
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if body["status"] != "ok" {
+		t.Errorf("Expected status ok, got %v", body["status"])
+	}
+	for _, name := range []string{"proxy", "dns", "geoip"} {
+		if got := componentStatus(t, body, name); got != "ok" {
+			t.Errorf("Expected %s ok, got %q", name, got)
+		}
+	}
+}
+
+func TestHandleHealth_Degraded(t *testing.T) {
+	s := &AdminServer{}
+	s.AddHealthCheck("proxy", true, func() error { return nil })
+	s.AddHealthCheck("geoip", false, func() error { return errors.New("not initialized") })
+
+	code, body := doHealthRequest(t, s)
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 for degraded non-critical component, got %d", code)
+	}
+	if body["status"] != "degraded" {
+		t.Errorf("Expected status degraded, got %v", body["status"])
+	}
+	if got := componentStatus(t, body, "geoip"); got != "unhealthy" {
+		t.Errorf("Expected geoip unhealthy, got %q", got)
+	}
+}
+
+func TestHandleHealth_Unhealthy(t *testing.T) {
+	s := &AdminServer{}
+	s.AddHealthCheck("proxy", true, func() error { return nil })
+	s.AddHealthCheck("dns", true, func() error { return errors.New("not listening") })
+	s.AddHealthCheck("geoip", false, func() error { return errors.New("not initialized") })
+
+	code, body := doHealthRequest(t, s)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d", code)
+	}
+	if body["status"] != "unhealthy" {
+		t.Errorf("Expected status unhealthy, got %v", body["status"])
+	}
+	components := body["components"].(map[string]any)
+	dns := components["dns"].(map[string]any)
+	if dns["error"] != "not listening" {
+		t.Errorf("Expected dns error detail, got %v", dns["error"])
+	}
+	if got := componentStatus(t, body, "proxy"); got != "ok" {
+		t.Errorf("Expected proxy ok, got %q", got)
+	}
+}
@@ -4,28 +4,48 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/fs"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/http/pprof"
+	"os"
+	"path"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/monsterxx03/linko/pkg/config"
 	"github.com/monsterxx03/linko/pkg/dns"
+	"github.com/monsterxx03/linko/pkg/ipdb"
 	"github.com/monsterxx03/linko/pkg/mitm"
+	"github.com/monsterxx03/linko/pkg/proxy"
 	"github.com/monsterxx03/linko/pkg/ui"
+	"github.com/monsterxx03/linko/pkg/version"
 )
 
 type AdminServer struct {
-	addr        string
-	uiPath      string
-	uiEmbed     bool
-	server      *http.Server
-	listener    net.Listener
-	wg          sync.WaitGroup
-	dnsServer   *dns.DNSServer
-	eventBus    *mitm.EventBus
-	llmEventBus *mitm.EventBus
+	addr         string
+	autoPort     bool
+	corsOrigins  []string
+	uiPath       string
+	uiEmbed      bool
+	server       *http.Server
+	listener     net.Listener
+	wg           sync.WaitGroup
+	dnsServer    *dns.DNSServer
+	eventBus     *mitm.EventBus
+	llmEventBus  *mitm.EventBus
+	stats        *mitm.TrafficStatsCollector
+	siteCerts    *mitm.SiteCertManager
+	upstream     *proxy.UpstreamClient
+	healthChecks []healthCheck
+	pprof        bool   // Mount /debug/pprof/, only when pprofToken is set
+	pprofToken   string // Bearer token required by /debug/pprof/
+	config       *config.Config
+	configToken  string // Bearer token required by /api/config
 }
 
 type StatsResponse struct {
@@ -45,12 +65,86 @@ func NewAdminServer(addr string, uiPath string, uiEmbed bool, dnsServer *dns.DNS
 	}
 }
 
-func (s *AdminServer) Start() error {
+// maxPortAttempts is how many consecutive ports are tried when auto port is enabled
+const maxPortAttempts = 10
+
+// SetAutoPort allows Start to try the next ports when the configured one is in use
+func (s *AdminServer) SetAutoPort(enable bool) {
+	s.autoPort = enable
+}
+
+// SetTrafficStats sets the collector served by /stats/traffic/histograms
+func (s *AdminServer) SetTrafficStats(stats *mitm.TrafficStatsCollector) {
+	s.stats = stats
+}
+
+// SetSiteCertManager sets the certificate cache served by /api/mitm/certs
+func (s *AdminServer) SetSiteCertManager(scm *mitm.SiteCertManager) {
+	s.siteCerts = scm
+}
+
+// SetUpstream sets the upstream proxy client whose connect stats are served by /api/upstream/stats
+func (s *AdminServer) SetUpstream(upstream *proxy.UpstreamClient) {
+	s.upstream = upstream
+}
+
+// SetPprof mounts the Go profiler at /debug/pprof/, guarded by a bearer token. It stays
+// unmounted without a token since the admin server may listen on all interfaces.
+func (s *AdminServer) SetPprof(enable bool, token string) {
+	s.pprof = enable
+	s.pprofToken = token
+}
+
+// SetConfig serves cfg, secrets masked, at /api/config guarded by a bearer token.
+// It stays unmounted without a token.
+func (s *AdminServer) SetConfig(cfg *config.Config, token string) {
+	s.config = cfg
+	s.configToken = token
+}
+
+// SetCORSOrigins sets the origins allowed to call the admin API cross-origin, "*" allows any
+func (s *AdminServer) SetCORSOrigins(origins []string) {
+	s.corsOrigins = origins
+}
+
+// GetAddr returns the address the admin server listens on
+func (s *AdminServer) GetAddr() string {
+	return s.addr
+}
+
+// listen binds the configured address, moving to the next ports when auto port is enabled
+func (s *AdminServer) listen() (net.Listener, error) {
 	listener, err := net.Listen("tcp", s.addr)
+	if err == nil || !s.autoPort {
+		return listener, err
+	}
+
+	host, portStr, splitErr := net.SplitHostPort(s.addr)
+	if splitErr != nil {
+		return nil, err
+	}
+	port, convErr := strconv.Atoi(portStr)
+	if convErr != nil || port == 0 {
+		return nil, err
+	}
+
+	for i := 1; i < maxPortAttempts && port+i <= 65535; i++ {
+		addr := net.JoinHostPort(host, strconv.Itoa(port+i))
+		if listener, nextErr := net.Listen("tcp", addr); nextErr == nil {
+			slog.Warn("Admin port in use, using next free port", "configured", s.addr, "address", addr)
+			return listener, nil
+		}
+	}
+	return nil, fmt.Errorf("no free port in %d-%d: %w", port, min(port+maxPortAttempts-1, 65535), err)
+}
+
+func (s *AdminServer) Start() error {
+	listener, err := s.listen()
 	if err != nil {
 		return err
 	}
 	s.listener = listener
+	s.addr = listener.Addr().String()
 
 	mux := http.NewServeMux()
 
@@ -65,16 +159,45 @@ func (s *AdminServer) Start() error {
 	mux.HandleFunc("/stats/dns", s.handleDNSStats)
 	mux.HandleFunc("/stats/dns/clear", s.handleDNSStatsClear)
 	mux.HandleFunc("/cache/dns/clear", s.handleDNSCacheClear)
+	mux.HandleFunc("/stats/traffic/histograms", s.handleTrafficHistograms)
 	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/version", s.handleVersion)
+	mux.HandleFunc("/api/geoip/status", s.handleGeoIPStatus)
+	mux.HandleFunc("/api/upstream/stats", s.handleUpstreamStats)
+
+	// DNS-over-HTTPS endpoint (RFC 8484)
+	if s.dnsServer != nil {
+		mux.HandleFunc("/dns-query", s.dnsServer.ServeDoH)
+	}
 
 	// MITM traffic SSE endpoint
 	mux.HandleFunc("/api/mitm/traffic/sse", s.handleMITMTrafficSSE)
 
+	// MITM site certificate cache
+	mux.HandleFunc("/api/mitm/certs", s.handleMITMCerts)
+	mux.HandleFunc("/api/mitm/certs/clear", s.handleMITMCertsClear)
+
 	// LLM conversation SSE endpoint
 	mux.HandleFunc("/api/llm/conversation/sse", s.handleLLMConversationSSE)
+	mux.HandleFunc("/api/llm/conversations/clear", s.handleLLMConversationsClear)
+	mux.HandleFunc("/api/llm/conversations/{id}", s.handleLLMConversationDelete)
+
+	// Go profiler
+	if s.pprof {
+		if s.pprofToken == "" {
+			slog.Warn("pprof enabled without a token, not mounting /debug/pprof/")
+		} else {
+			mux.Handle("/debug/pprof/", authMiddleware(s.pprofToken, pprofHandler()))
+		}
+	}
+
+	// Effective configuration
+	if s.config != nil && s.configToken != "" {
+		mux.Handle("/api/config", authMiddleware(s.configToken, http.HandlerFunc(s.handleConfig)))
+	}
 
 	s.server = &http.Server{
-		Handler: mux,
+		Handler: corsMiddleware(s.corsOrigins, gzipMiddleware(mux)),
 	}
 
 	s.wg.Go(func() {
@@ -87,6 +210,17 @@ func (s *AdminServer) Start() error {
 	return nil
 }
 
+// pprofHandler serves the net/http/pprof endpoints, named profiles (heap, goroutine, ...) via Index
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
 func (s *AdminServer) Stop() {
 	if s.server != nil {
 		s.server.Close()
@@ -96,20 +230,57 @@ func (s *AdminServer) Stop() {
 
 // handleStatic serves static files from the UI directory
 func (s *AdminServer) handleStatic() http.Handler {
-	return http.FileServer(http.Dir(s.uiPath))
+	fsys := os.DirFS(s.uiPath)
+	files := http.FileServer(http.Dir(s.uiPath))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" && spaPath(fsys, r.URL.Path) != r.URL.Path {
+			http.ServeFileFS(w, r, fsys, "admin.html")
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
 }
 
 // handleEmbed serves the embedded static files
 func (s *AdminServer) handleEmbed() http.Handler {
+	// embed path is dist/admin, Sub only fails for an invalid directory name
+	fsys, _ := fs.Sub(ui.AdminFS, "dist/admin")
+	return embedHandler(fsys)
+}
+
+// spaPath returns the path to serve for urlPath, client-side routes that don't exist
+// in fsys get the admin.html shell while missing assets and API paths are left to 404
+func spaPath(fsys fs.FS, urlPath string) string {
+	if !isClientRoute(urlPath) {
+		return urlPath
+	}
+	if _, err := fs.Stat(fsys, strings.TrimPrefix(urlPath, "/")); err == nil {
+		return urlPath
+	}
+	return "/admin.html"
+}
+
+// isClientRoute reports whether urlPath may be a client-side route: not an API path and without a file extension
+func isClientRoute(urlPath string) bool {
+	for _, prefix := range []string{"/api/", "/stats/", "/cache/"} {
+		if strings.HasPrefix(urlPath, prefix) {
+			return false
+		}
+	}
+	return path.Ext(urlPath) == ""
+}
+
+// embedHandler serves the UI from fsys with admin.html as the SPA shell
+func embedHandler(fsys fs.FS) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Remove leading slash
-		path := r.URL.Path
-		if path == "/" {
-			path = "/admin.html"
+		name := r.URL.Path
+		if name == "/" {
+			name = "/admin.html"
+		} else {
+			name = spaPath(fsys, name)
 		}
 
-		// Try to open the file from embedded FS (embed path is dist/admin)
-		data, err := ui.AdminFS.ReadFile("dist/admin" + path)
+		data, encoding, err := readAsset(fsys, strings.TrimPrefix(name, "/"), r)
 		if err != nil {
 			if errors.Is(err, fs.ErrNotExist) {
 				http.NotFound(w, r)
@@ -122,37 +293,85 @@ func (s *AdminServer) handleEmbed() http.Handler {
 		// Set content type based on extension
 		contentType := "application/octet-stream"
 		switch {
-		case hasExt(path, ".html"):
+		case hasExt(name, ".html"):
 			contentType = "text/html; charset=utf-8"
-		case hasExt(path, ".js"):
+		case hasExt(name, ".js"):
 			contentType = "application/javascript"
-		case hasExt(path, ".css"):
+		case hasExt(name, ".css"):
 			contentType = "text/css"
-		case hasExt(path, ".json"):
+		case hasExt(name, ".json"):
 			contentType = "application/json"
-		case hasExt(path, ".png"):
+		case hasExt(name, ".png"):
 			contentType = "image/png"
-		case hasExt(path, ".svg"):
+		case hasExt(name, ".svg"):
 			contentType = "image/svg+xml"
 		}
 
 		w.Header().Set("Content-Type", contentType)
 		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Add("Vary", "Accept-Encoding")
+		if encoding != "" {
+			w.Header().Set("Content-Encoding", encoding)
+		}
 		reader := bytes.NewReader(data)
 		http.ServeContent(w, r, "admin.html", time.Time{}, reader)
 	})
 }
 
+// precompressed lists the pre-compressed asset variants by preference
+var precompressed = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// readAsset reads name from fsys, preferring a pre-compressed variant the client accepts.
+// The returned encoding is empty when the plain file is served.
+func readAsset(fsys fs.FS, name string, r *http.Request) ([]byte, string, error) {
+	for _, p := range precompressed {
+		if !acceptsEncoding(r, p.encoding) {
+			continue
+		}
+		if data, err := fs.ReadFile(fsys, name+p.ext); err == nil {
+			return data, p.encoding, nil
+		}
+	}
+	data, err := fs.ReadFile(fsys, name)
+	return data, "", err
+}
+
 func hasExt(path string, ext string) bool {
 	return len(path) >= len(ext) && path[len(path)-len(ext):] == ext
 }
 
-func (s *AdminServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+func (s *AdminServer) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(StatsResponse{
+			Code:    405,
+			Message: "Method not allowed",
+		})
+		return
+	}
+
+	info := version.Get()
+	response := StatsResponse{
+		Code:    0,
+		Message: "success",
+		Data: map[string]any{
+			"version":    info.Version,
+			"commit":     info.Commit,
+			"date":       info.Date,
+			"go_version": info.GoVersion,
+		},
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "ok",
-	})
+	json.NewEncoder(w).Encode(response)
 }
 
 func (s *AdminServer) writeServiceUnavailable(w http.ResponseWriter, msg string) {
@@ -194,6 +413,34 @@ func (s *AdminServer) handleDNSStats(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+func (s *AdminServer) handleTrafficHistograms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(StatsResponse{
+			Code:    405,
+			Message: "Method not allowed",
+		})
+		return
+	}
+
+	if s.stats == nil {
+		s.writeServiceUnavailable(w, "MITM traffic stats not available")
+		return
+	}
+
+	response := StatsResponse{
+		Code:    0,
+		Message: "success",
+		Data:    s.stats.Histograms(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
 func (s *AdminServer) handleDNSStatsClear(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Content-Type", "application/json")
@@ -252,6 +499,46 @@ func (s *AdminServer) handleDNSCacheClear(w http.ResponseWriter, r *http.Request
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleGeoIPStatus reports the state of the China IP database used for geo-routing
+func (s *AdminServer) handleGeoIPStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(StatsResponse{
+			Code:    405,
+			Message: "Method not allowed",
+		})
+		return
+	}
+
+	status := ipdb.GetStatus()
+	data := map[string]any{
+		"path":        status.Path,
+		"initialized": status.Initialized,
+		"range_count": status.RangeCount,
+		"updated_at":  nil,
+		"age":         "",
+	}
+	if !status.UpdatedAt.IsZero() {
+		data["updated_at"] = status.UpdatedAt
+		data["age"] = status.Age.Round(time.Second).String()
+	}
+	if status.Error != nil {
+		data["error"] = status.Error.Error()
+	}
+
+	response := StatsResponse{
+		Code:    0,
+		Message: "success",
+		Data:    data,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
 // handleMITMTrafficSSE handles the SSE endpoint for MITM traffic
 func (s *AdminServer) handleMITMTrafficSSE(w http.ResponseWriter, r *http.Request) {
 	// Check if event bus is available
@@ -264,7 +551,6 @@ func (s *AdminServer) handleMITMTrafficSSE(w http.ResponseWriter, r *http.Reques
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	// Get flusher for SSE
 	flusher, ok := w.(http.Flusher)
@@ -274,7 +560,7 @@ func (s *AdminServer) handleMITMTrafficSSE(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Create subscriber
-	subscriber := s.eventBus.SubscribeWithName("mitm-traffic-sse")
+	subscriber := s.eventBus.SubscribeWithName("mitm-traffic-sse", 0)
 	defer s.eventBus.Unsubscribe(subscriber)
 
 	// Send welcome message
@@ -331,7 +617,6 @@ func (s *AdminServer) handleLLMConversationSSE(w http.ResponseWriter, r *http.Re
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	// Get flusher for SSE
 	flusher, ok := w.(http.Flusher)
@@ -341,7 +626,7 @@ func (s *AdminServer) handleLLMConversationSSE(w http.ResponseWriter, r *http.Re
 	}
 
 	// Create subscriber
-	subscriber := s.llmEventBus.SubscribeWithName("llm-conversation-sse")
+	subscriber := s.llmEventBus.SubscribeWithName("llm-conversation-sse", 0)
 	defer s.llmEventBus.Unsubscribe(subscriber)
 
 	// Send welcome message
@@ -381,6 +666,10 @@ data: {"message":"Connected to LLM conversation stream"}
 					eventType = "llm_token"
 				case "conversation":
 					eventType = "conversation"
+				case "llm_auxiliary":
+					eventType = "llm_auxiliary"
+				case "conversation_deleted":
+					eventType = "conversation_deleted"
 				default:
 					eventType = "traffic"
 				}
@@ -411,3 +700,53 @@ data: ` + string(eventData) + `
 		}
 	}
 }
+
+// writeJSON writes a StatsResponse with the given HTTP status
+func writeJSON(w http.ResponseWriter, status int, response StatsResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleLLMConversationDelete removes one captured conversation
+func (s *AdminServer) handleLLMConversationDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, StatsResponse{Code: 405, Message: "Method not allowed"})
+		return
+	}
+	if s.llmEventBus == nil {
+		s.writeServiceUnavailable(w, "LLM inspector not available")
+		return
+	}
+
+	id := r.PathValue("id")
+	removed := mitm.DeleteConversation(s.llmEventBus, id)
+	if removed == 0 {
+		writeJSON(w, http.StatusNotFound, StatsResponse{Code: 404, Message: "Conversation not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, StatsResponse{
+		Code:    0,
+		Message: "success",
+		Data:    map[string]any{"conversation_id": id, "removed_events": removed},
+	})
+}
+
+// handleLLMConversationsClear removes all captured conversations
+func (s *AdminServer) handleLLMConversationsClear(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, StatsResponse{Code: 405, Message: "Method not allowed"})
+		return
+	}
+	if s.llmEventBus == nil {
+		s.writeServiceUnavailable(w, "LLM inspector not available")
+		return
+	}
+
+	removed := mitm.ClearConversations(s.llmEventBus)
+	writeJSON(w, http.StatusOK, StatsResponse{
+		Code:    0,
+		Message: "success",
+		Data:    map[string]any{"removed_events": removed},
+	})
+}
@@ -0,0 +1,261 @@
+package admin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/monsterxx03/linko/pkg/mitm"
+	"github.com/monsterxx03/linko/pkg/mitm/llm"
+)
+
+// occupyPort listens on a free port and returns its address
+func occupyPort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String()
+}
+
+func TestAdminServer_PortInUseFails(t *testing.T) {
+	addr := occupyPort(t)
+
+	server := NewAdminServer(addr, "", false, nil, nil, nil)
+	if err := server.Start(); err == nil {
+		server.Stop()
+		t.Fatal("Expected Start to fail when the port is in use")
+	}
+}
+
+func TestAdminServer_AutoPortPicksNextPort(t *testing.T) {
+	addr := occupyPort(t)
+	_, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+
+	server := NewAdminServer(addr, "", false, nil, nil, nil)
+	server.SetAutoPort(true)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Expected Start to pick another port, got %v", err)
+	}
+	defer server.Stop()
+
+	_, gotStr, _ := net.SplitHostPort(server.GetAddr())
+	got, _ := strconv.Atoi(gotStr)
+	if got <= port || got >= port+maxPortAttempts {
+		t.Errorf("Expected a port in (%d, %d), got %d", port, port+maxPortAttempts, got)
+	}
+}
+
+// startLLMAdmin starts an admin server backed by bus and returns its base URL
+func startLLMAdmin(t *testing.T, bus *mitm.EventBus) string {
+	t.Helper()
+	server := NewAdminServer("127.0.0.1:0", "", false, nil, nil, bus)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start admin server: %v", err)
+	}
+	t.Cleanup(server.Stop)
+	return "http://" + server.GetAddr()
+}
+
+func publishLLMMessage(bus *mitm.EventBus, conversationID string) {
+	bus.Publish(&mitm.TrafficEvent{
+		Direction: "llm_message",
+		Extra:     &llm.LLMMessageEvent{ConversationID: conversationID},
+	})
+}
+
+func doRequest(t *testing.T, method, url string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAdminServer_DeleteConversation(t *testing.T) {
+	bus := mitm.NewEventBus(slog.Default(), 100)
+	publishLLMMessage(bus, "conv-a")
+	publishLLMMessage(bus, "conv-b")
+	base := startLLMAdmin(t, bus)
+
+	sub := bus.Subscribe()
+	defer bus.Unsubscribe(sub)
+	<-sub.Channel
+	<-sub.Channel
+
+	if code := doRequest(t, http.MethodDelete, base+"/api/llm/conversations/conv-a"); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	select {
+	case ev := <-sub.Channel:
+		deleted, ok := ev.Extra.(*llm.ConversationDeletedEvent)
+		if ev.Direction != "conversation_deleted" || !ok || deleted.ConversationID != "conv-a" {
+			t.Errorf("Expected conversation_deleted for conv-a, got %s %+v", ev.Direction, ev.Extra)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected conversation_deleted event")
+	}
+
+	if code := doRequest(t, http.MethodDelete, base+"/api/llm/conversations/conv-a"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an already deleted conversation, got %d", code)
+	}
+	if code := doRequest(t, http.MethodGet, base+"/api/llm/conversations/conv-b"); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", code)
+	}
+}
+
+func TestAdminServer_ClearConversations(t *testing.T) {
+	bus := mitm.NewEventBus(slog.Default(), 100)
+	publishLLMMessage(bus, "conv-a")
+	publishLLMMessage(bus, "conv-b")
+	base := startLLMAdmin(t, bus)
+
+	if code := doRequest(t, http.MethodPost, base+"/api/llm/conversations/clear"); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if code := doRequest(t, http.MethodDelete, base+"/api/llm/conversations/conv-b"); code != http.StatusNotFound {
+		t.Errorf("Expected conv-b to be gone after clear, got %d", code)
+	}
+}
+
+func TestEmbedHandler_SPAFallback(t *testing.T) {
+	handler := embedHandler(fstest.MapFS{
+		"admin.html":     {Data: []byte("<html>shell</html>")},
+		"assets/app.js":  {Data: []byte("console.log(1)")},
+		"assets/app.css": {Data: []byte("body{}")},
+	})
+	testSPAFallback(t, handler)
+}
+
+func TestHandleStatic_SPAFallback(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "assets"), 0755)
+	os.WriteFile(filepath.Join(dir, "admin.html"), []byte("<html>shell</html>"), 0644)
+	os.WriteFile(filepath.Join(dir, "assets", "app.js"), []byte("console.log(1)"), 0644)
+
+	server := NewAdminServer("127.0.0.1:0", dir, false, nil, nil, nil)
+	testSPAFallback(t, server.handleStatic())
+}
+
+func testSPAFallback(t *testing.T, handler http.Handler) {
+	t.Helper()
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/llm/conversations/abc", http.StatusOK, "<html>shell</html>"},
+		{"/admin.html", http.StatusOK, "<html>shell</html>"},
+		{"/assets/app.js", http.StatusOK, "console.log(1)"},
+		{"/assets/missing.js", http.StatusNotFound, ""},
+		{"/api/unknown", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, rec.Code)
+			continue
+		}
+		if tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("%s: expected body %q, got %q", tt.path, tt.body, rec.Body.String())
+		}
+	}
+}
+
+func TestEmbedHandler_Precompressed(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("console.log(1)"))
+	gz.Close()
+
+	handler := embedHandler(fstest.MapFS{
+		"admin.html":       {Data: []byte("<html>shell</html>")},
+		"assets/app.js":    {Data: []byte("console.log(1)")},
+		"assets/app.js.gz": {Data: buf.Bytes()},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/assets/app.js", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected gzip Content-Encoding, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	if rec.Header().Get("Content-Type") != "application/javascript" {
+		t.Errorf("Expected javascript Content-Type, got %q", rec.Header().Get("Content-Type"))
+	}
+	if !bytes.Equal(rec.Body.Bytes(), buf.Bytes()) {
+		t.Error("Expected the pre-compressed asset to be served as is")
+	}
+
+	// Without gzip support the plain asset is served
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/assets/app.js", nil))
+	if rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected no Content-Encoding, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	if rec.Body.String() != "console.log(1)" {
+		t.Errorf("Expected plain asset, got %q", rec.Body.String())
+	}
+}
+
+func TestAdminServer_Pprof(t *testing.T) {
+	tests := []struct {
+		name   string
+		enable bool
+		token  string
+		auth   string
+		want   int
+	}{
+		{"enabled with token", true, "s3cret", "Bearer s3cret", http.StatusOK},
+		{"missing auth", true, "s3cret", "", http.StatusUnauthorized},
+		{"wrong token", true, "s3cret", "Bearer nope", http.StatusUnauthorized},
+		{"disabled", false, "s3cret", "Bearer s3cret", http.StatusNotFound},
+		{"enabled without token", true, "", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewAdminServer("127.0.0.1:0", "", false, nil, nil, nil)
+			server.SetPprof(tt.enable, tt.token)
+			if err := server.Start(); err != nil {
+				t.Fatalf("Failed to start admin server: %v", err)
+			}
+			defer server.Stop()
+
+			req, err := http.NewRequest(http.MethodGet, "http://"+server.GetAddr()+"/debug/pprof/", nil)
+			if err != nil {
+				t.Fatalf("Failed to build request: %v", err)
+			}
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package admin
+
+import (
+	"net/http"
+)
+
+// handleUpstreamStats serves connect attempts, success rate and latency of the upstream proxy
+func (s *AdminServer) handleUpstreamStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, StatsResponse{Code: 405, Message: "Method not allowed"})
+		return
+	}
+	if s.upstream == nil || !s.upstream.IsEnabled() {
+		s.writeServiceUnavailable(w, "Upstream proxy not enabled")
+		return
+	}
+
+	stats := s.upstream.Stats()
+	writeJSON(w, http.StatusOK, StatsResponse{
+		Code:    0,
+		Message: "success",
+		Data: map[string]any{
+			"addr":             stats.Addr,
+			"type":             stats.Type,
+			"attempts":         stats.Attempts,
+			"successes":        stats.Successes,
+			"failures":         stats.Failures,
+			"success_rate":     stats.SuccessRate,
+			"avg_connect_time": stats.AvgConnectTime.String(),
+			"pool_hits":        stats.PoolHits,
+			"pool_misses":      stats.PoolMisses,
+		},
+	})
+}
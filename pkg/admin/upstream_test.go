@@ -0,0 +1,65 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/monsterxx03/linko/pkg/config"
+	"github.com/monsterxx03/linko/pkg/proxy"
+)
+
+func TestAdminServer_UpstreamStats(t *testing.T) {
+	server := NewAdminServer("127.0.0.1:0", "", false, nil, nil, nil)
+	upstream := proxy.NewUpstreamClient(config.UpstreamConfig{Enable: true, Type: "socks5", Addr: "127.0.0.1:1"})
+	server.SetUpstream(upstream)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start admin server: %v", err)
+	}
+	defer server.Stop()
+
+	// Nothing listens on port 1, the attempt fails
+	if _, err := upstream.Connect("127.0.0.1", 443); err == nil {
+		t.Fatal("Expected connect to fail")
+	}
+
+	resp, err := http.Get("http://" + server.GetAddr() + "/api/upstream/stats")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	var body struct {
+		Data struct {
+			Attempts    uint64  `json:"attempts"`
+			Failures    uint64  `json:"failures"`
+			SuccessRate float64 `json:"success_rate"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body.Data.Attempts != 1 || body.Data.Failures != 1 || body.Data.SuccessRate != 0 {
+		t.Errorf("Expected 1 failed attempt, got %+v", body.Data)
+	}
+}
+
+func TestAdminServer_UpstreamStatsDisabled(t *testing.T) {
+	server := NewAdminServer("127.0.0.1:0", "", false, nil, nil, nil)
+	server.SetUpstream(proxy.NewUpstreamClient(config.UpstreamConfig{}))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start admin server: %v", err)
+	}
+	defer server.Stop()
+
+	resp, err := http.Get("http://" + server.GetAddr() + "/api/upstream/stats")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without an upstream, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,302 @@
+package clienthello
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotTLS means the data does not start with a TLS handshake record
+	ErrNotTLS = errors.New("not a TLS handshake record")
+	// ErrNeedMoreData means the ClientHello is not fully buffered yet
+	ErrNeedMoreData = errors.New("TLS record incomplete")
+	// ErrNoSNI means the ClientHello carries no SNI extension
+	ErrNoSNI = errors.New("SNI not found in ClientHello")
+)
+
+const (
+	recordTypeHandshake      = 0x16
+	handshakeTypeClientHello = 0x01
+	recordHeaderLen          = 5
+	handshakeHeaderLen       = 4
+	// maxClientHelloLen bounds reassembly of fragmented ClientHellos
+	maxClientHelloLen = 64 * 1024
+
+	extServerName        = 0x0000
+	extALPN              = 0x0010
+	extSupportedVersions = 0x002b
+	extEncryptedHello    = 0xfe0d
+)
+
+// Info contains the parsed ClientHello fields
+type Info struct {
+	Hostname          string   // SNI hostname (the outer/public name when ECH is used)
+	ALPN              []string // Protocols from the ALPN extension, in client preference order
+	SupportedVersions []uint16 // Versions from the supported_versions extension, e.g. 0x0304 for TLS 1.3
+	HasECH            bool     // The ClientHello carries an encrypted_client_hello extension
+	IsValid           bool     // SNI hostname was found
+	IsTLS             bool     // Data starts with a TLS handshake record
+	Complete          bool     // The whole ClientHello was available
+	Consumed          int      // Length of the TLS records holding the ClientHello once complete
+	ParseError        error    // ErrNotTLS, ErrNeedMoreData, ErrNoSNI or a parse error
+
+	need int // total bytes needed to make progress when ParseError is ErrNeedMoreData
+}
+
+// SupportsTLS13 reports whether the client offered TLS 1.3
+func (i *Info) SupportsTLS13() bool {
+	for _, v := range i.SupportedVersions {
+		if v == 0x0304 {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse inspects buffered bytes from a connection and reports whether they are TLS, whether the
+// ClientHello is complete (it may be fragmented over several records) and the fields it carries
+func Parse(data []byte) *Info {
+	info := &Info{}
+	if len(data) == 0 {
+		info.need = recordHeaderLen
+		info.ParseError = ErrNeedMoreData
+		return info
+	}
+	if data[0] != recordTypeHandshake {
+		info.Complete = true
+		info.ParseError = ErrNotTLS
+		return info
+	}
+	info.IsTLS = true
+
+	// Reassemble the handshake message from consecutive handshake records
+	var msg []byte
+	pos := 0
+	for {
+		if len(data) < pos+recordHeaderLen {
+			info.need = pos + recordHeaderLen
+			info.ParseError = ErrNeedMoreData
+			return info
+		}
+		if data[pos] != recordTypeHandshake {
+			info.Complete = true
+			info.Consumed = pos
+			info.ParseError = errors.New("ClientHello interrupted by non-handshake record")
+			return info
+		}
+		recordEnd := pos + recordHeaderLen + (int(data[pos+3])<<8 | int(data[pos+4]))
+		if len(data) < recordEnd {
+			info.need = recordEnd
+			info.ParseError = ErrNeedMoreData
+			return info
+		}
+		msg = append(msg, data[pos+recordHeaderLen:recordEnd]...)
+		pos = recordEnd
+
+		if len(msg) >= handshakeHeaderLen {
+			msgLen := handshakeHeaderLen + (int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3]))
+			if msgLen > maxClientHelloLen {
+				info.Complete = true
+				info.Consumed = pos
+				info.ParseError = errors.New("ClientHello too large")
+				return info
+			}
+			if len(msg) >= msgLen {
+				msg = msg[:msgLen]
+				break
+			}
+		}
+	}
+	info.Complete = true
+	info.Consumed = pos
+
+	if err := parseClientHello(msg, info); err != nil {
+		info.ParseError = err
+		return info
+	}
+	if !info.IsValid {
+		info.ParseError = ErrNoSNI
+	}
+	return info
+}
+
+// Peek parses the ClientHello through a peek function such as bufio.Reader.Peek without consuming
+// any data, peeking at most limit bytes
+func Peek(peek func(n int) ([]byte, error), limit int) *Info {
+	n := recordHeaderLen
+	for {
+		data, err := peek(min(n, limit))
+		info := Parse(data)
+		if !errors.Is(info.ParseError, ErrNeedMoreData) || err != nil || info.need > limit || info.need <= len(data) {
+			return info
+		}
+		n = info.need
+	}
+}
+
+// parseClientHello parses a ClientHello handshake message (header included) into info
+func parseClientHello(msg []byte, info *Info) error {
+	if msg[0] != handshakeTypeClientHello {
+		return errors.New("not a ClientHello")
+	}
+	data := msg[handshakeHeaderLen:]
+
+	// ClientHello body:
+	// ProtocolVersion: 2 bytes, Random: 32 bytes
+	// SessionID: 1 byte length + variable
+	// CipherSuites: 2 bytes length + variable
+	// CompressionMethods: 1 byte length + variable
+	// Extensions: 2 bytes length + variable (optional)
+	if len(data) < 34 {
+		return errors.New("ClientHello too short")
+	}
+	pos := 34
+
+	// Session ID
+	if len(data) < pos+1 {
+		return errors.New("ClientHello session ID truncated")
+	}
+	pos += 1 + int(data[pos])
+	if len(data) < pos {
+		return errors.New("ClientHello session ID truncated")
+	}
+
+	// Cipher suites
+	if len(data) < pos+2 {
+		return errors.New("ClientHello cipher suites truncated")
+	}
+	pos += 2 + (int(data[pos])<<8 | int(data[pos+1]))
+	if len(data) < pos {
+		return errors.New("ClientHello cipher suites truncated")
+	}
+
+	// Compression methods
+	if len(data) < pos+1 {
+		return errors.New("ClientHello compression methods truncated")
+	}
+	pos += 1 + int(data[pos])
+	if len(data) < pos {
+		return errors.New("ClientHello compression methods truncated")
+	}
+
+	// Extensions are optional
+	if len(data) == pos {
+		return nil
+	}
+	if len(data) < pos+2 {
+		return errors.New("ClientHello extensions truncated")
+	}
+	extensionsEnd := pos + 2 + (int(data[pos])<<8 | int(data[pos+1]))
+	pos += 2
+	if len(data) < extensionsEnd {
+		return errors.New("ClientHello extensions truncated")
+	}
+
+	for pos+4 <= extensionsEnd {
+		extType := int(data[pos])<<8 | int(data[pos+1])
+		extLen := int(data[pos+2])<<8 | int(data[pos+3])
+		pos += 4
+
+		if pos+extLen > extensionsEnd {
+			return errors.New("ClientHello extension truncated")
+		}
+		extData := data[pos : pos+extLen]
+
+		switch extType {
+		case extServerName:
+			hostname, err := parseSNIExtension(extData)
+			if err != nil {
+				return fmt.Errorf("failed to parse SNI extension: %w", err)
+			}
+			info.Hostname = hostname
+			info.IsValid = true
+		case extALPN:
+			info.ALPN = parseALPNExtension(extData)
+		case extSupportedVersions:
+			info.SupportedVersions = parseSupportedVersionsExtension(extData)
+		case extEncryptedHello:
+			info.HasECH = true
+		}
+
+		pos += extLen
+	}
+
+	return nil
+}
+
+// parseSNIExtension parses the SNI extension data
+func parseSNIExtension(data []byte) (string, error) {
+	if len(data) < 2 {
+		return "", errors.New("SNI extension too short")
+	}
+
+	// SNI extension format:
+	// 2 bytes: list length (not including this length)
+	// Then list of name entries:
+	//   1 byte: name type (0 = hostname)
+	//   2 bytes: name length
+	//   N bytes: name data
+	end := min(2+(int(data[0])<<8|int(data[1])), len(data))
+	pos := 2
+
+	for pos+3 <= end {
+		nameType := data[pos]
+		nameLen := int(data[pos+1])<<8 | int(data[pos+2])
+		pos += 3
+
+		if pos+nameLen > end {
+			break
+		}
+
+		if nameType == 0 { // hostname
+			return string(data[pos : pos+nameLen]), nil
+		}
+
+		pos += nameLen
+	}
+
+	return "", errors.New("SNI hostname not found")
+}
+
+// parseALPNExtension parses the ALPN extension data into a protocol list
+func parseALPNExtension(data []byte) []string {
+	if len(data) < 2 {
+		return nil
+	}
+
+	// ALPN extension format:
+	// 2 bytes: list length
+	// Then list of protocols:
+	//   1 byte: protocol length
+	//   N bytes: protocol name
+	end := min(2+(int(data[0])<<8|int(data[1])), len(data))
+	pos := 2
+
+	var protocols []string
+	for pos < end {
+		nameLen := int(data[pos])
+		pos++
+		if nameLen == 0 || pos+nameLen > end {
+			break
+		}
+		protocols = append(protocols, string(data[pos:pos+nameLen]))
+		pos += nameLen
+	}
+
+	return protocols
+}
+
+// parseSupportedVersionsExtension parses the supported_versions extension of a ClientHello:
+// 1 byte list length followed by 2-byte versions
+func parseSupportedVersionsExtension(data []byte) []uint16 {
+	if len(data) < 1 {
+		return nil
+	}
+	end := min(1+int(data[0]), len(data))
+
+	var versions []uint16
+	for pos := 1; pos+2 <= end; pos += 2 {
+		versions = append(versions, uint16(data[pos])<<8|uint16(data[pos+1]))
+	}
+	return versions
+}
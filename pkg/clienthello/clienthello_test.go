@@ -1,7 +1,12 @@
-package mitm
+package clienthello
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
 	"errors"
+	"net"
+	"slices"
 	"testing"
 )
 
@@ -119,13 +124,13 @@ func buildSNIExtension(hostname string) []byte {
 	return sniExt
 }
 
-func TestParseSNI_Valid(t *testing.T) {
+func TestParse_Valid(t *testing.T) {
 	hostname := "example.com"
 	data := buildTLSClientHello(hostname)
 
-	sniInfo, err := parseSNI(data)
-	if err != nil {
-		t.Fatalf("parseSNI failed: %v", err)
+	sniInfo := Parse(data)
+	if sniInfo.ParseError != nil && !errors.Is(sniInfo.ParseError, ErrNoSNI) {
+		t.Fatalf("Parse failed: %v", sniInfo.ParseError)
 	}
 
 	if !sniInfo.IsValid {
@@ -135,18 +140,14 @@ func TestParseSNI_Valid(t *testing.T) {
 	if sniInfo.Hostname != hostname {
 		t.Errorf("Expected hostname %s, got %s", hostname, sniInfo.Hostname)
 	}
-
-	if sniInfo.ServerName != hostname {
-		t.Errorf("Expected ServerName %s, got %s", hostname, sniInfo.ServerName)
-	}
 }
 
-func TestParseSNI_EmptyHostname(t *testing.T) {
+func TestParse_EmptyHostname(t *testing.T) {
 	data := buildTLSClientHello("")
 
-	sniInfo, err := parseSNI(data)
-	if err != nil {
-		t.Fatalf("parseSNI failed: %v", err)
+	sniInfo := Parse(data)
+	if sniInfo.ParseError != nil && !errors.Is(sniInfo.ParseError, ErrNoSNI) {
+		t.Fatalf("Parse failed: %v", sniInfo.ParseError)
 	}
 
 	// Empty hostname is still valid from parsing perspective
@@ -155,44 +156,40 @@ func TestParseSNI_EmptyHostname(t *testing.T) {
 	}
 }
 
-func TestParseSNI_InvalidData_TooShort(t *testing.T) {
+func TestParse_InvalidData_TooShort(t *testing.T) {
 	data := []byte{0x16, 0x03, 0x01}
 
-	_, err := parseSNI(data)
-	if err == nil {
+	if Parse(data).ParseError == nil {
 		t.Error("Expected error for too short data")
 	}
 }
 
-func TestParseSNI_InvalidData_NotHandshake(t *testing.T) {
+func TestParse_InvalidData_NotHandshake(t *testing.T) {
 	data := []byte{0x17, 0x03, 0x01, 0x00, 0x00} // Not a handshake record
 
-	_, err := parseSNI(data)
-	if err == nil {
+	if Parse(data).ParseError == nil {
 		t.Error("Expected error for non-handshake record")
 	}
 }
 
-func TestParseSNI_InvalidData_NotClientHello(t *testing.T) {
+func TestParse_InvalidData_NotClientHello(t *testing.T) {
 	// Build a valid record but with wrong handshake type
-	data := []byte{0x16, 0x03, 0x01, 0x00, 0x05, 0x02, 0x00, 0x00, 0x01} // ServerHello type
+	data := []byte{0x16, 0x03, 0x01, 0x00, 0x04, 0x02, 0x00, 0x00, 0x00} // ServerHello type
 
-	_, err := parseSNI(data)
-	if err == nil {
+	if Parse(data).ParseError == nil {
 		t.Error("Expected error for non-ClientHello")
 	}
 }
 
-func TestParseSNI_InvalidData_ClientHelloTooShort(t *testing.T) {
+func TestParse_InvalidData_ClientHelloTooShort(t *testing.T) {
 	data := []byte{0x16, 0x03, 0x01, 0x00, 0x05, 0x01, 0x00, 0x00, 0x01, 0x00} // Incomplete ClientHello
 
-	_, err := parseSNI(data)
-	if err == nil {
+	if Parse(data).ParseError == nil {
 		t.Error("Expected error for truncated ClientHello")
 	}
 }
 
-func TestParseSNI_NoSNIExtension(t *testing.T) {
+func TestParse_NoSNIExtension(t *testing.T) {
 	// Build a ClientHello without SNI extension
 	recordHeader := []byte{0x16, 0x03, 0x01, 0x00, 0x00}
 	handshakeHeader := []byte{0x01, 0x00, 0x00, 0x00}
@@ -224,12 +221,15 @@ func TestParseSNI_NoSNIExtension(t *testing.T) {
 	data := append(recordHeader, handshakeHeader...)
 	data = append(data, clientHello...)
 
-	sniInfo, err := parseSNI(data)
-	if err != nil {
-		t.Fatalf("parseSNI failed: %v", err)
+	sniInfo := Parse(data)
+	if sniInfo.ParseError != nil && !errors.Is(sniInfo.ParseError, ErrNoSNI) {
+		t.Fatalf("Parse failed: %v", sniInfo.ParseError)
 	}
 
 	// No SNI should result in invalid
+	if !errors.Is(sniInfo.ParseError, ErrNoSNI) {
+		t.Errorf("Expected ErrNoSNI, got %v", sniInfo.ParseError)
+	}
 	if sniInfo.IsValid {
 		t.Error("Expected IsValid to be false when no SNI extension")
 	}
@@ -304,13 +304,13 @@ func TestParseSNIExtension_InvalidNameType(t *testing.T) {
 	}
 }
 
-func TestExtractSNIFromConn_Valid(t *testing.T) {
+func TestParse_ValidSubdomain(t *testing.T) {
 	hostname := "api.example.com"
 	data := buildTLSClientHello(hostname)
 
-	sniInfo, err := ExtractSNIFromConn(data)
-	if err != nil {
-		t.Fatalf("ExtractSNIFromConn failed: %v", err)
+	sniInfo := Parse(data)
+	if !sniInfo.IsValid {
+		t.Fatalf("Parse failed: %v", sniInfo.ParseError)
 	}
 
 	if sniInfo.Hostname != hostname {
@@ -318,7 +318,7 @@ func TestExtractSNIFromConn_Valid(t *testing.T) {
 	}
 }
 
-func TestExtractSNIFromConn_NoSNI(t *testing.T) {
+func TestParse_NoSNI(t *testing.T) {
 	// Build ClientHello without SNI
 	recordHeader := []byte{0x16, 0x03, 0x01, 0x00, 0x00}
 	handshakeHeader := []byte{0x01, 0x00, 0x00, 0x00}
@@ -348,33 +348,27 @@ func TestExtractSNIFromConn_NoSNI(t *testing.T) {
 	data := append(recordHeader, handshakeHeader...)
 	data = append(data, clientHello...)
 
-	_, err := ExtractSNIFromConn(data)
-	if err == nil {
-		t.Error("Expected error when no SNI in connection")
-	}
-
-	if !errors.Is(err, errors.New("SNI not found in ClientHello")) {
-		t.Logf("Got expected error: %v", err)
+	if err := Parse(data).ParseError; !errors.Is(err, ErrNoSNI) {
+		t.Errorf("Expected ErrNoSNI when no SNI in connection, got %v", err)
 	}
 }
 
-func TestExtractSNIFromConn_InvalidData(t *testing.T) {
+func TestParse_InvalidData(t *testing.T) {
 	data := []byte{0x16} // Too short
 
-	_, err := ExtractSNIFromConn(data)
-	if err == nil {
+	if Parse(data).ParseError == nil {
 		t.Error("Expected error for invalid data")
 	}
 }
 
-func TestParseSNI_LongHostname(t *testing.T) {
+func TestParse_LongHostname(t *testing.T) {
 	// Test with a very long hostname (subdomain max is 253 chars)
 	hostname := "a.b.c.d.e.f.g.h.i.j.k.l.m.n.o.p.q.r.s.t.u.v.w.x.y.z.example.com"
 	data := buildTLSClientHello(hostname)
 
-	sniInfo, err := parseSNI(data)
-	if err != nil {
-		t.Fatalf("parseSNI failed: %v", err)
+	sniInfo := Parse(data)
+	if sniInfo.ParseError != nil && !errors.Is(sniInfo.ParseError, ErrNoSNI) {
+		t.Fatalf("Parse failed: %v", sniInfo.ParseError)
 	}
 
 	if sniInfo.Hostname != hostname {
@@ -382,7 +376,7 @@ func TestParseSNI_LongHostname(t *testing.T) {
 	}
 }
 
-func TestParseSNI_WithOtherExtensions(t *testing.T) {
+func TestParse_WithOtherExtensions(t *testing.T) {
 	// Build ClientHello with SNI and other extensions
 	hostname := "secure.example.com"
 
@@ -427,12 +421,253 @@ func TestParseSNI_WithOtherExtensions(t *testing.T) {
 	data := append(recordHeader, handshakeHeader...)
 	data = append(data, clientHello...)
 
-	sniInfo, err := parseSNI(data)
-	if err != nil {
-		t.Fatalf("parseSNI failed: %v", err)
+	sniInfo := Parse(data)
+	if sniInfo.ParseError != nil && !errors.Is(sniInfo.ParseError, ErrNoSNI) {
+		t.Fatalf("Parse failed: %v", sniInfo.ParseError)
 	}
 
 	if sniInfo.Hostname != hostname {
 		t.Errorf("Expected hostname %s, got %s", hostname, sniInfo.Hostname)
 	}
 }
+
+func TestParse_ClientHello(t *testing.T) {
+	hello := buildTLSClientHello("api.example.com")
+
+	// ClientHello without SNI extension (empty extensions)
+	noSNI := buildTLSClientHello("x")
+	noSNI = noSNI[:len(noSNI)-len(buildSNIExtension("x"))]
+	extLenPos := len(noSNI) - 2
+	noSNI[extLenPos], noSNI[extLenPos+1] = 0x00, 0x00
+	helloLen := len(noSNI) - 9
+	noSNI[6], noSNI[7], noSNI[8] = byte(helloLen>>16), byte(helloLen>>8), byte(helloLen)
+	noSNI[3], noSNI[4] = byte((helloLen+4)>>8), byte(helloLen+4)
+
+	tests := []struct {
+		name         string
+		data         []byte
+		wantValid    bool
+		wantTLS      bool
+		wantComplete bool
+		wantConsumed int
+		wantErr      error
+	}{
+		{"valid", hello, true, true, true, len(hello), nil},
+		{"valid with trailing data", append(append([]byte{}, hello...), 0x17, 0x03), true, true, true, len(hello), nil},
+		{"no sni", noSNI, false, true, true, len(noSNI), ErrNoSNI},
+		{"not tls", []byte("GET / HTTP/1.1\r\n"), false, false, true, 0, ErrNotTLS},
+		{"empty", nil, false, false, false, 0, ErrNeedMoreData},
+		{"partial header", hello[:3], false, true, false, 0, ErrNeedMoreData},
+		{"partial record", hello[:len(hello)-1], false, true, false, 0, ErrNeedMoreData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := Parse(tt.data)
+			if info.IsValid != tt.wantValid {
+				t.Errorf("IsValid = %v, want %v", info.IsValid, tt.wantValid)
+			}
+			if info.IsTLS != tt.wantTLS {
+				t.Errorf("IsTLS = %v, want %v", info.IsTLS, tt.wantTLS)
+			}
+			if info.Complete != tt.wantComplete {
+				t.Errorf("Complete = %v, want %v", info.Complete, tt.wantComplete)
+			}
+			if info.Consumed != tt.wantConsumed {
+				t.Errorf("Consumed = %d, want %d", info.Consumed, tt.wantConsumed)
+			}
+			if tt.wantErr != nil && !errors.Is(info.ParseError, tt.wantErr) {
+				t.Errorf("ParseError = %v, want %v", info.ParseError, tt.wantErr)
+			}
+			if tt.wantErr == nil && info.ParseError != nil {
+				t.Errorf("Unexpected ParseError: %v", info.ParseError)
+			}
+			if tt.wantValid && info.Hostname != "api.example.com" {
+				t.Errorf("Hostname = %s, want api.example.com", info.Hostname)
+			}
+		})
+	}
+}
+
+// captureClientHello returns the first TLS record written by a crypto/tls client
+func captureClientHello(t *testing.T, config *tls.Config) []byte {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	go func() {
+		tls.Client(clientConn, config).Handshake()
+		clientConn.Close()
+	}()
+
+	reader := bufio.NewReader(serverConn)
+	header, err := reader.Peek(5)
+	if err != nil {
+		t.Fatalf("Failed to read record header: %v", err)
+	}
+	data, err := reader.Peek(5 + (int(header[3])<<8 | int(header[4])))
+	if err != nil {
+		t.Fatalf("Failed to read ClientHello: %v", err)
+	}
+	return append([]byte{}, data...)
+}
+
+func TestParse_ClientHello_ALPN(t *testing.T) {
+	data := captureClientHello(t, &tls.Config{
+		ServerName:         "api.example.com",
+		NextProtos:         []string{"h2", "http/1.1"},
+		InsecureSkipVerify: true,
+	})
+
+	info := Parse(data)
+	if !info.IsValid || info.Hostname != "api.example.com" {
+		t.Fatalf("Expected SNI api.example.com, got %+v", info)
+	}
+	if !slices.Equal(info.ALPN, []string{"h2", "http/1.1"}) {
+		t.Errorf("Expected ALPN [h2 http/1.1], got %v", info.ALPN)
+	}
+}
+
+func TestParse_ClientHello_NoALPN(t *testing.T) {
+	data := captureClientHello(t, &tls.Config{ServerName: "api.example.com", InsecureSkipVerify: true})
+
+	info := Parse(data)
+	if !info.IsValid {
+		t.Fatalf("Expected valid SNI, got %+v", info)
+	}
+	if len(info.ALPN) != 0 {
+		t.Errorf("Expected no ALPN, got %v", info.ALPN)
+	}
+}
+
+func TestParseALPNExtension_Truncated(t *testing.T) {
+	// List claims 2 protocols but second one is cut short
+	data := []byte{0x00, 0x0c, 0x02, 'h', '2', 0x08, 'h', 't', 't', 'p'}
+	protocols := parseALPNExtension(data)
+	if !slices.Equal(protocols, []string{"h2"}) {
+		t.Errorf("Expected [h2], got %v", protocols)
+	}
+}
+
+// fragmentClientHello splits the handshake message of a single-record ClientHello over records of at most size bytes
+func fragmentClientHello(hello []byte, size int) []byte {
+	msg := hello[5:]
+	var out []byte
+	for len(msg) > 0 {
+		n := min(size, len(msg))
+		out = append(out, 0x16, hello[1], hello[2], byte(n>>8), byte(n))
+		out = append(out, msg[:n]...)
+		msg = msg[n:]
+	}
+	return out
+}
+
+func TestParse_FragmentedClientHello(t *testing.T) {
+	hello := buildTLSClientHello("fragmented.example.com")
+
+	// Split even the 4-byte handshake header across records
+	for _, size := range []int{2, 16, 40} {
+		data := fragmentClientHello(hello, size)
+
+		info := Parse(data)
+		if !info.IsValid || info.Hostname != "fragmented.example.com" {
+			t.Fatalf("size %d: expected SNI fragmented.example.com, got %+v", size, info)
+		}
+		if !info.Complete || info.Consumed != len(data) {
+			t.Errorf("size %d: expected complete with Consumed %d, got %v %d", size, len(data), info.Complete, info.Consumed)
+		}
+
+		// Any prefix cut short of the last record is incomplete
+		partial := Parse(data[:len(data)-1])
+		if partial.Complete || !errors.Is(partial.ParseError, ErrNeedMoreData) {
+			t.Errorf("size %d: expected incomplete fragmented hello, got %+v", size, partial)
+		}
+	}
+}
+
+func TestParse_FragmentedInterruptedByOtherRecord(t *testing.T) {
+	data := fragmentClientHello(buildTLSClientHello("example.com"), 16)
+	data = append(data[:21], 0x17, 0x03, 0x03, 0x00, 0x00)
+
+	info := Parse(data)
+	if !info.Complete || info.ParseError == nil || info.IsValid {
+		t.Errorf("Expected parse error for interrupted handshake, got %+v", info)
+	}
+}
+
+func TestPeek_FragmentedClientHello(t *testing.T) {
+	data := fragmentClientHello(buildTLSClientHello("peek.example.com"), 32)
+	reader := bufio.NewReader(bytes.NewReader(append(data, "trailing"...)))
+
+	info := Peek(reader.Peek, 16384)
+	if !info.IsValid || info.Hostname != "peek.example.com" {
+		t.Fatalf("Expected SNI peek.example.com, got %+v", info)
+	}
+	if info.Consumed != len(data) {
+		t.Errorf("Expected Consumed %d, got %d", len(data), info.Consumed)
+	}
+	if reader.Buffered() == 0 {
+		t.Error("Expected Peek not to consume data")
+	}
+
+	// A limit below the hello size reports it as incomplete
+	reader = bufio.NewReader(bytes.NewReader(data))
+	if info := Peek(reader.Peek, 64); info.Complete || !errors.Is(info.ParseError, ErrNeedMoreData) {
+		t.Errorf("Expected incomplete hello when limit is exceeded, got %+v", info)
+	}
+}
+
+func TestParse_TLS13ClientHello(t *testing.T) {
+	data := captureClientHello(t, &tls.Config{
+		ServerName:         "tls13.example.com",
+		MinVersion:         tls.VersionTLS13,
+		InsecureSkipVerify: true,
+	})
+
+	info := Parse(data)
+	if !info.IsValid || info.Hostname != "tls13.example.com" {
+		t.Fatalf("Expected SNI tls13.example.com, got %+v", info)
+	}
+	if !info.SupportsTLS13() {
+		t.Errorf("Expected TLS 1.3 in supported_versions, got %x", info.SupportedVersions)
+	}
+	if info.HasECH {
+		t.Error("Expected no ECH extension")
+	}
+
+	// Fragmenting a real hello must give the same result
+	fragmented := Parse(fragmentClientHello(data, 100))
+	if fragmented.Hostname != info.Hostname || !slices.Equal(fragmented.SupportedVersions, info.SupportedVersions) {
+		t.Errorf("Expected fragmented hello to match, got %+v", fragmented)
+	}
+}
+
+func TestParse_SupportedVersionsAndECH(t *testing.T) {
+	hello := buildTLSClientHello("x")
+	hello = hello[:len(hello)-len(buildSNIExtension("x"))]
+
+	extensions := buildSNIExtension("public.example.com")
+	extensions = append(extensions, 0x00, 0x2b, 0x00, 0x05, 0x04, 0x03, 0x04, 0x03, 0x03) // supported_versions: 1.3, 1.2
+	extensions = append(extensions, 0xfe, 0x0d, 0x00, 0x01, 0x00)                         // encrypted_client_hello
+	hello = append(hello, extensions...)
+
+	extLen := len(extensions)
+	extLenPos := len(hello) - extLen - 2
+	hello[extLenPos], hello[extLenPos+1] = byte(extLen>>8), byte(extLen)
+	helloLen := len(hello) - 9
+	hello[6], hello[7], hello[8] = byte(helloLen>>16), byte(helloLen>>8), byte(helloLen)
+	hello[3], hello[4] = byte((helloLen+4)>>8), byte(helloLen+4)
+
+	info := Parse(hello)
+	if info.ParseError != nil {
+		t.Fatalf("Parse failed: %v", info.ParseError)
+	}
+	if !slices.Equal(info.SupportedVersions, []uint16{0x0304, 0x0303}) {
+		t.Errorf("Expected supported versions [0304 0303], got %x", info.SupportedVersions)
+	}
+	if !info.HasECH {
+		t.Error("Expected ECH flag to be set")
+	}
+	if info.Hostname != "public.example.com" {
+		t.Errorf("Expected outer SNI public.example.com, got %s", info.Hostname)
+	}
+}
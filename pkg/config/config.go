@@ -42,8 +42,44 @@ type ServerConfig struct {
 	// Listen address for the main proxy server
 	ListenAddr string `mapstructure:"listen_addr" yaml:"listen_addr"`
 
+	// Address family of the transparent proxy listener: ipv4, ipv6, dual for both, or empty to
+	// listen on the address as-is. The proxy listens on loopback, so ipv6 binds ::1 and dual binds
+	// 127.0.0.1 and ::1 on the same port. Automatic firewall rules only redirect IPv4, redirect IPv6
+	// with ip6tables -t nat ... -j REDIRECT. The DNS and admin servers listen on their address as-is.
+	ListenFamily string `mapstructure:"listen_family" yaml:"listen_family"`
+
 	// Log level (debug, info, warn, error)
 	LogLevel string `mapstructure:"log_level" yaml:"log_level"`
+
+	// Log format (json, text)
+	LogFormat string `mapstructure:"log_format" yaml:"log_format"`
+
+	// Log output: stdout, stderr or a file path
+	LogOutput string `mapstructure:"log_output" yaml:"log_output"`
+
+	// Rotate the log file when it exceeds this size in MB (0 = never rotate)
+	LogMaxSizeMB int `mapstructure:"log_max_size_mb" yaml:"log_max_size_mb"`
+
+	// Number of rotated log files to keep
+	LogMaxBackups int `mapstructure:"log_max_backups" yaml:"log_max_backups"`
+
+	// Maximum concurrent proxy connections (0 = unlimited)
+	MaxConnections int `mapstructure:"max_connections" yaml:"max_connections"`
+
+	// How long a new connection waits for a free slot when the limit is reached (0 = reject at once)
+	ConnectionQueueTimeout time.Duration `mapstructure:"connection_queue_timeout" yaml:"connection_queue_timeout"`
+
+	// Client IPs or CIDRs allowed to use the proxy (empty = all)
+	AllowedClients []string `mapstructure:"allowed_clients" yaml:"allowed_clients"`
+
+	// Client IPs or CIDRs denied from using the proxy, takes precedence over allowed_clients
+	DeniedClients []string `mapstructure:"denied_clients" yaml:"denied_clients"`
+
+	// Expect a PROXY protocol (v1/v2) header on inbound connections, for running behind a load balancer
+	AcceptProxyProtocol bool `mapstructure:"accept_proxy_protocol" yaml:"accept_proxy_protocol"`
+
+	// Load balancer IPs or CIDRs whose PROXY headers are trusted, required with accept_proxy_protocol
+	ProxyProtocolTrusted []string `mapstructure:"proxy_protocol_trusted" yaml:"proxy_protocol_trusted"`
 }
 
 // DNSConfig contains DNS分流 settings
@@ -51,6 +87,12 @@ type DNSConfig struct {
 	// Listen address for DNS server
 	ListenAddr string `mapstructure:"listen_addr" yaml:"listen_addr"`
 
+	// Serve DNS over UDP on the listen address
+	ListenUDP bool `mapstructure:"listen_udp" yaml:"listen_udp"`
+
+	// Serve DNS over TCP on the listen address (needed for truncated responses)
+	ListenTCP bool `mapstructure:"listen_tcp" yaml:"listen_tcp"`
+
 	// Domestic DNS servers (China)
 	DomesticDNS []string `mapstructure:"domestic_dns" yaml:"domestic_dns"`
 
@@ -60,8 +102,57 @@ type DNSConfig struct {
 	// DNS cache TTL
 	CacheTTL time.Duration `mapstructure:"cache_ttl" yaml:"cache_ttl"`
 
-	// Enable DNS over TCP for foreign queries
+	// Save the DNS cache to CacheFile on shutdown and load it on startup, expired entries are dropped
+	CachePersist bool   `mapstructure:"cache_persist" yaml:"cache_persist"`
+	CacheFile    string `mapstructure:"cache_file" yaml:"cache_file"`
+
+	// Protocol for domestic DNS servers: udp, tcp, doh or dot
+	DomesticProtocol string `mapstructure:"domestic_protocol" yaml:"domestic_protocol"`
+
+	// Protocol for foreign DNS servers: udp, tcp, doh or dot (empty = follow tcp_for_foreign)
+	ForeignProtocol string `mapstructure:"foreign_protocol" yaml:"foreign_protocol"`
+
+	// Deprecated: use ForeignProtocol, true maps to tcp when foreign_protocol is unset
 	TCPForForeign bool `mapstructure:"tcp_for_foreign" yaml:"tcp_for_foreign"`
+
+	// Source IP address or network interface name that queries to domestic/foreign servers are
+	// sent from (empty = let the OS choose), proxied foreign queries ignore it
+	DomesticBind string `mapstructure:"domestic_bind" yaml:"domestic_bind"`
+	ForeignBind  string `mapstructure:"foreign_bind" yaml:"foreign_bind"`
+
+	// Warn at startup when the China IP database is older than this (0 = never warn)
+	ChinaIPMaxAge time.Duration `mapstructure:"china_ip_max_age" yaml:"china_ip_max_age"`
+
+	// EDNS0 UDP payload size advertised to upstream DNS servers (0 = don't add EDNS0)
+	EDNSBufferSize uint16 `mapstructure:"edns_buffer_size" yaml:"edns_buffer_size"`
+
+	// Set the DNSSEC OK (DO) bit in upstream queries
+	EDNSDNSSECOK bool `mapstructure:"edns_dnssec_ok" yaml:"edns_dnssec_ok"`
+
+	// Answer with the domestic result when the foreign upstream fails (SERVFAIL, network error,
+	// timeout). A failed domestic query always moves on to the foreign upstream.
+	Fallback bool `mapstructure:"fallback" yaml:"fallback"`
+
+	// Override resolved IPs of domains in forwarded answers
+	Rewrite []DNSRewriteRule `mapstructure:"rewrite" yaml:"rewrite"`
+
+	// Strip additional and authority records from forwarded answers (SOA of negative answers is kept)
+	MinimizeResponses bool `mapstructure:"minimize_responses" yaml:"minimize_responses"`
+
+	// Only resolve these domains (and their subdomains) or globs, NXDOMAIN for the rest (empty = resolve all)
+	Allowlist []string `mapstructure:"allowlist" yaml:"allowlist"`
+
+	// Maximum number of domains kept in query statistics, least recently queried are evicted first
+	StatsMaxDomains int `mapstructure:"stats_max_domains" yaml:"stats_max_domains"`
+}
+
+// DNSRewriteRule pins the resolved IPs of a domain
+type DNSRewriteRule struct {
+	// Domain to rewrite (exact match)
+	Domain string `mapstructure:"domain" yaml:"domain"`
+
+	// IPs returned instead of the upstream A/AAAA records
+	IPs []string `mapstructure:"ips" yaml:"ips"`
 }
 
 // FirewallConfig contains firewall-related settings
@@ -88,6 +179,10 @@ type FirewallConfig struct {
 	// ReservedDomains is a list of domains that should be resolved using Chinese DNS
 	// and added to the firewall reserved list (bypass proxy, direct connection)
 	ReservedDomains []string `mapstructure:"reserved_domains" yaml:"reserved_domains"`
+
+	// OnGeoIPError decides where TCP traffic goes when China IP data can't be loaded for CN bypass:
+	// "upstream" redirects everything to the proxy, "direct" redirects nothing so nothing leaks upstream
+	OnGeoIPError string `mapstructure:"on_geoip_error" yaml:"on_geoip_error"`
 }
 
 // UpstreamConfig contains upstream proxy settings
@@ -105,7 +200,20 @@ type UpstreamConfig struct {
 	Username string `mapstructure:"username" yaml:"username"`
 
 	// Password for upstream proxy (optional)
-	Password string `mapstructure:"password" yaml:"password"`
+	Password string `mapstructure:"password" yaml:"password" redact:"true"`
+
+	// Number of warm connections kept to the upstream proxy (0 to disable pooling)
+	PoolSize int `mapstructure:"pool_size" yaml:"pool_size"`
+
+	// Max idle time of a pooled connection before it's discarded
+	PoolIdleTTL time.Duration `mapstructure:"pool_idle_ttl" yaml:"pool_idle_ttl"`
+
+	// Route every connection via upstream, China IPs included, and never fall back to direct
+	ForceUpstream bool `mapstructure:"force_upstream" yaml:"force_upstream"`
+
+	// Prepend a PROXY protocol header (v1, v2) carrying the client address, empty to disable.
+	// Connections are dialed per client, so this disables pooling.
+	ProxyProtocol string `mapstructure:"proxy_protocol" yaml:"proxy_protocol"`
 }
 
 // AdminConfig contains admin server settings
@@ -116,11 +224,27 @@ type AdminConfig struct {
 	// Admin server listen address
 	ListenAddr string `mapstructure:"listen_addr" yaml:"listen_addr"`
 
+	// Try the next ports when the listen port is in use
+	AutoPort bool `mapstructure:"auto_port" yaml:"auto_port"`
+
+	// Origins allowed to call the admin API cross-origin ("*" for any, same origin is always allowed)
+	CORSOrigins []string `mapstructure:"cors_origins" yaml:"cors_origins"`
+
 	// UI directory path for static files
 	UIPath string `mapstructure:"ui_path" yaml:"ui_path"`
 
 	// UI embed mode - serve embedded HTML directly
 	UIEmbed bool `mapstructure:"ui_embed" yaml:"ui_embed"`
+
+	// Expose Go profiling at /debug/pprof/, only mounted when PprofToken is set
+	Pprof bool `mapstructure:"pprof" yaml:"pprof"`
+
+	// Bearer token required by /debug/pprof/ (Authorization: Bearer <token>)
+	PprofToken string `mapstructure:"pprof_token" yaml:"pprof_token" redact:"true"`
+
+	// Bearer token required by /api/config, which serves the running configuration with
+	// secrets masked. The endpoint is only mounted when set
+	ConfigToken string `mapstructure:"config_token" yaml:"config_token" redact:"true"`
 }
 
 // MITMConfig contains MITM proxy settings
@@ -143,6 +267,9 @@ type MITMConfig struct {
 	// Site certificate validity duration
 	SiteCertValidity time.Duration `mapstructure:"site_cert_validity" yaml:"site_cert_validity"`
 
+	// Renew cached site certificates this long before they expire (0 = regenerate on demand after expiry)
+	CertRenewWindow time.Duration `mapstructure:"cert_renew_window" yaml:"cert_renew_window"`
+
 	// CA certificate validity duration (default: 365 days)
 	CACertValidity time.Duration `mapstructure:"ca_cert_validity" yaml:"ca_cert_validity"`
 
@@ -151,15 +278,65 @@ type MITMConfig struct {
 	// If specified, only traffic to these domains will be MITM'd
 	Whitelist []string `mapstructure:"whitelist" yaml:"whitelist"`
 
+	// Bypass is a list of SNI globs (e.g. "*.googlevideo.com") that are never decrypted,
+	// matching connections are tunneled as-is even if they would otherwise be MITM'd
+	Bypass []string `mapstructure:"bypass" yaml:"bypass"`
+
+	// AutoBypass bypasses hosts for an hour once the client refuses the MITM certificate (certificate
+	// alert or reset, e.g. cert pinning) or the upstream server rejects the handshake with a
+	// bad_certificate/unknown_ca alert (e.g. client cert required). Only upstream failures can
+	// tunnel the failed connection itself raw, a client that refused the certificate must retry
+	AutoBypass bool `mapstructure:"auto_bypass" yaml:"auto_bypass"`
+
 	// MaxBodySize is the maximum body size to capture for inspection (0 = unlimited)
 	MaxBodySize int64 `mapstructure:"max_body_size" yaml:"max_body_size"`
 
+	// MaxRequestBodySize and MaxResponseBodySize override MaxBodySize per direction, e.g. to keep
+	// LLM prompts in full while truncating large responses (0 = MaxBodySize)
+	MaxRequestBodySize  int64 `mapstructure:"max_request_body_size" yaml:"max_request_body_size"`
+	MaxResponseBodySize int64 `mapstructure:"max_response_body_size" yaml:"max_response_body_size"`
+
+	// MaxHeaderSize is the maximum HTTP header size buffered before a stream is passed through unparsed
+	MaxHeaderSize int64 `mapstructure:"max_header_size" yaml:"max_header_size"`
+
+	// MaxChunkedBodySize is the hard cap on a chunked request body buffered before its terminator,
+	// larger requests are passed through uninspected
+	MaxChunkedBodySize int64 `mapstructure:"max_chunked_body_size" yaml:"max_chunked_body_size"`
+
+	// MaxStreamDuration is the longest a streaming (SSE) response is inspected, longer streams are
+	// finalized and the rest passed through uninspected (0 = unlimited)
+	MaxStreamDuration time.Duration `mapstructure:"max_stream_duration" yaml:"max_stream_duration"`
+
+	// SkipRequestBody skips capturing request bodies in traffic events, metadata is still recorded
+	SkipRequestBody bool `mapstructure:"skip_request_body" yaml:"skip_request_body"`
+
+	// SkipResponseBody skips capturing response bodies in traffic events, metadata is still recorded
+	SkipResponseBody bool `mapstructure:"skip_response_body" yaml:"skip_response_body"`
+
+	// MetadataOnly records method, path, host, status, headers and sizes but never buffers or
+	// captures bodies, LLM inspection is disabled
+	MetadataOnly bool `mapstructure:"metadata_only" yaml:"metadata_only"`
+
+	// CaptureRawBody adds the body as received, before decompression, to traffic events next to
+	// the decoded body, base64 encoded and bounded by MaxBodySize
+	CaptureRawBody bool `mapstructure:"capture_raw_body" yaml:"capture_raw_body"`
+
+	// SizeBuckets are the body size histogram bucket upper bounds in bytes (default: 1KB, 10KB, 100KB)
+	SizeBuckets []int64 `mapstructure:"size_buckets" yaml:"size_buckets"`
+
 	// EventHistorySize is the number of events to keep in history for replay (default: 10)
 	EventHistorySize int `mapstructure:"event_history_size" yaml:"event_history_size"`
 
 	// LLMEventHistorySize is the number of LLM events to keep in history for replay (default: 10)
 	LLMEventHistorySize int `mapstructure:"llm_event_history_size" yaml:"llm_event_history_size"`
 
+	// EventBufferSize is the per-subscriber channel buffer of the traffic event bus, events are
+	// dropped for a subscriber that falls this far behind (default: 100)
+	EventBufferSize int `mapstructure:"event_buffer_size" yaml:"event_buffer_size"`
+
+	// LLMEventBufferSize is the per-subscriber channel buffer of the LLM event bus (default: 100)
+	LLMEventBufferSize int `mapstructure:"llm_event_buffer_size" yaml:"llm_event_buffer_size"`
+
 	// CustomAnthropicMatches is a list of custom hostname/path patterns for Anthropic API matching
 	// Format: "hostname/path" (e.g., "api.example.com/v1/messages")
 	// These patterns will be matched in addition to the built-in Anthropic-compatible APIs
@@ -169,6 +346,109 @@ type MITMConfig struct {
 	// Format: "hostname/path" (e.g., "api.myai.com/v1/chat/completions")
 	// These patterns will be matched in addition to the built-in OpenAI-compatible APIs
 	CustomOpenAIMatches []string `mapstructure:"custom_openai_matches" yaml:"custom_openai_matches"`
+
+	// ConversationIDStrategy groups Anthropic requests into conversations: metadata, messages-hash or header
+	ConversationIDStrategy string `mapstructure:"conversation_id_strategy" yaml:"conversation_id_strategy"`
+
+	// ConversationIDHeader is the request header used by the header strategy
+	ConversationIDHeader string `mapstructure:"conversation_id_header" yaml:"conversation_id_header"`
+
+	// SlowTokenRate flags LLM streams whose content events arrive slower than this many per second
+	// for SlowTokenWindow with a "slow" conversation update, a hint of provider throttling (0 = disabled)
+	SlowTokenRate   float64       `mapstructure:"slow_token_rate" yaml:"slow_token_rate"`
+	SlowTokenWindow time.Duration `mapstructure:"slow_token_window" yaml:"slow_token_window"`
+
+	// GRPCHosts are hostname globs of LLM gateways speaking gRPC or gRPC-Web, a non-zero
+	// grpc-status in their responses is reported as an LLM error (e.g. "*.grpc.example.com")
+	GRPCHosts []string `mapstructure:"grpc_hosts" yaml:"grpc_hosts"`
+
+	// Inject synthetic faults into responses for resilience testing
+	Chaos ChaosConfig `mapstructure:"chaos" yaml:"chaos"`
+
+	// Add X-Forwarded-For/X-Forwarded-Proto with the real client IP to intercepted requests
+	ForwardedFor ForwardedForConfig `mapstructure:"forwarded_for" yaml:"forwarded_for"`
+
+	// Pick which inspectors (llm, sse) run per hostname glob, first match wins.
+	// Hosts matching no profile run every inspector.
+	Profiles []InspectionProfileConfig `mapstructure:"profiles" yaml:"profiles"`
+
+	// Append TLS session secrets of intercepted connections to this file in SSLKEYLOGFILE
+	// format so packet captures can be decrypted in Wireshark. Debugging only, empty = disabled
+	KeyLogFile string `mapstructure:"key_log_file" yaml:"key_log_file"`
+
+	// POST every (or a sample of) traffic and LLM event as JSON to an external webhook
+	Webhook WebhookConfig `mapstructure:"webhook" yaml:"webhook"`
+
+	// Answer repeated GET requests from an in-memory cache honoring Cache-Control and ETag,
+	// responses served from it carry X-Linko-Cache and are flagged as cached in traffic events
+	ResponseCache ResponseCacheConfig `mapstructure:"response_cache" yaml:"response_cache"`
+}
+
+// ResponseCacheConfig controls caching of intercepted GET responses
+type ResponseCacheConfig struct {
+	Enable bool `mapstructure:"enable" yaml:"enable"`
+
+	// Responses kept, least recently used are evicted first
+	MaxEntries int `mapstructure:"max_entries" yaml:"max_entries"`
+
+	// Largest response body stored in bytes
+	MaxEntrySize int64 `mapstructure:"max_entry_size" yaml:"max_entry_size"`
+}
+
+// WebhookConfig controls forwarding of captured events to a webhook
+type WebhookConfig struct {
+	Enable bool `mapstructure:"enable" yaml:"enable"`
+
+	// Endpoint receiving the events, may carry credentials
+	URL string `mapstructure:"url" yaml:"url" redact:"true"`
+
+	// Fraction of events forwarded (0-1), 0 = all
+	SampleRate float64 `mapstructure:"sample_rate" yaml:"sample_rate"`
+
+	// Events waiting for delivery, events arriving while the queue is full are dropped
+	QueueSize int `mapstructure:"queue_size" yaml:"queue_size"`
+
+	// Retries of a failed delivery, waiting Backoff before the first and doubling it after each
+	MaxRetries int           `mapstructure:"max_retries" yaml:"max_retries"`
+	Backoff    time.Duration `mapstructure:"backoff" yaml:"backoff"`
+
+	// Timeout of a single delivery attempt
+	Timeout time.Duration `mapstructure:"timeout" yaml:"timeout"`
+}
+
+// InspectionProfileConfig maps hostname globs to an ordered list of inspectors
+type InspectionProfileConfig struct {
+	// Hostname globs, e.g. "*.anthropic.com", "*" for a catch-all default
+	Hosts []string `mapstructure:"hosts" yaml:"hosts"`
+
+	// Inspectors to run in order: llm, sse. Empty disables inspection for these hosts.
+	Inspectors []string `mapstructure:"inspectors" yaml:"inspectors"`
+}
+
+// ForwardedForConfig controls X-Forwarded-For injection
+type ForwardedForConfig struct {
+	Enable bool `mapstructure:"enable" yaml:"enable"`
+
+	// Mode is "append" to extend an existing X-Forwarded-For chain or "replace" to overwrite it
+	Mode string `mapstructure:"mode" yaml:"mode"`
+}
+
+// ChaosConfig contains fault injection settings, rates are fractions of responses (0-1)
+type ChaosConfig struct {
+	Enable bool `mapstructure:"enable" yaml:"enable"`
+
+	// Hostname substrings to inject faults for, empty = all MITM hosts
+	Hosts []string `mapstructure:"hosts" yaml:"hosts"`
+
+	// Close the connection instead of sending the response
+	DropRate float64 `mapstructure:"drop_rate" yaml:"drop_rate"`
+
+	// Cut the response off partway and close the connection
+	TruncateRate float64 `mapstructure:"truncate_rate" yaml:"truncate_rate"`
+
+	// Delay the response by Delay
+	DelayRate float64       `mapstructure:"delay_rate" yaml:"delay_rate"`
+	Delay     time.Duration `mapstructure:"delay" yaml:"delay"`
 }
 
 // DefaultConfig returns a default configuration
@@ -178,15 +458,34 @@ func DefaultConfig() *Config {
 
 	return &Config{
 		Server: ServerConfig{
-			ListenAddr: "127.0.0.1:9890",
-			LogLevel:   "info",
+			ListenAddr:             "127.0.0.1:9890",
+			LogLevel:               "info",
+			LogFormat:              "json",
+			LogOutput:              "stdout",
+			LogMaxSizeMB:           100,
+			LogMaxBackups:          3,
+			MaxConnections:         4096,
+			ConnectionQueueTimeout: 100 * time.Millisecond,
+			AllowedClients:         []string{},
+			DeniedClients:          []string{},
+			AcceptProxyProtocol:    false,
+			ProxyProtocolTrusted:   []string{},
 		},
 		DNS: DNSConfig{
-			ListenAddr:    "127.0.0.1:6363",
-			DomesticDNS:   []string{"223.5.5.5", "114.114.114.114"},
-			ForeignDNS:    []string{"8.8.8.8", "1.1.1.1"},
-			CacheTTL:      5 * time.Minute,
-			TCPForForeign: true,
+			ListenAddr:       "127.0.0.1:6363",
+			ListenUDP:        true,
+			ListenTCP:        true,
+			DomesticDNS:      []string{"223.5.5.5", "114.114.114.114"},
+			ForeignDNS:       []string{"8.8.8.8", "1.1.1.1"},
+			CacheTTL:         5 * time.Minute,
+			CachePersist:     false,
+			CacheFile:        filepath.Join(configDir, "dns_cache.json"),
+			DomesticProtocol: "udp",
+			TCPForForeign:    true,
+			ChinaIPMaxAge:    90 * 24 * time.Hour, // 90 days
+			EDNSBufferSize:   1232,
+			Fallback:         true,
+			StatsMaxDomains:  10000,
 		},
 		Firewall: FirewallConfig{
 			EnableAuto:    true,
@@ -194,31 +493,62 @@ func DefaultConfig() *Config {
 			RedirectHTTP:  true,
 			RedirectHTTPS: true,
 			RedirectSSH:   false,
+			OnGeoIPError:  "upstream",
 		},
 		Upstream: UpstreamConfig{
-			Enable:   true,
-			Type:     "socks5",
-			Addr:     "127.0.0.1:7891",
-			Username: "",
-			Password: "",
+			Enable:        true,
+			Type:          "socks5",
+			Addr:          "127.0.0.1:7891",
+			Username:      "",
+			Password:      "",
+			PoolSize:      0,
+			PoolIdleTTL:   30 * time.Second,
+			ForceUpstream: false,
+			ProxyProtocol: "",
 		},
 		Admin: AdminConfig{
-			Enable:     true,
-			ListenAddr: "0.0.0.0:9810",
-			UIPath:     "pkg/ui",
-			UIEmbed:    true,
+			Enable:      true,
+			ListenAddr:  "0.0.0.0:9810",
+			AutoPort:    false,
+			CORSOrigins: []string{},
+			UIPath:      "pkg/ui",
+			UIEmbed:     true,
 		},
 		MITM: MITMConfig{
-			Enable:              false,
-			GID:                 8001,
-			CACertPath:          filepath.Join(certsDir, "ca.crt"),
-			CAKeyPath:           filepath.Join(certsDir, "ca.key"),
-			CertCacheDir:        filepath.Join(certsDir, "sites"),
-			SiteCertValidity:    168 * time.Hour,      // 7 days
-			CACertValidity:      365 * 24 * time.Hour, // 365 days
-			MaxBodySize:         2097152,              // 2M default
-			EventHistorySize:    10,                   // Default 10 historical events
-			LLMEventHistorySize: 10,                   // Default 10 LLM historical events
+			Enable:                 false,
+			GID:                    8001,
+			CACertPath:             filepath.Join(certsDir, "ca.crt"),
+			CAKeyPath:              filepath.Join(certsDir, "ca.key"),
+			CertCacheDir:           filepath.Join(certsDir, "sites"),
+			SiteCertValidity:       168 * time.Hour,      // 7 days
+			CertRenewWindow:        24 * time.Hour,       // 1 day
+			CACertValidity:         365 * 24 * time.Hour, // 365 days
+			MaxBodySize:            2097152,              // 2M default
+			MaxHeaderSize:          65536,                // 64K default
+			MaxChunkedBodySize:     33554432,             // 32M default
+			MaxStreamDuration:      time.Hour,            // 1h default
+			EventHistorySize:       10,                   // Default 10 historical events
+			LLMEventHistorySize:    10,                   // Default 10 LLM historical events
+			EventBufferSize:        100,
+			LLMEventBufferSize:     100,
+			AutoBypass:             false,
+			SizeBuckets:            []int64{1024, 10240, 102400},
+			ConversationIDStrategy: "metadata",
+			SlowTokenWindow:        5 * time.Second,
+			ForwardedFor: ForwardedForConfig{
+				Mode: "append",
+			},
+			Profiles: []InspectionProfileConfig{},
+			Webhook: WebhookConfig{
+				QueueSize:  1000,
+				MaxRetries: 3,
+				Backoff:    500 * time.Millisecond,
+				Timeout:    10 * time.Second,
+			},
+			ResponseCache: ResponseCacheConfig{
+				MaxEntries:   1000,
+				MaxEntrySize: 1048576, // 1M
+			},
 		},
 	}
 }
@@ -231,6 +561,17 @@ func extractPort(listenAddr string) string {
 	return port
 }
 
+// ForeignDNSProtocol returns the protocol for foreign DNS servers, honoring the deprecated tcp_for_foreign
+func (c *DNSConfig) ForeignDNSProtocol() string {
+	if c.ForeignProtocol != "" {
+		return c.ForeignProtocol
+	}
+	if c.TCPForForeign {
+		return "tcp"
+	}
+	return "udp"
+}
+
 func (c *Config) ProxyPort() string {
 	return extractPort(c.Server.ListenAddr)
 }
@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -11,7 +12,14 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// StdinConfigPath is the config path that reads the configuration from stdin
+const StdinConfigPath = "-"
+
 func LoadConfig(configPath string) (*Config, error) {
+	if configPath == StdinConfigPath {
+		return LoadConfigFromReader(os.Stdin)
+	}
+
 	configDir := filepath.Dir(configPath)
 	if err := os.MkdirAll(configDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create config directory: %w", err)
@@ -34,6 +42,22 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
 
+	return unmarshalConfig(v, config)
+}
+
+// LoadConfigFromReader loads a YAML configuration from r on top of the defaults
+func LoadConfigFromReader(r io.Reader) (*Config, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+
+	if err := v.ReadConfig(r); err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	return unmarshalConfig(v, DefaultConfig())
+}
+
+func unmarshalConfig(v *viper.Viper, config *Config) (*Config, error) {
 	if err := v.Unmarshal(config); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfigYAML = `server:
+    listen_addr: 127.0.0.1:9999
+    log_level: debug
+dns:
+    listen_addr: 127.0.0.1:5353
+    domestic_dns:
+        - 223.5.5.5
+    foreign_dns:
+        - 1.1.1.1
+    cache_ttl: 1m0s
+upstream:
+    enable: false
+`
+
+func TestLoadConfigFromReader_MatchesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "linko.yaml")
+	if err := os.WriteFile(path, []byte(testConfigYAML), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	fromFile, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	fromReader, err := LoadConfigFromReader(strings.NewReader(testConfigYAML))
+	if err != nil {
+		t.Fatalf("LoadConfigFromReader failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(fromFile, fromReader) {
+		t.Errorf("Expected identical configs:\nfile:   %+v\nreader: %+v", fromFile, fromReader)
+	}
+	if fromReader.Server.ListenAddr != "127.0.0.1:9999" || fromReader.DNS.CacheTTL != time.Minute {
+		t.Errorf("Expected values from YAML, got listen_addr=%s cache_ttl=%s", fromReader.Server.ListenAddr, fromReader.DNS.CacheTTL)
+	}
+	if fromReader.Upstream.Enable {
+		t.Error("Expected upstream to be disabled")
+	}
+}
+
+func TestLoadConfig_Stdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	go func() {
+		w.WriteString(testConfigYAML)
+		w.Close()
+	}()
+
+	origStdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = origStdin }()
+
+	cfg, err := LoadConfig(StdinConfigPath)
+	if err != nil {
+		t.Fatalf("LoadConfig(-) failed: %v", err)
+	}
+	if cfg.DNS.ListenAddr != "127.0.0.1:5353" {
+		t.Errorf("Expected DNS listen address from stdin, got %s", cfg.DNS.ListenAddr)
+	}
+}
+
+func TestLoadConfigFromReader_Invalid(t *testing.T) {
+	if _, err := LoadConfigFromReader(strings.NewReader("dns:\n    listen_addr: \"\"\n")); err == nil {
+		t.Error("Expected validation error")
+	}
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// RedactedValue replaces the value of a non-empty field tagged `redact:"true"`
+const RedactedValue = "******"
+
+// Redacted returns the configuration as nested maps keyed like the config file, with the
+// values of fields tagged `redact:"true"` masked so it can be shown by the admin API
+func (c *Config) Redacted() map[string]any {
+	return redactValue(reflect.ValueOf(*c)).(map[string]any)
+}
+
+// fieldName returns the config file key of a struct field
+func fieldName(f reflect.StructField) string {
+	for _, tag := range []string{"yaml", "mapstructure"} {
+		if name, _, _ := strings.Cut(f.Tag.Get(tag), ","); name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+func redactValue(v reflect.Value) any {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		out := make(map[string]any, t.NumField())
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name := fieldName(f)
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if f.Tag.Get("redact") == "true" && !v.Field(i).IsZero() {
+				out[name] = RedactedValue
+				continue
+			}
+			out[name] = redactValue(v.Field(i))
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		out := make([]any, v.Len())
+		for i := range v.Len() {
+			out[i] = redactValue(v.Index(i))
+		}
+		return out
+	case reflect.Map:
+		out := make(map[string]any, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			out[iter.Key().String()] = redactValue(iter.Value())
+		}
+		return out
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return redactValue(v.Elem())
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,51 @@
+package dns
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DomainAllowlist limits resolution to listed domains, everything else gets NXDOMAIN
+type DomainAllowlist struct {
+	suffixes []string // "example.com" matches example.com and its subdomains
+	globs    []string // Entries with glob characters, e.g. "api-*.example.com"
+}
+
+// NewDomainAllowlist creates an allowlist from domain and glob entries
+func NewDomainAllowlist(entries []string) (*DomainAllowlist, error) {
+	a := &DomainAllowlist{}
+	for _, entry := range entries {
+		entry = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(entry)), ".")
+		if entry == "" {
+			return nil, fmt.Errorf("allowlist has empty entry")
+		}
+		if strings.ContainsAny(entry, "*?[") {
+			if _, err := path.Match(entry, ""); err != nil {
+				return nil, fmt.Errorf("invalid allowlist glob %q: %w", entry, err)
+			}
+			a.globs = append(a.globs, entry)
+			continue
+		}
+		a.suffixes = append(a.suffixes, entry)
+	}
+	return a, nil
+}
+
+// Allowed reports whether name (FQDN or not) matches an allowlist entry
+func (a *DomainAllowlist) Allowed(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, suffix := range a.suffixes {
+		if dns.IsSubDomain(suffix, name) {
+			return true
+		}
+	}
+	for _, glob := range a.globs {
+		if matched, _ := path.Match(glob, name); matched {
+			return true
+		}
+	}
+	return false
+}
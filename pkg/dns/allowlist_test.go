@@ -0,0 +1,36 @@
+package dns
+
+import "testing"
+
+func TestDomainAllowlist_Allowed(t *testing.T) {
+	allowlist, err := NewDomainAllowlist([]string{"Example.com.", "api-*.corp.local", "*.svc"})
+	if err != nil {
+		t.Fatalf("NewDomainAllowlist failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		allowed bool
+	}{
+		{"example.com.", true},
+		{"www.example.com.", true},
+		{"WWW.EXAMPLE.COM", true},
+		{"notexample.com.", false},
+		{"example.com.evil.org.", false},
+		{"api-v1.corp.local.", true},
+		{"web.corp.local.", false},
+		{"db.svc.", true},
+		{"svc.", false},
+	}
+	for _, tt := range tests {
+		if got := allowlist.Allowed(tt.name); got != tt.allowed {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.name, got, tt.allowed)
+		}
+	}
+
+	for _, entries := range [][]string{{""}, {"[a-"}} {
+		if _, err := NewDomainAllowlist(entries); err == nil {
+			t.Errorf("Expected error for entries %q", entries)
+		}
+	}
+}
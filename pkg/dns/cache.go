@@ -3,23 +3,15 @@ package dns
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"fmt"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
-var typeMap = map[uint16]string{
-	dns.TypeA:     "A",
-	dns.TypeAAAA:  "AAAA",
-	dns.TypeCNAME: "CNAME",
-	dns.TypeMX:    "MX",
-	dns.TypeNS:    "NS",
-	dns.TypeTXT:   "TXT",
-	dns.TypeSOA:   "SOA",
-}
-
 // CacheEntry represents a cached DNS response
 type CacheEntry struct {
 	Response  *dns.Msg
@@ -107,19 +99,15 @@ func (c *DNSCache) Set(question, response *dns.Msg) {
 	c.cache[key] = entry
 }
 
-// generateKey generates a cache key from DNS question
+// generateKey generates a cache key from the name, type and class of the DNS question
 func (c *DNSCache) generateKey(question *dns.Msg) string {
 	if len(question.Question) == 0 {
 		return ""
 	}
 
+	// Names compare case-insensitively, type and class keep A/AAAA and other lookups apart
 	q := question.Question[0]
-	typeStr, exists := typeMap[q.Qtype]
-	if !exists {
-		typeStr = "UNKNOWN"
-	}
-
-	keyData := q.Name + ":" + typeStr
+	keyData := fmt.Sprintf("%s:%d:%d", strings.ToLower(q.Name), q.Qtype, q.Qclass)
 	hash := sha1.Sum([]byte(keyData))
 	return hex.EncodeToString(hash[:])
 }
@@ -0,0 +1,107 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// persistedEntry is a cache entry as stored on disk, the response in DNS wire format
+type persistedEntry struct {
+	Key       string    `json:"key"`
+	Response  []byte    `json:"response"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SaveToFile writes unexpired entries to path so the cache can be warmed on the next start
+func (c *DNSCache) SaveToFile(path string) (int, error) {
+	c.Mutex.RLock()
+	now := time.Now()
+	entries := make([]persistedEntry, 0, len(c.cache))
+	for key, entry := range c.cache {
+		if now.After(entry.ExpiresAt) {
+			continue
+		}
+		packed, err := entry.Response.Pack()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, persistedEntry{
+			Key:       key,
+			Response:  packed,
+			ExpiresAt: entry.ExpiresAt,
+			CreatedAt: entry.CreatedAt,
+		})
+	}
+	c.Mutex.RUnlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	// Write then rename so a crash mid-write never leaves a truncated cache file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// LoadFromFile restores entries saved by SaveToFile, dropping those whose TTL ran out meanwhile.
+// A missing file is not an error.
+func (c *DNSCache) LoadFromFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var entries []persistedEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return 0, fmt.Errorf("failed to parse DNS cache file: %w", err)
+	}
+
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+
+	now := time.Now()
+	loaded := 0
+	for _, e := range entries {
+		if !now.Before(e.ExpiresAt) {
+			continue
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(e.Response); err != nil {
+			continue
+		}
+		// Rekey from the question so files written with an older key format stay usable
+		key := e.Key
+		if len(resp.Question) > 0 {
+			key = c.generateKey(resp)
+		}
+		if len(c.cache) >= c.maxSize {
+			c.evictOldest()
+		}
+		c.cache[key] = &CacheEntry{
+			Response:  resp,
+			ExpiresAt: e.ExpiresAt,
+			CreatedAt: e.CreatedAt,
+		}
+		loaded++
+	}
+	return loaded, nil
+}
@@ -1,6 +1,7 @@
 package dns
 
 import (
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -187,3 +188,113 @@ func TestDNSCache_Eviction(t *testing.T) {
 		t.Errorf("Expected cache size to be limited to 2, got %d", stats["size"])
 	}
 }
+
+func TestDNSCache_SaveAndLoad(t *testing.T) {
+	cache := NewDNSCache(5*time.Minute, 100)
+
+	fresh := new(dns.Msg)
+	fresh.SetQuestion("fresh.example.com.", dns.TypeA)
+	freshResp := new(dns.Msg)
+	freshResp.SetReply(fresh)
+	rr, _ := dns.NewRR("fresh.example.com. 300 IN A 1.2.3.4")
+	freshResp.Answer = append(freshResp.Answer, rr)
+	cache.Set(fresh, freshResp)
+
+	stale := new(dns.Msg)
+	stale.SetQuestion("stale.example.com.", dns.TypeA)
+	staleResp := new(dns.Msg)
+	staleResp.SetReply(stale)
+	rr, _ = dns.NewRR("stale.example.com. 1 IN A 5.6.7.8")
+	staleResp.Answer = append(staleResp.Answer, rr)
+	cache.Set(stale, staleResp)
+
+	path := filepath.Join(t.TempDir(), "cache", "dns_cache.json")
+	saved, err := cache.SaveToFile(path)
+	if err != nil {
+		t.Fatalf("SaveToFile failed: %v", err)
+	}
+	if saved != 2 {
+		t.Fatalf("Expected 2 saved entries, got %d", saved)
+	}
+
+	// Let the 1s entry expire between shutdown and restart
+	time.Sleep(1100 * time.Millisecond)
+
+	restored := NewDNSCache(5*time.Minute, 100)
+	loaded, err := restored.LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadFromFile failed: %v", err)
+	}
+	if loaded != 1 {
+		t.Errorf("Expected 1 loaded entry, got %d", loaded)
+	}
+
+	got := restored.Get(fresh)
+	if got == nil || len(got.Answer) != 1 {
+		t.Fatal("Expected unexpired entry to survive reload")
+	}
+	if a, ok := got.Answer[0].(*dns.A); !ok || a.A.String() != "1.2.3.4" {
+		t.Errorf("Expected restored answer 1.2.3.4, got %v", got.Answer[0])
+	}
+	if restored.Get(stale) != nil {
+		t.Error("Expected expired entry to be dropped on reload")
+	}
+
+	if n, err := NewDNSCache(time.Minute, 10).LoadFromFile(filepath.Join(t.TempDir(), "missing.json")); err != nil || n != 0 {
+		t.Errorf("Expected missing cache file to load nothing without error, got %d, %v", n, err)
+	}
+}
+
+func TestDNSCache_KeyedByTypeAndClass(t *testing.T) {
+	cache := NewDNSCache(5*time.Minute, 100)
+
+	answers := map[uint16]string{
+		dns.TypeA:     "example.com. 300 IN A 1.2.3.4",
+		dns.TypeAAAA:  "example.com. 60 IN AAAA 2001:db8::1",
+		dns.TypeHTTPS: "example.com. 300 IN HTTPS 1 .",
+		dns.TypeSRV:   "example.com. 300 IN SRV 0 5 5060 sip.example.com.",
+	}
+	for qtype, answer := range answers {
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", qtype)
+		resp := new(dns.Msg)
+		resp.SetReply(query)
+		rr, err := dns.NewRR(answer)
+		if err != nil {
+			t.Fatalf("NewRR(%q) failed: %v", answer, err)
+		}
+		resp.Answer = append(resp.Answer, rr)
+		cache.Set(query, resp)
+	}
+
+	for qtype := range answers {
+		query := new(dns.Msg)
+		// Lookups match names case-insensitively
+		query.SetQuestion("EXAMPLE.com.", qtype)
+		got := cache.Get(query)
+		if got == nil || len(got.Answer) != 1 {
+			t.Fatalf("Expected cached %s answer, got %v", dns.TypeToString[qtype], got)
+		}
+		if rrType := got.Answer[0].Header().Rrtype; rrType != qtype {
+			t.Errorf("%s lookup returned %s answer", dns.TypeToString[qtype], dns.TypeToString[rrType])
+		}
+	}
+
+	// Same name and type in another class is a separate entry
+	chaos := new(dns.Msg)
+	chaos.SetQuestion("example.com.", dns.TypeA)
+	chaos.Question[0].Qclass = dns.ClassCHAOS
+	if cache.Get(chaos) != nil {
+		t.Error("Expected CHAOS class lookup to miss the IN class entry")
+	}
+
+	// Entries keep their own TTL, the AAAA answer's 60s is shorter than the A answer's 300s
+	a, aaaa := new(dns.Msg), new(dns.Msg)
+	a.SetQuestion("example.com.", dns.TypeA)
+	aaaa.SetQuestion("example.com.", dns.TypeAAAA)
+	aEntry, _ := cache.GetEntry(cache.generateKey(a))
+	aaaaEntry, _ := cache.GetEntry(cache.generateKey(aaaa))
+	if aEntry == nil || aaaaEntry == nil || !aaaaEntry.ExpiresAt.Before(aEntry.ExpiresAt) {
+		t.Errorf("Expected independent TTLs for A and AAAA entries")
+	}
+}
@@ -0,0 +1,109 @@
+package dns
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// dohContentType is the RFC 8484 media type for wire-format DNS messages
+	dohContentType = "application/dns-message"
+	// dohMaxMessageSize caps request bodies at the DNS message size limit
+	dohMaxMessageSize = dns.MaxMsgSize
+)
+
+// ServeDoH handles RFC 8484 DNS-over-HTTPS GET and POST requests
+func (s *DNSServer) ServeDoH(w http.ResponseWriter, r *http.Request) {
+	var wire []byte
+	switch r.Method {
+	case http.MethodGet:
+		param := r.URL.Query().Get("dns")
+		if param == "" {
+			http.Error(w, "missing dns parameter", http.StatusBadRequest)
+			return
+		}
+		data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(param, "="))
+		if err != nil {
+			http.Error(w, "invalid dns parameter", http.StatusBadRequest)
+			return
+		}
+		wire = data
+	case http.MethodPost:
+		if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, dohContentType) {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(r.Body, dohMaxMessageSize+1))
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		if len(data) > dohMaxMessageSize {
+			http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		wire = data
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := new(dns.Msg)
+	if err := req.Unpack(wire); err != nil {
+		http.Error(w, "invalid dns message", http.StatusBadRequest)
+		return
+	}
+	if len(req.Question) == 0 {
+		http.Error(w, "empty question", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.query(req)
+	if err != nil {
+		resp = new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeServerFailure)
+	}
+
+	packed, err := resp.Pack()
+	if err != nil {
+		http.Error(w, "failed to pack response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", dohContentType)
+	if maxAge, ok := dohMaxAge(resp); ok {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", maxAge))
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.Write(packed)
+}
+
+// dohMaxAge returns the smallest TTL in the response, per RFC 8484 section 5.1
+func dohMaxAge(resp *dns.Msg) (uint32, bool) {
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return 0, false
+	}
+
+	var minTTL uint32
+	found := false
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			// OPT pseudo-records carry flags in the TTL field
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if ttl := rr.Header().Ttl; !found || ttl < minTTL {
+				minTTL = ttl
+				found = true
+			}
+		}
+	}
+	return minTTL, found
+}
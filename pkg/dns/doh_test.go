@@ -0,0 +1,147 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// stubResolver answers every A query with a fixed address
+type stubResolver struct {
+	calls int
+}
+
+func (r *stubResolver) SplitQuery(_ context.Context, question *dns.Msg) (*dns.Msg, error) {
+	r.calls++
+	resp := new(dns.Msg)
+	resp.SetReply(question)
+	rr, _ := dns.NewRR(question.Question[0].Name + " 120 IN A 5.6.7.8")
+	resp.Answer = append(resp.Answer, rr)
+	return resp, nil
+}
+
+func newDoHTestServer() (*DNSServer, *stubResolver) {
+	resolver := &stubResolver{}
+	server := NewDNSServer("127.0.0.1:0", nil, NewDNSCache(5*time.Minute, 100))
+	server.resolver = resolver
+	return server, resolver
+}
+
+func packTestQuery(t *testing.T) []byte {
+	t.Helper()
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	query.Id = 0
+	wire, err := query.Pack()
+	if err != nil {
+		t.Fatalf("Failed to pack query: %v", err)
+	}
+	return wire
+}
+
+func checkDoHResponse(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != dohContentType {
+		t.Errorf("Expected content type %s, got %s", dohContentType, ct)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "max-age=120" {
+		t.Errorf("Expected Cache-Control max-age=120, got %s", cc)
+	}
+
+	body, _ := io.ReadAll(rec.Body)
+	resp := new(dns.Msg)
+	if err := resp.Unpack(body); err != nil {
+		t.Fatalf("Failed to unpack response: %v", err)
+	}
+	if len(resp.Answer) != 1 {
+		t.Fatalf("Expected 1 answer, got %d", len(resp.Answer))
+	}
+	if a, ok := resp.Answer[0].(*dns.A); !ok || a.A.String() != "5.6.7.8" {
+		t.Errorf("Expected A 5.6.7.8, got %v", resp.Answer[0])
+	}
+}
+
+func TestDoH_Get(t *testing.T) {
+	server, _ := newDoHTestServer()
+	defer server.Stop()
+
+	param := base64.RawURLEncoding.EncodeToString(packTestQuery(t))
+	req := httptest.NewRequest(http.MethodGet, "/dns-query?dns="+param, nil)
+	rec := httptest.NewRecorder()
+	server.ServeDoH(rec, req)
+
+	checkDoHResponse(t, rec)
+}
+
+func TestDoH_Post(t *testing.T) {
+	server, _ := newDoHTestServer()
+	defer server.Stop()
+
+	req := httptest.NewRequest(http.MethodPost, "/dns-query", bytes.NewReader(packTestQuery(t)))
+	req.Header.Set("Content-Type", dohContentType)
+	rec := httptest.NewRecorder()
+	server.ServeDoH(rec, req)
+
+	checkDoHResponse(t, rec)
+}
+
+func TestDoH_UsesCache(t *testing.T) {
+	server, resolver := newDoHTestServer()
+	defer server.Stop()
+
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/dns-query", bytes.NewReader(packTestQuery(t)))
+		req.Header.Set("Content-Type", dohContentType)
+		rec := httptest.NewRecorder()
+		server.ServeDoH(rec, req)
+		checkDoHResponse(t, rec)
+	}
+
+	if resolver.calls != 1 {
+		t.Errorf("Expected resolver to be called once, got %d", resolver.calls)
+	}
+}
+
+func TestDoH_BadRequests(t *testing.T) {
+	server, _ := newDoHTestServer()
+	defer server.Stop()
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        []byte
+		wantStatus  int
+	}{
+		{"missing param", http.MethodGet, "/dns-query", "", nil, http.StatusBadRequest},
+		{"invalid base64", http.MethodGet, "/dns-query?dns=!!!", "", nil, http.StatusBadRequest},
+		{"invalid message", http.MethodPost, "/dns-query", dohContentType, []byte("garbage"), http.StatusBadRequest},
+		{"wrong content type", http.MethodPost, "/dns-query", "application/json", []byte("{}"), http.StatusUnsupportedMediaType},
+		{"wrong method", http.MethodPut, "/dns-query", "", nil, http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, bytes.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			server.ServeDoH(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Upstream DNS protocols, selected separately for domestic and foreign servers
+const (
+	ProtocolUDP = "udp"
+	ProtocolTCP = "tcp"
+	ProtocolDoH = "doh" // DNS over HTTPS (RFC 8484), servers are URLs or hosts serving /dns-query
+	ProtocolDoT = "dot" // DNS over TLS (RFC 7858), port 853 unless given
+)
+
+// upstreamTimeout bounds a single exchange with an upstream DNS server
+const upstreamTimeout = 5 * time.Second
+
+func validProtocol(protocol string) bool {
+	switch protocol {
+	case ProtocolUDP, ProtocolTCP, ProtocolDoH, ProtocolDoT:
+		return true
+	}
+	return false
+}
+
+// exchange sends query to server over protocol, TCP based protocols go through the
+// upstream proxy when proxied is set and the proxy is enabled
+func (s *DNSSplitter) exchange(ctx context.Context, query *dns.Msg, server, protocol string, proxied bool) (*dns.Msg, error) {
+	switch protocol {
+	case ProtocolTCP:
+		return s.exchangeStream(ctx, query, serverAddr(server), nil, proxied)
+	case ProtocolDoT:
+		addr := serverAddrWithPort(server, "853")
+		host, _, _ := net.SplitHostPort(addr)
+		tlsConfig := s.tlsConfig.Clone()
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig.ServerName = host
+		return s.exchangeStream(ctx, query, addr, tlsConfig, proxied)
+	case ProtocolDoH:
+		return s.exchangeDoH(ctx, query, server, proxied)
+	default:
+		client := s.client
+		if s.bindAddr(proxied) != nil {
+			client = &dns.Client{Timeout: upstreamTimeout, Dialer: s.dialer(ProtocolUDP, proxied)}
+		}
+		resp, _, err := client.ExchangeContext(ctx, query, serverAddr(server))
+		return resp, err
+	}
+}
+
+// exchangeStream exchanges a length-prefixed message over TCP, wrapped in TLS when tlsConfig is set
+func (s *DNSSplitter) exchangeStream(ctx context.Context, query *dns.Msg, addr string, tlsConfig *tls.Config, proxied bool) (*dns.Msg, error) {
+	conn, err := s.dial(ctx, addr, proxied)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(upstreamTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	if tlsConfig != nil {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, fmt.Errorf("TLS handshake with %s failed: %w", addr, err)
+		}
+		conn = tlsConn
+	}
+
+	client := &dns.Client{Net: "tcp"}
+	resp, _, err := client.ExchangeWithConn(query, &dns.Conn{Conn: conn})
+	return resp, err
+}
+
+// dial opens a TCP connection to addr, through the upstream proxy when proxied
+func (s *DNSSplitter) dial(ctx context.Context, addr string, proxied bool) (net.Conn, error) {
+	if proxied && s.upstream != nil && s.upstream.IsEnabled() {
+		host, port, _ := net.SplitHostPort(addr)
+		portNum, _ := strconv.Atoi(port)
+		return s.upstream.Connect(host, portNum)
+	}
+	return s.dialer(ProtocolTCP, proxied).DialContext(ctx, "tcp", addr)
+}
+
+// bindAddr returns the source address of foreign (proxied) or domestic queries, nil = OS default
+func (s *DNSSplitter) bindAddr(proxied bool) net.IP {
+	if proxied {
+		return s.foreignBind
+	}
+	return s.domesticBind
+}
+
+// dialer returns a dialer for direct udp or tcp exchanges, bound to the configured source address
+func (s *DNSSplitter) dialer(network string, proxied bool) *net.Dialer {
+	dialer := &net.Dialer{Timeout: upstreamTimeout}
+	if ip := s.bindAddr(proxied); ip != nil {
+		if network == ProtocolUDP {
+			dialer.LocalAddr = &net.UDPAddr{IP: ip}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+	return dialer
+}
+
+// resolveBindAddr parses an IP address or looks up the address of a network interface,
+// preferring IPv4 as the default DNS servers are IPv4
+func resolveBindAddr(bind string) (net.IP, error) {
+	if bind == "" {
+		return nil, nil
+	}
+	if ip := net.ParseIP(bind); ip != nil {
+		return ip, nil
+	}
+	iface, err := net.InterfaceByName(bind)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither an IP address nor a network interface: %w", bind, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses of %s: %w", bind, err)
+	}
+	var found net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP, nil
+		}
+		if found == nil {
+			found = ipNet.IP
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("network interface %s has no usable address", bind)
+	}
+	return found, nil
+}
+
+// exchangeDoH POSTs the wire-format query to a DoH server
+func (s *DNSSplitter) exchangeDoH(ctx context.Context, query *dns.Msg, server string, proxied bool) (*dns.Msg, error) {
+	wire, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack query: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dohURL(server), bytes.NewReader(wire))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+
+	client := s.dohDirect
+	if proxied {
+		client = s.dohProxied
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned HTTP %d", server, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dohMaxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > dohMaxMessageSize {
+		return nil, fmt.Errorf("%s returned an oversized message", server)
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(body); err != nil {
+		return nil, fmt.Errorf("invalid DoH response from %s: %w", server, err)
+	}
+	return msg, nil
+}
+
+// newDoHClient returns an HTTP client for DoH queries, dialing through the upstream proxy when proxied
+func (s *DNSSplitter) newDoHClient(proxied bool) *http.Client {
+	return &http.Client{
+		Timeout: upstreamTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return s.dial(ctx, addr, proxied)
+			},
+			TLSClientConfig:   s.tlsConfig,
+			ForceAttemptHTTP2: true,
+		},
+	}
+}
+
+// dohURL returns the query URL of a DoH server, a bare host is served at /dns-query
+func dohURL(server string) string {
+	if strings.Contains(server, "://") {
+		return server
+	}
+	return "https://" + server + "/dns-query"
+}
@@ -0,0 +1,93 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// AnswerRewriter replaces resolved A/AAAA records of pinned domains in forwarded responses
+type AnswerRewriter struct {
+	ipv4 map[string][]net.IP // fqdn -> pinned IPv4 addresses
+	ipv6 map[string][]net.IP // fqdn -> pinned IPv6 addresses
+}
+
+// NewAnswerRewriter creates a rewriter from domain -> IPs rules
+func NewAnswerRewriter(rules map[string][]string) (*AnswerRewriter, error) {
+	r := &AnswerRewriter{
+		ipv4: make(map[string][]net.IP),
+		ipv6: make(map[string][]net.IP),
+	}
+	for domain, ips := range rules {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			return nil, fmt.Errorf("rewrite rule has empty domain")
+		}
+		name := dns.Fqdn(domain)
+		for _, s := range ips {
+			ip := net.ParseIP(strings.TrimSpace(s))
+			if ip == nil {
+				return nil, fmt.Errorf("invalid rewrite IP %q for %s", s, domain)
+			}
+			if ipv4 := ip.To4(); ipv4 != nil {
+				r.ipv4[name] = append(r.ipv4[name], ipv4)
+			} else {
+				r.ipv6[name] = append(r.ipv6[name], ip)
+			}
+		}
+	}
+	return r, nil
+}
+
+// Rewrite replaces the A/AAAA answers for the question domain with the pinned IPs.
+// The owner name and TTL of the replaced records and all other records (e.g. CNAME) are kept.
+func (r *AnswerRewriter) Rewrite(resp *dns.Msg) bool {
+	if resp == nil || len(resp.Question) == 0 {
+		return false
+	}
+	name := strings.ToLower(resp.Question[0].Name)
+
+	rewritten := false
+	if ips, ok := r.ipv4[name]; ok {
+		rewritten = rewriteAnswers(resp, dns.TypeA, ips) || rewritten
+	}
+	if ips, ok := r.ipv6[name]; ok {
+		rewritten = rewriteAnswers(resp, dns.TypeAAAA, ips) || rewritten
+	}
+	return rewritten
+}
+
+// rewriteAnswers swaps records of rrtype for ips in place of the first matching record
+func rewriteAnswers(resp *dns.Msg, rrtype uint16, ips []net.IP) bool {
+	idx := -1
+	var hdr dns.RR_Header
+	answers := make([]dns.RR, 0, len(resp.Answer))
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype != rrtype {
+			answers = append(answers, rr)
+			continue
+		}
+		if idx < 0 {
+			idx = len(answers)
+			hdr = *rr.Header()
+			hdr.Rdlength = 0
+		}
+	}
+	if idx < 0 {
+		return false
+	}
+
+	pinned := make([]dns.RR, 0, len(ips))
+	for _, ip := range ips {
+		if rrtype == dns.TypeA {
+			pinned = append(pinned, &dns.A{Hdr: hdr, A: ip})
+		} else {
+			pinned = append(pinned, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+
+	resp.Answer = append(answers[:idx], append(pinned, answers[idx:]...)...)
+	return true
+}
@@ -0,0 +1,125 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func newForwardedResponse(t *testing.T, rrs ...string) *dns.Msg {
+	t.Helper()
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	resp.RecursionAvailable = true
+	resp.AuthenticatedData = true
+	for _, s := range rrs {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatalf("Failed to parse RR %q: %v", s, err)
+		}
+		resp.Answer = append(resp.Answer, rr)
+	}
+	return resp
+}
+
+func TestAnswerRewriter_ReplacesARecords(t *testing.T) {
+	rewriter, err := NewAnswerRewriter(map[string][]string{"Example.com": {"10.0.0.1", "10.0.0.2"}})
+	if err != nil {
+		t.Fatalf("NewAnswerRewriter failed: %v", err)
+	}
+
+	resp := newForwardedResponse(t,
+		"example.com. 300 IN CNAME cdn.example.net.",
+		"cdn.example.net. 120 IN A 1.2.3.4",
+		"cdn.example.net. 120 IN A 1.2.3.5",
+	)
+	flags := resp.MsgHdr
+
+	if !rewriter.Rewrite(resp) {
+		t.Fatal("Expected response to be rewritten")
+	}
+
+	if resp.MsgHdr != flags {
+		t.Errorf("Expected header to be unchanged, got %+v want %+v", resp.MsgHdr, flags)
+	}
+	if len(resp.Answer) != 3 {
+		t.Fatalf("Expected 3 answers, got %d: %v", len(resp.Answer), resp.Answer)
+	}
+	if cname, ok := resp.Answer[0].(*dns.CNAME); !ok || cname.Target != "cdn.example.net." {
+		t.Errorf("Expected CNAME to be preserved, got %v", resp.Answer[0])
+	}
+	for i, want := range []string{"10.0.0.1", "10.0.0.2"} {
+		a, ok := resp.Answer[i+1].(*dns.A)
+		if !ok {
+			t.Fatalf("Expected A record, got %T", resp.Answer[i+1])
+		}
+		if a.A.String() != want {
+			t.Errorf("Expected %s, got %s", want, a.A)
+		}
+		if a.Hdr.Name != "cdn.example.net." || a.Hdr.Ttl != 120 || a.Hdr.Class != dns.ClassINET {
+			t.Errorf("Expected owner/TTL/class of the original record, got %+v", a.Hdr)
+		}
+	}
+
+	// The rewritten message must still pack
+	if _, err := resp.Pack(); err != nil {
+		t.Errorf("Failed to pack rewritten response: %v", err)
+	}
+}
+
+func TestAnswerRewriter_IgnoresOtherDomainsAndTypes(t *testing.T) {
+	rewriter, err := NewAnswerRewriter(map[string][]string{
+		"other.com":   {"10.0.0.1"},
+		"example.com": {"2001:db8::1"},
+	})
+	if err != nil {
+		t.Fatalf("NewAnswerRewriter failed: %v", err)
+	}
+
+	resp := newForwardedResponse(t, "example.com. 60 IN A 1.2.3.4")
+	if rewriter.Rewrite(resp) {
+		t.Error("Expected A answer to be untouched when only IPv6 is pinned")
+	}
+	if a := resp.Answer[0].(*dns.A); a.A.String() != "1.2.3.4" {
+		t.Errorf("Expected original answer, got %s", a.A)
+	}
+}
+
+func TestNewAnswerRewriter_InvalidIP(t *testing.T) {
+	if _, err := NewAnswerRewriter(map[string][]string{"example.com": {"not-an-ip"}}); err == nil {
+		t.Error("Expected error for invalid IP")
+	}
+}
+
+func TestDNSServer_RewriteForwardedAnswer(t *testing.T) {
+	server, resolver := newDoHTestServer()
+	rewriter, err := NewAnswerRewriter(map[string][]string{"example.com": {"10.0.0.1"}})
+	if err != nil {
+		t.Fatalf("NewAnswerRewriter failed: %v", err)
+	}
+	server.SetRewriter(rewriter)
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	for range 2 {
+		resp, err := server.query(query)
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		if len(resp.Answer) != 1 {
+			t.Fatalf("Expected 1 answer, got %d", len(resp.Answer))
+		}
+		a := resp.Answer[0].(*dns.A)
+		if a.A.String() != "10.0.0.1" {
+			t.Errorf("Expected pinned 10.0.0.1, got %s", a.A)
+		}
+	}
+	// Second query is served from the cache with the rewritten answer
+	if resolver.calls != 1 {
+		t.Errorf("Expected 1 upstream call, got %d", resolver.calls)
+	}
+}
@@ -5,60 +5,154 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
+// QueryResolver resolves a DNS question, implemented by DNSSplitter
+type QueryResolver interface {
+	SplitQuery(ctx context.Context, question *dns.Msg) (*dns.Msg, error)
+}
+
 // DNSServer handles DNS requests with splitting and caching
 type DNSServer struct {
 	addr           string
-	splitter       *DNSSplitter
+	resolver       QueryResolver
 	cache          *DNSCache
+	rewriter       *AnswerRewriter
+	minimize       bool             // Strip additional and authority records from forwarded responses
+	allowlist      *DomainAllowlist // Only these domains are resolved, nil = all
+	deniedQueries  atomic.Uint64    // Queries answered NXDOMAIN by the allowlist
+	enableUDP      bool
+	enableTCP      bool
 	serverUDP      *dns.Server
+	serverTCP      *dns.Server
 	wg             sync.WaitGroup
 	ctx            context.Context
 	cancel         context.CancelFunc
 	statsCollector *DNSStatsCollector
+	inflightMu     sync.Mutex
+	inflight       map[string]*inflightQuery // identical cache misses share one upstream query
+}
+
+// inflightQuery is an upstream resolution awaited by every identical concurrent query
+type inflightQuery struct {
+	done chan struct{}
+	resp *dns.Msg
+	err  error
 }
 
 // NewDNSServer creates a new DNS server
 func NewDNSServer(addr string, splitter *DNSSplitter, cache *DNSCache) *DNSServer {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &DNSServer{
+	s := &DNSServer{
 		addr:           addr,
-		splitter:       splitter,
 		cache:          cache,
+		enableUDP:      true,
+		enableTCP:      true,
 		ctx:            ctx,
 		cancel:         cancel,
 		statsCollector: NewDNSStatsCollector(),
+		inflight:       make(map[string]*inflightQuery),
 	}
+	// Avoid storing a typed nil pointer in the interface
+	if splitter != nil {
+		s.resolver = splitter
+	}
+	return s
+}
+
+// SetProtocols toggles the UDP and TCP listeners, must be called before Start
+func (s *DNSServer) SetProtocols(udp, tcp bool) {
+	s.enableUDP = udp
+	s.enableTCP = tcp
+}
+
+// SetAllowlist restricts resolution to allowlisted domains, others are answered with NXDOMAIN
+func (s *DNSServer) SetAllowlist(allowlist *DomainAllowlist) {
+	s.allowlist = allowlist
+}
+
+// SetStatsMaxDomains caps the number of domains kept in query statistics
+func (s *DNSServer) SetStatsMaxDomains(n int) {
+	s.statsCollector.SetMaxDomains(n)
+}
+
+// SetRewriter sets the rewriter applied to resolved answers before caching
+func (s *DNSServer) SetRewriter(rewriter *AnswerRewriter) {
+	s.rewriter = rewriter
 }
 
-// Start starts the DNS server (UDP only for transparent proxy)
+// SetMinimizeResponses toggles stripping of additional and authority records from forwarded responses
+func (s *DNSServer) SetMinimizeResponses(enabled bool) {
+	s.minimize = enabled
+}
+
+// Start starts the DNS server on UDP and/or TCP with a shared handler
 func (s *DNSServer) Start() error {
+	if !s.enableUDP && !s.enableTCP {
+		return fmt.Errorf("DNS server has neither UDP nor TCP enabled")
+	}
 
-	// Create UDP handler
-	udpHandler := s.handleDNS
-	dns.HandleFunc(".", udpHandler)
+	dns.HandleFunc(".", s.handleDNS)
+	handler := dns.HandlerFunc(s.handleDNS)
 
-	// Create UDP server
-	s.serverUDP = &dns.Server{
-		Addr:    s.addr,
-		Net:     "udp",
-		Handler: dns.HandlerFunc(udpHandler),
+	// Bind listeners synchronously so address conflicts are reported to the caller
+	addr := s.addr
+	if s.enableTCP {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on tcp %s: %w", addr, err)
+		}
+		// Reuse the bound address so UDP gets the same port when port 0 is configured
+		addr = listener.Addr().String()
+		s.serverTCP = &dns.Server{
+			Listener: listener,
+			Net:      "tcp",
+			Handler:  handler,
+		}
 	}
 
-	// Start UDP server
-	s.wg.Go(func() {
-		if err := s.serverUDP.ListenAndServe(); err != nil {
-			slog.Error("UDP server error", "error", err)
+	if s.enableUDP {
+		packetConn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			if s.serverTCP != nil {
+				s.serverTCP.Listener.Close()
+				s.serverTCP = nil
+			}
+			return fmt.Errorf("failed to listen on udp %s: %w", addr, err)
+		}
+		addr = packetConn.LocalAddr().String()
+		s.serverUDP = &dns.Server{
+			PacketConn: packetConn,
+			Net:        "udp",
+			Handler:    handler,
 		}
-	})
+	}
+	s.addr = addr
+
+	if s.serverUDP != nil {
+		s.wg.Go(func() {
+			if err := s.serverUDP.ActivateAndServe(); err != nil {
+				slog.Error("UDP server error", "error", err)
+			}
+		})
+	}
+
+	if s.serverTCP != nil {
+		s.wg.Go(func() {
+			if err := s.serverTCP.ActivateAndServe(); err != nil {
+				slog.Error("TCP server error", "error", err)
+			}
+		})
+	}
 
 	clearDNSCache()
-	slog.Info("DNS server started", "address", s.addr, "mode", "UDP only (transparent proxy)")
+	slog.Info("DNS server started", "address", s.addr, "udp", s.serverUDP != nil, "tcp", s.serverTCP != nil)
 	return nil
 }
 
@@ -70,8 +164,8 @@ func (s *DNSServer) Stop() {
 		s.serverUDP.Shutdown()
 	}
 
-	if s.statsCollector != nil {
-		s.statsCollector.Shutdown()
+	if s.serverTCP != nil {
+		s.serverTCP.Shutdown()
 	}
 
 	done := make(chan struct{})
@@ -86,10 +180,38 @@ func (s *DNSServer) Stop() {
 	case <-time.After(10 * time.Second):
 		slog.Warn("DNS server stop timeout")
 	}
+
+	// Flush stats after in-flight queries have recorded theirs
+	if s.statsCollector != nil {
+		s.statsCollector.Shutdown()
+	}
 }
 
 // handleDNS handles DNS requests
 func (s *DNSServer) handleDNS(w dns.ResponseWriter, r *dns.Msg) {
+	if len(r.Question) == 0 {
+		return
+	}
+
+	resp, err := s.query(r)
+	if err != nil {
+		dns.HandleFailed(w, r)
+		return
+	}
+
+	// Upstream answers can exceed what the client accepts over UDP, set TC so it retries over TCP
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		size := dns.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil {
+			size = max(int(opt.UDPSize()), dns.MinMsgSize)
+		}
+		resp.Truncate(size)
+	}
+	w.WriteMsg(resp)
+}
+
+// query resolves r through the cache and resolver, shared by all transports
+func (s *DNSServer) query(r *dns.Msg) (*dns.Msg, error) {
 	startTime := time.Now()
 
 	var queryRecord *QueryRecord
@@ -101,12 +223,21 @@ func (s *DNSServer) handleDNS(w dns.ResponseWriter, r *dns.Msg) {
 	}()
 
 	if len(r.Question) == 0 {
-		return
+		return nil, fmt.Errorf("empty question")
 	}
 
 	domain := r.Question[0].Name
 	queryType := QueryType(dns.TypeToString[r.Question[0].Qtype])
 
+	// Denied names never reach the cache or upstreams, and are counted apart from domain stats
+	if s.allowlist != nil && !s.allowlist.Allowed(domain) {
+		s.deniedQueries.Add(1)
+		slog.Debug("DNS query denied by allowlist", "domain", domain)
+		resp := new(dns.Msg)
+		resp.SetRcode(r, dns.RcodeNameError)
+		return resp, nil
+	}
+
 	queryRecord = &QueryRecord{
 		Domain:    domain,
 		QueryType: queryType,
@@ -121,34 +252,31 @@ func (s *DNSServer) handleDNS(w dns.ResponseWriter, r *dns.Msg) {
 		if cached := s.cache.Get(r); cached != nil {
 			cached.SetReply(r)
 			queryRecord.Success = true
-			w.WriteMsg(cached)
-			return
+			return cached, nil
 		}
 	}
 
-	var resp *dns.Msg
-	var err error
-
-	if s.splitter != nil {
-		// Use DNSSplitter for intelligent DNS splitting
-		resp, err = s.splitter.SplitQuery(ctx, r)
-	} else {
-		// Use system default DNS resolver
-		resp, err = s.resolveWithSystemDNS(ctx, r)
-	}
+	resp, err := s.resolveShared(ctx, r)
 
 	if err != nil {
 		slog.Error("DNS query error", "domain", domain, "error", err)
 		queryRecord.Success = false
-		dns.HandleFailed(w, r)
-		return
+		return nil, err
 	}
 
 	if resp == nil {
 		slog.Debug("DNS query returned nil response", "domain", domain)
 		queryRecord.Success = false
-		dns.HandleFailed(w, r)
-		return
+		return nil, fmt.Errorf("nil response for %s", domain)
+	}
+
+	// Pin answers of rewritten domains, cached as-is so later hits stay consistent
+	if s.rewriter != nil && s.rewriter.Rewrite(resp) {
+		slog.Debug("DNS answer rewritten", "domain", domain)
+	}
+
+	if s.minimize {
+		minimizeResponse(resp)
 	}
 
 	// Cache the response if cache is not nil
@@ -156,7 +284,105 @@ func (s *DNSServer) handleDNS(w dns.ResponseWriter, r *dns.Msg) {
 		s.cache.Set(r, resp)
 	}
 	queryRecord.Success = true
-	w.WriteMsg(resp)
+	return resp, nil
+}
+
+// minimizeResponse drops the additional and authority sections, keeping the EDNS OPT record
+// and, for answers without records, the SOA that negative caching relies on
+func minimizeResponse(resp *dns.Msg) {
+	var extra []dns.RR
+	for _, rr := range resp.Extra {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	resp.Extra = extra
+
+	var ns []dns.RR
+	if len(resp.Answer) == 0 {
+		for _, rr := range resp.Ns {
+			if rr.Header().Rrtype == dns.TypeSOA {
+				ns = append(ns, rr)
+			}
+		}
+	}
+	resp.Ns = ns
+}
+
+// upstreamQueryTimeout bounds a shared upstream query, independent of the callers waiting on it
+const upstreamQueryTimeout = 10 * time.Second
+
+// resolveShared resolves r upstream, joining an identical query already in flight instead of
+// sending another one. Every caller gets its own copy of the response carrying its query ID.
+func (s *DNSServer) resolveShared(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	key := coalesceKey(r)
+
+	s.inflightMu.Lock()
+	call, shared := s.inflight[key]
+	if !shared {
+		call = &inflightQuery{done: make(chan struct{})}
+		s.inflight[key] = call
+	}
+	s.inflightMu.Unlock()
+
+	if shared {
+		s.statsCollector.RecordCoalesced()
+	} else {
+		s.statsCollector.RecordUpstreamSent()
+		// The query outlives the caller that started it, so the others still get the answer
+		// when that caller gives up first
+		query := r.Copy()
+		s.wg.Go(func() { s.resolveUpstream(key, call, query) })
+	}
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if call.err != nil || call.resp == nil {
+		return call.resp, call.err
+	}
+	resp := call.resp.Copy()
+	resp.Id = r.Id
+	return resp, nil
+}
+
+// resolveUpstream runs the upstream query of call and wakes every caller waiting on it
+func (s *DNSServer) resolveUpstream(key string, call *inflightQuery, r *dns.Msg) {
+	ctx, cancel := context.WithTimeout(s.ctx, upstreamQueryTimeout)
+	defer cancel()
+
+	if s.resolver != nil {
+		// Use DNSSplitter for intelligent DNS splitting
+		call.resp, call.err = s.resolver.SplitQuery(ctx, r)
+	} else {
+		// Use system default DNS resolver
+		call.resp, call.err = s.resolveWithSystemDNS(ctx, r)
+	}
+	s.inflightMu.Lock()
+	delete(s.inflight, key)
+	s.inflightMu.Unlock()
+	close(call.done)
+}
+
+// coalesceKey identifies queries that can share one upstream answer: the question, the CD flag
+// and the EDNS fields that change the answer, the DNSSEC OK bit and the client subnet
+func coalesceKey(r *dns.Msg) string {
+	q := r.Question[0]
+	key := fmt.Sprintf("%s/%d/%d/cd=%t", strings.ToLower(q.Name), q.Qtype, q.Qclass, r.CheckingDisabled)
+	opt := r.IsEdns0()
+	if opt == nil {
+		return key
+	}
+	key += fmt.Sprintf("/do=%t", opt.Do())
+	for _, option := range opt.Option {
+		if subnet, ok := option.(*dns.EDNS0_SUBNET); ok {
+			key += fmt.Sprintf("/ecs=%s/%d", subnet.Address, subnet.SourceNetmask)
+		}
+	}
+	return key
 }
 
 // resolveWithSystemDNS resolves DNS using the system's default resolver
@@ -205,6 +431,11 @@ func (s *DNSServer) IsRunning() bool {
 	return s.ctx.Err() == nil
 }
 
+// IsListening checks if the server is running with at least one bound listener
+func (s *DNSServer) IsListening() bool {
+	return s.IsRunning() && (s.serverUDP != nil || s.serverTCP != nil)
+}
+
 // HealthCheck performs a health check on the DNS server
 func (s *DNSServer) HealthCheck() error {
 	if !s.IsRunning() {
@@ -266,7 +497,10 @@ func (s *DNSServer) GetCacheStats() map[string]interface{} {
 			"total_success":     statsStats.TotalSuccess,
 			"total_failed":      statsStats.TotalFailed,
 			"success_rate":      statsStats.SuccessRate,
+			"denied_queries":    s.deniedQueries.Load(),
 			"avg_response_time": statsStats.AvgResponseTime.String(),
+			"coalesced":         statsStats.Coalesced,
+			"upstream_sent":     statsStats.UpstreamSent,
 			"top_domains":       domains,
 		},
 	}
@@ -275,6 +509,7 @@ func (s *DNSServer) GetCacheStats() map[string]interface{} {
 // ClearStats clears all DNS statistics
 func (s *DNSServer) ClearStats() {
 	s.statsCollector.ClearStats()
+	s.deniedQueries.Store(0)
 }
 
 // ClearCache clears the DNS cache
@@ -0,0 +1,376 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func newCachedTestServer(t *testing.T) (*DNSServer, *dns.Msg) {
+	t.Helper()
+
+	cache := NewDNSCache(5*time.Minute, 100)
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	resp := new(dns.Msg)
+	rr, _ := dns.NewRR("example.com. 300 IN A 1.2.3.4")
+	resp.Answer = append(resp.Answer, rr)
+	cache.Set(query, resp)
+
+	return NewDNSServer("127.0.0.1:0", nil, cache), query
+}
+
+func TestDNSServer_UDPAndTCP(t *testing.T) {
+	server, query := newCachedTestServer(t)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start DNS server: %v", err)
+	}
+	defer server.Stop()
+
+	var answers []string
+	for _, network := range []string{"udp", "tcp"} {
+		client := &dns.Client{Net: network, Timeout: 2 * time.Second}
+		resp, _, err := client.Exchange(query, server.GetAddr())
+		if err != nil {
+			t.Fatalf("%s query failed: %v", network, err)
+		}
+		if len(resp.Answer) != 1 {
+			t.Fatalf("%s: expected 1 answer, got %d", network, len(resp.Answer))
+		}
+		a, ok := resp.Answer[0].(*dns.A)
+		if !ok {
+			t.Fatalf("%s: expected A record, got %T", network, resp.Answer[0])
+		}
+		answers = append(answers, a.A.String())
+	}
+
+	if answers[0] != answers[1] {
+		t.Errorf("Expected consistent answers, got udp=%s tcp=%s", answers[0], answers[1])
+	}
+	if answers[0] != "1.2.3.4" {
+		t.Errorf("Expected 1.2.3.4, got %s", answers[0])
+	}
+}
+
+func TestDNSServer_TruncatesUDPToClientSize(t *testing.T) {
+	cache := NewDNSCache(5*time.Minute, 100)
+
+	query := new(dns.Msg)
+	query.SetQuestion("large.example.com.", dns.TypeA)
+
+	resp := new(dns.Msg)
+	for i := range 60 {
+		rr, _ := dns.NewRR(fmt.Sprintf("large.example.com. 300 IN A 10.0.0.%d", i+1))
+		resp.Answer = append(resp.Answer, rr)
+	}
+	cache.Set(query, resp)
+
+	server := NewDNSServer("127.0.0.1:0", nil, cache)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start DNS server: %v", err)
+	}
+	defer server.Stop()
+
+	client := &dns.Client{Net: "udp", Timeout: 2 * time.Second}
+	reply, _, err := client.Exchange(query, server.GetAddr())
+	if err != nil {
+		t.Fatalf("UDP query without EDNS failed: %v", err)
+	}
+	if !reply.Truncated || len(reply.Answer) >= 60 {
+		t.Errorf("Expected a truncated reply with TC set, got %d answers (TC=%v)", len(reply.Answer), reply.Truncated)
+	}
+
+	query.SetEdns0(4096, false)
+	reply, _, err = client.Exchange(query, server.GetAddr())
+	if err != nil {
+		t.Fatalf("UDP query with EDNS failed: %v", err)
+	}
+	if reply.Truncated || len(reply.Answer) != 60 {
+		t.Errorf("Expected all 60 answers untruncated, got %d (TC=%v)", len(reply.Answer), reply.Truncated)
+	}
+
+	tcp := &dns.Client{Net: "tcp", Timeout: 2 * time.Second}
+	query.Extra = nil
+	reply, _, err = tcp.Exchange(query, server.GetAddr())
+	if err != nil {
+		t.Fatalf("TCP query failed: %v", err)
+	}
+	if reply.Truncated || len(reply.Answer) != 60 {
+		t.Errorf("Expected all 60 answers over TCP, got %d (TC=%v)", len(reply.Answer), reply.Truncated)
+	}
+}
+
+func TestDNSServer_TCPDisabled(t *testing.T) {
+	server, query := newCachedTestServer(t)
+	server.SetProtocols(true, false)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start DNS server: %v", err)
+	}
+	defer server.Stop()
+
+	client := &dns.Client{Net: "udp", Timeout: 2 * time.Second}
+	if _, _, err := client.Exchange(query, server.GetAddr()); err != nil {
+		t.Fatalf("udp query failed: %v", err)
+	}
+
+	client = &dns.Client{Net: "tcp", Timeout: 2 * time.Second}
+	if _, _, err := client.Exchange(query, server.GetAddr()); err == nil {
+		t.Error("Expected tcp query to fail when TCP is disabled")
+	}
+}
+
+func TestDNSServer_NoProtocols(t *testing.T) {
+	server, _ := newCachedTestServer(t)
+	server.SetProtocols(false, false)
+	if err := server.Start(); err == nil {
+		server.Stop()
+		t.Error("Expected error when both UDP and TCP are disabled")
+	}
+}
+
+func TestDNSServer_Allowlist(t *testing.T) {
+	server, query := newCachedTestServer(t)
+	defer server.statsCollector.Shutdown()
+	allowlist, err := NewDomainAllowlist([]string{"example.com"})
+	if err != nil {
+		t.Fatalf("NewDomainAllowlist failed: %v", err)
+	}
+	server.SetAllowlist(allowlist)
+
+	resp, err := server.query(query)
+	if err != nil {
+		t.Fatalf("Allowlisted query failed: %v", err)
+	}
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Errorf("Expected allowlisted name to resolve, got rcode %s with %d answers", dns.RcodeToString[resp.Rcode], len(resp.Answer))
+	}
+
+	denied := new(dns.Msg)
+	denied.SetQuestion("tracker.example.org.", dns.TypeA)
+	resp, err = server.query(denied)
+	if err != nil {
+		t.Fatalf("Denied query failed: %v", err)
+	}
+	if resp.Rcode != dns.RcodeNameError || len(resp.Answer) != 0 {
+		t.Errorf("Expected NXDOMAIN for non-listed name, got rcode %s with %d answers", dns.RcodeToString[resp.Rcode], len(resp.Answer))
+	}
+	if resp.Id != denied.Id || len(resp.Question) != 1 {
+		t.Error("Expected NXDOMAIN reply to echo the query")
+	}
+
+	stats := server.GetCacheStats()["dns"].(map[string]interface{})
+	if got := stats["denied_queries"]; got != uint64(1) {
+		t.Errorf("Expected 1 denied query, got %v", got)
+	}
+}
+
+// blockingResolver answers every query with 1.2.3.4 once released
+type blockingResolver struct {
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (b *blockingResolver) SplitQuery(ctx context.Context, question *dns.Msg) (*dns.Msg, error) {
+	b.calls.Add(1)
+	<-b.release
+	resp := new(dns.Msg)
+	resp.SetReply(question)
+	rr, _ := dns.NewRR(question.Question[0].Name + " 300 IN A 1.2.3.4")
+	resp.Answer = append(resp.Answer, rr)
+	return resp, nil
+}
+
+func TestDNSServer_CoalescesConcurrentQueries(t *testing.T) {
+	resolver := &blockingResolver{release: make(chan struct{})}
+	server := NewDNSServer("127.0.0.1:0", nil, nil)
+	server.resolver = resolver
+	defer server.statsCollector.Shutdown()
+
+	const n = 5
+	var wg sync.WaitGroup
+	responses := make([]*dns.Msg, n)
+	queries := make([]*dns.Msg, n)
+	for i := range n {
+		queries[i] = new(dns.Msg)
+		queries[i].SetQuestion("Example.com.", dns.TypeA)
+		wg.Go(func() {
+			resp, err := server.query(queries[i])
+			if err != nil {
+				t.Errorf("Query %d failed: %v", i, err)
+			}
+			responses[i] = resp
+		})
+	}
+
+	// Release the upstream once every query is waiting on it
+	deadline := time.Now().Add(2 * time.Second)
+	for server.statsCollector.GetStatsSummary().Coalesced < n-1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(resolver.release)
+	wg.Wait()
+
+	if got := resolver.calls.Load(); got != 1 {
+		t.Errorf("Expected a single upstream query, got %d", got)
+	}
+	for i, resp := range responses {
+		if resp == nil || len(resp.Answer) != 1 {
+			t.Fatalf("Query %d: expected 1 answer, got %v", i, resp)
+		}
+		if resp.Id != queries[i].Id {
+			t.Errorf("Query %d: expected reply ID %d, got %d", i, queries[i].Id, resp.Id)
+		}
+	}
+
+	stats := server.GetCacheStats()["dns"].(map[string]interface{})
+	if got := stats["coalesced"]; got != uint64(n-1) {
+		t.Errorf("Expected %d coalesced queries, got %v", n-1, got)
+	}
+	if got := stats["upstream_sent"]; got != uint64(1) {
+		t.Errorf("Expected 1 upstream query, got %v", got)
+	}
+}
+
+func TestDNSServer_CoalesceKeySeparatesEDNS(t *testing.T) {
+	plain := new(dns.Msg)
+	plain.SetQuestion("example.com.", dns.TypeA)
+	dnssec := plain.Copy()
+	dnssec.SetEdns0(1232, true)
+	subnet := plain.Copy()
+	subnet.SetEdns0(1232, false)
+	opt := subnet.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: []byte{203, 0, 113, 0}})
+	otherSubnet := subnet.Copy()
+	otherSubnet.IsEdns0().Option[0].(*dns.EDNS0_SUBNET).Address = []byte{198, 51, 100, 0}
+
+	keys := make(map[string]bool)
+	for _, query := range []*dns.Msg{plain, dnssec, subnet, otherSubnet} {
+		keys[coalesceKey(query)] = true
+	}
+	if len(keys) != 4 {
+		t.Errorf("Expected distinct keys for the DO bit and each client subnet, got %v", keys)
+	}
+}
+
+func TestDNSServer_SharedQuerySurvivesLeaderCancel(t *testing.T) {
+	resolver := &blockingResolver{release: make(chan struct{})}
+	server := NewDNSServer("127.0.0.1:0", nil, nil)
+	server.resolver = resolver
+	defer server.statsCollector.Shutdown()
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	// The first caller gives up while the upstream query is still running
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderDone := make(chan error, 1)
+	go func() {
+		_, err := server.resolveShared(leaderCtx, query)
+		leaderDone <- err
+	}()
+	for resolver.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	follower := make(chan *dns.Msg, 1)
+	go func() {
+		resp, _ := server.resolveShared(context.Background(), query.Copy())
+		follower <- resp
+	}()
+	for server.statsCollector.GetStatsSummary().Coalesced < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancelLeader()
+	if err := <-leaderDone; err != context.Canceled {
+		t.Errorf("Expected the leader to return its own cancellation, got %v", err)
+	}
+	close(resolver.release)
+
+	select {
+	case resp := <-follower:
+		if resp == nil || len(resp.Answer) != 1 {
+			t.Errorf("Expected the follower to get the shared answer, got %v", resp)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the shared answer")
+	}
+}
+
+// staticResolver answers every query with a copy of resp
+type staticResolver struct {
+	resp *dns.Msg
+}
+
+func (s *staticResolver) SplitQuery(ctx context.Context, question *dns.Msg) (*dns.Msg, error) {
+	resp := s.resp.Copy()
+	resp.SetReply(question)
+	return resp, nil
+}
+
+func TestDNSServer_MinimizeResponses(t *testing.T) {
+	answer, _ := dns.NewRR("example.com. 300 IN A 1.2.3.4")
+	ns, _ := dns.NewRR("example.com. 300 IN NS ns1.example.com.")
+	glue, _ := dns.NewRR("ns1.example.com. 300 IN A 5.6.7.8")
+	upstream := new(dns.Msg)
+	upstream.Answer = []dns.RR{answer}
+	upstream.Ns = []dns.RR{ns}
+	upstream.Extra = []dns.RR{glue}
+	upstream.SetEdns0(1232, false)
+
+	for _, minimize := range []bool{false, true} {
+		server := NewDNSServer("127.0.0.1:0", nil, nil)
+		server.resolver = &staticResolver{resp: upstream}
+		server.SetMinimizeResponses(minimize)
+
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", dns.TypeA)
+		resp, err := server.query(query)
+		server.statsCollector.Shutdown()
+		if err != nil {
+			t.Fatalf("minimize=%v: query failed: %v", minimize, err)
+		}
+
+		if len(resp.Answer) != 1 {
+			t.Errorf("minimize=%v: expected 1 answer, got %d", minimize, len(resp.Answer))
+		}
+		if resp.IsEdns0() == nil {
+			t.Errorf("minimize=%v: expected OPT record to be kept", minimize)
+		}
+		wantNs, wantExtra := 1, 2
+		if minimize {
+			wantNs, wantExtra = 0, 1
+		}
+		if len(resp.Ns) != wantNs {
+			t.Errorf("minimize=%v: expected %d authority records, got %d", minimize, wantNs, len(resp.Ns))
+		}
+		if len(resp.Extra) != wantExtra {
+			t.Errorf("minimize=%v: expected %d additional records, got %d", minimize, wantExtra, len(resp.Extra))
+		}
+	}
+}
+
+func TestMinimizeResponse_KeepsSOAForNegativeAnswer(t *testing.T) {
+	resp := new(dns.Msg)
+	resp.Rcode = dns.RcodeNameError
+	soa, _ := dns.NewRR("example.com. 300 IN SOA ns1.example.com. admin.example.com. 1 7200 3600 1209600 300")
+	ns, _ := dns.NewRR("example.com. 300 IN NS ns1.example.com.")
+	glue, _ := dns.NewRR("ns1.example.com. 300 IN A 5.6.7.8")
+	resp.Ns = []dns.RR{ns, soa}
+	resp.Extra = []dns.RR{glue}
+
+	minimizeResponse(resp)
+
+	if len(resp.Ns) != 1 || resp.Ns[0].Header().Rrtype != dns.TypeSOA {
+		t.Errorf("Expected only the SOA record in authority, got %v", resp.Ns)
+	}
+	if len(resp.Extra) != 0 {
+		t.Errorf("Expected no additional records, got %v", resp.Extra)
+	}
+}
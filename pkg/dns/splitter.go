@@ -2,12 +2,14 @@ package dns
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
+	"slices"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/miekg/dns"
 	"github.com/monsterxx03/linko/pkg/ipdb"
@@ -18,20 +20,103 @@ import (
 type DNSSplitter struct {
 	domestic         []string
 	foreign          []string
-	useTCPForForeign bool
+	domesticProtocol string // ProtocolUDP, ProtocolTCP, ProtocolDoH or ProtocolDoT
+	foreignProtocol  string
 	upstream         *proxy.UpstreamClient
 	client           *dns.Client
+	dohDirect        *http.Client
+	dohProxied       *http.Client // dials through upstream, used for foreign DoH servers
+	tlsConfig        *tls.Config  // base TLS config for DoT/DoH, nil = system roots
+	ednsBufferSize   uint16       // UDP payload size advertised in outgoing queries, 0 = no EDNS0
+	ednsDO           bool         // set the DNSSEC OK bit in outgoing queries
+	fallback         bool         // retry via the other upstream when the chosen one fails
+	domesticBind     net.IP       // source address of domestic queries, nil = OS default
+	foreignBind      net.IP       // source address of direct foreign queries, nil = OS default
 }
 
-// NewDNSSplitter creates a new DNS splitter
+// NewDNSSplitter creates a new DNS splitter, useTCPForForeign selects ProtocolTCP for foreign
+// servers and is kept for compatibility, use SetProtocols to choose any protocol
 func NewDNSSplitter(domesticDNS, foreignDNS []string, useTCPForForeign bool, upstream *proxy.UpstreamClient) *DNSSplitter {
-	return &DNSSplitter{
+	foreignProtocol := ProtocolUDP
+	if useTCPForForeign {
+		foreignProtocol = ProtocolTCP
+	}
+	s := &DNSSplitter{
 		domestic:         domesticDNS,
 		foreign:          foreignDNS,
-		useTCPForForeign: useTCPForForeign,
+		domesticProtocol: ProtocolUDP,
+		foreignProtocol:  foreignProtocol,
 		upstream:         upstream,
-		client:           &dns.Client{Timeout: 5 * time.Second},
+		client:           &dns.Client{Timeout: upstreamTimeout},
+		fallback:         true,
+	}
+	s.dohDirect = s.newDoHClient(false)
+	s.dohProxied = s.newDoHClient(true)
+	return s
+}
+
+// SetProtocols sets the protocol used for domestic and foreign servers, empty keeps the current one
+func (s *DNSSplitter) SetProtocols(domestic, foreign string) error {
+	for _, p := range []string{domestic, foreign} {
+		if p != "" && !validProtocol(p) {
+			return fmt.Errorf("unknown DNS protocol %q, want udp, tcp, doh or dot", p)
+		}
+	}
+	if domestic != "" {
+		s.domesticProtocol = domestic
+	}
+	if foreign != "" {
+		s.foreignProtocol = foreign
+	}
+	return nil
+}
+
+// SetBindAddrs sets the source of domestic and foreign queries, each an IP address or a network
+// interface name whose address is used, empty keeps the OS default
+func (s *DNSSplitter) SetBindAddrs(domestic, foreign string) error {
+	domesticIP, err := resolveBindAddr(domestic)
+	if err != nil {
+		return fmt.Errorf("invalid domestic DNS bind: %w", err)
+	}
+	foreignIP, err := resolveBindAddr(foreign)
+	if err != nil {
+		return fmt.Errorf("invalid foreign DNS bind: %w", err)
+	}
+	s.domesticBind = domesticIP
+	s.foreignBind = foreignIP
+	return nil
+}
+
+// SetFallback sets whether the domestic answer is used when the foreign query fails (SERVFAIL,
+// network error, timeout). A failed domestic query always moves on to the foreign upstream.
+func (s *DNSSplitter) SetFallback(enable bool) {
+	s.fallback = enable
+}
+
+// SetEDNS sets the EDNS0 UDP payload size and DO bit advertised to upstream DNS servers
+func (s *DNSSplitter) SetEDNS(bufferSize uint16, dnssecOK bool) {
+	s.ednsBufferSize = bufferSize
+	s.ednsDO = dnssecOK
+}
+
+// withEDNS returns a copy of msg carrying an OPT record with the configured buffer size and DO bit
+func (s *DNSSplitter) withEDNS(msg *dns.Msg) *dns.Msg {
+	if s.ednsBufferSize == 0 && !s.ednsDO {
+		return msg
+	}
+	out := msg.Copy()
+	opt := out.IsEdns0()
+	if opt == nil {
+		out.SetEdns0(max(s.ednsBufferSize, dns.MinMsgSize), s.ednsDO)
+		return out
 	}
+	if opt.UDPSize() < s.ednsBufferSize {
+		opt.SetUDPSize(s.ednsBufferSize)
+	}
+	if s.ednsDO {
+		opt.SetDo()
+	}
+	return out
 }
 
 // SplitQuery splits a DNS query based on IP geolocation
@@ -43,24 +128,29 @@ func (s *DNSSplitter) SplitQuery(ctx context.Context, question *dns.Msg) (*dns.M
 	qname := question.Question[0].Name
 
 	// Query domestic DNS first
-	domesticResp, domesticErr := s.queryDNS(ctx, question, s.domestic, false)
-	if domesticErr == nil && domesticResp != nil {
+	domesticResp, domesticErr := s.queryDNS(ctx, question, s.domestic, s.domesticProtocol, false)
+	if domesticErr == nil {
 		// Check if response IPs are domestic
 		if s.areIPsDomestic(domesticResp) {
 			slog.Debug("using domestic DNS result", "qname", qname, "dns", s.domestic)
 			return domesticResp, nil
 		}
 		slog.Debug("domestic DNS returned foreign IPs, trying foreign DNS", "qname", qname)
+	} else {
+		slog.Debug("domestic DNS failed, trying foreign DNS", "qname", qname, "error", domesticErr)
 	}
 
 	// Query foreign DNS
-	foreignResp, foreignErr := s.queryDNS(ctx, question, s.foreign, s.useTCPForForeign)
+	foreignResp, foreignErr := s.queryDNS(ctx, question, s.foreign, s.foreignProtocol, true)
 	if foreignErr != nil {
 		// If foreign query failed, return domestic response if available
-		if domesticResp != nil {
+		if domesticResp != nil && s.fallback {
 			slog.Warn("foreign DNS failed, using domestic DNS result", "qname", qname, "error", foreignErr)
 			return domesticResp, nil
 		}
+		if domesticErr == nil {
+			return nil, fmt.Errorf("foreign DNS query failed: %w", foreignErr)
+		}
 		return nil, fmt.Errorf("both domestic and foreign DNS queries failed: domestic=%v, foreign=%v", domesticErr, foreignErr)
 	}
 
@@ -68,11 +158,13 @@ func (s *DNSSplitter) SplitQuery(ctx context.Context, question *dns.Msg) (*dns.M
 	return foreignResp, nil
 }
 
-// queryDNS sends a DNS query to the specified servers
-func (s *DNSSplitter) queryDNS(ctx context.Context, msg *dns.Msg, servers []string, useTCP bool) (*dns.Msg, error) {
+// queryDNS sends a DNS query to the specified servers over protocol, proxied queries
+// dial through the upstream proxy when it is enabled
+func (s *DNSSplitter) queryDNS(ctx context.Context, msg *dns.Msg, servers []string, protocol string, proxied bool) (*dns.Msg, error) {
 	var lastErr error
 	var response *dns.Msg
 
+	query := s.withEDNS(msg)
 	for _, server := range servers {
 		select {
 		case <-ctx.Done():
@@ -80,56 +172,99 @@ func (s *DNSSplitter) queryDNS(ctx context.Context, msg *dns.Msg, servers []stri
 		default:
 		}
 
-		var resp *dns.Msg
-		var err error
-
-		if useTCP && s.upstream != nil && s.upstream.IsEnabled() {
-			conn, err := s.upstream.Connect(server, 53)
-			if err != nil {
-				lastErr = err
-				continue
-			}
-			client := &dns.Client{Net: "tcp"}
-			resp, _, err = client.ExchangeWithConn(msg, &dns.Conn{Conn: conn})
-			conn.Close()
-		} else if useTCP {
-			client := &dns.Client{Net: "tcp", Timeout: 5 * time.Second}
-			resp, _, err = client.Exchange(msg, net.JoinHostPort(server, "53"))
-		} else {
-			resp, _, err = s.client.Exchange(msg, net.JoinHostPort(server, "53"))
-		}
-
+		resp, err := s.exchange(ctx, query, server, protocol, proxied)
 		if err != nil {
 			lastErr = err
 			continue
 		}
 
-		if resp != nil && resp.Rcode == dns.RcodeSuccess {
-			response = resp
-			break
+		// NXDOMAIN, NODATA and REFUSED are answers, only SERVFAIL moves on to the next server
+		if resp.Rcode == dns.RcodeServerFailure {
+			lastErr = fmt.Errorf("%s returned %s", server, dns.RcodeToString[resp.Rcode])
+			continue
 		}
+
+		response = resp
+		break
 	}
 
 	if response == nil {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no DNS servers configured")
+		}
 		return nil, lastErr
 	}
 
+	// Don't hand an OPT record back to clients that didn't send one
+	if query != msg && msg.IsEdns0() == nil {
+		response.Extra = slices.DeleteFunc(response.Extra, func(rr dns.RR) bool {
+			return rr.Header().Rrtype == dns.TypeOPT
+		})
+	}
+
 	return response, nil
 }
 
-// areIPsDomestic checks if all IPs in the response are domestic
+// serverAddr returns host:port of a DNS server, port 53 is used when not given
+func serverAddr(server string) string {
+	return serverAddrWithPort(server, "53")
+}
+
+// serverAddrWithPort returns host:port of a server, defaultPort is used when not given
+func serverAddrWithPort(server, defaultPort string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, defaultPort)
+}
+
+// areIPsDomestic reports whether the response resolves to a domestic address. Only the
+// addresses at the end of the CNAME chain count, so a domestic name aliased to a foreign
+// CDN is treated as foreign and vice versa.
 func (s *DNSSplitter) areIPsDomestic(resp *dns.Msg) bool {
-	for _, answer := range resp.Answer {
-		switch a := answer.(type) {
-		case *dns.A:
-			ipStr := a.A.String()
-			if ipdb.IsChinaIP(ipStr) {
-				return true
+	for _, ip := range terminalAddresses(resp) {
+		if ipdb.IsChinaIP(ip.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// terminalAddresses follows the CNAME chain from the question name through the answer
+// section and returns the A/AAAA addresses of the final name
+func terminalAddresses(resp *dns.Msg) []net.IP {
+	if len(resp.Question) == 0 {
+		return nil
+	}
+	name := resp.Question[0].Name
+	// Each hop consumes a CNAME, so the answer count bounds the chain and stops CNAME loops
+	for range len(resp.Answer) {
+		next := ""
+		for _, rr := range resp.Answer {
+			if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+				next = cname.Target
+				break
 			}
 		}
+		if next == "" {
+			break
+		}
+		name = next
 	}
 
-	return false
+	var ips []net.IP
+	for _, rr := range resp.Answer {
+		if !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		switch a := rr.(type) {
+		case *dns.A:
+			ips = append(ips, a.A)
+		case *dns.AAAA:
+			ips = append(ips, a.AAAA)
+		}
+	}
+	return ips
 }
 
 // SplitAndMerge splits queries and merges responses for multiple domains
@@ -2,7 +2,14 @@ package dns
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -74,3 +81,475 @@ func TestDNSSplitter_BatchSplitQuery(t *testing.T) {
 		t.Error("Expected responses")
 	}
 }
+
+func TestDNSSplitter_EDNSOnOutgoingQuery(t *testing.T) {
+	splitter := NewDNSSplitter([]string{"114.114.114.114"}, []string{"8.8.8.8"}, false, nil)
+	splitter.SetEDNS(1232, true)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+
+	query := splitter.withEDNS(msg)
+	opt := query.IsEdns0()
+	if opt == nil {
+		t.Fatal("Expected outgoing query to carry an OPT record")
+	}
+	if opt.UDPSize() != 1232 {
+		t.Errorf("Expected UDP size 1232, got %d", opt.UDPSize())
+	}
+	if !opt.Do() {
+		t.Error("Expected DO bit to be set")
+	}
+	if msg.IsEdns0() != nil {
+		t.Error("Expected original query to be left unmodified")
+	}
+}
+
+func TestDNSSplitter_EDNSKeepsLargerClientBuffer(t *testing.T) {
+	splitter := NewDNSSplitter([]string{"114.114.114.114"}, []string{"8.8.8.8"}, false, nil)
+	splitter.SetEDNS(1232, false)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	msg.SetEdns0(4096, false)
+
+	opt := splitter.withEDNS(msg).IsEdns0()
+	if opt == nil || opt.UDPSize() != 4096 {
+		t.Fatalf("Expected client's 4096 buffer size to be kept, got %v", opt)
+	}
+	if opt.Do() {
+		t.Error("Expected DO bit to stay unset")
+	}
+}
+
+func TestDNSSplitter_EDNSDisabled(t *testing.T) {
+	splitter := NewDNSSplitter([]string{"114.114.114.114"}, []string{"8.8.8.8"}, false, nil)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+
+	if splitter.withEDNS(msg).IsEdns0() != nil {
+		t.Error("Expected no OPT record when EDNS is not configured")
+	}
+}
+
+// startTestDNSServer serves UDP DNS on a random local port, answering every query with handler
+func startTestDNSServer(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestDNSSplitter_FallbackOnServfail(t *testing.T) {
+	domestic := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+	})
+	foreign := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("93.184.216.34"),
+		})
+		w.WriteMsg(m)
+	})
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	splitter := NewDNSSplitter([]string{domestic}, []string{foreign}, false, nil)
+	resp, err := splitter.SplitQuery(ctx, msg)
+	if err != nil {
+		t.Fatalf("Expected fallback to foreign DNS, got error: %v", err)
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "93.184.216.34" {
+		t.Errorf("Expected foreign answer, got %v", resp.Answer)
+	}
+
+	// A failed domestic query moves on to the foreign upstream regardless of fallback
+	splitter.SetFallback(false)
+	if _, err := splitter.SplitQuery(ctx, msg); err != nil {
+		t.Errorf("Expected foreign answer with fallback disabled, got error: %v", err)
+	}
+}
+
+func TestDNSSplitter_FallbackToDomesticAnswer(t *testing.T) {
+	domestic := startTestDNSServer(t, answerA)
+	foreign := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+	})
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	splitter := NewDNSSplitter([]string{domestic}, []string{foreign}, false, nil)
+	resp, err := splitter.SplitQuery(ctx, msg)
+	if err != nil {
+		t.Fatalf("Expected domestic answer, got error: %v", err)
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "5.6.7.8" {
+		t.Errorf("Expected domestic answer, got %v", resp.Answer)
+	}
+
+	splitter.SetFallback(false)
+	if _, err := splitter.SplitQuery(ctx, msg); err == nil {
+		t.Error("Expected foreign SERVFAIL error with fallback disabled")
+	}
+}
+
+func TestDNSSplitter_NXDOMAINIsAnAnswer(t *testing.T) {
+	var foreignQueries atomic.Int32
+	nxdomain := func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeNameError)
+		w.WriteMsg(m)
+	}
+	domestic := startTestDNSServer(t, nxdomain)
+	foreign := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		foreignQueries.Add(1)
+		nxdomain(w, r)
+	})
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("nonexistent.example.", dns.TypeA)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	splitter := NewDNSSplitter([]string{domestic}, []string{foreign}, false, nil)
+	resp, err := splitter.SplitQuery(ctx, msg)
+	if err != nil {
+		t.Fatalf("Expected NXDOMAIN answer, got error: %v", err)
+	}
+	if resp.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if n := foreignQueries.Load(); n != 1 {
+		t.Errorf("Expected 1 foreign query, got %d", n)
+	}
+}
+
+// answerA replies to every query with a single A record
+func answerA(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Answer = append(m.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("5.6.7.8"),
+	})
+	w.WriteMsg(m)
+}
+
+// startTestStreamDNSServer serves DNS over TCP, or over TLS when tlsConfig is set
+func startTestStreamDNSServer(t *testing.T, tlsConfig *tls.Config, handler dns.HandlerFunc) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	server := &dns.Server{Listener: ln, Handler: handler}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return ln.Addr().String()
+}
+
+// testTLSConfigs returns a server config with a certificate for 127.0.0.1 and a client config trusting it
+func testTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+	return &tls.Config{Certificates: ts.TLS.Certificates}, &tls.Config{RootCAs: roots}
+}
+
+func TestDNSSplitter_Protocols(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+
+	dohServer, _ := newDoHTestServer()
+	doh := httptest.NewTLSServer(http.HandlerFunc(dohServer.ServeDoH))
+	defer doh.Close()
+
+	tests := []struct {
+		protocol string
+		server   string
+	}{
+		{ProtocolUDP, startTestDNSServer(t, answerA)},
+		{ProtocolTCP, startTestStreamDNSServer(t, nil, answerA)},
+		{ProtocolDoT, startTestStreamDNSServer(t, serverTLS, answerA)},
+		{ProtocolDoH, doh.URL + "/dns-query"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.protocol, func(t *testing.T) {
+			splitter := NewDNSSplitter(nil, nil, false, nil)
+			splitter.tlsConfig = clientTLS
+			splitter.dohDirect = doh.Client()
+			splitter.dohProxied = doh.Client()
+
+			msg := new(dns.Msg)
+			msg.SetQuestion("example.com.", dns.TypeA)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// Both splits honor their own protocol
+			for _, proxied := range []bool{false, true} {
+				resp, err := splitter.queryDNS(ctx, msg, []string{tt.server}, tt.protocol, proxied)
+				if err != nil {
+					t.Fatalf("Query over %s failed: %v", tt.protocol, err)
+				}
+				if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "5.6.7.8" {
+					t.Errorf("Unexpected answer over %s: %v", tt.protocol, resp.Answer)
+				}
+			}
+		})
+	}
+}
+
+func TestDNSSplitter_SetProtocols(t *testing.T) {
+	splitter := NewDNSSplitter(nil, nil, true, nil)
+	if splitter.domesticProtocol != ProtocolUDP || splitter.foreignProtocol != ProtocolTCP {
+		t.Errorf("Expected udp/tcp from the TCP-for-foreign alias, got %s/%s", splitter.domesticProtocol, splitter.foreignProtocol)
+	}
+
+	if err := splitter.SetProtocols(ProtocolDoT, ProtocolDoH); err != nil {
+		t.Fatalf("SetProtocols failed: %v", err)
+	}
+	if splitter.domesticProtocol != ProtocolDoT || splitter.foreignProtocol != ProtocolDoH {
+		t.Errorf("Expected dot/doh, got %s/%s", splitter.domesticProtocol, splitter.foreignProtocol)
+	}
+
+	// Empty keeps the current protocol
+	if err := splitter.SetProtocols("", ProtocolUDP); err != nil {
+		t.Fatalf("SetProtocols failed: %v", err)
+	}
+	if splitter.domesticProtocol != ProtocolDoT || splitter.foreignProtocol != ProtocolUDP {
+		t.Errorf("Expected dot/udp, got %s/%s", splitter.domesticProtocol, splitter.foreignProtocol)
+	}
+
+	if err := splitter.SetProtocols("quic", ""); err == nil {
+		t.Error("Expected error for unknown protocol")
+	}
+}
+
+func TestDNSSplitter_SetBindAddrs(t *testing.T) {
+	splitter := NewDNSSplitter(nil, nil, false, nil)
+	if err := splitter.SetBindAddrs("127.0.0.1", ""); err != nil {
+		t.Fatalf("SetBindAddrs failed: %v", err)
+	}
+
+	udp, ok := splitter.dialer(ProtocolUDP, false).LocalAddr.(*net.UDPAddr)
+	if !ok || !udp.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Expected domestic UDP dialer bound to 127.0.0.1, got %v", splitter.dialer(ProtocolUDP, false).LocalAddr)
+	}
+	tcp, ok := splitter.dialer(ProtocolTCP, false).LocalAddr.(*net.TCPAddr)
+	if !ok || !tcp.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Expected domestic TCP dialer bound to 127.0.0.1, got %v", splitter.dialer(ProtocolTCP, false).LocalAddr)
+	}
+	if addr := splitter.dialer(ProtocolUDP, true).LocalAddr; addr != nil {
+		t.Errorf("Expected unbound foreign dialer, got %v", addr)
+	}
+
+	if err := splitter.SetBindAddrs("", "no-such-interface0"); err == nil {
+		t.Error("Expected error for unknown interface")
+	}
+}
+
+func TestDNSSplitter_BindInterface(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skipf("Failed to list interfaces: %v", err)
+	}
+	var loopback string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			loopback = iface.Name
+			break
+		}
+	}
+	if loopback == "" {
+		t.Skip("No loopback interface")
+	}
+
+	splitter := NewDNSSplitter(nil, nil, false, nil)
+	if err := splitter.SetBindAddrs("", loopback); err != nil {
+		t.Fatalf("SetBindAddrs(%s) failed: %v", loopback, err)
+	}
+	if !splitter.foreignBind.IsLoopback() {
+		t.Errorf("Expected loopback address for %s, got %v", loopback, splitter.foreignBind)
+	}
+}
+
+func TestDNSSplitter_QueriesFromBindAddr(t *testing.T) {
+	sources := make(chan string, 2)
+	handler := func(w dns.ResponseWriter, r *dns.Msg) {
+		host, _, _ := net.SplitHostPort(w.RemoteAddr().String())
+		sources <- host
+		answerA(w, r)
+	}
+
+	tests := []struct {
+		protocol string
+		server   string
+	}{
+		{ProtocolUDP, startTestDNSServer(t, handler)},
+		{ProtocolTCP, startTestStreamDNSServer(t, nil, handler)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.protocol, func(t *testing.T) {
+			splitter := NewDNSSplitter(nil, nil, false, nil)
+			if err := splitter.SetBindAddrs("127.0.0.1", ""); err != nil {
+				t.Fatalf("SetBindAddrs failed: %v", err)
+			}
+
+			msg := new(dns.Msg)
+			msg.SetQuestion("example.com.", dns.TypeA)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if _, err := splitter.queryDNS(ctx, msg, []string{tt.server}, tt.protocol, false); err != nil {
+				t.Fatalf("Query over %s failed: %v", tt.protocol, err)
+			}
+			if got := <-sources; got != "127.0.0.1" {
+				t.Errorf("Expected query from 127.0.0.1, got %s", got)
+			}
+		})
+	}
+}
+
+func TestDohURL(t *testing.T) {
+	if got := dohURL("dns.google"); got != "https://dns.google/dns-query" {
+		t.Errorf("Expected default DoH path, got %s", got)
+	}
+	if got := dohURL("https://1.1.1.1/dns-query"); got != "https://1.1.1.1/dns-query" {
+		t.Errorf("Expected URL to be kept, got %s", got)
+	}
+}
+
+// answerCNAME replies with qname -> target CNAME followed by the target's A record
+func answerCNAME(target, ip string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer,
+			&dns.CNAME{
+				Hdr:    dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+				Target: target,
+			},
+			&dns.A{
+				Hdr: dns.RR_Header{Name: target, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP(ip),
+			},
+		)
+		w.WriteMsg(m)
+	}
+}
+
+func TestDNSSplitter_CNAMEChain(t *testing.T) {
+	tests := []struct {
+		name       string
+		domestic   dns.HandlerFunc
+		wantTarget string
+		wantIP     string
+	}{
+		{
+			// Queried name looks domestic but aliases a foreign CDN
+			name:       "domestic name to foreign CDN",
+			domestic:   answerCNAME("cdn.foreign.example.", "93.184.216.34"),
+			wantTarget: "cdn.foreign-resolver.example.",
+			wantIP:     "93.184.216.35",
+		},
+		{
+			name:       "foreign-looking name to domestic CDN",
+			domestic:   answerCNAME("cdn.domestic.example.", "1.0.1.1"),
+			wantTarget: "cdn.domestic.example.",
+			wantIP:     "1.0.1.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domestic := startTestDNSServer(t, tt.domestic)
+			foreign := startTestDNSServer(t, answerCNAME("cdn.foreign-resolver.example.", "93.184.216.35"))
+
+			msg := new(dns.Msg)
+			msg.SetQuestion("www.example.com.", dns.TypeA)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			splitter := NewDNSSplitter([]string{domestic}, []string{foreign}, false, nil)
+			resp, err := splitter.SplitQuery(ctx, msg)
+			if err != nil {
+				t.Fatalf("SplitQuery failed: %v", err)
+			}
+			// The whole chain is handed back to the client
+			if len(resp.Answer) != 2 {
+				t.Fatalf("Expected CNAME and A records, got %v", resp.Answer)
+			}
+			if cname, ok := resp.Answer[0].(*dns.CNAME); !ok || cname.Target != tt.wantTarget {
+				t.Errorf("Expected CNAME to %s, got %v", tt.wantTarget, resp.Answer[0])
+			}
+			if a, ok := resp.Answer[1].(*dns.A); !ok || a.A.String() != tt.wantIP {
+				t.Errorf("Expected A %s, got %v", tt.wantIP, resp.Answer[1])
+			}
+		})
+	}
+}
+
+func TestTerminalAddresses(t *testing.T) {
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", s, err)
+		}
+		return r
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.example.com.", dns.TypeA)
+	msg.Answer = []dns.RR{
+		// Out of order, with an unrelated domestic record that must not decide the route
+		rr("edge.cdn.example. 60 IN A 93.184.216.34"),
+		rr("other.example. 60 IN A 1.0.1.1"),
+		rr("WWW.example.com. 60 IN CNAME alias.example.net."),
+		rr("alias.example.net. 60 IN CNAME edge.cdn.example."),
+	}
+	ips := terminalAddresses(msg)
+	if len(ips) != 1 || ips[0].String() != "93.184.216.34" {
+		t.Errorf("Expected only the chain's terminal address, got %v", ips)
+	}
+
+	// CNAME loops terminate
+	msg.Answer = []dns.RR{
+		rr("www.example.com. 60 IN CNAME a.example.net."),
+		rr("a.example.net. 60 IN CNAME www.example.com."),
+	}
+	if ips := terminalAddresses(msg); len(ips) != 0 {
+		t.Errorf("Expected no addresses for a CNAME loop, got %v", ips)
+	}
+}
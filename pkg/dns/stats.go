@@ -1,6 +1,7 @@
 package dns
 
 import (
+	"container/list"
 	"log/slog"
 	"sync"
 	"sync/atomic"
@@ -9,6 +10,8 @@ import (
 
 type QueryType string
 
+// DomainStats holds per-domain counters. Counters are only accessed atomically, QueryTypes and the
+// time fields are guarded by DNSStatsCollector.domainsMu. Callers get copies, never the live stats.
 type DomainStats struct {
 	Domain          string
 	TotalQueries    uint64
@@ -18,6 +21,8 @@ type DomainStats struct {
 	QueryTypes      map[QueryType]*QueryTypeStats
 	FirstQueryTime  time.Time
 	LastQueryTime   time.Time
+
+	elem *list.Element // position in DNSStatsCollector.lru
 }
 
 type QueryTypeStats struct {
@@ -27,13 +32,21 @@ type QueryTypeStats struct {
 	TotalNs      uint64
 }
 
+// DefaultMaxStatsDomains caps the number of domains tracked by a DNSStatsCollector
+const DefaultMaxStatsDomains = 10000
+
 type DNSStatsCollector struct {
 	domains           map[string]*DomainStats
+	lru               *list.List // *DomainStats, most recently queried at the front
 	domainsMu         sync.RWMutex
+	maxDomains        int // least recently queried domains are evicted past this many
 	queryChan         chan *QueryRecord
 	done              chan struct{}
+	shutdownOnce      sync.Once
 	wg                sync.WaitGroup
 	aggregationTicker *time.Ticker
+	coalesced         atomic.Uint64 // cache misses that joined an identical in-flight upstream query
+	upstreamSent      atomic.Uint64 // cache misses resolved upstream
 }
 
 type QueryRecord struct {
@@ -47,6 +60,8 @@ type QueryRecord struct {
 func NewDNSStatsCollector() *DNSStatsCollector {
 	c := &DNSStatsCollector{
 		domains:           make(map[string]*DomainStats),
+		lru:               list.New(),
+		maxDomains:        DefaultMaxStatsDomains,
 		queryChan:         make(chan *QueryRecord, 10000),
 		done:              make(chan struct{}),
 		aggregationTicker: time.NewTicker(5 * time.Minute),
@@ -55,6 +70,19 @@ func NewDNSStatsCollector() *DNSStatsCollector {
 	return c
 }
 
+// SetMaxDomains caps the number of tracked domains, <= 0 restores DefaultMaxStatsDomains
+func (c *DNSStatsCollector) SetMaxDomains(n int) {
+	if n <= 0 {
+		n = DefaultMaxStatsDomains
+	}
+	c.domainsMu.Lock()
+	defer c.domainsMu.Unlock()
+	c.maxDomains = n
+	for len(c.domains) > c.maxDomains {
+		c.evictLeastRecentLocked()
+	}
+}
+
 func (c *DNSStatsCollector) RecordQuery(record *QueryRecord) {
 	select {
 	case c.queryChan <- record:
@@ -62,6 +90,16 @@ func (c *DNSStatsCollector) RecordQuery(record *QueryRecord) {
 	}
 }
 
+// RecordCoalesced counts a query answered by an identical query already in flight
+func (c *DNSStatsCollector) RecordCoalesced() {
+	c.coalesced.Add(1)
+}
+
+// RecordUpstreamSent counts a query sent to the upstream resolver
+func (c *DNSStatsCollector) RecordUpstreamSent() {
+	c.upstreamSent.Add(1)
+}
+
 func (c *DNSStatsCollector) processLoop() {
 	for {
 		select {
@@ -83,14 +121,21 @@ func (c *DNSStatsCollector) updateStats(record *QueryRecord) {
 	c.domainsMu.Lock()
 	stats, exists := c.domains[record.Domain]
 	if !exists {
+		// Bound memory when many distinct names are queried, e.g. a random subdomain scan
+		if len(c.domains) >= c.maxDomains {
+			c.evictLeastRecentLocked()
+		}
 		stats = &DomainStats{
 			Domain:         record.Domain,
 			QueryTypes:     make(map[QueryType]*QueryTypeStats),
 			FirstQueryTime: record.Timestamp,
 		}
+		stats.elem = c.lru.PushFront(stats)
 		c.domains[record.Domain] = stats
+	} else {
+		c.lru.MoveToFront(stats.elem)
 	}
-	stats.TotalQueries++
+	atomic.AddUint64(&stats.TotalQueries, 1)
 	stats.LastQueryTime = record.Timestamp
 	atomic.AddUint64(&stats.TotalResponseNs, uint64(record.ResponseTime))
 
@@ -115,6 +160,19 @@ func (c *DNSStatsCollector) updateStats(record *QueryRecord) {
 	c.domainsMu.Unlock()
 }
 
+// evictLeastRecentLocked removes the least recently queried domain, domainsMu must be held
+func (c *DNSStatsCollector) evictLeastRecentLocked() {
+	if back := c.lru.Back(); back != nil {
+		c.removeLocked(back.Value.(*DomainStats))
+	}
+}
+
+// removeLocked stops tracking a domain, domainsMu must be held
+func (c *DNSStatsCollector) removeLocked(stats *DomainStats) {
+	c.lru.Remove(stats.elem)
+	delete(c.domains, stats.Domain)
+}
+
 func (c *DNSStatsCollector) aggregateStats() {
 	c.domainsMu.Lock()
 	defer c.domainsMu.Unlock()
@@ -122,11 +180,14 @@ func (c *DNSStatsCollector) aggregateStats() {
 	cutoff := time.Now().AddDate(0, 0, -7)
 	cleanedCount := 0
 
-	for domain, stats := range c.domains {
-		if stats.LastQueryTime.Before(cutoff) {
-			delete(c.domains, domain)
-			cleanedCount++
+	// Least recently queried domains are at the back
+	for back := c.lru.Back(); back != nil; back = c.lru.Back() {
+		stats := back.Value.(*DomainStats)
+		if !stats.LastQueryTime.Before(cutoff) {
+			break
 		}
+		c.removeLocked(stats)
+		cleanedCount++
 	}
 
 	if cleanedCount > 0 {
@@ -138,7 +199,10 @@ func (c *DNSStatsCollector) GetDomainStats(domain string) (*DomainStats, bool) {
 	c.domainsMu.RLock()
 	defer c.domainsMu.RUnlock()
 	stats, exists := c.domains[domain]
-	return stats, exists
+	if !exists {
+		return nil, false
+	}
+	return stats.copy(), true
 }
 
 func (c *DNSStatsCollector) GetAllStats() map[string]*DomainStats {
@@ -155,6 +219,9 @@ func (c *DNSStatsCollector) ClearStats() {
 	c.domainsMu.Lock()
 	defer c.domainsMu.Unlock()
 	c.domains = make(map[string]*DomainStats)
+	c.lru.Init()
+	c.coalesced.Store(0)
+	c.upstreamSent.Store(0)
 }
 
 func (c *DNSStatsCollector) GetTopDomains(limit int, sortBy string) []*DomainStats {
@@ -224,12 +291,18 @@ func (c *DNSStatsCollector) GetStatsSummary() StatsSummary {
 		TotalFailed:     totalFailed,
 		SuccessRate:     successRate,
 		AvgResponseTime: avgResponseTime,
+		Coalesced:       c.coalesced.Load(),
+		UpstreamSent:    c.upstreamSent.Load(),
 	}
 }
 
+// Shutdown stops the collector after applying all buffered records
 func (c *DNSStatsCollector) Shutdown() {
-	close(c.done)
-	c.wg.Wait()
+	c.shutdownOnce.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+		c.aggregationTicker.Stop()
+	})
 }
 
 type StatsSummary struct {
@@ -239,6 +312,8 @@ type StatsSummary struct {
 	TotalFailed     uint64
 	SuccessRate     float64
 	AvgResponseTime time.Duration
+	Coalesced       uint64
+	UpstreamSent    uint64
 }
 
 func (s *DomainStats) copy() *DomainStats {
@@ -0,0 +1,96 @@
+package dns
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDNSStatsCollector_ShutdownDrainsBufferedRecords(t *testing.T) {
+	c := NewDNSStatsCollector()
+
+	const n = 1000
+	for range n {
+		c.RecordQuery(&QueryRecord{
+			Domain:       "example.com",
+			QueryType:    "A",
+			ResponseTime: time.Millisecond,
+			Success:      true,
+			Timestamp:    time.Now(),
+		})
+	}
+	c.Shutdown()
+
+	stats, ok := c.GetDomainStats("example.com")
+	if !ok {
+		t.Fatal("Expected stats for example.com after shutdown")
+	}
+	if stats.TotalQueries != n {
+		t.Errorf("Expected %d queries to be drained, got %d", n, stats.TotalQueries)
+	}
+
+	// A second shutdown must not panic
+	c.Shutdown()
+}
+
+func TestDNSStatsCollector_ConcurrentRecordAndRead(t *testing.T) {
+	c := NewDNSStatsCollector()
+	defer c.Shutdown()
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Go(func() {
+			for j := range 500 {
+				c.RecordQuery(&QueryRecord{
+					Domain:       fmt.Sprintf("host%d.example.com", j%10),
+					QueryType:    QueryType([]string{"A", "AAAA"}[i%2]),
+					ResponseTime: time.Millisecond,
+					Success:      j%3 != 0,
+					Timestamp:    time.Now(),
+				})
+			}
+		})
+		wg.Go(func() {
+			for range 200 {
+				for _, stats := range c.GetTopDomains(5, "response_time") {
+					FormatDomainStats(stats)
+				}
+				if stats, ok := c.GetDomainStats("host1.example.com"); ok {
+					FormatDomainStats(stats)
+				}
+				c.GetAllStats()
+				c.GetStatsSummary()
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestDNSStatsCollector_MaxDomains(t *testing.T) {
+	c := NewDNSStatsCollector()
+	c.SetMaxDomains(3)
+
+	start := time.Now()
+	record := func(domain string, at time.Duration) {
+		c.RecordQuery(&QueryRecord{Domain: domain, QueryType: "A", Success: true, Timestamp: start.Add(at)})
+	}
+	record("host0.example.com", 0)
+	record("host1.example.com", time.Second)
+	record("host2.example.com", 2*time.Second)
+	// host0 is active again, so host1 and host2 are the least recently active when the cap is hit
+	record("host0.example.com", 3*time.Second)
+	record("host3.example.com", 4*time.Second)
+	record("host4.example.com", 5*time.Second)
+	c.Shutdown()
+
+	all := c.GetAllStats()
+	if len(all) != 3 {
+		t.Fatalf("Expected 3 domains after eviction, got %d", len(all))
+	}
+	for _, domain := range []string{"host0.example.com", "host3.example.com", "host4.example.com"} {
+		if _, ok := all[domain]; !ok {
+			t.Errorf("Expected recently active %s to be kept, got %v", domain, all)
+		}
+	}
+}
@@ -14,7 +14,9 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/monsterxx03/linko/pkg/version"
 	"github.com/yl2chen/cidranger"
 )
 
@@ -34,11 +36,44 @@ var (
 	chinaIPDataDir = "pkg/ipdb"
 	chinaIPFile    = "china_ip_data.json"
 	chinaRanger    atomic.Value
-	chinaCIDRsList []string
 	chinaCIDRsOnce sync.Once
+
+	// chinaMu guards the load state below, read by GetStatus while a load writes it
+	chinaMu        sync.RWMutex
+	chinaCIDRsList []string
 	chinaCIDRsErr  error
+	chinaUpdatedAt time.Time
 )
 
+// embeddedPath is reported as the database path when using the embedded data
+var embeddedPath = "embedded:" + chinaIPFile
+
+// chinaIPData is the format written by FetchChinaIPRanges.
+// Older data files are a plain JSON array of CIDRs without a timestamp.
+type chinaIPData struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	Ranges    []string  `json:"ranges"`
+}
+
+// Status describes the state of the loaded China IP database
+type Status struct {
+	Path        string
+	Initialized bool
+	UpdatedAt   time.Time
+	Age         time.Duration
+	RangeCount  int
+	Error       error
+}
+
+// IsStale reports whether the database is older than maxAge.
+// A database with unknown build date is never considered stale.
+func (s Status) IsStale(maxAge time.Duration) bool {
+	if maxAge <= 0 || s.UpdatedAt.IsZero() {
+		return false
+	}
+	return s.Age > maxAge
+}
+
 //go:embed china_ip_data.json
 var embeddedData []byte
 
@@ -113,7 +148,7 @@ func FetchChinaIPRanges() error {
 	defer file.Close()
 
 	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(ranges); err != nil {
+	if err := encoder.Encode(chinaIPData{UpdatedAt: time.Now().UTC(), Ranges: ranges}); err != nil {
 		return fmt.Errorf("failed to write JSON file: %w", err)
 	}
 
@@ -121,22 +156,51 @@ func FetchChinaIPRanges() error {
 }
 
 func LoadChinaIPRanges() error {
-	chinaCIDRsOnce.Do(func() {
-		chinaCIDRsErr = loadChinaIPRangesFromEmbed()
-	})
+	chinaCIDRsOnce.Do(loadChinaIPRangesOnce)
+	chinaMu.RLock()
+	defer chinaMu.RUnlock()
 	return chinaCIDRsErr
 }
 
+// loadChinaIPRangesOnce loads the embedded data and records the outcome for GetStatus
+func loadChinaIPRangesOnce() {
+	err := loadChinaIPRangesFromEmbed()
+	chinaMu.Lock()
+	chinaCIDRsErr = err
+	chinaMu.Unlock()
+}
+
 func loadChinaIPRangesFromEmbed() error {
 	rangesJSON, err := getEmbeddedChinaIPRanges()
 	if err != nil {
 		return fmt.Errorf("failed to get embedded China IP ranges: %w", err)
 	}
 
-	var ranges []string
-	if err := json.Unmarshal([]byte(rangesJSON), &ranges); err != nil {
+	if err := loadChinaIPRanges([]byte(rangesJSON)); err != nil {
 		return fmt.Errorf("failed to parse embedded China IP ranges: %w", err)
 	}
+	return nil
+}
+
+// loadChinaIPRanges parses China IP data in either the legacy array format
+// or the timestamped object format and installs it as the active database
+func loadChinaIPRanges(data []byte) error {
+	var parsed chinaIPData
+	if err := json.Unmarshal(data, &parsed.Ranges); err != nil {
+		if err := json.Unmarshal(data, &parsed); err != nil {
+			return err
+		}
+	}
+	ranges := parsed.Ranges
+
+	// Legacy data has no timestamp, fall back to the binary build date
+	// since the data is embedded at build time
+	updatedAt := parsed.UpdatedAt
+	if updatedAt.IsZero() && version.Date != "" {
+		if t, err := time.Parse(time.RFC3339, version.Date); err == nil {
+			updatedAt = t
+		}
+	}
 
 	ranger := cidranger.NewPCTrieRanger()
 	for _, cidr := range ranges {
@@ -147,16 +211,19 @@ func loadChinaIPRangesFromEmbed() error {
 		ranger.Insert(cidranger.NewBasicRangerEntry(*ipNet))
 	}
 
+	chinaMu.Lock()
 	chinaRanger.Store(ranger)
 	chinaCIDRsList = ranges
+	chinaUpdatedAt = updatedAt
+	chinaMu.Unlock()
 
 	return nil
 }
 
 func GetChinaCIDRs() ([]string, error) {
-	chinaCIDRsOnce.Do(func() {
-		chinaCIDRsErr = loadChinaIPRangesFromEmbed()
-	})
+	chinaCIDRsOnce.Do(loadChinaIPRangesOnce)
+	chinaMu.RLock()
+	defer chinaMu.RUnlock()
 	if chinaCIDRsErr != nil {
 		return nil, chinaCIDRsErr
 	}
@@ -168,10 +235,38 @@ func GetReservedCIDRs() []string {
 	return reservedCIDRs
 }
 
+// IsInitialized reports whether the China IP database has been loaded successfully
+func IsInitialized() bool {
+	ranger, ok := chinaRanger.Load().(cidranger.Ranger)
+	return ok && ranger != nil
+}
+
+// GetStatus returns the current state of the China IP database without triggering a load
+func GetStatus() Status {
+	chinaMu.RLock()
+	defer chinaMu.RUnlock()
+
+	status := Status{
+		Path:        embeddedPath,
+		Initialized: IsInitialized(),
+		Error:       chinaCIDRsErr,
+	}
+	if !status.Initialized {
+		return status
+	}
+
+	status.RangeCount = len(chinaCIDRsList)
+	status.UpdatedAt = chinaUpdatedAt
+	if !chinaUpdatedAt.IsZero() {
+		status.Age = time.Since(chinaUpdatedAt)
+	}
+	return status
+}
+
 func IsChinaIP(ipStr string) bool {
 	GetChinaCIDRs()
-	ranger := chinaRanger.Load().(cidranger.Ranger)
-	if ranger == nil {
+	ranger, ok := chinaRanger.Load().(cidranger.Ranger)
+	if !ok || ranger == nil {
 		return false
 	}
 
@@ -0,0 +1,130 @@
+package ipdb
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// resetChinaIPState clears the loaded database so each test starts uninitialized
+func resetChinaIPState(t *testing.T) {
+	t.Helper()
+	chinaRanger = atomic.Value{}
+	chinaCIDRsList = nil
+	chinaCIDRsOnce = sync.Once{}
+	chinaCIDRsErr = nil
+	chinaUpdatedAt = time.Time{}
+	// Mark as loaded so lookups don't replace the stub with embedded data
+	chinaCIDRsOnce.Do(func() {})
+}
+
+func TestGetStatus_NotInitialized(t *testing.T) {
+	resetChinaIPState(t)
+
+	status := GetStatus()
+	if status.Initialized {
+		t.Error("Expected status to be uninitialized")
+	}
+	if IsInitialized() {
+		t.Error("Expected IsInitialized to be false")
+	}
+	if status.Path != embeddedPath {
+		t.Errorf("Expected path %s, got %s", embeddedPath, status.Path)
+	}
+	if status.RangeCount != 0 {
+		t.Errorf("Expected 0 ranges, got %d", status.RangeCount)
+	}
+	if IsChinaIP("1.0.1.1") {
+		t.Error("Expected IsChinaIP to be false when database is not loaded")
+	}
+}
+
+func TestGetStatus_StubDB(t *testing.T) {
+	resetChinaIPState(t)
+
+	updatedAt := time.Now().Add(-48 * time.Hour).UTC()
+	stub := []byte(`{"updated_at":"` + updatedAt.Format(time.RFC3339) + `","ranges":["1.0.1.0/24","1.0.2.0/23"]}`)
+	if err := loadChinaIPRanges(stub); err != nil {
+		t.Fatalf("loadChinaIPRanges failed: %v", err)
+	}
+
+	status := GetStatus()
+	if !status.Initialized {
+		t.Fatal("Expected status to be initialized")
+	}
+	if status.RangeCount != 2 {
+		t.Errorf("Expected 2 ranges, got %d", status.RangeCount)
+	}
+	if !status.UpdatedAt.Equal(updatedAt.Truncate(time.Second)) {
+		t.Errorf("Expected updated_at %v, got %v", updatedAt, status.UpdatedAt)
+	}
+	if status.Age < 47*time.Hour || status.Age > 49*time.Hour {
+		t.Errorf("Expected age around 48h, got %v", status.Age)
+	}
+	if !status.IsStale(24 * time.Hour) {
+		t.Error("Expected database to be stale with 24h max age")
+	}
+	if status.IsStale(72 * time.Hour) {
+		t.Error("Expected database to be fresh with 72h max age")
+	}
+	if status.IsStale(0) {
+		t.Error("Expected zero max age to disable staleness check")
+	}
+	if !IsChinaIP("1.0.1.1") {
+		t.Error("Expected 1.0.1.1 to be a China IP")
+	}
+}
+
+func TestGetStatus_LegacyArrayFormat(t *testing.T) {
+	resetChinaIPState(t)
+
+	if err := loadChinaIPRanges([]byte(`["1.0.1.0/24"]`)); err != nil {
+		t.Fatalf("loadChinaIPRanges failed: %v", err)
+	}
+
+	status := GetStatus()
+	if !status.Initialized {
+		t.Fatal("Expected status to be initialized")
+	}
+	if status.RangeCount != 1 {
+		t.Errorf("Expected 1 range, got %d", status.RangeCount)
+	}
+	if !status.UpdatedAt.IsZero() {
+		t.Errorf("Expected unknown build date for legacy data, got %v", status.UpdatedAt)
+	}
+	if status.IsStale(time.Hour) {
+		t.Error("Expected unknown build date to never be stale")
+	}
+}
+
+func TestLoadChinaIPRanges_Invalid(t *testing.T) {
+	resetChinaIPState(t)
+
+	if err := loadChinaIPRanges([]byte(`not json`)); err == nil {
+		t.Error("Expected error for invalid data")
+	}
+	if IsInitialized() {
+		t.Error("Expected database to remain uninitialized")
+	}
+}
+
+func TestGetStatus_ConcurrentLoad(t *testing.T) {
+	resetChinaIPState(t)
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for range 100 {
+			if err := loadChinaIPRanges([]byte(`["1.0.1.0/24","1.0.2.0/23"]`)); err != nil {
+				t.Errorf("loadChinaIPRanges failed: %v", err)
+				return
+			}
+		}
+	})
+	for range 100 {
+		if status := GetStatus(); status.Initialized && status.RangeCount != 2 {
+			t.Fatalf("Expected 2 ranges once initialized, got %d", status.RangeCount)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/monsterxx03/linko/pkg/config"
+)
+
+// nopCloser is returned for stdout/stderr outputs which must not be closed
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// ParseLevel converts a config log level to slog.Level, unknown levels fall back to info
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "info":
+		return slog.LevelInfo
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// NewLogger creates a logger from the server config.
+// The returned closer releases the log file and must be closed on exit.
+func NewLogger(cfg config.ServerConfig) (*slog.Logger, io.Closer, error) {
+	var w io.Writer
+	var closer io.Closer = nopCloser{}
+
+	switch cfg.LogOutput {
+	case "", "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		f, err := NewRotatingFile(cfg.LogOutput, int64(cfg.LogMaxSizeMB)<<20, cfg.LogMaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, closer = f, f
+	}
+
+	logger, err := newLogger(w, cfg.LogFormat, ParseLevel(cfg.LogLevel))
+	if err != nil {
+		closer.Close()
+		return nil, nil, err
+	}
+	return logger, closer, nil
+}
+
+func newLogger(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "", "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", format)
+	}
+}
@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monsterxx03/linko/pkg/config"
+)
+
+func TestNewLogger_JSONToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "linko.log")
+	logger, closer, err := NewLogger(config.ServerConfig{
+		LogLevel:  "debug",
+		LogFormat: "json",
+		LogOutput: path,
+	})
+	if err != nil {
+		t.Fatalf("NewLogger failed: %v", err)
+	}
+	logger.Debug("hello", "domain", "example.com")
+	closer.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	var entry map[string]any
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("Expected JSON log line, got %q: %v", data, err)
+	}
+	if entry["msg"] != "hello" || entry["domain"] != "example.com" || entry["level"] != "DEBUG" {
+		t.Errorf("Unexpected log entry: %v", entry)
+	}
+}
+
+func TestNewLogger_TextFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "linko.log")
+	logger, closer, err := NewLogger(config.ServerConfig{LogFormat: "text", LogOutput: path})
+	if err != nil {
+		t.Fatalf("NewLogger failed: %v", err)
+	}
+	logger.Info("hello")
+	logger.Debug("filtered")
+	closer.Close()
+
+	data, _ := os.ReadFile(path)
+	line := string(data)
+	if !strings.Contains(line, "level=INFO") || !strings.Contains(line, "msg=hello") {
+		t.Errorf("Expected text log line, got %q", line)
+	}
+	if strings.Contains(line, "filtered") {
+		t.Error("Expected debug message to be filtered at default info level")
+	}
+}
+
+func TestNewLogger_InvalidFormat(t *testing.T) {
+	if _, _, err := NewLogger(config.ServerConfig{LogFormat: "xml"}); err == nil {
+		t.Error("Expected error for unsupported log format")
+	}
+}
+
+func TestRotatingFile_Rotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "linko.log")
+	f, err := NewRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("NewRotatingFile failed: %v", err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first-\n", "second\n", "third-\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third-\n",
+		path + ".2": "second\n",
+	} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if string(data) != want {
+			t.Errorf("%s: expected %q, got %q", name, want, data)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Expected only 2 backups to be kept")
+	}
+}
@@ -1,6 +1,8 @@
 package mitm
 
 import (
+	"path"
+	"regexp"
 	"strings"
 )
 
@@ -31,13 +33,25 @@ type Inspector interface {
 type BaseInspector struct {
 	name     string
 	hostname string
+	hostRe   *regexp.Regexp // compiled "~" hostname pattern
 }
 
+// NewBaseInspector creates an inspector base matching hostname, which is one of
+//   - empty: every host
+//   - "example.com": example.com and its subdomains
+//   - "*.example.com": a glob, see path.Match
+//   - "~api[0-9]+\.example\.com": a case-insensitive regular expression, anchored to the whole host.
+//
+// An invalid expression or glob matches nothing.
 func NewBaseInspector(name, hostname string) *BaseInspector {
-	return &BaseInspector{
+	b := &BaseInspector{
 		name:     name,
-		hostname: hostname,
+		hostname: strings.TrimSuffix(strings.ToLower(hostname), "."),
 	}
+	if expr, ok := strings.CutPrefix(hostname, "~"); ok {
+		b.hostRe, _ = regexp.Compile("(?i)^(?:" + expr + ")$")
+	}
+	return b
 }
 
 func (b *BaseInspector) Name() string {
@@ -48,5 +62,14 @@ func (b *BaseInspector) ShouldInspect(hostname string) bool {
 	if b.hostname == "" {
 		return true
 	}
-	return strings.Contains(hostname, b.hostname)
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	switch {
+	case strings.HasPrefix(b.hostname, "~"):
+		return b.hostRe != nil && b.hostRe.MatchString(hostname)
+	case strings.ContainsAny(b.hostname, "*?["):
+		matched, _ := path.Match(b.hostname, hostname)
+		return matched
+	default:
+		return hostname == b.hostname || strings.HasSuffix(hostname, "."+b.hostname)
+	}
 }
@@ -0,0 +1,42 @@
+package mitm
+
+import "testing"
+
+func TestBaseInspector_ShouldInspect(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		hostname string
+		want     bool
+	}{
+		{"", "anything.example.com", true},
+
+		// Plain names match the host and its subdomains only
+		{"api.openai.com", "api.openai.com", true},
+		{"api.openai.com", "API.OpenAI.com.", true},
+		{"openai.com", "api.openai.com", true},
+		{"api.openai.com", "notapi.openai.com", false},
+		{"api.openai.com", "api.openai.com.evil.com", false},
+		{"openai.com", "evilopenai.com", false},
+
+		// Globs
+		{"*.openai.com", "api.openai.com", true},
+		{"*.openai.com", "openai.com", false},
+		{"*.openai.com", "api.openai.com.evil.com", false},
+		{"api-?.example.com", "api-1.example.com", true},
+		{"[api.openai.com", "[api.openai.com", false},
+
+		// Regular expressions are anchored
+		{`~api[0-9]+\.example\.com`, "api12.example.com", true},
+		{`~api[0-9]+\.example\.com`, "API3.example.com", true},
+		{`~api[0-9]+\.example\.com`, "xapi1.example.com", false},
+		{`~api[0-9]+\.example\.com`, "api1.example.com.evil.com", false},
+		{`~api\d+|chat\.example\.com`, "api7", true},
+		{`~(`, "anything", false},
+	}
+	for _, tt := range tests {
+		b := NewBaseInspector("test", tt.pattern)
+		if got := b.ShouldInspect(tt.hostname); got != tt.want {
+			t.Errorf("pattern %q, ShouldInspect(%q) = %v, want %v", tt.pattern, tt.hostname, got, tt.want)
+		}
+	}
+}
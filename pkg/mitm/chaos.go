@@ -0,0 +1,135 @@
+package mitm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// ChaosConfig configures synthetic faults injected into responses for resilience testing
+type ChaosConfig struct {
+	Hosts        []string      // Hostname substrings to apply faults to, empty = all hosts
+	DropRate     float64       // Fraction of responses whose connection is closed before any data is sent
+	TruncateRate float64       // Fraction of responses cut off halfway through the first chunk
+	DelayRate    float64       // Fraction of responses delayed by Delay
+	Delay        time.Duration // Delay applied to delayed responses
+}
+
+// ChaosFault is the fault chosen for a response
+type ChaosFault int
+
+const (
+	ChaosNone ChaosFault = iota
+	ChaosDrop
+	ChaosTruncate
+	ChaosDelay
+)
+
+func (f ChaosFault) String() string {
+	switch f {
+	case ChaosDrop:
+		return "drop"
+	case ChaosTruncate:
+		return "truncate"
+	case ChaosDelay:
+		return "delay"
+	default:
+		return "none"
+	}
+}
+
+// errChaosDrop aborts the relay of a dropped response
+var errChaosDrop = errors.New("chaos: response dropped")
+
+// Chaos picks faults for responses of matching hosts
+type Chaos struct {
+	config ChaosConfig
+	rand   func() float64
+}
+
+// NewChaos validates config and creates a Chaos
+func NewChaos(config ChaosConfig) (*Chaos, error) {
+	for name, rate := range map[string]float64{
+		"drop":     config.DropRate,
+		"truncate": config.TruncateRate,
+		"delay":    config.DelayRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("chaos %s rate must be between 0 and 1, got %v", name, rate)
+		}
+	}
+	if total := config.DropRate + config.TruncateRate + config.DelayRate; total > 1 {
+		return nil, fmt.Errorf("chaos fault rates add up to %v, must not exceed 1", total)
+	}
+	return &Chaos{config: config, rand: rand.Float64}, nil
+}
+
+// Matches checks if faults apply to hostname
+func (c *Chaos) Matches(hostname string) bool {
+	if len(c.config.Hosts) == 0 {
+		return true
+	}
+	for _, host := range c.config.Hosts {
+		if strings.Contains(hostname, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pick rolls the fault for one response
+func (c *Chaos) Pick() ChaosFault {
+	r := c.rand()
+	switch {
+	case r < c.config.DropRate:
+		return ChaosDrop
+	case r < c.config.DropRate+c.config.TruncateRate:
+		return ChaosTruncate
+	case r < c.config.DropRate+c.config.TruncateRate+c.config.DelayRate:
+		return ChaosDelay
+	default:
+		return ChaosNone
+	}
+}
+
+// chaosReader applies faults to the server->client stream, rolling once per HTTP/1.x response
+type chaosReader struct {
+	r       io.Reader
+	chaos   *Chaos
+	faulted bool // a drop or truncate ended the stream, the connection should be closed
+}
+
+func newChaosReader(r io.Reader, chaos *Chaos) *chaosReader {
+	return &chaosReader{r: r, chaos: chaos}
+}
+
+// hasFaulted reports whether a fault ended the stream, false for a nil chaosReader
+func (c *chaosReader) hasFaulted() bool {
+	return c != nil && c.faulted
+}
+
+func (c *chaosReader) Read(p []byte) (int, error) {
+	if c.faulted {
+		return 0, io.EOF
+	}
+	n, err := c.r.Read(p)
+	if n == 0 || !bytes.HasPrefix(p[:n], []byte("HTTP/1.")) {
+		return n, err
+	}
+
+	switch c.chaos.Pick() {
+	case ChaosDrop:
+		c.faulted = true
+		return 0, errChaosDrop
+	case ChaosTruncate:
+		c.faulted = true
+		return n / 2, nil
+	case ChaosDelay:
+		time.Sleep(c.chaos.config.Delay)
+	}
+	return n, err
+}
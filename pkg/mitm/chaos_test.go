@@ -0,0 +1,103 @@
+package mitm
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestChaos(t *testing.T, config ChaosConfig) *Chaos {
+	t.Helper()
+	chaos, err := NewChaos(config)
+	if err != nil {
+		t.Fatalf("NewChaos failed: %v", err)
+	}
+	chaos.rand = rand.New(rand.NewPCG(1, 2)).Float64
+	return chaos
+}
+
+func TestChaos_FaultRatesWithinBounds(t *testing.T) {
+	config := ChaosConfig{DropRate: 0.1, TruncateRate: 0.2, DelayRate: 0.05}
+	chaos := newTestChaos(t, config)
+
+	const n = 20000
+	counts := make(map[ChaosFault]int)
+	for range n {
+		counts[chaos.Pick()]++
+	}
+
+	for fault, rate := range map[ChaosFault]float64{
+		ChaosDrop:     config.DropRate,
+		ChaosTruncate: config.TruncateRate,
+		ChaosDelay:    config.DelayRate,
+		ChaosNone:     1 - config.DropRate - config.TruncateRate - config.DelayRate,
+	} {
+		// Allow 4 standard deviations of a binomial distribution
+		tolerance := 4 * math.Sqrt(rate*(1-rate)/n)
+		got := float64(counts[fault]) / n
+		if math.Abs(got-rate) > tolerance {
+			t.Errorf("%s: expected rate %.3f±%.3f, got %.3f", fault, rate, tolerance, got)
+		}
+	}
+}
+
+func TestNewChaos_InvalidRates(t *testing.T) {
+	for _, config := range []ChaosConfig{
+		{DropRate: -0.1},
+		{TruncateRate: 1.5},
+		{DropRate: 0.6, DelayRate: 0.6},
+	} {
+		if _, err := NewChaos(config); err == nil {
+			t.Errorf("Expected error for %+v", config)
+		}
+	}
+}
+
+func TestChaos_Matches(t *testing.T) {
+	chaos := newTestChaos(t, ChaosConfig{Hosts: []string{"api.example.com"}})
+	if !chaos.Matches("api.example.com") {
+		t.Error("Expected configured host to match")
+	}
+	if chaos.Matches("other.com") {
+		t.Error("Expected other host not to match")
+	}
+	if !newTestChaos(t, ChaosConfig{}).Matches("any.com") {
+		t.Error("Expected empty host list to match all hosts")
+	}
+}
+
+const chaosTestResponse = "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"
+
+func TestChaosReader_Faults(t *testing.T) {
+	t.Run("drop", func(t *testing.T) {
+		r := newChaosReader(strings.NewReader(chaosTestResponse), newTestChaos(t, ChaosConfig{DropRate: 1}))
+		data, err := io.ReadAll(r)
+		if !errors.Is(err, errChaosDrop) || len(data) != 0 || !r.faulted {
+			t.Errorf("Expected dropped response, got %q, %v", data, err)
+		}
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		r := newChaosReader(strings.NewReader(chaosTestResponse), newTestChaos(t, ChaosConfig{TruncateRate: 1}))
+		data, err := io.ReadAll(r)
+		if err != nil || len(data) != len(chaosTestResponse)/2 || !r.faulted {
+			t.Errorf("Expected truncated response, got %q, %v", data, err)
+		}
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		r := newChaosReader(strings.NewReader(chaosTestResponse), newTestChaos(t, ChaosConfig{DelayRate: 1, Delay: 20 * time.Millisecond}))
+		start := time.Now()
+		data, err := io.ReadAll(r)
+		if err != nil || string(data) != chaosTestResponse || r.faulted {
+			t.Errorf("Expected full response, got %q, %v", data, err)
+		}
+		if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+			t.Errorf("Expected response to be delayed, took %v", elapsed)
+		}
+	})
+}
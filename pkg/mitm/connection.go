@@ -4,13 +4,18 @@ import (
 	"bufio"
 	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"sync"
+	"syscall"
 	"time"
+
+	"github.com/monsterxx03/linko/pkg/clienthello"
 )
 
 // bufferPool is a sync.Pool for managing buffers used in io.CopyBuffer
@@ -27,6 +32,11 @@ type ConnectionHandler struct {
 	upstream        UpstreamClient
 	peekReader      *PeekReader // Optional pre-wrapped connection for whitelist check
 	inspector       *InspectorChain
+	chaos           *Chaos         // Optional fault injection for responses
+	cache           *ResponseCache // Optional cache answering repeated GETs
+	forwardedMode   string         // X-Forwarded-For injection mode, empty = requests are relayed untouched
+	keyLog          io.Writer      // Optional NSS key log destination for both TLS legs, debugging only
+	upstreamRoots   *x509.CertPool // Roots for verifying upstream servers, nil = system roots
 	ctx             interface{}
 }
 
@@ -36,6 +46,85 @@ type UpstreamClient interface {
 	IsEnabled() bool
 }
 
+// sourceConnector is implemented by upstream clients that can announce the original client address
+type sourceConnector interface {
+	ConnectFrom(src net.Addr, host string, port int) (net.Conn, error)
+}
+
+// UpstreamHandshakeError is returned when the TLS handshake with the real server fails
+// before the client side was touched, so the connection can still be tunneled raw
+type UpstreamHandshakeError struct {
+	Hostname string
+	Err      error
+}
+
+func (e *UpstreamHandshakeError) Error() string {
+	return fmt.Sprintf("upstream TLS handshake failed for %s: %v", e.Hostname, e.Err)
+}
+
+func (e *UpstreamHandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// TLS alerts a peer sends when it rejects the certificate it was presented with
+const (
+	alertBadCertificate     = tls.AlertError(42)
+	alertCertificateUnknown = tls.AlertError(46)
+	alertUnknownCA          = tls.AlertError(48)
+)
+
+// CertificateRejected reports whether the server ended the handshake with a bad_certificate or
+// unknown_ca alert, typically because it requires a client certificate linko can't present,
+// rather than failing for a transient reason
+func (e *UpstreamHandshakeError) CertificateRejected() bool {
+	return remoteAlert(e.Err, alertBadCertificate, alertUnknownCA)
+}
+
+// ClientHandshakeError is returned when the client aborts the TLS handshake with the MITM certificate
+type ClientHandshakeError struct {
+	Hostname string
+	Err      error
+}
+
+func (e *ClientHandshakeError) Error() string {
+	return fmt.Sprintf("client TLS handshake failed for %s: %v", e.Hostname, e.Err)
+}
+
+func (e *ClientHandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// CertificateRejected reports whether the client refused the MITM certificate, as pinning or
+// untrusting clients do, by sending a certificate alert or resetting the connection mid-handshake
+func (e *ClientHandshakeError) CertificateRejected() bool {
+	if errors.Is(e.Err, syscall.ECONNRESET) {
+		return true
+	}
+	return remoteAlert(e.Err, alertBadCertificate, alertCertificateUnknown, alertUnknownCA)
+}
+
+// remoteAlert reports whether err is one of alerts received from the peer
+func remoteAlert(err error, alerts ...tls.AlertError) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "remote error" || opErr.Err == nil {
+		return false
+	}
+	// crypto/tls reports received alerts with an unexported type carrying the same text
+	msg := opErr.Err.Error()
+	for _, alert := range alerts {
+		if msg == alert.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUpstreamHandshakeError reports whether err is an UpstreamHandshakeError
+func IsUpstreamHandshakeError(err error) bool {
+	var hsErr *UpstreamHandshakeError
+	return errors.As(err, &hsErr)
+}
+
 // NewConnectionHandler creates a new MITM connection handler
 func NewConnectionHandler(
 	siteCertManager *SiteCertManager,
@@ -55,7 +144,13 @@ func NewConnectionHandler(
 
 // HandleConnection handles a MITM connection
 func (h *ConnectionHandler) HandleConnection(clientConn net.Conn, targetIP net.IP, targetPort int) error {
-	defer clientConn.Close()
+	// Client connection is left open on upstream handshake failure so the caller can tunnel it raw
+	keepClient := false
+	defer func() {
+		if !keepClient {
+			clientConn.Close()
+		}
+	}()
 
 	// Use provided peekReader or create a new one
 	peekReader := h.peekReader
@@ -86,7 +181,11 @@ func (h *ConnectionHandler) HandleConnection(clientConn net.Conn, targetIP net.I
 	var serverConn net.Conn
 	targetHost := targetIP.String()
 	if h.upstream.IsEnabled() {
-		serverConn, err = h.upstream.Connect(targetHost, targetPort)
+		if sc, ok := h.upstream.(sourceConnector); ok {
+			serverConn, err = sc.ConnectFrom(clientConn.RemoteAddr(), targetHost, targetPort)
+		} else {
+			serverConn, err = h.upstream.Connect(targetHost, targetPort)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to connect to upstream: %w", err)
 		}
@@ -98,69 +197,59 @@ func (h *ConnectionHandler) HandleConnection(clientConn net.Conn, targetIP net.I
 	}
 	defer serverConn.Close()
 
-	// Create TLS config for client side (MITM side)
-	clientTLSConfig := &tls.Config{
-		Certificates: []tls.Certificate{*siteCert},
-		ServerName:   hostname,
-		// Accept any certificate from the server
-		InsecureSkipVerify: true,
-	}
+	clientTLSConfig, serverTLSConfig := h.tlsConfigs(siteCert, hostname)
 
-	// Create TLS config for server side (connecting to actual server)
-	serverTLSConfig := &tls.Config{
-		ServerName: hostname,
-		// Verify server certificate
-		InsecureSkipVerify: false,
+	// Handshake with the server first, the ClientHello is still unconsumed in peekReader
+	// so a failure here (e.g. untrusted cert, client cert required) can fall back to a raw tunnel
+	serverTLS := tls.Client(serverConn, serverTLSConfig)
+	if err := serverTLS.Handshake(); err != nil {
+		keepClient = true
+		return &UpstreamHandshakeError{Hostname: hostname, Err: err}
 	}
+	defer serverTLS.Close()
 
 	// Upgrade connection to TLS with client using the peek reader
 	clientTLS := tls.Server(peekReader, clientTLSConfig)
 	if err := clientTLS.Handshake(); err != nil {
-		return fmt.Errorf("client TLS handshake failed: %w", err)
+		return &ClientHandshakeError{Hostname: hostname, Err: err}
 	}
 	defer clientTLS.Close()
 
-	// Connect to server with TLS
-	serverTLS := tls.Client(serverConn, serverTLSConfig)
-	if err := serverTLS.Handshake(); err != nil {
-		return fmt.Errorf("server TLS handshake failed: %w", err)
-	}
-	defer serverTLS.Close()
-
 	// Handle the connection
 	return h.relayTraffic(clientTLS, serverTLS, hostname)
 }
 
-// peekSNI extracts SNI from the connection using a PeekReader
-func (h *ConnectionHandler) peekSNI(peekReader *PeekReader, targetIP net.IP) (string, error) {
-	// First, peek at the TLS record header to get the full record length
-	header, err := peekReader.Peek(5)
-	if err != nil {
-		return "", fmt.Errorf("failed to peek TLS header: %w", err)
-	}
-
-	// TLS record header: 1 byte type + 2 bytes version + 2 bytes length
-	recordLen := int(header[3])<<8 | int(header[4])
-	totalLen := 5 + recordLen
-
-	// Peek at the complete TLS record
-	if totalLen > DefaultBufferSize {
-		totalLen = DefaultBufferSize // Cap at buffer size
+// tlsConfigs returns the configs for the client facing (MITM) and server facing TLS legs
+func (h *ConnectionHandler) tlsConfigs(siteCert *tls.Certificate, hostname string) (client, server *tls.Config) {
+	// Create TLS config for client side (MITM side)
+	client = &tls.Config{
+		Certificates: []tls.Certificate{*siteCert},
+		ServerName:   hostname,
+		// Accept any certificate from the server
+		InsecureSkipVerify: true,
+		KeyLogWriter:       h.keyLog,
 	}
 
-	peekData, err := peekReader.Peek(totalLen)
-	if err != nil && len(peekData) < 200 {
-		return "", fmt.Errorf("failed to peek TLS record: %w", err)
+	// Create TLS config for server side (connecting to actual server)
+	server = &tls.Config{
+		ServerName: hostname,
+		// Verify server certificate
+		InsecureSkipVerify: false,
+		RootCAs:            h.upstreamRoots,
+		KeyLogWriter:       h.keyLog,
 	}
+	return client, server
+}
 
-	// Parse SNI from the peeked data
-	sniInfo, err := ExtractSNIFromConn(peekData)
-	if err != nil {
-		return "", fmt.Errorf("SNI parsing failed: %w", err)
+// peekSNI extracts SNI from the connection using a PeekReader
+func (h *ConnectionHandler) peekSNI(peekReader *PeekReader, targetIP net.IP) (string, error) {
+	info := clienthello.Peek(peekReader.Peek, DefaultBufferSize)
+	if info.ParseError != nil {
+		return "", fmt.Errorf("SNI parsing failed: %w", info.ParseError)
 	}
 
-	if sniInfo.IsValid && sniInfo.Hostname != "" {
-		return sniInfo.Hostname, nil
+	if info.Hostname != "" {
+		return info.Hostname, nil
 	}
 
 	// Fall back to target IP
@@ -188,19 +277,54 @@ func (h *ConnectionHandler) relayTraffic(client, server net.Conn, hostname strin
 	var serverReader io.Reader = server
 	var serverWriter io.Writer = server
 
+	var chaos *chaosReader
+	if h.chaos != nil && h.chaos.Matches(hostname) {
+		chaos = newChaosReader(server, h.chaos)
+		serverReader = chaos
+	}
+
 	if h.inspector.ShouldInspect(hostname) {
+		// Streams still open when the connection closes get their terminal events
+		defer h.inspector.Finalize(connectionID)
+
 		// Only inspect on read operations to avoid duplicate inspection
 		// Client -> Server: inspect when reading from client
 		clientReader = NewInspectReader(client, h.inspector, hostname, DirectionClientToServer, h.logger, idGenerator)
 		// Server -> Client: inspect when reading from server
-		serverReader = NewInspectReader(server, h.inspector, hostname, DirectionServerToClient, h.logger, idGenerator)
+		serverReader = NewInspectReader(serverReader, h.inspector, hostname, DirectionServerToClient, h.logger, idGenerator)
 		// Use original connections for write operations
 		clientWriter = client
 		serverWriter = server
 	}
 
+	if h.cache != nil {
+		var emit func([]byte)
+		if h.inspector.ShouldInspect(hostname) {
+			emit = func(data []byte) {
+				if err := h.inspector.Inspect(DirectionServerToClient, data, hostname, connectionID, idGenerator.Current()); err != nil {
+					h.logger.Warn("inspect error", "error", err)
+				}
+			}
+		}
+		if err := h.relayCached(clientWriter, serverWriter, clientReader, serverReader, hostname, clientIP(client.RemoteAddr()), forwardedProto(client), emit, chaos); err != nil {
+			h.logger.Debug("Cached relay stopped", "hostname", hostname, "error", err)
+		}
+		if chaos.hasFaulted() {
+			// Surface the injected fault to the client as a closed connection
+			client.Close()
+			server.Close()
+		}
+		return nil
+	}
+
 	// Client -> Server
 	wg.Go(func() {
+		if h.forwardedMode != "" {
+			if err := forwardRequests(serverWriter, clientReader, clientIP(client.RemoteAddr()), forwardedProto(client), h.forwardedMode); err != nil {
+				h.logger.Debug("Forwarding requests stopped", "hostname", hostname, "error", err)
+			}
+			return
+		}
 		// Get buffer from pool
 		buffer := bufferPool.Get().([]byte)
 		defer bufferPool.Put(buffer)
@@ -213,6 +337,11 @@ func (h *ConnectionHandler) relayTraffic(client, server net.Conn, hostname strin
 		buffer := bufferPool.Get().([]byte)
 		defer bufferPool.Put(buffer)
 		_, _ = io.CopyBuffer(clientWriter, serverReader, buffer)
+		if chaos.hasFaulted() {
+			// Surface the injected fault to the client as a closed connection
+			client.Close()
+			server.Close()
+		}
 	})
 
 	wg.Wait()
@@ -0,0 +1,205 @@
+package mitm
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/monsterxx03/linko/pkg/clienthello"
+)
+
+// directUpstream dials targets directly
+type directUpstream struct{}
+
+func (directUpstream) Connect(host string, port int) (net.Conn, error) {
+	return nil, errors.New("not used")
+}
+
+func (directUpstream) IsEnabled() bool {
+	return false
+}
+
+func TestConnectionHandler_UpstreamHandshakeFailure(t *testing.T) {
+	caCert, caKey := generateTestCA(t)
+	scm, err := NewSiteCertManager(caCert, caKey, t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("NewSiteCertManager failed: %v", err)
+	}
+
+	// Upstream accepts TCP then drops the connection, failing the TLS handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	target := listener.Addr().(*net.TCPAddr)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	go tls.Client(clientConn, &tls.Config{ServerName: "pinned.example.com", InsecureSkipVerify: true}).Handshake()
+
+	peekReader := NewPeekReader(serverConn)
+	handler := NewConnectionHandler(scm, slog.Default(), directUpstream{}, NewInspectorChain(), peekReader)
+	err = handler.HandleConnection(serverConn, target.IP, target.Port)
+
+	var hsErr *UpstreamHandshakeError
+	if !errors.As(err, &hsErr) {
+		t.Fatalf("Expected UpstreamHandshakeError, got %v", err)
+	}
+	if !IsUpstreamHandshakeError(err) {
+		t.Error("Expected IsUpstreamHandshakeError to be true")
+	}
+	if hsErr.Hostname != "pinned.example.com" {
+		t.Errorf("Expected hostname pinned.example.com, got %s", hsErr.Hostname)
+	}
+
+	// The ClientHello must still be unconsumed so the caller can tunnel it raw
+	data, err := peekReader.Peek(peekReader.Buffered())
+	if err != nil {
+		t.Fatalf("Peek failed: %v", err)
+	}
+	sniInfo := clienthello.Parse(data)
+	if sniInfo.ParseError != nil {
+		t.Fatalf("Expected buffered ClientHello, got error: %v", sniInfo.ParseError)
+	}
+	if sniInfo.Hostname != "pinned.example.com" {
+		t.Errorf("Expected buffered SNI pinned.example.com, got %s", sniInfo.Hostname)
+	}
+
+	// Client connection must be left open for the fallback tunnel
+	serverConn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := serverConn.Write([]byte{0}); errors.Is(err, io.ErrClosedPipe) {
+		t.Error("Expected client connection to remain open after upstream handshake failure")
+	}
+}
+
+// upstreamHandshakeError runs a client handshake against a server that answers the ClientHello
+// with a fatal alert, or just closes when alert is 0
+func upstreamHandshakeError(alert byte) *UpstreamHandshakeError {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		defer serverConn.Close()
+		buf := make([]byte, DefaultBufferSize)
+		serverConn.Read(buf)
+		if alert != 0 {
+			serverConn.Write([]byte{21, 3, 3, 0, 2, 2, alert})
+		}
+	}()
+	err := tls.Client(clientConn, &tls.Config{ServerName: "pinned.example.com"}).Handshake()
+	return &UpstreamHandshakeError{Hostname: "pinned.example.com", Err: err}
+}
+
+func TestUpstreamHandshakeError_CertificateRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		alert byte
+		want  bool
+	}{
+		{"bad_certificate", 42, true},
+		{"unknown_ca", 48, true},
+		{"handshake_failure", 40, false},
+		{"connection closed", 0, false},
+	}
+	for _, tt := range tests {
+		if got := upstreamHandshakeError(tt.alert).CertificateRejected(); got != tt.want {
+			t.Errorf("CertificateRejected() for %s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientHandshakeError_CertificateRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"bad_certificate", &net.OpError{Op: "remote error", Err: alertBadCertificate}, true},
+		{"certificate_unknown", &net.OpError{Op: "remote error", Err: alertCertificateUnknown}, true},
+		{"unknown_ca", &net.OpError{Op: "remote error", Err: alertUnknownCA}, true},
+		{"reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{"handshake_failure", &net.OpError{Op: "remote error", Err: tls.AlertError(40)}, false},
+		{"connection closed", io.EOF, false},
+	}
+	for _, tt := range tests {
+		e := &ClientHandshakeError{Hostname: "pinned.example.com", Err: tt.err}
+		if got := e.CertificateRejected(); got != tt.want {
+			t.Errorf("CertificateRejected() for %s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConnectionHandler_KeyLog(t *testing.T) {
+	caCert, caKey := generateTestCA(t)
+	scm, err := NewSiteCertManager(caCert, caKey, t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("NewSiteCertManager failed: %v", err)
+	}
+	siteCert, err := scm.GetCertificate("example.com")
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+
+	// Key logging is off unless configured
+	handler := NewConnectionHandler(scm, slog.Default(), directUpstream{}, NewInspectorChain(), nil)
+	clientCfg, serverCfg := handler.tlsConfigs(siteCert, "example.com")
+	if clientCfg.KeyLogWriter != nil || serverCfg.KeyLogWriter != nil {
+		t.Fatal("Expected no key log writer by default")
+	}
+
+	var keyLog bytes.Buffer
+	handler.keyLog = &keyLog
+	clientCfg, serverCfg = handler.tlsConfigs(siteCert, "example.com")
+	if serverCfg.KeyLogWriter != &keyLog {
+		t.Error("Expected upstream TLS config to log keys")
+	}
+
+	// Handshake a client against the MITM side
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tls.Client(clientConn, &tls.Config{ServerName: "example.com", InsecureSkipVerify: true}).Handshake()
+	}()
+	if err := tls.Server(serverConn, clientCfg).Handshake(); err != nil {
+		t.Fatalf("Server handshake failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Client handshake failed: %v", err)
+	}
+
+	// NSS key log format: <label> <64 hex client random> <hex secret>
+	line := regexp.MustCompile(`^[A-Z0-9_]+ [0-9a-f]{64} [0-9a-f]+$`)
+	lines := strings.Split(strings.TrimSuffix(keyLog.String(), "\n"), "\n")
+	labels := make(map[string]bool)
+	for _, l := range lines {
+		if !line.MatchString(l) {
+			t.Errorf("Malformed key log line %q", l)
+		}
+		labels[strings.Fields(l)[0]] = true
+	}
+	for _, label := range []string{"CLIENT_HANDSHAKE_TRAFFIC_SECRET", "SERVER_HANDSHAKE_TRAFFIC_SECRET", "CLIENT_TRAFFIC_SECRET_0", "SERVER_TRAFFIC_SECRET_0"} {
+		if !labels[label] {
+			t.Errorf("Expected %s in key log, got %q", label, keyLog.String())
+		}
+	}
+}
@@ -1,9 +1,20 @@
 package mitm
 
+import "time"
+
 const (
 	// DefaultMaxBodySize 默认最大请求/响应体大小 (2M)
 	DefaultMaxBodySize = 2097152
 
+	// DefaultMaxHeaderSize 默认最大 HTTP 头大小 (64KB)，超过后放弃解析直接透传
+	DefaultMaxHeaderSize = 64 * 1024
+
+	// DefaultMaxChunkedBodySize 默认 chunked 请求体上限 (32M)，超过终止符仍未出现时丢弃缓冲直接透传
+	DefaultMaxChunkedBodySize = 32 * 1024 * 1024
+
 	// DefaultBufferSize 默认缓冲区大小 (16KB)
 	DefaultBufferSize = 16 * 1024
+
+	// certRenewInterval 站点证书到期续签的检查间隔
+	certRenewInterval = time.Minute
 )
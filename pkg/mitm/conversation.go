@@ -0,0 +1,54 @@
+package mitm
+
+import (
+	"time"
+
+	"github.com/monsterxx03/linko/pkg/mitm/llm"
+)
+
+// EventConversationID returns the conversation an LLM event belongs to, empty if none
+func EventConversationID(event *TrafficEvent) string {
+	switch extra := event.Extra.(type) {
+	case *llm.LLMMessageEvent:
+		return extra.ConversationID
+	case *llm.LLMTokenEvent:
+		return extra.ConversationID
+	case *llm.ConversationUpdateEvent:
+		return extra.ConversationID
+	case map[string]any:
+		id, _ := extra["conversation_id"].(string)
+		return id
+	}
+	return ""
+}
+
+// DeleteConversation removes a conversation's events from the bus history and notifies
+// subscribers. It returns the number of events removed, 0 means the conversation was unknown.
+func DeleteConversation(bus *EventBus, conversationID string) int {
+	removed := bus.RemoveHistory(func(ev *TrafficEvent) bool {
+		return EventConversationID(ev) == conversationID
+	})
+	if removed > 0 {
+		publishConversationDeleted(bus, &llm.ConversationDeletedEvent{ConversationID: conversationID})
+	}
+	return removed
+}
+
+// ClearConversations removes all captured LLM events from the bus history and notifies subscribers
+func ClearConversations(bus *EventBus) int {
+	removed := bus.RemoveHistory(func(*TrafficEvent) bool { return true })
+	publishConversationDeleted(bus, &llm.ConversationDeletedEvent{All: true})
+	return removed
+}
+
+func publishConversationDeleted(bus *EventBus, event *llm.ConversationDeletedEvent) {
+	event.ID = generateEventID()
+	event.Timestamp = time.Now()
+	// Not kept in history, a later subscriber never sees the deleted events anyway
+	bus.Broadcast(&TrafficEvent{
+		ID:        event.ID,
+		Timestamp: event.Timestamp,
+		Direction: "conversation_deleted",
+		Extra:     event,
+	})
+}
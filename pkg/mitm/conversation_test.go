@@ -0,0 +1,84 @@
+package mitm
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/monsterxx03/linko/pkg/mitm/llm"
+)
+
+func publishConversationMessage(bus *EventBus, conversationID string) {
+	bus.Publish(&TrafficEvent{
+		Direction: "llm_message",
+		Extra:     &llm.LLMMessageEvent{ConversationID: conversationID},
+	})
+}
+
+// replayed collects the history a new subscriber receives
+func replayed(t *testing.T, bus *EventBus) []*TrafficEvent {
+	t.Helper()
+	sub := bus.Subscribe()
+	defer bus.Unsubscribe(sub)
+	var events []*TrafficEvent
+	for {
+		select {
+		case ev := <-sub.Channel:
+			events = append(events, ev)
+		case <-time.After(50 * time.Millisecond):
+			return events
+		}
+	}
+}
+
+func TestDeleteConversation(t *testing.T) {
+	bus := NewEventBus(slog.Default(), 100)
+	publishConversationMessage(bus, "conv-a")
+	publishConversationMessage(bus, "conv-b")
+	bus.Publish(&TrafficEvent{
+		Direction: "conversation",
+		Extra:     &llm.ConversationUpdateEvent{ConversationID: "conv-a", Status: "complete"},
+	})
+
+	live := bus.Subscribe()
+	defer bus.Unsubscribe(live)
+	for range 3 {
+		<-live.Channel
+	}
+
+	if removed := DeleteConversation(bus, "conv-a"); removed != 2 {
+		t.Fatalf("Expected 2 events removed, got %d", removed)
+	}
+
+	select {
+	case ev := <-live.Channel:
+		deleted, ok := ev.Extra.(*llm.ConversationDeletedEvent)
+		if ev.Direction != "conversation_deleted" || !ok || deleted.ConversationID != "conv-a" {
+			t.Errorf("Expected conversation_deleted for conv-a, got %s %+v", ev.Direction, ev.Extra)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected conversation_deleted to be broadcast")
+	}
+
+	events := replayed(t, bus)
+	if len(events) != 1 || EventConversationID(events[0]) != "conv-b" {
+		t.Errorf("Expected only conv-b to remain in history, got %d events", len(events))
+	}
+
+	if removed := DeleteConversation(bus, "missing"); removed != 0 {
+		t.Errorf("Expected unknown conversation to remove nothing, got %d", removed)
+	}
+}
+
+func TestClearConversations(t *testing.T) {
+	bus := NewEventBus(slog.Default(), 100)
+	publishConversationMessage(bus, "conv-a")
+	publishConversationMessage(bus, "conv-b")
+
+	if removed := ClearConversations(bus); removed != 2 {
+		t.Fatalf("Expected 2 events removed, got %d", removed)
+	}
+	if events := replayed(t, bus); len(events) != 0 {
+		t.Errorf("Expected empty history after clear, got %d events", len(events))
+	}
+}
@@ -21,24 +21,40 @@ type TrafficEvent struct {
 
 // HTTPRequest represents an HTTP request
 type HTTPRequest struct {
-	Method        string            `json:"method"`         // HTTP method
-	URL           string            `json:"url"`            // Request URL
-	Host          string            `json:"host"`           // Request host
-	Headers       map[string]string `json:"headers"`        // Request headers
-	Body          string            `json:"body"`           // Request body (truncated)
-	ContentType   string            `json:"content_type"`   // Content-Type header
-	ContentLength int64             `json:"content_length"` // Content-Length header
+	Method        string              `json:"method"`                 // HTTP method
+	URL           string              `json:"url"`                    // Request URL
+	Query         map[string][]string `json:"query,omitempty"`        // Parsed query parameters
+	FormFields    map[string][]string `json:"form_fields,omitempty"`  // Parsed URL-encoded form body
+	Host          string              `json:"host"`                   // Request host
+	Headers       map[string]string   `json:"headers"`                // Request headers
+	Body          string              `json:"body"`                   // Request body (truncated)
+	BodyPreview   *BodyPreview        `json:"body_preview,omitempty"` // Summary of a binary body, Body is then empty
+	RawBody       []byte              `json:"raw_body,omitempty"`     // Body before content decoding, base64 in JSON
+	ContentType   string              `json:"content_type"`           // Content-Type header
+	ContentLength int64               `json:"content_length"`         // Content-Length header
+	Trailers      map[string]string   `json:"trailers,omitempty"`     // Chunked trailer fields
 }
 
 // HTTPResponse represents an HTTP response
 type HTTPResponse struct {
-	Status        string            `json:"status"`         // Status line
-	StatusCode    int               `json:"status_code"`    // Status code
-	Headers       map[string]string `json:"headers"`        // Response headers
-	Body          string            `json:"body"`           // Response body (truncated)
-	ContentType   string            `json:"content_type"`   // Content-Type header
-	ContentLength int64             `json:"content_length"` // Content-Length header
-	Latency       int64             `json:"latency"`        // Response latency in milliseconds
+	Status        string            `json:"status"`                 // Status line
+	StatusCode    int               `json:"status_code"`            // Status code
+	Headers       map[string]string `json:"headers"`                // Response headers
+	Body          string            `json:"body"`                   // Response body (truncated)
+	BodyPreview   *BodyPreview      `json:"body_preview,omitempty"` // Summary of a binary body, Body is then empty
+	RawBody       []byte            `json:"raw_body,omitempty"`     // Body before content decoding, base64 in JSON
+	ContentType   string            `json:"content_type"`           // Content-Type header
+	ContentLength int64             `json:"content_length"`         // Content-Length header
+	Latency       int64             `json:"latency"`                // Response latency in milliseconds
+	Trailers      map[string]string `json:"trailers,omitempty"`     // Chunked trailer fields, e.g. grpc-status
+	Cached        bool              `json:"cached,omitempty"`       // Served from the MITM response cache
+}
+
+// BodyPreview summarizes a binary body (image, protobuf, ...) instead of carrying it in the event
+type BodyPreview struct {
+	ContentType string `json:"content_type"` // Declared content type, sniffed when missing
+	Size        int64  `json:"size"`         // Full body size in bytes
+	Head        string `json:"head"`         // First bytes of the body, hex encoded
 }
 
 // Subscriber represents an event subscriber
@@ -55,8 +71,13 @@ type EventBus struct {
 	logger      *slog.Logger         // Logger for error and warning messages
 	history     []*TrafficEvent      // Historical events for replay
 	historySize int                  // Maximum number of historical events to keep
+	bufferSize  int                  // Default channel buffer for new subscribers
+	closed      bool                 // Set by Close, no more events are delivered
 }
 
+// DefaultSubscriberBufferSize is the channel buffer of a subscriber when none is configured
+const DefaultSubscriberBufferSize = 100
+
 // NewEventBus creates a new EventBus with the specified history size
 func NewEventBus(logger *slog.Logger, historySize int) *EventBus {
 	if historySize <= 0 {
@@ -67,11 +88,33 @@ func NewEventBus(logger *slog.Logger, historySize int) *EventBus {
 		logger:      logger,
 		history:     make([]*TrafficEvent, 0, historySize),
 		historySize: historySize,
+		bufferSize:  DefaultSubscriberBufferSize,
 	}
 }
 
+// SetBufferSize sets the channel buffer for subscribers created afterwards, <= 0 restores the default.
+// Events are dropped for a subscriber whose buffer is full.
+func (eb *EventBus) SetBufferSize(size int) {
+	if size <= 0 {
+		size = DefaultSubscriberBufferSize
+	}
+	eb.mu.Lock()
+	eb.bufferSize = size
+	eb.mu.Unlock()
+}
+
 // Publish publishes a traffic event to all subscribers
 func (eb *EventBus) Publish(event *TrafficEvent) {
+	eb.publish(event, false)
+}
+
+// Replace publishes event like Publish, but an event with the same ID still in history is
+// replaced in place instead of followed by a second entry
+func (eb *EventBus) Replace(event *TrafficEvent) {
+	eb.publish(event, true)
+}
+
+func (eb *EventBus) publish(event *TrafficEvent, replace bool) {
 	// Generate a unique ID if not provided
 	if event.ID == "" {
 		event.ID = time.Now().Format("20060102150405.000000") + "-" + event.Hostname
@@ -84,14 +127,57 @@ func (eb *EventBus) Publish(event *TrafficEvent) {
 
 	// Lock for writing
 	eb.mu.Lock()
+	if eb.closed {
+		eb.mu.Unlock()
+		return
+	}
 
 	// Add to history (keep only the latest N events)
-	eb.history = append(eb.history, event)
-	if len(eb.history) > eb.historySize {
-		eb.history = eb.history[len(eb.history)-eb.historySize:]
+	i := -1
+	if replace {
+		i = eb.historyIndex(event.ID)
+	}
+	if i >= 0 {
+		eb.history[i] = event
+	} else {
+		eb.history = append(eb.history, event)
+		if len(eb.history) > eb.historySize {
+			eb.history = eb.history[len(eb.history)-eb.historySize:]
+		}
+	}
+
+	eb.broadcastLocked(event)
+	eb.mu.Unlock()
+}
+
+// Broadcast sends an event to current subscribers without recording it in history,
+// for notifications that must not be replayed to later subscribers
+func (eb *EventBus) Broadcast(event *TrafficEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	if eb.closed {
+		return
+	}
+	eb.broadcastLocked(event)
+}
+
+// historyIndex returns the position of the latest event with id in history, -1 if there is none.
+// eb.mu must be held
+func (eb *EventBus) historyIndex(id string) int {
+	for i := len(eb.history) - 1; i >= 0; i-- {
+		if eb.history[i].ID == id {
+			return i
+		}
 	}
+	return -1
+}
 
-	// Publish to all subscribers
+// broadcastLocked delivers event to all subscribers, eb.mu must be held
+func (eb *EventBus) broadcastLocked(event *TrafficEvent) {
 	for subscriber := range eb.subscribers {
 		select {
 		case subscriber.Channel <- event:
@@ -104,19 +190,47 @@ func (eb *EventBus) Publish(event *TrafficEvent) {
 				"hostname", event.Hostname)
 		}
 	}
+}
 
-	eb.mu.Unlock()
+// RemoveHistory drops historical events for which match returns true and returns how many were removed
+func (eb *EventBus) RemoveHistory(match func(*TrafficEvent) bool) int {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	kept := eb.history[:0]
+	for _, ev := range eb.history {
+		if !match(ev) {
+			kept = append(kept, ev)
+		}
+	}
+	removed := len(eb.history) - len(kept)
+	clear(eb.history[len(kept):])
+	eb.history = kept
+	return removed
 }
 
-// Subscribe creates a new subscriber and returns it
+// Subscribe creates a new subscriber with the bus's buffer size and returns it
 func (eb *EventBus) Subscribe() *Subscriber {
+	return eb.subscribe(0)
+}
+
+// subscribe creates a subscriber with a bufferSize channel, <= 0 uses the bus's buffer size
+func (eb *EventBus) subscribe(bufferSize int) *Subscriber {
+	eb.mu.Lock()
+	if bufferSize <= 0 {
+		bufferSize = eb.bufferSize
+	}
 	subscriber := &Subscriber{
 		ID:      time.Now().Format("20060102150405.000000") + "-sub",
-		Name:    "",                            // Name can be set by caller for logging
-		Channel: make(chan *TrafficEvent, 100), // Buffered channel to prevent blocking
+		Name:    "",                                   // Name can be set by caller for logging
+		Channel: make(chan *TrafficEvent, bufferSize), // Buffered channel to prevent blocking
 	}
 
-	eb.mu.Lock()
+	if eb.closed {
+		eb.mu.Unlock()
+		close(subscriber.Channel)
+		return subscriber
+	}
 	eb.subscribers[subscriber] = true
 
 	// Copy historical events for replay
@@ -126,6 +240,12 @@ func (eb *EventBus) Subscribe() *Subscriber {
 
 	// Send historical events in a new goroutine (oldest to newest)
 	go func() {
+		// Hold the read lock so the channel can't be closed by Unsubscribe/Close meanwhile
+		eb.mu.RLock()
+		defer eb.mu.RUnlock()
+		if !eb.subscribers[subscriber] {
+			return
+		}
 		for _, ev := range historicalEvents {
 			select {
 			case subscriber.Channel <- ev:
@@ -138,9 +258,10 @@ func (eb *EventBus) Subscribe() *Subscriber {
 	return subscriber
 }
 
-// SubscribeWithName creates a new subscriber with a given name for logging
-func (eb *EventBus) SubscribeWithName(name string) *Subscriber {
-	subscriber := eb.Subscribe()
+// SubscribeWithName creates a new subscriber with a given name for logging and channel buffer size,
+// bufferSize <= 0 uses the bus's buffer size
+func (eb *EventBus) SubscribeWithName(name string, bufferSize int) *Subscriber {
+	subscriber := eb.subscribe(bufferSize)
 	subscriber.Name = name
 	return subscriber
 }
@@ -155,6 +276,22 @@ func (eb *EventBus) Unsubscribe(subscriber *Subscriber) {
 	eb.mu.Unlock()
 }
 
+// Close closes all subscriber channels so readers can drain buffered events and exit.
+// Events published after Close are dropped.
+func (eb *EventBus) Close() {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	if eb.closed {
+		return
+	}
+	eb.closed = true
+	for subscriber := range eb.subscribers {
+		close(subscriber.Channel)
+	}
+	clear(eb.subscribers)
+}
+
 // GetSubscriberCount returns the number of active subscribers
 func (eb *EventBus) GetSubscriberCount() int {
 	eb.mu.RLock()
@@ -0,0 +1,84 @@
+package mitm
+
+import (
+	"log/slog"
+	"slices"
+	"testing"
+)
+
+func TestEventBus_CloseDrainsSubscribers(t *testing.T) {
+	eb := NewEventBus(slog.Default(), 10)
+	sub := eb.Subscribe()
+
+	for _, host := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		eb.Publish(&TrafficEvent{Hostname: host})
+	}
+	eb.Close()
+
+	var got []string
+	for event := range sub.Channel {
+		got = append(got, event.Hostname)
+	}
+	if len(got) != 3 {
+		t.Errorf("Expected 3 buffered events before channel close, got %v", got)
+	}
+
+	// Publishing, subscribing and unsubscribing after close must not panic
+	eb.Publish(&TrafficEvent{Hostname: "late.example.com"})
+	eb.Unsubscribe(sub)
+	if _, ok := <-eb.Subscribe().Channel; ok {
+		t.Error("Expected subscriber channel to be closed after bus close")
+	}
+	if count := eb.GetSubscriberCount(); count != 0 {
+		t.Errorf("Expected no subscribers after close, got %d", count)
+	}
+}
+
+func TestEventBus_BufferSize(t *testing.T) {
+	eb := NewEventBus(slog.Default(), 10)
+	if got := cap(eb.Subscribe().Channel); got != DefaultSubscriberBufferSize {
+		t.Errorf("Expected default buffer size %d, got %d", DefaultSubscriberBufferSize, got)
+	}
+
+	eb.SetBufferSize(500)
+	sub := eb.Subscribe()
+	if got := cap(sub.Channel); got != 500 {
+		t.Errorf("Expected configured buffer size 500, got %d", got)
+	}
+	named := eb.SubscribeWithName("burst", 1000)
+	if got := cap(named.Channel); got != 1000 {
+		t.Errorf("Expected per-subscriber buffer size 1000, got %d", got)
+	}
+
+	// A burst that fits in the buffer is delivered in full to a subscriber that isn't reading yet
+	for range 500 {
+		eb.Broadcast(&TrafficEvent{Hostname: "burst.example.com"})
+	}
+	if got := len(sub.Channel); got != 500 {
+		t.Errorf("Expected 500 buffered events, got %d", got)
+	}
+	if got := len(named.Channel); got != 500 {
+		t.Errorf("Expected 500 buffered events for named subscriber, got %d", got)
+	}
+}
+
+func TestEventBus_Replace(t *testing.T) {
+	eb := NewEventBus(slog.Default(), 10)
+	eb.Publish(&TrafficEvent{ID: "req-1", Direction: "client->server"})
+	eb.Publish(&TrafficEvent{ID: "req-2"})
+	eb.Replace(&TrafficEvent{ID: "req-1", Direction: "server->client"})
+	// Nothing to replace, appended like Publish
+	eb.Replace(&TrafficEvent{ID: "req-3"})
+
+	var ids, directions []string
+	for _, ev := range eb.history {
+		ids = append(ids, ev.ID)
+		directions = append(directions, ev.Direction)
+	}
+	if !slices.Equal(ids, []string{"req-1", "req-2", "req-3"}) {
+		t.Fatalf("Expected the replaced event to keep its place, got %v", ids)
+	}
+	if directions[0] != "server->client" {
+		t.Errorf("Expected req-1 to be replaced, got direction %q", directions[0])
+	}
+}
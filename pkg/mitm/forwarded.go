@@ -0,0 +1,133 @@
+package mitm
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+)
+
+// X-Forwarded-For modes
+const (
+	ForwardedAppend  = "append"  // Append the client IP to an existing X-Forwarded-For chain
+	ForwardedReplace = "replace" // Overwrite X-Forwarded-For and X-Forwarded-Proto
+)
+
+// forwardRequests copies HTTP/1.x requests from src to dst, adding X-Forwarded-For with clientIP
+// and X-Forwarded-Proto with proto. Non-HTTP data and anything after a protocol upgrade is copied raw.
+func forwardRequests(dst io.Writer, src io.Reader, clientIP, proto, mode string) error {
+	br := bufio.NewReaderSize(src, DefaultBufferSize)
+	for {
+		prefix, err := br.Peek(8)
+		if len(prefix) == 0 {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if !isHTTPPrefix(prefix) {
+			_, err := io.Copy(dst, br)
+			return err
+		}
+
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return err
+		}
+		setForwardedHeaders(req.Header, clientIP, proto, mode)
+		if err := writeRequest(dst, req); err != nil {
+			return err
+		}
+
+		if req.Header.Get("Upgrade") != "" || req.Method == http.MethodConnect {
+			_, err := io.Copy(dst, br)
+			return err
+		}
+	}
+}
+
+// setForwardedHeaders applies X-Forwarded-For and X-Forwarded-Proto according to mode
+func setForwardedHeaders(header http.Header, clientIP, proto, mode string) {
+	if mode == ForwardedReplace {
+		header.Set("X-Forwarded-For", clientIP)
+		header.Set("X-Forwarded-Proto", proto)
+		return
+	}
+
+	// Multiple X-Forwarded-For lines are one comma-separated list
+	if prior := header.Values("X-Forwarded-For"); len(prior) > 0 {
+		header.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+clientIP)
+	} else {
+		header.Set("X-Forwarded-For", clientIP)
+	}
+	if header.Get("X-Forwarded-Proto") == "" {
+		header.Set("X-Forwarded-Proto", proto)
+	}
+}
+
+// writeRequest writes req as received, flushing the head before the body so
+// "Expect: 100-continue" clients are not stalled by buffering
+func writeRequest(dst io.Writer, req *http.Request) error {
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "%s %s HTTP/%d.%d\r\n", req.Method, req.RequestURI, req.ProtoMajor, req.ProtoMinor)
+	if req.Host != "" {
+		fmt.Fprintf(&head, "Host: %s\r\n", req.Host)
+	}
+	chunked := len(req.TransferEncoding) > 0 && req.TransferEncoding[0] == "chunked"
+	if chunked {
+		head.WriteString("Transfer-Encoding: chunked\r\n")
+	}
+	if err := req.Header.Write(&head); err != nil {
+		return err
+	}
+	head.WriteString("\r\n")
+	if _, err := dst.Write(head.Bytes()); err != nil {
+		return err
+	}
+
+	if !chunked {
+		_, err := io.Copy(dst, req.Body)
+		return err
+	}
+
+	cw := httputil.NewChunkedWriter(dst)
+	if _, err := io.Copy(cw, req.Body); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	// Trailers are only populated once the body has been read to EOF
+	var tail bytes.Buffer
+	if err := req.Trailer.Write(&tail); err != nil {
+		return err
+	}
+	tail.WriteString("\r\n")
+	_, err := dst.Write(tail.Bytes())
+	return err
+}
+
+// forwardedProto returns the scheme the client used, "https" unless its connection isn't TLS
+func forwardedProto(client net.Conn) string {
+	if _, ok := client.(*tls.Conn); ok {
+		return "https"
+	}
+	return "http"
+}
+
+// clientIP returns the host part of addr
+func clientIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
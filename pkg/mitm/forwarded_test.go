@@ -0,0 +1,113 @@
+package mitm
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestForwardRequests_AddsHeaders(t *testing.T) {
+	src := strings.NewReader("GET /v1/models HTTP/1.1\r\nHost: api.example.com\r\n\r\n")
+	var dst bytes.Buffer
+	if err := forwardRequests(&dst, src, "10.0.0.5", "https", ForwardedAppend); err != nil {
+		t.Fatalf("forwardRequests failed: %v", err)
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(&dst))
+	if err != nil {
+		t.Fatalf("Failed to parse forwarded request: %v", err)
+	}
+	if got := req.Header.Get("X-Forwarded-For"); got != "10.0.0.5" {
+		t.Errorf("Expected X-Forwarded-For 10.0.0.5, got %q", got)
+	}
+	if got := req.Header.Get("X-Forwarded-Proto"); got != "https" {
+		t.Errorf("Expected X-Forwarded-Proto https, got %q", got)
+	}
+	if req.Host != "api.example.com" || req.RequestURI != "/v1/models" {
+		t.Errorf("Request line or host changed: %s %s", req.Host, req.RequestURI)
+	}
+}
+
+func TestForwardRequests_Modes(t *testing.T) {
+	raw := "GET / HTTP/1.1\r\nHost: example.com\r\nX-Forwarded-For: 1.2.3.4\r\nX-Forwarded-Proto: http\r\n\r\n"
+	tests := []struct {
+		mode  string
+		xff   string
+		proto string
+	}{
+		{ForwardedAppend, "1.2.3.4, 10.0.0.5", "http"},
+		{ForwardedReplace, "10.0.0.5", "https"},
+	}
+	for _, tt := range tests {
+		var dst bytes.Buffer
+		if err := forwardRequests(&dst, strings.NewReader(raw), "10.0.0.5", "https", tt.mode); err != nil {
+			t.Fatalf("%s: forwardRequests failed: %v", tt.mode, err)
+		}
+		req, err := http.ReadRequest(bufio.NewReader(&dst))
+		if err != nil {
+			t.Fatalf("%s: failed to parse forwarded request: %v", tt.mode, err)
+		}
+		if got := req.Header.Get("X-Forwarded-For"); got != tt.xff {
+			t.Errorf("%s: expected X-Forwarded-For %q, got %q", tt.mode, tt.xff, got)
+		}
+		if got := req.Header.Get("X-Forwarded-Proto"); got != tt.proto {
+			t.Errorf("%s: expected X-Forwarded-Proto %q, got %q", tt.mode, tt.proto, got)
+		}
+	}
+}
+
+func TestForwardRequests_BodiesAndPipelining(t *testing.T) {
+	raw := "POST /a HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nhello" +
+		"POST /b HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nfoo\r\n3\r\nbar\r\n0\r\n\r\n"
+	var dst bytes.Buffer
+	if err := forwardRequests(&dst, strings.NewReader(raw), "10.0.0.5", "https", ForwardedAppend); err != nil {
+		t.Fatalf("forwardRequests failed: %v", err)
+	}
+
+	br := bufio.NewReader(&dst)
+	for _, want := range []string{"hello", "foobar"} {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			t.Fatalf("Failed to parse forwarded request: %v", err)
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Fatalf("Failed to read body: %v", err)
+		}
+		if string(body) != want {
+			t.Errorf("Expected body %q, got %q", want, body)
+		}
+		if req.Header.Get("X-Forwarded-For") != "10.0.0.5" {
+			t.Errorf("Missing X-Forwarded-For on %s", req.RequestURI)
+		}
+	}
+}
+
+func TestForwardRequests_NonHTTPPassthrough(t *testing.T) {
+	raw := "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+	var dst bytes.Buffer
+	if err := forwardRequests(&dst, strings.NewReader(raw), "10.0.0.5", "https", ForwardedAppend); err != nil {
+		t.Fatalf("forwardRequests failed: %v", err)
+	}
+	if dst.String() != raw {
+		t.Errorf("Non-HTTP data was modified: %q", dst.String())
+	}
+}
+
+func TestForwardedProto(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	if got := forwardedProto(serverConn); got != "http" {
+		t.Errorf("Expected http for a plain connection, got %q", got)
+	}
+	if got := forwardedProto(tls.Server(serverConn, &tls.Config{})); got != "https" {
+		t.Errorf("Expected https for a TLS connection, got %q", got)
+	}
+}
@@ -0,0 +1,115 @@
+package mitm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// grpcCodeNames are the canonical names of gRPC status codes, indexed by code
+var grpcCodeNames = []string{
+	"OK",
+	"CANCELLED",
+	"UNKNOWN",
+	"INVALID_ARGUMENT",
+	"DEADLINE_EXCEEDED",
+	"NOT_FOUND",
+	"ALREADY_EXISTS",
+	"PERMISSION_DENIED",
+	"RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION",
+	"ABORTED",
+	"OUT_OF_RANGE",
+	"UNIMPLEMENTED",
+	"INTERNAL",
+	"UNAVAILABLE",
+	"DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+// grpcStatus is the outcome of a gRPC call carried in grpc-status/grpc-message
+type grpcStatus struct {
+	Code    int
+	Message string
+}
+
+// CodeName returns the canonical name of the status code, e.g. RESOURCE_EXHAUSTED
+func (s grpcStatus) CodeName() string {
+	if s.Code >= 0 && s.Code < len(grpcCodeNames) {
+		return grpcCodeNames[s.Code]
+	}
+	return "CODE_" + strconv.Itoa(s.Code)
+}
+
+// decodeGRPCStatus finds the gRPC status of a response: in HTTP trailers, in the trailer frame
+// of a gRPC-Web body, or in the headers of a trailers-only response
+func decodeGRPCStatus(httpMsg *HTTPMessage) (grpcStatus, bool) {
+	if status, ok := parseGRPCStatus(httpMsg.Trailers); ok {
+		return status, true
+	}
+	if strings.HasPrefix(httpMsg.ContentType, "application/grpc-web") {
+		if status, ok := parseGRPCStatus(grpcWebTrailers(httpMsg.ContentType, httpMsg.Body)); ok {
+			return status, true
+		}
+	}
+	return parseGRPCStatus(httpMsg.Headers)
+}
+
+// parseGRPCStatus reads grpc-status and the percent-encoded grpc-message from canonical fields
+func parseGRPCStatus(fields map[string]string) (grpcStatus, bool) {
+	value, exists := fields["Grpc-Status"]
+	if !exists {
+		return grpcStatus{}, false
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return grpcStatus{}, false
+	}
+	message := fields["Grpc-Message"]
+	if decoded, err := url.PathUnescape(message); err == nil {
+		message = decoded
+	}
+	return grpcStatus{Code: code, Message: message}, true
+}
+
+// grpcWebTrailers extracts the fields of the trailer frame (flag 0x80) of a gRPC-Web body,
+// grpc-web-text bodies are base64 encoded
+func grpcWebTrailers(contentType string, body []byte) map[string]string {
+	if strings.HasPrefix(contentType, "application/grpc-web-text") {
+		decoded := make([]byte, base64.StdEncoding.DecodedLen(len(body)))
+		n, err := base64.StdEncoding.Decode(decoded, bytes.TrimSpace(body))
+		if err != nil {
+			return nil
+		}
+		body = decoded[:n]
+	}
+
+	for len(body) >= 5 {
+		flag := body[0]
+		size := int(binary.BigEndian.Uint32(body[1:5]))
+		if len(body)-5 < size {
+			return nil
+		}
+		frame := body[5 : 5+size]
+		body = body[5+size:]
+		if flag&0x80 == 0 {
+			continue
+		}
+
+		fields := make(map[string]string)
+		scanner := bufio.NewScanner(bytes.NewReader(frame))
+		for scanner.Scan() {
+			name, value, found := strings.Cut(scanner.Text(), ":")
+			if found {
+				fields[textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))] = strings.TrimSpace(value)
+			}
+		}
+		return fields
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package mitm
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestDecodeGRPCStatus(t *testing.T) {
+	trailerFrame := string([]byte{0x80, 0, 0, 0, 17}) + "grpc-status: 14\r\n"
+	tests := []struct {
+		name   string
+		msg    *HTTPMessage
+		want   grpcStatus
+		wantOK bool
+	}{
+		{
+			name: "HTTP trailers",
+			msg: &HTTPMessage{
+				ContentType: "application/grpc",
+				Trailers:    map[string]string{"Grpc-Status": "7", "Grpc-Message": "missing%20scope"},
+			},
+			want:   grpcStatus{Code: 7, Message: "missing scope"},
+			wantOK: true,
+		},
+		{
+			name: "gRPC-Web trailer frame after a data frame",
+			msg: &HTTPMessage{
+				ContentType: "application/grpc-web+proto",
+				Body:        []byte(string([]byte{0, 0, 0, 0, 2}) + "hi" + trailerFrame),
+			},
+			want:   grpcStatus{Code: 14},
+			wantOK: true,
+		},
+		{
+			name: "gRPC-Web text",
+			msg: &HTTPMessage{
+				ContentType: "application/grpc-web-text",
+				Body:        []byte(base64.StdEncoding.EncodeToString([]byte(trailerFrame))),
+			},
+			want:   grpcStatus{Code: 14},
+			wantOK: true,
+		},
+		{
+			name: "trailers-only response",
+			msg: &HTTPMessage{
+				ContentType: "application/grpc",
+				Headers:     map[string]string{"Grpc-Status": "0"},
+			},
+			want:   grpcStatus{Code: 0},
+			wantOK: true,
+		},
+		{
+			name:   "no status",
+			msg:    &HTTPMessage{ContentType: "application/json", Body: []byte(`{}`)},
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := decodeGRPCStatus(tt.msg)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("decodeGRPCStatus() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGRPCStatusCodeName(t *testing.T) {
+	for code, want := range map[int]string{0: "OK", 8: "RESOURCE_EXHAUSTED", 16: "UNAUTHENTICATED", 99: "CODE_99"} {
+		if got := (grpcStatus{Code: code}).CodeName(); got != want {
+			t.Errorf("CodeName(%d) = %s, want %s", code, got, want)
+		}
+	}
+}
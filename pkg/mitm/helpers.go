@@ -8,6 +8,7 @@ import (
 	"io"
 	"slices"
 	"strings"
+	"unicode/utf8"
 
 	"log/slog"
 
@@ -27,6 +28,20 @@ var readableAppTypes = []string{
 	"application/svg+xml",
 	"application/proto",
 	"application/connect+proto",
+	"application/x-ndjson",
+	"application/ndjson",
+	"application/yaml",
+	"application/x-yaml",
+	"application/csv",
+}
+
+// readableTypeSuffixes are structured syntax suffixes (RFC 6839) of text-based formats, e.g. application/soap+xml
+var readableTypeSuffixes = []string{"+json", "+xml", "+yaml"}
+
+// isNDJSONContentType checks if the content type is a newline-delimited JSON stream
+func isNDJSONContentType(contentType string) bool {
+	contentType = strings.TrimSpace(strings.ToLower(strings.Split(contentType, ";")[0]))
+	return contentType == "application/x-ndjson" || contentType == "application/ndjson"
 }
 
 func isHTTPPrefix(data []byte) bool {
@@ -82,9 +97,45 @@ func isReadableTextType(contentType string) bool {
 	}
 
 	// Check if it's a common text-based application type
-	return slices.Contains(readableAppTypes, contentType)
+	if slices.Contains(readableAppTypes, contentType) {
+		return true
+	}
+	for _, suffix := range readableTypeSuffixes {
+		if strings.HasSuffix(contentType, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBinaryBody reports whether body is shown as a preview instead of text. Bodies without a
+// Content-Type or with one not known to be readable (e.g. application/graphql) are sniffed
+func isBinaryBody(contentType string, body []byte) bool {
+	if isReadableTextType(contentType) {
+		return false
+	}
+	return !looksLikeText(body)
+}
+
+// looksLikeText reports whether body is valid UTF-8 without control bytes other than whitespace
+func looksLikeText(body []byte) bool {
+	for _, c := range body {
+		if (c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != '\f') || c == 0x7f {
+			return false
+		}
+	}
+	return utf8.Valid(body)
 }
 
+// isFormContentType checks if the content type is an URL-encoded HTML form
+func isFormContentType(contentType string) bool {
+	contentType = strings.TrimSpace(strings.ToLower(strings.Split(contentType, ";")[0]))
+	return contentType == "application/x-www-form-urlencoded"
+}
+
+// maxContentEncodings caps how many nested Content-Encoding layers are decoded
+const maxContentEncodings = 4
+
 func decompressBody(body []byte, contentEncoding string, contentType string, logger *slog.Logger) []byte {
 	if contentEncoding == "" {
 		return body
@@ -98,44 +149,69 @@ func decompressBody(body []byte, contentEncoding string, contentType string, log
 		return body
 	}
 
-	var decompressed []byte
-	var err error
-
-	switch strings.ToLower(contentEncoding) {
-	case "gzip":
-		reader, err := gzip.NewReader(bytes.NewReader(body))
-		if err != nil {
-			logger.Warn("Failed to create gzip reader", "error", err)
-			return body
+	// Encodings are listed in the order they were applied, e.g. "gzip, br"
+	var encodings []string
+	for enc := range strings.SplitSeq(contentEncoding, ",") {
+		enc = strings.ToLower(strings.TrimSpace(enc))
+		// identity means no transformation was applied
+		if enc != "" && enc != "identity" {
+			encodings = append(encodings, enc)
 		}
-		defer reader.Close()
-		decompressed, err = io.ReadAll(reader)
-	case "deflate":
-		reader := flate.NewReader(bytes.NewReader(body))
-		defer reader.Close()
-		decompressed, err = io.ReadAll(reader)
-	case "br":
-		reader := brotli.NewReader(bytes.NewReader(body))
-		decompressed, err = io.ReadAll(reader)
-	default:
+	}
+	if len(encodings) > maxContentEncodings {
+		logger.Warn("Too many nested content encodings", "contentEncoding", contentEncoding)
 		return body
 	}
 
-	if err != nil {
-		// For SSE, try to return whatever we managed to decompress
-		if isSSE {
-			if len(decompressed) > 0 {
-				if !errors.Is(err, io.ErrUnexpectedEOF) {
-					logger.Warn("Partial decompression for SSE", "contentEncoding", contentEncoding, "error", err)
+	decompressed := body
+	for i := len(encodings) - 1; i >= 0; i-- {
+		data, err := decodeContent(decompressed, encodings[i])
+		if errors.Is(err, errUnsupportedEncoding) {
+			// Pass the raw bytes through rather than guessing at the encoding
+			logger.Debug("Unsupported content encoding, keeping raw body", "contentEncoding", contentEncoding)
+			return body
+		}
+		if err != nil {
+			// For SSE, try to return whatever we managed to decompress
+			if isSSE {
+				if len(data) > 0 {
+					if !errors.Is(err, io.ErrUnexpectedEOF) {
+						logger.Warn("Partial decompression for SSE", "contentEncoding", contentEncoding, "error", err)
+					}
+					decompressed = data
+					continue
 				}
-				return decompressed
+				// No decompressed data, return original
+				return body
 			}
-			// No decompressed data, return original
+			// For non-SSE, return original body on error
+			logger.Warn("Failed to decompress body", "contentEncoding", contentEncoding, "error", err)
 			return body
 		}
-		// For non-SSE, return original body on error
-		logger.Warn("Failed to decompress body", "contentEncoding", contentEncoding, "error", err)
-		return body
+		decompressed = data
 	}
 	return decompressed
 }
+
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// decodeContent removes a single content encoding layer
+func decodeContent(data []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	case "deflate":
+		reader := flate.NewReader(bytes.NewReader(data))
+		defer reader.Close()
+		return io.ReadAll(reader)
+	case "br":
+		return io.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+	default:
+		return nil, errUnsupportedEncoding
+	}
+}
@@ -6,24 +6,37 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 	"sync"
 )
 
 type pendingHTTPRequest struct {
 	data          []byte
+	received      int64 // total bytes appended, data may hold less in metadata-only mode
 	headers       []byte
 	contentLength int64
 	isComplete    bool
 	isWebSocket   bool
+	tooLarge      bool // chunked body passed maxChunkedBodySize, dropped until its terminator
+	chunked       chunkedBody
 }
 
 type pendingHTTPResponse struct {
 	data          []byte
+	received      int64 // total bytes appended, data may hold less in metadata-only mode
 	headers       []byte
 	contentLength int64
 	isComplete    bool
 	isSSE         bool
+	isNDJSON      bool
+	isGzipStream  bool           // stream body is gzip encoded and decoded incrementally
+	decoder       *streamDecoder // persistent decoder for isGzipStream, created on first body data
+	decodeFailed  bool           // decoder error already logged
+	window        *streamWindow  // bounded stream body, data then only holds the headers
+	isChunked     bool           // chunked framing, otherwise a body of unknown length ends with the connection
+	chunked       chunkedBody
 }
 
 // HTTPProcessorInterface defines the interface for HTTP message processing
@@ -31,28 +44,67 @@ type HTTPProcessorInterface interface {
 	ProcessRequest(inputData []byte, requestID string) ([]byte, *HTTPMessage, bool, error)
 	ProcessResponse(inputData []byte, requestID string) ([]byte, *HTTPMessage, bool, error)
 	ClearPending(requestID string)
+	ClearConnection(connectionID string)
 	GetPendingMessage(requestID string) (*HTTPMessage, bool)
 }
 
 // HTTPProcessor provides common HTTP protocol parsing capabilities
 type HTTPProcessor struct {
-	logger       *slog.Logger
-	pendingReqs  sync.Map // requestID -> *pendingHTTPRequest
-	pendingResps sync.Map // requestID -> *pendingHTTPResponse
-	maxBodySize  int64
+	logger        *slog.Logger
+	pendingReqs   sync.Map // requestID -> *pendingHTTPRequest
+	pendingResps  sync.Map // requestID -> *pendingHTTPResponse
+	maxBodySize   int64
+	maxReqBody    int64 // request body capture limit, 0 = maxBodySize
+	maxRespBody   int64 // response body capture limit, 0 = maxBodySize
+	maxHeaderSize int64 // headers not terminated within this many bytes are abandoned
+	maxChunked    int64 // chunked request bodies not terminated within this many bytes are dropped
+	skipReqBody   bool
+	skipRespBody  bool
+	metadataOnly  bool                // bodies are dropped as they arrive instead of buffered
+	rawBody       bool                // keep the body as received, before content decoding, in RawBody
+	reqBodyFilter func([]byte) []byte // rewrites decoded request bodies before they're truncated
 }
 
 // HTTPMessage represents a complete HTTP message
 type HTTPMessage struct {
 	Hostname    string
-	Path        string
+	Path        string              // request URI including the raw query string
+	Query       map[string][]string // parsed query parameters, nil when the request has none
+	FormFields  map[string][]string // parsed application/x-www-form-urlencoded request body, nil otherwise
 	Method      string
 	Headers     map[string]string
 	Body        []byte
+	RawBody     []byte // body before content decoding (truncated to the body limit), only with SetRawBody
+	BodySize    int64  // size of the body on the wire, set even when body capture is skipped
 	ContentType string
 	IsResponse  bool
 	StatusCode  int
 	IsSSE       bool
+	IsNDJSON    bool              // newline-delimited JSON stream, incremental like SSE
+	Trailers    map[string]string // chunked trailer fields such as grpc-status, nil when none
+}
+
+// IsStream reports whether the body is an incremental stream (SSE or NDJSON) that never completes
+func (m *HTTPMessage) IsStream() bool {
+	return m.IsSSE || m.IsNDJSON
+}
+
+// NDJSONObjects returns the complete newline-delimited objects received so far,
+// a trailing partial line is left out until its newline arrives
+func (m *HTTPMessage) NDJSONObjects() [][]byte {
+	end := bytes.LastIndexByte(m.Body, '\n')
+	if end < 0 {
+		return nil
+	}
+
+	var objects [][]byte
+	for line := range bytes.SplitSeq(m.Body[:end], []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			objects = append(objects, line)
+		}
+	}
+	return objects
 }
 
 // NewHTTPProcessor creates a new HTTPProcessor
@@ -61,9 +113,174 @@ func NewHTTPProcessor(logger *slog.Logger, maxBodySize int64) *HTTPProcessor {
 		maxBodySize = 1024 * 1024 // 1MB default
 	}
 	return &HTTPProcessor{
-		logger:      logger,
-		maxBodySize: maxBodySize,
+		logger:        logger,
+		maxBodySize:   maxBodySize,
+		maxHeaderSize: DefaultMaxHeaderSize,
+		maxChunked:    DefaultMaxChunkedBodySize,
+	}
+}
+
+// SetMaxBodySize sets separate body capture limits for requests and responses, 0 keeps the
+// limit given to NewHTTPProcessor for that direction
+func (p *HTTPProcessor) SetMaxBodySize(request, response int64) {
+	p.maxReqBody = request
+	p.maxRespBody = response
+}
+
+// bodyLimit returns the body capture limit of a direction
+func (p *HTTPProcessor) bodyLimit(isResponse bool) int64 {
+	limit := p.maxReqBody
+	if isResponse {
+		limit = p.maxRespBody
+	}
+	if limit <= 0 {
+		return p.maxBodySize
+	}
+	return limit
+}
+
+// SetMaxHeaderSize sets how many bytes may accumulate before headers complete, 0 keeps the default
+func (p *HTTPProcessor) SetMaxHeaderSize(size int64) {
+	if size <= 0 {
+		size = DefaultMaxHeaderSize
+	}
+	p.maxHeaderSize = size
+}
+
+// SetMaxChunkedBodySize sets how many body bytes of a chunked request may accumulate before its
+// terminator, larger bodies are dropped and the request passes through uninspected. 0 keeps the default
+func (p *HTTPProcessor) SetMaxChunkedBodySize(size int64) {
+	if size <= 0 {
+		size = DefaultMaxChunkedBodySize
+	}
+	p.maxChunked = size
+}
+
+// SetSkipBody disables body capture per direction, metadata is still recorded
+func (p *HTTPProcessor) SetSkipBody(request, response bool) {
+	p.skipReqBody = request
+	p.skipRespBody = response
+}
+
+// SetMetadataOnly records only method, path, status, headers and sizes. Body bytes are
+// counted and dropped as they arrive, so no body is ever buffered or captured.
+func (p *HTTPProcessor) SetMetadataOnly(enabled bool) {
+	p.metadataOnly = enabled
+	if enabled {
+		p.SetSkipBody(true, true)
+	}
+}
+
+// SetRawBody keeps the undecoded body bytes next to the decoded body of complete messages
+func (p *HTTPProcessor) SetRawBody(enabled bool) {
+	p.rawBody = enabled
+}
+
+// SetRequestBodyFilter rewrites decoded readable request bodies before the capture limit cuts them
+func (p *HTTPProcessor) SetRequestBodyFilter(filter func([]byte) []byte) {
+	p.reqBodyFilter = filter
+}
+
+// chunkedTerminator ends a chunked body without trailers
+var chunkedTerminator = []byte("\r\n0\r\n\r\n")
+
+// lastChunk starts the final zero-size chunk, trailer fields may follow it
+var lastChunk = []byte("\r\n0\r\n")
+
+// maxDroppedTrailer bounds the trailer section kept after lastChunk while a body is dropped
+const maxDroppedTrailer = 64 << 10
+
+// chunkedBody walks the chunk-size lines of a chunked body as it arrives to find where it ends,
+// trailer section included, without holding on to the chunk data
+type chunkedBody struct {
+	walked    int64  // body bytes walked so far
+	line      []byte // partial chunk-size or trailer line
+	remaining int64  // data bytes left in the current chunk
+	skipCRLF  int    // bytes of the CRLF after chunk data still to skip
+	trailer   bool   // last chunk seen, reading trailer lines
+	done      bool   // body complete or framing broken, later bytes are ignored
+}
+
+// Walk feeds the body bytes appended to data since the last call and reports whether the body
+// is complete. It runs before the body is trimmed, so data still ends with the new bytes
+func (c *chunkedBody) Walk(data []byte, received int64, headerLen int) bool {
+	n := bodySeen(received, headerLen) - c.walked
+	c.walked += n
+	c.write(data[len(data)-int(n):])
+	return c.done
+}
+
+func (c *chunkedBody) write(p []byte) {
+	for len(p) > 0 && !c.done {
+		switch {
+		case c.skipCRLF > 0:
+			n := min(c.skipCRLF, len(p))
+			c.skipCRLF -= n
+			p = p[n:]
+		case c.remaining > 0:
+			n := int(min(c.remaining, int64(len(p))))
+			c.remaining -= int64(n)
+			p = p[n:]
+			if c.remaining == 0 {
+				c.skipCRLF = 2
+			}
+		default:
+			idx := bytes.IndexByte(p, '\n')
+			if idx < 0 {
+				c.line = append(c.line, p...)
+				c.done = len(c.line) > maxChunkLineSize
+				return
+			}
+			c.line = append(c.line, p[:idx]...)
+			p = p[idx+1:]
+			line := bytes.TrimSuffix(c.line, []byte("\r"))
+			c.line = c.line[:0]
+			if c.trailer {
+				// An empty line ends the trailer section
+				c.done = len(line) == 0
+				continue
+			}
+			size, ok := parseChunkSize(line)
+			switch {
+			case !ok:
+				c.done = true
+			case size == 0:
+				c.trailer = true
+			default:
+				c.remaining = size
+			}
+		}
+	}
+}
+
+// trimBody drops the body from data in metadata-only mode, keeping a tail just long
+// enough for the last chunk and its trailers to parse
+func (p *HTTPProcessor) trimBody(data []byte, headerLen int) []byte {
+	if !p.metadataOnly {
+		return data
+	}
+	return dropBody(data, headerLen)
+}
+
+// dropBody drops the body from data except for a tail as long as a chunked terminator.
+// Once the last chunk has arrived the tail starts at it, so a trailer section split across
+// reads still ends up in the message
+func dropBody(data []byte, headerLen int) []byte {
+	if len(data)-headerLen <= len(chunkedTerminator) {
+		return data
+	}
+	tail := len(data) - len(chunkedTerminator)
+	start := max(headerLen-2, 0)
+	if idx := bytes.LastIndex(data[start:], lastChunk); idx >= 0 && len(data)-(start+idx) <= maxDroppedTrailer {
+		tail = max(start+idx, headerLen)
 	}
+	n := copy(data[headerLen:], data[tail:])
+	return data[:headerLen+n]
+}
+
+// bodySeen returns the body bytes received after headers of headerLen
+func bodySeen(received int64, headerLen int) int64 {
+	return received - int64(headerLen)
 }
 
 // ProcessRequest processes incoming request data incrementally
@@ -75,19 +292,22 @@ func (p *HTTPProcessor) ProcessRequest(inputData []byte, requestID string) ([]by
 
 	pending := p.loadOrCreatePendingRequest(requestID)
 
-	// If we already have headers, just append the data
+	// If we already have headers or a partial header, just append the data
 	// Otherwise, check if this is the start of a new request
-	if pending.headers == nil && !isHTTPPrefix(inputData) {
+	if pending.headers == nil && len(pending.data) == 0 && !isHTTPPrefix(inputData) {
 		p.logger.Warn("not http request", "msg", string(inputData), "request_id", requestID)
 		return inputData, nil, false, nil
 	}
 
 	pending.data = append(pending.data, inputData...)
+	pending.received += int64(len(inputData))
 
 	if pending.headers == nil {
 		idx := bytes.Index(pending.data, []byte("\r\n\r\n"))
 		if idx < 0 {
-			p.logger.Warn("no http header found", "msg", pending.data)
+			if p.headerTooLarge(pending.data, requestID) {
+				p.pendingReqs.Delete(requestID)
+			}
 			return inputData, nil, false, nil
 		}
 		pending.headers = make([]byte, idx+4)
@@ -101,6 +321,8 @@ func (p *HTTPProcessor) ProcessRequest(inputData []byte, requestID string) ([]by
 	}
 
 	headerLen := len(pending.headers)
+	chunkedDone := pending.contentLength == -1 && pending.chunked.Walk(pending.data, pending.received, headerLen)
+	pending.data = p.trimBody(pending.data, headerLen)
 
 	switch pending.contentLength {
 	case 0:
@@ -113,8 +335,21 @@ func (p *HTTPProcessor) ProcessRequest(inputData []byte, requestID string) ([]by
 		return pending.data, msg, true, nil
 	case -1:
 		// Chunked transfer encoding
-		bodyEndIdx := bytes.Index(pending.data, []byte("\r\n0\r\n\r\n"))
-		if bodyEndIdx < 0 {
+		if pending.tooLarge {
+			// Only watch for the terminator so the next request on the connection is parsed again
+			pending.data = dropBody(pending.data, headerLen)
+			if chunkedDone {
+				p.pendingReqs.Delete(requestID)
+			}
+			return inputData, nil, false, nil
+		}
+		if !chunkedDone {
+			if size := bodySeen(pending.received, headerLen); size > p.maxChunked {
+				p.logger.Warn("chunked request body exceeds max size, passing through", "request_id", requestID, "size", size, "max", p.maxChunked)
+				pending.tooLarge = true
+				pending.data = dropBody(pending.data, headerLen)
+				return inputData, nil, false, nil
+			}
 			p.logger.Warn("no body found in transfer encoding chunk")
 			return inputData, nil, false, nil
 		}
@@ -123,14 +358,25 @@ func (p *HTTPProcessor) ProcessRequest(inputData []byte, requestID string) ([]by
 		if msg == nil {
 			return pending.data, nil, true, nil
 		}
+		if p.metadataOnly {
+			msg.BodySize = bodySeen(pending.received, headerLen)
+		}
 		return pending.data, msg, true, nil
 	default:
 		needed := int(pending.contentLength) + headerLen
-		if len(pending.data) < needed {
+		if pending.received < int64(needed) {
 			// Data incomplete, keep pending and return false
 			return inputData, nil, false, nil
 		}
 		p.pendingReqs.Delete(requestID)
+		if p.metadataOnly {
+			msg := p.buildRequestMessage(pending.headers)
+			if msg == nil {
+				return pending.data, nil, true, nil
+			}
+			msg.BodySize = pending.contentLength
+			return pending.data, msg, true, nil
+		}
 		// Make a copy to ensure the returned data is independent
 		fullData := make([]byte, needed)
 		copy(fullData, pending.data[:needed])
@@ -144,7 +390,8 @@ func (p *HTTPProcessor) ProcessRequest(inputData []byte, requestID string) ([]by
 
 // ProcessResponse processes incoming response data incrementally
 // Returns: (completeMessage, isComplete, error)
-// For SSE responses, always returns accumulated data (for streaming inspection)
+// For SSE responses, always returns accumulated data (for streaming inspection). Windowed
+// streams complete on their last chunk or Content-Length, others only end with the connection
 func (p *HTTPProcessor) ProcessResponse(inputData []byte, requestID string) ([]byte, *HTTPMessage, bool, error) {
 	if len(inputData) == 0 {
 		return inputData, nil, false, nil
@@ -152,30 +399,68 @@ func (p *HTTPProcessor) ProcessResponse(inputData []byte, requestID string) ([]b
 
 	pending := p.loadOrCreatePendingResponse(requestID)
 
-	// If we already have headers, just append the data
+	// If we already have headers or a partial header, just append the data
 	// Otherwise, check if this is the start of a new response
-	if pending.headers == nil && !isHTTPResponsePrefix(inputData) {
+	if pending.headers == nil && len(pending.data) == 0 && !isHTTPResponsePrefix(inputData) {
 		return inputData, nil, false, nil
 	}
 
+	pending.received += int64(len(inputData))
+	if pending.window != nil {
+		pending.window.Write(inputData)
+		msg, complete := p.streamMessage(pending, requestID)
+		return inputData, msg, complete, nil
+	}
 	pending.data = append(pending.data, inputData...)
 
 	if pending.headers == nil {
+		// 100 Continue and other interim responses precede the final response, which may follow in the same read
+		if n := interimResponsesLen(pending.data); n > 0 {
+			pending.data = pending.data[n:]
+			pending.received -= int64(n)
+			if len(pending.data) == 0 {
+				return inputData, nil, false, nil
+			}
+		}
 		idx := bytes.Index(pending.data, []byte("\r\n\r\n"))
 		if idx < 0 {
+			if p.headerTooLarge(pending.data, requestID) {
+				p.pendingResps.Delete(requestID)
+			}
 			return inputData, nil, false, nil
 		}
 		pending.headers = make([]byte, idx+4)
 		copy(pending.headers, pending.data[:idx+4])
 		pending.contentLength = p.parseContentLength(pending.headers, true)
+		pending.isChunked = p.detectChunked(pending.headers)
 		pending.isSSE = p.detectSSE(pending.headers)
+		pending.isNDJSON = p.detectNDJSON(pending.headers)
+		pending.isGzipStream = (pending.isSSE || pending.isNDJSON) && p.detectGzip(pending.headers)
+
+		// Streams may run for hours, decode them into a bounded window instead of accumulating
+		if (pending.isSSE || pending.isNDJSON) && p.windowable(pending) {
+			limit := p.bodyLimit(true)
+			if p.skipRespBody {
+				limit = 0
+			}
+			pending.window = newStreamWindow(p.detectChunked(pending.headers), limit)
+			pending.window.Write(pending.data[len(pending.headers):])
+			pending.data = pending.headers
+			msg, complete := p.streamMessage(pending, requestID)
+			return inputData, msg, complete, nil
+		}
 	}
 
 	headerLen := len(pending.headers)
+	chunkedDone := pending.isChunked && pending.chunked.Walk(pending.data, pending.received, headerLen)
+	pending.data = p.trimBody(pending.data, headerLen)
 
-	// For SSE responses, always return accumulated data (don't consume it)
-	if pending.isSSE {
+	// Streams the window can't decode (metadata-only, other encodings) return accumulated data
+	if pending.isSSE || pending.isNDJSON {
 		msg := p.buildResponseMessage(pending.data)
+		if msg != nil && p.metadataOnly {
+			msg.BodySize = bodySeen(pending.received, headerLen)
+		}
 		return pending.data, msg, false, nil
 	}
 
@@ -189,9 +474,9 @@ func (p *HTTPProcessor) ProcessResponse(inputData []byte, requestID string) ([]b
 		}
 		return pending.data, msg, true, nil
 	case -1:
-		// Chunked transfer encoding
-		bodyEndIdx := bytes.Index(pending.data, []byte("\r\n0\r\n\r\n"))
-		if bodyEndIdx < 0 {
+		// Chunked transfer encoding, or a body running until the connection closes which
+		// CloseDelimited completes
+		if !chunkedDone {
 			return inputData, nil, false, nil
 		}
 		p.pendingResps.Delete(requestID)
@@ -199,14 +484,25 @@ func (p *HTTPProcessor) ProcessResponse(inputData []byte, requestID string) ([]b
 		if msg == nil {
 			return pending.data, nil, true, nil
 		}
+		if p.metadataOnly {
+			msg.BodySize = bodySeen(pending.received, headerLen)
+		}
 		return pending.data, msg, true, nil
 	default:
 		needed := int(pending.contentLength) + headerLen
-		if len(pending.data) < needed {
+		if pending.received < int64(needed) {
 			// Data incomplete, keep pending and return false
 			return inputData, nil, false, nil
 		}
 		p.pendingResps.Delete(requestID)
+		if p.metadataOnly {
+			msg := p.buildResponseMessage(pending.headers)
+			if msg == nil {
+				return pending.data, nil, true, nil
+			}
+			msg.BodySize = pending.contentLength
+			return pending.data, msg, true, nil
+		}
 		// Make a copy to ensure the returned data is independent
 		fullData := make([]byte, needed)
 		copy(fullData, pending.data[:needed])
@@ -218,6 +514,24 @@ func (p *HTTPProcessor) ProcessResponse(inputData []byte, requestID string) ([]b
 	}
 }
 
+// interimResponsesLen returns the length of the complete 1xx interim responses (100 Continue,
+// 103 Early Hints) at the start of data. 101 Switching Protocols is final and not counted.
+func interimResponsesLen(data []byte) int {
+	off := 0
+	for {
+		rest := data[off:]
+		if !bytes.HasPrefix(rest, []byte("HTTP/1.")) || len(rest) < 13 || rest[8] != ' ' || rest[9] != '1' ||
+			bytes.HasPrefix(rest[9:], []byte("101")) {
+			return off
+		}
+		end := bytes.Index(rest, []byte("\r\n\r\n"))
+		if end < 0 {
+			return off
+		}
+		off += end + 4
+	}
+}
+
 // GetPendingMessage gets a pending request/response by requestID
 func (p *HTTPProcessor) GetPendingMessage(requestID string) (*HTTPMessage, bool) {
 	if val, exists := p.pendingReqs.Load(requestID); exists {
@@ -227,6 +541,9 @@ func (p *HTTPProcessor) GetPendingMessage(requestID string) (*HTTPMessage, bool)
 	}
 	if val, exists := p.pendingResps.Load(requestID); exists {
 		pending := val.(*pendingHTTPResponse)
+		if pending.window != nil {
+			return p.buildStreamMessage(pending), true
+		}
 		msg := p.buildResponseMessage(pending.data)
 		return msg, true
 	}
@@ -236,7 +553,57 @@ func (p *HTTPProcessor) GetPendingMessage(requestID string) (*HTTPMessage, bool)
 // ClearPending clears pending state for a requestID
 func (p *HTTPProcessor) ClearPending(requestID string) {
 	p.pendingReqs.Delete(requestID)
-	p.pendingResps.Delete(requestID)
+	if val, exists := p.pendingResps.LoadAndDelete(requestID); exists {
+		if decoder := val.(*pendingHTTPResponse).decoder; decoder != nil {
+			decoder.Close()
+		}
+	}
+}
+
+// ClearConnection clears the pending state of every request of a closed connection, stopping
+// the decoders of streams that never ended
+func (p *HTTPProcessor) ClearConnection(connectionID string) {
+	clear := func(key, _ any) bool {
+		if requestID := key.(string); strings.HasPrefix(requestID, connectionID+"-") {
+			p.ClearPending(requestID)
+		}
+		return true
+	}
+	p.pendingReqs.Range(clear)
+	p.pendingResps.Range(clear)
+}
+
+// CloseDelimited removes and returns, by request ID, the responses of a closed connection whose
+// body ran until the close, having neither Content-Length nor chunked framing
+func (p *HTTPProcessor) CloseDelimited(connectionID string) map[string]*HTTPMessage {
+	msgs := make(map[string]*HTTPMessage)
+	p.pendingResps.Range(func(key, val any) bool {
+		requestID := key.(string)
+		pending := val.(*pendingHTTPResponse)
+		if !strings.HasPrefix(requestID, connectionID+"-") || pending.headers == nil || pending.contentLength != -1 ||
+			pending.isChunked || pending.isSSE || pending.isNDJSON {
+			return true
+		}
+		p.pendingResps.Delete(requestID)
+		if msg := p.buildResponseMessage(pending.data); msg != nil {
+			if p.metadataOnly {
+				msg.BodySize = bodySeen(pending.received, len(pending.headers))
+			}
+			msgs[requestID] = msg
+		}
+		return true
+	})
+	return msgs
+}
+
+// headerTooLarge reports whether data still lacks a header terminator past maxHeaderSize,
+// the caller then drops the pending entry and the stream passes through uninspected
+func (p *HTTPProcessor) headerTooLarge(data []byte, requestID string) bool {
+	if int64(len(data)) <= p.maxHeaderSize {
+		return false
+	}
+	p.logger.Warn("http header exceeds max size, passing through", "request_id", requestID, "size", len(data), "max", p.maxHeaderSize)
+	return true
 }
 
 func (p *HTTPProcessor) loadOrCreatePendingRequest(requestID string) *pendingHTTPRequest {
@@ -295,6 +662,39 @@ func (p *HTTPProcessor) detectSSE(headerData []byte) bool {
 	return strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "text/event-stream")
 }
 
+func (p *HTTPProcessor) detectNDJSON(headerData []byte) bool {
+	reader := bytes.NewReader(headerData)
+	resp, err := http.ReadResponse(bufio.NewReader(reader), nil)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return isNDJSONContentType(resp.Header.Get("Content-Type"))
+}
+
+// detectChunked reports whether the response body uses chunked transfer encoding
+func (p *HTTPProcessor) detectChunked(headerData []byte) bool {
+	reader := bytes.NewReader(headerData)
+	resp, err := http.ReadResponse(bufio.NewReader(reader), nil)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return slices.Contains(resp.TransferEncoding, "chunked")
+}
+
+// detectGzip reports whether the response body is a single gzip layer
+func (p *HTTPProcessor) detectGzip(headerData []byte) bool {
+	reader := bytes.NewReader(headerData)
+	resp, err := http.ReadResponse(bufio.NewReader(reader), nil)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	encoding := strings.ToLower(strings.TrimSpace(getContentEncoding(resp.Header)))
+	return encoding == "gzip" || encoding == "x-gzip"
+}
+
 func (p *HTTPProcessor) detectWebSocket(headerData []byte) bool {
 	reader := bytes.NewReader(headerData)
 	req, err := http.ReadRequest(bufio.NewReader(reader))
@@ -315,28 +715,33 @@ func (p *HTTPProcessor) buildRequestMessage(data []byte) *HTTPMessage {
 	}
 	defer req.Body.Close()
 
-	bodyBytes, _ := io.ReadAll(req.Body)
-
 	contentType := req.Header.Get("Content-Type")
-	// Only decompress readable content types, but always apply body size limit
-	if isReadableTextType(contentType) {
-		// Decompress if needed
-		contentEncoding := getContentEncoding(req.Header)
-		decompressed := decompressBody(bodyBytes, contentEncoding, contentType, p.logger)
-		bodyBytes = p.truncateBody(decompressed)
-	} else {
-		// Apply body size limit even for non-readable types
-		bodyBytes = p.truncateBody(bodyBytes)
+	bodyBytes, rawBody, bodySize := p.readBody(req.Body, req.Header, false)
+
+	var query map[string][]string
+	if req.URL.RawQuery != "" {
+		query = req.URL.Query()
+	}
+
+	var formFields map[string][]string
+	if isFormContentType(contentType) && len(bodyBytes) > 0 {
+		// A body truncated by the request body limit still yields the fields before the cut
+		formFields, _ = url.ParseQuery(string(bodyBytes))
 	}
 
 	return &HTTPMessage{
 		Hostname:    req.Host,
 		Path:        req.URL.RequestURI(),
+		Query:       query,
+		FormFields:  formFields,
 		Method:      req.Method,
 		Headers:     extractHeaders(req.Header),
 		Body:        bodyBytes,
+		RawBody:     rawBody,
+		BodySize:    bodySize,
 		ContentType: contentType,
 		IsResponse:  false,
+		Trailers:    extractTrailers(req.Trailer),
 	}
 }
 
@@ -347,19 +752,8 @@ func (p *HTTPProcessor) buildResponseMessage(data []byte) *HTTPMessage {
 	}
 	defer resp.Body.Close()
 
-	bodyBytes, _ := io.ReadAll(resp.Body)
-
 	contentType := resp.Header.Get("Content-Type")
-	// Only decompress readable content types, but always apply body size limit
-	if isReadableTextType(contentType) {
-		// Decompress if needed
-		contentEncoding := getContentEncoding(resp.Header)
-		decompressed := decompressBody(bodyBytes, contentEncoding, contentType, p.logger)
-		bodyBytes = p.truncateBody(decompressed)
-	} else {
-		// Apply body size limit even for non-readable types
-		bodyBytes = p.truncateBody(bodyBytes)
-	}
+	bodyBytes, rawBody, bodySize := p.readBody(resp.Body, resp.Header, true)
 
 	hostname := ""
 	path := ""
@@ -373,17 +767,156 @@ func (p *HTTPProcessor) buildResponseMessage(data []byte) *HTTPMessage {
 		Path:        path,
 		Headers:     extractHeaders(resp.Header),
 		Body:        bodyBytes,
+		RawBody:     rawBody,
+		BodySize:    bodySize,
 		ContentType: contentType,
 		IsResponse:  true,
 		StatusCode:  resp.StatusCode,
 		IsSSE:       p.detectSSE(data[:bytes.Index(data, []byte("\r\n\r\n"))+4]),
+		IsNDJSON:    isNDJSONContentType(contentType),
+		Trailers:    extractTrailers(resp.Trailer),
+	}
+}
+
+// windowable reports whether a stream body can be decoded incrementally into a window,
+// only identity and gzip bodies can unless the body is skipped anyway
+func (p *HTTPProcessor) windowable(pending *pendingHTTPResponse) bool {
+	if p.metadataOnly {
+		return false
+	}
+	if p.skipRespBody || pending.isGzipStream {
+		return true
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(pending.headers)), nil)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	encoding := strings.ToLower(strings.TrimSpace(getContentEncoding(resp.Header)))
+	return encoding == "" || encoding == "identity"
+}
+
+// buildStreamMessage builds a stream message from the window. Gzip streams feed only the newly
+// arrived compressed bytes to the pending decoder instead of re-decompressing on every chunk.
+func (p *HTTPProcessor) buildStreamMessage(pending *pendingHTTPResponse) *HTTPMessage {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(pending.headers)), nil)
+	if err != nil {
+		return nil
+	}
+	resp.Body.Close()
+
+	window := pending.window
+	var body []byte
+	switch {
+	case p.skipRespBody:
+	case pending.isGzipStream:
+		if pending.decoder == nil {
+			pending.decoder = newStreamDecoder(p.bodyLimit(true))
+		}
+		pending.decoder.Feed(window.body)
+		body = pending.decoder.Output()
+		if err := pending.decoder.Err(); err != nil {
+			if !pending.decodeFailed {
+				p.logger.Warn("Failed to decompress gzip stream", "error", err)
+				pending.decodeFailed = true
+			}
+			// Nothing decodable, keep the raw bytes like decompressBody does
+			if len(body) == 0 {
+				body = bytes.Clone(window.body)
+				break
+			}
+		}
+		window.Discard(len(window.body))
+	default:
+		body = bytes.Clone(window.body)
+	}
+
+	return &HTTPMessage{
+		Headers:     extractHeaders(resp.Header),
+		Body:        body,
+		BodySize:    window.total,
+		ContentType: resp.Header.Get("Content-Type"),
+		IsResponse:  true,
+		StatusCode:  resp.StatusCode,
+		IsSSE:       pending.isSSE,
+		IsNDJSON:    pending.isNDJSON,
+	}
+}
+
+// streamMessage builds the message of a windowed stream and reports whether its body has fully
+// arrived, the pending state of a complete stream is cleared
+func (p *HTTPProcessor) streamMessage(pending *pendingHTTPResponse, requestID string) (*HTTPMessage, bool) {
+	msg := p.buildStreamMessage(pending)
+	window := pending.window
+	if !window.ended && (pending.contentLength <= 0 || window.total < pending.contentLength) {
+		return msg, false
+	}
+	p.ClearPending(requestID)
+	return msg, true
+}
+
+// DiscardStream drops the first n bytes of a stream's body once the caller has parsed them,
+// later messages carry the body from that point. Returns false when the stream isn't windowed.
+func (p *HTTPProcessor) DiscardStream(requestID string, n int) bool {
+	val, exists := p.pendingResps.Load(requestID)
+	if !exists {
+		return false
+	}
+	pending := val.(*pendingHTTPResponse)
+	if pending.window == nil {
+		return false
+	}
+	if pending.isGzipStream {
+		if pending.decoder == nil || pending.decodeFailed {
+			return false
+		}
+		pending.decoder.Discard(n)
+		return true
+	}
+	pending.window.Discard(n)
+	return true
+}
+
+// readBody reads and decodes a message body, returning the captured bytes and the wire size.
+// When body capture is skipped for the direction the body is drained without buffering or decompression.
+func (p *HTTPProcessor) readBody(body io.Reader, header http.Header, isResponse bool) ([]byte, []byte, int64) {
+	skip := p.skipReqBody
+	if isResponse {
+		skip = p.skipRespBody
+	}
+	if skip {
+		size, _ := io.Copy(io.Discard, body)
+		return nil, nil, size
+	}
+
+	bodyBytes, _ := io.ReadAll(body)
+	bodySize := int64(len(bodyBytes))
+
+	var rawBody []byte
+	if p.rawBody {
+		rawBody = p.truncateBody(bodyBytes, isResponse)
 	}
+
+	contentType := header.Get("Content-Type")
+	// Only decompress readable content types, but always apply body size limit
+	if isReadableTextType(contentType) {
+		// Decompress if needed
+		contentEncoding := getContentEncoding(header)
+		decompressed := decompressBody(bodyBytes, contentEncoding, contentType, p.logger)
+		if !isResponse && p.reqBodyFilter != nil {
+			decompressed = p.reqBodyFilter(decompressed)
+		}
+		return p.truncateBody(decompressed, isResponse), rawBody, bodySize
+	}
+	// Apply body size limit even for non-readable types
+	return p.truncateBody(bodyBytes, isResponse), rawBody, bodySize
 }
 
-func (p *HTTPProcessor) truncateBody(body []byte) []byte {
+// truncateBody cuts body to the capture limit of its direction
+func (p *HTTPProcessor) truncateBody(body []byte, isResponse bool) []byte {
 	bodyStr := string(body)
-	if p.maxBodySize > 0 && len(bodyStr) > int(p.maxBodySize) {
-		return []byte(bodyStr[:p.maxBodySize])
+	if limit := p.bodyLimit(isResponse); limit > 0 && len(bodyStr) > int(limit) {
+		return []byte(bodyStr[:limit])
 	}
 	return body
 }
@@ -398,6 +931,14 @@ func extractHeaders(header http.Header) map[string]string {
 	return headers
 }
 
+// extractTrailers flattens trailer fields read after a chunked body, nil when there are none
+func extractTrailers(trailer http.Header) map[string]string {
+	if len(trailer) == 0 {
+		return nil
+	}
+	return extractHeaders(trailer)
+}
+
 func getContentEncoding(header http.Header) string {
 	if ce := header.Get("Content-Encoding"); ce != "" {
 		return ce
@@ -7,6 +7,8 @@ import (
 	"log/slog"
 	"strings"
 	"testing"
+
+	"github.com/andybalholm/brotli"
 )
 
 func TestHTTPProcessor_ProcessRequest_Basic(t *testing.T) {
@@ -155,6 +157,84 @@ func TestHTTPProcessor_ProcessRequest_Chunked(t *testing.T) {
 	}
 }
 
+func TestHTTPProcessor_ProcessRequest_ChunkedTerminatorInData(t *testing.T) {
+	logger := slog.Default()
+	processor := NewHTTPProcessor(logger, 1024*1024)
+
+	// Chunk data that looks like the last chunk must not end the body
+	body := "a\r\n0\r\n\r\nb"
+	head := "POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n"
+	chunks := []string{head + fmt.Sprintf("%x\r\n", len(body)) + body[:4], body[4:] + "\r\n0\r\nX-Checksum: 1\r\n", "\r\n"}
+	requestID := "test-req-terminator"
+
+	for i, chunk := range chunks {
+		_, msg, isComplete, err := processor.ProcessRequest([]byte(chunk), requestID)
+		if err != nil {
+			t.Fatalf("ProcessRequest failed: %v", err)
+		}
+		if last := i == len(chunks)-1; isComplete != last {
+			t.Fatalf("Read %d: expected isComplete %v, got %v", i, last, isComplete)
+		}
+		if i == len(chunks)-1 {
+			if msg == nil || string(msg.Body) != body {
+				t.Fatalf("Expected body %q, got %+v", body, msg)
+			}
+			if msg.Trailers["X-Checksum"] != "1" {
+				t.Errorf("Expected trailer to be parsed, got %v", msg.Trailers)
+			}
+		}
+	}
+}
+
+func TestHTTPProcessor_ProcessRequest_ChunkedTooLarge(t *testing.T) {
+	logger := slog.Default()
+	processor := NewHTTPProcessor(logger, 1024*1024)
+	processor.SetMaxChunkedBodySize(1024)
+	requestID := "test-chunked-cap"
+
+	chunk := []byte("200\r\n" + strings.Repeat("a", 512) + "\r\n")
+	inputs := [][]byte{[]byte("POST /upload HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n")}
+	for range 8 {
+		inputs = append(inputs, chunk)
+	}
+	for i, input := range inputs {
+		result, msg, complete, err := processor.ProcessRequest(input, requestID)
+		if err != nil || msg != nil || complete {
+			t.Fatalf("Input %d: expected pending pass-through, got msg=%v complete=%v err=%v", i, msg, complete, err)
+		}
+		if !bytes.Equal(result, input) {
+			t.Errorf("Input %d: expected data to pass through unchanged", i)
+		}
+	}
+
+	val, exists := processor.pendingReqs.Load(requestID)
+	if !exists {
+		t.Fatal("Expected the oversized request to stay pending until its terminator")
+	}
+	pending := val.(*pendingHTTPRequest)
+	if !pending.tooLarge {
+		t.Error("Expected the cap to trigger after 4KB of chunks")
+	}
+	if body := len(pending.data) - len(pending.headers); body > len(chunkedTerminator) {
+		t.Errorf("Expected the body buffer to be dropped, %d bytes kept", body)
+	}
+
+	// The terminator ends the dropped request without a message
+	_, msg, complete, _ := processor.ProcessRequest([]byte("0\r\n\r\n"), requestID)
+	if msg != nil || complete {
+		t.Errorf("Expected no message for the dropped request, got msg=%v complete=%v", msg, complete)
+	}
+	if _, exists := processor.pendingReqs.Load(requestID); exists {
+		t.Error("Expected pending state to be released at the terminator")
+	}
+
+	// Chunked requests under the cap are still parsed
+	_, msg, complete, _ = processor.ProcessRequest([]byte("POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nHello\r\n0\r\n\r\n"), "test-chunked-small")
+	if !complete || msg == nil || string(msg.Body) != "Hello" {
+		t.Errorf("Expected small chunked request to complete with body Hello, got %v", msg)
+	}
+}
+
 func TestHTTPProcessor_ProcessRequest_EmptyData(t *testing.T) {
 	logger := slog.Default()
 	processor := NewHTTPProcessor(logger, 1024*1024)
@@ -203,6 +283,76 @@ func TestHTTPProcessor_ProcessRequest_InvalidHTTP(t *testing.T) {
 	}
 }
 
+func TestHTTPProcessor_MaxHeaderSize(t *testing.T) {
+	logger := slog.Default()
+	processor := NewHTTPProcessor(logger, 1024*1024)
+	processor.SetMaxHeaderSize(1024)
+
+	// Request line followed by endless header bytes, never terminated by \r\n\r\n
+	chunk := []byte("GET / HTTP/1.1\r\nX-Long: " + strings.Repeat("a", 600))
+	filler := []byte(strings.Repeat("b", 600))
+	requestID := "test-maxheader-1"
+
+	result, msg, complete, err := processor.ProcessRequest(chunk, requestID)
+	if err != nil || msg != nil || complete {
+		t.Fatalf("Expected first chunk to stay pending, got msg=%v complete=%v err=%v", msg, complete, err)
+	}
+	if !bytes.Equal(result, chunk) {
+		t.Error("Expected first chunk to pass through unchanged")
+	}
+	if _, exists := processor.pendingReqs.Load(requestID); !exists {
+		t.Fatal("Expected pending request while under the header limit")
+	}
+
+	result, msg, complete, err = processor.ProcessRequest(filler, requestID)
+	if err != nil || msg != nil || complete {
+		t.Fatalf("Expected oversized header to be abandoned, got msg=%v complete=%v err=%v", msg, complete, err)
+	}
+	if !bytes.Equal(result, filler) {
+		t.Error("Expected oversized chunk to pass through unchanged")
+	}
+	if _, exists := processor.pendingReqs.Load(requestID); exists {
+		t.Error("Expected pending buffer to be released after exceeding max header size")
+	}
+
+	// Later bytes of the abandoned stream are not buffered again
+	result, _, _, _ = processor.ProcessRequest(filler, requestID)
+	if !bytes.Equal(result, filler) {
+		t.Error("Expected subsequent data to pass through unchanged")
+	}
+	if val, exists := processor.pendingReqs.Load(requestID); exists && len(val.(*pendingHTTPRequest).data) > 0 {
+		t.Error("Expected no data to be buffered for the abandoned stream")
+	}
+
+	// Responses are guarded the same way
+	respChunk := []byte("HTTP/1.1 200 OK\r\nX-Long: " + strings.Repeat("a", 2048))
+	result, _, _, _ = processor.ProcessResponse(respChunk, requestID)
+	if !bytes.Equal(result, respChunk) {
+		t.Error("Expected oversized response header to pass through unchanged")
+	}
+	if _, exists := processor.pendingResps.Load(requestID); exists {
+		t.Error("Expected pending response to be released after exceeding max header size")
+	}
+}
+
+func TestHTTPProcessor_ProcessRequest_SplitHeaders(t *testing.T) {
+	logger := slog.Default()
+	processor := NewHTTPProcessor(logger, 1024*1024)
+	requestID := "test-split-headers-1"
+
+	_, msg, complete, _ := processor.ProcessRequest([]byte("GET /split HTTP/1.1\r\nHost: exa"), requestID)
+	if msg != nil || complete {
+		t.Fatal("Expected partial headers to stay pending")
+	}
+	_, msg, complete, _ = processor.ProcessRequest([]byte("mple.com\r\n\r\n"), requestID)
+	if !complete || msg == nil {
+		t.Fatal("Expected request to complete once the header terminator arrives")
+	}
+	if msg.Hostname != "example.com" || msg.Path != "/split" {
+		t.Errorf("Expected example.com/split, got %s%s", msg.Hostname, msg.Path)
+	}
+}
+
 func TestHTTPProcessor_ProcessRequest_TruncateBody(t *testing.T) {
 	logger := slog.Default()
 	processor := NewHTTPProcessor(logger, 10) // 10 bytes max
@@ -233,6 +383,47 @@ func TestHTTPProcessor_ProcessRequest_TruncateBody(t *testing.T) {
 	}
 }
 
+func TestHTTPProcessor_SeparateBodyLimits(t *testing.T) {
+	logger := slog.Default()
+	processor := NewHTTPProcessor(logger, 16)
+	processor.SetMaxBodySize(100, 10)
+
+	prompt := strings.Repeat("p", 80)
+	requestData := fmt.Sprintf("POST /v1/messages HTTP/1.1\r\nHost: example.com\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n%s", len(prompt), prompt)
+	_, msg, complete, err := processor.ProcessRequest([]byte(requestData), "test-limits-1")
+	if err != nil || !complete || msg == nil {
+		t.Fatalf("ProcessRequest() = %v, %v, %v", msg, complete, err)
+	}
+	if string(msg.Body) != prompt {
+		t.Errorf("Expected the %d byte request kept in full under the request limit, got %d bytes", len(prompt), len(msg.Body))
+	}
+
+	// A request over its own limit is cut at it
+	long := strings.Repeat("q", 150)
+	requestData = fmt.Sprintf("POST /v1/messages HTTP/1.1\r\nHost: example.com\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n%s", len(long), long)
+	_, msg, _, _ = processor.ProcessRequest([]byte(requestData), "test-limits-2")
+	if msg == nil || len(msg.Body) != 100 || msg.BodySize != 150 {
+		t.Errorf("Expected the request truncated to 100 of 150 bytes, got %+v", msg)
+	}
+
+	output := strings.Repeat("r", 80)
+	responseData := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n%s", len(output), output)
+	_, msg, complete, err = processor.ProcessResponse([]byte(responseData), "test-limits-1")
+	if err != nil || !complete || msg == nil {
+		t.Fatalf("ProcessResponse() = %v, %v, %v", msg, complete, err)
+	}
+	if string(msg.Body) != output[:10] || msg.BodySize != 80 {
+		t.Errorf("Expected the response truncated to 10 of 80 bytes, got %q (%d)", msg.Body, msg.BodySize)
+	}
+
+	// 0 falls back to the shared limit
+	processor.SetMaxBodySize(0, 0)
+	_, msg, _, _ = processor.ProcessRequest([]byte(requestData), "test-limits-3")
+	if msg == nil || len(msg.Body) != 16 {
+		t.Errorf("Expected the shared 16 byte limit, got %+v", msg)
+	}
+}
+
 func TestHTTPProcessor_ProcessResponse_Basic(t *testing.T) {
 	logger := slog.Default()
 	processor := NewHTTPProcessor(logger, 1024*1024)
@@ -370,6 +561,78 @@ func TestHTTPProcessor_ProcessResponse_Chunked(t *testing.T) {
 	}
 }
 
+func TestHTTPProcessor_ProcessResponse_CloseDelimited(t *testing.T) {
+	processor := NewHTTPProcessor(slog.Default(), 1024*1024)
+	requestID := "conn-close-1"
+
+	// Neither Content-Length nor chunked, the body runs until the connection closes
+	reads := []string{"HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nhello world\n", "second line\n"}
+	for i, data := range reads {
+		_, _, isComplete, err := processor.ProcessResponse([]byte(data), requestID)
+		if err != nil {
+			t.Fatalf("ProcessResponse failed: %v", err)
+		}
+		if isComplete {
+			t.Fatalf("Read %d: expected the response to stay open until the connection closes", i)
+		}
+	}
+
+	msgs := processor.CloseDelimited("conn-close")
+	msg := msgs[requestID]
+	if msg == nil {
+		t.Fatalf("Expected the response to complete on close, got %v", msgs)
+	}
+	if string(msg.Body) != "hello world\nsecond line\n" {
+		t.Errorf("Expected the whole body, got %q", msg.Body)
+	}
+	if _, exists := processor.pendingResps.Load(requestID); exists {
+		t.Error("Expected the completed response to be released")
+	}
+}
+
+func TestHTTPProcessor_ProcessResponse_ChunkedTrailers(t *testing.T) {
+	logger := slog.Default()
+	processor := NewHTTPProcessor(logger, 1024*1024)
+
+	// gRPC-Web style response, the status lives in trailers that arrive in a separate read
+	requestID := "test-resp-trailers"
+	chunks := []string{
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nContent-Type: text/plain\r\nTrailer: Grpc-Status, Grpc-Message\r\n\r\n5\r\nHello\r\n0\r\n",
+		"grpc-status: 5\r\ngrpc-message: not found\r\n\r\n",
+	}
+
+	_, msg, isComplete, err := processor.ProcessResponse([]byte(chunks[0]), requestID)
+	if err != nil {
+		t.Fatalf("ProcessResponse failed: %v", err)
+	}
+	if isComplete || msg != nil {
+		t.Fatal("Expected response to wait for the trailer section")
+	}
+
+	_, msg, isComplete, err = processor.ProcessResponse([]byte(chunks[1]), requestID)
+	if err != nil {
+		t.Fatalf("ProcessResponse failed: %v", err)
+	}
+	if !isComplete || msg == nil {
+		t.Fatal("Expected complete response after trailers")
+	}
+	if string(msg.Body) != "Hello" {
+		t.Errorf("Expected body 'Hello', got '%s'", msg.Body)
+	}
+	if msg.Trailers["Grpc-Status"] != "5" || msg.Trailers["Grpc-Message"] != "not found" {
+		t.Errorf("Expected grpc trailers, got %v", msg.Trailers)
+	}
+
+	// Without trailers the field stays nil
+	_, msg, _, _ = processor.ProcessResponse([]byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"), "test-resp-no-trailers")
+	if msg == nil {
+		t.Fatal("Expected complete response for empty chunked body")
+	}
+	if msg.Trailers != nil {
+		t.Errorf("Expected no trailers, got %v", msg.Trailers)
+	}
+}
+
 func TestHTTPProcessor_ProcessResponse_SSE(t *testing.T) {
 	logger := slog.Default()
 	processor := NewHTTPProcessor(logger, 1024*1024)
@@ -436,15 +699,47 @@ func TestHTTPProcessor_ProcessResponse_SSE_Incremental(t *testing.T) {
 		t.Error("Expected isComplete to remain false for SSE")
 	}
 
-	// Should return accumulated data
-	expected2 := append(chunk1, chunk2...)
-	if !bytes.Equal(result2, expected2) {
-		t.Error("Expected accumulated data")
+	// Streams are not accumulated, the chunk is passed through
+	if !bytes.Equal(result2, chunk2) {
+		t.Error("Expected chunk to be passed through")
 	}
 
-	// Message should still be SSE
+	// Message should still be SSE, carrying the body of both chunks
 	if msg2 == nil || !msg2.IsSSE {
-		t.Error("Expected SSE message")
+		t.Fatal("Expected SSE message")
+	}
+	if string(msg2.Body) != "data: first\r\n\r\ndata: second\r\n\r\n" {
+		t.Errorf("Expected body of both chunks, got %q", msg2.Body)
+	}
+}
+
+func TestHTTPProcessor_ProcessResponse_SSEBoundedWindow(t *testing.T) {
+	logger := slog.Default()
+	processor := NewHTTPProcessor(logger, 64)
+	requestID := "test-resp-sse-window"
+
+	processor.ProcessResponse([]byte("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\n\r\n"), requestID)
+	event := []byte("data: 0123456789\n\n")
+	var msg *HTTPMessage
+	for range 100 {
+		_, msg, _, _ = processor.ProcessResponse(event, requestID)
+	}
+
+	// Nothing is discarded by the caller, retention stops at the body limit
+	if len(msg.Body) != 64 || !bytes.HasPrefix(msg.Body, event) {
+		t.Errorf("Expected the first 64 body bytes to be retained, got %q", msg.Body)
+	}
+	if want := int64(100 * len(event)); msg.BodySize != want {
+		t.Errorf("Expected body size %d, got %d", want, msg.BodySize)
+	}
+
+	// Discarding parsed bytes makes room for the rest of the stream
+	if !processor.DiscardStream(requestID, len(msg.Body)) {
+		t.Fatal("Expected stream to be windowed")
+	}
+	_, msg, _, _ = processor.ProcessResponse(event, requestID)
+	if !bytes.Equal(msg.Body, event) {
+		t.Errorf("Expected only the new event after discard, got %q", msg.Body)
 	}
 }
 
@@ -629,6 +924,102 @@ func TestHTTPProcessor_BuildRequestMessage_WithHeaders(t *testing.T) {
 	}
 }
 
+func TestHTTPProcessor_BuildRequestMessage_Query(t *testing.T) {
+	logger := slog.Default()
+	processor := NewHTTPProcessor(logger, 1024*1024)
+
+	requestData := []byte("GET /search?tag=a&tag=b&q=hello%20world&name=%E4%BD%A0%E5%A5%BD&empty= HTTP/1.1\r\nHost: example.com\r\n\r\n")
+
+	_, msg, _, err := processor.ProcessRequest(requestData, "test-query-1")
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if msg == nil {
+		t.Fatal("Expected HTTPMessage to be returned")
+	}
+
+	if msg.Path != "/search?tag=a&tag=b&q=hello%20world&name=%E4%BD%A0%E5%A5%BD&empty=" {
+		t.Errorf("Expected raw path to be kept, got %s", msg.Path)
+	}
+	if got := msg.Query["tag"]; len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Expected repeated tag values [a b], got %v", got)
+	}
+	if got := msg.Query["q"]; len(got) != 1 || got[0] != "hello world" {
+		t.Errorf("Expected decoded q 'hello world', got %v", got)
+	}
+	if got := msg.Query["name"]; len(got) != 1 || got[0] != "你好" {
+		t.Errorf("Expected decoded name '你好', got %v", got)
+	}
+	if got, ok := msg.Query["empty"]; !ok || len(got) != 1 || got[0] != "" {
+		t.Errorf("Expected empty value for 'empty', got %v", got)
+	}
+
+	_, msg, _, _ = processor.ProcessRequest([]byte("GET /plain HTTP/1.1\r\nHost: example.com\r\n\r\n"), "test-query-2")
+	if msg == nil || msg.Query != nil {
+		t.Errorf("Expected nil query without a query string, got %+v", msg)
+	}
+}
+
+func TestHTTPProcessor_BuildRequestMessage_FormFields(t *testing.T) {
+	logger := slog.Default()
+	processor := NewHTTPProcessor(logger, 1024*1024)
+
+	body := "user=alice&tag=a&tag=b&note=hello+world%21"
+	requestData := []byte(fmt.Sprintf("POST /login HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/x-www-form-urlencoded; charset=utf-8\r\nContent-Length: %d\r\n\r\n%s", len(body), body))
+
+	_, msg, _, err := processor.ProcessRequest(requestData, "test-form-1")
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if msg == nil {
+		t.Fatal("Expected HTTPMessage to be returned")
+	}
+	if string(msg.Body) != body {
+		t.Errorf("Expected raw form body to be captured, got %q", msg.Body)
+	}
+	if got := msg.FormFields["user"]; len(got) != 1 || got[0] != "alice" {
+		t.Errorf("Expected user [alice], got %v", got)
+	}
+	if got := msg.FormFields["tag"]; len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Expected repeated tag values [a b], got %v", got)
+	}
+	if got := msg.FormFields["note"]; len(got) != 1 || got[0] != "hello world!" {
+		t.Errorf("Expected decoded note 'hello world!', got %v", got)
+	}
+
+	jsonBody := `{"user":"alice"}`
+	requestData = []byte(fmt.Sprintf("POST /login HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(jsonBody), jsonBody))
+	_, msg, _, _ = processor.ProcessRequest(requestData, "test-form-2")
+	if msg == nil || msg.FormFields != nil {
+		t.Errorf("Expected no form fields for a JSON body, got %+v", msg)
+	}
+}
+
+func TestHTTPProcessor_XMLResponseDecompressed(t *testing.T) {
+	logger := slog.Default()
+	processor := NewHTTPProcessor(logger, 1024*1024)
+
+	xmlBody := `<?xml version="1.0"?><feed><entry>hello</entry></feed>`
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(xmlBody))
+	gz.Close()
+
+	for _, contentType := range []string{"application/xml", "application/atom+xml", "application/soap+xml; charset=utf-8", "application/yaml"} {
+		responseData := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: %s\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", contentType, buf.Len(), buf.String())
+		_, msg, complete, err := processor.ProcessResponse([]byte(responseData), "test-xml-"+contentType)
+		if err != nil {
+			t.Fatalf("ProcessResponse failed: %v", err)
+		}
+		if !complete || msg == nil {
+			t.Fatalf("Expected a complete response for %s", contentType)
+		}
+		if string(msg.Body) != xmlBody {
+			t.Errorf("Expected decompressed body for %s, got %q", contentType, msg.Body)
+		}
+	}
+}
+
 func TestHTTPProcessor_BuildResponseMessage_WithHeaders(t *testing.T) {
 	logger := slog.Default()
 	processor := NewHTTPProcessor(logger, 1024*1024)
@@ -739,6 +1130,94 @@ func TestHTTPProcessor_DecompressGzip(t *testing.T) {
 	}
 }
 
+func TestHTTPProcessor_RawBody(t *testing.T) {
+	processor := NewHTTPProcessor(slog.Default(), 1024*1024)
+	processor.SetRawBody(true)
+
+	originalBody := `{"message":"Hello World"}`
+	compressedBody := gzipBytes(t, []byte(originalBody))
+	responseData := append([]byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n", len(compressedBody))), compressedBody...)
+
+	_, msg, _, err := processor.ProcessResponse(responseData, "test-raw-1")
+	if err != nil {
+		t.Fatalf("ProcessResponse failed: %v", err)
+	}
+	if msg == nil {
+		t.Fatal("Expected HTTPMessage to be returned")
+	}
+	if string(msg.Body) != originalBody {
+		t.Errorf("Expected decoded body %q, got %q", originalBody, msg.Body)
+	}
+	if !bytes.Equal(msg.RawBody, compressedBody) {
+		t.Errorf("Expected raw body to be the gzip bytes as received, got %q", msg.RawBody)
+	}
+
+	// Off by default
+	_, msg, _, _ = NewHTTPProcessor(slog.Default(), 1024*1024).ProcessResponse(responseData, "test-raw-2")
+	if msg == nil || msg.RawBody != nil {
+		t.Errorf("Expected no raw body unless enabled, got %+v", msg)
+	}
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressBody_NestedEncodings(t *testing.T) {
+	logger := slog.Default()
+	originalBody := `{"message":"Hello World"}`
+
+	doubleGzip := gzipBytes(t, gzipBytes(t, []byte(originalBody)))
+	if got := decompressBody(doubleGzip, "gzip, gzip", "application/json", logger); string(got) != originalBody {
+		t.Errorf("gzip, gzip: expected %q, got %q", originalBody, got)
+	}
+
+	// gzip applied first, then br
+	var buf bytes.Buffer
+	br := brotli.NewWriter(&buf)
+	br.Write(gzipBytes(t, []byte(originalBody)))
+	br.Close()
+	if got := decompressBody(buf.Bytes(), "gzip, br", "application/json", logger); string(got) != originalBody {
+		t.Errorf("gzip, br: expected %q, got %q", originalBody, got)
+	}
+}
+
+func TestDecompressBody_TooManyEncodings(t *testing.T) {
+	body := []byte(`{"message":"Hello World"}`)
+	for range maxContentEncodings + 1 {
+		body = gzipBytes(t, body)
+	}
+	encoding := strings.TrimSuffix(strings.Repeat("gzip,", maxContentEncodings+1), ",")
+
+	got := decompressBody(body, encoding, "application/json", slog.Default())
+	if !bytes.Equal(got, body) {
+		t.Error("Expected body to be returned untouched when nesting exceeds the cap")
+	}
+}
+
+func TestDecompressBody_IdentityAndUnknownEncodings(t *testing.T) {
+	logger := slog.Default()
+	body := []byte(`{"message":"Hello World"}`)
+
+	for _, encoding := range []string{"identity", "Identity", "bogus", "gzip, bogus"} {
+		if got := decompressBody(body, encoding, "application/json", logger); !bytes.Equal(got, body) {
+			t.Errorf("%q: expected raw body, got %q", encoding, got)
+		}
+	}
+
+	// identity layers are skipped when combined with a real encoding
+	if got := decompressBody(gzipBytes(t, body), "identity, gzip", "application/json", logger); !bytes.Equal(got, body) {
+		t.Errorf("identity, gzip: expected %q, got %q", body, got)
+	}
+}
+
 func TestHTTPProcessor_DefaultMaxBodySize(t *testing.T) {
 	logger := slog.Default()
 	processor := NewHTTPProcessor(logger, 0) // Should use default 1MB
@@ -793,3 +1272,359 @@ func TestHTTPProcessor_ResponseWithNoContentLength(t *testing.T) {
 	_ = isComplete
 	_ = msg
 }
+
+func TestHTTPProcessor_SkipRequestBody(t *testing.T) {
+	logger := slog.Default()
+	processor := NewHTTPProcessor(logger, 1024*1024)
+	processor.SetSkipBody(true, false)
+
+	requestData := []byte("POST /v1/messages HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/json\r\nContent-Length: 13\r\n\r\n{\"key\":\"val\"}")
+	_, reqMsg, isComplete, err := processor.ProcessRequest(requestData, "test-skip-req")
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if !isComplete || reqMsg == nil {
+		t.Fatal("Expected complete request message")
+	}
+	if len(reqMsg.Body) != 0 {
+		t.Errorf("Expected empty request body, got '%s'", reqMsg.Body)
+	}
+	if reqMsg.BodySize != 13 {
+		t.Errorf("Expected request body size 13, got %d", reqMsg.BodySize)
+	}
+	if reqMsg.Method != "POST" || reqMsg.Path != "/v1/messages" {
+		t.Errorf("Expected POST /v1/messages, got %s %s", reqMsg.Method, reqMsg.Path)
+	}
+	if reqMsg.Headers["Content-Type"] != "application/json" {
+		t.Errorf("Expected Content-Type header to be recorded, got %v", reqMsg.Headers)
+	}
+
+	// Response body is still captured
+	responseData := []byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 12\r\n\r\nHello Server")
+	_, respMsg, _, err := processor.ProcessResponse(responseData, "test-skip-req")
+	if err != nil {
+		t.Fatalf("ProcessResponse failed: %v", err)
+	}
+	if respMsg == nil || string(respMsg.Body) != "Hello Server" {
+		t.Errorf("Expected response body 'Hello Server', got %v", respMsg)
+	}
+}
+
+func TestHTTPProcessor_SkipResponseBody(t *testing.T) {
+	logger := slog.Default()
+	processor := NewHTTPProcessor(logger, 1024*1024)
+	processor.SetSkipBody(false, true)
+
+	// Compressed body must not be decompressed when skipped
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("Hello Server"))
+	gz.Close()
+	compressed := buf.Bytes()
+
+	responseData := fmt.Appendf(nil, "HTTP/1.1 404 Not Found\r\nContent-Type: text/plain\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n", len(compressed))
+	responseData = append(responseData, compressed...)
+
+	_, respMsg, isComplete, err := processor.ProcessResponse(responseData, "test-skip-resp")
+	if err != nil {
+		t.Fatalf("ProcessResponse failed: %v", err)
+	}
+	if !isComplete || respMsg == nil {
+		t.Fatal("Expected complete response message")
+	}
+	if len(respMsg.Body) != 0 {
+		t.Errorf("Expected empty response body, got '%s'", respMsg.Body)
+	}
+	if respMsg.BodySize != int64(len(compressed)) {
+		t.Errorf("Expected response body size %d, got %d", len(compressed), respMsg.BodySize)
+	}
+	if respMsg.StatusCode != 404 {
+		t.Errorf("Expected status 404, got %d", respMsg.StatusCode)
+	}
+	if respMsg.Headers["Content-Encoding"] != "gzip" {
+		t.Errorf("Expected Content-Encoding header to be recorded, got %v", respMsg.Headers)
+	}
+
+	// Request body is still captured
+	requestData := []byte("POST / HTTP/1.1\r\nHost: example.com\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nHello")
+	_, reqMsg, _, err := processor.ProcessRequest(requestData, "test-skip-resp")
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if reqMsg == nil || string(reqMsg.Body) != "Hello" {
+		t.Errorf("Expected request body 'Hello', got %v", reqMsg)
+	}
+}
+
+func TestHTTPProcessor_MetadataOnly(t *testing.T) {
+	logger := slog.Default()
+	processor := NewHTTPProcessor(logger, 1024*1024)
+	processor.SetMetadataOnly(true)
+
+	// Body arrives over several reads and is never buffered
+	requestID := "test-metadata"
+	head := "POST /v1/messages?beta=true HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/json\r\nContent-Length: 26\r\n\r\n"
+	chunks := []string{head + `{"secret":`, `"do not keep`, `"}  `}
+	var reqMsg *HTTPMessage
+	for i, chunk := range chunks {
+		_, msg, complete, err := processor.ProcessRequest([]byte(chunk), requestID)
+		if err != nil {
+			t.Fatalf("ProcessRequest failed: %v", err)
+		}
+		if val, ok := processor.pendingReqs.Load(requestID); ok {
+			if held := len(val.(*pendingHTTPRequest).data) - len(head); held > len(chunkedTerminator) {
+				t.Errorf("Expected body bytes to be dropped, %d still buffered", held)
+			}
+		}
+		if complete != (i == len(chunks)-1) {
+			t.Fatalf("Chunk %d: unexpected completion %v", i, complete)
+		}
+		reqMsg = msg
+	}
+	if reqMsg == nil {
+		t.Fatal("Expected complete request message")
+	}
+	if len(reqMsg.Body) != 0 {
+		t.Errorf("Expected empty request body, got '%s'", reqMsg.Body)
+	}
+	if reqMsg.BodySize != 26 {
+		t.Errorf("Expected request body size 26, got %d", reqMsg.BodySize)
+	}
+	if reqMsg.Method != "POST" || reqMsg.Path != "/v1/messages?beta=true" || reqMsg.Hostname != "example.com" {
+		t.Errorf("Expected POST example.com/v1/messages?beta=true, got %s %s%s", reqMsg.Method, reqMsg.Hostname, reqMsg.Path)
+	}
+	if reqMsg.Headers["Content-Type"] != "application/json" {
+		t.Errorf("Expected Content-Type header to be recorded, got %v", reqMsg.Headers)
+	}
+
+	// Chunked response whose terminator is split across reads
+	respChunks := []string{
+		"HTTP/1.1 201 Created\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nHello\r\n6\r\n World\r\n0\r",
+		"\n\r\n",
+	}
+	var respMsg *HTTPMessage
+	var complete bool
+	for _, chunk := range respChunks {
+		var err error
+		_, respMsg, complete, err = processor.ProcessResponse([]byte(chunk), requestID)
+		if err != nil {
+			t.Fatalf("ProcessResponse failed: %v", err)
+		}
+	}
+	if !complete || respMsg == nil {
+		t.Fatal("Expected complete response message")
+	}
+	if len(respMsg.Body) != 0 {
+		t.Errorf("Expected empty response body, got '%s'", respMsg.Body)
+	}
+	if respMsg.StatusCode != 201 || respMsg.ContentType != "text/plain" {
+		t.Errorf("Expected 201 text/plain, got %d %s", respMsg.StatusCode, respMsg.ContentType)
+	}
+	if respMsg.BodySize == 0 {
+		t.Error("Expected response body size to be recorded")
+	}
+}
+
+func TestHTTPProcessor_ProcessResponse_NDJSON_Incremental(t *testing.T) {
+	logger := slog.Default()
+	processor := NewHTTPProcessor(logger, 1024*1024)
+
+	requestID := "test-resp-ndjson"
+
+	// Chunked ndjson without Content-Length, the second object is split across chunks
+	chunks := [][]byte{
+		[]byte("HTTP/1.1 200 OK\r\nContent-Type: application/x-ndjson\r\nTransfer-Encoding: chunked\r\n\r\n"),
+		[]byte("d\r\n{\"n\":1}\n{\"n\":\r\n"),
+		[]byte("3\r\n2}\n\r\n"),
+		[]byte("8\r\n{\"n\":3}\n\r\n"),
+	}
+	wantObjects := []int{0, 1, 2, 3}
+
+	for i, chunk := range chunks {
+		result, msg, complete, err := processor.ProcessResponse(chunk, requestID)
+		if err != nil {
+			t.Fatalf("chunk %d: ProcessResponse failed: %v", i, err)
+		}
+		if complete {
+			t.Errorf("chunk %d: expected NDJSON stream to never be complete", i)
+		}
+		if msg == nil || !msg.IsNDJSON || !msg.IsStream() {
+			t.Fatalf("chunk %d: expected NDJSON stream message, got %+v", i, msg)
+		}
+
+		if !bytes.Equal(result, chunk) {
+			t.Errorf("chunk %d: expected chunk to be passed through", i)
+		}
+
+		objects := msg.NDJSONObjects()
+		if len(objects) != wantObjects[i] {
+			t.Fatalf("chunk %d: expected %d objects, got %d: %q", i, wantObjects[i], len(objects), objects)
+		}
+		for j, obj := range objects {
+			if want := fmt.Sprintf(`{"n":%d}`, j+1); string(obj) != want {
+				t.Errorf("chunk %d: object %d = %s, want %s", i, j, obj, want)
+			}
+		}
+	}
+}
+
+func TestHTTPProcessor_ProcessResponse_100Continue(t *testing.T) {
+	processor := NewHTTPProcessor(slog.Default(), 1024*1024)
+	final := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nHello"
+
+	// Interim response in its own read, the final response arrives once the client sent the body
+	requestID := "test-continue-1"
+	_, msg, complete, err := processor.ProcessResponse([]byte("HTTP/1.1 100 Continue\r\n\r\n"), requestID)
+	if err != nil {
+		t.Fatalf("ProcessResponse failed: %v", err)
+	}
+	if complete || msg != nil {
+		t.Fatalf("Expected 100 Continue not to complete the response, got complete=%v msg=%+v", complete, msg)
+	}
+	result, msg, complete, err := processor.ProcessResponse([]byte(final), requestID)
+	if err != nil {
+		t.Fatalf("ProcessResponse failed: %v", err)
+	}
+	if !complete || msg == nil || msg.StatusCode != 200 || string(msg.Body) != "Hello" {
+		t.Fatalf("Expected final 200 response with body, got complete=%v msg=%+v", complete, msg)
+	}
+	if string(result) != final {
+		t.Errorf("Expected result to hold only the final response, got %q", result)
+	}
+
+	// Interim responses and the start of the final response in one read
+	requestID = "test-continue-2"
+	data := "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" + final[:len(final)-3]
+	if _, msg, complete, _ = processor.ProcessResponse([]byte(data), requestID); complete || msg != nil {
+		t.Fatalf("Expected incomplete response, got complete=%v msg=%+v", complete, msg)
+	}
+	_, msg, complete, _ = processor.ProcessResponse([]byte(final[len(final)-3:]), requestID)
+	if !complete || msg == nil || msg.StatusCode != 200 || string(msg.Body) != "Hello" {
+		t.Fatalf("Expected final 200 response with body, got complete=%v msg=%+v", complete, msg)
+	}
+}
+
+func TestHTTPProcessor_ProcessRequest_ExpectContinue(t *testing.T) {
+	processor := NewHTTPProcessor(slog.Default(), 1024*1024)
+	requestID := "test-expect-1"
+
+	// The client holds the body back until the server answers 100 Continue
+	headers := "POST /upload HTTP/1.1\r\nHost: example.com\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n"
+	if _, msg, complete, _ := processor.ProcessRequest([]byte(headers), requestID); complete || msg != nil {
+		t.Fatalf("Expected request to wait for its body, got complete=%v", complete)
+	}
+	_, msg, complete, err := processor.ProcessRequest([]byte("data"), requestID)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if !complete || msg == nil || string(msg.Body) != "data" {
+		t.Fatalf("Expected complete request with body, got complete=%v msg=%+v", complete, msg)
+	}
+}
+
+func TestInterimResponsesLen(t *testing.T) {
+	tests := []struct {
+		data string
+		want int
+	}{
+		{"HTTP/1.1 200 OK\r\n\r\n", 0},
+		{"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n", 0},
+		{"HTTP/1.1 100 Continue\r\n\r\n", 25},
+		{"HTTP/1.1 100 Continue\r\n", 0},
+		{"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\n\r\n", 25},
+		{"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 102 Processing\r\n\r\n", 52},
+	}
+	for _, tt := range tests {
+		if got := interimResponsesLen([]byte(tt.data)); got != tt.want {
+			t.Errorf("interimResponsesLen(%q) = %d, want %d", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPProcessor_ClearConnectionClosesDecoder(t *testing.T) {
+	processor := NewHTTPProcessor(slog.Default(), 1024*1024)
+
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	gw.Write([]byte("data: {\"text\":\"hello\"}\n\n"))
+	gw.Flush()
+	headers := "HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nContent-Encoding: gzip\r\n\r\n"
+	for _, requestID := range []string{"conn-1-1", "conn-2-1"} {
+		processor.ProcessResponse(append([]byte(headers), compressed.Bytes()...), requestID)
+	}
+
+	val, _ := processor.pendingResps.Load("conn-1-1")
+	decoder := val.(*pendingHTTPResponse).decoder
+	if decoder == nil {
+		t.Fatal("Expected gzip stream to start a decoder")
+	}
+
+	processor.ClearConnection("conn-1")
+	select {
+	case <-decoder.done:
+	default:
+		t.Error("Expected decoder goroutine of the closed connection to exit")
+	}
+	if _, exists := processor.pendingResps.Load("conn-1-1"); exists {
+		t.Error("Expected pending stream of the closed connection to be cleared")
+	}
+	if _, exists := processor.pendingResps.Load("conn-2-1"); !exists {
+		t.Error("Expected other connections to keep their pending stream")
+	}
+	processor.ClearPending("conn-2-1")
+}
+
+func TestHTTPProcessor_StreamCompletes(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers string
+		body    []string
+	}{
+		{"chunked", "Transfer-Encoding: chunked\r\n", []string{"b\r\ndata: one\n\n\r\n", "0\r\n\r\n"}},
+		{"content length", "Content-Length: 22\r\n", []string{"data: one\n\n", "data: two\n\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := NewHTTPProcessor(slog.Default(), 1024*1024)
+			requestID := "conn-stream-1"
+
+			head := "HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\n" + tt.headers + "\r\n"
+			_, msg, complete, _ := processor.ProcessResponse([]byte(head+tt.body[0]), requestID)
+			if complete || msg == nil {
+				t.Fatalf("Expected open stream after first chunk, got complete=%v", complete)
+			}
+			_, msg, complete, _ = processor.ProcessResponse([]byte(tt.body[1]), requestID)
+			if !complete || msg == nil || !msg.IsSSE {
+				t.Fatalf("Expected stream to complete with its last bytes, got complete=%v msg=%+v", complete, msg)
+			}
+			if _, exists := processor.pendingResps.Load(requestID); exists {
+				t.Error("Expected pending state of the complete stream to be cleared")
+			}
+		})
+	}
+}
+
+func TestHTTPProcessor_MetadataOnlySplitTrailer(t *testing.T) {
+	processor := NewHTTPProcessor(slog.Default(), 1024*1024)
+	processor.SetMetadataOnly(true)
+	requestID := "test-split-trailer"
+
+	// The trailer section after the last chunk spans two reads
+	head := "POST /upload HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n"
+	reads := []string{head + "5\r\nhello\r\n0\r\nX-Checksum: ab", "cdef\r\n\r\n"}
+	for i, read := range reads {
+		_, msg, complete, err := processor.ProcessRequest([]byte(read), requestID)
+		if err != nil {
+			t.Fatalf("ProcessRequest failed: %v", err)
+		}
+		if complete != (i == len(reads)-1) {
+			t.Fatalf("Read %d: unexpected completion %v", i, complete)
+		}
+		if complete && msg == nil {
+			t.Fatal("Expected complete request message")
+		}
+	}
+	if _, exists := processor.pendingReqs.Load(requestID); exists {
+		t.Error("Expected pending state to be released once the trailer completes")
+	}
+}
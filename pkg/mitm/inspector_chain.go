@@ -1,20 +1,44 @@
 package mitm
 
 import (
+	"container/list"
 	"errors"
 	"io"
 	"log/slog"
+	"path"
 	"strconv"
+	"strings"
 	"sync"
 )
 
 type InspectorChain struct {
+	inspectors  []Inspector
+	profiles    []inspectorProfile
+	selectedMu  sync.Mutex
+	selected    map[string]*list.Element // hostname -> element of selectedLRU, capped at maxSelectedHosts
+	selectedLRU *list.List               // *selectedInspectors, most recently used first
+}
+
+// maxSelectedHosts caps the cached profile matches, since the hostnames come from the client's SNI
+const maxSelectedHosts = 1024
+
+// selectedInspectors is the profile match of a hostname
+type selectedInspectors struct {
+	hostname   string
+	inspectors []Inspector
+}
+
+// inspectorProfile runs inspectors, in order, for hostnames matching any of hosts
+type inspectorProfile struct {
+	hosts      []string // Lowercase hostname globs
 	inspectors []Inspector
 }
 
 func NewInspectorChain() *InspectorChain {
 	return &InspectorChain{
-		inspectors: make([]Inspector, 0),
+		inspectors:  make([]Inspector, 0),
+		selected:    make(map[string]*list.Element),
+		selectedLRU: list.New(),
 	}
 }
 
@@ -22,9 +46,56 @@ func (c *InspectorChain) Add(inspector Inspector) {
 	c.inspectors = append(c.inspectors, inspector)
 }
 
+// addProfile makes hostnames matching hosts run only inspectors, profiles are tried in the order added
+func (c *InspectorChain) addProfile(hosts []string, inspectors []Inspector) {
+	lower := make([]string, len(hosts))
+	for i, host := range hosts {
+		lower[i] = strings.ToLower(host)
+	}
+	c.profiles = append(c.profiles, inspectorProfile{hosts: lower, inspectors: inspectors})
+}
+
+// inspectorsFor returns the inspectors of the first profile matching hostname, all of them when none match
+func (c *InspectorChain) inspectorsFor(hostname string) []Inspector {
+	if len(c.profiles) == 0 {
+		return c.inspectors
+	}
+	c.selectedMu.Lock()
+	if elem, ok := c.selected[hostname]; ok {
+		c.selectedLRU.MoveToFront(elem)
+		inspectors := elem.Value.(*selectedInspectors).inspectors
+		c.selectedMu.Unlock()
+		return inspectors
+	}
+	c.selectedMu.Unlock()
+
+	selected := c.inspectors
+	host := strings.ToLower(hostname)
+profiles:
+	for _, profile := range c.profiles {
+		for _, pattern := range profile.hosts {
+			if matched, _ := path.Match(pattern, host); matched {
+				selected = profile.inspectors
+				break profiles
+			}
+		}
+	}
+	c.selectedMu.Lock()
+	defer c.selectedMu.Unlock()
+	if elem, ok := c.selected[hostname]; ok {
+		c.selectedLRU.Remove(elem)
+	}
+	c.selected[hostname] = c.selectedLRU.PushFront(&selectedInspectors{hostname: hostname, inspectors: selected})
+	for c.selectedLRU.Len() > maxSelectedHosts {
+		oldest := c.selectedLRU.Remove(c.selectedLRU.Back()).(*selectedInspectors)
+		delete(c.selected, oldest.hostname)
+	}
+	return selected
+}
+
 func (c *InspectorChain) Inspect(direction Direction, data []byte, hostname string, connectionID, requestID string) error {
 	errs := make([]error, 0)
-	for _, inspector := range c.inspectors {
+	for _, inspector := range c.inspectorsFor(hostname) {
 		_, err := inspector.Inspect(direction, data, hostname, connectionID, requestID)
 		if err != nil {
 			errs = append(errs, err)
@@ -33,8 +104,22 @@ func (c *InspectorChain) Inspect(direction Direction, data []byte, hostname stri
 	return errors.Join(errs...)
 }
 
-func (c *InspectorChain) ShouldInspect(hostname string) bool {
+// finalizer is implemented by inspectors holding per-connection state to flush when it closes
+type finalizer interface {
+	Finalize(connectionID string)
+}
+
+// Finalize lets every inspector flush state of a closed connection, such as unterminated streams
+func (c *InspectorChain) Finalize(connectionID string) {
 	for _, inspector := range c.inspectors {
+		if f, ok := inspector.(finalizer); ok {
+			f.Finalize(connectionID)
+		}
+	}
+}
+
+func (c *InspectorChain) ShouldInspect(hostname string) bool {
+	for _, inspector := range c.inspectorsFor(hostname) {
 		if inspector.ShouldInspect(hostname) {
 			return true
 		}
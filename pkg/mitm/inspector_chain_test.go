@@ -0,0 +1,129 @@
+package mitm
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingInspector records the hostnames it was asked to inspect
+type recordingInspector struct {
+	*BaseInspector
+	mu    sync.Mutex
+	hosts []string
+}
+
+func newRecordingInspector(name string) *recordingInspector {
+	return &recordingInspector{BaseInspector: NewBaseInspector(name, "")}
+}
+
+func (r *recordingInspector) Inspect(direction Direction, data []byte, hostname string, connectionID, requestID string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts = append(r.hosts, hostname)
+	return data, nil
+}
+
+func (r *recordingInspector) inspected(hostname string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Contains(r.hosts, hostname)
+}
+
+func TestInspectorChain_Profiles(t *testing.T) {
+	llmInspector := newRecordingInspector("llm")
+	sseInspector := newRecordingInspector("sse")
+	chain := NewInspectorChain()
+	chain.Add(llmInspector)
+	chain.Add(sseInspector)
+	chain.addProfile([]string{"*.anthropic.com", "api.openai.com"}, []Inspector{llmInspector})
+	chain.addProfile([]string{"*.internal"}, nil)
+	chain.addProfile([]string{"*"}, []Inspector{sseInspector})
+
+	tests := []struct {
+		host       string
+		wantLLM    bool
+		wantSSE    bool
+		wantActive bool
+	}{
+		{"api.anthropic.com", true, false, true},
+		{"API.OpenAI.com", true, false, true},
+		{"db.internal", false, false, false},
+		{"example.com", false, true, true},
+	}
+	for _, tt := range tests {
+		if got := chain.ShouldInspect(tt.host); got != tt.wantActive {
+			t.Errorf("ShouldInspect(%s) = %v, want %v", tt.host, got, tt.wantActive)
+		}
+		chain.Inspect(DirectionClientToServer, []byte("data"), tt.host, "conn-1", "req-1")
+		if got := llmInspector.inspected(tt.host); got != tt.wantLLM {
+			t.Errorf("llm inspector ran for %s = %v, want %v", tt.host, got, tt.wantLLM)
+		}
+		if got := sseInspector.inspected(tt.host); got != tt.wantSSE {
+			t.Errorf("sse inspector ran for %s = %v, want %v", tt.host, got, tt.wantSSE)
+		}
+	}
+}
+
+func TestInspectorChain_NoProfileRunsAll(t *testing.T) {
+	llmInspector := newRecordingInspector("llm")
+	sseInspector := newRecordingInspector("sse")
+	chain := NewInspectorChain()
+	chain.Add(llmInspector)
+	chain.Add(sseInspector)
+	chain.addProfile([]string{"*.anthropic.com"}, []Inspector{llmInspector})
+
+	chain.Inspect(DirectionClientToServer, []byte("data"), "example.com", "conn-1", "req-1")
+	if !llmInspector.inspected("example.com") || !sseInspector.inspected("example.com") {
+		t.Error("Expected a host matching no profile to run every inspector")
+	}
+}
+
+func TestInspectorChain_SelectedHostsBounded(t *testing.T) {
+	llmInspector := newRecordingInspector("llm")
+	chain := NewInspectorChain()
+	chain.Add(llmInspector)
+	chain.addProfile([]string{"*.anthropic.com"}, []Inspector{llmInspector})
+
+	for i := range maxSelectedHosts + 10 {
+		chain.ShouldInspect(fmt.Sprintf("host%d.example.com", i))
+	}
+	if got := len(chain.selected); got != maxSelectedHosts {
+		t.Errorf("Expected %d cached hosts, got %d", maxSelectedHosts, got)
+	}
+	if _, ok := chain.selected["host0.example.com"]; ok {
+		t.Error("Expected the least recently used host to be evicted")
+	}
+	if !chain.ShouldInspect("api.anthropic.com") {
+		t.Error("Expected a profile host to be inspected after evictions")
+	}
+}
+
+func TestNewManager_InvalidProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles []InspectionProfile
+		wantErr  string
+	}{
+		{"unknown inspector", []InspectionProfile{{Hosts: []string{"*"}, Inspectors: []string{"log"}}}, "unknown inspector"},
+		{"bad glob", []InspectionProfile{{Hosts: []string{"[a-"}, Inspectors: []string{InspectorSSE}}}, "invalid inspection profile host"},
+		{"no hosts", []InspectionProfile{{Inspectors: []string{InspectorLLM}}}, "has no hosts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			_, err := NewManager(ManagerConfig{
+				CACertPath:   dir + "/ca.crt",
+				CAKeyPath:    dir + "/ca.key",
+				CertCacheDir: dir + "/certs",
+				Profiles:     tt.profiles,
+			}, slog.Default())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
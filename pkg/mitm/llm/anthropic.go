@@ -1,11 +1,10 @@
 package llm
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"strings"
 )
 
@@ -46,6 +45,9 @@ type AnthropicContent struct {
 	ID       string                 `json:"id,omitempty"`    // for tool_use
 	Name     string                 `json:"name,omitempty"`  // for tool_use
 	Input    map[string]interface{} `json:"input,omitempty"` // for tool_use
+	// for web_search_tool_result
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
 }
 
 type ImageSource struct {
@@ -100,6 +102,9 @@ type AnthropicStreamEvent struct {
 		Thinking string `json:"thinking,omitempty"`
 		ID       string `json:"id,omitempty"`   // for tool_use
 		Name     string `json:"name,omitempty"` // for tool_use
+		// for web_search_tool_result
+		ToolUseID string          `json:"tool_use_id,omitempty"`
+		Content   json.RawMessage `json:"content,omitempty"`
 	} `json:"content_block,omitempty"`
 }
 
@@ -115,6 +120,11 @@ func (a anthropicProvider) Match(hostname, path string, body []byte) bool {
 		return true
 	}
 
+	// Match Anthropic models hosted on Vertex AI (:rawPredict / :streamRawPredict)
+	if publisher, _, ok := parseVertexPath(hostname, path); ok && publisher == "anthropic" {
+		return true
+	}
+
 	// Check custom matches
 	if a.customMatches != nil {
 		for _, match := range a.customMatches.CustomAnthropicMatches {
@@ -141,24 +151,57 @@ func (a anthropicProvider) extractConversationID(hostname string, headers map[st
 		}
 	}
 
+	var strategy, header string
+	if a.customMatches != nil {
+		strategy = a.customMatches.ConversationIDStrategy
+		header = a.customMatches.ConversationIDHeader
+	}
+
+	switch strategy {
+	case ConversationIDHeader:
+		// header 缺失时退回 metadata 策略
+		if header != "" {
+			if value := headers[http.CanonicalHeaderKey(header)]; value != "" {
+				return fmt.Sprintf("anthropic-%s", value)
+			}
+		}
+	case ConversationIDMessagesHash:
+		// 同一会话的历史只会追加，system 和首条消息保持不变
+		if len(req.Messages) > 0 {
+			first, _ := json.Marshal(req.Messages[0])
+			system, _ := json.Marshal(req.System)
+			return fmt.Sprintf("anthropic-%s", shortHash(string(system)+"\n"+string(first)))
+		}
+	}
+
 	// 从 metadata.user_id 获取会话 ID
 	if req.Metadata != nil && req.Metadata.UserID != "" {
 		// 对 UserID 进行哈希处理，取前6位
-		hash := sha256.Sum256([]byte(req.Metadata.UserID))
-		hashStr := hex.EncodeToString(hash[:])
-		if len(hashStr) > 6 {
-			hashStr = hashStr[:6]
-		}
-		return fmt.Sprintf("anthropic-%s", hashStr)
+		return fmt.Sprintf("anthropic-%s", shortHash(req.Metadata.UserID))
 	}
 
 	// 如果没有 UserID，返回固定 ID
 	return "anthropic-default"
 }
 
+// anthropicEndpoint returns the non-chat endpoint addressed by path, empty for /v1/messages itself
+func anthropicEndpoint(path string) string {
+	switch {
+	case strings.Contains(path, "/v1/messages/count_tokens"):
+		return "count_tokens"
+	case strings.Contains(path, "/v1/messages/batches"):
+		return "batches"
+	}
+	return ""
+}
+
 func (a anthropicProvider) ParseResponse(path string, body []byte) (*LLMResponse, error) {
+	if anthropicEndpoint(path) == "batches" {
+		return nil, fmt.Errorf("batch responses are not conversation turns")
+	}
+
 	// Handle count_tokens endpoint
-	if strings.Contains(path, "/v1/messages/count_tokens") {
+	if anthropicEndpoint(path) == "count_tokens" {
 		var countResp struct {
 			InputTokens int `json:"input_tokens"`
 		}
@@ -197,7 +240,7 @@ func (a anthropicProvider) ParseResponse(path string, body []byte) (*LLMResponse
 			textContent += c.Text
 		case "thinking":
 			thinkingContent += c.Thinking
-		case "tool_use":
+		case "tool_use", "server_tool_use":
 			args, _ := json.Marshal(c.Input)
 			toolCalls = append(toolCalls, ToolCall{
 				ID:   c.ID,
@@ -206,7 +249,10 @@ func (a anthropicProvider) ParseResponse(path string, body []byte) (*LLMResponse
 					Name:      c.Name,
 					Arguments: string(args),
 				},
+				ArgumentsComplete: true,
 			})
+		case "web_search_tool_result":
+			toolCalls = attachServerToolResult(toolCalls, c.ToolUseID, summarizeWebSearchResult(c.Content))
 		}
 	}
 
@@ -263,11 +309,26 @@ func (a anthropicProvider) extractToolsFromReq(req *AnthropicRequest) []ToolDef
 }
 
 // ParseFullRequest parses the request body once and returns all extracted info
-func (a anthropicProvider) ParseFullRequest(hostname string, headers map[string]string, body []byte) (*RequestInfo, error) {
+func (a anthropicProvider) ParseFullRequest(hostname, path string, headers map[string]string, body []byte) (*RequestInfo, error) {
+	// Batch bodies wrap many requests and count_tokens only sizes a prompt, neither is a chat turn
+	if endpoint := anthropicEndpoint(path); endpoint != "" {
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.Unmarshal(body, &req)
+		return &RequestInfo{Model: req.Model, Endpoint: endpoint}, nil
+	}
+
 	var req AnthropicRequest
 	if err := json.Unmarshal(body, &req); err != nil {
 		return nil, fmt.Errorf("failed to parse Anthropic request: %w", err)
 	}
+	if req.Model == "" {
+		// Vertex AI carries the model in the path rather than the body
+		if _, model, ok := parseVertexPath(hostname, path); ok {
+			req.Model = model
+		}
+	}
 
 	return &RequestInfo{
 		ConversationID: a.extractConversationID(hostname, headers, &req),
@@ -279,15 +340,14 @@ func (a anthropicProvider) ParseFullRequest(hostname string, headers map[string]
 }
 
 // ParseSSEStreamFrom parses SSE stream from a specific position for incremental processing
-func (a anthropicProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDelta {
+func (a anthropicProvider) ParseSSEStreamFrom(body []byte, startPos int) ([]TokenDelta, int) {
 	if startPos >= len(body) {
 		a.logger.Warn("sse startPos > len(body)", "startPos", startPos)
-		return nil
+		return nil, startPos
 	}
 
 	// Extract new lines from start position
 	remaining := string(body[startPos:])
-	lines := strings.Split(remaining, "\n")
 
 	// Track tool information by content block index
 	toolInfoByIndex := make(map[int]struct {
@@ -306,6 +366,9 @@ func (a anthropicProvider) ParseSSEStreamFrom(body []byte, startPos int) []Token
 		if newDelta.Usage == (TokenUsage{}) {
 			newDelta.Usage = cumulativeUsage
 		}
+		if newDelta.Text != "" || newDelta.Thinking != "" || newDelta.ToolData != "" {
+			newDelta.ContentEvents = 1
+		}
 
 		// First delta always gets appended
 		if len(deltas) == 0 {
@@ -318,12 +381,14 @@ func (a anthropicProvider) ParseSSEStreamFrom(body []byte, startPos int) []Token
 		// 1. Merge text content
 		if newDelta.Text != "" {
 			last.Text += newDelta.Text
+			last.ContentEvents += newDelta.ContentEvents
 			return
 		}
 
 		// 2. Merge thinking content
 		if newDelta.Thinking != "" {
 			last.Thinking += newDelta.Thinking
+			last.ContentEvents += newDelta.ContentEvents
 			return
 		}
 
@@ -345,6 +410,7 @@ func (a anthropicProvider) ParseSSEStreamFrom(body []byte, startPos int) []Token
 				(newDelta.ToolName == "" || newDelta.ToolName == last.ToolName)
 			if sameTool && !strings.HasSuffix(last.ToolData, newDelta.ToolData) {
 				last.ToolData += newDelta.ToolData
+				last.ContentEvents += newDelta.ContentEvents
 				return
 			}
 		}
@@ -353,33 +419,16 @@ func (a anthropicProvider) ParseSSEStreamFrom(body []byte, startPos int) []Token
 		deltas = append(deltas, newDelta)
 	}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// Parse event/data lines, supporting both "event:" and "event: " (with/without space)
-		var data string
-
-		if strings.HasPrefix(line, "event:") {
-			// Event type line - skip, we'll extract type from data JSON
-			continue
-		} else if strings.HasPrefix(line, "data:") {
-			data = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
-		} else {
-			a.logger.Warn("skipping sse line", "line", line)
-			continue
-		}
-
-		if data == "" || data == "[DONE]" {
-			continue
+	consumed, failed := forEachSSEData(remaining, func(data string) error {
+		if data == "[DONE]" {
+			// Sent by some compatible gateways after message_delta, merges into it when both arrive
+			tryMergeDelta(TokenDelta{IsComplete: true})
+			return nil
 		}
 
 		var event AnthropicStreamEvent
 		if err := json.Unmarshal([]byte(data), &event); err != nil {
-			a.logger.Warn("failed to parse Anthropic SSE event", "error", err, "data", data)
-			continue
+			return err
 		}
 
 		switch event.Type {
@@ -409,7 +458,7 @@ func (a anthropicProvider) ParseSSEStreamFrom(body []byte, startPos int) []Token
 			switch event.ContentBlock.Type {
 			case "thinking":
 				a.logger.Debug("thinking block started", "index", event.Index)
-			case "tool_use":
+			case "tool_use", "server_tool_use":
 				toolInfoByIndex[event.Index] = struct {
 					toolID   string
 					toolName string
@@ -421,6 +470,12 @@ func (a anthropicProvider) ParseSSEStreamFrom(body []byte, startPos int) []Token
 					ToolName: event.ContentBlock.Name,
 					ToolID:   event.ContentBlock.ID,
 				})
+			case "web_search_tool_result":
+				// Results arrive whole in the start event, no deltas follow
+				tryMergeDelta(TokenDelta{
+					ToolID:     event.ContentBlock.ToolUseID,
+					ToolResult: summarizeWebSearchResult(event.ContentBlock.Content),
+				})
 			}
 		case "content_block_stop":
 			a.logger.Debug("content block stopped", "index", event.Index)
@@ -443,9 +498,10 @@ func (a anthropicProvider) ParseSSEStreamFrom(body []byte, startPos int) []Token
 			})
 		case "message_start":
 			a.logger.Debug("message started", "role", event.Message.Role)
-			// Update cumulative input tokens
+			// Update cumulative input tokens, reported right away so they are known before completion
 			if event.Message.Usage.InputTokens > 0 {
 				cumulativeUsage.InputTokens = event.Message.Usage.InputTokens
+				tryMergeDelta(TokenDelta{Usage: TokenUsage{InputTokens: cumulativeUsage.InputTokens}})
 			}
 		case "message_stop":
 			a.logger.Debug("message stopped")
@@ -467,7 +523,61 @@ func (a anthropicProvider) ParseSSEStreamFrom(body []byte, startPos int) []Token
 		default:
 			a.logger.Debug("unhandled Anthropic event", "type", event.Type, "data", data)
 		}
+		return nil
+	})
+	for _, f := range failed {
+		a.logger.Warn("failed to parse Anthropic SSE event", "error", f.err, "data", f.data)
+	}
+
+	return deltas, startPos + consumed
+}
+
+// maxWebSearchResultsSummarized caps the hits listed in a web search result summary
+const maxWebSearchResultsSummarized = 5
+
+// summarizeWebSearchResult renders a web_search_tool_result content as a short summary,
+// e.g. `2 results: Go (https://go.dev); ...` or `error: max_uses_exceeded`
+func summarizeWebSearchResult(content json.RawMessage) string {
+	var results []struct {
+		Type  string `json:"type"`
+		Title string `json:"title"`
+		URL   string `json:"url"`
+	}
+	if err := json.Unmarshal(content, &results); err != nil {
+		// Failed searches carry a single error object instead of a result list
+		var searchErr struct {
+			ErrorCode string `json:"error_code"`
+		}
+		if json.Unmarshal(content, &searchErr) == nil && searchErr.ErrorCode != "" {
+			return "error: " + searchErr.ErrorCode
+		}
+		return ""
+	}
+
+	parts := make([]string, 0, min(len(results), maxWebSearchResultsSummarized))
+	for _, r := range results[:min(len(results), maxWebSearchResultsSummarized)] {
+		parts = append(parts, fmt.Sprintf("%s (%s)", r.Title, r.URL))
 	}
+	summary := fmt.Sprintf("%d results", len(results))
+	if len(results) == 1 {
+		summary = "1 result"
+	}
+	if len(parts) > 0 {
+		summary += ": " + strings.Join(parts, "; ")
+	}
+	if len(results) > len(parts) {
+		summary += "; ..."
+	}
+	return summary
+}
 
-	return deltas
+// attachServerToolResult sets result on the tool call toolUseID, or records a result-only call when it is missing
+func attachServerToolResult(toolCalls []ToolCall, toolUseID, result string) []ToolCall {
+	for i := range toolCalls {
+		if toolCalls[i].ID == toolUseID {
+			toolCalls[i].Result = result
+			return toolCalls
+		}
+	}
+	return append(toolCalls, ToolCall{ID: toolUseID, Type: "function", Result: result, ArgumentsComplete: true})
 }
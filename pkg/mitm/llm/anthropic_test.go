@@ -3,6 +3,7 @@ package llm
 import (
 	"encoding/json"
 	"log/slog"
+	"slices"
 	"testing"
 )
 
@@ -23,6 +24,20 @@ func TestAnthropicMatch(t *testing.T) {
 			body:     []byte(`{"model": "claude-3-5-sonnet-20241022"}`),
 			want:     true,
 		},
+		{
+			name:     "Anthropic on Vertex AI",
+			hostname: "us-east5-aiplatform.googleapis.com",
+			path:     "/v1/projects/my-project/locations/us-east5/publishers/anthropic/models/claude-sonnet-4@20250514:rawPredict",
+			body:     []byte(`{"anthropic_version": "vertex-2023-10-16"}`),
+			want:     true,
+		},
+		{
+			name:     "Google model on Vertex AI",
+			hostname: "us-central1-aiplatform.googleapis.com",
+			path:     "/v1/projects/my-project/locations/us-central1/publishers/google/models/gemini-2.5-pro:generateContent",
+			body:     nil,
+			want:     false,
+		},
 		{
 			name:     "official Anthropic API with different path",
 			hostname: "api.anthropic.com",
@@ -254,7 +269,7 @@ func TestAnthropicParseFullRequest(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := provider.ParseFullRequest("", nil, []byte(tt.body))
+			got, err := provider.ParseFullRequest("", "", nil, []byte(tt.body))
 			if tt.want == nil {
 				if err == nil {
 					t.Error("expected error for invalid JSON")
@@ -338,7 +353,7 @@ data: {"type": "content_block_delta", "index": 0, "delta": {"type": "input_json_
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			body := []byte(tt.body)
-			deltas := provider.ParseSSEStreamFrom(body, tt.startPos)
+			deltas, _ := provider.ParseSSEStreamFrom(body, tt.startPos)
 
 			var text string
 			for _, d := range deltas {
@@ -467,7 +482,7 @@ data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta"
 data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 10}}
 `
 
-	deltas := provider.ParseSSEStreamFrom([]byte(body), 0)
+	deltas, _ := provider.ParseSSEStreamFrom([]byte(body), 0)
 
 	if len(deltas) != 1 {
 		t.Errorf("expected 1 merged delta, got %d", len(deltas))
@@ -558,3 +573,336 @@ func TestAnthropicMessageContentTypes(t *testing.T) {
 		})
 	}
 }
+
+func TestAnthropicParseFullRequest_AuxiliaryEndpoints(t *testing.T) {
+	provider := anthropicProvider{}
+	body := []byte(`{"model":"claude-sonnet-4","messages":[{"role":"user","content":"hello"}]}`)
+
+	for path, want := range map[string]string{
+		"/v1/messages/count_tokens": "count_tokens",
+		"/v1/messages/batches":      "batches",
+		"/v1/messages":              "",
+	} {
+		got, err := provider.ParseFullRequest("", path, nil, body)
+		if err != nil {
+			t.Fatalf("ParseFullRequest(%s) error = %v", path, err)
+		}
+		if got.Endpoint != want {
+			t.Errorf("ParseFullRequest(%s) Endpoint = %q, want %q", path, got.Endpoint, want)
+		}
+		if want != "" && len(got.Messages) != 0 {
+			t.Errorf("ParseFullRequest(%s) returned %d messages, want none", path, len(got.Messages))
+		}
+		if got.Model != "claude-sonnet-4" {
+			t.Errorf("ParseFullRequest(%s) Model = %q", path, got.Model)
+		}
+	}
+}
+
+func TestAnthropicConversationIDStrategies(t *testing.T) {
+	// Two conversations from the same user, the first one continued with a second turn
+	convA1 := `{"model":"claude","metadata":{"user_id":"user-1"},"messages":[{"role":"user","content":"write a poem"}]}`
+	convA2 := `{"model":"claude","metadata":{"user_id":"user-1"},"messages":[{"role":"user","content":"write a poem"},{"role":"assistant","content":"roses"},{"role":"user","content":"longer"}]}`
+	convB := `{"model":"claude","metadata":{"user_id":"user-1"},"messages":[{"role":"user","content":"fix my code"}]}`
+
+	tests := []struct {
+		name       string
+		matcher    *ProviderMatcher
+		headersA   map[string]string
+		headersB   map[string]string
+		wantMerged bool // whether conversation A and B share an ID
+	}{
+		{
+			name:       "metadata merges same user",
+			matcher:    &ProviderMatcher{ConversationIDStrategy: ConversationIDMetadata},
+			wantMerged: true,
+		},
+		{
+			name:       "messages-hash separates conversations",
+			matcher:    &ProviderMatcher{ConversationIDStrategy: ConversationIDMessagesHash},
+			wantMerged: false,
+		},
+		{
+			name:       "header separates conversations",
+			matcher:    &ProviderMatcher{ConversationIDStrategy: ConversationIDHeader, ConversationIDHeader: "x-session-id"},
+			headersA:   map[string]string{"X-Session-Id": "a"},
+			headersB:   map[string]string{"X-Session-Id": "b"},
+			wantMerged: false,
+		},
+		{
+			name:       "header falls back to metadata when missing",
+			matcher:    &ProviderMatcher{ConversationIDStrategy: ConversationIDHeader, ConversationIDHeader: "x-session-id"},
+			wantMerged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := anthropicProvider{customMatches: tt.matcher}
+			parse := func(body string, headers map[string]string) string {
+				info, err := provider.ParseFullRequest("", "/v1/messages", headers, []byte(body))
+				if err != nil {
+					t.Fatalf("ParseFullRequest() error = %v", err)
+				}
+				return info.ConversationID
+			}
+
+			a1 := parse(convA1, tt.headersA)
+			a2 := parse(convA2, tt.headersA)
+			b := parse(convB, tt.headersB)
+
+			if a1 != a2 {
+				t.Errorf("Expected turns of the same conversation to share an ID, got %q and %q", a1, a2)
+			}
+			if (a1 == b) != tt.wantMerged {
+				t.Errorf("Conversation IDs %q and %q, want merged = %v", a1, b, tt.wantMerged)
+			}
+		})
+	}
+}
+
+func TestAnthropicParseSSEStreamFrom_MessageStartUsage(t *testing.T) {
+	provider := anthropicProvider{logger: slog.Default()}
+
+	start := `data: {"type": "message_start", "message": {"role": "assistant", "usage": {"input_tokens": 25, "output_tokens": 1}}}
+`
+	deltas, _ := provider.ParseSSEStreamFrom([]byte(start), 0)
+	if len(deltas) != 1 || deltas[0].Usage.InputTokens != 25 || deltas[0].IsComplete {
+		t.Fatalf("Expected a single incomplete delta with 25 input tokens, got %+v", deltas)
+	}
+
+	body := start + `data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hi"}}
+data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 12}}
+`
+	deltas, _ = provider.ParseSSEStreamFrom([]byte(body), 0)
+	last := deltas[len(deltas)-1]
+	if !last.IsComplete || last.Usage != (TokenUsage{InputTokens: 25, OutputTokens: 12}) {
+		t.Errorf("Expected final usage to merge input and output tokens, got %+v", last)
+	}
+}
+
+func TestAnthropicParseSSEStreamFrom_MultiLineData(t *testing.T) {
+	provider := anthropicProvider{logger: slog.Default()}
+
+	// The content_block_delta JSON is split across two data lines of one event
+	body := `event: content_block_delta
+data: {"type": "content_block_delta", "index": 0,
+data:  "delta": {"type": "text_delta", "text": "Hello"}}
+
+event: content_block_delta
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": " world"}}
+
+`
+	deltas, _ := provider.ParseSSEStreamFrom([]byte(body), 0)
+	if len(deltas) != 1 || deltas[0].Text != "Hello world" {
+		t.Errorf("Expected merged text %q, got %+v", "Hello world", deltas)
+	}
+}
+
+func TestAnthropicParseResponse_WebSearch(t *testing.T) {
+	provider := anthropicProvider{logger: slog.Default()}
+
+	body := `{
+		"type": "message",
+		"role": "assistant",
+		"content": [
+			{"type": "server_tool_use", "id": "srvtoolu_1", "name": "web_search", "input": {"query": "golang release"}},
+			{"type": "web_search_tool_result", "tool_use_id": "srvtoolu_1", "content": [
+				{"type": "web_search_result", "title": "Go 1.25", "url": "https://go.dev/doc/go1.25", "encrypted_content": "abc"},
+				{"type": "web_search_result", "title": "Release History", "url": "https://go.dev/doc/devel/release", "encrypted_content": "def"}
+			]},
+			{"type": "text", "text": "Go 1.25 is the latest release."}
+		],
+		"stop_reason": "end_turn",
+		"usage": {"input_tokens": 10, "output_tokens": 20}
+	}`
+	resp, err := provider.ParseResponse("/v1/messages", []byte(body))
+	if err != nil {
+		t.Fatalf("ParseResponse failed: %v", err)
+	}
+	if resp.Content != "Go 1.25 is the latest release." {
+		t.Errorf("Unexpected content %q", resp.Content)
+	}
+	if len(resp.ToolCalls) != 1 {
+		t.Fatalf("Expected 1 tool call, got %+v", resp.ToolCalls)
+	}
+	call := resp.ToolCalls[0]
+	if call.ID != "srvtoolu_1" || call.Function.Name != "web_search" || call.Function.Arguments != `{"query":"golang release"}` {
+		t.Errorf("Unexpected tool call %+v", call)
+	}
+	want := "2 results: Go 1.25 (https://go.dev/doc/go1.25); Release History (https://go.dev/doc/devel/release)"
+	if call.Result != want {
+		t.Errorf("Expected result %q, got %q", want, call.Result)
+	}
+
+	// A failed search reports its error code
+	body = `{"type": "message", "content": [
+		{"type": "server_tool_use", "id": "srvtoolu_2", "name": "web_search", "input": {"query": "x"}},
+		{"type": "web_search_tool_result", "tool_use_id": "srvtoolu_2", "content": {"type": "web_search_tool_result_error", "error_code": "max_uses_exceeded"}}
+	]}`
+	resp, err = provider.ParseResponse("/v1/messages", []byte(body))
+	if err != nil {
+		t.Fatalf("ParseResponse failed: %v", err)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Result != "error: max_uses_exceeded" {
+		t.Errorf("Expected error result, got %+v", resp.ToolCalls)
+	}
+}
+
+func TestAnthropicParseSSEStreamFrom_WebSearch(t *testing.T) {
+	provider := anthropicProvider{logger: slog.Default()}
+
+	body := `data: {"type": "content_block_start", "index": 0, "content_block": {"type": "server_tool_use", "id": "srvtoolu_1", "name": "web_search", "input": {}}}
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "input_json_delta", "partial_json": "{\"query\": \"golang release\"}"}}
+data: {"type": "content_block_stop", "index": 0}
+data: {"type": "content_block_start", "index": 1, "content_block": {"type": "web_search_tool_result", "tool_use_id": "srvtoolu_1", "content": [{"type": "web_search_result", "title": "Go 1.25", "url": "https://go.dev/doc/go1.25"}]}}
+data: {"type": "content_block_stop", "index": 1}
+data: {"type": "content_block_start", "index": 2, "content_block": {"type": "text", "text": ""}}
+data: {"type": "content_block_delta", "index": 2, "delta": {"type": "text_delta", "text": "Go 1.25 is out."}}
+data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 20}}
+`
+	deltas, _ := provider.ParseSSEStreamFrom([]byte(body), 0)
+
+	var toolName, toolData, toolResult, text string
+	for _, d := range deltas {
+		if d.ToolName != "" {
+			toolName = d.ToolName
+		}
+		toolData += d.ToolData
+		if d.ToolResult != "" {
+			if d.ToolID != "srvtoolu_1" {
+				t.Errorf("Expected result for srvtoolu_1, got %q", d.ToolID)
+			}
+			toolResult = d.ToolResult
+		}
+		text += d.Text
+	}
+	if toolName != "web_search" || toolData != `{"query": "golang release"}` {
+		t.Errorf("Expected web_search call with query, got name %q data %q", toolName, toolData)
+	}
+	if toolResult != "1 result: Go 1.25 (https://go.dev/doc/go1.25)" {
+		t.Errorf("Unexpected tool result %q", toolResult)
+	}
+	if text != "Go 1.25 is out." {
+		t.Errorf("Unexpected text %q", text)
+	}
+	if !deltas[len(deltas)-1].IsComplete {
+		t.Error("Expected the stream to complete")
+	}
+}
+
+func TestForEachSSEData(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		want     []string
+		failed   []string
+		consumed int // -1 = all of input
+	}{
+		{
+			name:     "one line per event",
+			input:    "data: {\"a\":1}\n\ndata: {\"b\":2}\n\n",
+			want:     []string{`{"a":1}`, `{"b":2}`},
+			consumed: -1,
+		},
+		{
+			name:     "no blank lines between events",
+			input:    "data: {\"a\":1}\ndata: {\"b\":2}\ndata: [DONE]\n",
+			want:     []string{`{"a":1}`, `{"b":2}`, "[DONE]"},
+			consumed: -1,
+		},
+		{
+			name:     "multi-line event",
+			input:    "event: message\nid: 1\ndata: {\"a\":\ndata: 1}\n\n",
+			want:     []string{"{\"a\":\n1}"},
+			consumed: -1,
+		},
+		{
+			name:     "multi-line event cut before its blank line",
+			input:    "data: {\"a\":1}\n\ndata: {\"b\":\ndata: 2}\n",
+			want:     []string{`{"a":1}`},
+			consumed: len("data: {\"a\":1}\n\n"),
+		},
+		{
+			name:     "CRLF and comments",
+			input:    ": keep-alive\r\ndata:{\"a\":1}\r\n\r\n",
+			want:     []string{`{"a":1}`},
+			consumed: -1,
+		},
+		{
+			name:     "non-JSON data ends at blank line",
+			input:    "data: hello\ndata: world\n\n",
+			failed:   []string{"hello\nworld"},
+			consumed: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			calls := 0
+			consumed, failed := forEachSSEData(tt.input, func(data string) error {
+				calls++
+				if data != "[DONE]" {
+					var v any
+					if err := json.Unmarshal([]byte(data), &v); err != nil {
+						return err
+					}
+				}
+				got = append(got, data)
+				return nil
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+			var gotFailed []string
+			for _, f := range failed {
+				gotFailed = append(gotFailed, f.data)
+			}
+			if !slices.Equal(gotFailed, tt.failed) {
+				t.Errorf("Expected failed %q, got %q", tt.failed, gotFailed)
+			}
+			want := tt.consumed
+			if want < 0 {
+				want = len(tt.input)
+			}
+			if consumed != want {
+				t.Errorf("Expected %d bytes consumed, got %d", want, consumed)
+			}
+			if calls > len(got)+len(gotFailed)+1 {
+				t.Errorf("Expected each payload handled about once, got %d calls", calls)
+			}
+		})
+	}
+}
+
+func TestToolCallCheckArguments(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         string
+		wantComplete bool
+		wantArgs     string
+	}{
+		{"complete", `{"path": "/tmp/a", "lines": [1, 2]}`, true, `{"path": "/tmp/a", "lines": [1, 2]}`},
+		{"no arguments", ``, true, ``},
+		{"cut inside string", `{"path": "/tmp/a`, false, `{"path": "/tmp/a"}`},
+		{"cut after escape", `{"cmd": "echo \`, false, `{"cmd": "echo "}`},
+		{"cut after comma", `{"lines": [1, 2,`, false, `{"lines": [1, 2]}`},
+		{"cut after colon", `{"path": "a", "mode":`, false, `{"path": "a", "mode":null}`},
+		{"cut after key", `{"path": "a", "mode"`, false, `{"path": "a", "mode":null}`},
+		{"cut inside literal", `{"force": tr`, false, `{"force": tr`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			toolCall := ToolCall{Function: FunctionCall{Name: "edit", Arguments: tt.args}}
+			toolCall.CheckArguments()
+			if toolCall.ArgumentsComplete != tt.wantComplete {
+				t.Errorf("ArgumentsComplete = %v, want %v", toolCall.ArgumentsComplete, tt.wantComplete)
+			}
+			if toolCall.Function.Arguments != tt.wantArgs {
+				t.Errorf("Arguments = %q, want %q", toolCall.Function.Arguments, tt.wantArgs)
+			}
+		})
+	}
+}
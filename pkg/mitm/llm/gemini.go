@@ -15,9 +15,9 @@ type geminiProvider struct {
 }
 
 func (g geminiProvider) Match(hostname, path string, body []byte) bool {
-	// Match Google Generative Language API
+	// Match Google Generative Language API, its OpenAI-compatible endpoints are left to the OpenAI provider
 	if strings.Contains(hostname, "generativelanguage.googleapis.com") {
-		return true
+		return !strings.Contains(path, "/openai/")
 	}
 
 	// Match Google Cloud Code Prediction API
@@ -27,6 +27,11 @@ func (g geminiProvider) Match(hostname, path string, body []byte) bool {
 		}
 	}
 
+	// Match Google models on Vertex AI, other publishers (e.g. Anthropic) have their own formats
+	if publisher, _, ok := parseVertexPath(hostname, path); ok && publisher == "google" {
+		return true
+	}
+
 	// Check custom matches
 	if g.customMatches != nil {
 		for _, match := range g.customMatches.CustomGeminiMatches {
@@ -92,7 +97,7 @@ func (g geminiProvider) parseGeminiCandidates(candidates []GeminiCandidate, usag
 	}, nil
 }
 
-func (g geminiProvider) ParseFullRequest(hostname string, headers map[string]string, body []byte) (*RequestInfo, error) {
+func (g geminiProvider) ParseFullRequest(hostname, path string, headers map[string]string, body []byte) (*RequestInfo, error) {
 	// First try standard Gemini format
 	var req GeminiRequest
 	if err := json.Unmarshal(body, &req); err == nil && len(req.Contents) > 0 {
@@ -105,9 +110,13 @@ func (g geminiProvider) ParseFullRequest(hostname string, headers map[string]str
 				conversationID = fmt.Sprintf("opencode-%s", sessionID)
 			}
 		}
+		model := req.Model
+		if _, vertexModel, ok := parseVertexPath(hostname, path); ok && model == "" {
+			model = vertexModel
+		}
 		return &RequestInfo{
 			ConversationID: conversationID,
-			Model:          req.Model,
+			Model:          model,
 			Messages:       convertGeminiMessages(req.Contents),
 			SystemPrompts:  g.extractSystemPromptsFromReq(&req),
 			Tools:          g.extractToolsFromReq(&req),
@@ -204,13 +213,12 @@ func (g geminiProvider) extractToolsFromReq(req *GeminiRequest) []ToolDef {
 	return tools
 }
 
-func (g geminiProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDelta {
+func (g geminiProvider) ParseSSEStreamFrom(body []byte, startPos int) ([]TokenDelta, int) {
 	if startPos >= len(body) {
-		return nil
+		return nil, startPos
 	}
 
 	remaining := string(body[startPos:])
-	lines := strings.Split(remaining, "\n")
 
 	var deltas []TokenDelta
 	var cumulativeUsage TokenUsage
@@ -219,6 +227,9 @@ func (g geminiProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDel
 		if newDelta.Usage == (TokenUsage{}) {
 			newDelta.Usage = cumulativeUsage
 		}
+		if newDelta.Text != "" || newDelta.Thinking != "" || newDelta.ToolData != "" {
+			newDelta.ContentEvents = 1
+		}
 
 		if len(deltas) == 0 {
 			deltas = append(deltas, newDelta)
@@ -230,6 +241,7 @@ func (g geminiProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDel
 		// Merge text content
 		if newDelta.Text != "" {
 			last.Text += newDelta.Text
+			last.ContentEvents += newDelta.ContentEvents
 			return
 		}
 
@@ -251,6 +263,7 @@ func (g geminiProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDel
 				(newDelta.ToolName == "" || newDelta.ToolName == last.ToolName)
 			if sameTool && !strings.HasSuffix(last.ToolData, newDelta.ToolData) {
 				last.ToolData += newDelta.ToolData
+				last.ContentEvents += newDelta.ContentEvents
 				return
 			}
 		}
@@ -316,32 +329,30 @@ func (g geminiProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDel
 		}
 	}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-
-		data := strings.TrimPrefix(line, "data: ")
-		if data == "" {
-			continue
+	// Payloads in neither format carry no deltas and are skipped
+	consumed, _ := forEachSSEData(remaining, func(data string) error {
+		if data == "[DONE]" {
+			// OpenAI style terminator from compatible gateways, merges into a preceding finishReason
+			tryMergeDelta(TokenDelta{IsComplete: true})
+			return nil
 		}
 
 		// First try standard Gemini format
 		var chunk GeminiStreamChunk
-		if err := json.Unmarshal([]byte(data), &chunk); err == nil && len(chunk.Candidates) > 0 {
+		err := json.Unmarshal([]byte(data), &chunk)
+		if err == nil && len(chunk.Candidates) > 0 {
 			processCandidates(chunk.Candidates, chunk.UsageMetadata)
-			continue
+			return nil
 		}
 
 		// Try CloudCode format
 		var cloudChunk CloudCodeStreamChunk
 		if err := json.Unmarshal([]byte(data), &cloudChunk); err == nil && len(cloudChunk.Response.Candidates) > 0 {
 			processCandidates(cloudChunk.Response.Candidates, cloudChunk.Response.UsageMetadata)
-			continue
+			return nil
 		}
+		return err
+	})
 
-	}
-
-	return deltas
+	return deltas, startPos + consumed
 }
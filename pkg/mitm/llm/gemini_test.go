@@ -2,6 +2,7 @@ package llm
 
 import (
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"testing"
 )
@@ -23,6 +24,20 @@ func TestGeminiMatch(t *testing.T) {
 			body:     nil,
 			want:     true,
 		},
+		{
+			name:     "Vertex AI Google model",
+			hostname: "us-central1-aiplatform.googleapis.com",
+			path:     "/v1/projects/my-project/locations/us-central1/publishers/google/models/gemini-2.5-pro:streamGenerateContent",
+			body:     nil,
+			want:     true,
+		},
+		{
+			name:     "Vertex AI Anthropic model",
+			hostname: "us-east5-aiplatform.googleapis.com",
+			path:     "/v1/projects/my-project/locations/us-east5/publishers/anthropic/models/claude-sonnet-4@20250514:streamRawPredict",
+			body:     nil,
+			want:     false,
+		},
 		{
 			name:     "Non-matching hostname",
 			hostname: "api.openai.com",
@@ -116,7 +131,7 @@ func TestGeminiParseFullRequest(t *testing.T) {
 		t.Fatalf("failed to marshal request: %v", err)
 	}
 
-	info, err := provider.ParseFullRequest("", nil, body)
+	info, err := provider.ParseFullRequest("", "", nil, body)
 	if err != nil {
 		t.Fatalf("ParseFullRequest() error = %v", err)
 	}
@@ -149,7 +164,7 @@ func TestGeminiParseSSEStream(t *testing.T) {
 	sseData := `data: {"candidates": [{"content": {"parts": [{"text": "Hello"}],"role": "model"},"finishReason": "STOP","index": 0}],"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":5,"totalTokenCount":15}}
 `
 
-	deltas := provider.ParseSSEStreamFrom([]byte(sseData), 0)
+	deltas, _ := provider.ParseSSEStreamFrom([]byte(sseData), 0)
 	if len(deltas) == 0 {
 		t.Fatal("expected deltas, got none")
 	}
@@ -173,3 +188,53 @@ func TestGeminiParseSSEStream(t *testing.T) {
 func testLogger() *slog.Logger {
 	return slog.Default()
 }
+
+func TestFindProvider_Vertex(t *testing.T) {
+	tests := []struct {
+		name      string
+		hostname  string
+		path      string
+		body      string
+		wantType  Provider
+		wantModel string
+	}{
+		{
+			name:      "Google model",
+			hostname:  "us-central1-aiplatform.googleapis.com",
+			path:      "/v1/projects/my-project/locations/us-central1/publishers/google/models/gemini-2.5-pro:streamGenerateContent",
+			body:      `{"contents":[{"role":"user","parts":[{"text":"Hello"}]}]}`,
+			wantType:  geminiProvider{},
+			wantModel: "gemini-2.5-pro",
+		},
+		{
+			name:      "Anthropic model",
+			hostname:  "us-east5-aiplatform.googleapis.com",
+			path:      "/v1/projects/my-project/locations/us-east5/publishers/anthropic/models/claude-sonnet-4@20250514:streamRawPredict",
+			body:      `{"anthropic_version":"vertex-2023-10-16","max_tokens":1024,"messages":[{"role":"user","content":"Hello"}],"stream":true}`,
+			wantType:  anthropicProvider{},
+			wantModel: "claude-sonnet-4@20250514",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := FindProvider(tt.hostname, tt.path, []byte(tt.body), testLogger())
+			if provider == nil {
+				t.Fatal("Expected a provider for the Vertex AI path")
+			}
+			if fmt.Sprintf("%T", provider) != fmt.Sprintf("%T", tt.wantType) {
+				t.Fatalf("Provider = %T, want %T", provider, tt.wantType)
+			}
+			info, err := provider.ParseFullRequest(tt.hostname, tt.path, nil, []byte(tt.body))
+			if err != nil {
+				t.Fatalf("ParseFullRequest() error = %v", err)
+			}
+			if info.Model != tt.wantModel {
+				t.Errorf("Model = %v, want %v", info.Model, tt.wantModel)
+			}
+			if len(info.Messages) != 1 {
+				t.Errorf("Messages length = %v, want 1", len(info.Messages))
+			}
+		})
+	}
+}
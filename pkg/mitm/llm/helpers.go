@@ -4,6 +4,8 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"strings"
 )
 
 func generateOpenAIConversationHash(messages []OpenAIMessage) string {
@@ -19,6 +21,12 @@ func generateOpenAIConversationHash(messages []OpenAIMessage) string {
 	return hex.EncodeToString(hash[:8])
 }
 
+// shortHash returns the first 6 hex characters of the SHA-256 of s
+func shortHash(s string) string {
+	hash := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(hash[:])[:6]
+}
+
 // extractToolResultContent handles both string and array content for tool results.
 // Anthropic's tool_result content can be a plain string or an array of content blocks.
 func extractToolResultContent(content any) string {
@@ -89,6 +97,7 @@ func convertAnthropicMessages(messages []AnthropicMessage) []LLMMessage {
 								Name:      name,
 								Arguments: string(inputJSON),
 							},
+							ArgumentsComplete: true,
 						})
 					case "tool_result":
 						toolUseID, _ := itemMap["tool_use_id"].(string)
@@ -144,6 +153,7 @@ func convertGeminiMessages(contents []GeminiContent) []LLMMessage {
 						Arguments: argsStr,
 					},
 				})
+				toolCalls[len(toolCalls)-1].CheckArguments()
 			}
 
 			// Handle function response (tool result from user)
@@ -200,7 +210,7 @@ func convertOpenAIMessages(messages []OpenAIMessage) []LLMMessage {
 						// Handle image content: extract URL or base64 data
 						if imageURL, ok := partMap["image_url"].(map[string]any); ok {
 							if url, ok := imageURL["url"].(string); ok {
-								contentParts = append(contentParts, "[Image: "+url+"]")
+								contentParts = append(contentParts, "[Image: "+MaskDataURL(url)+"]")
 							}
 						}
 					case "tool_use":
@@ -216,6 +226,7 @@ func convertOpenAIMessages(messages []OpenAIMessage) []LLMMessage {
 								Name:      name,
 								Arguments: string(inputJSON),
 							},
+							ArgumentsComplete: true,
 						})
 					case "tool_result":
 						toolUseID, _ := partMap["tool_use_id"].(string)
@@ -231,14 +242,16 @@ func convertOpenAIMessages(messages []OpenAIMessage) []LLMMessage {
 
 		// Handle ToolCalls at message level (from assistant messages)
 		for _, tc := range m.ToolCalls {
-			toolCalls = append(toolCalls, ToolCall{
+			toolCall := ToolCall{
 				ID:   tc.ID,
 				Type: "function",
 				Function: FunctionCall{
 					Name:      tc.Function.Name,
 					Arguments: tc.Function.Arguments,
 				},
-			})
+			}
+			toolCall.CheckArguments()
+			toolCalls = append(toolCalls, toolCall)
 		}
 
 		result = append(result, LLMMessage{
@@ -251,3 +264,148 @@ func convertOpenAIMessages(messages []OpenAIMessage) []LLMMessage {
 	}
 	return result
 }
+
+// CheckArguments sets ArgumentsComplete from whether the arguments are valid JSON. Truncated
+// arguments are replaced with a best-effort closed version when one can be produced.
+func (t *ToolCall) CheckArguments() {
+	args := t.Function.Arguments
+	// No-argument tools may stream nothing at all
+	if strings.TrimSpace(args) == "" || json.Valid([]byte(args)) {
+		t.ArgumentsComplete = true
+		return
+	}
+	t.ArgumentsComplete = false
+	if repaired, ok := repairTruncatedJSON(args); ok {
+		t.Function.Arguments = repaired
+	}
+}
+
+// repairTruncatedJSON closes the open string, arrays and objects of a JSON prefix, dropping a
+// dangling comma and giving a dangling key a null value. ok is false if the result is still invalid,
+// e.g. the input was cut inside a literal or was never JSON.
+func repairTruncatedJSON(s string) (string, bool) {
+	var closers []byte
+	inString, escaped := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			closers = append(closers, '}')
+		case '[':
+			closers = append(closers, ']')
+		case '}', ']':
+			if len(closers) == 0 || closers[len(closers)-1] != c {
+				return "", false
+			}
+			closers = closers[:len(closers)-1]
+		}
+	}
+
+	repaired := s
+	if inString {
+		// A trailing lone backslash would escape the closing quote
+		if escaped {
+			repaired = repaired[:len(repaired)-1]
+		}
+		repaired += `"`
+	}
+	repaired = strings.TrimRight(repaired, " \t\r\n")
+	repaired = strings.TrimSuffix(repaired, ",")
+	if strings.HasSuffix(repaired, ":") {
+		repaired += "null"
+	}
+	suffix := make([]byte, 0, len(closers))
+	for i := len(closers) - 1; i >= 0; i-- {
+		suffix = append(suffix, closers[i])
+	}
+	if candidate := repaired + string(suffix); json.Valid([]byte(candidate)) {
+		return candidate, true
+	}
+	// Cut right after an object key, e.g. {"path": "a", "mode"
+	if candidate := repaired + ":null" + string(suffix); json.Valid([]byte(candidate)) {
+		return candidate, true
+	}
+	return "", false
+}
+
+// sseDataError is an SSE event payload the handler of forEachSSEData failed to parse
+type sseDataError struct {
+	data string
+	err  error
+}
+
+// forEachSSEData calls handle with the data of each complete SSE event in s and returns the
+// number of bytes of s those events span, an event still missing its end is left for the next
+// call. A "data:" line is handed to handle on its own first so streams without blank lines
+// between events still split per line; when handle fails with a JSON syntax error the line
+// starts a multi-line event, whose lines are joined once at the blank line ending it. Payloads
+// handle rejected are returned in failed.
+func forEachSSEData(s string, handle func(data string) error) (consumed int, failed []sseDataError) {
+	var pending []string
+	var pendingErr error // Error of the only pending line, saves handling it twice
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		data, err := strings.Join(pending, "\n"), pendingErr
+		if len(pending) > 1 {
+			err = handle(data)
+		}
+		if err != nil {
+			failed = append(failed, sseDataError{data: data, err: err})
+		}
+		pending, pendingErr = nil, nil
+	}
+
+	for pos := 0; pos < len(s); {
+		next := len(s)
+		if i := strings.IndexByte(s[pos:], '\n'); i >= 0 {
+			next = pos + i + 1
+		}
+		line := strings.TrimSpace(s[pos:next])
+		pos = next
+
+		if line == "" {
+			flush()
+			consumed = pos
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			// event:, id:, retry: and comment lines carry no payload
+			if len(pending) == 0 {
+				consumed = pos
+			}
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if len(pending) > 0 {
+			pending = append(pending, data)
+			continue
+		}
+		if data != "" {
+			var syntaxErr *json.SyntaxError
+			if err := handle(data); errors.As(err, &syntaxErr) {
+				pending, pendingErr = []string{data}, err
+				continue
+			} else if err != nil {
+				failed = append(failed, sseDataError{data: data, err: err})
+			}
+		}
+		consumed = pos
+	}
+	return consumed, failed
+}
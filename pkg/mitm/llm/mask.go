@@ -0,0 +1,278 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// maskImageMinLen is the shortest base64 payload worth replacing with a placeholder
+const maskImageMinLen = 256
+
+// MaskImageData replaces long base64 image payloads in an LLM request body with a
+// "<image: media_type, N bytes>" placeholder, leaving everything else untouched. Handles Anthropic
+// base64 sources, OpenAI data URLs and Gemini inline data. The body is scanned token by token
+// without decoding it, so a body cut short by the capture limit still gets its complete payloads
+// masked, and any other long base64 string in it, including the cut one, becomes "<base64: N bytes>".
+func MaskImageData(body []byte) []byte {
+	if !bytes.Contains(body, []byte("base64")) && !bytes.Contains(body, []byte(`"data"`)) {
+		return body
+	}
+	m := imageMasker{body: body}
+	m.scan()
+	if len(m.replacements) == 0 {
+		return body
+	}
+
+	slices.SortFunc(m.replacements, func(a, b maskReplacement) int { return a.start - b.start })
+	var buf bytes.Buffer
+	last := 0
+	for _, r := range m.replacements {
+		buf.Write(body[last:r.start])
+		buf.Write(r.text)
+		last = r.end
+	}
+	buf.Write(body[last:])
+	return buf.Bytes()
+}
+
+// maskReplacement replaces body[start:end] with text
+type maskReplacement struct {
+	start, end int
+	text       []byte
+}
+
+// base64Run is a string literal long enough to be masked, positions include the quotes
+type base64Run struct {
+	start, end int
+	size       int // decoded size
+}
+
+// maskFrame is an open JSON object or array
+type maskFrame struct {
+	object    bool
+	key       string     // key of the value being read, objects only
+	mediaType string     // media_type, mime_type or mimeType of the object
+	data      *base64Run // long base64 "data" value of the object
+}
+
+// imageMasker scans a possibly truncated JSON body for image payloads
+type imageMasker struct {
+	body         []byte
+	stack        []maskFrame
+	runs         []base64Run // long base64 strings not replaced yet, for truncated bodies
+	replacements []maskReplacement
+}
+
+func (m *imageMasker) scan() {
+	body := m.body
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '{', '[':
+			m.stack = append(m.stack, maskFrame{object: body[i] == '{'})
+		case '}', ']':
+			if len(m.stack) == 0 {
+				return
+			}
+			m.closeFrame(m.stack[len(m.stack)-1])
+			m.stack = m.stack[:len(m.stack)-1]
+		case ',':
+			if len(m.stack) > 0 {
+				m.stack[len(m.stack)-1].key = ""
+			}
+		case '"':
+			end, ok := stringEnd(body, i)
+			if !ok {
+				// Cut inside a string by the capture limit
+				m.truncated(i)
+				return
+			}
+			m.handleString(i, end)
+			i = end - 1
+		}
+	}
+	if len(m.stack) > 0 {
+		m.truncated(len(body))
+	}
+}
+
+// handleString handles the string literal body[start:end]
+func (m *imageMasker) handleString(start, end int) {
+	var frame *maskFrame
+	if len(m.stack) > 0 {
+		frame = &m.stack[len(m.stack)-1]
+	}
+	raw := m.body[start+1 : end-1]
+	next := bytes.TrimLeft(m.body[end:], " \t\r\n")
+	if frame != nil && frame.object && len(next) > 0 && next[0] == ':' {
+		if len(raw) < maskImageMinLen {
+			frame.key = unquote(m.body[start:end])
+		}
+		return
+	}
+
+	if mediaType, size, ok := dataURL(raw); ok {
+		m.replace(start, end, imagePlaceholderSize(mediaType, size))
+		return
+	}
+	if frame != nil && frame.object {
+		switch frame.key {
+		case "media_type", "mime_type", "mimeType":
+			if len(raw) < maskImageMinLen && frame.mediaType == "" {
+				frame.mediaType = unquote(m.body[start:end])
+			}
+		}
+	}
+	if len(raw) < maskImageMinLen || !isBase64(raw) {
+		return
+	}
+	run := base64Run{start: start, end: end, size: base64Size(raw)}
+	if frame != nil && frame.object && frame.key == "data" {
+		frame.data = &run
+		return
+	}
+	m.runs = append(m.runs, run)
+}
+
+// closeFrame masks the data of a closed object once its media type is known
+func (m *imageMasker) closeFrame(frame maskFrame) {
+	if frame.data == nil {
+		return
+	}
+	if strings.HasPrefix(frame.mediaType, "image/") {
+		m.replace(frame.data.start, frame.data.end, imagePlaceholderSize(frame.mediaType, frame.data.size))
+		return
+	}
+	m.runs = append(m.runs, *frame.data)
+}
+
+// truncated masks what's left of a body cut at pos: data of still open objects, every long
+// base64 string seen and the string cut in the middle
+func (m *imageMasker) truncated(pos int) {
+	for _, frame := range m.stack {
+		if frame.data != nil && strings.HasPrefix(frame.mediaType, "image/") {
+			m.replace(frame.data.start, frame.data.end, imagePlaceholderSize(frame.mediaType, frame.data.size))
+		} else if frame.data != nil {
+			m.runs = append(m.runs, *frame.data)
+		}
+	}
+	for _, run := range m.runs {
+		m.replace(run.start, run.end, fmt.Sprintf("<base64: %d bytes>", run.size))
+	}
+	if pos >= len(m.body) {
+		return
+	}
+	// The cut may split an escaped slash
+	raw := bytes.TrimSuffix(m.body[pos+1:], []byte(`\`))
+	if mediaType, size, ok := dataURL(raw); ok {
+		m.replace(pos, len(m.body), imagePlaceholderSize(mediaType, size))
+		return
+	}
+	if len(raw) < maskImageMinLen || !isBase64(raw) {
+		return
+	}
+	placeholder := fmt.Sprintf("<base64: %d bytes>", base64Size(raw))
+	if len(m.stack) > 0 {
+		if frame := m.stack[len(m.stack)-1]; frame.object && frame.key == "data" && strings.HasPrefix(frame.mediaType, "image/") {
+			placeholder = imagePlaceholderSize(frame.mediaType, base64Size(raw))
+		}
+	}
+	m.replace(pos, len(m.body), placeholder)
+}
+
+func (m *imageMasker) replace(start, end int, placeholder string) {
+	m.replacements = append(m.replacements, maskReplacement{start: start, end: end, text: jsonString(placeholder)})
+}
+
+// stringEnd returns the position after the closing quote of the string literal starting at start
+func stringEnd(body []byte, start int) (int, bool) {
+	for i := start + 1; i < len(body); i++ {
+		switch body[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// unquote decodes a short JSON string literal, empty if it's malformed
+func unquote(literal []byte) string {
+	var s string
+	json.Unmarshal(literal, &s)
+	return s
+}
+
+// isBase64 reports whether a raw string literal holds only base64, allowing escaped slashes
+func isBase64(raw []byte) bool {
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '+', c == '/', c == '=':
+		case c == '\\' && i+1 < len(raw) && raw[i+1] == '/':
+			i++
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// base64Size is the decoded size of a raw base64 string literal
+func base64Size(raw []byte) int {
+	n := len(raw) - bytes.Count(raw, []byte(`\/`))
+	pad := len(raw) - len(bytes.TrimRight(raw, "="))
+	return base64.StdEncoding.DecodedLen(n) - pad
+}
+
+// dataURL recognizes a raw string literal holding a long base64 image data URL
+func dataURL(raw []byte) (mediaType string, size int, ok bool) {
+	rest, ok := bytes.CutPrefix(raw, []byte("data:"))
+	if !ok {
+		return "", 0, false
+	}
+	media, data, ok := bytes.Cut(rest, []byte(";base64,"))
+	if !ok || len(media) > 64 || len(data) < maskImageMinLen || !isBase64(data) {
+		return "", 0, false
+	}
+	mediaType = strings.ReplaceAll(string(media), `\/`, "/")
+	if !strings.HasPrefix(mediaType, "image/") {
+		return "", 0, false
+	}
+	return mediaType, base64Size(data), true
+}
+
+// MaskDataURL replaces a long base64 image data URL with a placeholder, other strings are returned as is
+func MaskDataURL(s string) string {
+	rest, ok := strings.CutPrefix(s, "data:")
+	if !ok {
+		return s
+	}
+	mediaType, data, ok := strings.Cut(rest, ";base64,")
+	if !ok || !strings.HasPrefix(mediaType, "image/") || len(data) < maskImageMinLen {
+		return s
+	}
+	return imagePlaceholder(mediaType, data)
+}
+
+// imagePlaceholder describes a base64 payload by media type and decoded size
+func imagePlaceholder(mediaType, data string) string {
+	return imagePlaceholderSize(mediaType, base64Size([]byte(data)))
+}
+
+func imagePlaceholderSize(mediaType string, size int) string {
+	return fmt.Sprintf("<image: %s, %d bytes>", mediaType, size)
+}
+
+// jsonString encodes s as a JSON string the way encoding/json does, without HTML escaping
+func jsonString(s string) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
@@ -0,0 +1,82 @@
+package llm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMaskImageData(t *testing.T) {
+	image := base64.StdEncoding.EncodeToString(make([]byte, 3000))
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"anthropic", `{"model":"claude-sonnet-4","messages":[{"role":"user","content":[{"type":"image","source":{"type":"base64","media_type":"image/png","data":"` + image + `"}},{"type":"text","text":"What is in this picture?"}]}]}`},
+		{"openai", `{"model":"gpt-4o","messages":[{"role":"user","content":[{"type":"text","text":"What is in this picture?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,` + image + `"}}]}]}`},
+		{"gemini", `{"contents":[{"role":"user","parts":[{"text":"What is in this picture?"},{"inline_data":{"mime_type":"image/png","data":"` + image + `"}}]}]}`},
+		{"escaped slashes", `{"messages":[{"role":"user","content":[{"type":"text","text":"What is in this picture?"},{"type":"image_url","image_url":{"url":"data:image\/png;base64,` + image + `"}}]}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			masked := string(MaskImageData([]byte(tt.body)))
+			if strings.Contains(masked, image) {
+				t.Fatal("Expected image data to be elided")
+			}
+			if !strings.Contains(masked, "<image: image/png, 3000 bytes>") {
+				t.Errorf("Expected image placeholder, got %s", masked)
+			}
+			if !strings.Contains(masked, "What is in this picture?") {
+				t.Errorf("Expected prompt text to survive, got %s", masked)
+			}
+			if !json.Valid([]byte(masked)) {
+				t.Errorf("Expected masked body to stay valid JSON, got %s", masked)
+			}
+		})
+	}
+}
+
+func TestMaskImageData_Unchanged(t *testing.T) {
+	for _, body := range []string{
+		`{"messages":[{"role":"user","content":[{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0KGgo="}}]}]}`,
+		`{"messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"https://example.com/cat.png"}}]}]}`,
+		`not json base64`,
+	} {
+		if got := string(MaskImageData([]byte(body))); got != body {
+			t.Errorf("Expected %s to be left as is, got %s", body, got)
+		}
+	}
+}
+
+func TestMaskImageData_Truncated(t *testing.T) {
+	image := base64.StdEncoding.EncodeToString(make([]byte, 3000))
+	anthropic := `{"model":"claude-sonnet-4","messages":[{"role":"user","content":[{"type":"text","text":"What is in this picture?"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"` + image + `"}}`
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"open object after image", anthropic + `,{"type":"text","text":"more`, "<image: image/png, 3000 bytes>"},
+		{"cut inside image", anthropic[:len(anthropic)-1003], "<image: image/png, 2250 bytes>"},
+		{"cut inside data url", `{"messages":[{"role":"user","content":[{"type":"text","text":"What is in this picture?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,` + image[:2000], "<image: image/png, 1500 bytes>"},
+		{"media type after cut", `{"messages":[{"role":"user","content":[{"type":"text","text":"What is in this picture?"},{"inline_data":{"data":"` + image + `","mime`, "<base64: 3000 bytes>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			masked := string(MaskImageData([]byte(tt.body)))
+			if strings.Contains(masked, image[:maskImageMinLen]) {
+				t.Fatalf("Expected image data to be elided, got %s", masked)
+			}
+			if !strings.Contains(masked, tt.want) {
+				t.Errorf("Expected %q in %s", tt.want, masked)
+			}
+			if !strings.Contains(masked, "What is in this picture?") {
+				t.Errorf("Expected prompt text to survive, got %s", masked)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"sort"
 	"strings"
 )
 
@@ -97,6 +98,15 @@ type OpenAIResponse struct {
 	Usage             OpenAIUsage    `json:"usage"`
 	ReasoningContent  string         `json:"reasoning_content,omitempty"` // o1 model's reasoning
 	SystemFingerprint string         `json:"system_fingerprint,omitempty"`
+	Error             *OpenAIError   `json:"error,omitempty"`
+}
+
+// OpenAIError is the {"error": {...}} body of a failed request
+type OpenAIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Param   string `json:"param,omitempty"`
+	Code    any    `json:"code,omitempty"` // string, or a number on some compatible APIs
 }
 
 type OpenAIChoice struct {
@@ -152,6 +162,11 @@ func (o openaiProvider) Match(hostname, path string, body []byte) bool {
 		return true
 	}
 
+	// Azure OpenAI deployments behind custom domains or API gateways
+	if _, operation, ok := parseAzurePath(path); ok && operation == "/completions" {
+		return true
+	}
+
 	// Check custom matches
 	if o.customMatches != nil {
 		for _, match := range o.customMatches.CustomOpenAIMatches {
@@ -170,26 +185,66 @@ func (o openaiProvider) ParseResponse(path string, body []byte) (*LLMResponse, e
 		return nil, fmt.Errorf("failed to parse OpenAI response: %w", err)
 	}
 
+	// Check for API error response
+	if resp.Error != nil {
+		apiError := &APIError{Type: resp.Error.Type, Message: resp.Error.Message}
+		if resp.Error.Code != nil {
+			apiError.Code = fmt.Sprint(resp.Error.Code)
+		}
+		if apiError.Type == "" {
+			apiError.Type = apiError.Code
+		}
+		return &LLMResponse{
+			Content:    apiError.Message,
+			StopReason: "error",
+			Error:      apiError,
+		}, nil
+	}
+
 	if len(resp.Choices) == 0 {
 		return nil, fmt.Errorf("no choices in response")
 	}
 
-	// Extract content with type assertion
-	content := ""
-	reasoningContent := ""
+	sort.SliceStable(resp.Choices, func(i, j int) bool {
+		return resp.Choices[i].Index < resp.Choices[j].Index
+	})
 
-	// Check reasoning_content at response level (for o1 model)
-	if resp.ReasoningContent != "" {
-		reasoningContent = resp.ReasoningContent
+	choices := make([]ResponseChoice, len(resp.Choices))
+	for i, choice := range resp.Choices {
+		// Response level reasoning_content (for o1 model) belongs to the first choice
+		reasoningContent := ""
+		if i == 0 {
+			reasoningContent = resp.ReasoningContent
+		}
+		choices[i] = parseOpenAIChoice(choice, reasoningContent)
+	}
+
+	llmResp := &LLMResponse{
+		Content:    choices[0].Content,
+		StopReason: choices[0].StopReason,
+		Usage: TokenUsage{
+			InputTokens:  resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.CompletionTokens,
+		},
+		ToolCalls: choices[0].ToolCalls,
+	}
+	if len(choices) > 1 {
+		llmResp.Choices = choices
 	}
+	return llmResp, nil
+}
+
+// parseOpenAIChoice extracts content, reasoning and tool calls from a single choice
+func parseOpenAIChoice(choice OpenAIChoice, reasoningContent string) ResponseChoice {
+	content := ""
 
 	// Check reasoning_content at choice level (for o1 model)
-	if resp.Choices[0].ReasoningContent != "" {
-		reasoningContent = resp.Choices[0].ReasoningContent
+	if choice.ReasoningContent != "" {
+		reasoningContent = choice.ReasoningContent
 	}
 
 	// Extract content from message
-	switch c := resp.Choices[0].Message.Content.(type) {
+	switch c := choice.Message.Content.(type) {
 	case string:
 		content = c
 	case []any:
@@ -203,8 +258,8 @@ func (o openaiProvider) ParseResponse(path string, body []byte) (*LLMResponse, e
 	}
 
 	// Check message level reasoning_content (for o1 model)
-	if resp.Choices[0].Message.ReasoningContent != "" {
-		reasoningContent = resp.Choices[0].Message.ReasoningContent
+	if choice.Message.ReasoningContent != "" {
+		reasoningContent = choice.Message.ReasoningContent
 	}
 
 	// Include reasoning content in the content if present
@@ -212,15 +267,15 @@ func (o openaiProvider) ParseResponse(path string, body []byte) (*LLMResponse, e
 		content = "[Reasoning]\n" + reasoningContent + "\n[/Reasoning]\n" + content
 	}
 
-	return &LLMResponse{
+	for i := range choice.Message.ToolCalls {
+		choice.Message.ToolCalls[i].CheckArguments()
+	}
+	return ResponseChoice{
+		Index:      choice.Index,
 		Content:    content,
-		StopReason: resp.Choices[0].FinishReason,
-		Usage: TokenUsage{
-			InputTokens:  resp.Usage.PromptTokens,
-			OutputTokens: resp.Usage.CompletionTokens,
-		},
-		ToolCalls: resp.Choices[0].Message.ToolCalls,
-	}, nil
+		StopReason: choice.FinishReason,
+		ToolCalls:  choice.Message.ToolCalls,
+	}
 }
 
 // extractSystemPromptsFromReq extracts system prompts from a parsed OpenAIRequest
@@ -278,11 +333,15 @@ func (o openaiProvider) extractConversationID(hostname string, headers map[strin
 }
 
 // ParseFullRequest parses the request body once and returns all extracted info
-func (o openaiProvider) ParseFullRequest(hostname string, headers map[string]string, body []byte) (*RequestInfo, error) {
+func (o openaiProvider) ParseFullRequest(hostname, path string, headers map[string]string, body []byte) (*RequestInfo, error) {
 	var req OpenAIRequest
 	if err := json.Unmarshal(body, &req); err != nil {
 		return nil, fmt.Errorf("failed to parse OpenAI request: %w", err)
 	}
+	// Azure routes on the deployment in the path, the body usually has no model
+	if deployment, _, ok := parseAzurePath(path); ok {
+		req.Model = deployment
+	}
 
 	return &RequestInfo{
 		ConversationID: o.extractConversationID(hostname, headers, &req),
@@ -312,19 +371,18 @@ func (o openaiProvider) extractToolsFromReq(req *OpenAIRequest) []ToolDef {
 }
 
 // ParseSSEStreamFrom parses SSE stream from a specific position for incremental processing
-func (o openaiProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDelta {
+func (o openaiProvider) ParseSSEStreamFrom(body []byte, startPos int) ([]TokenDelta, int) {
 	if startPos >= len(body) {
-		return nil
+		return nil, startPos
 	}
 
 	remaining := string(body[startPos:])
-	lines := strings.Split(remaining, "\n")
 
 	// Track cumulative token usage
 	var cumulativeUsage TokenUsage
 
-	// Track tool call info by index
-	toolInfoByIndex := make(map[int]struct {
+	// Track tool call info by choice index and tool call index
+	toolInfoByIndex := make(map[[2]int]struct {
 		toolID   string
 		toolName string
 	})
@@ -337,6 +395,9 @@ func (o openaiProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDel
 		if newDelta.Usage == (TokenUsage{}) {
 			newDelta.Usage = cumulativeUsage
 		}
+		if newDelta.Text != "" || newDelta.Thinking != "" || newDelta.ToolData != "" {
+			newDelta.ContentEvents = 1
+		}
 
 		// First delta always gets appended
 		if len(deltas) == 0 {
@@ -346,15 +407,23 @@ func (o openaiProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDel
 
 		last := &deltas[len(deltas)-1]
 
+		// Deltas of different choices (n>1) are never merged
+		if newDelta.Index != last.Index {
+			deltas = append(deltas, newDelta)
+			return
+		}
+
 		// 1. Merge text content
 		if newDelta.Text != "" {
 			last.Text += newDelta.Text
+			last.ContentEvents += newDelta.ContentEvents
 			return
 		}
 
 		// 2. Merge reasoning content (for o1 model)
 		if newDelta.Thinking != "" {
 			last.Thinking += newDelta.Thinking
+			last.ContentEvents += newDelta.ContentEvents
 			return
 		}
 
@@ -376,6 +445,7 @@ func (o openaiProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDel
 				(newDelta.ToolName == "" || newDelta.ToolName == last.ToolName)
 			if sameTool && !strings.HasSuffix(last.ToolData, newDelta.ToolData) {
 				last.ToolData += newDelta.ToolData
+				last.ContentEvents += newDelta.ContentEvents
 				return
 			}
 		}
@@ -384,28 +454,24 @@ func (o openaiProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDel
 		deltas = append(deltas, newDelta)
 	}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-
-		data := strings.TrimPrefix(line, "data: ")
-		if data == "" {
-			continue
-		}
+	consumed, failed := forEachSSEData(remaining, func(data string) error {
 		if data == "[DONE]" {
+			// [DONE] ends the stream, attach it to the last choice seen
+			index := 0
+			if len(deltas) > 0 {
+				index = deltas[len(deltas)-1].Index
+			}
 			tryMergeDelta(TokenDelta{
 				Text:       "",
 				IsComplete: true,
+				Index:      index,
 			})
-			continue
+			return nil
 		}
 
 		var chunk OpenAIStreamChunk
 		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-			o.logger.Warn("failed to parse OpenAI SSE event", "error", err, "data", data)
-			continue
+			return err
 		}
 
 		// Update cumulative usage if present
@@ -425,13 +491,15 @@ func (o openaiProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDel
 			if choice.Delta.ReasoningContent != "" {
 				tryMergeDelta(TokenDelta{
 					Thinking: choice.Delta.ReasoningContent,
+					Index:    choice.Index,
 				})
 			}
 
 			// Handle content
 			if choice.Delta.Content != "" {
 				tryMergeDelta(TokenDelta{
-					Text: choice.Delta.Content,
+					Text:  choice.Delta.Content,
+					Index: choice.Index,
 				})
 			}
 
@@ -440,7 +508,7 @@ func (o openaiProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDel
 				for i, toolCall := range choice.Delta.ToolCalls {
 					if toolCall.ID != "" && toolCall.Function.Name != "" {
 						// Store tool info for later use
-						toolInfoByIndex[i] = struct {
+						toolInfoByIndex[[2]int{choice.Index, i}] = struct {
 							toolID   string
 							toolName string
 						}{
@@ -450,16 +518,18 @@ func (o openaiProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDel
 						tryMergeDelta(TokenDelta{
 							ToolName: toolCall.Function.Name,
 							ToolID:   toolCall.ID,
+							Index:    choice.Index,
 						})
 					}
 					if toolCall.Function.Arguments != "" {
 						var toolID string
-						if info, exists := toolInfoByIndex[i]; exists {
+						if info, exists := toolInfoByIndex[[2]int{choice.Index, i}]; exists {
 							toolID = info.toolID
 						}
 						tryMergeDelta(TokenDelta{
 							ToolData: toolCall.Function.Arguments,
 							ToolID:   toolID,
+							Index:    choice.Index,
 						})
 					}
 				}
@@ -472,10 +542,15 @@ func (o openaiProvider) ParseSSEStreamFrom(body []byte, startPos int) []TokenDel
 					IsComplete: true,
 					StopReason: choice.FinishReason,
 					Usage:      cumulativeUsage,
+					Index:      choice.Index,
 				})
 			}
 		}
+		return nil
+	})
+	for _, f := range failed {
+		o.logger.Warn("failed to parse OpenAI SSE event", "error", f.err, "data", f.data)
 	}
 
-	return deltas
+	return deltas, startPos + consumed
 }
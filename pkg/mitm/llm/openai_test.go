@@ -36,6 +36,13 @@ func TestOpenAIMatch(t *testing.T) {
 			body:     []byte(`{"model": "gpt-4"}`),
 			want:     true,
 		},
+		{
+			name:     "Azure deployment behind a gateway",
+			hostname: "llm-gateway.example.com",
+			path:     "/openai/deployments/gpt-35-instruct/completions?api-version=2024-10-21",
+			body:     []byte(`{"prompt": "Hello"}`),
+			want:     true,
+		},
 		{
 			name:     "unknown hostname",
 			hostname: "api.unknown.com",
@@ -259,6 +266,53 @@ func TestOpenAIParseResponse(t *testing.T) {
 	}
 }
 
+func TestOpenAIParseResponseError(t *testing.T) {
+	provider := openaiProvider{logger: slog.Default()}
+
+	tests := []struct {
+		name string
+		body string
+		want APIError
+	}{
+		{
+			name: "400 invalid request",
+			body: `{"error": {"message": "Unrecognized request argument supplied: foo", "type": "invalid_request_error", "param": null, "code": null}}`,
+			want: APIError{Type: "invalid_request_error", Message: "Unrecognized request argument supplied: foo"},
+		},
+		{
+			name: "401 invalid api key",
+			body: `{"error": {"message": "Incorrect API key provided: sk-abc.", "type": "invalid_request_error", "param": null, "code": "invalid_api_key"}}`,
+			want: APIError{Type: "invalid_request_error", Message: "Incorrect API key provided: sk-abc.", Code: "invalid_api_key"},
+		},
+		{
+			name: "numeric code without type",
+			body: `{"error": {"message": "Invalid token", "code": 401}}`,
+			want: APIError{Type: "401", Message: "Invalid token", Code: "401"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := provider.ParseResponse("/v1/chat/completions", []byte(tt.body))
+			if err != nil {
+				t.Fatalf("ParseResponse() error = %v", err)
+			}
+			if got.Error == nil {
+				t.Fatal("Expected Error to be set")
+			}
+			if *got.Error != tt.want {
+				t.Errorf("Error = %+v, want %+v", *got.Error, tt.want)
+			}
+			if got.Content != tt.want.Message {
+				t.Errorf("Content = %v, want %v", got.Content, tt.want.Message)
+			}
+			if got.StopReason != "error" {
+				t.Errorf("StopReason = %v, want error", got.StopReason)
+			}
+		})
+	}
+}
+
 func TestOpenAIParseFullRequest(t *testing.T) {
 	provider := openaiProvider{logger: slog.Default()}
 
@@ -349,7 +403,7 @@ func TestOpenAIParseFullRequest(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := provider.ParseFullRequest("", nil, []byte(tt.body))
+			got, err := provider.ParseFullRequest("", "", nil, []byte(tt.body))
 			if tt.want == nil {
 				if err == nil {
 					t.Error("expected error for invalid JSON")
@@ -377,6 +431,77 @@ func TestOpenAIParseFullRequest(t *testing.T) {
 	}
 }
 
+func TestFindProvider_Azure(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		body      string
+		wantModel string
+	}{
+		{
+			name:      "deployment without model in body",
+			path:      "/openai/deployments/gpt-4o-prod/chat/completions?api-version=2024-10-21",
+			body:      `{"messages":[{"role":"user","content":"Hello"}],"stream":true}`,
+			wantModel: "gpt-4o-prod",
+		},
+		{
+			name:      "deployment wins over body model",
+			path:      "/openai/deployments/team-gpt4/chat/completions?api-version=2025-01-01-preview",
+			body:      `{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`,
+			wantModel: "team-gpt4",
+		},
+		{
+			name:      "v1 API keeps the body model",
+			path:      "/openai/v1/chat/completions?api-version=preview",
+			body:      `{"model":"gpt-4.1","messages":[{"role":"user","content":"Hello"}]}`,
+			wantModel: "gpt-4.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostname := "my-resource.openai.azure.com"
+			provider := FindProvider(hostname, tt.path, []byte(tt.body), testLogger())
+			if _, ok := provider.(openaiProvider); !ok {
+				t.Fatalf("Provider = %T, want openaiProvider", provider)
+			}
+			info, err := provider.ParseFullRequest(hostname, tt.path, nil, []byte(tt.body))
+			if err != nil {
+				t.Fatalf("ParseFullRequest() error = %v", err)
+			}
+			if info.Model != tt.wantModel {
+				t.Errorf("Model = %v, want %v", info.Model, tt.wantModel)
+			}
+			if len(info.Messages) != 1 {
+				t.Errorf("Messages length = %v, want 1", len(info.Messages))
+			}
+		})
+	}
+}
+
+func TestParseAzurePath(t *testing.T) {
+	tests := []struct {
+		path           string
+		wantDeployment string
+		wantOperation  string
+		wantOK         bool
+	}{
+		{"/openai/deployments/gpt-4o/chat/completions?api-version=2024-10-21", "gpt-4o", "/chat/completions", true},
+		{"/openai/deployments/embed-small/embeddings", "embed-small", "/embeddings", true},
+		{"/openai/deployments/gpt-4o", "", "", false},
+		{"/openai/deployments//chat/completions", "", "", false},
+		{"/v1/chat/completions", "", "", false},
+	}
+
+	for _, tt := range tests {
+		deployment, operation, ok := parseAzurePath(tt.path)
+		if deployment != tt.wantDeployment || operation != tt.wantOperation || ok != tt.wantOK {
+			t.Errorf("parseAzurePath(%q) = %q, %q, %v, want %q, %q, %v",
+				tt.path, deployment, operation, ok, tt.wantDeployment, tt.wantOperation, tt.wantOK)
+		}
+	}
+}
+
 func TestOpenAIParseSSEStreamFrom(t *testing.T) {
 	provider := openaiProvider{logger: slog.Default()}
 
@@ -439,7 +564,7 @@ data: [DONE]
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			body := []byte(tt.body)
-			deltas := provider.ParseSSEStreamFrom(body, tt.startPos)
+			deltas, _ := provider.ParseSSEStreamFrom(body, tt.startPos)
 
 			var text string
 			for _, d := range deltas {
@@ -636,7 +761,7 @@ data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1677652288
 data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1677652288,"model":"gpt-3.5-turbo","choices":[{"index":0,"delta":{},"finish_reason":"stop","usage":{"completion_tokens":5}}]}
 `
 
-	deltas := provider.ParseSSEStreamFrom([]byte(body), 0)
+	deltas, _ := provider.ParseSSEStreamFrom([]byte(body), 0)
 
 	if len(deltas) != 1 {
 		t.Errorf("expected 1 merged delta, got %d", len(deltas))
@@ -665,7 +790,7 @@ func TestOpenAISSELastChunkWithContent(t *testing.T) {
 data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1677652288,"model":"gpt-3.5-turbo","choices":[{"index":0,"delta":{"content":"?"},"finish_reason":"stop"}]}
 `
 
-	deltas := provider.ParseSSEStreamFrom([]byte(body), 0)
+	deltas, _ := provider.ParseSSEStreamFrom([]byte(body), 0)
 
 	// Should have 1 delta with merged content
 	if len(deltas) != 1 {
@@ -697,7 +822,7 @@ data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1677652288
 data: [DONE]
 `
 
-	deltas := provider.ParseSSEStreamFrom([]byte(body), 0)
+	deltas, _ := provider.ParseSSEStreamFrom([]byte(body), 0)
 
 	if len(deltas) != 1 {
 		t.Errorf("expected 1 delta, got %d", len(deltas))
@@ -726,3 +851,82 @@ data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1677652288
 		provider.ParseSSEStreamFrom([]byte(body), 0)
 	}
 }
+
+// TestOpenAIParseResponseMultipleChoices tests n>1 responses keep every choice
+func TestOpenAIParseResponseMultipleChoices(t *testing.T) {
+	provider := openaiProvider{logger: slog.Default()}
+
+	body := `{"id":"chatcmpl-123","object":"chat.completion","model":"gpt-4","choices":[{"index":1,"message":{"role":"assistant","content":"Hi there"},"finish_reason":"length"},{"index":0,"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":4,"total_tokens":9}}`
+
+	resp, err := provider.ParseResponse("/v1/chat/completions", []byte(body))
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+
+	// Top-level fields mirror choice 0
+	if resp.Content != "Hello" || resp.StopReason != "stop" {
+		t.Errorf("expected first choice 'Hello'/stop, got %q/%q", resp.Content, resp.StopReason)
+	}
+	if len(resp.Choices) != 2 {
+		t.Fatalf("expected 2 choices, got %d", len(resp.Choices))
+	}
+	want := []ResponseChoice{
+		{Index: 0, Content: "Hello", StopReason: "stop"},
+		{Index: 1, Content: "Hi there", StopReason: "length"},
+	}
+	for i, w := range want {
+		got := resp.Choices[i]
+		if got.Index != w.Index || got.Content != w.Content || got.StopReason != w.StopReason {
+			t.Errorf("choice %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+// TestOpenAIParseResponseSingleChoice tests single choice responses don't populate Choices
+func TestOpenAIParseResponseSingleChoice(t *testing.T) {
+	provider := openaiProvider{logger: slog.Default()}
+
+	body := `{"choices":[{"index":0,"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}]}`
+
+	resp, err := provider.ParseResponse("/v1/chat/completions", []byte(body))
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if resp.Choices != nil {
+		t.Errorf("expected no Choices for a single choice response, got %+v", resp.Choices)
+	}
+}
+
+// TestOpenAISSEInterleavedChoices tests streamed n>1 choices are kept apart by index
+func TestOpenAISSEInterleavedChoices(t *testing.T) {
+	provider := openaiProvider{logger: slog.Default()}
+
+	body := `data: {"id":"chatcmpl-123","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"Hello"}}]}
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","choices":[{"index":1,"delta":{"content":"Hi"}}]}
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":" World"}}]}
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","choices":[{"index":1,"delta":{"content":" there"},"finish_reason":"stop"}]}
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"length"}]}
+data: [DONE]
+`
+
+	deltas, _ := provider.ParseSSEStreamFrom([]byte(body), 0)
+
+	textByIndex := make(map[int]string)
+	stopByIndex := make(map[int]string)
+	for _, d := range deltas {
+		textByIndex[d.Index] += d.Text
+		if d.IsComplete && d.StopReason != "" {
+			stopByIndex[d.Index] = d.StopReason
+		}
+	}
+
+	if textByIndex[0] != "Hello World" {
+		t.Errorf("expected choice 0 text 'Hello World', got %q", textByIndex[0])
+	}
+	if textByIndex[1] != "Hi there" {
+		t.Errorf("expected choice 1 text 'Hi there', got %q", textByIndex[1])
+	}
+	if stopByIndex[0] != "length" || stopByIndex[1] != "stop" {
+		t.Errorf("expected stop reasons length/stop, got %q/%q", stopByIndex[0], stopByIndex[1])
+	}
+}
@@ -5,22 +5,34 @@ import (
 	"strings"
 )
 
+// Conversation ID strategies for Anthropic requests
+const (
+	ConversationIDMetadata     = "metadata"      // Hash of metadata.user_id, the default
+	ConversationIDMessagesHash = "messages-hash" // Hash of the system prompt and first message, stable as history grows
+	ConversationIDHeader       = "header"        // Value of a configured request header
+)
+
 // ProviderMatcher defines custom matching rules for LLM providers
 type ProviderMatcher struct {
 	CustomAnthropicMatches []string
 	CustomOpenAIMatches    []string
 	CustomGeminiMatches    []string
+	ConversationIDStrategy string // One of the ConversationID* strategies, empty = metadata
+	ConversationIDHeader   string // Request header used by the header strategy
 }
 
 // Provider interface defines the contract for LLM API parsers
 type Provider interface {
 	Match(hostname, path string, body []byte) bool
 	ParseResponse(path string, body []byte) (*LLMResponse, error)
-	// ParseSSEStreamFrom parses SSE stream from a specific position (for incremental processing)
-	ParseSSEStreamFrom(body []byte, startPos int) []TokenDelta
+	// ParseSSEStreamFrom parses SSE stream from a specific position (for incremental processing),
+	// returning the position after the last complete event. An event cut off at the end of body
+	// is not parsed, it is left for the next call, so a finished stream is passed ending with a
+	// blank line.
+	ParseSSEStreamFrom(body []byte, startPos int) ([]TokenDelta, int)
 	// ParseFullRequest parses the request body once and returns all extracted info
 	// This avoids multiple JSON unmarshaling of the same request
-	ParseFullRequest(hostname string, headers map[string]string, body []byte) (*RequestInfo, error)
+	ParseFullRequest(hostname, path string, headers map[string]string, body []byte) (*RequestInfo, error)
 }
 
 // FindProvider returns the appropriate provider for the given request
@@ -30,18 +42,7 @@ func FindProvider(hostname, path string, body []byte, logger *slog.Logger) Provi
 
 // FindProviderWithMatcher returns the appropriate provider for the given request with custom matching rules
 func FindProviderWithMatcher(hostname, path string, body []byte, logger *slog.Logger, matcher *ProviderMatcher) Provider {
-	providers := []Provider{
-		anthropicProvider{logger: logger, customMatches: matcher},
-		openaiProvider{logger: logger, customMatches: matcher},
-		geminiProvider{logger: logger, customMatches: matcher},
-	}
-
-	for _, p := range providers {
-		if p.Match(hostname, path, body) {
-			return p
-		}
-	}
-	return nil
+	return NewDefaultRegistry(logger, matcher).Find(hostname, path, body)
 }
 
 // matchCustomPattern checks if hostname/path matches a custom pattern (format: "hostname/path")
@@ -57,3 +58,37 @@ func matchCustomPattern(hostname, path, pattern string) bool {
 
 	return hostname == patternHostname && strings.HasPrefix(path, patternPath)
 }
+
+// parseVertexPath extracts publisher and model from a Vertex AI model path, e.g.
+// /v1/projects/p/locations/us-east5/publishers/anthropic/models/claude-sonnet-4@20250514:streamRawPredict
+func parseVertexPath(hostname, path string) (publisher, model string, ok bool) {
+	if !strings.HasSuffix(hostname, "aiplatform.googleapis.com") {
+		return "", "", false
+	}
+	_, rest, found := strings.Cut(path, "/publishers/")
+	if !found {
+		return "", "", false
+	}
+	publisher, rest, found = strings.Cut(rest, "/models/")
+	if !found || publisher == "" {
+		return "", "", false
+	}
+	model, _, _ = strings.Cut(rest, ":")
+	return publisher, model, model != ""
+}
+
+// parseAzurePath extracts the deployment and operation from an Azure OpenAI path,
+// dropping the api-version query, e.g.
+// /openai/deployments/gpt-4o/chat/completions?api-version=2024-10-21
+func parseAzurePath(path string) (deployment, operation string, ok bool) {
+	path, _, _ = strings.Cut(path, "?")
+	rest, found := strings.CutPrefix(path, "/openai/deployments/")
+	if !found {
+		return "", "", false
+	}
+	deployment, operation, found = strings.Cut(rest, "/")
+	if !found || deployment == "" || operation == "" {
+		return "", "", false
+	}
+	return deployment, "/" + operation, true
+}
@@ -0,0 +1,74 @@
+package llm
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// Priorities of the built-in providers, the generic OpenAI-compatible matcher comes last so
+// more specific providers win requests both could parse
+const (
+	PriorityAnthropic = 300
+	PriorityGemini    = 200
+	PriorityOpenAI    = 100
+)
+
+// Registry matches requests against registered providers in priority order
+type Registry struct {
+	mu      sync.RWMutex
+	entries []registryEntry // highest priority first, registration order on ties
+}
+
+type registryEntry struct {
+	provider Provider
+	priority int
+}
+
+// NewRegistry creates an empty provider registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewDefaultRegistry creates a registry holding the built-in providers
+func NewDefaultRegistry(logger *slog.Logger, matcher *ProviderMatcher) *Registry {
+	r := NewRegistry()
+	r.Register(anthropicProvider{logger: logger, customMatches: matcher}, PriorityAnthropic)
+	r.Register(geminiProvider{logger: logger, customMatches: matcher}, PriorityGemini)
+	r.Register(openaiProvider{logger: logger, customMatches: matcher}, PriorityOpenAI)
+	return r
+}
+
+// Register adds provider, it is tried before every provider with a lower priority
+func (r *Registry) Register(provider Provider, priority int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := slices.IndexFunc(r.entries, func(e registryEntry) bool { return e.priority < priority })
+	if i < 0 {
+		i = len(r.entries)
+	}
+	r.entries = slices.Insert(r.entries, i, registryEntry{provider: provider, priority: priority})
+}
+
+// Find returns the highest priority provider matching the request, nil if none does
+func (r *Registry) Find(hostname, path string, body []byte) Provider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, e := range r.entries {
+		if e.provider.Match(hostname, path, body) {
+			return e.provider
+		}
+	}
+	return nil
+}
+
+// Providers returns the registered providers in matching order
+func (r *Registry) Providers() []Provider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	providers := make([]Provider, len(r.entries))
+	for i, e := range r.entries {
+		providers[i] = e.provider
+	}
+	return providers
+}
@@ -0,0 +1,80 @@
+package llm
+
+import (
+	"fmt"
+	"testing"
+)
+
+// stubProvider matches requests to host
+type stubProvider struct {
+	openaiProvider
+	name string
+	host string
+}
+
+func (s stubProvider) Match(hostname, path string, body []byte) bool {
+	return hostname == s.host
+}
+
+func TestRegistry_PriorityOrder(t *testing.T) {
+	r := NewRegistry()
+	r.Register(stubProvider{name: "low"}, 10)
+	r.Register(stubProvider{name: "high"}, 50)
+	r.Register(stubProvider{name: "mid"}, 20)
+	r.Register(stubProvider{name: "mid-later"}, 20)
+
+	var names []string
+	for _, p := range r.Providers() {
+		names = append(names, p.(stubProvider).name)
+	}
+	if got := fmt.Sprint(names); got != "[high mid mid-later low]" {
+		t.Errorf("Providers() order = %s, want [high mid mid-later low]", got)
+	}
+}
+
+func TestRegistry_HigherPriorityWinsContestedMatch(t *testing.T) {
+	r := NewRegistry()
+	r.Register(stubProvider{name: "generic", host: "api.example.com"}, 10)
+	r.Register(stubProvider{name: "specific", host: "api.example.com"}, 100)
+
+	p := r.Find("api.example.com", "/", nil)
+	if p == nil || p.(stubProvider).name != "specific" {
+		t.Errorf("Find() = %v, want the specific provider", p)
+	}
+	if p := r.Find("other.example.com", "/", nil); p != nil {
+		t.Errorf("Find() = %v, want nil for an unmatched host", p)
+	}
+}
+
+func TestDefaultRegistry_SpecificProvidersOutrankOpenAI(t *testing.T) {
+	matcher := &ProviderMatcher{CustomGeminiMatches: []string{"llm.internal/v1/chat/completions"}}
+	r := NewDefaultRegistry(testLogger(), matcher)
+
+	tests := []struct {
+		hostname string
+		path     string
+		want     Provider
+	}{
+		// /chat/completions would also match OpenAI, the custom Gemini match wins
+		{"llm.internal", "/v1/chat/completions", geminiProvider{}},
+		{"api.anthropic.com", "/v1/messages", anthropicProvider{}},
+		{"generativelanguage.googleapis.com", "/v1beta/models/gemini-2.5-pro:generateContent", geminiProvider{}},
+		// Gemini's OpenAI-compatible endpoint speaks the OpenAI format
+		{"generativelanguage.googleapis.com", "/v1beta/openai/chat/completions", openaiProvider{}},
+		{"api.openai.com", "/v1/chat/completions", openaiProvider{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hostname+tt.path, func(t *testing.T) {
+			got := r.Find(tt.hostname, tt.path, nil)
+			if fmt.Sprintf("%T", got) != fmt.Sprintf("%T", tt.want) {
+				t.Errorf("Find() = %T, want %T", got, tt.want)
+			}
+		})
+	}
+
+	// Providers registered later outrank the built-ins with a higher priority
+	r.Register(stubProvider{name: "custom", host: "api.openai.com"}, PriorityAnthropic+1)
+	if p, ok := r.Find("api.openai.com", "/v1/chat/completions", nil).(stubProvider); !ok || p.name != "custom" {
+		t.Errorf("Expected the dynamically registered provider to win, got %T", p)
+	}
+}
@@ -1,6 +1,7 @@
 package llm
 
 import (
+	"sort"
 	"time"
 )
 
@@ -34,6 +35,11 @@ type ToolCall struct {
 	ID       string       `json:"id"`
 	Type     string       `json:"type"`
 	Function FunctionCall `json:"function"`
+	// ArgumentsComplete is false when the arguments were cut short (stream ended, max_tokens),
+	// Arguments then holds a best-effort repair for display only
+	ArgumentsComplete bool `json:"arguments_complete"`
+	// Result summarizes the output of a tool run by the provider itself (e.g. Anthropic web search)
+	Result string `json:"result,omitempty"`
 }
 
 // FunctionCall represents a function call within a tool call
@@ -54,8 +60,10 @@ func (tu TokenUsage) TotalTokens() int {
 
 // APIError represents an API error response
 type APIError struct {
-	Type    string `json:"type"`
-	Message string `json:"message"`
+	Type       string `json:"type"`
+	Message    string `json:"message"`
+	Code       string `json:"code,omitempty"`        // provider specific error code, e.g. OpenAI's invalid_api_key
+	RetryAfter int    `json:"retry_after,omitempty"` // seconds to wait before retrying, from Retry-After
 }
 
 // LLMResponse represents a response from an LLM
@@ -66,56 +74,148 @@ type LLMResponse struct {
 	Usage      TokenUsage `json:"usage"`
 	Error      *APIError  `json:"error,omitempty"`
 	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	// Choices holds every completion when the response has more than one (OpenAI n>1),
+	// the fields above mirror the first choice
+	Choices []ResponseChoice `json:"choices,omitempty"`
+}
+
+// ResponseChoice represents one of multiple completions in a response
+type ResponseChoice struct {
+	Index      int        `json:"index"`
+	Content    string     `json:"content"`
+	StopReason string     `json:"stop_reason"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
 }
 
 // TokenDelta represents incremental token updates for streaming
 type TokenDelta struct {
 	Text       string     `json:"text"`
 	Thinking   string     `json:"thinking,omitempty"`
-	ToolData   string     `json:"tool_data,omitempty"` // tool call JSON data (for input_json_delta)
-	ToolName   string     `json:"tool_name,omitempty"` // tool name for tool_calls
-	ToolID     string     `json:"tool_id,omitempty"`   // tool call ID
+	ToolData   string     `json:"tool_data,omitempty"`   // tool call JSON data (for input_json_delta)
+	ToolName   string     `json:"tool_name,omitempty"`   // tool name for tool_calls
+	ToolID     string     `json:"tool_id,omitempty"`     // tool call ID
+	ToolResult string     `json:"tool_result,omitempty"` // result summary of a server-side tool call ToolID
 	IsComplete bool       `json:"is_complete"`
 	StopReason string     `json:"stop_reason,omitempty"`
 	Usage      TokenUsage `json:"usage,omitempty"` // cumulative token usage
+	Index      int        `json:"index,omitempty"` // choice index when multiple completions are streamed
+	// ContentEvents counts the stream events carrying text, thinking or tool data merged
+	// into this delta, the tokens of a chunk arrive as many events but merge into few deltas
+	ContentEvents int `json:"-"`
 }
 
 // LLMMessageEvent is published when a new LLM message is detected
 type LLMMessageEvent struct {
-	ID             string     `json:"id"`
-	Timestamp      time.Time  `json:"timestamp"`
-	ConversationID string     `json:"conversation_id"`
-	Message        LLMMessage `json:"message"`
-	TokenCount     int        `json:"token_count,omitempty"`  // token count for this message
-	TotalTokens    int        `json:"total_tokens,omitempty"` // total tokens in conversation
-	Model          string     `json:"model,omitempty"`        // model name
+	ID             string       `json:"id"`
+	Timestamp      time.Time    `json:"timestamp"`
+	ConversationID string       `json:"conversation_id"`
+	Message        LLMMessage   `json:"message"`
+	TokenCount     int          `json:"token_count,omitempty"`  // token count for this message
+	TotalTokens    int          `json:"total_tokens,omitempty"` // total tokens in conversation
+	Model          string       `json:"model,omitempty"`        // model name
+	Tools          *ToolSummary `json:"tools,omitempty"`        // tools offered by the request, nil when none
+}
+
+// ToolSummary is a compact view of the tools offered by a request
+type ToolSummary struct {
+	Count int           `json:"count"`
+	Names []string      `json:"names"`
+	Tools []ToolOutline `json:"tools"`
+}
+
+// ToolOutline summarizes a single tool's input schema
+type ToolOutline struct {
+	Name     string   `json:"name"`
+	Params   []string `json:"params,omitempty"`   // top-level property names, sorted
+	Required []string `json:"required,omitempty"` // required property names
 }
 
+// SummarizeTools builds a ToolSummary from tool definitions, returns nil for no tools
+func SummarizeTools(tools []ToolDef) *ToolSummary {
+	if len(tools) == 0 {
+		return nil
+	}
+	summary := &ToolSummary{
+		Count: len(tools),
+		Names: make([]string, 0, len(tools)),
+		Tools: make([]ToolOutline, 0, len(tools)),
+	}
+	for _, t := range tools {
+		outline := ToolOutline{Name: t.Name}
+		if props, ok := t.InputSchema["properties"].(map[string]interface{}); ok {
+			for name := range props {
+				outline.Params = append(outline.Params, name)
+			}
+			sort.Strings(outline.Params)
+		}
+		if required, ok := t.InputSchema["required"].([]interface{}); ok {
+			for _, r := range required {
+				if name, ok := r.(string); ok {
+					outline.Required = append(outline.Required, name)
+				}
+			}
+		}
+		summary.Names = append(summary.Names, t.Name)
+		summary.Tools = append(summary.Tools, outline)
+	}
+	return summary
+}
+
+// Token event types, a stream emits one start, any number of deltas and one end sharing the same ID
+const (
+	TokenEventStart = "message_start"
+	TokenEventDelta = "message_delta"
+	TokenEventEnd   = "message_end"
+)
+
 // LLMTokenEvent is published during streaming responses
 type LLMTokenEvent struct {
 	ID             string `json:"id"`
+	Type           string `json:"type"` // TokenEventStart, TokenEventDelta or TokenEventEnd
 	ConversationID string `json:"conversation_id"`
-	Delta          string `json:"delta"`               // new token content
-	Thinking       string `json:"thinking,omitempty"`  // thinking content (for Claude)
-	ToolName       string `json:"tool_name,omitempty"` // tool name for tool_calls
-	ToolID         string `json:"tool_id,omitempty"`   // tool call ID
-	ToolData       string `json:"tool_data,omitempty"` // tool call arguments delta
-	IsComplete     bool   `json:"is_complete"`         // true when streaming is done
+	Delta          string `json:"delta"`                 // new token content
+	Thinking       string `json:"thinking,omitempty"`    // thinking content (for Claude)
+	ToolName       string `json:"tool_name,omitempty"`   // tool name for tool_calls
+	ToolID         string `json:"tool_id,omitempty"`     // tool call ID
+	ToolData       string `json:"tool_data,omitempty"`   // tool call arguments delta
+	ToolResult     string `json:"tool_result,omitempty"` // server-side tool result summary
+	IsComplete     bool   `json:"is_complete"`           // true when streaming is done
 	StopReason     string `json:"stop_reason,omitempty"`
 	TokenCount     int    `json:"token_count,omitempty"`  // token count for this message
 	TotalTokens    int    `json:"total_tokens,omitempty"` // total tokens in conversation
 }
 
+// AuxiliaryRequestEvent is published for non-chat endpoints that must not join a conversation
+type AuxiliaryRequestEvent struct {
+	ID          string    `json:"id"`
+	Timestamp   time.Time `json:"timestamp"`
+	Endpoint    string    `json:"endpoint"` // "count_tokens", "batches"
+	Model       string    `json:"model,omitempty"`
+	InputTokens int       `json:"input_tokens,omitempty"`
+}
+
 // ConversationUpdateEvent is published when conversation status changes
 type ConversationUpdateEvent struct {
+	ID                 string    `json:"id"`
+	Timestamp          time.Time `json:"timestamp"`
+	ConversationID     string    `json:"conversation_id"`
+	Status             string    `json:"status"` // "streaming", "complete", "error"
+	MessageCount       int       `json:"message_count"`
+	TotalTokens        int       `json:"total_tokens"`
+	Duration           int64     `json:"duration_ms"` // request to completion in milliseconds, set when a stream completes
+	Model              string    `json:"model,omitempty"`
+	Slow               bool      `json:"slow,omitempty"`                   // streaming deltas arrive below the slow token rate, possibly throttled
+	TokenRate          float64   `json:"token_rate,omitempty"`             // deltas per second over the slow window, set on slow changes
+	TimeToFirstTokenMs int64     `json:"time_to_first_token_ms,omitempty"` // request to first streamed delta
+	TokensPerSecond    float64   `json:"tokens_per_second,omitempty"`      // output tokens per second from the first delta to completion
+}
+
+// ConversationDeletedEvent is published when captured conversations are removed
+type ConversationDeletedEvent struct {
 	ID             string    `json:"id"`
 	Timestamp      time.Time `json:"timestamp"`
-	ConversationID string    `json:"conversation_id"`
-	Status         string    `json:"status"` // "streaming", "complete", "error"
-	MessageCount   int       `json:"message_count"`
-	TotalTokens    int       `json:"total_tokens"`
-	Duration       int64     `json:"duration_ms"` // duration in milliseconds
-	Model          string    `json:"model,omitempty"`
+	ConversationID string    `json:"conversation_id,omitempty"` // empty when All is set
+	All            bool      `json:"all,omitempty"`             // every conversation was cleared
 }
 
 // RequestInfo contains parsed information from an LLM request body
@@ -126,6 +226,7 @@ type RequestInfo struct {
 	Messages       []LLMMessage
 	SystemPrompts  []string
 	Tools          []ToolDef
+	Endpoint       string // Non-chat endpoint such as "count_tokens" or "batches", empty for conversation turns
 }
 
 // GeminiRequest represents a Gemini API request
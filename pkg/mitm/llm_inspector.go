@@ -1,10 +1,16 @@
 package mitm
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,19 +25,80 @@ type LLMInspector struct {
 	httpProc           HTTPProcessorInterface
 	requestPaths       sync.Map // requestID -> string (path)
 	conversationIDs    sync.Map // requestID -> string (conversationID)
+	models             sync.Map // requestID -> string (model)
 	processedBytes     sync.Map // requestID -> int (last processed byte position)
-	accumulatedContent sync.Map // requestID -> string (accumulated content for streaming)
-	providerMatcher    *llm.ProviderMatcher
+	inputTokens        sync.Map // requestID -> int (input tokens reported at stream start)
+	accumulatedContent sync.Map // streamKey -> string (accumulated content for streaming)
+	toolCalls          sync.Map // streamKey -> *streamToolCalls
+	openChoices        sync.Map // requestID -> int (choices still streaming)
+	auxiliary          sync.Map // requestID -> string (non-chat endpoint)
+	tokenRates         sync.Map // requestID -> *tokenRate
+	timings            sync.Map // requestID -> *requestTiming
+	providers          *llm.Registry
+	slowTokenRate      float64       // content events per second below which a stream is slow, 0 = disabled
+	slowTokenWindow    time.Duration // how long the rate must stay below slowTokenRate
+	lifetime           *streamLifetime
+	grpcHosts          []string // lowercase hostname globs whose responses carry a gRPC status
+	now                func() time.Time
 }
 
 // NewLLMInspector creates a new LLMInspector
 func NewLLMInspector(logger *slog.Logger, eventBus *EventBus, hostname string, providerMatcher *llm.ProviderMatcher) *LLMInspector {
 	return &LLMInspector{
-		BaseInspector:   NewBaseInspector("llm_inspector", hostname),
-		logger:          logger,
-		eventBus:        eventBus,
-		httpProc:        NewHTTPProcessor(logger, 0),
-		providerMatcher: providerMatcher,
+		BaseInspector: NewBaseInspector("llm_inspector", hostname),
+		logger:        logger,
+		eventBus:      eventBus,
+		httpProc:      NewHTTPProcessor(logger, 0),
+		providers:     llm.NewDefaultRegistry(logger, providerMatcher),
+		now:           time.Now,
+	}
+}
+
+// DefaultSlowTokenWindow is the slow detection window used when SetSlowTokenRate gets none
+const DefaultSlowTokenWindow = 5 * time.Second
+
+// SetSlowTokenRate flags streams whose content events arrive slower than rate per second for a
+// whole window with a "slow" conversation update, rate 0 disables the detection. A stream that
+// stalls completely is flagged a window after its last content.
+func (l *LLMInspector) SetSlowTokenRate(rate float64, window time.Duration) {
+	if window <= 0 {
+		window = DefaultSlowTokenWindow
+	}
+	l.slowTokenRate = rate
+	l.slowTokenWindow = window
+}
+
+// SetMaxStreamDuration completes LLM streams open longer than d and passes the rest of them
+// through uninspected, 0 = unlimited
+func (l *LLMInspector) SetMaxStreamDuration(d time.Duration) {
+	l.lifetime = newStreamLifetime(d)
+}
+
+// SetGRPCHosts makes responses of hostnames matching the globs in hosts report a non-zero
+// grpc-status as an LLM error
+func (l *LLMInspector) SetGRPCHosts(hosts []string) {
+	l.grpcHosts = make([]string, len(hosts))
+	for i, host := range hosts {
+		l.grpcHosts[i] = strings.ToLower(host)
+	}
+}
+
+// RegisterProvider adds an LLM provider, matched before every provider with a lower priority
+func (l *LLMInspector) RegisterProvider(provider llm.Provider, priority int) {
+	l.providers.Register(provider, priority)
+}
+
+// SetMaxHeaderSize sets the header size past which a stream is passed through unparsed
+func (l *LLMInspector) SetMaxHeaderSize(size int64) {
+	if proc, ok := l.httpProc.(*HTTPProcessor); ok {
+		proc.SetMaxHeaderSize(size)
+	}
+}
+
+// SetMaxChunkedBodySize sets the chunked request body size past which the request is passed through unparsed
+func (l *LLMInspector) SetMaxChunkedBodySize(size int64) {
+	if proc, ok := l.httpProc.(*HTTPProcessor); ok {
+		proc.SetMaxChunkedBodySize(size)
 	}
 }
 
@@ -77,20 +144,29 @@ func (l *LLMInspector) processCompleteRequest(httpMsg *HTTPMessage, requestID st
 	}
 
 	// Try to find a provider for this request
-	provider := llm.FindProviderWithMatcher(httpMsg.Hostname, httpMsg.Path, bodyBytes, l.logger, l.providerMatcher)
+	provider := l.providers.Find(httpMsg.Hostname, httpMsg.Path, bodyBytes)
 	if provider == nil {
 		return
 	}
 
 	// 一次解析获取所有信息
-	reqInfo, err := provider.ParseFullRequest(httpMsg.Hostname, httpMsg.Headers, bodyBytes)
+	reqInfo, err := provider.ParseFullRequest(httpMsg.Hostname, httpMsg.Path, httpMsg.Headers, bodyBytes)
 	if err != nil {
 		l.logger.Debug("failed to parse LLM request", "error", err)
 		return
 	}
 
-	// 缓存 conversationID，用于响应处理时匹配
+	// count_tokens / batches 不属于会话，响应时单独发布轻量事件
+	if reqInfo.Endpoint != "" {
+		l.auxiliary.Store(requestID, reqInfo.Endpoint)
+		l.models.Store(requestID, reqInfo.Model)
+		return
+	}
+
+	// 缓存 conversationID 和 model，用于响应处理时匹配
 	l.conversationIDs.Store(requestID, reqInfo.ConversationID)
+	l.models.Store(requestID, reqInfo.Model)
+	l.timings.Store(requestID, &requestTiming{start: l.now()})
 
 	if len(reqInfo.Messages) == 0 {
 		return
@@ -106,6 +182,8 @@ func (l *LLMInspector) processCompleteRequest(httpMsg *HTTPMessage, requestID st
 		Timestamp:      time.Now(),
 		ConversationID: reqInfo.ConversationID,
 		Message:        lastMsg,
+		Model:          reqInfo.Model,
+		Tools:          llm.SummarizeTools(reqInfo.Tools),
 	}
 
 	l.publishEvent("llm_message", event)
@@ -122,26 +200,93 @@ func (l *LLMInspector) processCompleteRequest(httpMsg *HTTPMessage, requestID st
 
 // inspectResponse processes server-to-client (response) traffic
 func (l *LLMInspector) inspectResponse(inputData []byte, hostname string, requestID string) ([]byte, error) {
+	if d := l.lifetime.acquire(requestID); d != nil {
+		defer d.mu.Unlock()
+		if d.expired {
+			return inputData, nil
+		}
+	}
+
 	_, httpMsg, complete, err := l.httpProc.ProcessResponse(inputData, requestID)
 	if err != nil || httpMsg == nil {
 		return inputData, nil
 	}
 
-	if httpMsg.IsSSE {
-		return l.processSSEStream(httpMsg, hostname, requestID)
+	if httpMsg.IsStream() {
+		if complete {
+			l.lifetime.stop(requestID)
+			defer l.releaseRequest(requestID)
+		} else {
+			defer l.lifetime.start(requestID, func() { l.expireStream(requestID) })
+		}
+		return l.processSSEStream(httpMsg, hostname, requestID, complete)
 	}
 
 	if complete {
-		l.processCompleteResponse(httpMsg, hostname, requestID)
+		if !l.processGRPCStatus(httpMsg, hostname, requestID) {
+			l.processCompleteResponse(httpMsg, hostname, requestID)
+		}
 		l.httpProc.ClearPending(requestID)
 	}
 
 	return inputData, nil
 }
 
+// isGRPCHost reports whether hostname was configured as a gRPC gateway
+func (l *LLMInspector) isGRPCHost(hostname string) bool {
+	host := strings.ToLower(hostname)
+	for _, pattern := range l.grpcHosts {
+		if matched, _ := path.Match(pattern, host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// processGRPCStatus publishes the error of a gRPC response whose grpc-status is not OK, the
+// HTTP status of such responses is usually 200. It reports whether the response was handled
+func (l *LLMInspector) processGRPCStatus(httpMsg *HTTPMessage, hostname, requestID string) bool {
+	if !l.isGRPCHost(hostname) {
+		return false
+	}
+	status, ok := decodeGRPCStatus(httpMsg)
+	if !ok || status.Code == 0 {
+		return false
+	}
+
+	var conversationID string
+	if val, exists := l.conversationIDs.Load(requestID); exists {
+		conversationID = val.(string)
+	}
+	model := l.requestModel(requestID)
+	l.requestPaths.Delete(requestID)
+
+	l.logger.Warn("LLM gRPC error",
+		"conversation_id", conversationID,
+		"grpc_status", status.Code,
+		"grpc_message", status.Message,
+	)
+	l.publishLLMError(conversationID, requestID, &llm.APIError{
+		Type:    status.CodeName(),
+		Message: status.Message,
+		Code:    strconv.Itoa(status.Code),
+	})
+	l.publishConversationUpdate(conversationID, "error", 0, 0, model)
+	return true
+}
+
 // processSSEStream processes streaming responses
-func (l *LLMInspector) processSSEStream(httpMsg *HTTPMessage, hostname string, requestID string) ([]byte, error) {
+func (l *LLMInspector) processSSEStream(httpMsg *HTTPMessage, hostname string, requestID string, complete bool) ([]byte, error) {
+	if complete {
+		// 流结束时仍未收到终止事件的 choice 在此收尾
+		defer l.finishOpenChoices(requestID)
+	}
+
 	bodyBytes := httpMsg.Body
+	if httpMsg.IsNDJSON {
+		// NDJSON 转成 SSE data 行，复用 provider 的流式解析
+		bodyBytes = ndjsonToSSE(httpMsg.NDJSONObjects())
+	}
 	if len(bodyBytes) == 0 {
 		return bodyBytes, nil
 	}
@@ -152,11 +297,24 @@ func (l *LLMInspector) processSSEStream(httpMsg *HTTPMessage, hostname string, r
 		startPos = val.(int)
 	}
 
-	// Skip if no new data
-	if startPos >= len(bodyBytes) {
+	// Only parse complete lines, the trailing partial line is left for the next chunk unless
+	// the response has ended. An event split across chunks is left by the provider as well.
+	end := len(bodyBytes)
+	if !complete {
+		end = bytes.LastIndexByte(bodyBytes, '\n') + 1
+	}
+
+	// Skip if no new complete lines
+	if startPos >= end {
 		return bodyBytes, nil
 	}
 
+	parseBody := bodyBytes[:end]
+	if complete {
+		// 流已结束，补一个空行让没有以空行结尾的最后一个事件也被解析
+		parseBody = append(bytes.Clone(parseBody), '\n', '\n')
+	}
+
 	// 从缓存中获取路径信息
 	path := ""
 	if val, exists := l.requestPaths.Load(requestID); exists {
@@ -164,18 +322,19 @@ func (l *LLMInspector) processSSEStream(httpMsg *HTTPMessage, hostname string, r
 	}
 
 	// Try to find a provider using the hostname from the connection and cached path
-	provider := llm.FindProviderWithMatcher(hostname, path, bodyBytes, l.logger, l.providerMatcher)
+	provider := l.providers.Find(hostname, path, bodyBytes)
 	if provider == nil {
 		return bodyBytes, nil
 	}
 
 	// Parse SSE stream tokens incrementally
-	deltas := provider.ParseSSEStreamFrom(bodyBytes, startPos)
+	deltas, consumed := provider.ParseSSEStreamFrom(parseBody, startPos)
+	consumed = min(consumed, end)
 
-	// Only update processed position if we got new deltas
-	// This allows retrying when previous data was truncated
+	// Only update processed position if we got new deltas, events without deltas
+	// are reparsed with the next chunk so their state reaches its deltas
 	if len(deltas) > 0 {
-		l.processedBytes.Store(requestID, len(bodyBytes))
+		l.processedBytes.Store(requestID, l.discardParsed(httpMsg, requestID, consumed))
 	}
 
 	// 从缓存中获取 conversationID（与请求时一致）
@@ -183,55 +342,73 @@ func (l *LLMInspector) processSSEStream(httpMsg *HTTPMessage, hostname string, r
 	if val, exists := l.conversationIDs.Load(requestID); exists {
 		conversationID = val.(string)
 	}
+	model := l.requestModel(requestID)
 
-	// 获取或初始化累积内容（支持多 chunk 响应）
-	accumulatedContent := ""
-	if val, exists := l.accumulatedContent.Load(requestID); exists {
-		accumulatedContent = val.(string)
-	}
-
-	// Accumulate tool calls for streaming completion
-	toolCallsByID := make(map[string]*llm.ToolCall)
-	var currentToolID string
+	// 按内容事件的到达速率检测限流导致的吐字变慢
+	l.trackTokenRate(requestID, conversationID, model, deltas)
+	l.trackFirstToken(requestID, conversationID, model, deltas)
 
 	for _, delta := range deltas {
-		// Accumulate content
-		accumulatedContent += delta.Text
-
-		// Accumulate tool calls
-		if delta.ToolName != "" && delta.ToolID != "" {
-			// New tool call started
-			toolCallsByID[delta.ToolID] = &llm.ToolCall{
-				ID:   delta.ToolID,
-				Type: "function",
-				Function: llm.FunctionCall{
-					Name:      delta.ToolName,
-					Arguments: "",
-				},
+		// input tokens 在流开始时先上报，之后与 output usage 合并
+		if delta.Usage.InputTokens > 0 {
+			if _, loaded := l.inputTokens.LoadOrStore(requestID, delta.Usage.InputTokens); !loaded {
+				l.publishConversationUpdate(conversationID, "streaming", 1, delta.Usage.TotalTokens(), model)
 			}
-			currentToolID = delta.ToolID
+		} else if val, exists := l.inputTokens.Load(requestID); exists {
+			delta.Usage.InputTokens = val.(int)
 		}
-		if delta.ToolData != "" {
-			// Append tool arguments data
-			toolID := delta.ToolID
-			if toolID == "" {
-				toolID = currentToolID
-			}
-			if toolID != "" {
-				if toolCall, exists := toolCallsByID[toolID]; exists {
-					toolCall.Function.Arguments += delta.ToolData
-				}
+		if delta == (llm.TokenDelta{Index: delta.Index, Usage: delta.Usage}) {
+			// usage-only delta，没有内容可发布
+			continue
+		}
+
+		// n>1 时每个 choice 单独累积，index 0 沿用 requestID
+		key := streamKey(requestID, delta.Index)
+
+		// 已结束的 choice 再收到终止信号（如 finish_reason 之后单独到达的 [DONE]）时忽略
+		if delta == (llm.TokenDelta{Index: delta.Index, Usage: delta.Usage, IsComplete: true}) {
+			if _, open := l.accumulatedContent.Load(key); !open {
+				continue
 			}
 		}
 
+		// 获取或初始化累积内容（支持多 chunk 响应）
+		accumulatedContent, seen := l.accumulatedContent.Load(key)
+		if !seen {
+			accumulatedContent = ""
+			l.openChoice(requestID, 1)
+			// 先发布空的 start 事件，客户端据此创建消息，后续事件按 ID 原地更新
+			l.publishEvent("llm_token", &llm.LLMTokenEvent{
+				ID:             key,
+				Type:           llm.TokenEventStart,
+				ConversationID: conversationID,
+			})
+		}
+		content := accumulatedContent.(string) + delta.Text
+
+		// Accumulate tool calls, kept across chunks until the choice completes
+		if delta.ToolName != "" && delta.ToolID != "" {
+			val, _ := l.toolCalls.LoadOrStore(key, &streamToolCalls{byID: make(map[string]*llm.ToolCall)})
+			val.(*streamToolCalls).start(delta.ToolID, delta.ToolName)
+		}
+		if val, exists := l.toolCalls.Load(key); exists {
+			val.(*streamToolCalls).add(delta)
+		}
+
+		eventType := llm.TokenEventDelta
+		if delta.IsComplete {
+			eventType = llm.TokenEventEnd
+		}
 		event := &llm.LLMTokenEvent{
-			ID:             requestID, // 复用同一个 ID
+			ID:             key, // 复用同一个 ID
+			Type:           eventType,
 			ConversationID: conversationID,
 			Delta:          delta.Text,
 			Thinking:       delta.Thinking,
 			ToolName:       delta.ToolName,
 			ToolID:         delta.ToolID,
 			ToolData:       delta.ToolData,
+			ToolResult:     delta.ToolResult,
 			IsComplete:     delta.IsComplete,
 			StopReason:     delta.StopReason,
 			TokenCount:     delta.Usage.InputTokens,
@@ -241,43 +418,382 @@ func (l *LLMInspector) processSSEStream(httpMsg *HTTPMessage, hostname string, r
 		l.publishEvent("llm_token", event)
 
 		if delta.IsComplete {
-			// Convert accumulated tool calls to slice
-			var toolCallsSlice []llm.ToolCall
-			if len(toolCallsByID) > 0 {
-				toolCallsSlice = make([]llm.ToolCall, 0, len(toolCallsByID))
-				for _, toolCall := range toolCallsByID {
-					toolCallsSlice = append(toolCallsSlice, *toolCall)
-				}
-			}
-
-			// Publish message event for streaming completion (使用相同 ID，前端会更新)
-			msgEvent := &llm.LLMMessageEvent{
-				ID:             requestID,
-				Timestamp:      time.Now(),
-				ConversationID: conversationID,
-				Message: llm.LLMMessage{
-					Role:      "assistant",
-					Content:   []string{accumulatedContent},
-					ToolCalls: toolCallsSlice,
-				},
-				TokenCount:  event.TokenCount,
-				TotalTokens: event.TotalTokens,
-			}
-			l.publishEvent("llm_message", msgEvent)
-
-			l.publishConversationUpdate(conversationID, "complete", 1, event.TotalTokens, "")
-
-			// 清理累积内容缓存
-			l.accumulatedContent.Delete(requestID)
+			l.completeChoice(requestID, key, conversationID, model, content, event.TokenCount, event.TotalTokens)
 		} else {
 			// 保存累积内容以便后续 chunk 使用
-			l.accumulatedContent.Store(requestID, accumulatedContent)
+			l.accumulatedContent.Store(key, content)
 		}
 	}
 
 	return bodyBytes, nil
 }
 
+// streamToolCalls accumulates the tool calls of a streamed choice, chunks of one stream are
+// inspected sequentially
+type streamToolCalls struct {
+	byID    map[string]*llm.ToolCall
+	order   []string // IDs in the order the calls started
+	current string   // Call receiving argument deltas that carry no ID
+}
+
+// start begins a new tool call
+func (s *streamToolCalls) start(id, name string) {
+	if _, exists := s.byID[id]; !exists {
+		s.order = append(s.order, id)
+	}
+	s.byID[id] = &llm.ToolCall{
+		ID:       id,
+		Type:     "function",
+		Function: llm.FunctionCall{Name: name},
+	}
+	s.current = id
+}
+
+// add appends the tool arguments and result of delta to its call
+func (s *streamToolCalls) add(delta llm.TokenDelta) {
+	if delta.ToolData != "" {
+		toolID := delta.ToolID
+		if toolID == "" {
+			toolID = s.current
+		}
+		if toolCall, exists := s.byID[toolID]; exists {
+			toolCall.Function.Arguments += delta.ToolData
+		}
+	}
+	if delta.ToolResult != "" {
+		if toolCall, exists := s.byID[delta.ToolID]; exists {
+			toolCall.Result = delta.ToolResult
+		}
+	}
+}
+
+// list returns the calls in the order they started with their arguments checked
+func (s *streamToolCalls) list() []llm.ToolCall {
+	toolCalls := make([]llm.ToolCall, 0, len(s.order))
+	for _, id := range s.order {
+		toolCall := s.byID[id]
+		// max_tokens 或流被截断时参数可能是不完整的 JSON
+		toolCall.CheckArguments()
+		toolCalls = append(toolCalls, *toolCall)
+	}
+	return toolCalls
+}
+
+// completeChoice publishes the final message of a streamed choice with its accumulated tool
+// calls and releases its state
+func (l *LLMInspector) completeChoice(requestID, key, conversationID, model, content string, tokenCount, totalTokens int) {
+	var toolCalls []llm.ToolCall
+	if val, exists := l.toolCalls.LoadAndDelete(key); exists {
+		toolCalls = val.(*streamToolCalls).list()
+	}
+
+	// Publish message event for streaming completion (使用相同 ID，前端会更新)
+	msgEvent := &llm.LLMMessageEvent{
+		ID:             key,
+		Timestamp:      time.Now(),
+		ConversationID: conversationID,
+		Message: llm.LLMMessage{
+			Role:      "assistant",
+			Content:   []string{content},
+			ToolCalls: toolCalls,
+		},
+		TokenCount:  tokenCount,
+		TotalTokens: totalTokens,
+		Model:       model,
+	}
+	l.publishEvent("llm_message", msgEvent)
+
+	update := l.newConversationUpdate(conversationID, "complete", 1, totalTokens, model)
+	if val, exists := l.timings.Load(requestID); exists {
+		inputTokens := 0
+		if v, ok := l.inputTokens.Load(requestID); ok {
+			inputTokens = v.(int)
+		}
+		val.(*requestTiming).complete(update, l.now(), totalTokens-inputTokens)
+	}
+	l.publishUpdate(update)
+
+	// 清理累积内容缓存，所有 choice 完成后再清理 model 缓存
+	l.accumulatedContent.Delete(key)
+	if l.openChoice(requestID, -1) == 0 {
+		l.models.Delete(requestID)
+		l.inputTokens.Delete(requestID)
+		l.dropTokenRate(requestID)
+		l.timings.Delete(requestID)
+	}
+}
+
+// requestTiming tracks when a streamed request started and produced its first token,
+// chunks of one stream are inspected sequentially
+type requestTiming struct {
+	start      time.Time
+	firstToken time.Time
+	deltas     int // content events received, the token count when the usage is unknown
+}
+
+// complete fills the timing breakdown of a finished stream into update
+func (t *requestTiming) complete(update *llm.ConversationUpdateEvent, now time.Time, outputTokens int) {
+	update.Duration = now.Sub(t.start).Milliseconds()
+	if t.firstToken.IsZero() {
+		return
+	}
+	update.TimeToFirstTokenMs = t.firstToken.Sub(t.start).Milliseconds()
+	if outputTokens <= 0 {
+		outputTokens = t.deltas
+	}
+	if streaming := now.Sub(t.firstToken); streaming > 0 {
+		update.TokensPerSecond = float64(outputTokens) / streaming.Seconds()
+	}
+}
+
+// trackFirstToken counts the content events of a chunk of requestID and publishes a conversation
+// update with the time to first token when the first one arrives
+func (l *LLMInspector) trackFirstToken(requestID, conversationID, model string, deltas []llm.TokenDelta) {
+	val, exists := l.timings.Load(requestID)
+	if !exists {
+		return
+	}
+	timing := val.(*requestTiming)
+	n := 0
+	for _, delta := range deltas {
+		n += delta.ContentEvents
+	}
+	if n == 0 {
+		return
+	}
+	timing.deltas += n
+	if !timing.firstToken.IsZero() {
+		return
+	}
+	timing.firstToken = l.now()
+
+	update := l.newConversationUpdate(conversationID, "streaming", 1, 0, model)
+	update.TimeToFirstTokenMs = timing.firstToken.Sub(timing.start).Milliseconds()
+	l.publishUpdate(update)
+}
+
+// tokenRate tracks when the content events of a stream arrived. Chunks of one stream are
+// inspected sequentially, the stall timer runs concurrently with them.
+type tokenRate struct {
+	mu             sync.Mutex
+	started        time.Time
+	arrivals       []time.Time // arrival of each content event within the last window
+	slow           bool
+	stall          *time.Timer // fires a window after the last content
+	stopped        bool
+	conversationID string
+	model          string
+	totalTokens    int
+}
+
+// rate records n content events arriving at now and returns the events per second over the
+// last window, ok is false until the stream has been running for a whole window
+func (r *tokenRate) rate(now time.Time, n int, window time.Duration) (rate float64, ok bool) {
+	for range n {
+		r.arrivals = append(r.arrivals, now)
+	}
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(r.arrivals) && !r.arrivals[i].After(cutoff) {
+		i++
+	}
+	r.arrivals = r.arrivals[i:]
+	if now.Sub(r.started) < window {
+		return 0, false
+	}
+	return float64(len(r.arrivals)) / window.Seconds(), true
+}
+
+// trackTokenRate records the content events of a chunk of requestID and publishes a conversation
+// update when the stream becomes slow or recovers
+func (l *LLMInspector) trackTokenRate(requestID, conversationID, model string, deltas []llm.TokenDelta) {
+	if l.slowTokenRate <= 0 {
+		return
+	}
+	n, totalTokens := 0, 0
+	for _, delta := range deltas {
+		n += delta.ContentEvents
+		if total := delta.Usage.TotalTokens(); total > totalTokens {
+			totalTokens = total
+		}
+	}
+	if n == 0 {
+		return
+	}
+
+	now := l.now()
+	val, _ := l.tokenRates.LoadOrStore(requestID, &tokenRate{started: now})
+	tracker := val.(*tokenRate)
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if tracker.stopped {
+		return
+	}
+	tracker.conversationID, tracker.model = conversationID, model
+	tracker.totalTokens = max(tracker.totalTokens, totalTokens)
+	// 完全停止输出时不会再有 chunk 触发检测，由定时器在一个窗口后补查
+	if tracker.stall == nil {
+		tracker.stall = time.AfterFunc(l.slowTokenWindow, func() { l.checkStall(tracker) })
+	} else {
+		tracker.stall.Reset(l.slowTokenWindow)
+	}
+	l.updateTokenRate(tracker, now, n)
+}
+
+// checkStall re-evaluates the rate of a stream no content arrived for during a whole window
+func (l *LLMInspector) checkStall(tracker *tokenRate) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if !tracker.stopped {
+		l.updateTokenRate(tracker, l.now(), 0)
+	}
+}
+
+// updateTokenRate records n content events arriving at now and publishes a conversation update
+// when the stream becomes slow or recovers, the caller holds tracker.mu
+func (l *LLMInspector) updateTokenRate(tracker *tokenRate, now time.Time, n int) {
+	rate, ok := tracker.rate(now, n, l.slowTokenWindow)
+	if !ok {
+		return
+	}
+	slow := rate < l.slowTokenRate
+	if slow == tracker.slow {
+		return
+	}
+	tracker.slow = slow
+	if slow {
+		l.logger.Warn("LLM stream slowed down, provider may be throttling",
+			"conversation_id", tracker.conversationID, "model", tracker.model, "token_rate", rate, "threshold", l.slowTokenRate)
+	}
+
+	if l.eventBus == nil {
+		return
+	}
+	l.publishEvent("conversation", &llm.ConversationUpdateEvent{
+		ID:             generateEventID(),
+		Timestamp:      now,
+		ConversationID: tracker.conversationID,
+		Status:         "streaming",
+		MessageCount:   1,
+		TotalTokens:    tracker.totalTokens,
+		Model:          tracker.model,
+		Slow:           slow,
+		TokenRate:      rate,
+	})
+}
+
+// dropTokenRate releases the rate tracking of requestID and stops its stall timer
+func (l *LLMInspector) dropTokenRate(requestID string) {
+	val, exists := l.tokenRates.LoadAndDelete(requestID)
+	if !exists {
+		return
+	}
+	tracker := val.(*tokenRate)
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	tracker.stopped = true
+	if tracker.stall != nil {
+		tracker.stall.Stop()
+	}
+}
+
+// Finalize completes the streams of a closed connection and drops their expiry, per-request
+// and pending state. Streams without a length or last chunk only end here
+func (l *LLMInspector) Finalize(connectionID string) {
+	l.lifetime.forget(connectionID)
+	for _, requestID := range l.connectionRequests(connectionID) {
+		// 连接关闭时仍未收到终止事件的 choice 在此收尾
+		l.finishOpenChoices(requestID)
+		l.releaseRequest(requestID)
+	}
+	l.httpProc.ClearConnection(connectionID)
+}
+
+// connectionRequests returns the requests of connectionID that still hold inspector state
+func (l *LLMInspector) connectionRequests(connectionID string) []string {
+	seen := make(map[string]bool)
+	var requestIDs []string
+	collect := func(key, _ any) bool {
+		requestID := key.(string)
+		if strings.HasPrefix(requestID, connectionID+"-") && !seen[requestID] {
+			seen[requestID] = true
+			requestIDs = append(requestIDs, requestID)
+		}
+		return true
+	}
+	for _, m := range []*sync.Map{&l.requestPaths, &l.conversationIDs, &l.models, &l.processedBytes,
+		&l.inputTokens, &l.openChoices, &l.auxiliary, &l.tokenRates, &l.timings} {
+		m.Range(collect)
+	}
+	return requestIDs
+}
+
+// expireStream completes an LLM stream open longer than the max stream duration and releases its state
+func (l *LLMInspector) expireStream(requestID string) {
+	l.logger.Warn("LLM stream exceeded max duration, passing through uninspected",
+		"request_id", requestID, "max_duration", l.lifetime.max)
+
+	if val, exists := l.openChoices.Load(requestID); exists && val.(int) > 0 {
+		l.finishOpenChoices(requestID)
+	} else if val, exists := l.conversationIDs.Load(requestID); exists {
+		// 尚未收到任何内容，仍需结束会话的 streaming 状态
+		l.publishConversationUpdate(val.(string), "complete", 0, 0, l.requestModel(requestID))
+	}
+	l.releaseRequest(requestID)
+}
+
+// releaseRequest drops all per-request state of requestID
+func (l *LLMInspector) releaseRequest(requestID string) {
+	l.requestPaths.Delete(requestID)
+	l.conversationIDs.Delete(requestID)
+	l.models.Delete(requestID)
+	l.processedBytes.Delete(requestID)
+	l.inputTokens.Delete(requestID)
+	l.openChoices.Delete(requestID)
+	for _, m := range []*sync.Map{&l.accumulatedContent, &l.toolCalls} {
+		m.Range(func(k, _ any) bool {
+			if key := k.(string); key == requestID || strings.HasPrefix(key, requestID+"#") {
+				m.Delete(key)
+			}
+			return true
+		})
+	}
+	l.auxiliary.Delete(requestID)
+	l.dropTokenRate(requestID)
+	l.timings.Delete(requestID)
+	l.httpProc.ClearPending(requestID)
+}
+
+// finishOpenChoices completes choices of a stream that ended without finish_reason, message_delta or [DONE]
+func (l *LLMInspector) finishOpenChoices(requestID string) {
+	var conversationID string
+	if val, exists := l.conversationIDs.Load(requestID); exists {
+		conversationID = val.(string)
+	}
+	var inputTokens int
+	if val, exists := l.inputTokens.Load(requestID); exists {
+		inputTokens = val.(int)
+	}
+	model := l.requestModel(requestID)
+
+	l.accumulatedContent.Range(func(k, v any) bool {
+		key := k.(string)
+		if key != requestID && !strings.HasPrefix(key, requestID+"#") {
+			return true
+		}
+		l.publishEvent("llm_token", &llm.LLMTokenEvent{
+			ID:             key,
+			Type:           llm.TokenEventEnd,
+			ConversationID: conversationID,
+			IsComplete:     true,
+			TokenCount:     inputTokens,
+			TotalTokens:    inputTokens,
+		})
+		l.completeChoice(requestID, key, conversationID, model, v.(string), inputTokens, inputTokens)
+		return true
+	})
+}
+
 // processCompleteResponse processes regular JSON responses
 func (l *LLMInspector) processCompleteResponse(httpMsg *HTTPMessage, hostname string, requestID string) {
 	bodyBytes := httpMsg.Body
@@ -295,22 +811,53 @@ func (l *LLMInspector) processCompleteResponse(httpMsg *HTTPMessage, hostname st
 
 	// 清理 processedBytes（对于 SSE 流）
 	l.processedBytes.Delete(requestID)
+	l.inputTokens.Delete(requestID)
+	l.timings.Delete(requestID)
 	// 清理累积内容缓存
 	l.accumulatedContent.Delete(requestID)
+	l.toolCalls.Delete(requestID)
 
 	// Try to find a provider using the hostname from the connection and cached path
-	provider := llm.FindProviderWithMatcher(hostname, path, bodyBytes, l.logger, l.providerMatcher)
+	provider := l.providers.Find(hostname, path, bodyBytes)
 	if provider == nil {
 		return
 	}
 
-	// 从缓存中获取 conversationID
+	if val, exists := l.auxiliary.LoadAndDelete(requestID); exists {
+		l.publishAuxiliary(provider, path, val.(string), requestID, bodyBytes)
+		return
+	}
+
+	// 从缓存中获取 conversationID 和 model
 	var conversationID string
 	if val, exists := l.conversationIDs.Load(requestID); exists {
 		conversationID = val.(string)
 	}
+	model := l.requestModel(requestID)
+	defer l.models.Delete(requestID)
 
 	resp, err := provider.ParseResponse(path, bodyBytes)
+
+	// 429 单独标记为限流，携带 Retry-After 秒数
+	if httpMsg.StatusCode == http.StatusTooManyRequests {
+		apiError := &llm.APIError{
+			Type:       "rate_limited",
+			Message:    "rate limited",
+			RetryAfter: parseRetryAfter(httpMsg.Headers["Retry-After"], time.Now()),
+		}
+		if err == nil && resp.Error != nil && resp.Error.Message != "" {
+			apiError.Message = resp.Error.Message
+		}
+		l.logger.Warn("LLM API rate limited",
+			"conversation_id", conversationID,
+			"retry_after", apiError.RetryAfter,
+		)
+		l.publishLLMError(conversationID, requestID, apiError)
+		l.publishConversationUpdate(conversationID, "error", 0, 0, model)
+		l.conversationIDs.Delete(requestID)
+		return
+	}
+
 	if err != nil {
 		l.logger.Debug("failed to parse LLM response", "error", err)
 		return
@@ -324,7 +871,7 @@ func (l *LLMInspector) processCompleteResponse(httpMsg *HTTPMessage, hostname st
 			"error_message", resp.Error.Message,
 		)
 		l.publishLLMError(conversationID, requestID, resp.Error)
-		l.publishConversationUpdate(conversationID, "error", 0, 0, "")
+		l.publishConversationUpdate(conversationID, "error", 0, 0, model)
 		// 清理缓存
 		l.conversationIDs.Delete(requestID)
 		return
@@ -345,12 +892,32 @@ func (l *LLMInspector) processCompleteResponse(httpMsg *HTTPMessage, hostname st
 		Message:        msg,
 		TokenCount:     resp.Usage.OutputTokens,
 		TotalTokens:    resp.Usage.TotalTokens(),
+		Model:          model,
 	}
 
 	l.publishEvent("llm_message", event)
 
+	// Additional choices (n>1) are published as separate assistant messages
+	messageCount := 1
+	if len(resp.Choices) > 1 {
+		messageCount = len(resp.Choices)
+		for _, choice := range resp.Choices[1:] {
+			l.publishEvent("llm_message", &llm.LLMMessageEvent{
+				ID:             generateEventID(),
+				Timestamp:      time.Now(),
+				ConversationID: conversationID,
+				Message: llm.LLMMessage{
+					Role:      "assistant",
+					Content:   []string{choice.Content},
+					ToolCalls: choice.ToolCalls,
+				},
+				Model: model,
+			})
+		}
+	}
+
 	// Publish completion update
-	l.publishConversationUpdate(conversationID, "complete", 1, event.TotalTokens, "")
+	l.publishConversationUpdate(conversationID, "complete", messageCount, event.TotalTokens, model)
 
 	l.logger.Debug("LLM response inspected",
 		"conversation_id", conversationID,
@@ -362,6 +929,80 @@ func (l *LLMInspector) processCompleteResponse(httpMsg *HTTPMessage, hostname st
 	l.conversationIDs.Delete(requestID)
 }
 
+// parseRetryAfter converts a Retry-After header (delay seconds or HTTP date) to seconds, 0 if absent or invalid
+func parseRetryAfter(value string, now time.Time) int {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(seconds, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(int(math.Ceil(at.Sub(now).Seconds())), 0)
+	}
+	return 0
+}
+
+// discardParsed lets the processor drop the parsed part of a stream so long streams stay
+// bounded, returning the processed position within what remains of the body
+func (l *LLMInspector) discardParsed(httpMsg *HTTPMessage, requestID string, end int) int {
+	proc, ok := l.httpProc.(*HTTPProcessor)
+	if !ok {
+		return end
+	}
+	n := end
+	if httpMsg.IsNDJSON {
+		// end counts converted bytes, every complete raw line has been converted
+		n = bytes.LastIndexByte(httpMsg.Body, '\n') + 1
+	}
+	if !proc.DiscardStream(requestID, n) {
+		return end
+	}
+	return 0
+}
+
+// ndjsonToSSE rewrites complete NDJSON objects as SSE events of one data line each
+func ndjsonToSSE(objects [][]byte) []byte {
+	var buf bytes.Buffer
+	for _, obj := range objects {
+		buf.WriteString("data: ")
+		buf.Write(obj)
+		buf.WriteString("\n\n")
+	}
+	return buf.Bytes()
+}
+
+// streamKey returns the cache and event ID of a streamed choice
+func streamKey(requestID string, index int) string {
+	if index == 0 {
+		return requestID
+	}
+	return fmt.Sprintf("%s#%d", requestID, index)
+}
+
+// openChoice adjusts the number of choices still streaming for a request and returns it
+func (l *LLMInspector) openChoice(requestID string, delta int) int {
+	count := delta
+	if val, exists := l.openChoices.Load(requestID); exists {
+		count += val.(int)
+	}
+	if count <= 0 {
+		l.openChoices.Delete(requestID)
+		return 0
+	}
+	l.openChoices.Store(requestID, count)
+	return count
+}
+
+// requestModel returns the model cached from the request, empty if unknown
+func (l *LLMInspector) requestModel(requestID string) string {
+	if val, exists := l.models.Load(requestID); exists {
+		return val.(string)
+	}
+	return ""
+}
+
 // publishLLMError publishes an LLM API error event and a message with error content
 func (l *LLMInspector) publishLLMError(conversationID, requestID string, apiError *llm.APIError) {
 	if l.eventBus == nil {
@@ -369,27 +1010,38 @@ func (l *LLMInspector) publishLLMError(conversationID, requestID string, apiErro
 	}
 
 	// Publish llm_error event
+	extra := map[string]any{
+		"conversation_id": conversationID,
+		"request_id":      requestID,
+		"error_type":      apiError.Type,
+		"error_message":   apiError.Message,
+	}
+	if apiError.Code != "" {
+		extra["error_code"] = apiError.Code
+	}
+	if apiError.RetryAfter > 0 {
+		extra["retry_after_seconds"] = apiError.RetryAfter
+	}
 	event := &TrafficEvent{
 		ID:        generateEventID(),
 		Timestamp: time.Now(),
 		Direction: "llm_error",
-		Extra: map[string]any{
-			"conversation_id": conversationID,
-			"request_id":      requestID,
-			"error_type":      apiError.Type,
-			"error_message":   apiError.Message,
-		},
+		Extra:     extra,
 	}
 	l.eventBus.Publish(event)
 
 	// Also publish an error message so it shows in the conversation
+	content := fmt.Sprintf("[Error: %s] %s", apiError.Type, apiError.Message)
+	if apiError.RetryAfter > 0 {
+		content += fmt.Sprintf(" (retry after %ds)", apiError.RetryAfter)
+	}
 	errorMsgEvent := &llm.LLMMessageEvent{
 		ID:             generateEventID(),
 		Timestamp:      time.Now(),
 		ConversationID: conversationID,
 		Message: llm.LLMMessage{
 			Role:    "assistant",
-			Content: []string{fmt.Sprintf("[Error: %s] %s", apiError.Type, apiError.Message)},
+			Content: []string{content},
 		},
 	}
 	l.publishEvent("llm_message", errorMsgEvent)
@@ -397,6 +1049,24 @@ func (l *LLMInspector) publishLLMError(conversationID, requestID string, apiErro
 	// 清理缓存
 	l.conversationIDs.Delete(requestID)
 	l.processedBytes.Delete(requestID)
+	l.inputTokens.Delete(requestID)
+	l.models.Delete(requestID)
+	l.timings.Delete(requestID)
+}
+
+// publishAuxiliary publishes a non-chat endpoint response without touching any conversation
+func (l *LLMInspector) publishAuxiliary(provider llm.Provider, path, endpoint, requestID string, body []byte) {
+	event := &llm.AuxiliaryRequestEvent{
+		ID:        generateEventID(),
+		Timestamp: time.Now(),
+		Endpoint:  endpoint,
+		Model:     l.requestModel(requestID),
+	}
+	l.models.Delete(requestID)
+	if resp, err := provider.ParseResponse(path, body); err == nil {
+		event.InputTokens = resp.Usage.InputTokens
+	}
+	l.publishEvent("llm_auxiliary", event)
 }
 
 // publishEvent publishes an event to the event bus
@@ -416,11 +1086,12 @@ func (l *LLMInspector) publishEvent(direction string, extra interface{}) {
 
 // publishConversationUpdate publishes a conversation status update
 func (l *LLMInspector) publishConversationUpdate(conversationID, status string, messageCount, totalTokens int, model string) {
-	if l.eventBus == nil {
-		return
-	}
+	l.publishUpdate(l.newConversationUpdate(conversationID, status, messageCount, totalTokens, model))
+}
 
-	event := &llm.ConversationUpdateEvent{
+// newConversationUpdate builds a conversation status update for callers adding more fields
+func (l *LLMInspector) newConversationUpdate(conversationID, status string, messageCount, totalTokens int, model string) *llm.ConversationUpdateEvent {
+	return &llm.ConversationUpdateEvent{
 		ID:             generateEventID(),
 		Timestamp:      time.Now(),
 		ConversationID: conversationID,
@@ -430,7 +1101,13 @@ func (l *LLMInspector) publishConversationUpdate(conversationID, status string,
 		Duration:       0,
 		Model:          model,
 	}
+}
 
+// publishUpdate publishes a conversation status update built by newConversationUpdate
+func (l *LLMInspector) publishUpdate(event *llm.ConversationUpdateEvent) {
+	if l.eventBus == nil {
+		return
+	}
 	l.publishEvent("conversation", event)
 }
 
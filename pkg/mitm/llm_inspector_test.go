@@ -1,8 +1,12 @@
 package mitm
 
 import (
+	"fmt"
 	"log/slog"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/monsterxx03/linko/pkg/mitm/llm"
 )
@@ -45,6 +49,19 @@ func (m *mockHTTPProcessor) ClearPending(requestID string) {
 	delete(m.pendingResps, requestID)
 }
 
+func (m *mockHTTPProcessor) ClearConnection(connectionID string) {
+	for requestID := range m.pendingReqs {
+		if strings.HasPrefix(requestID, connectionID+"-") {
+			delete(m.pendingReqs, requestID)
+		}
+	}
+	for requestID := range m.pendingResps {
+		if strings.HasPrefix(requestID, connectionID+"-") {
+			delete(m.pendingResps, requestID)
+		}
+	}
+}
+
 func (m *mockHTTPProcessor) GetPendingMessage(requestID string) (*HTTPMessage, bool) {
 	if msg, ok := m.pendingReqs[requestID]; ok {
 		return msg, true
@@ -82,11 +99,11 @@ func (m *mockProvider) ParseResponse(path string, body []byte) (*llm.LLMResponse
 	return m.resp, m.respErr
 }
 
-func (m *mockProvider) ParseSSEStreamFrom(body []byte, startPos int) []llm.TokenDelta {
-	return m.deltas
+func (m *mockProvider) ParseSSEStreamFrom(body []byte, startPos int) ([]llm.TokenDelta, int) {
+	return m.deltas, len(body)
 }
 
-func (m *mockProvider) ParseFullRequest(hostname string, headers map[string]string, body []byte) (*llm.RequestInfo, error) {
+func (m *mockProvider) ParseFullRequest(hostname, path string, headers map[string]string, body []byte) (*llm.RequestInfo, error) {
 	return m.reqInfo, m.reqInfoErr
 }
 
@@ -361,3 +378,1278 @@ func bytesEqual(a, b []byte) bool {
 	}
 	return true
 }
+
+func TestLLMInspector_StreamingCompletionCarriesModel(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewLLMInspector(logger, eventBus, "api.anthropic.com", nil)
+	requestID := "req-model"
+
+	mockProc := newMockHTTPProcessor(t)
+	mockProc.processRequestFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			Method:      "POST",
+			ContentType: "application/json",
+			Body:        []byte(`{"model":"claude-sonnet-4","stream":true,"messages":[{"role":"user","content":"hello"}]}`),
+		}, true, nil
+	}
+	mockProc.processResponseFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			StatusCode:  200,
+			ContentType: "text/event-stream",
+			Body: []byte(`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hi"}}
+data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 2}}
+data: {"type": "message_stop"}
+`),
+			IsSSE: true,
+		}, false, nil
+	}
+	inspector.httpProc = mockProc
+
+	inspector.Inspect(DirectionClientToServer, []byte("request"), "api.anthropic.com", "conn-1", requestID)
+	inspector.Inspect(DirectionServerToClient, []byte("response"), "api.anthropic.com", "conn-1", requestID)
+
+	var completedMsg, completedConv bool
+	timeout := time.After(time.Second)
+	for !completedMsg || !completedConv {
+		select {
+		case ev := <-sub.Channel:
+			switch extra := ev.Extra.(type) {
+			case *llm.LLMMessageEvent:
+				if extra.Model != "claude-sonnet-4" {
+					t.Errorf("Expected %s message to carry model claude-sonnet-4, got %q", extra.Message.Role, extra.Model)
+				}
+				if extra.Message.Role == "assistant" {
+					completedMsg = true
+				}
+			case *llm.ConversationUpdateEvent:
+				if extra.Model != "claude-sonnet-4" {
+					t.Errorf("Expected %s update to carry model claude-sonnet-4, got %q", extra.Status, extra.Model)
+				}
+				if extra.Status == "complete" {
+					completedConv = true
+				}
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for completion events (message=%v, conversation=%v)", completedMsg, completedConv)
+		}
+	}
+}
+
+func TestLLMInspector_CountTokensSkipsConversation(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewLLMInspector(logger, eventBus, "api.anthropic.com", nil)
+	requestID := "req-count"
+
+	mockProc := newMockHTTPProcessor(t)
+	mockProc.processRequestFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages/count_tokens",
+			Method:      "POST",
+			ContentType: "application/json",
+			Body:        []byte(`{"model":"claude-sonnet-4","messages":[{"role":"user","content":"hello"}]}`),
+		}, true, nil
+	}
+	mockProc.processResponseFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages/count_tokens",
+			StatusCode:  200,
+			ContentType: "application/json",
+			Body:        []byte(`{"input_tokens":42}`),
+		}, true, nil
+	}
+	inspector.httpProc = mockProc
+
+	inspector.Inspect(DirectionClientToServer, []byte("request"), "api.anthropic.com", "conn-1", requestID)
+	inspector.Inspect(DirectionServerToClient, []byte("response"), "api.anthropic.com", "conn-1", requestID)
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case ev := <-sub.Channel:
+			switch extra := ev.Extra.(type) {
+			case *llm.LLMMessageEvent:
+				t.Fatalf("Expected no conversation message for count_tokens, got %s message", extra.Message.Role)
+			case *llm.ConversationUpdateEvent:
+				t.Fatalf("Expected no conversation update for count_tokens, got %q", extra.Status)
+			case *llm.AuxiliaryRequestEvent:
+				if ev.Direction != "llm_auxiliary" {
+					t.Errorf("Expected llm_auxiliary direction, got %q", ev.Direction)
+				}
+				if extra.Endpoint != "count_tokens" || extra.Model != "claude-sonnet-4" || extra.InputTokens != 42 {
+					t.Errorf("Unexpected auxiliary event: %+v", extra)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for auxiliary event")
+		}
+	}
+}
+
+func TestLLMInspector_StreamEmitsSingleStartAndEnd(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewLLMInspector(logger, eventBus, "api.anthropic.com", nil)
+	requestID := "req-stream"
+
+	chunks := []string{
+		`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hel"}}
+`,
+		`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "lo"}}
+data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 2}}
+data: {"type": "message_stop"}
+`,
+	}
+	var body string
+
+	mockProc := newMockHTTPProcessor(t)
+	mockProc.processRequestFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			Method:      "POST",
+			ContentType: "application/json",
+			Body:        []byte(`{"model":"claude-sonnet-4","stream":true,"messages":[{"role":"user","content":"hello"}]}`),
+		}, true, nil
+	}
+	mockProc.processResponseFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		body += string(data)
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			StatusCode:  200,
+			ContentType: "text/event-stream",
+			Body:        []byte(body),
+			IsSSE:       true,
+		}, false, nil
+	}
+	inspector.httpProc = mockProc
+
+	inspector.Inspect(DirectionClientToServer, []byte("request"), "api.anthropic.com", "conn-1", requestID)
+	for _, chunk := range chunks {
+		inspector.Inspect(DirectionServerToClient, []byte(chunk), "api.anthropic.com", "conn-1", requestID)
+	}
+
+	counts := make(map[string]int)
+	var order []string
+	timeout := time.After(time.Second)
+	for counts[llm.TokenEventEnd] == 0 {
+		select {
+		case ev := <-sub.Channel:
+			if token, ok := ev.Extra.(*llm.LLMTokenEvent); ok {
+				counts[token.Type]++
+				order = append(order, token.Type)
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for end event, got %v", order)
+		}
+	}
+
+	if counts[llm.TokenEventStart] != 1 || counts[llm.TokenEventEnd] != 1 {
+		t.Errorf("Expected exactly one start and one end event, got %v", order)
+	}
+	if order[0] != llm.TokenEventStart {
+		t.Errorf("Expected stream to begin with %s, got %v", llm.TokenEventStart, order)
+	}
+	if counts[llm.TokenEventDelta] == 0 {
+		t.Errorf("Expected delta events between start and end, got %v", order)
+	}
+}
+
+func TestLLMInspector_StreamSplitMidEvent(t *testing.T) {
+	lines := `data: {"type": "message_start", "message": {"role": "assistant", "usage": {"input_tokens": 5}}}
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hel"}}
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "lo, "}}
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "world"}}
+data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 3}}
+data: {"type": "message_stop"}
+`
+	// The second delta spans two data lines, the read ends between them
+	multiLineHead := `data: {"type": "message_start", "message": {"role": "assistant", "usage": {"input_tokens": 5}}}
+
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hel"}}
+
+data: {"type": "content_block_delta", "index": 0,
+`
+	multiLine := multiLineHead + `data: "delta": {"type": "text_delta", "text": "lo, "}}
+
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "world"}}
+
+data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 3}}
+
+`
+
+	tests := []struct {
+		name   string
+		stream string
+		cuts   []int
+	}{
+		// Cut inside events so accumulated bodies overlap partial lines
+		{"partial lines", lines, []int{40, 150, 160, 230, 300, len(lines)}},
+		{"multi-line event", multiLine, []int{len(multiLineHead), len(multiLine)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testStreamSplit(t, tt.stream, tt.cuts)
+		})
+	}
+}
+
+// testStreamSplit feeds an Anthropic stream to the inspector in reads ending at cuts and
+// checks the deltas and final message add up to "Hello, world"
+func testStreamSplit(t *testing.T, stream string, cuts []int) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewLLMInspector(logger, eventBus, "api.anthropic.com", nil)
+	requestID := "req-split"
+	var body string
+
+	mockProc := newMockHTTPProcessor(t)
+	mockProc.processRequestFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			Method:      "POST",
+			ContentType: "application/json",
+			Body:        []byte(`{"model":"claude-sonnet-4","stream":true,"messages":[{"role":"user","content":"hello"}]}`),
+		}, true, nil
+	}
+	mockProc.processResponseFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		body += string(data)
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			StatusCode:  200,
+			ContentType: "text/event-stream",
+			Body:        []byte(body),
+			IsSSE:       true,
+		}, len(body) == len(stream), nil
+	}
+	inspector.httpProc = mockProc
+
+	inspector.Inspect(DirectionClientToServer, []byte("request"), "api.anthropic.com", "conn-1", requestID)
+	prev := 0
+	for _, cut := range cuts {
+		inspector.Inspect(DirectionServerToClient, []byte(stream[prev:cut]), "api.anthropic.com", "conn-1", requestID)
+		prev = cut
+	}
+
+	var deltas string
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case ev := <-sub.Channel:
+			switch extra := ev.Extra.(type) {
+			case *llm.LLMTokenEvent:
+				deltas += extra.Delta
+			case *llm.LLMMessageEvent:
+				if extra.Message.Role != "assistant" {
+					continue
+				}
+				if len(extra.Message.Content) != 1 || extra.Message.Content[0] != "Hello, world" {
+					t.Errorf("Expected final message %q, got %q", "Hello, world", extra.Message.Content)
+				}
+				if deltas != "Hello, world" {
+					t.Errorf("Expected token deltas to add up to %q, got %q", "Hello, world", deltas)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for final message, deltas so far %q", deltas)
+		}
+	}
+}
+
+func TestLLMInspector_LongStreamBoundedMemory(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	inspector := NewLLMInspector(logger, eventBus, "api.anthropic.com", nil)
+	proc := inspector.httpProc.(*HTTPProcessor)
+	requestID := "req-long"
+
+	reqBody := `{"model":"claude-sonnet-4","stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	request := fmt.Sprintf("POST /v1/messages HTTP/1.1\r\nHost: api.anthropic.com\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(reqBody), reqBody)
+	inspector.Inspect(DirectionClientToServer, []byte(request), "api.anthropic.com", "conn-1", requestID)
+
+	chunk := func(s string) []byte {
+		return []byte(fmt.Sprintf("%x\r\n%s\r\n", len(s), s))
+	}
+	inspector.Inspect(DirectionServerToClient, []byte("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nTransfer-Encoding: chunked\r\n\r\n"), "api.anthropic.com", "conn-1", requestID)
+
+	// Far more than the 1MB body limit, one delta per read, some events split across reads
+	event := `data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "` + strings.Repeat("x", 100) + `"}}` + "\n"
+	const events = 8000
+	var retained int
+	for i := range events {
+		if i%3 == 0 {
+			half := len(event) / 2
+			inspector.Inspect(DirectionServerToClient, chunk(event[:half]), "api.anthropic.com", "conn-1", requestID)
+			inspector.Inspect(DirectionServerToClient, chunk(event[half:]), "api.anthropic.com", "conn-1", requestID)
+		} else {
+			inspector.Inspect(DirectionServerToClient, chunk(event), "api.anthropic.com", "conn-1", requestID)
+		}
+		val, ok := proc.pendingResps.Load(requestID)
+		if !ok {
+			t.Fatal("Expected stream to stay pending")
+		}
+		retained = max(retained, cap(val.(*pendingHTTPResponse).window.body))
+	}
+	inspector.Inspect(DirectionServerToClient, chunk(`data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 1}}`+"\n"+`data: {"type": "message_stop"}`+"\n"), "api.anthropic.com", "conn-1", requestID)
+
+	if limit := 4 * len(event); retained > limit {
+		t.Errorf("Expected retained stream buffer to stay under %d bytes, grew to %d", limit, retained)
+	}
+
+	// The final message is replayed from history
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case ev := <-sub.Channel:
+			msg, ok := ev.Extra.(*llm.LLMMessageEvent)
+			if !ok || msg.Message.Role != "assistant" {
+				continue
+			}
+			if content := strings.Join(msg.Message.Content, ""); content != strings.Repeat("x", 100*events) {
+				t.Errorf("Expected %d parsed bytes of content, got %d", 100*events, len(content))
+			}
+			return
+		case <-timeout:
+			t.Fatal("Timed out waiting for final message")
+		}
+	}
+}
+
+func TestLLMInspector_RateLimitedResponse(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewLLMInspector(logger, eventBus, "api.anthropic.com", nil)
+	requestID := "req-429"
+
+	mockProc := newMockHTTPProcessor(t)
+	mockProc.processRequestFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			Method:      "POST",
+			ContentType: "application/json",
+			Body:        []byte(`{"model":"claude-sonnet-4","messages":[{"role":"user","content":"hello"}]}`),
+		}, true, nil
+	}
+	mockProc.processResponseFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			StatusCode:  429,
+			Headers:     map[string]string{"Retry-After": "30"},
+			ContentType: "application/json",
+			Body:        []byte(`{"type":"error","error":{"type":"rate_limit_error","message":"Number of requests has exceeded your rate limit"}}`),
+		}, true, nil
+	}
+	inspector.httpProc = mockProc
+
+	inspector.Inspect(DirectionClientToServer, []byte("request"), "api.anthropic.com", "conn-1", requestID)
+	inspector.Inspect(DirectionServerToClient, []byte("response"), "api.anthropic.com", "conn-1", requestID)
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case ev := <-sub.Channel:
+			if ev.Direction != "llm_error" {
+				continue
+			}
+			extra := ev.Extra.(map[string]any)
+			if extra["error_type"] != "rate_limited" {
+				t.Errorf("Expected error_type rate_limited, got %v", extra["error_type"])
+			}
+			if extra["retry_after_seconds"] != 30 {
+				t.Errorf("Expected retry_after_seconds 30, got %v", extra["retry_after_seconds"])
+			}
+			if extra["error_message"] != "Number of requests has exceeded your rate limit" {
+				t.Errorf("Expected provider error message, got %v", extra["error_message"])
+			}
+			return
+		case <-timeout:
+			t.Fatal("Timed out waiting for llm_error event")
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[string]int{
+		"":                              0,
+		"120":                           120,
+		"-5":                            0,
+		"soon":                          0,
+		"Wed, 01 Jan 2025 00:00:45 GMT": 45,
+		"Tue, 31 Dec 2024 23:59:00 GMT": 0,
+	}
+	for value, want := range tests {
+		if got := parseRetryAfter(value, now); got != want {
+			t.Errorf("parseRetryAfter(%q) = %d, want %d", value, got, want)
+		}
+	}
+}
+
+func TestLLMInspector_MessageEventToolSummary(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewLLMInspector(logger, eventBus, "api.anthropic.com", nil)
+
+	body := `{"model":"claude-sonnet-4","messages":[{"role":"user","content":"weather in Paris?"}],"tools":[` +
+		`{"name":"get_weather","description":"Get weather","input_schema":{"type":"object","properties":{"unit":{"type":"string"},"city":{"type":"string"}},"required":["city"]}},` +
+		`{"name":"search","input_schema":{"type":"object","properties":{"query":{"type":"string"}}}}]}`
+	mockProc := newMockHTTPProcessor(t)
+	mockProc.processRequestFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			Method:      "POST",
+			ContentType: "application/json",
+			Body:        []byte(body),
+		}, true, nil
+	}
+	inspector.httpProc = mockProc
+
+	inspector.Inspect(DirectionClientToServer, []byte("request"), "api.anthropic.com", "conn-1", "req-tools")
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case ev := <-sub.Channel:
+			msgEvent, ok := ev.Extra.(*llm.LLMMessageEvent)
+			if !ok {
+				continue
+			}
+			summary := msgEvent.Tools
+			if summary == nil {
+				t.Fatal("Expected tool summary on message event")
+			}
+			if summary.Count != 2 {
+				t.Errorf("Expected 2 tools, got %d", summary.Count)
+			}
+			if len(summary.Names) != 2 || summary.Names[0] != "get_weather" || summary.Names[1] != "search" {
+				t.Errorf("Unexpected tool names: %v", summary.Names)
+			}
+			weather := summary.Tools[0]
+			if len(weather.Params) != 2 || weather.Params[0] != "city" || weather.Params[1] != "unit" {
+				t.Errorf("Expected sorted params [city unit], got %v", weather.Params)
+			}
+			if len(weather.Required) != 1 || weather.Required[0] != "city" {
+				t.Errorf("Expected required [city], got %v", weather.Required)
+			}
+			if len(summary.Tools[1].Required) != 0 {
+				t.Errorf("Expected no required params for search, got %v", summary.Tools[1].Required)
+			}
+			return
+		case <-timeout:
+			t.Fatal("Timed out waiting for message event")
+		}
+	}
+}
+
+func TestLLMInspector_StreamReportsInputTokensEarly(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewLLMInspector(logger, eventBus, "api.anthropic.com", nil)
+	requestID := "req-usage"
+
+	chunks := []string{
+		`data: {"type": "message_start", "message": {"role": "assistant", "usage": {"input_tokens": 25, "output_tokens": 1}}}
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hello"}}
+`,
+		`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": " there"}}
+data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 12}}
+data: {"type": "message_stop"}
+`,
+	}
+	var body string
+
+	mockProc := newMockHTTPProcessor(t)
+	mockProc.processRequestFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			Method:      "POST",
+			ContentType: "application/json",
+			Body:        []byte(`{"model":"claude-sonnet-4","stream":true,"messages":[{"role":"user","content":"hello"}]}`),
+		}, true, nil
+	}
+	mockProc.processResponseFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		body += string(data)
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			StatusCode:  200,
+			ContentType: "text/event-stream",
+			Body:        []byte(body),
+			IsSSE:       true,
+		}, len(body) == len(chunks[0])+len(chunks[1]), nil
+	}
+	inspector.httpProc = mockProc
+
+	inspector.Inspect(DirectionClientToServer, []byte("request"), "api.anthropic.com", "conn-1", requestID)
+	inspector.Inspect(DirectionServerToClient, []byte(chunks[0]), "api.anthropic.com", "conn-1", requestID)
+
+	// Input tokens are reported while the stream is still running
+	var early bool
+	timeout := time.After(time.Second)
+	for !early {
+		select {
+		case ev := <-sub.Channel:
+			if update, ok := ev.Extra.(*llm.ConversationUpdateEvent); ok && update.Status == "streaming" && update.TotalTokens == 25 {
+				early = true
+			}
+			if tokenEvent, ok := ev.Extra.(*llm.LLMTokenEvent); ok && tokenEvent.Type == llm.TokenEventDelta && tokenEvent.Delta == "" {
+				t.Errorf("Expected no empty delta for usage-only event, got %+v", tokenEvent)
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for streaming update with input tokens")
+		}
+	}
+
+	inspector.Inspect(DirectionServerToClient, []byte(chunks[1]), "api.anthropic.com", "conn-1", requestID)
+
+	timeout = time.After(time.Second)
+	for {
+		select {
+		case ev := <-sub.Channel:
+			update, ok := ev.Extra.(*llm.ConversationUpdateEvent)
+			if !ok || update.Status != "complete" {
+				continue
+			}
+			if update.TotalTokens != 37 {
+				t.Errorf("Expected merged total of 37 tokens, got %d", update.TotalTokens)
+			}
+			return
+		case <-timeout:
+			t.Fatal("Timed out waiting for complete update")
+		}
+	}
+}
+
+func TestLLMInspector_StreamToolArgumentsComplete(t *testing.T) {
+	webSearchResult := `data: {"type": "content_block_start", "index": 1, "content_block": {"type": "web_search_tool_result", "tool_use_id": "toolu_1", "content": [{"type": "web_search_result", "title": "Go", "url": "https://go.dev"}]}}
+`
+	tests := []struct {
+		name         string
+		partialJSON  []string
+		extra        string // Events after the arguments
+		stopReason   string // Empty = the connection closes before the stream ends
+		wantComplete bool
+		wantArgs     string
+		wantResult   string
+	}{
+		{"complete", []string{`{\"path\": `, `\"/tmp/a\"}`}, "", "tool_use", true, `{"path": "/tmp/a"}`, ""},
+		{"truncated", []string{`{\"path\": `, `\"/tmp/a`}, "", "max_tokens", false, `{"path": "/tmp/a"}`, ""},
+		{"connection closed", []string{`{\"path\": `, `\"/tmp/a`}, "", "", false, `{"path": "/tmp/a"}`, ""},
+		{"result in later chunk", []string{`{\"query\": \"go\"}`}, webSearchResult, "end_turn", true, `{"query": "go"}`, "1 result: Go (https://go.dev)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.Default()
+			eventBus := NewEventBus(logger, 10)
+			sub := eventBus.Subscribe()
+			defer eventBus.Unsubscribe(sub)
+			inspector := NewLLMInspector(logger, eventBus, "api.anthropic.com", nil)
+			requestID := "conn-1-1"
+
+			// Every event arrives in a read of its own
+			events := []string{`data: {"type": "content_block_start", "index": 0, "content_block": {"type": "tool_use", "id": "toolu_1", "name": "read_file"}}
+`}
+			for _, part := range tt.partialJSON {
+				events = append(events, `data: {"type": "content_block_delta", "index": 0, "delta": {"type": "input_json_delta", "partial_json": "`+part+`"}}
+`)
+			}
+			if tt.extra != "" {
+				events = append(events, tt.extra)
+			}
+			if tt.stopReason != "" {
+				events = append(events, `data: {"type": "message_delta", "delta": {"stop_reason": "`+tt.stopReason+`"}, "usage": {"output_tokens": 5}}
+data: {"type": "message_stop"}
+`)
+			}
+			var body string
+
+			mockProc := newMockHTTPProcessor(t)
+			mockProc.processRequestFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+				return data, &HTTPMessage{
+					Hostname:    "api.anthropic.com",
+					Path:        "/v1/messages",
+					Method:      "POST",
+					ContentType: "application/json",
+					Body:        []byte(`{"model":"claude-sonnet-4","stream":true,"messages":[{"role":"user","content":"hello"}]}`),
+				}, true, nil
+			}
+			mockProc.processResponseFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+				body += string(data)
+				return data, &HTTPMessage{
+					Hostname:    "api.anthropic.com",
+					Path:        "/v1/messages",
+					StatusCode:  200,
+					ContentType: "text/event-stream",
+					Body:        []byte(body),
+					IsSSE:       true,
+				}, false, nil
+			}
+			inspector.httpProc = mockProc
+
+			inspector.Inspect(DirectionClientToServer, []byte("request"), "api.anthropic.com", "conn-1", requestID)
+			for _, event := range events {
+				inspector.Inspect(DirectionServerToClient, []byte(event), "api.anthropic.com", "conn-1", requestID)
+			}
+			if tt.stopReason == "" {
+				inspector.Finalize("conn-1")
+			}
+
+			timeout := time.After(time.Second)
+			for {
+				select {
+				case ev := <-sub.Channel:
+					msg, ok := ev.Extra.(*llm.LLMMessageEvent)
+					if !ok || msg.Message.Role != "assistant" {
+						continue
+					}
+					if len(msg.Message.ToolCalls) != 1 {
+						t.Fatalf("Expected 1 tool call, got %d", len(msg.Message.ToolCalls))
+					}
+					toolCall := msg.Message.ToolCalls[0]
+					if toolCall.ArgumentsComplete != tt.wantComplete {
+						t.Errorf("ArgumentsComplete = %v, want %v", toolCall.ArgumentsComplete, tt.wantComplete)
+					}
+					if toolCall.Function.Arguments != tt.wantArgs {
+						t.Errorf("Arguments = %q, want %q", toolCall.Function.Arguments, tt.wantArgs)
+					}
+					if toolCall.Result != tt.wantResult {
+						t.Errorf("Result = %q, want %q", toolCall.Result, tt.wantResult)
+					}
+					if _, exists := inspector.toolCalls.Load(requestID); exists {
+						t.Error("Expected tool call state to be released")
+					}
+					return
+				case <-timeout:
+					t.Fatal("Timed out waiting for assistant message")
+				}
+			}
+		})
+	}
+}
+
+func TestLLMInspector_StreamTerminalSignals(t *testing.T) {
+	openaiChunk := func(delta, finish string) string {
+		return `data: {"id":"c1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":` + delta + `,"finish_reason":` + finish + `}]}
+`
+	}
+	tests := []struct {
+		name     string
+		hostname string
+		path     string
+		chunks   []string
+		wantText string
+	}{
+		{
+			name:     "finish_reason then [DONE]",
+			hostname: "api.openai.com",
+			path:     "/v1/chat/completions",
+			chunks: []string{
+				openaiChunk(`{"content":"Hello"}`, "null"),
+				openaiChunk(`{}`, `"stop"`),
+				"data: [DONE]\n",
+			},
+			wantText: "Hello",
+		},
+		{
+			name:     "[DONE] only",
+			hostname: "api.openai.com",
+			path:     "/v1/chat/completions",
+			chunks: []string{
+				openaiChunk(`{"content":"Hello"}`, "null"),
+				"data: [DONE]\n",
+			},
+			wantText: "Hello",
+		},
+		{
+			name:     "message_delta",
+			hostname: "api.anthropic.com",
+			path:     "/v1/messages",
+			chunks: []string{
+				`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hello"}}
+`,
+				`data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 2}}
+data: {"type": "message_stop"}
+`,
+				"data: [DONE]\n",
+			},
+			wantText: "Hello",
+		},
+		{
+			name:     "neither",
+			hostname: "api.anthropic.com",
+			path:     "/v1/messages",
+			chunks: []string{
+				`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hel"}}
+`,
+				`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "lo"}}
+`,
+			},
+			wantText: "Hello",
+		},
+		{
+			// The last event spans several data lines and the stream ends without a blank line
+			name:     "multi-line event at end",
+			hostname: "api.anthropic.com",
+			path:     "/v1/messages",
+			chunks: []string{
+				`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hel"}}
+`,
+				`data: {"type": "content_block_delta", "index": 0,
+data: "delta": {"type": "text_delta", "text": "lo"}}
+`,
+			},
+			wantText: "Hello",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.Default()
+			eventBus := NewEventBus(logger, 10)
+			sub := eventBus.Subscribe()
+			defer eventBus.Unsubscribe(sub)
+			inspector := NewLLMInspector(logger, eventBus, tt.hostname, nil)
+			requestID := "req-terminal"
+
+			var body string
+			sent := 0
+			mockProc := newMockHTTPProcessor(t)
+			mockProc.processRequestFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+				return data, &HTTPMessage{
+					Hostname:    tt.hostname,
+					Path:        tt.path,
+					Method:      "POST",
+					ContentType: "application/json",
+					Body:        []byte(`{"model":"test-model","stream":true,"messages":[{"role":"user","content":"hello"}]}`),
+				}, true, nil
+			}
+			mockProc.processResponseFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+				body += string(data)
+				sent++
+				return data, &HTTPMessage{
+					Hostname:    tt.hostname,
+					Path:        tt.path,
+					StatusCode:  200,
+					ContentType: "text/event-stream",
+					Body:        []byte(body),
+					IsSSE:       true,
+				}, sent == len(tt.chunks), nil
+			}
+			inspector.httpProc = mockProc
+
+			inspector.Inspect(DirectionClientToServer, []byte("request"), tt.hostname, "conn-1", requestID)
+			for _, chunk := range tt.chunks {
+				inspector.Inspect(DirectionServerToClient, []byte(chunk), tt.hostname, "conn-1", requestID)
+			}
+
+			// Events are published synchronously, drain what is buffered
+			var ends, messages int
+			var text string
+			for drained := false; !drained; {
+				select {
+				case ev := <-sub.Channel:
+					switch extra := ev.Extra.(type) {
+					case *llm.LLMTokenEvent:
+						if extra.Type == llm.TokenEventEnd {
+							ends++
+						}
+					case *llm.LLMMessageEvent:
+						if extra.Message.Role == "assistant" {
+							messages++
+							text = extra.Message.Content[0]
+						}
+					}
+				case <-time.After(100 * time.Millisecond):
+					drained = true
+				}
+			}
+
+			if ends != 1 || messages != 1 {
+				t.Errorf("Expected exactly one end event and one assistant message, got %d and %d", ends, messages)
+			}
+			if text != tt.wantText {
+				t.Errorf("Expected message %q, got %q", tt.wantText, text)
+			}
+		})
+	}
+}
+
+func TestLLMInspector_SlowTokenRate(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.SubscribeWithName("test", 200)
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewLLMInspector(logger, eventBus, "api.anthropic.com", nil)
+	inspector.SetSlowTokenRate(5, time.Second)
+	now := time.Unix(1700000000, 0)
+	inspector.now = func() time.Time { return now }
+	requestID := "req-slow"
+
+	var body string
+	mockProc := newMockHTTPProcessor(t)
+	mockProc.processRequestFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			Method:      "POST",
+			ContentType: "application/json",
+			Body:        []byte(`{"model":"claude-sonnet-4","stream":true,"messages":[{"role":"user","content":"hello"}]}`),
+		}, true, nil
+	}
+	mockProc.processResponseFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		body += string(data)
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			StatusCode:  200,
+			ContentType: "text/event-stream",
+			Body:        []byte(body),
+			IsSSE:       true,
+		}, false, nil
+	}
+	inspector.httpProc = mockProc
+
+	inspector.Inspect(DirectionClientToServer, []byte("request"), "api.anthropic.com", "conn-1", requestID)
+	token := `data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "x"}}
+`
+	// Events of one read merge into a single delta, the rate counts the events
+	feed := func(count, events int, gap time.Duration) {
+		for range count {
+			now = now.Add(gap)
+			inspector.Inspect(DirectionServerToClient, []byte(strings.Repeat(token, events)), "api.anthropic.com", "conn-1", requestID)
+		}
+	}
+
+	feed(10, 4, 400*time.Millisecond) // 10 events/s in 2.5 reads/s
+	feed(3, 1, time.Second)           // 1 event/s, throttled
+	feed(20, 2, 100*time.Millisecond) // 20 events/s, recovered
+	inspector.Inspect(DirectionServerToClient, []byte(`data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 43}}
+data: {"type": "message_stop"}
+`), "api.anthropic.com", "conn-1", requestID)
+
+	var changes []*llm.ConversationUpdateEvent
+	timeout := time.After(time.Second)
+	for done := false; !done; {
+		select {
+		case ev := <-sub.Channel:
+			update, ok := ev.Extra.(*llm.ConversationUpdateEvent)
+			if !ok {
+				continue
+			}
+			if update.TokenRate > 0 {
+				changes = append(changes, update)
+			}
+			done = update.Status == "complete"
+		case <-timeout:
+			t.Fatal("Timed out waiting for complete update")
+		}
+	}
+
+	if len(changes) != 2 {
+		t.Fatalf("Expected a slow and a recovered update, got %d", len(changes))
+	}
+	if !changes[0].Slow || changes[0].TokenRate >= 5 || changes[0].Status != "streaming" {
+		t.Errorf("Expected slow streaming update below 5/s, got %+v", changes[0])
+	}
+	if changes[1].Slow || changes[1].TokenRate < 5 {
+		t.Errorf("Expected recovered update at or above 5/s, got %+v", changes[1])
+	}
+	if _, exists := inspector.tokenRates.Load(requestID); exists {
+		t.Error("Expected token rate state to be released when the stream completes")
+	}
+}
+
+func TestLLMInspector_SlowTokenRateStall(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.SubscribeWithName("test", 200)
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewLLMInspector(logger, eventBus, "api.anthropic.com", nil)
+	inspector.SetSlowTokenRate(5, 50*time.Millisecond)
+	requestID := "conn-stall-1"
+
+	mockProc := newMockHTTPProcessor(t)
+	mockProc.processRequestFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			Method:      "POST",
+			ContentType: "application/json",
+			Body:        []byte(`{"model":"claude-sonnet-4","stream":true,"messages":[{"role":"user","content":"hello"}]}`),
+		}, true, nil
+	}
+	mockProc.processResponseFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			StatusCode:  200,
+			ContentType: "text/event-stream",
+			Body:        data,
+			IsSSE:       true,
+		}, false, nil
+	}
+	inspector.httpProc = mockProc
+
+	inspector.Inspect(DirectionClientToServer, []byte("request"), "api.anthropic.com", "conn-stall", requestID)
+	token := `data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "x"}}
+`
+	inspector.Inspect(DirectionServerToClient, []byte(strings.Repeat(token, 10)), "api.anthropic.com", "conn-stall", requestID)
+
+	// No more data arrives, the stall is reported without another chunk
+	timeout := time.After(time.Second)
+	for slow := false; !slow; {
+		select {
+		case ev := <-sub.Channel:
+			update, ok := ev.Extra.(*llm.ConversationUpdateEvent)
+			if ok && update.Slow {
+				if update.TokenRate != 0 || update.Model != "claude-sonnet-4" {
+					t.Errorf("Expected a stalled update at 0/s for the request model, got %+v", update)
+				}
+				slow = true
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for the stalled stream to be flagged slow")
+		}
+	}
+
+	inspector.Finalize("conn-stall")
+	if _, exists := inspector.tokenRates.Load(requestID); exists {
+		t.Error("Expected token rate state to be released on Finalize")
+	}
+}
+
+func TestLLMInspector_MaxStreamDuration(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.SubscribeWithName("test", 1000)
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewLLMInspector(logger, eventBus, "api.anthropic.com", nil)
+	inspector.SetMaxStreamDuration(50 * time.Millisecond)
+	requestID := "conn-endless-1"
+
+	reqBody := `{"model":"claude-sonnet-4","stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	request := fmt.Sprintf("POST /v1/messages HTTP/1.1\r\nHost: api.anthropic.com\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(reqBody), reqBody)
+	inspector.Inspect(DirectionClientToServer, []byte(request), "api.anthropic.com", "conn-endless", requestID)
+	inspector.Inspect(DirectionServerToClient, []byte("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\n\r\n"), "api.anthropic.com", "conn-endless", requestID)
+
+	// The model never stops generating
+	token := []byte(`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "x"}}` + "\n")
+	stop := make(chan struct{})
+	fed := make(chan struct{})
+	go func() {
+		defer close(fed)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+				inspector.Inspect(DirectionServerToClient, token, "api.anthropic.com", "conn-endless", requestID)
+			}
+		}
+	}()
+
+	var message *llm.LLMMessageEvent
+	timeout := time.After(2 * time.Second)
+	for done := false; !done; {
+		select {
+		case ev := <-sub.Channel:
+			switch extra := ev.Extra.(type) {
+			case *llm.LLMMessageEvent:
+				if extra.Message.Role == "assistant" {
+					message = extra
+				}
+			case *llm.ConversationUpdateEvent:
+				done = extra.Status == "complete"
+			}
+		case <-timeout:
+			close(stop)
+			t.Fatal("Expected the stream to complete after the max duration")
+		}
+	}
+	close(stop)
+	<-fed
+
+	if message == nil || message.Model != "claude-sonnet-4" || !strings.HasPrefix(strings.Join(message.Message.Content, ""), "x") {
+		t.Errorf("Expected the streamed content as the final message, got %+v", message)
+	}
+	for name, state := range map[string]*sync.Map{
+		"conversation ID": &inspector.conversationIDs,
+		"model":           &inspector.models,
+		"processed bytes": &inspector.processedBytes,
+		"content":         &inspector.accumulatedContent,
+	} {
+		if _, exists := state.Load(requestID); exists {
+			t.Errorf("Expected %s state to be released", name)
+		}
+	}
+
+	// Later deltas pass through without reopening the stream
+	inspector.Inspect(DirectionServerToClient, token, "api.anthropic.com", "conn-endless", requestID)
+	if _, exists := inspector.accumulatedContent.Load(requestID); exists {
+		t.Error("Expected no content to be accumulated after expiry")
+	}
+}
+
+// grpcWebFrame frames payload as a gRPC-Web message, flag 0x80 marks the trailer frame
+func grpcWebFrame(flag byte, payload string) string {
+	return string([]byte{flag, 0, 0, 0, byte(len(payload))}) + payload
+}
+
+func TestLLMInspector_GRPCWebErrorStatus(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.SubscribeWithName("test", 100)
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewLLMInspector(logger, eventBus, "", nil)
+	inspector.SetGRPCHosts([]string{"*.Gateway.example.com"})
+
+	body := grpcWebFrame(0x80, "grpc-status:8\r\ngrpc-message:quota%20exceeded for model\r\n")
+	respond := func(hostname, requestID string) {
+		request := grpcWebFrame(0, "\x0a\x05hello")
+		inspector.Inspect(DirectionClientToServer, []byte(fmt.Sprintf("POST /llm.v1.Chat/Generate HTTP/1.1\r\nHost: %s\r\nContent-Type: application/grpc-web+proto\r\nContent-Length: %d\r\n\r\n%s", hostname, len(request), request)), hostname, "conn-1", requestID)
+		inspector.Inspect(DirectionServerToClient, []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/grpc-web+proto\r\nContent-Length: %d\r\n\r\n%s", len(body), body)), hostname, "conn-1", requestID)
+	}
+
+	// Hosts not configured as gRPC are left alone
+	respond("api.example.com", "conn-1-1")
+	respond("llm.gateway.example.com", "conn-1-2")
+
+	var errorEvent *TrafficEvent
+	var message *llm.LLMMessageEvent
+	timeout := time.After(time.Second)
+	for errorEvent == nil || message == nil {
+		select {
+		case ev := <-sub.Channel:
+			if ev.Direction == "llm_error" {
+				errorEvent = ev
+			} else if msg, ok := ev.Extra.(*llm.LLMMessageEvent); ok {
+				message = msg
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for the gRPC error events")
+		}
+	}
+
+	extra := errorEvent.Extra.(map[string]any)
+	if extra["request_id"] != "conn-1-2" {
+		t.Errorf("Expected the error of the gRPC host, got request %v", extra["request_id"])
+	}
+	if extra["error_type"] != "RESOURCE_EXHAUSTED" || extra["error_code"] != "8" {
+		t.Errorf("Expected RESOURCE_EXHAUSTED (8), got %v (%v)", extra["error_type"], extra["error_code"])
+	}
+	if extra["error_message"] != "quota exceeded for model" {
+		t.Errorf("Expected the decoded grpc-message, got %q", extra["error_message"])
+	}
+	if content := strings.Join(message.Message.Content, ""); content != "[Error: RESOURCE_EXHAUSTED] quota exceeded for model" {
+		t.Errorf("Expected the error in the conversation, got %q", content)
+	}
+}
+
+func TestLLMInspector_StreamTimingBreakdown(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.SubscribeWithName("test", 100)
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewLLMInspector(logger, eventBus, "api.anthropic.com", nil)
+	start := time.Unix(1700000000, 0)
+	now := start
+	inspector.now = func() time.Time { return now }
+	requestID := "req-timing"
+
+	var body string
+	mockProc := newMockHTTPProcessor(t)
+	mockProc.processRequestFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			Method:      "POST",
+			ContentType: "application/json",
+			Body:        []byte(`{"model":"claude-sonnet-4","stream":true,"messages":[{"role":"user","content":"hello"}]}`),
+		}, true, nil
+	}
+	mockProc.processResponseFunc = func(data []byte, rid string) ([]byte, *HTTPMessage, bool, error) {
+		body += string(data)
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			StatusCode:  200,
+			ContentType: "text/event-stream",
+			Body:        []byte(body),
+			IsSSE:       true,
+		}, false, nil
+	}
+	inspector.httpProc = mockProc
+
+	feed := func(at time.Duration, data string) {
+		now = start.Add(at)
+		inspector.Inspect(DirectionServerToClient, []byte(data), "api.anthropic.com", "conn-1", requestID)
+	}
+	inspector.Inspect(DirectionClientToServer, []byte("request"), "api.anthropic.com", "conn-1", requestID)
+	// Usage before the first content delta does not count as the first token
+	feed(200*time.Millisecond, `data: {"type": "message_start", "message": {"usage": {"input_tokens": 10, "output_tokens": 1}}}
+`)
+	feed(400*time.Millisecond, `data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hello"}}
+`)
+	feed(900*time.Millisecond, `data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": " world"}}
+`)
+	feed(2400*time.Millisecond, `data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 50}}
+data: {"type": "message_stop"}
+`)
+
+	var first, complete *llm.ConversationUpdateEvent
+	timeout := time.After(time.Second)
+	for complete == nil {
+		select {
+		case ev := <-sub.Channel:
+			update, ok := ev.Extra.(*llm.ConversationUpdateEvent)
+			if !ok {
+				continue
+			}
+			if update.Status == "complete" {
+				complete = update
+			} else if first == nil && update.TimeToFirstTokenMs > 0 {
+				first = update
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for complete update")
+		}
+	}
+
+	if first == nil || first.Status != "streaming" || first.TimeToFirstTokenMs != 400 {
+		t.Errorf("Expected a streaming update with a 400ms time to first token, got %+v", first)
+	}
+	if complete.TimeToFirstTokenMs != 400 || complete.Duration != 2400 {
+		t.Errorf("Expected 400ms to first token of a 2400ms request, got %dms of %dms", complete.TimeToFirstTokenMs, complete.Duration)
+	}
+	// 50 output tokens over the 2s from the first token
+	if complete.TokensPerSecond != 25 {
+		t.Errorf("Expected 25 tokens/s, got %v", complete.TokensPerSecond)
+	}
+	if _, exists := inspector.timings.Load(requestID); exists {
+		t.Error("Expected timing state to be released when the stream completes")
+	}
+}
+
+func TestLLMInspector_FinalizeReleasesState(t *testing.T) {
+	logger := slog.Default()
+	inspector := NewLLMInspector(logger, NewEventBus(logger, 100), "api.anthropic.com", nil)
+	requestID := "conn-closed-1"
+
+	reqBody := `{"model":"claude-sonnet-4","stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	request := fmt.Sprintf("POST /v1/messages HTTP/1.1\r\nHost: api.anthropic.com\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(reqBody), reqBody)
+	inspector.Inspect(DirectionClientToServer, []byte(request), "api.anthropic.com", "conn-closed", requestID)
+	inspector.Inspect(DirectionServerToClient, []byte("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\n\r\n"+
+		`data: {"type": "message_start", "message": {"usage": {"input_tokens": 10}}}`+"\n"+
+		`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hel"}}`+"\n"),
+		"api.anthropic.com", "conn-closed", requestID)
+
+	inspector.Finalize("conn-closed")
+
+	for name, state := range map[string]*sync.Map{
+		"path":            &inspector.requestPaths,
+		"conversation ID": &inspector.conversationIDs,
+		"model":           &inspector.models,
+		"processed bytes": &inspector.processedBytes,
+		"input tokens":    &inspector.inputTokens,
+		"open choices":    &inspector.openChoices,
+		"content":         &inspector.accumulatedContent,
+		"tool calls":      &inspector.toolCalls,
+		"token rate":      &inspector.tokenRates,
+		"timing":          &inspector.timings,
+	} {
+		if _, exists := state.Load(requestID); exists {
+			t.Errorf("Expected %s state to be released", name)
+		}
+	}
+	if _, exists := inspector.httpProc.GetPendingMessage(requestID); exists {
+		t.Error("Expected pending response to be cleared")
+	}
+}
+
+func TestLLMInspector_FinalizeFinishesOpenChoices(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 100)
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewLLMInspector(logger, eventBus, "api.anthropic.com", nil)
+	requestID := "conn-neither-1"
+
+	reqBody := `{"model":"claude-sonnet-4","stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	request := fmt.Sprintf("POST /v1/messages HTTP/1.1\r\nHost: api.anthropic.com\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(reqBody), reqBody)
+	inspector.Inspect(DirectionClientToServer, []byte(request), "api.anthropic.com", "conn-neither", requestID)
+	// No length, no last chunk and no terminal event, the stream ends with the connection
+	inspector.Inspect(DirectionServerToClient, []byte("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\n\r\n"+
+		`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hel"}}`+"\n"),
+		"api.anthropic.com", "conn-neither", requestID)
+	inspector.Inspect(DirectionServerToClient, []byte(
+		`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "lo"}}`+"\n"),
+		"api.anthropic.com", "conn-neither", requestID)
+
+	// Requests of other connections are left alone
+	inspector.Finalize("conn-other")
+	if _, open := inspector.openChoices.Load(requestID); !open {
+		t.Fatal("Expected the stream to stay open until its connection is finalized")
+	}
+	inspector.Finalize("conn-neither")
+
+	var ends, messages int
+	var text string
+	for drained := false; !drained; {
+		select {
+		case ev := <-sub.Channel:
+			switch extra := ev.Extra.(type) {
+			case *llm.LLMTokenEvent:
+				if extra.Type == llm.TokenEventEnd {
+					ends++
+				}
+			case *llm.LLMMessageEvent:
+				if extra.Message.Role == "assistant" {
+					messages++
+					text = extra.Message.Content[0]
+				}
+			}
+		case <-time.After(100 * time.Millisecond):
+			drained = true
+		}
+	}
+	if ends != 1 || messages != 1 {
+		t.Errorf("Expected exactly one end event and one assistant message, got %d and %d", ends, messages)
+	}
+	if text != "Hello" {
+		t.Errorf("Expected message %q, got %q", "Hello", text)
+	}
+}
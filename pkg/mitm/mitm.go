@@ -1,8 +1,11 @@
 package mitm
 
 import (
+	"crypto/x509"
 	"fmt"
 	"log/slog"
+	"os"
+	"path"
 	"sync"
 	"time"
 
@@ -18,6 +21,13 @@ type Manager struct {
 	inspector       *InspectorChain
 	eventBus        *EventBus
 	llmEventBus     *EventBus
+	trafficStats    *TrafficStatsCollector
+	chaos           *Chaos
+	responseCache   *ResponseCache
+	forwardedMode   string
+	keyLog          *os.File
+	upstreamRoots   *x509.CertPool
+	webhook         *WebhookForwarder
 	mu              sync.RWMutex
 }
 
@@ -27,13 +37,50 @@ type ManagerConfig struct {
 	CAKeyPath              string
 	CertCacheDir           string
 	SiteCertValidity       time.Duration
+	CertRenewWindow        time.Duration // Renew cached site certs this long before expiry, 0 = renew on demand
 	CACertValidity         time.Duration
 	Enabled                bool
 	MaxBodySize            int64
+	MaxRequestBodySize     int64 // Request body capture limit, 0 = MaxBodySize
+	MaxResponseBodySize    int64 // Response body capture limit, 0 = MaxBodySize
+	MaxHeaderSize          int64 // Bytes allowed before headers complete, 0 = DefaultMaxHeaderSize
+	MaxChunkedBodySize     int64 // Chunked request body bytes allowed before the terminator, 0 = DefaultMaxChunkedBodySize
+	SkipRequestBody        bool  // Skip capturing request bodies in traffic events
+	SkipResponseBody       bool  // Skip capturing response bodies in traffic events
+	MetadataOnly           bool  // Never buffer or capture bodies, disables LLM inspection
+	RawBody                bool  // Include undecoded body bytes in traffic events
 	EventHistorySize       int
-	LLMEventHistorySize    int      // Event history size for LLM inspector
-	CustomAnthropicMatches []string // Custom Anthropic API match patterns
-	CustomOpenAIMatches    []string // Custom OpenAI API match patterns
+	LLMEventHistorySize    int                  // Event history size for LLM inspector
+	EventBufferSize        int                  // Subscriber channel buffer of the traffic bus, 0 = DefaultSubscriberBufferSize
+	LLMEventBufferSize     int                  // Subscriber channel buffer of the LLM bus, 0 = DefaultSubscriberBufferSize
+	SizeBuckets            []int64              // Body size histogram bucket upper bounds in bytes
+	CustomAnthropicMatches []string             // Custom Anthropic API match patterns
+	CustomOpenAIMatches    []string             // Custom OpenAI API match patterns
+	ConversationIDStrategy string               // Anthropic conversation grouping: metadata, messages-hash or header
+	ConversationIDHeader   string               // Request header used by the header strategy
+	SlowTokenRate          float64              // Content events per second below which an LLM stream is flagged slow, 0 = disabled
+	SlowTokenWindow        time.Duration        // How long the rate must stay low, 0 = DefaultSlowTokenWindow
+	MaxStreamDuration      time.Duration        // Finalize streams open longer than this and pass them through, 0 = unlimited
+	GRPCHosts              []string             // Hostname globs of gRPC LLM gateways whose grpc-status errors are reported
+	Chaos                  *ChaosConfig         // Inject synthetic faults into responses, nil = disabled
+	ForwardedFor           string               // Add X-Forwarded-For/Proto to requests: ForwardedAppend, ForwardedReplace or empty = off
+	Profiles               []InspectionProfile  // Per-hostname inspector selection, first match wins, unmatched hosts run all
+	KeyLogFile             string               // Append TLS secrets in SSLKEYLOGFILE format for Wireshark, empty = disabled
+	Webhook                *WebhookConfig       // POST traffic and LLM events to a webhook, nil = disabled
+	ResponseCache          *ResponseCacheConfig // Answer repeated GETs from memory, nil = disabled
+	UpstreamRootCAs        *x509.CertPool       // Roots for verifying upstream server certificates, nil = system roots
+}
+
+// Inspector names usable in inspection profiles
+const (
+	InspectorLLM = "llm" // LLM conversation parsing
+	InspectorSSE = "sse" // Traffic events for the admin UI
+)
+
+// InspectionProfile runs Inspectors, in order, for hostnames matching any of the Hosts globs
+type InspectionProfile struct {
+	Hosts      []string
+	Inspectors []string
 }
 
 // NewManager creates a new MITM manager
@@ -50,6 +97,73 @@ func NewManager(config ManagerConfig, logger *slog.Logger) (*Manager, error) {
 		caValidity = config.CACertValidity
 	}
 
+	if config.CertRenewWindow >= siteValidity {
+		// 默认的 renew window 可能不小于用户缩短后的证书有效期，截断而不是拒绝启动
+		logger.Warn("cert renew window is not shorter than site cert validity, clamping to half the validity",
+			"cert_renew_window", config.CertRenewWindow, "site_cert_validity", siteValidity)
+		config.CertRenewWindow = siteValidity / 2
+	}
+
+	switch config.ConversationIDStrategy {
+	case "", llm.ConversationIDMetadata, llm.ConversationIDMessagesHash:
+	case llm.ConversationIDHeader:
+		if config.ConversationIDHeader == "" {
+			return nil, fmt.Errorf("conversation ID strategy %q requires a header name", config.ConversationIDStrategy)
+		}
+	default:
+		return nil, fmt.Errorf("unknown conversation ID strategy %q", config.ConversationIDStrategy)
+	}
+
+	switch config.ForwardedFor {
+	case "", ForwardedAppend, ForwardedReplace:
+	default:
+		return nil, fmt.Errorf("unknown X-Forwarded-For mode %q", config.ForwardedFor)
+	}
+
+	for _, profile := range config.Profiles {
+		if len(profile.Hosts) == 0 {
+			return nil, fmt.Errorf("inspection profile %v has no hosts", profile.Inspectors)
+		}
+		for _, host := range profile.Hosts {
+			if _, err := path.Match(host, ""); err != nil {
+				return nil, fmt.Errorf("invalid inspection profile host %q: %w", host, err)
+			}
+		}
+		for _, name := range profile.Inspectors {
+			if name != InspectorLLM && name != InspectorSSE {
+				return nil, fmt.Errorf("unknown inspector %q in inspection profile, expected %s or %s", name, InspectorLLM, InspectorSSE)
+			}
+		}
+	}
+
+	var chaos *Chaos
+	if config.Chaos != nil {
+		var err error
+		chaos, err = NewChaos(*config.Chaos)
+		if err != nil {
+			return nil, err
+		}
+		logger.Warn("MITM chaos mode enabled", "hosts", config.Chaos.Hosts,
+			"drop_rate", config.Chaos.DropRate, "truncate_rate", config.Chaos.TruncateRate,
+			"delay_rate", config.Chaos.DelayRate, "delay", config.Chaos.Delay)
+	}
+
+	var responseCache *ResponseCache
+	if config.ResponseCache != nil {
+		responseCache = NewResponseCache(*config.ResponseCache)
+		logger.Info("MITM response cache enabled", "max_entries", responseCache.config.MaxEntries,
+			"max_entry_size", responseCache.config.MaxEntrySize)
+	}
+
+	var webhook *WebhookForwarder
+	if config.Webhook != nil {
+		var err error
+		webhook, err = NewWebhookForwarder(*config.Webhook, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Create certificate manager (loads or creates CA)
 	certManager, err := NewCertManager(config.CACertPath, config.CAKeyPath, caValidity)
 	if err != nil {
@@ -68,6 +182,15 @@ func NewManager(config ManagerConfig, logger *slog.Logger) (*Manager, error) {
 		return nil, fmt.Errorf("failed to create site certificate manager: %w", err)
 	}
 
+	var keyLog *os.File
+	if config.KeyLogFile != "" {
+		keyLog, err = os.OpenFile(config.KeyLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open TLS key log file: %w", err)
+		}
+		logger.Warn("MITM TLS key logging enabled, captured traffic can be decrypted with this file", "path", config.KeyLogFile)
+	}
+
 	m := &Manager{
 		certManager:     certManager,
 		siteCertManager: siteCertManager,
@@ -76,15 +199,65 @@ func NewManager(config ManagerConfig, logger *slog.Logger) (*Manager, error) {
 		inspector:       NewInspectorChain(),
 		eventBus:        NewEventBus(logger, config.EventHistorySize),
 		llmEventBus:     NewEventBus(logger, config.LLMEventHistorySize),
+		trafficStats:    NewTrafficStatsCollector(config.SizeBuckets),
+		chaos:           chaos,
+		responseCache:   responseCache,
+		forwardedMode:   config.ForwardedFor,
+		keyLog:          keyLog,
+		upstreamRoots:   config.UpstreamRootCAs,
+		webhook:         webhook,
 	}
+	m.eventBus.SetBufferSize(config.EventBufferSize)
+	m.llmEventBus.SetBufferSize(config.LLMEventBufferSize)
 
 	// Add both inspectors - they publish to separate event buses
 	// SSEInspector must in the last
-	m.inspector.Add(NewLLMInspector(logger, m.llmEventBus, "", &llm.ProviderMatcher{
-		CustomAnthropicMatches: config.CustomAnthropicMatches,
-		CustomOpenAIMatches:    config.CustomOpenAIMatches,
-	}))
-	m.inspector.Add(NewSSEInspector(logger, m.eventBus, "", config.MaxBodySize))
+	// LLM inspection parses bodies, so it is left out in metadata-only mode
+	byName := make(map[string]Inspector)
+	if !config.MetadataOnly {
+		llmInspector := NewLLMInspector(logger, m.llmEventBus, "", &llm.ProviderMatcher{
+			CustomAnthropicMatches: config.CustomAnthropicMatches,
+			CustomOpenAIMatches:    config.CustomOpenAIMatches,
+			ConversationIDStrategy: config.ConversationIDStrategy,
+			ConversationIDHeader:   config.ConversationIDHeader,
+		})
+		llmInspector.SetMaxHeaderSize(config.MaxHeaderSize)
+		llmInspector.SetMaxChunkedBodySize(config.MaxChunkedBodySize)
+		llmInspector.SetSlowTokenRate(config.SlowTokenRate, config.SlowTokenWindow)
+		llmInspector.SetMaxStreamDuration(config.MaxStreamDuration)
+		llmInspector.SetGRPCHosts(config.GRPCHosts)
+		m.inspector.Add(llmInspector)
+		byName[InspectorLLM] = llmInspector
+	}
+	sseInspector := NewSSEInspector(logger, m.eventBus, "", config.MaxBodySize)
+	sseInspector.SetMaxBodySize(config.MaxRequestBodySize, config.MaxResponseBodySize)
+	sseInspector.SetMaxHeaderSize(config.MaxHeaderSize)
+	sseInspector.SetMaxChunkedBodySize(config.MaxChunkedBodySize)
+	sseInspector.SetMaxStreamDuration(config.MaxStreamDuration)
+	sseInspector.SetSkipBody(config.SkipRequestBody, config.SkipResponseBody)
+	sseInspector.SetMetadataOnly(config.MetadataOnly)
+	sseInspector.SetRawBody(config.RawBody)
+	sseInspector.SetStatsCollector(m.trafficStats)
+	m.inspector.Add(sseInspector)
+	byName[InspectorSSE] = sseInspector
+
+	for _, profile := range config.Profiles {
+		inspectors := make([]Inspector, 0, len(profile.Inspectors))
+		for _, name := range profile.Inspectors {
+			// Inspectors disabled by other settings (llm in metadata-only mode) are skipped
+			if inspector, ok := byName[name]; ok {
+				inspectors = append(inspectors, inspector)
+			}
+		}
+		m.inspector.addProfile(profile.Hosts, inspectors)
+	}
+
+	siteCertManager.StartRenewal(config.CertRenewWindow, certRenewInterval)
+
+	if webhook != nil {
+		webhook.Start(m.eventBus, m.llmEventBus)
+		logger.Info("MITM event webhook enabled", "url", webhook.Endpoint(), "sample_rate", config.Webhook.SampleRate)
+	}
 
 	return m, nil
 }
@@ -120,12 +293,20 @@ func (m *Manager) GetCACertificatePath() string {
 
 // ConnectionHandler returns a new connection handler
 func (m *Manager) ConnectionHandler(upstream UpstreamClient) *ConnectionHandler {
-	return NewConnectionHandler(m.siteCertManager, m.logger, upstream, m.inspector, nil)
+	return m.ConnectionHandlerWithPeekReader(upstream, nil)
 }
 
 // ConnectionHandlerWithPeekReader returns a connection handler that uses the provided PeekReader
 func (m *Manager) ConnectionHandlerWithPeekReader(upstream UpstreamClient, peekReader *PeekReader) *ConnectionHandler {
-	return NewConnectionHandler(m.siteCertManager, m.logger, upstream, m.inspector, peekReader)
+	h := NewConnectionHandler(m.siteCertManager, m.logger, upstream, m.inspector, peekReader)
+	h.chaos = m.chaos
+	h.cache = m.responseCache
+	h.forwardedMode = m.forwardedMode
+	h.upstreamRoots = m.upstreamRoots
+	if m.keyLog != nil {
+		h.keyLog = m.keyLog
+	}
+	return h
 }
 
 // Statistics holds MITM statistics
@@ -152,3 +333,30 @@ func (m *Manager) GetEventBus() *EventBus {
 func (m *Manager) GetLLMEventBus() *EventBus {
 	return m.llmEventBus
 }
+
+// Close closes the event buses, ending all subscriber streams
+func (m *Manager) Close() {
+	m.siteCertManager.StopRenewal()
+	m.eventBus.Close()
+	m.llmEventBus.Close()
+	if m.webhook != nil {
+		m.webhook.Stop()
+	}
+	if m.keyLog != nil {
+		m.keyLog.Close()
+	}
+}
+
+// GetWebhookStats returns the webhook delivery counters, nil when the webhook is disabled
+func (m *Manager) GetWebhookStats() *WebhookStats {
+	if m.webhook == nil {
+		return nil
+	}
+	stats := m.webhook.Stats()
+	return &stats
+}
+
+// GetTrafficStats returns the request/response body size stats collector
+func (m *Manager) GetTrafficStats() *TrafficStatsCollector {
+	return m.trafficStats
+}
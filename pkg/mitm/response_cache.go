@@ -0,0 +1,480 @@
+package mitm
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCacheConfig configures the in-memory cache of intercepted GET responses
+type ResponseCacheConfig struct {
+	MaxEntries   int   // Responses kept, least recently used are evicted first, 0 = DefaultResponseCacheEntries
+	MaxEntrySize int64 // Largest body stored in bytes, 0 = DefaultResponseCacheEntrySize
+}
+
+// Response cache defaults used when the config leaves them unset
+const (
+	DefaultResponseCacheEntries   = 1000
+	DefaultResponseCacheEntrySize = 1 << 20 // 1MB
+)
+
+// CacheHeader is added to responses served from the response cache, its value is
+// CacheHit or CacheRevalidated
+const CacheHeader = "X-Linko-Cache"
+
+const (
+	CacheHit         = "HIT"         // Fresh entry served without contacting the server
+	CacheRevalidated = "REVALIDATED" // Stale entry the server confirmed with 304 Not Modified
+)
+
+// cacheEntry is a stored response of one URL and set of Vary header values
+type cacheEntry struct {
+	key      string      // Method and URL
+	vary     http.Header // Request values of the headers named by Vary
+	status   string      // Status line without the protocol, e.g. "200 OK"
+	header   http.Header
+	body     []byte
+	age      int // Age of the response when it was stored, in seconds
+	storedAt time.Time
+	expires  time.Time
+	// mustRevalidate forbids serving the entry stale, set by must-revalidate,
+	// proxy-revalidate or s-maxage
+	mustRevalidate bool
+	elem           *list.Element
+}
+
+// ResponseCache stores GET responses honoring Cache-Control, Expires, ETag and Vary. It is
+// shared by all clients and so follows the rules of a shared cache.
+type ResponseCache struct {
+	config  ResponseCacheConfig
+	mu      sync.Mutex
+	entries map[string][]*cacheEntry // key -> variants
+	lru     *list.List               // *cacheEntry, most recently used first
+	now     func() time.Time
+}
+
+// NewResponseCache creates an empty response cache
+func NewResponseCache(config ResponseCacheConfig) *ResponseCache {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = DefaultResponseCacheEntries
+	}
+	if config.MaxEntrySize <= 0 {
+		config.MaxEntrySize = DefaultResponseCacheEntrySize
+	}
+	return &ResponseCache{
+		config:  config,
+		entries: make(map[string][]*cacheEntry),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// Len returns the number of stored responses
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// cacheKey identifies the resource requested by req on hostname. The key uses the SNI
+// hostname the server certificate was verified against, never the client supplied Host.
+func cacheKey(req *http.Request, hostname string) string {
+	return req.Method + " https://" + strings.ToLower(hostname) + req.RequestURI
+}
+
+// hostMatches reports whether the Host of req, without port, names hostname. Requests for
+// another host than the verified one are relayed but never cached, so a client can't store
+// a response of one site under the name of another.
+func hostMatches(req *http.Request, hostname string) bool {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	return host != "" && strings.EqualFold(host, strings.TrimSuffix(hostname, "."))
+}
+
+// cacheableRequest reports whether req may be answered from the cache or have its response stored
+func cacheableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet || req.ContentLength != 0 {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" || req.Header.Get("Upgrade") != "" {
+		return false
+	}
+	_, noStore := cacheDirectives(req.Header)["no-store"]
+	return !noStore
+}
+
+// usesCachedResponse reports whether a cacheable req accepts a stored response, requests
+// forcing a reload or carrying their own validators always go to the server
+func usesCachedResponse(req *http.Request) bool {
+	if _, noCache := cacheDirectives(req.Header)["no-cache"]; noCache {
+		return false
+	}
+	if strings.Contains(strings.ToLower(req.Header.Get("Pragma")), "no-cache") {
+		return false
+	}
+	return req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == ""
+}
+
+// cacheDirectives parses Cache-Control into lowercased directive names and unquoted values
+func cacheDirectives(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, line := range header.Values("Cache-Control") {
+		for part := range strings.SplitSeq(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return directives
+}
+
+// varyNames returns the canonical header names listed in Vary
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, line := range header.Values("Vary") {
+		for part := range strings.SplitSeq(line, ",") {
+			if name := strings.TrimSpace(part); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// freshness returns how long a response with header stays fresh after being received and
+// whether it may be stored at all. Responses without freshness are only stored when they
+// carry an ETag to revalidate with.
+func freshness(header http.Header, now time.Time) (time.Duration, bool) {
+	directives := cacheDirectives(header)
+	if _, ok := directives["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := directives["private"]; ok {
+		return 0, false
+	}
+	if header.Get("Set-Cookie") != "" || slices.Contains(varyNames(header), "*") {
+		return 0, false
+	}
+	revalidatable := header.Get("ETag") != ""
+
+	// s-maxage applies to shared caches like this one and overrides max-age
+	maxAge, hasMaxAge := directives["s-maxage"]
+	if !hasMaxAge {
+		maxAge, hasMaxAge = directives["max-age"]
+	}
+
+	var ttl time.Duration
+	if _, ok := directives["no-cache"]; ok {
+		ttl = 0
+	} else if hasMaxAge {
+		seconds, err := strconv.Atoi(maxAge)
+		if err == nil {
+			age, _ := strconv.Atoi(header.Get("Age"))
+			ttl = time.Duration(seconds-age) * time.Second
+		}
+	} else if expires := header.Get("Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			date, err := http.ParseTime(header.Get("Date"))
+			if err != nil {
+				date = now
+			}
+			ttl = t.Sub(date)
+		}
+	}
+	return max(ttl, 0), ttl > 0 || revalidatable
+}
+
+// mustRevalidate reports whether a response with header may never be served stale
+func mustRevalidate(header http.Header) bool {
+	directives := cacheDirectives(header)
+	for _, name := range []string{"must-revalidate", "proxy-revalidate", "s-maxage"} {
+		if _, ok := directives[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the entry stored for key whose Vary values match reqHeader and its ETag,
+// read under the lock since revalidate updates the header of a shared entry
+func (c *ResponseCache) lookup(key string, reqHeader http.Header) (*cacheEntry, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries[key] {
+		if varyMatches(entry.vary, reqHeader) {
+			c.lru.MoveToFront(entry.elem)
+			return entry, entry.header.Get("ETag")
+		}
+	}
+	return nil, ""
+}
+
+func varyMatches(vary, reqHeader http.Header) bool {
+	for name, values := range vary {
+		if !slices.Equal(values, reqHeader.Values(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// fresh reports whether entry can be served to a request with reqHeader without
+// revalidation. A request max-stale accepts stale entries unless the response demands
+// revalidation.
+func (c *ResponseCache) fresh(entry *cacheEntry, reqHeader http.Header) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if now.Before(entry.expires) {
+		return true
+	}
+	maxStale, ok := cacheDirectives(reqHeader)["max-stale"]
+	if !ok || entry.mustRevalidate {
+		return false
+	}
+	if maxStale == "" {
+		return true
+	}
+	seconds, err := strconv.Atoi(maxStale)
+	return err == nil && now.Before(entry.expires.Add(time.Duration(seconds)*time.Second))
+}
+
+// store saves the response to req, replacing the variant with the same Vary values
+func (c *ResponseCache) store(key string, req *http.Request, resp *http.Response, body []byte) {
+	now := c.now()
+	ttl, ok := freshness(resp.Header, now)
+	if !ok {
+		return
+	}
+
+	vary := make(http.Header)
+	for _, name := range varyNames(resp.Header) {
+		vary[name] = req.Header.Values(name)
+	}
+	age, _ := strconv.Atoi(resp.Header.Get("Age"))
+	entry := &cacheEntry{
+		key:      key,
+		vary:     vary,
+		status:   resp.Status,
+		header:   resp.Header.Clone(),
+		body:     body,
+		age:      age,
+		storedAt: now,
+		expires:  now.Add(ttl),
+
+		mustRevalidate: mustRevalidate(resp.Header),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = slices.DeleteFunc(c.entries[key], func(old *cacheEntry) bool {
+		if varyMatches(old.vary, req.Header) {
+			c.lru.Remove(old.elem)
+			return true
+		}
+		return false
+	})
+	entry.elem = c.lru.PushFront(entry)
+	c.entries[key] = append(c.entries[key], entry)
+
+	for c.lru.Len() > c.config.MaxEntries {
+		oldest := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		c.entries[oldest.key] = slices.DeleteFunc(c.entries[oldest.key], func(e *cacheEntry) bool { return e == oldest })
+		if len(c.entries[oldest.key]) == 0 {
+			delete(c.entries, oldest.key)
+		}
+	}
+}
+
+// revalidate refreshes entry with the headers of a 304 Not Modified response
+func (c *ResponseCache) revalidate(entry *cacheEntry, header http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range []string{"Cache-Control", "Expires", "Date", "ETag", "Age"} {
+		if values := header.Values(name); len(values) > 0 {
+			entry.header[name] = values
+		} else if name == "Age" {
+			entry.header.Del(name)
+		}
+	}
+	now := c.now()
+	ttl, _ := freshness(entry.header, now)
+	entry.age, _ = strconv.Atoi(entry.header.Get("Age"))
+	entry.storedAt = now
+	entry.expires = now.Add(ttl)
+	entry.mustRevalidate = mustRevalidate(entry.header)
+}
+
+// serialize returns entry as an HTTP/1.1 response marked with state
+func (c *ResponseCache) serialize(entry *cacheEntry, state string) []byte {
+	c.mu.Lock()
+	header := entry.header.Clone()
+	age := entry.age + int(c.now().Sub(entry.storedAt).Seconds())
+	c.mu.Unlock()
+
+	for _, name := range []string{"Connection", "Keep-Alive", "Transfer-Encoding", "Trailer"} {
+		header.Del(name)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(entry.body)))
+	header.Set("Age", strconv.Itoa(age))
+	header.Set(CacheHeader, state)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "HTTP/1.1 %s\r\n", entry.status)
+	header.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(entry.body)
+	return buf.Bytes()
+}
+
+// readBody reads the body of resp for storing, the body is restored so resp can still be
+// relayed. ok is false when the body exceeds MaxEntrySize or could not be read.
+func (c *ResponseCache) readBody(resp *http.Response) (body []byte, ok bool) {
+	if resp.ContentLength > c.config.MaxEntrySize {
+		return nil, false
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.config.MaxEntrySize+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	return body, err == nil && int64(len(body)) <= c.config.MaxEntrySize
+}
+
+// relayCached relays HTTP/1.x exchanges one at a time so GET requests can be answered from
+// cache, emit receives responses served from cache for inspection. Non-HTTP data and anything
+// after a protocol upgrade is copied raw. Responses cut short by chaos are never stored and
+// end the relay.
+func (h *ConnectionHandler) relayCached(client, server io.Writer, clientReader, serverReader io.Reader, hostname, clientAddr, proto string, emit func([]byte), chaos *chaosReader) error {
+	cr := bufio.NewReaderSize(clientReader, DefaultBufferSize)
+	sr := bufio.NewReaderSize(serverReader, DefaultBufferSize)
+	for {
+		prefix, err := cr.Peek(8)
+		if len(prefix) == 0 {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if !isHTTPPrefix(prefix) {
+			return relayRaw(client, server, cr, sr)
+		}
+
+		req, err := http.ReadRequest(cr)
+		if err != nil {
+			return err
+		}
+		if h.forwardedMode != "" {
+			setForwardedHeaders(req.Header, clientAddr, proto, h.forwardedMode)
+		}
+
+		key := cacheKey(req, hostname)
+		cacheable := cacheableRequest(req) && hostMatches(req, hostname)
+		var entry *cacheEntry
+		if cacheable && usesCachedResponse(req) {
+			var etag string
+			entry, etag = h.cache.lookup(key, req.Header)
+			if entry != nil && h.cache.fresh(entry, req.Header) {
+				if err := serveCached(client, h.cache.serialize(entry, CacheHit), emit); err != nil {
+					return err
+				}
+				continue
+			}
+			if entry != nil && etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			} else {
+				entry = nil
+			}
+		}
+
+		// The body is sent concurrently so "Expect: 100-continue" interim responses reach the client
+		written := make(chan error, 1)
+		go func() { written <- writeRequest(server, req) }()
+
+		resp, err := readFinalResponse(sr, req, client)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry != nil && resp.StatusCode == http.StatusNotModified:
+			resp.Body.Close()
+			h.cache.revalidate(entry, resp.Header)
+			err = serveCached(client, h.cache.serialize(entry, CacheRevalidated), emit)
+		case cacheable && resp.StatusCode == http.StatusOK:
+			if body, ok := h.cache.readBody(resp); ok && !chaos.hasFaulted() {
+				h.cache.store(key, req, resp, body)
+			}
+			err = resp.Write(client)
+		default:
+			err = resp.Write(client)
+		}
+		if err != nil {
+			return err
+		}
+		if err := <-written; err != nil {
+			return err
+		}
+
+		if chaos.hasFaulted() {
+			return nil
+		}
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			return relayRaw(client, server, cr, sr)
+		}
+		if resp.Close || req.Close {
+			return nil
+		}
+	}
+}
+
+// readFinalResponse reads the response to req, relaying interim 1xx responses to client
+func readFinalResponse(sr *bufio.Reader, req *http.Request, client io.Writer) (*http.Response, error) {
+	for {
+		resp, err := http.ReadResponse(sr, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 200 && resp.StatusCode != http.StatusSwitchingProtocols {
+			if err := resp.Write(client); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// serveCached writes a cached response to client after handing it to emit
+func serveCached(client io.Writer, data []byte, emit func([]byte)) error {
+	if emit != nil {
+		emit(data)
+	}
+	_, err := client.Write(data)
+	return err
+}
+
+// relayRaw copies both directions until each side is done
+func relayRaw(client, server io.Writer, cr, sr io.Reader) error {
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		_, _ = io.Copy(client, sr)
+	})
+	_, err := io.Copy(server, cr)
+	wg.Wait()
+	return err
+}
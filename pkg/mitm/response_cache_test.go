@@ -0,0 +1,417 @@
+package mitm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cachedRelayClient is the client end of a relay with the response cache enabled
+type cachedRelayClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// startCachedRelay relays a client pipe to a server answering with handler, counting its requests
+func startCachedRelay(t *testing.T, inspector *InspectorChain, handler http.HandlerFunc) (*cachedRelayClient, *atomic.Int32) {
+	t.Helper()
+	h := &ConnectionHandler{
+		logger:    slog.Default(),
+		inspector: inspector,
+		cache:     NewResponseCache(ResponseCacheConfig{}),
+	}
+	var requests atomic.Int32
+	return startRelayWith(t, h, handler, &requests), &requests
+}
+
+// startRelayWith relays a client pipe through h to a server answering with handler, so
+// several relays can share the cache of h
+func startRelayWith(t *testing.T, h *ConnectionHandler, handler http.HandlerFunc, requests *atomic.Int32) *cachedRelayClient {
+	t.Helper()
+	clientConn, clientPeer := net.Pipe()
+	serverConn, serverPeer := net.Pipe()
+	t.Cleanup(func() {
+		clientPeer.Close()
+		serverPeer.Close()
+	})
+
+	go func() {
+		br := bufio.NewReader(serverPeer)
+		for {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			requests.Add(1)
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			resp := rec.Result()
+			resp.ContentLength = int64(rec.Body.Len())
+			if err := resp.Write(serverPeer); err != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		h.relayTraffic(clientConn, serverConn, "example.com")
+		clientConn.Close()
+		serverConn.Close()
+	}()
+
+	return &cachedRelayClient{t: t, conn: clientPeer, br: bufio.NewReader(clientPeer)}
+}
+
+// get sends a GET for path and returns the response with its body
+func (c *cachedRelayClient) get(path string, header ...string) (*http.Response, string) {
+	c.t.Helper()
+	req := "GET " + path + " HTTP/1.1\r\nHost: example.com\r\n"
+	for _, h := range header {
+		req += h + "\r\n"
+	}
+	if _, err := io.WriteString(c.conn, req+"\r\n"); err != nil {
+		c.t.Fatalf("Failed to write request: %v", err)
+	}
+	resp, err := http.ReadResponse(c.br, nil)
+	if err != nil {
+		c.t.Fatalf("Failed to read response: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatalf("Failed to read body: %v", err)
+	}
+	return resp, string(body)
+}
+
+func TestResponseCache_ServesFreshResponse(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.Subscribe()
+	chain := NewInspectorChain()
+	chain.Add(NewSSEInspector(logger, eventBus, "", 1024*1024))
+
+	client, requests := startCachedRelay(t, chain, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, "hello")
+	})
+
+	resp, body := client.get("/data")
+	if body != "hello" || resp.Header.Get(CacheHeader) != "" {
+		t.Fatalf("Expected uncached hello, got %q with %s=%q", body, CacheHeader, resp.Header.Get(CacheHeader))
+	}
+	resp, body = client.get("/data")
+	if body != "hello" {
+		t.Errorf("Expected cached body hello, got %q", body)
+	}
+	if got := resp.Header.Get(CacheHeader); got != CacheHit {
+		t.Errorf("Expected %s: %s, got %q", CacheHeader, CacheHit, got)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Expected 1 request to reach the server, got %d", got)
+	}
+
+	// A different URL is not served from the entry
+	if resp, _ := client.get("/other"); resp.Header.Get(CacheHeader) != "" {
+		t.Error("Expected /other to miss the cache")
+	}
+
+	var cached []bool
+	timeout := time.After(2 * time.Second)
+	for len(cached) < 3 {
+		select {
+		case event := <-sub.Channel:
+			if event.Response != nil {
+				cached = append(cached, event.Response.Cached)
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for events, got %v", cached)
+		}
+	}
+	if cached[0] || !cached[1] || cached[2] {
+		t.Errorf("Expected only the second response flagged as cached, got %v", cached)
+	}
+}
+
+func TestResponseCache_NoStoreBypasses(t *testing.T) {
+	client, requests := startCachedRelay(t, NewInspectorChain(), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store, max-age=60")
+		io.WriteString(w, "secret")
+	})
+
+	for i := 0; i < 2; i++ {
+		resp, body := client.get("/data")
+		if body != "secret" {
+			t.Errorf("Expected body secret, got %q", body)
+		}
+		if got := resp.Header.Get(CacheHeader); got != "" {
+			t.Errorf("Expected no-store response not to be cached, got %s: %s", CacheHeader, got)
+		}
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Expected both requests to reach the server, got %d", got)
+	}
+}
+
+func TestResponseCache_RevalidatesWithETag(t *testing.T) {
+	var conditional atomic.Int32
+	client, requests := startCachedRelay(t, NewInspectorChain(), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, "versioned")
+	})
+
+	client.get("/data")
+	resp, body := client.get("/data")
+	if resp.StatusCode != http.StatusOK || body != "versioned" {
+		t.Errorf("Expected the stored 200 with body versioned, got %d %q", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get(CacheHeader); got != CacheRevalidated {
+		t.Errorf("Expected %s: %s, got %q", CacheHeader, CacheRevalidated, got)
+	}
+	if requests.Load() != 2 || conditional.Load() != 1 {
+		t.Errorf("Expected one conditional request, got %d requests, %d conditional", requests.Load(), conditional.Load())
+	}
+
+	// A client forcing a reload bypasses the stored entry
+	resp, _ = client.get("/data", "Cache-Control: no-cache")
+	if resp.Header.Get(CacheHeader) != "" || conditional.Load() != 1 {
+		t.Error("Expected Cache-Control: no-cache request to skip the cache")
+	}
+}
+
+func TestResponseCache_ConcurrentRevalidation(t *testing.T) {
+	h := &ConnectionHandler{
+		logger:    slog.Default(),
+		inspector: NewInspectorChain(),
+		cache:     NewResponseCache(ResponseCacheConfig{}),
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, "versioned")
+	}
+
+	// Every relay revalidates the same shared entry while the others read its ETag
+	var requests atomic.Int32
+	startRelayWith(t, h, handler, &requests).get("/data")
+	var wg sync.WaitGroup
+	for range 4 {
+		client := startRelayWith(t, h, handler, &requests)
+		wg.Go(func() {
+			for range 20 {
+				if _, body := client.get("/data"); body != "versioned" {
+					t.Errorf("Expected body versioned, got %q", body)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestResponseCache_VaryAndEviction(t *testing.T) {
+	cache := NewResponseCache(ResponseCacheConfig{MaxEntries: 2})
+	store := func(path, lang string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Language", lang)
+		resp := &http.Response{Status: "200 OK", StatusCode: http.StatusOK, Header: http.Header{
+			"Cache-Control": {"max-age=60"},
+			"Vary":          {"accept-language"},
+		}}
+		cache.store(cacheKey(req, "example.com"), req, resp, []byte(lang))
+	}
+	lookup := func(path, lang string) *cacheEntry {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Language", lang)
+		entry, _ := cache.lookup(cacheKey(req, "example.com"), req.Header)
+		return entry
+	}
+
+	store("/a", "en")
+	store("/a", "fr")
+	if e := lookup("/a", "fr"); e == nil || string(e.body) != "fr" {
+		t.Errorf("Expected the fr variant, got %v", e)
+	}
+	if e := lookup("/a", "de"); e != nil {
+		t.Errorf("Expected no variant for de, got %q", e.body)
+	}
+
+	// /a en is the least recently used and gets evicted
+	store("/b", "en")
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", cache.Len())
+	}
+	if lookup("/a", "en") != nil {
+		t.Error("Expected /a en to be evicted")
+	}
+	if lookup("/a", "fr") == nil || lookup("/b", "en") == nil {
+		t.Error("Expected /a fr and /b en to be kept")
+	}
+}
+
+func TestResponseCache_HostMismatchNotCached(t *testing.T) {
+	client, requests := startCachedRelay(t, NewInspectorChain(), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, r.Host)
+	})
+
+	// The relay verified example.com, a request naming another host must not be stored
+	// where example.com requests would find it
+	for i := 0; i < 2; i++ {
+		if _, err := io.WriteString(client.conn, "GET /data HTTP/1.1\r\nHost: api.other.com\r\n\r\n"); err != nil {
+			t.Fatalf("Failed to write request: %v", err)
+		}
+		resp, err := http.ReadResponse(client.br, nil)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		if got := resp.Header.Get(CacheHeader); got != "" {
+			t.Errorf("Expected mismatched Host not to be served from cache, got %s: %s", CacheHeader, got)
+		}
+	}
+	if _, body := client.get("/data"); body != "example.com" {
+		t.Errorf("Expected the example.com response, got %q", body)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("Expected 3 requests to reach the server, got %d", got)
+	}
+
+	// A port on a matching Host is still cached
+	if resp, _ := client.get("/data"); resp.Header.Get(CacheHeader) != CacheHit {
+		t.Error("Expected the example.com response to be cached")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/data", nil)
+	req.Host = "Example.com:443"
+	if !hostMatches(req, "example.com") {
+		t.Error("Expected Host with port to match the hostname")
+	}
+}
+
+func TestResponseCache_MaxStaleAndMustRevalidate(t *testing.T) {
+	cache := NewResponseCache(ResponseCacheConfig{})
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	store := func(path, cacheControl string) *cacheEntry {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		resp := &http.Response{Status: "200 OK", StatusCode: http.StatusOK, Header: http.Header{
+			"Cache-Control": {cacheControl},
+		}}
+		cache.store(cacheKey(req, "example.com"), req, resp, nil)
+		entry, _ := cache.lookup(cacheKey(req, "example.com"), req.Header)
+		return entry
+	}
+	plain := store("/plain", "max-age=10")
+	strict := store("/strict", "max-age=10, must-revalidate")
+	shared := store("/shared", "s-maxage=10")
+
+	now = now.Add(20 * time.Second)
+	maxStale := http.Header{"Cache-Control": {"max-stale=60"}}
+	if cache.fresh(plain, http.Header{}) {
+		t.Error("Expected a stale entry not to be served without max-stale")
+	}
+	if !cache.fresh(plain, maxStale) || !cache.fresh(plain, http.Header{"Cache-Control": {"max-stale"}}) {
+		t.Error("Expected max-stale to accept the stale entry")
+	}
+	if cache.fresh(plain, http.Header{"Cache-Control": {"max-stale=5"}}) {
+		t.Error("Expected max-stale=5 to reject an entry stale for 10s")
+	}
+	if cache.fresh(strict, maxStale) || cache.fresh(shared, maxStale) {
+		t.Error("Expected must-revalidate and s-maxage entries never to be served stale")
+	}
+}
+
+func TestFreshness(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		header   http.Header
+		ttl      time.Duration
+		storable bool
+	}{
+		{http.Header{"Cache-Control": {"max-age=60"}}, 60 * time.Second, true},
+		{http.Header{"Cache-Control": {"max-age=60"}, "Age": {"50"}}, 10 * time.Second, true},
+		{http.Header{"Cache-Control": {"max-age=60, s-maxage=10"}}, 10 * time.Second, true},
+		{http.Header{"Cache-Control": {"public, max-age=0"}}, 0, false},
+		{http.Header{"Cache-Control": {"no-cache"}, "Etag": {`"x"`}}, 0, true},
+		{http.Header{"Cache-Control": {"private, max-age=60"}}, 0, false},
+		{http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}, 0, false},
+		{http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, 0, false},
+		{http.Header{"Expires": {now.Add(time.Hour).UTC().Format(http.TimeFormat)}, "Date": {now.UTC().Format(http.TimeFormat)}}, time.Hour, true},
+		{http.Header{}, 0, false},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			ttl, storable := freshness(tt.header, now)
+			if ttl.Round(time.Second) != tt.ttl || storable != tt.storable {
+				t.Errorf("freshness(%v) = %v, %v, want %v, %v", tt.header, ttl, storable, tt.ttl, tt.storable)
+			}
+		})
+	}
+}
+
+func TestRelayCached_TruncatedResponseNotStored(t *testing.T) {
+	clientConn, clientPeer := net.Pipe()
+	serverConn, serverPeer := net.Pipe()
+	t.Cleanup(func() {
+		clientPeer.Close()
+		serverPeer.Close()
+	})
+
+	go func() {
+		if _, err := http.ReadRequest(bufio.NewReader(serverPeer)); err != nil {
+			return
+		}
+		// 无 Content-Length，截断后 body 读到 EOF 看起来是完整的
+		serverPeer.Write([]byte("HTTP/1.1 200 OK\r\nCache-Control: max-age=60\r\nConnection: close\r\n\r\n" + strings.Repeat("x", 256)))
+		serverPeer.Close()
+	}()
+
+	h := &ConnectionHandler{
+		logger:    slog.Default(),
+		inspector: NewInspectorChain(),
+		cache:     NewResponseCache(ResponseCacheConfig{}),
+		chaos:     newTestChaos(t, ChaosConfig{TruncateRate: 1}),
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.relayTraffic(clientConn, serverConn, "example.com")
+	}()
+
+	if _, err := clientPeer.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+	got, _ := io.ReadAll(clientPeer)
+	if len(got) == 0 || strings.HasSuffix(string(got), strings.Repeat("x", 256)) {
+		t.Fatalf("client received %d bytes, want a truncated response", len(got))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay did not stop after the injected fault")
+	}
+	if n := h.cache.Len(); n != 0 {
+		t.Errorf("cache holds %d entries after a truncated response, want 0", n)
+	}
+}
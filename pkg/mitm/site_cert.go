@@ -12,8 +12,10 @@ import (
 	"fmt"
 	"log/slog"
 	"math/big"
+	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -27,6 +29,10 @@ type SiteCertManager struct {
 	cache    *CertCache
 	validity time.Duration
 	mu       sync.Mutex // protects certificate generation
+	now      func() time.Time
+
+	stopRenew chan struct{}
+	renewWG   sync.WaitGroup
 }
 
 // CertCache stores cached certificates in memory
@@ -56,6 +62,7 @@ func NewSiteCertManager(caCert *x509.Certificate, caKey crypto.Signer, cacheDir
 			certs: make(map[string]*CachedCert),
 		},
 		validity: validity,
+		now:      time.Now,
 	}
 
 	return scm, nil
@@ -66,17 +73,8 @@ func (scm *SiteCertManager) GetCertificate(hostname string) (*tls.Certificate, e
 	// Normalize hostname
 	hostname = strings.ToLower(hostname)
 
-	// Check memory cache first
-	cert := scm.getFromCache(hostname)
-	if cert != nil {
-		return cert, nil
-	}
-
-	// Check disk cache
-	cert, err := scm.loadFromDisk(hostname)
-	if err == nil && cert != nil {
-		// Add to memory cache
-		scm.addToCache(hostname, cert)
+	// Check memory and disk cache first
+	if cert := scm.lookup(hostname); cert != nil {
 		return cert, nil
 	}
 
@@ -85,19 +83,12 @@ func (scm *SiteCertManager) GetCertificate(hostname string) (*tls.Certificate, e
 	defer scm.mu.Unlock()
 
 	// Double-check cache after acquiring lock
-	cert = scm.getFromCache(hostname)
-	if cert != nil {
-		return cert, nil
-	}
-
-	cert, err = scm.loadFromDisk(hostname)
-	if err == nil && cert != nil {
-		scm.addToCache(hostname, cert)
+	if cert := scm.lookup(hostname); cert != nil {
 		return cert, nil
 	}
 
 	// Generate new certificate
-	cert, err = scm.generateCertificate(hostname)
+	cert, err := scm.generateCertificate(hostname)
 	if err != nil {
 		return nil, err
 	}
@@ -113,6 +104,38 @@ func (scm *SiteCertManager) GetCertificate(hostname string) (*tls.Certificate, e
 	return cert, nil
 }
 
+// lookup finds a cached certificate for hostname, falling back to a cached wildcard covering it
+// so hosts under an already generated wildcard don't each get their own certificate
+func (scm *SiteCertManager) lookup(hostname string) *tls.Certificate {
+	keys := append([]string{hostname}, wildcardKeys(hostname)...)
+	for _, key := range keys {
+		if cert := scm.getFromCache(key); cert != nil {
+			return cert
+		}
+	}
+	for _, key := range keys {
+		if cert, err := scm.loadFromDisk(key); err == nil && cert != nil {
+			scm.addToCache(key, cert)
+			return cert
+		}
+	}
+	return nil
+}
+
+// wildcardKeys returns the cache keys of wildcard certificates valid for hostname: the wildcard of
+// its parent domain, and its own wildcard, which also lists the base name
+func wildcardKeys(hostname string) []string {
+	if strings.HasPrefix(hostname, "*.") || net.ParseIP(hostname) != nil {
+		return nil
+	}
+	keys := []string{"*." + hostname}
+	// A single-label parent (e.g. *.com) is never a usable wildcard
+	if _, parent, ok := strings.Cut(hostname, "."); ok && strings.Contains(parent, ".") {
+		keys = append([]string{"*." + parent}, keys...)
+	}
+	return keys
+}
+
 // getFromCache retrieves a certificate from memory cache
 func (scm *SiteCertManager) getFromCache(hostname string) *tls.Certificate {
 	scm.cache.mu.RLock()
@@ -123,7 +146,7 @@ func (scm *SiteCertManager) getFromCache(hostname string) *tls.Certificate {
 		return nil
 	}
 
-	if time.Now().After(cached.ExpiresAt) {
+	if scm.now().After(cached.ExpiresAt) {
 		scm.cache.mu.RUnlock()
 		scm.cache.mu.Lock()
 		delete(scm.cache.certs, hostname)
@@ -143,8 +166,89 @@ func (scm *SiteCertManager) addToCache(hostname string, cert *tls.Certificate) {
 
 	scm.cache.certs[hostname] = &CachedCert{
 		Cert:      cert,
-		ExpiresAt: time.Now().Add(scm.validity),
+		ExpiresAt: scm.now().Add(scm.validity),
+	}
+}
+
+// StartRenewal regenerates cached certificates every interval once they are within window
+// of expiry, so requests keep hitting the cache instead of regenerating on a miss
+func (scm *SiteCertManager) StartRenewal(window, interval time.Duration) {
+	if window <= 0 || interval <= 0 || scm.stopRenew != nil {
+		return
 	}
+	stop := make(chan struct{})
+	scm.stopRenew = stop
+	scm.renewWG.Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if n := scm.renewExpiring(window); n > 0 {
+					slog.Debug("Renewed site certificates", "count", n)
+				}
+			}
+		}
+	})
+}
+
+// StopRenewal stops the background renewal started by StartRenewal
+func (scm *SiteCertManager) StopRenewal() {
+	if scm.stopRenew == nil {
+		return
+	}
+	close(scm.stopRenew)
+	scm.renewWG.Wait()
+	scm.stopRenew = nil
+}
+
+// renewExpiring regenerates cached certificates expiring within window and returns how many were renewed
+func (scm *SiteCertManager) renewExpiring(window time.Duration) int {
+	deadline := scm.now().Add(window)
+
+	scm.cache.mu.RLock()
+	var hostnames []string
+	for hostname, cached := range scm.cache.certs {
+		if cached.ExpiresAt.Before(deadline) {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	scm.cache.mu.RUnlock()
+
+	renewed := 0
+	for _, hostname := range hostnames {
+		if scm.renew(hostname, deadline) {
+			renewed++
+		}
+	}
+	return renewed
+}
+
+// renew regenerates the certificate for hostname under the generation lock shared with
+// GetCertificate, skipping it if it was cleared or refreshed meanwhile
+func (scm *SiteCertManager) renew(hostname string, deadline time.Time) bool {
+	scm.mu.Lock()
+	defer scm.mu.Unlock()
+
+	scm.cache.mu.RLock()
+	cached, ok := scm.cache.certs[hostname]
+	scm.cache.mu.RUnlock()
+	if !ok || !cached.ExpiresAt.Before(deadline) {
+		return false
+	}
+
+	cert, err := scm.generateCertificate(hostname)
+	if err != nil {
+		slog.Warn("Failed to renew certificate", "hostname", hostname, "error", err)
+		return false
+	}
+	if err := scm.saveToDisk(hostname, cert); err != nil {
+		slog.Warn("Failed to save certificate to disk", "hostname", hostname, "error", err)
+	}
+	scm.addToCache(hostname, cert)
+	return true
 }
 
 // loadFromDisk loads a certificate from disk cache
@@ -271,6 +375,31 @@ func (scm *SiteCertManager) generateCertificate(hostname string) (*tls.Certifica
 	}, nil
 }
 
+// CachedCertInfo describes a certificate held in the memory cache
+type CachedCertInfo struct {
+	Hostname  string    `json:"hostname"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CachedCerts lists the unexpired certificates in the memory cache sorted by hostname
+func (scm *SiteCertManager) CachedCerts() []CachedCertInfo {
+	scm.cache.mu.RLock()
+	defer scm.cache.mu.RUnlock()
+
+	now := scm.now()
+	infos := make([]CachedCertInfo, 0, len(scm.cache.certs))
+	for hostname, cached := range scm.cache.certs {
+		if now.After(cached.ExpiresAt) {
+			continue
+		}
+		infos = append(infos, CachedCertInfo{Hostname: hostname, ExpiresAt: cached.ExpiresAt})
+	}
+	slices.SortFunc(infos, func(a, b CachedCertInfo) int {
+		return strings.Compare(a.Hostname, b.Hostname)
+	})
+	return infos
+}
+
 // ClearCache clears the in-memory certificate cache
 func (scm *SiteCertManager) ClearCache() {
 	scm.cache.mu.Lock()
@@ -9,9 +9,11 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"log/slog"
 	"math/big"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"testing"
 	"time"
@@ -240,6 +242,75 @@ func TestGetCertificate_Wildcard(t *testing.T) {
 	}
 }
 
+func TestGetCertificate_ReusesCachedWildcard(t *testing.T) {
+	caCert, caKey := generateTestCA(t)
+	tmpDir := t.TempDir()
+
+	scm, err := NewSiteCertManager(caCert, caKey, tmpDir, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create SiteCertManager: %v", err)
+	}
+
+	wildcard, err := scm.GetCertificate("*.example.com")
+	if err != nil {
+		t.Fatalf("failed to get wildcard certificate: %v", err)
+	}
+
+	for _, hostname := range []string{"a.example.com", "example.com"} {
+		cert, err := scm.GetCertificate(hostname)
+		if err != nil {
+			t.Fatalf("failed to get certificate for %s: %v", hostname, err)
+		}
+		if cert != wildcard {
+			t.Errorf("expected %s to reuse the wildcard certificate", hostname)
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, hostname+".crt")); !os.IsNotExist(err) {
+			t.Errorf("expected no certificate generated for %s", hostname)
+		}
+	}
+
+	// A wildcard only covers one label
+	deep, err := scm.GetCertificate("b.a.example.com")
+	if err != nil {
+		t.Fatalf("failed to get certificate: %v", err)
+	}
+	if deep == wildcard {
+		t.Error("expected b.a.example.com to get its own certificate")
+	}
+
+	// The wildcard on disk is found after the memory cache is cleared
+	scm.ClearCache()
+	cert, err := scm.GetCertificate("a.example.com")
+	if err != nil {
+		t.Fatalf("failed to get certificate: %v", err)
+	}
+	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	if err := x509Cert.VerifyHostname("a.example.com"); err != nil {
+		t.Errorf("expected reused certificate to be valid for a.example.com: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "a.example.com.crt")); !os.IsNotExist(err) {
+		t.Error("expected disk wildcard to be reused instead of generating a certificate")
+	}
+}
+
+func TestWildcardKeys(t *testing.T) {
+	tests := map[string][]string{
+		"a.example.com": {"*.example.com", "*.a.example.com"},
+		"example.com":   {"*.example.com"},
+		"localhost":     {"*.localhost"},
+		"*.example.com": nil,
+		"10.0.0.1":      nil,
+	}
+	for hostname, want := range tests {
+		if got := wildcardKeys(hostname); !slices.Equal(got, want) {
+			t.Errorf("wildcardKeys(%s) = %v, want %v", hostname, got, want)
+		}
+	}
+}
+
 func TestGetCertificate_InvalidCA(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -711,3 +782,79 @@ func certBytesEqual(a, b [][]byte) bool {
 	}
 	return true
 }
+
+func TestSiteCertManager_RenewBeforeExpiry(t *testing.T) {
+	caCert, caKey := generateTestCA(t)
+	scm, err := NewSiteCertManager(caCert, caKey, t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("NewSiteCertManager failed: %v", err)
+	}
+	now := time.Now()
+	scm.now = func() time.Time { return now }
+
+	original, err := scm.GetCertificate("renew.example.com")
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+
+	// Outside the window nothing is renewed
+	if n := scm.renewExpiring(10 * time.Minute); n != 0 {
+		t.Fatalf("Expected no renewal outside the window, got %d", n)
+	}
+
+	// Move into the renewal window
+	now = now.Add(55 * time.Minute)
+	if n := scm.renewExpiring(10 * time.Minute); n != 1 {
+		t.Fatalf("Expected 1 renewed certificate, got %d", n)
+	}
+
+	// Past the original expiry the cache still hits with the renewed certificate
+	now = now.Add(10 * time.Minute)
+	renewed := scm.getFromCache("renew.example.com")
+	if renewed == nil {
+		t.Fatal("Expected renewed certificate to be served from cache")
+	}
+	if renewed == original {
+		t.Error("Expected a new certificate after renewal")
+	}
+}
+
+func TestSiteCertManager_StartRenewal(t *testing.T) {
+	caCert, caKey := generateTestCA(t)
+	scm, err := NewSiteCertManager(caCert, caKey, t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("NewSiteCertManager failed: %v", err)
+	}
+	original, err := scm.GetCertificate("bg.example.com")
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+
+	// A window longer than the validity puts the certificate in it at once
+	scm.StartRenewal(2*time.Hour, 10*time.Millisecond)
+	defer scm.StopRenewal()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for scm.getFromCache("bg.example.com") == original {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected background renewal to replace the certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewManager_ClampsRenewWindow(t *testing.T) {
+	dir := t.TempDir()
+	// The default one-day renew window outlasts a shortened site cert validity
+	m, err := NewManager(ManagerConfig{
+		CACertPath:       dir + "/ca.crt",
+		CAKeyPath:        dir + "/ca.key",
+		CertCacheDir:     dir + "/certs",
+		SiteCertValidity: 12 * time.Hour,
+		CertRenewWindow:  24 * time.Hour,
+	}, slog.Default())
+	if err != nil {
+		t.Fatalf("Expected the renew window to be clamped, got %v", err)
+	}
+	m.Close()
+}
@@ -1,11 +1,14 @@
 package mitm
 
 import (
+	"encoding/hex"
 	"log/slog"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/monsterxx03/linko/pkg/mitm/llm"
 )
 
 type SSEInspector struct {
@@ -14,20 +17,85 @@ type SSEInspector struct {
 	logger       *slog.Logger
 	httpProc     HTTPProcessorInterface
 	requestCache sync.Map
+	openStreams  sync.Map // requestID -> *openStream, streaming responses not finished yet
+	stats        *TrafficStatsCollector
+	lifetime     *streamLifetime
+}
+
+// openStream remembers what the terminal event of a streaming response needs
+type openStream struct {
+	hostname string
+	request  *HTTPRequest
 }
 
 func NewSSEInspector(logger *slog.Logger, eventBus *EventBus, hostname string, maxBodySize int64) *SSEInspector {
 	if maxBodySize == 0 {
 		maxBodySize = DefaultMaxBodySize
 	}
+	proc := NewHTTPProcessor(logger, maxBodySize)
+	// Mask images before the body limit cuts them, the cut would leave them unrecognizable
+	proc.SetRequestBodyFilter(llm.MaskImageData)
 	return &SSEInspector{
 		BaseInspector: NewBaseInspector("sse_inspector", hostname),
 		eventBus:      eventBus,
 		logger:        logger,
-		httpProc:      NewHTTPProcessor(logger, maxBodySize),
+		httpProc:      proc,
+	}
+}
+
+// SetSkipBody disables request and/or response body capture in traffic events
+func (s *SSEInspector) SetSkipBody(request, response bool) {
+	if proc, ok := s.httpProc.(*HTTPProcessor); ok {
+		proc.SetSkipBody(request, response)
+	}
+}
+
+// SetMetadataOnly records traffic metadata without ever buffering or capturing bodies
+func (s *SSEInspector) SetMetadataOnly(enabled bool) {
+	if proc, ok := s.httpProc.(*HTTPProcessor); ok {
+		proc.SetMetadataOnly(enabled)
+	}
+}
+
+// SetRawBody adds the undecoded body bytes to traffic events alongside the decoded body
+func (s *SSEInspector) SetRawBody(enabled bool) {
+	if proc, ok := s.httpProc.(*HTTPProcessor); ok {
+		proc.SetRawBody(enabled)
+	}
+}
+
+// SetMaxBodySize sets separate request and response body capture limits, 0 keeps maxBodySize
+func (s *SSEInspector) SetMaxBodySize(request, response int64) {
+	if proc, ok := s.httpProc.(*HTTPProcessor); ok {
+		proc.SetMaxBodySize(request, response)
+	}
+}
+
+// SetMaxHeaderSize sets the header size past which a stream is passed through unparsed
+func (s *SSEInspector) SetMaxHeaderSize(size int64) {
+	if proc, ok := s.httpProc.(*HTTPProcessor); ok {
+		proc.SetMaxHeaderSize(size)
 	}
 }
 
+// SetMaxChunkedBodySize sets the chunked request body size past which the request is passed through unparsed
+func (s *SSEInspector) SetMaxChunkedBodySize(size int64) {
+	if proc, ok := s.httpProc.(*HTTPProcessor); ok {
+		proc.SetMaxChunkedBodySize(size)
+	}
+}
+
+// SetMaxStreamDuration finalizes streaming responses open longer than d and passes the rest of
+// them through uninspected, 0 = unlimited
+func (s *SSEInspector) SetMaxStreamDuration(d time.Duration) {
+	s.lifetime = newStreamLifetime(d)
+}
+
+// SetStatsCollector sets the collector fed with request and response body sizes
+func (s *SSEInspector) SetStatsCollector(stats *TrafficStatsCollector) {
+	s.stats = stats
+}
+
 func (s *SSEInspector) Inspect(direction Direction, data []byte, hostname string, connectionID, requestID string) ([]byte, error) {
 	if len(data) == 0 {
 		return data, nil
@@ -55,7 +123,10 @@ func (s *SSEInspector) inspectRequest(inputData []byte, requestID string) ([]byt
 	}
 
 	if complete {
-		s.cacheChunkedRequest(httpMsg, requestID)
+		httpReq := s.cacheChunkedRequest(httpMsg, requestID)
+		// Publish the request on its own so it stays visible if no response ever arrives,
+		// the paired event later reuses the same ID and replaces it in history
+		s.publishTrafficEvent(httpMsg.Hostname, requestID, DirectionClientToServer.String(), httpReq, nil, false)
 		s.httpProc.ClearPending(requestID)
 	}
 
@@ -63,13 +134,26 @@ func (s *SSEInspector) inspectRequest(inputData []byte, requestID string) ([]byt
 }
 
 func (s *SSEInspector) inspectResponse(inputData []byte, hostname string, requestID string) ([]byte, error) {
+	if d := s.lifetime.acquire(requestID); d != nil {
+		defer d.mu.Unlock()
+		if d.expired {
+			return inputData, nil
+		}
+	}
+
 	resultData, httpMsg, complete, err := s.httpProc.ProcessResponse(inputData, requestID)
 	if err != nil || httpMsg == nil {
 		return inputData, nil
 	}
 
-	if httpMsg.IsSSE {
-		return s.processSSEStream(httpMsg, hostname, requestID, resultData)
+	if httpMsg.IsStream() {
+		resultData, err = s.processSSEStream(httpMsg, hostname, requestID, resultData)
+		if complete {
+			s.finishStream(requestID, httpMsg)
+		} else {
+			s.lifetime.start(requestID, func() { s.expireStream(requestID) })
+		}
+		return resultData, err
 	}
 
 	if complete {
@@ -80,44 +164,68 @@ func (s *SSEInspector) inspectResponse(inputData []byte, hostname string, reques
 	return resultData, nil
 }
 
-func (s *SSEInspector) cacheChunkedRequest(httpMsg *HTTPMessage, requestID string) {
-	s.requestCache.Store(requestID, &HTTPRequest{
+func (s *SSEInspector) cacheChunkedRequest(httpMsg *HTTPMessage, requestID string) *HTTPRequest {
+	if s.stats != nil {
+		s.stats.RecordRequest(bodySize(httpMsg))
+	}
+	// Base64 images in LLM requests would dwarf the prompt text, RawBody keeps them if enabled.
+	// HTTPProcessor already masked complete bodies, this covers other processors and cut payloads
+	body, preview := eventBody(httpMsg, llm.MaskImageData(httpMsg.Body))
+	httpReq := &HTTPRequest{
 		Method:        httpMsg.Method,
 		URL:           httpMsg.Path,
+		Query:         httpMsg.Query,
+		FormFields:    httpMsg.FormFields,
 		Host:          httpMsg.Hostname,
 		Headers:       httpMsg.Headers,
-		Body:          string(httpMsg.Body),
+		Body:          body,
+		BodyPreview:   preview,
+		RawBody:       httpMsg.RawBody,
 		ContentType:   httpMsg.ContentType,
-		ContentLength: int64(len(httpMsg.Body)),
-	})
+		ContentLength: contentLength(httpMsg),
+		Trailers:      httpMsg.Trailers,
+	}
+	s.requestCache.Store(requestID, httpReq)
+	return httpReq
 }
 
 func (s *SSEInspector) processCompleteResponse(httpMsg *HTTPMessage, hostname string, requestID string) {
+	if s.stats != nil {
+		s.stats.RecordResponse(bodySize(httpMsg))
+	}
 	var httpReq *HTTPRequest
 	if val, exists := s.requestCache.LoadAndDelete(requestID); exists {
 		httpReq = val.(*HTTPRequest)
 	}
 
 	// Body is already decompressed by HTTPProcessor
-	bodyStr := string(httpMsg.Body)
+	bodyStr, preview := eventBody(httpMsg, httpMsg.Body)
 
 	httpResp := &HTTPResponse{
 		Status:        http.StatusText(httpMsg.StatusCode),
 		StatusCode:    httpMsg.StatusCode,
 		Headers:       httpMsg.Headers,
 		Body:          bodyStr,
+		BodyPreview:   preview,
+		RawBody:       httpMsg.RawBody,
 		ContentType:   httpMsg.ContentType,
-		ContentLength: int64(len(bodyStr)),
+		ContentLength: contentLength(httpMsg),
 		Latency:       0,
+		Trailers:      httpMsg.Trailers,
+		Cached:        httpMsg.Headers[CacheHeader] != "",
 	}
 
-	s.publishTrafficEvent(hostname, requestID, "", httpReq, httpResp)
+	s.publishTrafficEvent(hostname, requestID, "", httpReq, httpResp, httpReq != nil)
 }
 
 func (s *SSEInspector) processSSEStream(httpMsg *HTTPMessage, hostname string, requestID string, resultData []byte) ([]byte, error) {
+	// Only the first stream event still finds the request, and replaces its request-only event
 	var httpReq *HTTPRequest
 	if val, exists := s.requestCache.LoadAndDelete(requestID); exists {
 		httpReq = val.(*HTTPRequest)
+		s.openStreams.Store(requestID, &openStream{hostname: hostname, request: httpReq})
+	} else if _, exists := s.openStreams.Load(requestID); !exists {
+		s.openStreams.Store(requestID, &openStream{hostname: hostname})
 	}
 
 	// Body is already decompressed by HTTPProcessor
@@ -129,15 +237,84 @@ func (s *SSEInspector) processSSEStream(httpMsg *HTTPMessage, hostname string, r
 		Headers:       httpMsg.Headers,
 		Body:          bodyStr,
 		ContentType:   httpMsg.ContentType,
-		ContentLength: int64(len(bodyStr)),
+		ContentLength: contentLength(httpMsg),
 	}
 
-	s.publishTrafficEvent(hostname, requestID, DirectionServerToClient.String(), httpReq, httpResp)
+	s.publishTrafficEvent(hostname, requestID, DirectionServerToClient.String(), httpReq, httpResp, httpReq != nil)
 	// For SSE, return the accumulated data (resultData may be longer than bodyStr if there are multiple events)
 	return resultData, nil
 }
 
-func (s *SSEInspector) publishTrafficEvent(hostname, requestID, direction string, httpReq *HTTPRequest, httpResp *HTTPResponse) {
+// finishStream publishes the terminal "complete" event of a streaming response with its
+// accumulated body and releases the pending and decoder state
+func (s *SSEInspector) finishStream(requestID string, httpMsg *HTTPMessage) {
+	s.lifetime.stop(requestID)
+	val, exists := s.openStreams.LoadAndDelete(requestID)
+	if !exists {
+		return
+	}
+	stream := val.(*openStream)
+	if s.stats != nil {
+		s.stats.RecordResponse(bodySize(httpMsg))
+	}
+
+	httpResp := &HTTPResponse{
+		Status:        http.StatusText(httpMsg.StatusCode),
+		StatusCode:    httpMsg.StatusCode,
+		Headers:       httpMsg.Headers,
+		Body:          string(httpMsg.Body),
+		ContentType:   httpMsg.ContentType,
+		ContentLength: contentLength(httpMsg),
+		Trailers:      httpMsg.Trailers,
+	}
+	s.publishTrafficEvent(stream.hostname, requestID, "complete", stream.request, httpResp, false)
+	s.ClearPending(requestID)
+}
+
+// Finalize ends the streaming and close-delimited responses of a closed connection, which would
+// otherwise never see a complete message since their bodies have no length
+func (s *SSEInspector) Finalize(connectionID string) {
+	s.lifetime.forget(connectionID)
+	s.openStreams.Range(func(key, _ any) bool {
+		requestID := key.(string)
+		if s.extractConnectionID(requestID) == connectionID {
+			s.endStream(requestID)
+		}
+		return true
+	})
+	// Responses without a length end with the connection
+	if proc, ok := s.httpProc.(*HTTPProcessor); ok {
+		for requestID, httpMsg := range proc.CloseDelimited(connectionID) {
+			hostname := ""
+			if val, ok := s.requestCache.Load(requestID); ok {
+				hostname = val.(*HTTPRequest).Host
+			}
+			s.processCompleteResponse(httpMsg, hostname, requestID)
+		}
+	}
+	s.httpProc.ClearConnection(connectionID)
+}
+
+// expireStream finalizes a streaming response open longer than the max stream duration
+func (s *SSEInspector) expireStream(requestID string) {
+	s.logger.Warn("stream exceeded max duration, passing through uninspected",
+		"request_id", requestID, "max_duration", s.lifetime.max)
+	s.endStream(requestID)
+}
+
+// endStream finishes a stream with what it received so far
+func (s *SSEInspector) endStream(requestID string) {
+	if httpMsg, ok := s.httpProc.GetPendingMessage(requestID); ok && httpMsg != nil {
+		s.finishStream(requestID, httpMsg)
+	} else {
+		s.openStreams.Delete(requestID)
+		s.ClearPending(requestID)
+	}
+}
+
+// publishTrafficEvent publishes an event for requestID, replace makes it take the place of the
+// request-only event in history
+func (s *SSEInspector) publishTrafficEvent(hostname, requestID, direction string, httpReq *HTTPRequest, httpResp *HTTPResponse, replace bool) {
 	event := &TrafficEvent{
 		ID:           requestID,
 		Timestamp:    time.Now(),
@@ -148,6 +325,10 @@ func (s *SSEInspector) publishTrafficEvent(hostname, requestID, direction string
 		Response:     httpResp,
 		Hostname:     hostname,
 	}
+	if replace {
+		s.eventBus.Replace(event)
+		return
+	}
 	s.eventBus.Publish(event)
 }
 
@@ -163,6 +344,41 @@ func (s *SSEInspector) ClearPending(requestID string) {
 	s.requestCache.Delete(requestID)
 }
 
+// contentLength returns the captured body length, or the wire size when the body was skipped
+func contentLength(httpMsg *HTTPMessage) int64 {
+	if httpMsg.Body == nil {
+		return httpMsg.BodySize
+	}
+	return int64(len(httpMsg.Body))
+}
+
+// bodySize returns the size of the body on the wire, so bodies over the capture limit are counted in full
+func bodySize(httpMsg *HTTPMessage) int64 {
+	if httpMsg.BodySize > 0 {
+		return httpMsg.BodySize
+	}
+	return int64(len(httpMsg.Body))
+}
+
+// binaryPreviewSize is the number of leading bytes of a binary body kept in its preview
+const binaryPreviewSize = 64
+
+// eventBody returns body as event text, binary bodies are replaced by a bounded preview
+func eventBody(httpMsg *HTTPMessage, body []byte) (string, *BodyPreview) {
+	if len(body) == 0 || !isBinaryBody(httpMsg.ContentType, body) {
+		return string(body), nil
+	}
+	contentType := httpMsg.ContentType
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	return "", &BodyPreview{
+		ContentType: contentType,
+		Size:        max(httpMsg.BodySize, int64(len(body))),
+		Head:        hex.EncodeToString(body[:min(len(body), binaryPreviewSize)]),
+	}
+}
+
 // GetRequestCache returns the request cache for other inspectors to access
 func (s *SSEInspector) GetRequestCache() *sync.Map {
 	return &s.requestCache
@@ -3,8 +3,12 @@ package mitm
 import (
 	"bytes"
 	"compress/gzip"
+	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 // mockSSEHTTPProcessor implements HTTPProcessorInterface for SSE inspector testing
@@ -36,6 +40,10 @@ func (m *mockSSEHTTPProcessor) ClearPending(requestID string) {
 	// No-op for mock
 }
 
+func (m *mockSSEHTTPProcessor) ClearConnection(connectionID string) {
+	// No-op for mock
+}
+
 func (m *mockSSEHTTPProcessor) GetPendingMessage(requestID string) (*HTTPMessage, bool) {
 	return nil, false
 }
@@ -337,3 +345,468 @@ func TestSSEInspector_CompressedRequest(t *testing.T) {
 		t.Error("Expected request to be cached")
 	}
 }
+
+func TestSSEInspector_SkipResponseBody(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	inspector := NewSSEInspector(logger, eventBus, "", 1024*1024)
+	inspector.SetSkipBody(false, true)
+	requestID := "test-skip-1"
+
+	requestData := []byte("POST /api HTTP/1.1\r\nHost: example.com\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nHello")
+	_, _ = inspector.Inspect(DirectionClientToServer, requestData, "example.com", "test-skip", requestID)
+
+	responseData := []byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 12\r\n\r\nHello Server")
+	_, _ = inspector.Inspect(DirectionServerToClient, responseData, "example.com", "test-skip", requestID)
+
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+
+	// Skip the request-only event published before the response
+	var event *TrafficEvent
+	for event == nil || event.Response == nil {
+		select {
+		case event = <-sub.Channel:
+		case <-time.After(time.Second):
+			t.Fatal("Expected traffic event to be published")
+		}
+	}
+
+	if event.Request == nil || event.Request.Body != "Hello" {
+		t.Errorf("Expected request body 'Hello', got %+v", event.Request)
+	}
+	if event.Response == nil {
+		t.Fatal("Expected response in traffic event")
+	}
+	if event.Response.Body != "" {
+		t.Errorf("Expected empty response body, got '%s'", event.Response.Body)
+	}
+	if event.Response.StatusCode != 200 {
+		t.Errorf("Expected status 200, got %d", event.Response.StatusCode)
+	}
+	if event.Response.ContentLength != 12 {
+		t.Errorf("Expected content length 12, got %d", event.Response.ContentLength)
+	}
+	if event.Response.Headers["Content-Type"] != "text/plain" {
+		t.Errorf("Expected Content-Type header to be recorded, got %v", event.Response.Headers)
+	}
+}
+
+func TestSSEInspector_RequestWithoutResponse(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewSSEInspector(logger, eventBus, "", 1024*1024)
+	requestID := "test-noresp-1"
+
+	requestData := []byte("POST /api HTTP/1.1\r\nHost: example.com\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nHello")
+	_, _ = inspector.Inspect(DirectionClientToServer, requestData, "example.com", "test-noresp", requestID)
+
+	var event *TrafficEvent
+	select {
+	case event = <-sub.Channel:
+	case <-time.After(time.Second):
+		t.Fatal("Expected request event to be published without a response")
+	}
+
+	if event.ID != requestID {
+		t.Errorf("Expected event ID %s, got %s", requestID, event.ID)
+	}
+	if event.Direction != DirectionClientToServer.String() {
+		t.Errorf("Expected direction %s, got %s", DirectionClientToServer, event.Direction)
+	}
+	if event.Request == nil || event.Request.Method != "POST" || event.Request.Body != "Hello" {
+		t.Errorf("Expected captured POST request with body 'Hello', got %+v", event.Request)
+	}
+	if event.Response != nil {
+		t.Errorf("Expected no response, got %+v", event.Response)
+	}
+}
+
+func TestSSEInspector_GzipSSEIncremental(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewSSEInspector(logger, eventBus, "", 1024*1024)
+	requestID := "test-gzsse-1"
+
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	gw.Write([]byte("data: {\"text\":\"hello\"}\n\n"))
+	gw.Flush()
+	first := bytes.Clone(compressed.Bytes())
+	gw.Write([]byte("data: {\"text\":\"world\"}\n\n"))
+	gw.Close()
+	second := compressed.Bytes()[len(first):]
+
+	headers := "HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nContent-Encoding: gzip\r\n\r\n"
+
+	nextBody := func() string {
+		t.Helper()
+		for {
+			select {
+			case event := <-sub.Channel:
+				if event.Response != nil {
+					return event.Response.Body
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Expected SSE traffic event to be published")
+			}
+		}
+	}
+
+	inspector.Inspect(DirectionServerToClient, append([]byte(headers), first...), "example.com", "test-gzsse", requestID)
+	if body := nextBody(); body != "data: {\"text\":\"hello\"}\n\n" {
+		t.Errorf("Expected first event decoded after first chunk, got %q", body)
+	}
+
+	inspector.Inspect(DirectionServerToClient, second, "example.com", "test-gzsse", requestID)
+	if body := nextBody(); body != "data: {\"text\":\"hello\"}\n\ndata: {\"text\":\"world\"}\n\n" {
+		t.Errorf("Expected both events decoded after second chunk, got %q", body)
+	}
+
+	inspector.ClearPending(requestID)
+}
+
+func TestSSEInspector_RequestEventReplacedInHistory(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	inspector := NewSSEInspector(logger, eventBus, "", 1024*1024)
+	requestID := "test-history-1"
+
+	requestData := []byte("POST /api HTTP/1.1\r\nHost: example.com\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nHello")
+	responseData := []byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 2\r\n\r\nOK")
+	inspector.Inspect(DirectionClientToServer, requestData, "example.com", "test-history", requestID)
+	inspector.Inspect(DirectionServerToClient, responseData, "example.com", "test-history", requestID)
+
+	// A later subscriber replays one entry per request, the request-only event was replaced
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	var events []*TrafficEvent
+	timeout := time.After(200 * time.Millisecond)
+collect:
+	for {
+		select {
+		case ev := <-sub.Channel:
+			events = append(events, ev)
+		case <-timeout:
+			break collect
+		}
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expected one history entry for the request, got %d", len(events))
+	}
+	if events[0].Request == nil || events[0].Response == nil || events[0].Response.Body != "OK" {
+		t.Errorf("Expected the paired request and response, got %+v", events[0])
+	}
+}
+
+func TestSSEInspector_MasksImageData(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	inspector := NewSSEInspector(logger, eventBus, "", 1024*1024)
+
+	image := strings.Repeat("A", 4000)
+	body := `{"model":"claude-sonnet-4","messages":[{"role":"user","content":[{"type":"image","source":{"type":"base64","media_type":"image/jpeg","data":"` + image + `"}},{"type":"text","text":"Describe this image"}]}]}`
+	mockProc := newMockSSEHTTPProcessor(t)
+	mockProc.processRequestFunc = func(data []byte, requestID string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{
+			Hostname:    "api.anthropic.com",
+			Path:        "/v1/messages",
+			Method:      "POST",
+			ContentType: "application/json",
+			Body:        []byte(body),
+		}, true, nil
+	}
+	inspector.httpProc = mockProc
+
+	inspector.Inspect(DirectionClientToServer, []byte("request"), "api.anthropic.com", "test-img", "test-img-1")
+
+	val, ok := inspector.requestCache.Load("test-img-1")
+	if !ok {
+		t.Fatal("Expected request to be cached")
+	}
+	captured := val.(*HTTPRequest).Body
+	if strings.Contains(captured, image) {
+		t.Error("Expected image data to be elided from the captured body")
+	}
+	if !strings.Contains(captured, "<image: image/jpeg, 3000 bytes>") || !strings.Contains(captured, "Describe this image") {
+		t.Errorf("Expected placeholder and prompt text in captured body, got %s", captured)
+	}
+}
+
+func TestSSEInspector_FinalizeOpenStream(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewSSEInspector(logger, eventBus, "", 1024*1024)
+	requestID := "conn-final-1"
+
+	inspector.Inspect(DirectionClientToServer, []byte("GET /events HTTP/1.1\r\nHost: example.com\r\n\r\n"), "example.com", "conn-final", requestID)
+	// No Content-Length or chunked encoding, the stream only ends when the connection closes
+	inspector.Inspect(DirectionServerToClient, []byte("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\n\r\ndata: one\n\n"), "example.com", "conn-final", requestID)
+	inspector.Inspect(DirectionServerToClient, []byte("data: two\n\ndata: thr"), "example.com", "conn-final", requestID)
+
+	// Streams of other connections are left alone
+	inspector.Finalize("conn-other")
+	inspector.Finalize("conn-final")
+
+	var final *TrafficEvent
+	timeout := time.After(time.Second)
+	for final == nil {
+		select {
+		case event := <-sub.Channel:
+			if event.Direction == "complete" {
+				final = event
+			}
+		case <-timeout:
+			t.Fatal("Expected a complete event after Finalize")
+		}
+	}
+
+	if final.Response == nil || final.Response.Body != "data: one\n\ndata: two\n\ndata: thr" {
+		t.Errorf("Expected accumulated body in complete event, got %+v", final.Response)
+	}
+	if final.Request == nil || final.Request.URL != "/events" {
+		t.Errorf("Expected request in complete event, got %+v", final.Request)
+	}
+	if _, ok := inspector.httpProc.GetPendingMessage(requestID); ok {
+		t.Error("Expected pending response to be cleared")
+	}
+
+	// A second Finalize has nothing left to publish
+	inspector.Finalize("conn-final")
+	select {
+	case event := <-sub.Channel:
+		t.Errorf("Expected no further events, got %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSSEInspector_ChunkedStreamCompletes(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewSSEInspector(logger, eventBus, "", 1024*1024)
+	requestID := "conn-chunked-1"
+
+	inspector.Inspect(DirectionClientToServer, []byte("GET /events HTTP/1.1\r\nHost: example.com\r\n\r\n"), "example.com", "conn-chunked", requestID)
+	inspector.Inspect(DirectionServerToClient, []byte("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nTransfer-Encoding: chunked\r\n\r\nb\r\ndata: one\n\n\r\n"), "example.com", "conn-chunked", requestID)
+	// The last chunk ends the stream without waiting for the connection to close
+	inspector.Inspect(DirectionServerToClient, []byte("0\r\n\r\n"), "example.com", "conn-chunked", requestID)
+
+	var final *TrafficEvent
+	timeout := time.After(time.Second)
+	for final == nil {
+		select {
+		case event := <-sub.Channel:
+			if event.Direction == "complete" {
+				final = event
+			}
+		case <-timeout:
+			t.Fatal("Expected a complete event after the last chunk")
+		}
+	}
+	if final.Response == nil || final.Response.Body != "data: one\n\n" {
+		t.Errorf("Expected stream body in complete event, got %+v", final.Response)
+	}
+	if _, open := inspector.openStreams.Load(requestID); open {
+		t.Error("Expected the complete stream to be released")
+	}
+}
+
+func TestSSEInspector_BinaryBodyPreview(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	inspector := NewSSEInspector(logger, eventBus, "", 1024*1024)
+	requestID := "test-binary-1"
+
+	// PNG signature followed by enough bytes to exceed the preview
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0xff}, 4096)...)
+	requestData := []byte("GET /logo.png HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	_, _ = inspector.Inspect(DirectionClientToServer, requestData, "example.com", "test-binary", requestID)
+	responseData := append([]byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: image/png\r\nContent-Length: %d\r\n\r\n", len(png))), png...)
+	_, _ = inspector.Inspect(DirectionServerToClient, responseData, "example.com", "test-binary", requestID)
+
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+
+	var event *TrafficEvent
+	for event == nil || event.Response == nil {
+		select {
+		case event = <-sub.Channel:
+		case <-time.After(time.Second):
+			t.Fatal("Expected traffic event to be published")
+		}
+	}
+
+	if event.Response.Body != "" {
+		t.Errorf("Expected binary body to be left out, got %d bytes", len(event.Response.Body))
+	}
+	preview := event.Response.BodyPreview
+	if preview == nil {
+		t.Fatal("Expected a body preview for the binary response")
+	}
+	if preview.ContentType != "image/png" || preview.Size != int64(len(png)) {
+		t.Errorf("Expected image/png of %d bytes, got %+v", len(png), preview)
+	}
+	if len(preview.Head) != 2*binaryPreviewSize || !strings.HasPrefix(preview.Head, "89504e470d0a1a0a") {
+		t.Errorf("Expected hex preview of the first %d bytes, got %q", binaryPreviewSize, preview.Head)
+	}
+}
+
+func TestIsBinaryBody(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        bool
+	}{
+		{"application/json", `{"a":1}`, false},
+		{"text/html; charset=utf-8", "<p>hi</p>", false},
+		{"image/png", "\x89PNG", true},
+		{"application/x-protobuf", "\x08\x01", true},
+		{"application/graphql", "query { viewer { login } }", false},
+		{"application/x-javascript; charset=utf-8", "var a = 1;\n", false},
+		{"application/octet-stream", "\x00\x01\x02", true},
+		{"", "plain text", false},
+		{"", "\xff\xfe\x00", true},
+	}
+	for _, tt := range tests {
+		if got := isBinaryBody(tt.contentType, []byte(tt.body)); got != tt.want {
+			t.Errorf("isBinaryBody(%q, %q) = %v, want %v", tt.contentType, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestSSEInspector_MaxStreamDuration(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.SubscribeWithName("test", 1000)
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewSSEInspector(logger, eventBus, "", 1024*1024)
+	inspector.SetMaxStreamDuration(50 * time.Millisecond)
+	requestID := "conn-endless-1"
+
+	inspector.Inspect(DirectionClientToServer, []byte("GET /events HTTP/1.1\r\nHost: example.com\r\n\r\n"), "example.com", "conn-endless", requestID)
+	inspector.Inspect(DirectionServerToClient, []byte("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\n\r\ndata: tick\n\n"), "example.com", "conn-endless", requestID)
+
+	// The server never ends the stream
+	stop := make(chan struct{})
+	fed := make(chan struct{})
+	go func() {
+		defer close(fed)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+				inspector.Inspect(DirectionServerToClient, []byte("data: tick\n\n"), "example.com", "conn-endless", requestID)
+			}
+		}
+	}()
+
+	var final *TrafficEvent
+	timeout := time.After(2 * time.Second)
+	for final == nil {
+		select {
+		case event := <-sub.Channel:
+			if event.Direction == "complete" {
+				final = event
+			}
+		case <-timeout:
+			close(stop)
+			t.Fatal("Expected the stream to be finalized after the max duration")
+		}
+	}
+	close(stop)
+	<-fed
+
+	if final.Response == nil || !strings.HasPrefix(final.Response.Body, "data: tick\n\n") {
+		t.Errorf("Expected accumulated body in complete event, got %+v", final.Response)
+	}
+	if _, ok := inspector.openStreams.Load(requestID); ok {
+		t.Error("Expected the stream state to be released")
+	}
+
+	// The rest of the stream passes through without events or new state
+	data := []byte("data: late\n\n")
+	if out, _ := inspector.Inspect(DirectionServerToClient, data, "example.com", "conn-endless", requestID); !bytes.Equal(out, data) {
+		t.Errorf("Expected data to pass through unchanged, got %q", out)
+	}
+	if _, ok := inspector.httpProc.GetPendingMessage(requestID); ok {
+		t.Error("Expected no pending response after expiry")
+	}
+	drain := time.After(50 * time.Millisecond)
+	for drained := false; !drained; {
+		select {
+		case event := <-sub.Channel:
+			if event.Response != nil && strings.Contains(event.Response.Body, "late") {
+				t.Errorf("Expected no event after expiry, got %+v", event)
+			}
+		case <-drain:
+			drained = true
+		}
+	}
+
+	inspector.Finalize("conn-endless")
+	if d := inspector.lifetime.acquire(requestID); d != nil {
+		d.mu.Unlock()
+		t.Error("Expected Finalize to drop the expired stream")
+	}
+}
+
+func TestSSEInspector_MasksImageBeforeTruncating(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	inspector := NewSSEInspector(logger, eventBus, "", 1024)
+
+	image := strings.Repeat("A", 4000)
+	body := `{"model":"claude-sonnet-4","messages":[{"role":"user","content":[{"type":"image","source":{"type":"base64","media_type":"image/jpeg","data":"` + image + `"}},{"type":"text","text":"Describe this image"}]}]}`
+	request := "POST /v1/messages HTTP/1.1\r\nHost: api.anthropic.com\r\nContent-Type: application/json\r\nContent-Length: " +
+		strconv.Itoa(len(body)) + "\r\n\r\n" + body
+
+	inspector.Inspect(DirectionClientToServer, []byte(request), "api.anthropic.com", "test-img", "test-img-1")
+
+	val, ok := inspector.requestCache.Load("test-img-1")
+	if !ok {
+		t.Fatal("Expected request to be cached")
+	}
+	// The body is over the limit only because of the image, so the whole prompt survives masking
+	captured := val.(*HTTPRequest).Body
+	if !strings.Contains(captured, "<image: image/jpeg, 3000 bytes>") || !strings.Contains(captured, "Describe this image") {
+		t.Errorf("Expected placeholder and prompt text in captured body, got %s", captured)
+	}
+}
+
+func TestSSEInspector_CloseDelimitedResponseFinalized(t *testing.T) {
+	logger := slog.Default()
+	eventBus := NewEventBus(logger, 10)
+	sub := eventBus.Subscribe()
+	defer eventBus.Unsubscribe(sub)
+	inspector := NewSSEInspector(logger, eventBus, "", 1024*1024)
+	requestID := "test-close-1"
+
+	inspector.Inspect(DirectionClientToServer, []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), "example.com", "test-close", requestID)
+	inspector.Inspect(DirectionServerToClient, []byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nhello world\n"), "example.com", "test-close", requestID)
+	inspector.Inspect(DirectionServerToClient, []byte("second line\n"), "example.com", "test-close", requestID)
+	inspector.Finalize("test-close")
+
+	var event *TrafficEvent
+	for event == nil || event.Response == nil {
+		select {
+		case event = <-sub.Channel:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the response to be published when the connection closes")
+		}
+	}
+	if event.Response.Body != "hello world\nsecond line\n" {
+		t.Errorf("Expected the whole body, got %q", event.Response.Body)
+	}
+	if event.Hostname != "example.com" {
+		t.Errorf("Expected hostname example.com, got %q", event.Hostname)
+	}
+}
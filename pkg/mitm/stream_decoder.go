@@ -0,0 +1,162 @@
+package mitm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+// streamDecoder decompresses a gzip stream incrementally as chunks arrive.
+// gzip.Reader errors are sticky, so it reads from a source that blocks for more
+// input instead of returning EOF, running in its own goroutine until the stream ends.
+type streamDecoder struct {
+	in      chan []byte   // compressed chunks handed to the decoder goroutine
+	idle    chan struct{} // signalled when the decoder has drained its input
+	done    chan struct{} // closed when the decoder goroutine exits
+	quit    chan struct{} // closed by Close to abandon the stream
+	waiting bool          // decoder is blocked waiting for the next chunk
+	limit   int64         // cap on buffered output, 0 = unlimited
+
+	mu     sync.Mutex
+	out    bytes.Buffer
+	err    error
+	closed bool
+}
+
+// newStreamDecoder starts a gzip decoder, output beyond limit bytes is discarded
+func newStreamDecoder(limit int64) *streamDecoder {
+	d := &streamDecoder{
+		in:    make(chan []byte),
+		idle:  make(chan struct{}),
+		done:  make(chan struct{}),
+		quit:  make(chan struct{}),
+		limit: limit,
+	}
+	go d.run()
+	return d
+}
+
+func (d *streamDecoder) run() {
+	defer close(d.done)
+
+	gz, err := gzip.NewReader(&chunkSource{d: d})
+	if err != nil {
+		d.setErr(err)
+		return
+	}
+	// A single member is expected, stop at its end instead of waiting for another header
+	gz.Multistream(false)
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := gz.Read(buf)
+		if n > 0 {
+			d.mu.Lock()
+			if d.limit <= 0 || int64(d.out.Len()) < d.limit {
+				d.out.Write(buf[:n])
+			}
+			d.mu.Unlock()
+		}
+		if err != nil {
+			if err != io.EOF {
+				d.setErr(err)
+			}
+			return
+		}
+	}
+}
+
+func (d *streamDecoder) setErr(err error) {
+	d.mu.Lock()
+	d.err = err
+	d.mu.Unlock()
+}
+
+// Feed passes newly arrived compressed bytes and waits until the decoder has
+// consumed them, so Output reflects everything decodable so far
+func (d *streamDecoder) Feed(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	chunk := bytes.Clone(data)
+
+	if !d.waiting {
+		select {
+		case <-d.idle:
+		case <-d.done:
+			return
+		}
+	}
+	d.waiting = false
+
+	select {
+	case d.in <- chunk:
+	case <-d.done:
+		return
+	}
+
+	select {
+	case <-d.idle:
+		d.waiting = true
+	case <-d.done:
+	}
+}
+
+// Output returns a copy of the data decoded so far
+func (d *streamDecoder) Output() []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return bytes.Clone(d.out.Bytes())
+}
+
+// Discard drops the first n bytes of decoded output
+func (d *streamDecoder) Discard(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.out.Next(n)
+}
+
+// Err returns the decoding error, nil while the stream is healthy or ended cleanly
+func (d *streamDecoder) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+// Close stops the decoder goroutine if the stream was abandoned before its end
+func (d *streamDecoder) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	d.mu.Unlock()
+	close(d.quit)
+	<-d.done
+}
+
+// chunkSource feeds the gzip reader, blocking until the next chunk arrives
+type chunkSource struct {
+	d   *streamDecoder
+	cur []byte
+}
+
+func (s *chunkSource) Read(p []byte) (int, error) {
+	for len(s.cur) == 0 {
+		select {
+		case s.d.idle <- struct{}{}:
+		case <-s.d.quit:
+			return 0, io.ErrUnexpectedEOF
+		}
+		select {
+		case s.cur = <-s.d.in:
+		case <-s.d.quit:
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+	n := copy(p, s.cur)
+	s.cur = s.cur[n:]
+	return n, nil
+}
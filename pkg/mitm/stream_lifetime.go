@@ -0,0 +1,106 @@
+package mitm
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// streamLifetime bounds how long an inspected streaming response may stay open. Each stream
+// gets a timer when it starts, on expiry the stream is finalized and the rest of its data
+// passes through uninspected. A nil streamLifetime never expires anything
+type streamLifetime struct {
+	max     time.Duration
+	mu      sync.Mutex
+	streams map[string]*streamDeadline // requestID -> open or expired stream
+}
+
+// streamDeadline is the expiry state of one stream
+type streamDeadline struct {
+	mu      sync.Mutex // held while a chunk of the stream is inspected or the stream expires
+	timer   *time.Timer
+	expired bool // written holding both mutexes
+}
+
+// newStreamLifetime returns a streamLifetime expiring streams after max, nil if max is not positive
+func newStreamLifetime(max time.Duration) *streamLifetime {
+	if max <= 0 {
+		return nil
+	}
+	return &streamLifetime{max: max, streams: make(map[string]*streamDeadline)}
+}
+
+// acquire locks the stream of requestID against expiry while a chunk is inspected, it returns
+// nil for untracked requests. The caller unlocks d.mu and passes data through if d.expired
+func (s *streamLifetime) acquire(requestID string) *streamDeadline {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	d := s.streams[requestID]
+	s.mu.Unlock()
+	if d != nil {
+		d.mu.Lock()
+	}
+	return d
+}
+
+// start arms the timer of a stream once, expire runs on the timer goroutine with the stream locked
+func (s *streamLifetime) start(requestID string, expire func()) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.streams[requestID]; exists {
+		return
+	}
+	d := &streamDeadline{}
+	d.timer = time.AfterFunc(s.max, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if s.claim(requestID, d) {
+			expire()
+		}
+	})
+	s.streams[requestID] = d
+}
+
+// claim marks d expired if it is still the open stream of requestID
+func (s *streamLifetime) claim(requestID string, d *streamDeadline) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams[requestID] != d || d.expired {
+		return false
+	}
+	d.expired = true
+	return true
+}
+
+// stop disarms the timer of a stream that ended, expired streams are kept until forget
+func (s *streamLifetime) stop(requestID string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, exists := s.streams[requestID]; exists && !d.expired {
+		d.timer.Stop()
+		delete(s.streams, requestID)
+	}
+}
+
+// forget drops the streams of a closed connection
+func (s *streamLifetime) forget(connectionID string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for requestID, d := range s.streams {
+		if strings.HasPrefix(requestID, connectionID+"-") {
+			d.timer.Stop()
+			delete(s.streams, requestID)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package mitm
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// maxChunkLineSize bounds a chunk-size line, longer lines mean the framing is broken
+const maxChunkLineSize = 4096
+
+// streamWindow transfer-decodes a streaming body as it arrives and retains at most limit
+// bytes of it, so hours-long streams don't accumulate in memory. Incremental parsers
+// Discard what they have parsed, bytes arriving while the window is full are dropped.
+type streamWindow struct {
+	chunked   bool
+	line      []byte // partial chunk-size line
+	remaining int64  // data bytes left in the current chunk
+	skipCRLF  int    // bytes of the CRLF after chunk data still to skip
+	done      bool   // last chunk seen or framing broken, later bytes are ignored
+	ended     bool   // last chunk seen, the body is complete
+	body      []byte // retained decoded bytes
+	total     int64  // decoded bytes seen, retained or not
+	limit     int64  // max retained bytes, 0 keeps none
+}
+
+func newStreamWindow(chunked bool, limit int64) *streamWindow {
+	return &streamWindow{chunked: chunked, limit: limit}
+}
+
+// Write feeds transfer-encoded bytes
+func (w *streamWindow) Write(p []byte) {
+	if !w.chunked {
+		w.append(p)
+		return
+	}
+	for len(p) > 0 && !w.done {
+		switch {
+		case w.skipCRLF > 0:
+			n := min(w.skipCRLF, len(p))
+			w.skipCRLF -= n
+			p = p[n:]
+		case w.remaining > 0:
+			n := int(min(w.remaining, int64(len(p))))
+			w.append(p[:n])
+			w.remaining -= int64(n)
+			p = p[n:]
+			if w.remaining == 0 {
+				w.skipCRLF = 2
+			}
+		default:
+			idx := bytes.IndexByte(p, '\n')
+			if idx < 0 {
+				w.line = append(w.line, p...)
+				w.done = len(w.line) > maxChunkLineSize
+				return
+			}
+			w.line = append(w.line, p[:idx]...)
+			p = p[idx+1:]
+			size, ok := parseChunkSize(w.line)
+			w.line = w.line[:0]
+			if !ok || size == 0 {
+				w.done = true
+				w.ended = ok
+				return
+			}
+			w.remaining = size
+		}
+	}
+}
+
+func (w *streamWindow) append(p []byte) {
+	w.total += int64(len(p))
+	if room := w.limit - int64(len(w.body)); room > 0 {
+		w.body = append(w.body, p[:min(int64(len(p)), room)]...)
+	}
+}
+
+// Discard drops the first n retained bytes, reusing the buffer for later data
+func (w *streamWindow) Discard(n int) {
+	n = min(max(n, 0), len(w.body))
+	w.body = append(w.body[:0], w.body[n:]...)
+}
+
+// parseChunkSize parses a chunk-size line, ignoring chunk extensions
+func parseChunkSize(line []byte) (int64, bool) {
+	s := strings.TrimSpace(string(line))
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	size, err := strconv.ParseInt(s, 16, 64)
+	return size, err == nil && size >= 0
+}
@@ -0,0 +1,119 @@
+package mitm
+
+import (
+	"fmt"
+	"slices"
+	"sync/atomic"
+)
+
+// DefaultSizeBuckets are the upper bounds in bytes of the body size histogram buckets:
+// <1KB, 1-10KB, 10-100KB and >100KB
+var DefaultSizeBuckets = []int64{1 << 10, 10 << 10, 100 << 10}
+
+// HistogramBucket is a snapshot of one histogram bucket
+type HistogramBucket struct {
+	Label string `json:"label"`
+	Min   int64  `json:"min"`           // inclusive lower bound in bytes
+	Max   int64  `json:"max,omitempty"` // exclusive upper bound in bytes, 0 for the last bucket
+	Count uint64 `json:"count"`
+}
+
+// SizeHistogram counts body sizes into fixed buckets
+type SizeHistogram struct {
+	bounds []int64
+	counts []atomic.Uint64 // len(bounds)+1, last bucket is unbounded
+}
+
+// NewSizeHistogram creates a histogram with the given bucket upper bounds
+func NewSizeHistogram(bounds []int64) *SizeHistogram {
+	bounds = slices.Clone(bounds)
+	slices.Sort(bounds)
+	bounds = slices.Compact(bounds)
+	return &SizeHistogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+// Record adds a body size to its bucket
+func (h *SizeHistogram) Record(size int64) {
+	idx, found := slices.BinarySearch(h.bounds, size)
+	if found {
+		// Upper bounds are exclusive
+		idx++
+	}
+	h.counts[idx].Add(1)
+}
+
+// Snapshot returns the current bucket counts
+func (h *SizeHistogram) Snapshot() []HistogramBucket {
+	buckets := make([]HistogramBucket, len(h.counts))
+	var lower int64
+	for i := range h.counts {
+		b := HistogramBucket{Min: lower, Count: h.counts[i].Load()}
+		switch {
+		case len(h.bounds) == 0:
+			b.Label = "all"
+		case i == 0:
+			b.Max = h.bounds[i]
+			b.Label = "<" + formatSize(b.Max)
+		case i == len(h.bounds):
+			b.Label = ">" + formatSize(lower)
+		default:
+			b.Max = h.bounds[i]
+			b.Label = formatSize(lower) + "-" + formatSize(b.Max)
+		}
+		if i < len(h.bounds) {
+			lower = h.bounds[i]
+		}
+		buckets[i] = b
+	}
+	return buckets
+}
+
+// formatSize formats a byte count as B/KB/MB
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", n>>10)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+// TrafficStatsCollector keeps request and response body size histograms
+type TrafficStatsCollector struct {
+	request  *SizeHistogram
+	response *SizeHistogram
+}
+
+// NewTrafficStatsCollector creates a collector, DefaultSizeBuckets is used when buckets is empty
+func NewTrafficStatsCollector(buckets []int64) *TrafficStatsCollector {
+	if len(buckets) == 0 {
+		buckets = DefaultSizeBuckets
+	}
+	return &TrafficStatsCollector{
+		request:  NewSizeHistogram(buckets),
+		response: NewSizeHistogram(buckets),
+	}
+}
+
+// RecordRequest records a request body size
+func (c *TrafficStatsCollector) RecordRequest(size int64) {
+	c.request.Record(size)
+}
+
+// RecordResponse records a response body size
+func (c *TrafficStatsCollector) RecordResponse(size int64) {
+	c.response.Record(size)
+}
+
+// Histograms returns snapshots of the request and response histograms
+func (c *TrafficStatsCollector) Histograms() map[string]any {
+	return map[string]any{
+		"request":  c.request.Snapshot(),
+		"response": c.response.Snapshot(),
+	}
+}
@@ -0,0 +1,101 @@
+package mitm
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSizeHistogram_Buckets(t *testing.T) {
+	h := NewSizeHistogram(DefaultSizeBuckets)
+
+	for _, size := range []int64{0, 512, 1023, 1024, 5000, 10239, 10240, 50 << 10, 100 << 10, 1 << 20} {
+		h.Record(size)
+	}
+
+	want := []struct {
+		label string
+		count uint64
+	}{
+		{"<1KB", 3},
+		{"1KB-10KB", 3},
+		{"10KB-100KB", 2},
+		{">100KB", 2},
+	}
+
+	buckets := h.Snapshot()
+	if len(buckets) != len(want) {
+		t.Fatalf("Expected %d buckets, got %d", len(want), len(buckets))
+	}
+	for i, w := range want {
+		if buckets[i].Label != w.label || buckets[i].Count != w.count {
+			t.Errorf("bucket %d = %s:%d, want %s:%d", i, buckets[i].Label, buckets[i].Count, w.label, w.count)
+		}
+	}
+}
+
+func TestSizeHistogram_CustomBucketsUnsorted(t *testing.T) {
+	h := NewSizeHistogram([]int64{100, 10, 100})
+	h.Record(5)
+	h.Record(50)
+	h.Record(500)
+
+	buckets := h.Snapshot()
+	if len(buckets) != 3 {
+		t.Fatalf("Expected 3 buckets, got %d", len(buckets))
+	}
+	for i, b := range buckets {
+		if b.Count != 1 {
+			t.Errorf("bucket %s: expected 1, got %d", b.Label, b.Count)
+		}
+		if i > 0 && b.Min != buckets[i-1].Max {
+			t.Errorf("bucket %s: expected min %d, got %d", b.Label, buckets[i-1].Max, b.Min)
+		}
+	}
+}
+
+func TestSSEInspector_RecordsBodySizes(t *testing.T) {
+	logger := slog.Default()
+	inspector := NewSSEInspector(logger, NewEventBus(logger, 10), "", 1024*1024)
+	stats := NewTrafficStatsCollector(nil)
+	inspector.SetStatsCollector(stats)
+
+	mockProc := newMockSSEHTTPProcessor(t)
+	mockProc.processRequestFunc = func(data []byte, requestID string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{Method: "POST", Body: make([]byte, 2048)}, true, nil
+	}
+	mockProc.processResponseFunc = func(data []byte, requestID string) ([]byte, *HTTPMessage, bool, error) {
+		return data, &HTTPMessage{StatusCode: 200, BodySize: 200 << 10}, true, nil
+	}
+	inspector.httpProc = mockProc
+
+	inspector.Inspect(DirectionClientToServer, []byte("request"), "example.com", "conn-1", "conn-1-1")
+	inspector.Inspect(DirectionServerToClient, []byte("response"), "example.com", "conn-1", "conn-1-1")
+
+	histograms := stats.Histograms()
+	request := histograms["request"].([]HistogramBucket)
+	response := histograms["response"].([]HistogramBucket)
+	if request[1].Count != 1 {
+		t.Errorf("Expected request in 1KB-10KB bucket, got %+v", request)
+	}
+	if response[3].Count != 1 {
+		t.Errorf("Expected skipped response counted by wire size in >100KB bucket, got %+v", response)
+	}
+}
+
+func TestSSEInspector_RecordsTruncatedBodyFullSize(t *testing.T) {
+	logger := slog.Default()
+	inspector := NewSSEInspector(logger, NewEventBus(logger, 10), "", 1024)
+	stats := NewTrafficStatsCollector(nil)
+	inspector.SetStatsCollector(stats)
+
+	body := strings.Repeat("a", 20<<10)
+	request := fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	inspector.Inspect(DirectionClientToServer, []byte(request), "example.com", "conn-1", "conn-1-1")
+
+	buckets := stats.Histograms()["request"].([]HistogramBucket)
+	if buckets[2].Count != 1 {
+		t.Errorf("Expected body over the capture limit in 10KB-100KB bucket, got %+v", buckets)
+	}
+}
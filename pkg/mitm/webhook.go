@@ -0,0 +1,212 @@
+package mitm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WebhookConfig configures posting captured events to an external webhook
+type WebhookConfig struct {
+	URL        string        // Endpoint receiving each event as a JSON POST
+	SampleRate float64       // Fraction of events forwarded (0-1), 0 = all
+	QueueSize  int           // Events waiting for delivery, newer events are dropped when full, 0 = DefaultWebhookQueueSize
+	MaxRetries int           // Retries after a failed delivery
+	Backoff    time.Duration // Delay before the first retry, doubled for each following one, 0 = DefaultWebhookBackoff
+	Timeout    time.Duration // Timeout of a single delivery attempt, 0 = DefaultWebhookTimeout
+}
+
+// Webhook defaults used when the config leaves them unset
+const (
+	DefaultWebhookQueueSize = 1000
+	DefaultWebhookBackoff   = 500 * time.Millisecond
+	DefaultWebhookTimeout   = 10 * time.Second
+)
+
+// WebhookStats are the delivery counters of a WebhookForwarder
+type WebhookStats struct {
+	Sent    uint64 `json:"sent"`    // Events accepted by the webhook (2xx)
+	Failed  uint64 `json:"failed"`  // Events given up on after all retries
+	Dropped uint64 `json:"dropped"` // Events dropped because the queue was full
+}
+
+// WebhookForwarder subscribes to event buses and POSTs their events to a webhook
+type WebhookForwarder struct {
+	config   WebhookConfig
+	endpoint string // scheme://host of the URL, the path and query may carry secrets so only this is logged
+	client   *http.Client
+	logger   *slog.Logger
+	queue    chan *TrafficEvent
+	rand     func() float64
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	sent     atomic.Uint64
+	failed   atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+// NewWebhookForwarder validates config and creates a forwarder, Start begins delivery
+func NewWebhookForwarder(config WebhookConfig, logger *slog.Logger) (*WebhookForwarder, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL, want an absolute http or https URL")
+	}
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		return nil, fmt.Errorf("webhook sample rate %v must be between 0 and 1", config.SampleRate)
+	}
+	if config.MaxRetries < 0 {
+		return nil, fmt.Errorf("webhook max retries %d must not be negative", config.MaxRetries)
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultWebhookQueueSize
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = DefaultWebhookBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultWebhookTimeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookForwarder{
+		config:   config,
+		endpoint: u.Scheme + "://" + u.Host,
+		client:   &http.Client{Timeout: config.Timeout},
+		logger:   logger,
+		queue:    make(chan *TrafficEvent, config.QueueSize),
+		rand:     rand.Float64,
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// Start subscribes to buses and starts the delivery worker
+func (w *WebhookForwarder) Start(buses ...*EventBus) {
+	for _, bus := range buses {
+		subscriber := bus.SubscribeWithName("webhook", 0)
+		w.wg.Go(func() {
+			defer bus.Unsubscribe(subscriber)
+			for {
+				select {
+				case event, ok := <-subscriber.Channel:
+					if !ok {
+						return
+					}
+					w.enqueue(event)
+				case <-w.ctx.Done():
+					return
+				}
+			}
+		})
+	}
+	w.wg.Go(w.run)
+}
+
+// Stop stops delivery, events still queued are discarded
+func (w *WebhookForwarder) Stop() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+// Stats returns the delivery counters
+func (w *WebhookForwarder) Stats() WebhookStats {
+	return WebhookStats{
+		Sent:    w.sent.Load(),
+		Failed:  w.failed.Load(),
+		Dropped: w.dropped.Load(),
+	}
+}
+
+// enqueue queues a sampled event for delivery, dropping it when the queue is full
+func (w *WebhookForwarder) enqueue(event *TrafficEvent) {
+	if w.config.SampleRate > 0 && w.rand() >= w.config.SampleRate {
+		return
+	}
+	select {
+	case w.queue <- event:
+	default:
+		if w.dropped.Add(1) == 1 {
+			w.logger.Warn("webhook queue is full, dropping events", "url", w.endpoint, "queue_size", w.config.QueueSize)
+		}
+	}
+}
+
+// run delivers queued events one at a time until Stop
+func (w *WebhookForwarder) run() {
+	for {
+		select {
+		case event := <-w.queue:
+			w.deliver(event)
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver posts event, retrying with exponential backoff
+func (w *WebhookForwarder) deliver(event *TrafficEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		w.logger.Warn("failed to encode event for webhook", "event_id", event.ID, "error", err)
+		w.failed.Add(1)
+		return
+	}
+
+	backoff := w.config.Backoff
+	for attempt := 0; ; attempt++ {
+		if err = w.post(body); err == nil {
+			w.sent.Add(1)
+			return
+		}
+		if attempt >= w.config.MaxRetries {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-w.ctx.Done():
+			return
+		}
+	}
+	w.failed.Add(1)
+	w.logger.Warn("webhook delivery failed", "url", w.endpoint, "event_id", event.ID, "error", err)
+}
+
+// Endpoint returns the scheme and host of the webhook URL, safe to log
+func (w *WebhookForwarder) Endpoint() string {
+	return w.endpoint
+}
+
+// post sends a single delivery attempt, non-2xx statuses are errors
+func (w *WebhookForwarder) post(body []byte) error {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		// url.Error 会带上完整 URL，只保留底层错误
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package mitm
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookForwarder_DeliversEvents(t *testing.T) {
+	var mu sync.Mutex
+	var hosts []string
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first attempt to exercise the retry
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var event TrafficEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Failed to decode webhook body: %v", err)
+		}
+		mu.Lock()
+		hosts = append(hosts, event.Hostname)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	forwarder, err := NewWebhookForwarder(WebhookConfig{URL: server.URL, MaxRetries: 1, Backoff: time.Millisecond}, slog.Default())
+	if err != nil {
+		t.Fatalf("NewWebhookForwarder failed: %v", err)
+	}
+	trafficBus := NewEventBus(slog.Default(), 10)
+	llmBus := NewEventBus(slog.Default(), 10)
+	forwarder.Start(trafficBus, llmBus)
+	defer forwarder.Stop()
+
+	trafficBus.Publish(&TrafficEvent{Hostname: "traffic.example.com"})
+	waitFor(t, func() bool { return forwarder.Stats().Sent == 1 })
+	llmBus.Publish(&TrafficEvent{Hostname: "llm.example.com"})
+	waitFor(t, func() bool { return forwarder.Stats().Sent == 2 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(hosts) != 2 || hosts[0] != "traffic.example.com" || hosts[1] != "llm.example.com" {
+		t.Errorf("Expected events from both buses in order, got %v", hosts)
+	}
+	if stats := forwarder.Stats(); stats.Failed != 0 || stats.Dropped != 0 {
+		t.Errorf("Expected no failed or dropped events, got %+v", stats)
+	}
+}
+
+func TestWebhookForwarder_DropsOnOverflow(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	forwarder, err := NewWebhookForwarder(WebhookConfig{URL: server.URL, QueueSize: 1}, slog.Default())
+	if err != nil {
+		t.Fatalf("NewWebhookForwarder failed: %v", err)
+	}
+	bus := NewEventBus(slog.Default(), 10)
+	forwarder.Start(bus)
+	defer forwarder.Stop()
+
+	// One event is stuck in delivery and one waits in the queue, the rest overflow
+	const n = 5
+	for range n {
+		bus.Publish(&TrafficEvent{Hostname: "example.com"})
+	}
+	waitFor(t, func() bool { return forwarder.Stats().Dropped >= n-2 })
+	close(release)
+	waitFor(t, func() bool {
+		stats := forwarder.Stats()
+		return stats.Sent+stats.Dropped == n
+	})
+}
+
+func TestWebhookForwarder_LogsOnlyEndpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rawURL := server.URL + "/hooks/secret-path?token=secret-token"
+	server.Close()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	forwarder, err := NewWebhookForwarder(WebhookConfig{URL: rawURL, Backoff: time.Millisecond}, logger)
+	if err != nil {
+		t.Fatalf("NewWebhookForwarder failed: %v", err)
+	}
+	if forwarder.Endpoint() != server.URL {
+		t.Errorf("Expected endpoint %q, got %q", server.URL, forwarder.Endpoint())
+	}
+	bus := NewEventBus(slog.Default(), 10)
+	forwarder.Start(bus)
+	bus.Publish(&TrafficEvent{Hostname: "example.com"})
+	waitFor(t, func() bool { return forwarder.Stats().Failed == 1 })
+	// Stop waits for the delivery goroutine, so the log line is written
+	forwarder.Stop()
+
+	out := logs.String()
+	if !strings.Contains(out, "webhook delivery failed") {
+		t.Fatalf("Expected a delivery failure log, got %q", out)
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("Expected the URL path and query to stay out of logs, got %q", out)
+	}
+}
+
+func TestWebhookForwarder_Sampling(t *testing.T) {
+	forwarder, err := NewWebhookForwarder(WebhookConfig{URL: "http://127.0.0.1:1", SampleRate: 0.5}, slog.Default())
+	if err != nil {
+		t.Fatalf("NewWebhookForwarder failed: %v", err)
+	}
+	values := []float64{0.2, 0.7}
+	forwarder.rand = func() float64 {
+		v := values[0]
+		values = values[1:]
+		return v
+	}
+
+	forwarder.enqueue(&TrafficEvent{Hostname: "kept.example.com"})
+	forwarder.enqueue(&TrafficEvent{Hostname: "skipped.example.com"})
+	if got := len(forwarder.queue); got != 1 {
+		t.Errorf("Expected 1 sampled event queued, got %d", got)
+	}
+}
+
+func TestNewWebhookForwarder_InvalidConfig(t *testing.T) {
+	for _, config := range []WebhookConfig{
+		{URL: ""},
+		{URL: "ftp://example.com/hook"},
+		{URL: "http://example.com/hook", SampleRate: 1.5},
+		{URL: "http://example.com/hook", MaxRetries: -1},
+	} {
+		if _, err := NewWebhookForwarder(config, slog.Default()); err == nil {
+			t.Errorf("Expected error for %+v", config)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ACL decides which client addresses may use the proxy
+type ACL struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewACL builds an ACL from IPs or CIDRs. Deny entries take precedence, and an empty
+// allow list admits every address that is not denied.
+func NewACL(allow, deny []string) (*ACL, error) {
+	a := &ACL{}
+	var err error
+	if a.allow, err = parseNets(allow); err != nil {
+		return nil, fmt.Errorf("invalid allowed address: %w", err)
+	}
+	if a.deny, err = parseNets(deny); err != nil {
+		return nil, fmt.Errorf("invalid denied address: %w", err)
+	}
+	return a, nil
+}
+
+// parseNets parses each entry as a CIDR, or as a single IP
+func parseNets(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, err
+			}
+			nets = append(nets, ipNet)
+			continue
+		}
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not an IP or CIDR", entry)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, nil
+}
+
+// Allowed reports whether ip may use the proxy
+func (a *ACL) Allowed(ip net.IP) bool {
+	if a == nil {
+		return true
+	}
+	if containsIP(a.deny, ip) {
+		return false
+	}
+	return len(a.allow) == 0 || containsIP(a.allow, ip)
+}
+
+// AllowedAddr reports whether the remote address addr may use the proxy
+func (a *ACL) AllowedAddr(addr net.Addr) bool {
+	if a == nil {
+		return true
+	}
+	ip := addrIP(addr)
+	return ip != nil && a.Allowed(ip)
+}
+
+// addrIP returns the IP of a network address, nil when it has none
+func addrIP(addr net.Addr) net.IP {
+	switch v := addr.(type) {
+	case *net.TCPAddr:
+		return v.IP
+	case *net.UDPAddr:
+		return v.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,27 @@
 package proxy
 
 import (
+	"errors"
 	"log/slog"
+
+	"github.com/monsterxx03/linko/pkg/ipdb"
+)
+
+// GeoIP error policies, where TCP traffic goes when China IP ranges can't be loaded for skip_cn
+const (
+	GeoIPErrorUpstream = "upstream" // Redirect all TCP traffic to the proxy, China IPs included
+	GeoIPErrorDirect   = "direct"   // Redirect no TCP traffic, everything connects directly
 )
 
+// ValidGeoIPErrorPolicy reports whether policy is a supported GeoIP error policy, empty means upstream
+func ValidGeoIPErrorPolicy(policy string) bool {
+	switch policy {
+	case "", GeoIPErrorUpstream, GeoIPErrorDirect:
+		return true
+	}
+	return false
+}
+
 type FirewallManagerInterface interface {
 	SetupFirewallRules() error
 	CleanupFirewallRules() error
@@ -44,7 +62,9 @@ type FirewallManager struct {
 	reservedDomains   []string
 	resolvedDomainIPs []string
 	mitmGID           int
-	skipCN            bool // whether to skip China IP ranges in firewall rules
+	skipCN            bool   // whether to skip China IP ranges in firewall rules
+	onGeoIPError      string // GeoIPErrorUpstream or GeoIPErrorDirect when China IP ranges can't be loaded
+	loadChinaCIDRs    func() ([]string, error)
 	impl              FirewallManagerInterface
 }
 
@@ -58,11 +78,44 @@ func NewFirewallManager(proxyPort string, dnsServerPort string, cnDNS []string,
 		reservedDomains: reservedDomains,
 		mitmGID:         mitmGID,
 		skipCN:          skipCN,
+		onGeoIPError:    GeoIPErrorUpstream,
+		loadChinaCIDRs:  ipdb.GetChinaCIDRs,
 	}
 	fm.impl = newFirewallManagerImpl(fm)
 	return fm
 }
 
+// SetGeoIPErrorPolicy sets where TCP traffic goes when skip_cn can't load China IP ranges, empty means upstream
+func (fm *FirewallManager) SetGeoIPErrorPolicy(policy string) {
+	if policy == "" {
+		policy = GeoIPErrorUpstream
+	}
+	fm.onGeoIPError = policy
+}
+
+// chinaCIDRs returns the China IP ranges that bypass the proxy when skip_cn is on. If they can't be
+// loaded, redirectTCP tells whether TCP traffic is still redirected, per the GeoIP error policy.
+func (fm *FirewallManager) chinaCIDRs() (cidrs []string, redirectTCP bool) {
+	if !fm.skipCN {
+		return nil, true
+	}
+	cidrs, err := fm.loadChinaCIDRs()
+	if err == nil && len(cidrs) == 0 {
+		err = errors.New("no China IP ranges")
+	}
+	if err == nil {
+		return cidrs, true
+	}
+
+	if fm.onGeoIPError == GeoIPErrorDirect {
+		slog.Warn("China IP ranges unavailable, TCP traffic is not redirected and connects directly", "error", err)
+		return nil, false
+	}
+	slog.Warn("China IP ranges unavailable, all TCP traffic goes through the proxy", "error", err)
+	slog.Info("Run 'linko update-cn-ip' to download China IP data")
+	return nil, true
+}
+
 func (fm *FirewallManager) SetupFirewallRules() error {
 	return fm.impl.SetupFirewallRules()
 }
@@ -41,20 +41,10 @@ func (d *darwinFirewallManager) SetupFirewallRules() error {
 
 	if d.fm.skipCN {
 		slog.Info("loading China IP ranges...")
-		if err := ipdb.LoadChinaIPRanges(); err != nil {
-			slog.Warn("Failed to load cached China IP ranges", "error", err)
-			slog.Info("Run 'linko update-cn-ip' to download China IP data")
-		}
 	}
-
-	chinaCIDRs, _ := ipdb.GetChinaCIDRs()
+	chinaCIDRs, redirectTCP := d.fm.chinaCIDRs()
 	reservedCIDRs := ipdb.GetReservedCIDRs()
-	var allCIDRs []string
-	if d.fm.skipCN {
-		allCIDRs = append(reservedCIDRs, chinaCIDRs...)
-	} else {
-		allCIDRs = reservedCIDRs
-	}
+	allCIDRs := append(reservedCIDRs, chinaCIDRs...)
 	// 追加域名解析出的 IP
 	allCIDRs = append(allCIDRs, d.fm.resolvedDomainIPs...)
 	proxyPort := d.fm.proxyPort
@@ -67,7 +57,7 @@ func (d *darwinFirewallManager) SetupFirewallRules() error {
 		"forceProxyIPs", len(forceProxyIPs))
 
 	slog.Info("rendering firewall rules...")
-	ruleConfig, err := d.renderFirewallRules(proxyPort, dnsServerPort, cnDNS, pfTableName, pfForceTableName, allCIDRs, forceProxyIPs)
+	ruleConfig, err := d.renderFirewallRules(proxyPort, dnsServerPort, cnDNS, pfTableName, pfForceTableName, allCIDRs, forceProxyIPs, redirectTCP)
 	if err != nil {
 		return fmt.Errorf("failed to render firewall rules: %w", err)
 	}
@@ -112,16 +102,16 @@ type firewallRuleData struct {
 	ExtIf          string // 动态检测的默认网络接口
 }
 
-func (d *darwinFirewallManager) renderFirewallRules(proxyPort, dnsPort string, cnDNS []string, tableName string, forceTableName string, cidrs []string, forceProxyIPs []string) (string, error) {
-	// 构建重定向端口列表
+func (d *darwinFirewallManager) renderFirewallRules(proxyPort, dnsPort string, cnDNS []string, tableName string, forceTableName string, cidrs []string, forceProxyIPs []string, redirectTCP bool) (string, error) {
+	// 构建重定向端口列表，redirectTCP 为 false 时 TCP 全部直连
 	var redirectPorts []int
-	if d.fm.redirectOpt.RedirectHTTP {
+	if redirectTCP && d.fm.redirectOpt.RedirectHTTP {
 		redirectPorts = append(redirectPorts, 80)
 	}
-	if d.fm.redirectOpt.RedirectHTTPS {
+	if redirectTCP && d.fm.redirectOpt.RedirectHTTPS {
 		redirectPorts = append(redirectPorts, 443)
 	}
-	if d.fm.redirectOpt.RedirectSSH {
+	if redirectTCP && d.fm.redirectOpt.RedirectSSH {
 		redirectPorts = append(redirectPorts, 22)
 	}
 
@@ -35,7 +35,8 @@ func (l *linuxFirewallManager) SetupFirewallRules() error {
 		return fmt.Errorf("failed to enable IP forwarding: %w", err)
 	}
 
-	if err := l.createIPSet(); err != nil {
+	chinaCIDRs, redirectTCP := l.fm.chinaCIDRs()
+	if err := l.createIPSet(chinaCIDRs); err != nil {
 		return fmt.Errorf("failed to create ipset: %w", err)
 	}
 
@@ -59,7 +60,8 @@ func (l *linuxFirewallManager) SetupFirewallRules() error {
 		rules = append(rules, fmt.Sprintf("iptables -t nat -A OUTPUT -p udp --dport 53 -j REDIRECT --to-port %s", dnsServerPort))
 	}
 
-	if l.fm.redirectOpt.RedirectHTTP {
+	// GeoIP error policy direct: TCP 全部直连，不重定向到代理
+	if redirectTCP && l.fm.redirectOpt.RedirectHTTP {
 		rules = append(rules,
 			fmt.Sprintf("iptables -t nat -A OUTPUT -p tcp --dport 443 -m set --match-set %s dst -j ACCEPT", ipsetForceName),
 			fmt.Sprintf("iptables -t nat -A OUTPUT -p tcp --dport 80 -m set --match-set %s dst -j ACCEPT", ipsetName),
@@ -67,7 +69,7 @@ func (l *linuxFirewallManager) SetupFirewallRules() error {
 		)
 	}
 
-	if l.fm.redirectOpt.RedirectHTTPS {
+	if redirectTCP && l.fm.redirectOpt.RedirectHTTPS {
 		rules = append(rules,
 			fmt.Sprintf("iptables -t nat -A OUTPUT -p tcp --dport 443 -m set --match-set %s dst -j ACCEPT", ipsetForceName),
 			fmt.Sprintf("iptables -t nat -A OUTPUT -p tcp --dport 443 -m set --match-set %s dst -j ACCEPT", ipsetName),
@@ -75,7 +77,7 @@ func (l *linuxFirewallManager) SetupFirewallRules() error {
 		)
 	}
 
-	if l.fm.redirectOpt.RedirectSSH {
+	if redirectTCP && l.fm.redirectOpt.RedirectSSH {
 		rules = append(rules,
 			fmt.Sprintf("iptables -t nat -A OUTPUT -p tcp --dport 22 -m set --match-set %s dst -j ACCEPT", ipsetForceName),
 			fmt.Sprintf("iptables -t nat -A OUTPUT -p tcp --dport 22 -m set --match-set %s dst -j ACCEPT", ipsetName),
@@ -93,7 +95,7 @@ func (l *linuxFirewallManager) SetupFirewallRules() error {
 	return nil
 }
 
-func (l *linuxFirewallManager) createIPSet() error {
+func (l *linuxFirewallManager) createIPSet(chinaCIDRs []string) error {
 	cmd := exec.Command("sudo", "ipset", "list", "-n")
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("ipset not available: %w", err)
@@ -111,10 +113,8 @@ func (l *linuxFirewallManager) createIPSet() error {
 		return fmt.Errorf("failed to add reserved IPs: %w", err)
 	}
 
-	if l.fm.skipCN {
-		if err := l.addChinaIPsToIPSet(); err != nil {
-			return fmt.Errorf("failed to add China IPs: %w", err)
-		}
+	if err := l.addChinaIPsToIPSet(chinaCIDRs); err != nil {
+		return fmt.Errorf("failed to add China IPs: %w", err)
 	}
 
 	return nil
@@ -139,12 +139,7 @@ func (l *linuxFirewallManager) addReservedIPsToIPSet() error {
 	return nil
 }
 
-func (l *linuxFirewallManager) addChinaIPsToIPSet() error {
-	if err := ipdb.LoadChinaIPRanges(); err != nil {
-		return fmt.Errorf("failed to load China IP ranges: %w", err)
-	}
-
-	chinaIPs, _ := ipdb.GetChinaCIDRs()
+func (l *linuxFirewallManager) addChinaIPsToIPSet(chinaIPs []string) error {
 	if len(chinaIPs) == 0 {
 		return nil
 	}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFirewallManager_GeoIPErrorPolicy(t *testing.T) {
+	loadErr := func() ([]string, error) { return nil, errors.New("china ip data unavailable") }
+	loadOK := func() ([]string, error) { return []string{"1.0.1.0/24"}, nil }
+
+	tests := []struct {
+		name            string
+		policy          string
+		load            func() ([]string, error)
+		wantCIDRs       int
+		wantRedirectTCP bool
+	}{
+		{"loaded", GeoIPErrorDirect, loadOK, 1, true},
+		{"error with default policy", "", loadErr, 0, true},
+		{"error with upstream policy", GeoIPErrorUpstream, loadErr, 0, true},
+		{"error with direct policy", GeoIPErrorDirect, loadErr, 0, false},
+		{"empty data with direct policy", GeoIPErrorDirect, func() ([]string, error) { return nil, nil }, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm := NewFirewallManager("7890", "6363", nil, RedirectOption{RedirectHTTPS: true}, nil, nil, 0, true)
+			fm.SetGeoIPErrorPolicy(tt.policy)
+			fm.loadChinaCIDRs = tt.load
+
+			cidrs, redirectTCP := fm.chinaCIDRs()
+			if len(cidrs) != tt.wantCIDRs {
+				t.Errorf("Expected %d China CIDRs, got %v", tt.wantCIDRs, cidrs)
+			}
+			if redirectTCP != tt.wantRedirectTCP {
+				t.Errorf("Expected redirectTCP %v, got %v", tt.wantRedirectTCP, redirectTCP)
+			}
+		})
+	}
+}
+
+func TestFirewallManager_GeoIPErrorPolicyWithoutSkipCN(t *testing.T) {
+	fm := NewFirewallManager("7890", "6363", nil, RedirectOption{RedirectHTTPS: true}, nil, nil, 0, false)
+	fm.SetGeoIPErrorPolicy(GeoIPErrorDirect)
+	fm.loadChinaCIDRs = func() ([]string, error) {
+		t.Error("China IP ranges must not be loaded when CN bypass is off")
+		return nil, nil
+	}
+	if cidrs, redirectTCP := fm.chinaCIDRs(); cidrs != nil || !redirectTCP {
+		t.Errorf("Expected all TCP traffic redirected without CN bypass, got cidrs=%v redirectTCP=%v", cidrs, redirectTCP)
+	}
+}
+
+func TestValidGeoIPErrorPolicy(t *testing.T) {
+	for _, policy := range []string{"", GeoIPErrorUpstream, GeoIPErrorDirect} {
+		if !ValidGeoIPErrorPolicy(policy) {
+			t.Errorf("Expected %q to be valid", policy)
+		}
+	}
+	if ValidGeoIPErrorPolicy("drop") {
+		t.Error("Expected unknown policy to be invalid")
+	}
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// Address families the proxy can listen on
+const (
+	ListenFamilyAny  = ""     // Listen on the configured address as-is
+	ListenFamilyIPv4 = "ipv4" // IPv4 only, an IPv6 address is mapped to its IPv4 counterpart
+	ListenFamilyIPv6 = "ipv6" // IPv6 only, an IPv4 address is mapped to its IPv6 counterpart
+	ListenFamilyDual = "dual" // Separate IPv4 and IPv6 listeners on the same port
+)
+
+// listenSpec is a network and address passed to net.Listen
+type listenSpec struct {
+	network string
+	addr    string
+}
+
+// familyHosts maps wildcard and loopback hosts to their IPv4 and IPv6 counterparts
+func familyHosts(host string) (ipv4, ipv6 string, ok bool) {
+	switch host {
+	case "", "0.0.0.0", "::":
+		return "0.0.0.0", "::", true
+	case "localhost", "127.0.0.1", "::1":
+		return "127.0.0.1", "::1", true
+	}
+	return "", "", false
+}
+
+// listenSpecs returns the listeners needed to serve addr on family
+func listenSpecs(family, addr string) ([]listenSpec, error) {
+	switch family {
+	case ListenFamilyAny:
+		return []listenSpec{{network: "tcp", addr: addr}}, nil
+	case ListenFamilyIPv4, ListenFamilyIPv6, ListenFamilyDual:
+	default:
+		return nil, fmt.Errorf("unknown listen family %q, expected %s, %s or %s", family, ListenFamilyIPv4, ListenFamilyIPv6, ListenFamilyDual)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %s: %w", addr, err)
+	}
+
+	ipv4, ipv6, ok := familyHosts(host)
+	if !ok {
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return nil, fmt.Errorf("listen family %s needs an IP address, got %s", family, host)
+		}
+		if ip.To4() != nil {
+			ipv4 = host
+		} else {
+			ipv6 = host
+		}
+	}
+
+	var specs []listenSpec
+	if family == ListenFamilyIPv4 || family == ListenFamilyDual {
+		if ipv4 == "" {
+			return nil, fmt.Errorf("listen family %s can't bind IPv6 address %s", family, host)
+		}
+		specs = append(specs, listenSpec{network: "tcp4", addr: net.JoinHostPort(ipv4, port)})
+	}
+	if family == ListenFamilyIPv6 || family == ListenFamilyDual {
+		if ipv6 == "" {
+			return nil, fmt.Errorf("listen family %s can't bind IPv4 address %s", family, host)
+		}
+		specs = append(specs, listenSpec{network: "tcp6", addr: net.JoinHostPort(ipv6, port)})
+	}
+	return specs, nil
+}
+
+// listenFamily opens a listener for every spec of family and addr. With port 0 the later
+// listeners reuse the port picked for the first one, so all families share a port.
+func listenFamily(family, addr string) ([]net.Listener, error) {
+	specs, err := listenSpecs(family, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := make([]net.Listener, 0, len(specs))
+	for i, spec := range specs {
+		if i > 0 {
+			host, port, _ := net.SplitHostPort(spec.addr)
+			if port == "0" {
+				spec.addr = net.JoinHostPort(host, strconv.Itoa(listeners[0].Addr().(*net.TCPAddr).Port))
+			}
+		}
+		listener, err := net.Listen(spec.network, spec.addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s (%s): %w", spec.addr, spec.network, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"strings"
+	"time"
+)
+
+// decisionTTL is how long a per-host MITM decision is reused before rules are evaluated again
+const decisionTTL = 30 * time.Second
+
+// maxDecisions caps the cached per-host decisions, since the hostnames come from the client's SNI
+const maxDecisions = 4096
+
+// mitmDecision is the outcome of the bypass/whitelist rules for a host
+type mitmDecision int
+
+const (
+	decisionMITM           mitmDecision = iota
+	decisionBypass                      // Matches a bypass glob or learned from a handshake failure
+	decisionNotWhitelisted              // Whitelist is set and the host is not in it
+)
+
+// cachedDecision is a cached rule outcome, entries share one TTL so insertion order is also
+// expiry order
+type cachedDecision struct {
+	host      string
+	decision  mitmDecision
+	expiresAt time.Time
+}
+
+// evaluateDecision applies the bypass and whitelist rules, bypass takes precedence
+func (h *MITMHandler) evaluateDecision(host string) mitmDecision {
+	if h.isBypassed(host) {
+		return decisionBypass
+	}
+	h.rulesMu.RLock()
+	hasWhitelist := len(h.whitelist) > 0
+	h.rulesMu.RUnlock()
+	if hasWhitelist && !h.isInWhitelist(host) {
+		return decisionNotWhitelisted
+	}
+	return decisionMITM
+}
+
+// decision returns the cached decision for host, evaluating the rules when missing or expired
+func (h *MITMHandler) decision(host string) mitmDecision {
+	host = strings.ToLower(host)
+	now := time.Now()
+	h.decisionsMu.Lock()
+	if elem, ok := h.decisions[host]; ok {
+		if cached := elem.Value.(*cachedDecision); now.Before(cached.expiresAt) {
+			h.decisionsMu.Unlock()
+			return cached.decision
+		}
+	}
+	gen := h.decisionGen
+	h.decisionsMu.Unlock()
+
+	// Rules are evaluated unlocked, a decision they produce is stale if the rules changed meanwhile
+	d := h.decide(host)
+	h.storeDecision(&cachedDecision{host: host, decision: d, expiresAt: now.Add(decisionTTL)}, gen, now)
+	return d
+}
+
+// storeDecision caches a decision evaluated at generation gen, dropping expired entries and
+// then the oldest past maxDecisions. Decisions from before the last invalidation are discarded.
+func (h *MITMHandler) storeDecision(cached *cachedDecision, gen uint64, now time.Time) {
+	h.decisionsMu.Lock()
+	defer h.decisionsMu.Unlock()
+	if gen != h.decisionGen {
+		return
+	}
+
+	if elem, ok := h.decisions[cached.host]; ok {
+		h.decisionQueue.Remove(elem)
+	}
+	for back := h.decisionQueue.Back(); back != nil; back = h.decisionQueue.Back() {
+		oldest := back.Value.(*cachedDecision)
+		if now.Before(oldest.expiresAt) && h.decisionQueue.Len() < maxDecisions {
+			break
+		}
+		h.decisionQueue.Remove(back)
+		delete(h.decisions, oldest.host)
+	}
+	h.decisions[cached.host] = h.decisionQueue.PushFront(cached)
+}
+
+// forgetDecision drops the cached decision of host
+func (h *MITMHandler) forgetDecision(host string) {
+	h.decisionsMu.Lock()
+	defer h.decisionsMu.Unlock()
+	h.decisionGen++
+	if elem, ok := h.decisions[host]; ok {
+		h.decisionQueue.Remove(elem)
+		delete(h.decisions, host)
+	}
+}
+
+// InvalidateDecisions drops all cached per-host decisions, e.g. after the rules changed
+func (h *MITMHandler) InvalidateDecisions() {
+	h.decisionsMu.Lock()
+	defer h.decisionsMu.Unlock()
+	h.decisionGen++
+	clear(h.decisions)
+	h.decisionQueue.Init()
+}
@@ -2,12 +2,18 @@ package proxy
 
 import (
 	"bufio"
+	"container/list"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"path"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/monsterxx03/linko/pkg/clienthello"
 	"github.com/monsterxx03/linko/pkg/mitm"
 )
 
@@ -16,23 +22,79 @@ type MITMHandler struct {
 	proxy     *TransparentProxy
 	manager   *mitm.Manager
 	logger    *slog.Logger
+	rulesMu   sync.RWMutex // Guards whitelist and bypass, replaced by SetRules on config reload
 	whitelist map[string]bool
+	bypass    []string // SNI globs that are tunneled without decryption
+
+	autoBypass    bool                 // Bypass hosts where either TLS leg rejected the certificate
+	learnedMu     sync.Mutex           // Guards learnedBypass
+	learnedBypass map[string]time.Time // hostname -> expiry, learned from upstream certificate rejections
+
+	decisionsMu   sync.Mutex
+	decisions     map[string]*list.Element       // hostname -> element of decisionQueue, capped at maxDecisions
+	decisionQueue *list.List                     // *cachedDecision, newest first, next to expire at the back
+	decisionGen   uint64                         // Bumped when decisions are dropped, a lookup started before is not stored
+	decide        func(host string) mitmDecision // Rule evaluation behind the decision cache
+
+	clientHelloTimeout time.Duration // How long the client may take to send its first bytes and ClientHello
 }
 
+// Learned bypass entries expire so the host is inspected again later, and are capped since
+// the hostnames come from the client's SNI
+const (
+	learnedBypassTTL = time.Hour
+	maxLearnedBypass = 1024
+)
+
+// defaultClientHelloTimeout bounds classification of a connection redirected from the HTTPS port
+const defaultClientHelloTimeout = 2 * time.Second
+
 // NewMITMHandler creates a new MITM handler
-func NewMITMHandler(proxy *TransparentProxy, manager *mitm.Manager, whitelist []string, logger *slog.Logger) *MITMHandler {
+func NewMITMHandler(proxy *TransparentProxy, manager *mitm.Manager, whitelist []string, bypass []string, logger *slog.Logger) *MITMHandler {
+	h := &MITMHandler{
+		proxy:   proxy,
+		manager: manager,
+		logger:  logger,
+
+		learnedBypass: make(map[string]time.Time),
+		decisions:     make(map[string]*list.Element),
+		decisionQueue: list.New(),
+
+		clientHelloTimeout: defaultClientHelloTimeout,
+	}
+	h.decide = h.evaluateDecision
+	h.SetRules(whitelist, bypass)
+	return h
+}
+
+// SetRules replaces the whitelist and bypass globs, e.g. on config reload, and drops the
+// decisions made with the old rules
+func (h *MITMHandler) SetRules(whitelist []string, bypass []string) {
 	// Build whitelist map for fast lookup
 	whitelistMap := make(map[string]bool)
 	for _, domain := range whitelist {
 		whitelistMap[strings.ToLower(domain)] = true
 	}
 
-	return &MITMHandler{
-		proxy:     proxy,
-		manager:   manager,
-		logger:    logger,
-		whitelist: whitelistMap,
+	bypassPatterns := make([]string, 0, len(bypass))
+	for _, pattern := range bypass {
+		if pattern = strings.ToLower(strings.TrimSpace(pattern)); pattern != "" {
+			bypassPatterns = append(bypassPatterns, pattern)
+		}
 	}
+
+	h.rulesMu.Lock()
+	h.whitelist = whitelistMap
+	h.bypass = bypassPatterns
+	h.rulesMu.Unlock()
+	h.InvalidateDecisions()
+}
+
+// SetAutoBypass controls whether hosts are bypassed for learnedBypassTTL once the client refuses the
+// MITM certificate or the upstream server rejects the handshake with a certificate alert
+func (h *MITMHandler) SetAutoBypass(enabled bool) {
+	h.autoBypass = enabled
+	h.InvalidateDecisions()
 }
 
 // BufferedConn wraps a net.Conn and provides buffered data that was already read
@@ -55,7 +117,7 @@ func (b *BufferedConn) Read(p []byte) (n int, err error) {
 }
 
 // HandleConnection handles a MITM connection for HTTPS traffic
-// It checks whitelist first using PeekReader, and only proceeds with MITM if domain is allowed
+// It checks bypass list and whitelist first using PeekReader, and only proceeds with MITM if domain is allowed
 func (h *MITMHandler) HandleConnection(clientConn net.Conn, originalDst OriginalDst) (net.Conn, error) {
 	if !h.manager.IsEnabled() {
 		return nil, fmt.Errorf("MITM is not enabled")
@@ -64,29 +126,78 @@ func (h *MITMHandler) HandleConnection(clientConn net.Conn, originalDst Original
 	// Wrap connection with PeekReader for both whitelist check and MITM
 	peekReader := mitm.NewPeekReader(clientConn)
 
-	// If whitelist is not empty, check if domain is in whitelist
-	if len(h.whitelist) > 0 {
-		sni, err := h.extractSNI(peekReader)
-		if err != nil || sni == "" {
-			h.logger.Debug("Cannot extract SNI for whitelist check, skipping MITM",
-				"target", originalDst, "error", err)
-			// Get buffered data and wrap connection
-			buffered := h.getBufferedData(peekReader)
-			return &BufferedConn{Conn: clientConn, buffered: buffered}, nil
-		}
+	// Classify by the first byte under a deadline, so silent or non-TLS clients (port scanners,
+	// plaintext protocols on 443) never stall waiting for a ClientHello
+	clientConn.SetReadDeadline(time.Now().Add(h.clientHelloTimeout))
+	first, err := peekReader.Peek(1)
+	if err != nil {
+		clientConn.SetReadDeadline(time.Time{})
+		h.logger.Debug("No data from client on HTTPS port, closing", "target", originalDst, "error", err)
+		return nil, nil
+	}
+	if !clienthello.Parse(first).IsTLS {
+		clientConn.SetReadDeadline(time.Time{})
+		h.logger.Info("Non-TLS traffic on HTTPS port, tunneling raw",
+			"target", originalDst, "first_byte", fmt.Sprintf("0x%02x", first[0]))
+		buffered := h.getBufferedData(peekReader)
+		return &BufferedConn{Conn: clientConn, buffered: buffered}, nil
+	}
+	sniInfo := h.extractSNI(peekReader)
+	clientConn.SetReadDeadline(time.Time{})
 
-		if !h.isInWhitelist(sni) {
-			h.logger.Debug("Domain not in whitelist, skipping MITM",
-				"sni", sni, "target", originalDst)
-			// Get buffered data and wrap connection
-			buffered := h.getBufferedData(peekReader)
-			return &BufferedConn{Conn: clientConn, buffered: buffered}, nil
+	h.rulesMu.RLock()
+	hasWhitelist, hasBypass := len(h.whitelist) > 0, len(h.bypass) > 0
+	h.rulesMu.RUnlock()
+	if hasWhitelist || hasBypass || h.autoBypass {
+		sni := sniInfo.Hostname
+		if !sniInfo.IsValid {
+			// Without SNI only the whitelist forces a skip, bypass needs a hostname to match
+			if hasWhitelist {
+				h.logger.Debug("Cannot extract SNI for whitelist check, skipping MITM",
+					"target", originalDst, "tls", sniInfo.IsTLS, "complete", sniInfo.Complete, "error", sniInfo.ParseError)
+				// Get buffered data and wrap connection
+				buffered := h.getBufferedData(peekReader)
+				return &BufferedConn{Conn: clientConn, buffered: buffered}, nil
+			}
+		} else {
+			switch h.decision(sni) {
+			case decisionBypass:
+				h.logger.Debug("Domain in bypass list, tunneling without MITM",
+					"sni", sni, "alpn", sniInfo.ALPN, "target", originalDst)
+				buffered := h.getBufferedData(peekReader)
+				return &BufferedConn{Conn: clientConn, buffered: buffered}, nil
+			case decisionNotWhitelisted:
+				h.logger.Debug("Domain not in whitelist, skipping MITM",
+					"sni", sni, "alpn", sniInfo.ALPN, "target", originalDst)
+				// Get buffered data and wrap connection
+				buffered := h.getBufferedData(peekReader)
+				return &BufferedConn{Conn: clientConn, buffered: buffered}, nil
+			}
 		}
 	}
 
 	// Proceed with MITM using the same PeekReader
 	handler := h.manager.ConnectionHandlerWithPeekReader(h.proxy.upstream, peekReader)
-	err := handler.HandleConnection(clientConn, originalDst.IP, originalDst.Port)
+	err = handler.HandleConnection(clientConn, originalDst.IP, originalDst.Port)
+	var hsErr *mitm.UpstreamHandshakeError
+	if errors.As(err, &hsErr) {
+		// Client side is untouched, replay the ClientHello through a raw tunnel instead
+		h.logger.Warn("Upstream TLS handshake failed, tunneling without MITM",
+			"hostname", hsErr.Hostname, "target", originalDst, "error", hsErr.Err, "auto_bypass", h.autoBypass)
+		// 只有证书被拒绝（如服务端要求客户端证书）才记住，超时等临时错误不影响后续连接
+		if h.autoBypass && hsErr.CertificateRejected() {
+			h.rememberRejection(hsErr.Hostname)
+		}
+		buffered := h.getBufferedData(peekReader)
+		return &BufferedConn{Conn: clientConn, buffered: buffered}, nil
+	}
+	var clientErr *mitm.ClientHandshakeError
+	if errors.As(err, &clientErr) && h.autoBypass && clientErr.CertificateRejected() {
+		// 客户端拒绝了 MITM 证书（如证书固定），ClientHello 已被消费无法回退，后续连接直接隧道
+		h.logger.Warn("Client rejected MITM certificate, bypassing host",
+			"hostname", clientErr.Hostname, "target", originalDst, "error", clientErr.Err)
+		h.rememberRejection(clientErr.Hostname)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -94,6 +205,13 @@ func (h *MITMHandler) HandleConnection(clientConn net.Conn, originalDst Original
 	return nil, nil
 }
 
+// rememberRejection bypasses hostname after a certificate rejection on either TLS leg
+func (h *MITMHandler) rememberRejection(hostname string) {
+	host := strings.ToLower(hostname)
+	h.learnBypass(host)
+	h.forgetDecision(host)
+}
+
 // getBufferedData extracts the already-buffered data from PeekReader
 func (h *MITMHandler) getBufferedData(reader *mitm.PeekReader) []byte {
 	buffered := make([]byte, reader.Buffered())
@@ -106,43 +224,16 @@ func (h *MITMHandler) IsEnabled() bool {
 	return h.manager != nil && h.manager.IsEnabled()
 }
 
-// extractSNI peeks at the connection to extract SNI without consuming data
-func (h *MITMHandler) extractSNI(reader *mitm.PeekReader) (string, error) {
-	// Peek at TLS record header first
-	header, err := reader.Peek(5)
-	if err != nil {
-		return "", fmt.Errorf("failed to peek TLS header: %w", err)
-	}
-
-	// Get the full record length
-	recordLen := int(header[3])<<8 | int(header[4])
-	totalLen := 5 + recordLen
-	if totalLen > 16384 {
-		totalLen = 16384
-	}
-
-	// Peek at complete record
-	data, err := reader.Peek(totalLen)
-	if err != nil && len(data) < 200 {
-		return "", fmt.Errorf("failed to peek TLS record: %w", err)
-	}
-
-	// Parse SNI
-	sniInfo, err := mitm.ExtractSNIFromConn(data)
-	if err != nil {
-		return "", fmt.Errorf("SNI parsing failed: %w", err)
-	}
-
-	if sniInfo.IsValid && sniInfo.Hostname != "" {
-		return sniInfo.Hostname, nil
-	}
-
-	return "", fmt.Errorf("SNI not found")
+// extractSNI peeks at the connection to parse the ClientHello without consuming data
+func (h *MITMHandler) extractSNI(reader *mitm.PeekReader) *clienthello.Info {
+	return clienthello.Peek(reader.Peek, mitm.DefaultBufferSize)
 }
 
 // isInWhitelist checks if a domain is in the whitelist
 func (h *MITMHandler) isInWhitelist(domain string) bool {
 	domainLower := strings.ToLower(domain)
+	h.rulesMu.RLock()
+	defer h.rulesMu.RUnlock()
 
 	// Exact match
 	if h.whitelist[domainLower] {
@@ -162,6 +253,58 @@ func (h *MITMHandler) isInWhitelist(domain string) bool {
 	return false
 }
 
+// isBypassed checks if a domain matches a bypass glob or was learned from a handshake failure,
+// bypass takes precedence over whitelist
+func (h *MITMHandler) isBypassed(domain string) bool {
+	domainLower := strings.ToLower(domain)
+	if h.isLearnedBypass(domainLower) {
+		return true
+	}
+	h.rulesMu.RLock()
+	defer h.rulesMu.RUnlock()
+	for _, pattern := range h.bypass {
+		if matched, _ := path.Match(pattern, domainLower); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// learnBypass bypasses host for learnedBypassTTL, when full it drops expired entries first,
+// then the one closest to expiry
+func (h *MITMHandler) learnBypass(host string) {
+	now := time.Now()
+	h.learnedMu.Lock()
+	defer h.learnedMu.Unlock()
+
+	if _, exists := h.learnedBypass[host]; !exists && len(h.learnedBypass) >= maxLearnedBypass {
+		oldest := ""
+		for learned, expiresAt := range h.learnedBypass {
+			if now.After(expiresAt) {
+				delete(h.learnedBypass, learned)
+			} else if oldest == "" || expiresAt.Before(h.learnedBypass[oldest]) {
+				oldest = learned
+			}
+		}
+		if len(h.learnedBypass) >= maxLearnedBypass {
+			delete(h.learnedBypass, oldest)
+		}
+	}
+	h.learnedBypass[host] = now.Add(learnedBypassTTL)
+}
+
+// isLearnedBypass reports whether host was learned from a certificate rejection that has not expired
+func (h *MITMHandler) isLearnedBypass(host string) bool {
+	h.learnedMu.Lock()
+	defer h.learnedMu.Unlock()
+	expiresAt, ok := h.learnedBypass[host]
+	if ok && time.Now().After(expiresAt) {
+		delete(h.learnedBypass, host)
+		return false
+	}
+	return ok
+}
+
 // BufferedReader is a bufio.Reader that allows getting buffered data
 type BufferedReader struct {
 	*bufio.Reader
@@ -0,0 +1,467 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monsterxx03/linko/pkg/clienthello"
+	"github.com/monsterxx03/linko/pkg/config"
+	"github.com/monsterxx03/linko/pkg/mitm"
+)
+
+func newTestMITMHandler(t *testing.T, whitelist, bypass []string) *MITMHandler {
+	t.Helper()
+	return newTestMITMHandlerWithRoots(t, whitelist, bypass, nil)
+}
+
+// newTestMITMHandlerWithRoots creates a handler verifying upstream servers against roots
+func newTestMITMHandlerWithRoots(t *testing.T, whitelist, bypass []string, roots *x509.CertPool) *MITMHandler {
+	t.Helper()
+	dir := t.TempDir()
+	manager, err := mitm.NewManager(mitm.ManagerConfig{
+		CACertPath:      filepath.Join(dir, "ca.crt"),
+		CAKeyPath:       filepath.Join(dir, "ca.key"),
+		CertCacheDir:    filepath.Join(dir, "certs"),
+		Enabled:         true,
+		UpstreamRootCAs: roots,
+	}, slog.Default())
+	if err != nil {
+		t.Fatalf("Failed to create MITM manager: %v", err)
+	}
+
+	proxy := NewTransparentProxy("127.0.0.1:0", NewUpstreamClient(config.UpstreamConfig{}))
+	return NewMITMHandler(proxy, manager, whitelist, bypass, slog.Default())
+}
+
+// startTLSClient starts a TLS handshake for serverName over conn, sending the ClientHello,
+// and reports the peer certificates, nil if the handshake failed
+func startTLSClient(conn net.Conn, serverName string) <-chan []*x509.Certificate {
+	certs := make(chan []*x509.Certificate, 1)
+	go func() {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		if err := tlsConn.Handshake(); err != nil {
+			certs <- nil
+			return
+		}
+		certs <- tlsConn.ConnectionState().PeerCertificates
+	}()
+	return certs
+}
+
+func TestMITMHandler_BypassedHostTunneledRaw(t *testing.T) {
+	handler := newTestMITMHandler(t, nil, []string{"*.googlevideo.com"})
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	startTLSClient(clientConn, "rr1.googlevideo.com")
+
+	conn, err := handler.HandleConnection(serverConn, OriginalDst{IP: net.ParseIP("127.0.0.1"), Port: 443})
+	if err != nil {
+		t.Fatalf("HandleConnection failed: %v", err)
+	}
+	buffered, ok := conn.(*BufferedConn)
+	if !ok {
+		t.Fatalf("Expected bypassed host to return a BufferedConn for tunneling, got %T", conn)
+	}
+
+	// The untouched ClientHello must be replayed to the real server
+	sniInfo := clienthello.Parse(buffered.buffered)
+	if sniInfo.ParseError != nil {
+		t.Fatalf("Expected buffered data to be the raw ClientHello: %v", sniInfo.ParseError)
+	}
+	if sniInfo.Hostname != "rr1.googlevideo.com" {
+		t.Errorf("Expected SNI rr1.googlevideo.com, got %s", sniInfo.Hostname)
+	}
+}
+
+// startTLSTarget accepts connections, reports the SNI of each proxy-originated ClientHello and
+// fails the upstream handshake, answering with a fatal TLS alert or just dropping the connection
+// when alert is 0
+func startTLSTarget(t *testing.T, alert byte) (*net.TCPAddr, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	snis := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 16384)
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, _ := conn.Read(buf)
+			if sniInfo := clienthello.Parse(buf[:n]); sniInfo.IsValid {
+				snis <- sniInfo.Hostname
+			} else {
+				snis <- ""
+			}
+			if alert != 0 {
+				conn.Write([]byte{21, 3, 3, 0, 2, 2, alert})
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr), snis
+}
+
+// startTLSUpstream serves TLS for hostname with a certificate from its own CA, returned as roots
+// so the MITM upstream handshake succeeds
+func startTLSUpstream(t *testing.T, hostname string) (*net.TCPAddr, *x509.CertPool) {
+	t.Helper()
+	dir := t.TempDir()
+	ca, err := mitm.NewCertManager(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"), time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create upstream CA: %v", err)
+	}
+	scm, err := mitm.NewSiteCertManager(ca.GetCACertificate(), ca.GetCAPrivateKey(), filepath.Join(dir, "certs"), time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create upstream cert manager: %v", err)
+	}
+	cert, err := scm.GetCertificate(hostname)
+	if err != nil {
+		t.Fatalf("Failed to create upstream certificate: %v", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*cert}})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.GetCACertificate())
+	return listener.Addr().(*net.TCPAddr), roots
+}
+
+func TestMITMHandler_NonBypassedHostInspected(t *testing.T) {
+	target, roots := startTLSUpstream(t, "api.example.com")
+	handler := newTestMITMHandlerWithRoots(t, nil, []string{"*.googlevideo.com"}, roots)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	certsCh := startTLSClient(clientConn, "api.example.com")
+
+	go handler.HandleConnection(serverConn, OriginalDst{IP: target.IP, Port: target.Port})
+
+	var certs []*x509.Certificate
+	select {
+	case certs = <-certsCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for client handshake")
+	}
+	if len(certs) == 0 {
+		t.Fatal("Expected client handshake with forged certificate to succeed")
+	}
+
+	caName := handler.manager.GetCertManager().GetCACertificate().Subject.CommonName
+	if certs[0].Issuer.CommonName != caName {
+		t.Errorf("Expected certificate issued by %q, got %q", caName, certs[0].Issuer.CommonName)
+	}
+	if !slices.Contains(certs[0].DNSNames, "api.example.com") {
+		t.Errorf("Expected forged certificate for api.example.com, got %v", certs[0].DNSNames)
+	}
+}
+
+func TestMITMHandler_UpstreamHandshakeFailureFallback(t *testing.T) {
+	handler := newTestMITMHandler(t, nil, nil)
+	handler.SetAutoBypass(true)
+	// The server rejects the handshake, e.g. because it requires a client certificate
+	target, snis := startTLSTarget(t, 42)
+	dst := OriginalDst{IP: target.IP, Port: target.Port}
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	startTLSClient(clientConn, "pinned.example.com")
+
+	conn, err := handler.HandleConnection(serverConn, dst)
+	if err != nil {
+		t.Fatalf("Expected fallback instead of error, got %v", err)
+	}
+	buffered, ok := conn.(*BufferedConn)
+	if !ok {
+		t.Fatalf("Expected raw tunnel fallback with BufferedConn, got %T", conn)
+	}
+	if sniInfo := clienthello.Parse(buffered.buffered); sniInfo.Hostname != "pinned.example.com" {
+		t.Errorf("Expected original ClientHello to be replayed, got %+v", sniInfo)
+	}
+	<-snis
+
+	if !handler.isBypassed("pinned.example.com") {
+		t.Fatal("Expected host to be bypassed after the upstream rejected the certificate")
+	}
+
+	// Next connection is tunneled without dialing the target for MITM
+	clientConn2, serverConn2 := net.Pipe()
+	defer clientConn2.Close()
+	defer serverConn2.Close()
+	startTLSClient(clientConn2, "pinned.example.com")
+
+	conn, err = handler.HandleConnection(serverConn2, dst)
+	if err != nil {
+		t.Fatalf("HandleConnection failed: %v", err)
+	}
+	if _, ok := conn.(*BufferedConn); !ok {
+		t.Fatalf("Expected bypassed BufferedConn, got %T", conn)
+	}
+	select {
+	case <-snis:
+		t.Error("Expected no MITM upstream handshake for a learned bypass host")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMITMHandler_ClientRejectedCertificateLearned(t *testing.T) {
+	target, roots := startTLSUpstream(t, "pinned.example.com")
+	handler := newTestMITMHandlerWithRoots(t, nil, nil, roots)
+	handler.SetAutoBypass(true)
+
+	// The client verifies against its own roots and refuses the forged certificate
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	go tls.Client(clientConn, &tls.Config{ServerName: "pinned.example.com", RootCAs: x509.NewCertPool()}).Handshake()
+
+	conn, err := handler.HandleConnection(serverConn, OriginalDst{IP: target.IP, Port: target.Port})
+	var clientErr *mitm.ClientHandshakeError
+	if !errors.As(err, &clientErr) {
+		t.Fatalf("Expected ClientHandshakeError, got conn %T, err %v", conn, err)
+	}
+	if !handler.isBypassed("pinned.example.com") {
+		t.Error("Expected host to be bypassed after the client rejected the certificate")
+	}
+}
+
+func TestMITMHandler_TransientHandshakeFailureNotLearned(t *testing.T) {
+	handler := newTestMITMHandler(t, nil, nil)
+	handler.SetAutoBypass(true)
+	// Dropped connection, e.g. a reset or timeout
+	target, snis := startTLSTarget(t, 0)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	startTLSClient(clientConn, "flaky.example.com")
+
+	conn, err := handler.HandleConnection(serverConn, OriginalDst{IP: target.IP, Port: target.Port})
+	if err != nil {
+		t.Fatalf("Expected fallback instead of error, got %v", err)
+	}
+	if _, ok := conn.(*BufferedConn); !ok {
+		t.Fatalf("Expected raw tunnel fallback with BufferedConn, got %T", conn)
+	}
+	<-snis
+
+	if handler.isBypassed("flaky.example.com") {
+		t.Error("Expected a transient handshake failure not to bypass the host")
+	}
+}
+
+func TestMITMHandler_LearnedBypassBounded(t *testing.T) {
+	handler := newTestMITMHandler(t, nil, nil)
+
+	for i := range maxLearnedBypass + 10 {
+		handler.learnBypass(fmt.Sprintf("host%d.example.com", i))
+	}
+	if n := len(handler.learnedBypass); n != maxLearnedBypass {
+		t.Errorf("Expected %d learned hosts at the cap, got %d", maxLearnedBypass, n)
+	}
+	if !handler.isLearnedBypass(fmt.Sprintf("host%d.example.com", maxLearnedBypass+9)) {
+		t.Error("Expected the latest learned host to be kept")
+	}
+
+	// Expired entries are inspected again
+	handler.learnedBypass["expired.example.com"] = time.Now().Add(-time.Second)
+	if handler.isBypassed("expired.example.com") {
+		t.Error("Expected an expired learned bypass to be ignored")
+	}
+	if _, exists := handler.learnedBypass["expired.example.com"]; exists {
+		t.Error("Expected the expired entry to be dropped")
+	}
+}
+
+func TestMITMHandler_IsBypassed(t *testing.T) {
+	handler := newTestMITMHandler(t, []string{"*.googlevideo.com"}, []string{"*.googlevideo.com", "cdn-*.example.com", " Video.Example.ORG "})
+
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"rr1.googlevideo.com", true},
+		{"RR1.GoogleVideo.com", true},
+		{"googlevideo.com", false},
+		{"cdn-1.example.com", true},
+		{"api.example.com", false},
+		{"video.example.org", true},
+	}
+
+	for _, tt := range tests {
+		if got := handler.isBypassed(tt.domain); got != tt.want {
+			t.Errorf("isBypassed(%s) = %v, want %v", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestMITMHandler_DecisionCached(t *testing.T) {
+	handler := newTestMITMHandler(t, nil, []string{"*.googlevideo.com"})
+
+	var calls atomic.Int32
+	evaluate := handler.decide
+	handler.decide = func(host string) mitmDecision {
+		calls.Add(1)
+		return evaluate(host)
+	}
+
+	connect := func() {
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+		defer serverConn.Close()
+		startTLSClient(clientConn, "rr1.googlevideo.com")
+
+		conn, err := handler.HandleConnection(serverConn, OriginalDst{IP: net.ParseIP("127.0.0.1"), Port: 443})
+		if err != nil {
+			t.Fatalf("HandleConnection failed: %v", err)
+		}
+		if _, ok := conn.(*BufferedConn); !ok {
+			t.Fatalf("Expected bypassed host to be tunneled, got %T", conn)
+		}
+	}
+
+	for range 5 {
+		connect()
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Expected rules to be evaluated once for 5 connections, got %d", got)
+	}
+
+	handler.InvalidateDecisions()
+	connect()
+	if got := calls.Load(); got != 2 {
+		t.Errorf("Expected rules to be evaluated again after invalidation, got %d", got)
+	}
+}
+
+func TestMITMHandler_DecisionsBounded(t *testing.T) {
+	handler := newTestMITMHandler(t, nil, nil)
+
+	for i := range maxDecisions + 10 {
+		handler.decision(fmt.Sprintf("host%d.example.com", i))
+	}
+	if got := len(handler.decisions); got != maxDecisions {
+		t.Errorf("Expected %d cached decisions, got %d", maxDecisions, got)
+	}
+	if _, ok := handler.decisions["host0.example.com"]; ok {
+		t.Error("Expected the oldest decision to be evicted")
+	}
+
+	// Expired decisions are swept when the next one is stored
+	for elem := handler.decisionQueue.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*cachedDecision).expiresAt = time.Now().Add(-time.Second)
+	}
+	handler.decision("fresh.example.com")
+	if got := len(handler.decisions); got != 1 {
+		t.Errorf("Expected expired decisions to be swept, %d left", got)
+	}
+}
+
+func TestMITMHandler_SetRulesInvalidatesDecisions(t *testing.T) {
+	handler := newTestMITMHandler(t, nil, nil)
+	if got := handler.decision("rr1.googlevideo.com"); got != decisionMITM {
+		t.Fatalf("Expected host to be inspected, got %v", got)
+	}
+
+	handler.SetRules(nil, []string{"*.googlevideo.com"})
+	if got := handler.decision("rr1.googlevideo.com"); got != decisionBypass {
+		t.Errorf("Expected reloaded bypass rule to apply at once, got %v", got)
+	}
+}
+
+func TestMITMHandler_RulesChangeDuringLookup(t *testing.T) {
+	handler := newTestMITMHandler(t, nil, nil)
+
+	// The rules change after this lookup evaluated the old ones but before it stored the result
+	evaluate := handler.decide
+	handler.decide = func(host string) mitmDecision {
+		d := evaluate(host)
+		handler.decide = evaluate
+		handler.SetRules(nil, []string{"*.googlevideo.com"})
+		return d
+	}
+	if got := handler.decision("rr1.googlevideo.com"); got != decisionMITM {
+		t.Fatalf("Expected the in-flight lookup to return the old decision, got %v", got)
+	}
+	if got := handler.decision("rr1.googlevideo.com"); got != decisionBypass {
+		t.Errorf("Expected the stale decision not to be cached, got %v", got)
+	}
+}
+
+func TestMITMHandler_NonTLSTunneledRaw(t *testing.T) {
+	handler := newTestMITMHandler(t, nil, nil)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	go clientConn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+
+	start := time.Now()
+	conn, err := handler.HandleConnection(serverConn, OriginalDst{IP: net.ParseIP("127.0.0.1"), Port: 443})
+	if err != nil {
+		t.Fatalf("HandleConnection failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected non-TLS traffic to be classified quickly, took %v", elapsed)
+	}
+	buffered, ok := conn.(*BufferedConn)
+	if !ok {
+		t.Fatalf("Expected non-TLS traffic to return a BufferedConn for tunneling, got %T", conn)
+	}
+	if len(buffered.buffered) == 0 || string(buffered.buffered[:3]) != "GET" {
+		t.Errorf("Expected buffered data to start with the plaintext request, got %q", buffered.buffered)
+	}
+}
+
+func TestMITMHandler_SilentClientClosed(t *testing.T) {
+	handler := newTestMITMHandler(t, nil, nil)
+	handler.clientHelloTimeout = 50 * time.Millisecond
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	start := time.Now()
+	conn, err := handler.HandleConnection(serverConn, OriginalDst{IP: net.ParseIP("127.0.0.1"), Port: 443})
+	if err != nil || conn != nil {
+		t.Fatalf("Expected silent client to be closed without error, got conn=%v err=%v", conn, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected silent client to be dropped after the timeout, took %v", elapsed)
+	}
+}
@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PROXY protocol versions for upstream connections
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+)
+
+// proxyProtocolV2Sig is the fixed 12-byte signature starting every v2 header
+var proxyProtocolV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyHeaderTimeout bounds how long an inbound client may take to send its PROXY header
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyProtocolV1MaxLen is the longest v1 header line allowed by the spec, CRLF included
+const proxyProtocolV1MaxLen = 107
+
+// ValidProxyProtocol reports whether version is a supported PROXY protocol version, empty means off
+func ValidProxyProtocol(version string) bool {
+	switch version {
+	case "", ProxyProtocolV1, ProxyProtocolV2:
+		return true
+	}
+	return false
+}
+
+// proxyProtocolHeader builds the PROXY protocol header announcing src as the client of a
+// connection to dst. Non-TCP or missing addresses produce UNKNOWN (v1) or LOCAL (v2) headers.
+func proxyProtocolHeader(version string, src, dst net.Addr) ([]byte, error) {
+	srcTCP, srcOK := src.(*net.TCPAddr)
+	dstTCP, dstOK := dst.(*net.TCPAddr)
+	known := srcOK && dstOK && srcTCP != nil && dstTCP != nil
+
+	var srcIP, dstIP net.IP
+	ipv4 := false
+	if known {
+		srcIP, dstIP = srcTCP.IP.To4(), dstTCP.IP.To4()
+		ipv4 = srcIP != nil && dstIP != nil
+		if !ipv4 {
+			// Mixed families are announced as IPv6, with IPv4 addresses mapped
+			srcIP, dstIP = srcTCP.IP.To16(), dstTCP.IP.To16()
+			known = srcIP != nil && dstIP != nil
+		}
+	}
+
+	switch version {
+	case ProxyProtocolV1:
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		family := "TCP6"
+		if ipv4 {
+			family = "TCP4"
+		}
+		return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, srcIP, dstIP, srcTCP.Port, dstTCP.Port), nil
+	case ProxyProtocolV2:
+		header := append([]byte{}, proxyProtocolV2Sig...)
+		if !known {
+			// Version 2, LOCAL command, unspecified family, no addresses
+			return append(header, 0x20, 0x00, 0x00, 0x00), nil
+		}
+		var addrs []byte
+		family := byte(0x21) // AF_INET6, STREAM
+		if ipv4 {
+			family = 0x11 // AF_INET, STREAM
+		}
+		addrs = append(addrs, srcIP...)
+		addrs = append(addrs, dstIP...)
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(srcTCP.Port))
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(dstTCP.Port))
+		// Version 2, PROXY command
+		header = append(header, 0x21, family)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+		return append(header, addrs...), nil
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol version: %s", version)
+	}
+}
+
+// proxyProtocolConn is an inbound connection whose addresses were announced in a PROXY header
+type proxyProtocolConn struct {
+	net.Conn
+	reader *bufio.Reader
+	src    net.Addr // Announced client address, nil = connection's own
+	dst    net.Addr // Announced destination address, nil = unknown
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the announced client address, falling back to the peer (load balancer) address
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads the v1 or v2 PROXY header a load balancer sends ahead of client data.
+// Connections without a valid header are rejected.
+func readProxyHeader(conn net.Conn, timeout time.Duration) (*proxyProtocolConn, error) {
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
+	pc := &proxyProtocolConn{Conn: conn, reader: bufio.NewReader(conn)}
+	prefix, err := pc.reader.Peek(len(proxyProtocolV2Sig))
+	if err != nil && len(prefix) < 6 {
+		return nil, fmt.Errorf("failed to read PROXY header: %w", err)
+	}
+	switch {
+	case bytes.Equal(prefix, proxyProtocolV2Sig):
+		err = pc.readV2()
+	case bytes.HasPrefix(prefix, []byte("PROXY ")):
+		err = pc.readV1()
+	default:
+		err = fmt.Errorf("missing PROXY protocol header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// readV1 parses a text header, e.g. "PROXY TCP4 10.0.0.1 10.0.0.2 5555 443\r\n"
+func (c *proxyProtocolConn) readV1() error {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyProtocolV1MaxLen {
+			return fmt.Errorf("PROXY v1 header longer than %d bytes", proxyProtocolV1MaxLen)
+		}
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return fmt.Errorf("failed to read PROXY v1 header: %w", err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(string(line), "\r\n"))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("malformed PROXY v1 header: %q", line)
+	}
+	src, err := parseProxyAddr(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return err
+	}
+	dst, err := parseProxyAddr(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return err
+	}
+	c.src, c.dst = src, dst
+	return nil
+}
+
+// parseProxyAddr parses a v1 address and port, checking the address family
+func parseProxyAddr(host, port string, ipv4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (ip.To4() != nil) != ipv4 {
+		return nil, fmt.Errorf("malformed PROXY v1 address: %q", host)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 65535 {
+		return nil, fmt.Errorf("malformed PROXY v1 port: %q", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: p}, nil
+}
+
+// readV2 parses a binary header, TLVs after the addresses are skipped
+func (c *proxyProtocolConn) readV2() error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return fmt.Errorf("failed to read PROXY v2 header: %w", err)
+	}
+	if header[12]>>4 != 2 {
+		return fmt.Errorf("unsupported PROXY v2 version: %d", header[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return fmt.Errorf("failed to read PROXY v2 addresses: %w", err)
+	}
+
+	switch header[12] & 0x0F {
+	case 0x0: // LOCAL, e.g. load balancer health checks, keeps the connection's own addresses
+		return nil
+	case 0x1: // PROXY
+	default:
+		return fmt.Errorf("unsupported PROXY v2 command: %d", header[12]&0x0F)
+	}
+
+	var ipLen int
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		ipLen = net.IPv4len
+	case 0x21: // TCP over IPv6
+		ipLen = net.IPv6len
+	default:
+		// UDP, unix sockets or unspecified, no TCP addresses to use
+		return nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return fmt.Errorf("PROXY v2 address block too short: %d bytes", len(body))
+	}
+	c.src = &net.TCPAddr{
+		IP:   net.IP(bytes.Clone(body[:ipLen])),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLen:])),
+	}
+	c.dst = &net.TCPAddr{
+		IP:   net.IP(bytes.Clone(body[ipLen : 2*ipLen])),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLen+2:])),
+	}
+	return nil
+}
@@ -2,11 +2,14 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math/rand/v2"
 	"net"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -18,31 +21,42 @@ type OriginalDst struct {
 // TransparentProxy represents a transparent proxy
 type TransparentProxy struct {
 	listenAddr   string
-	server       net.Listener
+	listenFamily string         // ListenFamilyAny, ListenFamilyIPv4, ListenFamilyIPv6 or ListenFamilyDual
+	listeners    []net.Listener // one per address family, each with its own accept loop
 	ctx          context.Context
 	cancel       context.CancelFunc
 	wg           sync.WaitGroup
 	stats        *ProxyStats
 	upstream     *UpstreamClient
-	enableDirect bool         // Enable direct connection when upstream is disabled
-	mitmHandler  *MITMHandler // MITM handler for HTTPS traffic
-	mitmEnabled  bool         // Whether MITM is enabled
+	enableDirect bool                        // Allow direct connections when upstream is disabled, cleared by SetForceUpstream
+	mitmHandler  *MITMHandler                // MITM handler for HTTPS traffic
+	mitmEnabled  bool                        // Whether MITM is enabled
 	onPanic      func(recovered interface{}) // Callback when a goroutine panics
+	handle       func(conn net.Conn)         // Connection handler, handleConnection unless replaced in tests
+
+	connSem      chan struct{} // Limits concurrent connections, nil = unlimited
+	queueTimeout time.Duration // How long a new connection may wait for a free slot, 0 = reject at once
+	acl          *ACL          // Client address filter, nil = accept all
+
+	proxyTrusted []*net.IPNet // Load balancers whose connections start with a PROXY protocol header, nil = disabled
 }
 
 // ProxyStats tracks proxy statistics
 type ProxyStats struct {
-	totalConnections  uint64
-	activeConnections uint64
-	bytesTransferred  uint64
-	startTime         time.Time
-	mu                sync.RWMutex
+	totalConnections    uint64
+	activeConnections   uint64
+	rejectedConnections uint64
+	deniedConnections   uint64
+	acceptErrors        uint64
+	bytesTransferred    uint64
+	startTime           time.Time
+	mu                  sync.RWMutex
 }
 
 // NewTransparentProxy creates a new transparent proxy
 func NewTransparentProxy(listenAddr string, upstream *UpstreamClient) *TransparentProxy {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &TransparentProxy{
+	p := &TransparentProxy{
 		listenAddr: listenAddr,
 		ctx:        ctx,
 		cancel:     cancel,
@@ -52,19 +66,95 @@ func NewTransparentProxy(listenAddr string, upstream *UpstreamClient) *Transpare
 		upstream:     upstream,
 		enableDirect: !upstream.IsEnabled(),
 	}
+	p.handle = p.handleConnection
+	return p
+}
+
+// SetConnectionLimit limits concurrent connections to max (0 = unlimited). When all slots are taken
+// a new connection waits up to queueTimeout for one to free up, then it is closed and counted as rejected.
+func (p *TransparentProxy) SetConnectionLimit(max int, queueTimeout time.Duration) {
+	if max > 0 {
+		p.connSem = make(chan struct{}, max)
+	} else {
+		p.connSem = nil
+	}
+	p.queueTimeout = queueTimeout
+}
+
+// SetListenFamily selects the address families to listen on, must be called before Start.
+// The firewall rules linko installs only redirect IPv4, IPv6 traffic needs ip6tables rules of its own.
+func (p *TransparentProxy) SetListenFamily(family string) {
+	p.listenFamily = family
+}
+
+// SetACL restricts which client addresses may use the proxy, nil accepts all. Must be called before Start
+func (p *TransparentProxy) SetACL(acl *ACL) {
+	p.acl = acl
+}
+
+// SetProxyProtocol makes the proxy read a PROXY protocol v1/v2 header on connections from the
+// trusted IPs or CIDRs and use the announced client address for ACLs and logging. Trusted
+// connections without a valid header are rejected, other peers are checked by their own address.
+func (p *TransparentProxy) SetProxyProtocol(trusted []string) error {
+	nets, err := parseNets(trusted)
+	if err != nil {
+		return fmt.Errorf("invalid PROXY protocol trusted address: %w", err)
+	}
+	p.proxyTrusted = nets
+	return nil
+}
+
+// SetForceUpstream makes every connection go via upstream. Connections then fail instead of
+// dialing direct when upstream is disabled.
+func (p *TransparentProxy) SetForceUpstream(force bool) {
+	p.enableDirect = !force && !p.upstream.IsEnabled()
+}
+
+// acquireConn takes a connection slot, waiting up to queueTimeout
+func (p *TransparentProxy) acquireConn() bool {
+	if p.connSem == nil {
+		return true
+	}
+	select {
+	case p.connSem <- struct{}{}:
+		return true
+	default:
+	}
+	if p.queueTimeout <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(p.queueTimeout)
+	defer timer.Stop()
+	select {
+	case p.connSem <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// releaseConn frees a slot taken by acquireConn
+func (p *TransparentProxy) releaseConn() {
+	if p.connSem != nil {
+		<-p.connSem
+	}
 }
 
 // Start starts the transparent proxy
 func (p *TransparentProxy) Start() error {
-	listener, err := net.Listen("tcp", p.listenAddr)
+	listeners, err := listenFamily(p.listenFamily, p.listenAddr)
 	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", p.listenAddr, err)
+		return err
 	}
 
-	p.server = listener
-
-	p.wg.Add(1)
-	go p.acceptLoop()
+	p.listeners = listeners
+	for _, listener := range listeners {
+		p.wg.Add(1)
+		go p.acceptLoop(listener)
+	}
 
 	if p.upstream.IsEnabled() {
 		slog.Info("Transparent proxy listening", "address", p.listenAddr, "upstream_type", p.upstream.GetConfig().Type, "upstream_addr", p.upstream.GetConfig().Addr, "mode", "proxy")
@@ -78,15 +168,15 @@ func (p *TransparentProxy) Start() error {
 func (p *TransparentProxy) Stop() {
 	p.cancel()
 
-	if p.server != nil {
-		p.server.Close()
+	for _, listener := range p.listeners {
+		listener.Close()
 	}
 
 	slog.Info("Transparent proxy stopped")
 }
 
-// acceptLoop accepts incoming connections
-func (p *TransparentProxy) acceptLoop() {
+// acceptLoop accepts incoming connections on listener
+func (p *TransparentProxy) acceptLoop(listener net.Listener) {
 	defer p.wg.Add(-1)
 	defer func() {
 		if r := recover(); r != nil {
@@ -97,20 +187,137 @@ func (p *TransparentProxy) acceptLoop() {
 		}
 	}()
 
+	var backoff time.Duration
 	for {
-		conn, err := p.server.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
+			if p.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			p.stats.mu.Lock()
+			p.stats.acceptErrors++
+			p.stats.mu.Unlock()
+			// Errors like EMFILE persist until fds are freed, back off instead of spinning
+			backoff = nextAcceptBackoff(backoff)
+			if isTemporaryAcceptError(err) {
+				slog.Warn("Accept error, backing off", "error", err, "backoff", backoff)
+			} else {
+				slog.Error("Accept error, backing off", "error", err, "backoff", backoff)
+			}
 			select {
+			case <-time.After(backoff/2 + rand.N(backoff/2)):
 			case <-p.ctx.Done():
 				return
-			default:
-				slog.Error("Accept error", "error", err)
-				continue
 			}
+			continue
+		}
+		backoff = 0
+
+		if ip := addrIP(conn.RemoteAddr()); ip != nil && containsIP(p.proxyTrusted, ip) {
+			p.admitProxied(conn)
+			continue
+		}
+		p.admit(conn)
+	}
+}
+
+// Accept error backoff bounds, the delay doubles on every consecutive error
+const (
+	acceptBackoffMin = 5 * time.Millisecond
+	acceptBackoffMax = time.Second
+)
+
+// nextAcceptBackoff returns the delay after another consecutive accept error
+func nextAcceptBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return acceptBackoffMin
+	}
+	return min(backoff*2, acceptBackoffMax)
+}
+
+// isTemporaryAcceptError reports whether err is expected to clear up, such as fd exhaustion
+func isTemporaryAcceptError(err error) bool {
+	if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.ENOMEM) {
+		return true
+	}
+	var temp interface{ Temporary() bool }
+	return errors.As(err, &temp) && temp.Temporary()
+}
+
+// admit applies the client ACL and connection limit, then hands conn to its handler
+func (p *TransparentProxy) admit(conn net.Conn) {
+	if !p.allowClient(conn) || !p.takeSlot(conn) {
+		return
+	}
+
+	p.wg.Go(func() {
+		defer p.releaseConn()
+		p.handle(conn)
+	})
+}
+
+// admitProxied takes a connection slot for a trusted load balancer connection, then reads its
+// PROXY header and applies the client ACL to the announced address
+func (p *TransparentProxy) admitProxied(conn net.Conn) {
+	if !p.takeSlot(conn) {
+		return
+	}
+
+	// Read the header off the accept loop so a slow client can't hold up others
+	p.wg.Go(func() {
+		defer p.releaseConn()
+		pc, err := readProxyHeader(conn, proxyHeaderTimeout)
+		if err != nil {
+			p.stats.mu.Lock()
+			p.stats.deniedConnections++
+			p.stats.mu.Unlock()
+			slog.Warn("Invalid PROXY protocol header, rejecting connection", "remote", conn.RemoteAddr(), "error", err)
+			conn.Close()
+			return
+		}
+		if p.allowClient(pc) {
+			p.handle(pc)
 		}
+	})
+}
+
+// allowClient applies the client ACL, closing conn when its address is denied
+func (p *TransparentProxy) allowClient(conn net.Conn) bool {
+	if p.acl.AllowedAddr(conn.RemoteAddr()) {
+		return true
+	}
+	p.stats.mu.Lock()
+	p.stats.deniedConnections++
+	p.stats.mu.Unlock()
+	slog.Warn("Client address not allowed, rejecting connection", "remote", conn.RemoteAddr())
+	conn.Close()
+	return false
+}
+
+// takeSlot acquires a connection slot, closing conn when the limit is reached
+func (p *TransparentProxy) takeSlot(conn net.Conn) bool {
+	if p.acquireConn() {
+		return true
+	}
+	p.stats.mu.Lock()
+	p.stats.rejectedConnections++
+	p.stats.mu.Unlock()
+	slog.Debug("Connection limit reached, rejecting connection", "remote", conn.RemoteAddr(), "limit", cap(p.connSem))
+	conn.Close()
+	return false
+}
 
-		p.wg.Go(func() { p.handleConnection(conn) })
+// originalDestination prefers the destination announced in a PROXY header, otherwise it is
+// looked up on the underlying socket
+func (p *TransparentProxy) originalDestination(conn net.Conn) (OriginalDst, error) {
+	if pc, ok := conn.(*proxyProtocolConn); ok {
+		if dst, ok := pc.dst.(*net.TCPAddr); ok {
+			return OriginalDst{IP: dst.IP, Port: dst.Port}, nil
+		}
+		conn = pc.Conn
 	}
+	return p.getOriginalDestination(conn)
 }
 
 // handleConnection handles a single connection
@@ -138,7 +345,7 @@ func (p *TransparentProxy) handleConnection(clientConn net.Conn) {
 	}()
 
 	// Get original destination from connection
-	originalDst, err := p.getOriginalDestination(clientConn)
+	originalDst, err := p.originalDestination(clientConn)
 	if err != nil {
 		slog.Error("Failed to get original destination", "error", err)
 		return
@@ -164,21 +371,10 @@ func (p *TransparentProxy) handleConnection(clientConn net.Conn) {
 	}
 
 	// Connect to target
-	var targetConn net.Conn
-	targetHost := originalDst.IP.String()
-	targetPort := originalDst.Port
-	if p.upstream.IsEnabled() {
-		targetConn, err = p.upstream.Connect(targetHost, targetPort)
-		if err != nil {
-			slog.Error("Failed to connect via upstream proxy", "target", originalDst, "error", err)
-			return
-		}
-	} else {
-		targetConn, err = net.DialTCP("tcp", nil, &net.TCPAddr{IP: originalDst.IP, Port: originalDst.Port})
-		if err != nil {
-			slog.Error("Failed to connect to target", "target", originalDst, "error", err)
-			return
-		}
+	targetConn, err := p.dialTarget(clientConn.RemoteAddr(), originalDst)
+	if err != nil {
+		slog.Error("Failed to connect to target", "target", originalDst, "error", err)
+		return
 	}
 	defer targetConn.Close()
 
@@ -193,6 +389,22 @@ func (p *TransparentProxy) handleConnection(clientConn net.Conn) {
 	}
 }
 
+// dialTarget connects to dst on behalf of src via upstream when enabled, otherwise directly
+// unless direct connections are disabled
+func (p *TransparentProxy) dialTarget(src net.Addr, dst OriginalDst) (net.Conn, error) {
+	if p.upstream.IsEnabled() {
+		conn, err := p.upstream.ConnectFrom(src, dst.IP.String(), dst.Port)
+		if err != nil {
+			return nil, fmt.Errorf("upstream proxy: %w", err)
+		}
+		return conn, nil
+	}
+	if !p.enableDirect {
+		return nil, fmt.Errorf("upstream is forced but not enabled, refusing direct connection")
+	}
+	return net.DialTCP("tcp", nil, &net.TCPAddr{IP: dst.IP, Port: dst.Port})
+}
+
 // relayBidirectional relays data between client and target
 func (p *TransparentProxy) relayBidirectional(client, target net.Conn) (int64, error) {
 	errChan := make(chan error, 2)
@@ -250,6 +462,9 @@ func (p *TransparentProxy) GetStats() map[string]interface{} {
 	}
 	stats["total_connections"] = p.stats.totalConnections
 	stats["active_connections"] = p.stats.activeConnections
+	stats["rejected_connections"] = p.stats.rejectedConnections
+	stats["denied_connections"] = p.stats.deniedConnections
+	stats["accept_errors"] = p.stats.acceptErrors
 	stats["bytes_transferred"] = p.stats.bytesTransferred
 	stats["bytes_transferred_mb"] = float64(p.stats.bytesTransferred) / (1024 * 1024)
 	stats["uptime_seconds"] = uptime
@@ -263,6 +478,11 @@ func (p *TransparentProxy) IsRunning() bool {
 	return p.ctx.Err() == nil
 }
 
+// IsListening checks if the proxy is running with a bound listener
+func (p *TransparentProxy) IsListening() bool {
+	return p.IsRunning() && len(p.listeners) > 0
+}
+
 // GetListenAddr returns the listen address
 func (p *TransparentProxy) GetListenAddr() string {
 	return p.listenAddr
@@ -7,12 +7,16 @@ import (
 	"fmt"
 	"net"
 	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
 )
 
 // Socket options for getting original destination
 const (
-	SO_ORIGINAL_DST    = 80 // Linux socket option number
-	SO_RECVORIGDSTADDR = 74 // macOS/Linux socket option number
+	SO_ORIGINAL_DST      = 80 // Linux socket option number
+	IP6T_SO_ORIGINAL_DST = 80 // Same number on the IPv6 level, for ip6tables redirects
+	SO_RECVORIGDSTADDR   = 74 // macOS/Linux socket option number
 )
 
 func (p *TransparentProxy) getOriginalDestination(conn net.Conn) (OriginalDst, error) {
@@ -35,6 +39,10 @@ func (p *TransparentProxy) getSOOriginalDst(conn net.Conn) (OriginalDst, error)
 	}
 	defer file.Close()
 
+	if local, ok := tcpConn.LocalAddr().(*net.TCPAddr); ok && local.IP.To4() == nil {
+		return getSOOriginalDst6(int(file.Fd()))
+	}
+
 	addr, err := syscall.GetsockoptIPv6Mreq(int(file.Fd()), syscall.IPPROTO_IP, SO_ORIGINAL_DST)
 	if err != nil {
 		return OriginalDst{}, err
@@ -45,3 +53,18 @@ func (p *TransparentProxy) getSOOriginalDst(conn net.Conn) (OriginalDst, error)
 
 	return OriginalDst{IP: ip, Port: port}, nil
 }
+
+// getSOOriginalDst6 looks up the original destination of a connection redirected by ip6tables
+func getSOOriginalDst6(fd int) (OriginalDst, error) {
+	// IPv6MTUInfo starts with a sockaddr_in6 and is large enough for the kernel to fill one in
+	info, err := unix.GetsockoptIPv6MTUInfo(fd, unix.IPPROTO_IPV6, IP6T_SO_ORIGINAL_DST)
+	if err != nil {
+		return OriginalDst{}, err
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, info.Addr.Addr[:])
+	// sin6_port is in network byte order
+	port := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+	return OriginalDst{IP: ip, Port: int(port[0])<<8 | int(port[1])}, nil
+}
@@ -0,0 +1,532 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/monsterxx03/linko/pkg/config"
+)
+
+// startLimitedProxy starts a proxy whose handler holds each connection until release is closed
+func startLimitedProxy(t *testing.T, max int, queueTimeout time.Duration, acl *ACL) (*TransparentProxy, chan struct{}, chan struct{}) {
+	t.Helper()
+	p := NewTransparentProxy("127.0.0.1:0", NewUpstreamClient(config.UpstreamConfig{}))
+	p.SetConnectionLimit(max, queueTimeout)
+	p.SetACL(acl)
+
+	handled := make(chan struct{}, 100)
+	release := make(chan struct{})
+	p.handle = func(conn net.Conn) {
+		defer conn.Close()
+		handled <- struct{}{}
+		<-release
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	return p, handled, release
+}
+
+func dialProxy(t *testing.T, p *TransparentProxy) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", p.listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial proxy: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// expectClosed checks the proxy closed conn without handling it
+func expectClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Errorf("Expected rejected connection to be closed, got %v", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+func waitHandled(t *testing.T, handled chan struct{}, n int) {
+	t.Helper()
+	for i := range n {
+		select {
+		case <-handled:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected %d handled connections, got %d", n, i)
+		}
+	}
+}
+
+func TestTransparentProxy_ConnectionLimitRejects(t *testing.T) {
+	p, handled, release := startLimitedProxy(t, 2, 0, nil)
+	defer close(release)
+
+	dialProxy(t, p)
+	dialProxy(t, p)
+	waitHandled(t, handled, 2)
+
+	excess := dialProxy(t, p)
+	expectClosed(t, excess)
+
+	if got := p.GetStats()["rejected_connections"]; got != uint64(1) {
+		t.Errorf("Expected 1 rejected connection, got %v", got)
+	}
+}
+
+func TestTransparentProxy_ConnectionLimitQueues(t *testing.T) {
+	p, handled, release := startLimitedProxy(t, 1, 2*time.Second, nil)
+
+	dialProxy(t, p)
+	waitHandled(t, handled, 1)
+
+	// Second connection waits for the first slot instead of being rejected
+	dialProxy(t, p)
+	select {
+	case <-handled:
+		t.Fatal("Expected queued connection not to be handled while the limit is reached")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	waitHandled(t, handled, 1)
+
+	if got := p.GetStats()["rejected_connections"]; got != uint64(0) {
+		t.Errorf("Expected no rejected connections, got %v", got)
+	}
+}
+
+func TestTransparentProxy_NoConnectionLimit(t *testing.T) {
+	p, handled, release := startLimitedProxy(t, 0, 0, nil)
+	defer close(release)
+
+	for range 10 {
+		dialProxy(t, p)
+	}
+	waitHandled(t, handled, 10)
+}
+
+func TestTransparentProxy_ACLAllowed(t *testing.T) {
+	acl, err := NewACL([]string{"127.0.0.0/8"}, nil)
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	p, handled, release := startLimitedProxy(t, 0, 0, acl)
+	defer close(release)
+
+	dialProxy(t, p)
+	waitHandled(t, handled, 1)
+}
+
+func TestTransparentProxy_ACLDenied(t *testing.T) {
+	acl, err := NewACL([]string{"127.0.0.0/8"}, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	p, handled, release := startLimitedProxy(t, 0, 0, acl)
+	defer close(release)
+
+	expectClosed(t, dialProxy(t, p))
+	select {
+	case <-handled:
+		t.Error("Expected denied client not to be handled")
+	default:
+	}
+	if got := p.GetStats()["denied_connections"]; got != uint64(1) {
+		t.Errorf("Expected 1 denied connection, got %v", got)
+	}
+}
+
+func TestACL_Allowed(t *testing.T) {
+	acl, err := NewACL([]string{"10.0.0.0/8", "192.168.1.5"}, []string{"10.0.0.9", "::1"})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"192.168.1.5", true},
+		{"192.168.1.6", false},
+		{"10.0.0.9", false},
+		{"::1", false},
+	}
+	for _, tt := range tests {
+		if got := acl.Allowed(net.ParseIP(tt.ip)); got != tt.allowed {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.ip, got, tt.allowed)
+		}
+	}
+
+	if _, err := NewACL([]string{"not-an-ip"}, nil); err == nil {
+		t.Error("Expected error for invalid entry")
+	}
+}
+
+// startRecordingSOCKS5Server is a SOCKS5 upstream that reports each CONNECT target on targets
+func startRecordingSOCKS5Server(t *testing.T) (string, chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	targets := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 3)
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					return
+				}
+				conn.Write([]byte{0x05, 0x00})
+				req := make([]byte, 10)
+				if _, err := io.ReadFull(conn, req); err != nil {
+					return
+				}
+				targets <- net.JoinHostPort(net.IP(req[4:8]).String(), strconv.Itoa(int(req[8])<<8|int(req[9])))
+				conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+			}()
+		}
+	}()
+	return listener.Addr().String(), targets
+}
+
+func TestTransparentProxy_ForceUpstreamSendsDomesticIPUpstream(t *testing.T) {
+	addr, targets := startRecordingSOCKS5Server(t)
+	p := NewTransparentProxy("127.0.0.1:0", NewUpstreamClient(config.UpstreamConfig{Enable: true, Type: "socks5", Addr: addr}))
+	p.SetForceUpstream(true)
+
+	// 114.114.114.114 is a China IP that would be bypassed by GeoIP routing
+	conn, err := p.dialTarget(nil, OriginalDst{IP: net.ParseIP("114.114.114.114"), Port: 443})
+	if err != nil {
+		t.Fatalf("dialTarget failed: %v", err)
+	}
+	conn.Close()
+
+	select {
+	case target := <-targets:
+		if target != "114.114.114.114:443" {
+			t.Errorf("Expected upstream CONNECT to 114.114.114.114:443, got %s", target)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected connection to go via upstream")
+	}
+}
+
+func TestTransparentProxy_ForceUpstreamRefusesDirect(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer target.Close()
+	dst := OriginalDst{IP: net.ParseIP("127.0.0.1"), Port: target.Addr().(*net.TCPAddr).Port}
+
+	p := NewTransparentProxy("127.0.0.1:0", NewUpstreamClient(config.UpstreamConfig{}))
+	conn, err := p.dialTarget(nil, dst)
+	if err != nil {
+		t.Fatalf("Expected direct connection without force, got %v", err)
+	}
+	conn.Close()
+
+	p.SetForceUpstream(true)
+	if conn, err := p.dialTarget(nil, dst); err == nil {
+		conn.Close()
+		t.Fatal("Expected error instead of direct connection when upstream is forced but disabled")
+	}
+}
+
+// acceptedConn is what a PROXY protocol proxy's handler saw on an inbound connection
+type acceptedConn struct {
+	remote string
+	dst    OriginalDst
+	data   string
+}
+
+// startProxyProtocolProxy starts a proxy expecting PROXY headers from trusted whose handler reports each connection
+func startProxyProtocolProxy(t *testing.T, trusted string, acl *ACL, max int) (*TransparentProxy, chan acceptedConn) {
+	t.Helper()
+	p := NewTransparentProxy("127.0.0.1:0", NewUpstreamClient(config.UpstreamConfig{}))
+	if err := p.SetProxyProtocol([]string{trusted}); err != nil {
+		t.Fatalf("SetProxyProtocol failed: %v", err)
+	}
+	p.SetACL(acl)
+	p.SetConnectionLimit(max, 0)
+
+	accepted := make(chan acceptedConn, 10)
+	p.handle = func(conn net.Conn) {
+		defer conn.Close()
+		dst, _ := p.originalDestination(conn)
+		buf := make([]byte, 5)
+		n, _ := io.ReadFull(conn, buf)
+		accepted <- acceptedConn{remote: conn.RemoteAddr().String(), dst: dst, data: string(buf[:n])}
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	return p, accepted
+}
+
+func TestTransparentProxy_ProxyProtocolHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4000}
+	dst := &net.TCPAddr{IP: net.ParseIP("93.184.216.34"), Port: 443}
+	v2, err := proxyProtocolHeader(ProxyProtocolV2, src, dst)
+	if err != nil {
+		t.Fatalf("proxyProtocolHeader failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		header []byte
+	}{
+		{"v1", []byte("PROXY TCP4 203.0.113.7 93.184.216.34 4000 443\r\n")},
+		{"v2", v2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, accepted := startProxyProtocolProxy(t, "127.0.0.1", nil, 0)
+			conn := dialProxy(t, p)
+			conn.Write(append(tt.header, "hello"...))
+
+			select {
+			case got := <-accepted:
+				if got.remote != "203.0.113.7:4000" {
+					t.Errorf("Expected announced client address, got %s", got.remote)
+				}
+				if !got.dst.IP.Equal(dst.IP) || got.dst.Port != 443 {
+					t.Errorf("Expected announced destination %s, got %v", dst, got.dst)
+				}
+				if got.data != "hello" {
+					t.Errorf("Expected client data after header, got %q", got.data)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("Timed out waiting for connection to be handled")
+			}
+		})
+	}
+}
+
+func TestTransparentProxy_ProxyProtocolACL(t *testing.T) {
+	// The load balancer (127.0.0.1) is allowed, the client it announces is not
+	acl, err := NewACL([]string{"127.0.0.0/8"}, nil)
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	p, accepted := startProxyProtocolProxy(t, "127.0.0.1", acl, 0)
+
+	conn := dialProxy(t, p)
+	conn.Write([]byte("PROXY TCP4 203.0.113.7 93.184.216.34 4000 443\r\nhello"))
+	expectClosed(t, conn)
+	select {
+	case <-accepted:
+		t.Error("Expected announced client to be denied by ACL")
+	default:
+	}
+}
+
+func TestTransparentProxy_ProxyProtocolUntrustedPeer(t *testing.T) {
+	// A header from a peer outside the trusted list is not parsed, the ACL sees the real peer
+	acl, err := NewACL([]string{"203.0.113.0/24"}, nil)
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	p, accepted := startProxyProtocolProxy(t, "192.0.2.1", acl, 0)
+
+	conn := dialProxy(t, p)
+	conn.Write([]byte("PROXY TCP4 203.0.113.7 93.184.216.34 4000 443\r\nhello"))
+	expectClosed(t, conn)
+	select {
+	case <-accepted:
+		t.Error("Expected spoofed client address to be ignored")
+	default:
+	}
+	if got := p.GetStats()["denied_connections"]; got != uint64(1) {
+		t.Errorf("Expected 1 denied connection, got %v", got)
+	}
+}
+
+func TestTransparentProxy_ProxyProtocolLimitBeforeHeader(t *testing.T) {
+	p, _ := startProxyProtocolProxy(t, "127.0.0.1", nil, 1)
+
+	// The first connection holds the only slot while its header is pending
+	dialProxy(t, p)
+	expectClosed(t, dialProxy(t, p))
+	if got := p.GetStats()["rejected_connections"]; got != uint64(1) {
+		t.Errorf("Expected 1 rejected connection, got %v", got)
+	}
+}
+
+func TestTransparentProxy_ProxyProtocolMalformed(t *testing.T) {
+	tests := []string{
+		"GET / HTTP/1.1\r\n\r\n",
+		"PROXY TCP4 203.0.113.7 nope 4000 443\r\n",
+		"PROXY TCP4 ::1 ::1 4000 443\r\n",
+		"PROXY TCP4 203.0.113.7 93.184.216.34 4000\r\n",
+	}
+	for _, header := range tests {
+		p, accepted := startProxyProtocolProxy(t, "127.0.0.1", nil, 0)
+		conn := dialProxy(t, p)
+		conn.Write([]byte(header))
+		expectClosed(t, conn)
+		select {
+		case <-accepted:
+			t.Errorf("Expected header %q to be rejected", header)
+		default:
+		}
+		if got := p.GetStats()["denied_connections"]; got != uint64(1) {
+			t.Errorf("Expected 1 denied connection for %q, got %v", header, got)
+		}
+	}
+}
+
+func TestTransparentProxy_DualStack(t *testing.T) {
+	if l, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	} else {
+		l.Close()
+	}
+
+	p := NewTransparentProxy("127.0.0.1:0", NewUpstreamClient(config.UpstreamConfig{}))
+	p.SetListenFamily(ListenFamilyDual)
+	handled := make(chan string, 2)
+	p.handle = func(conn net.Conn) {
+		defer conn.Close()
+		handled <- conn.LocalAddr().String()
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer p.Stop()
+
+	if len(p.listeners) != 2 {
+		t.Fatalf("Expected IPv4 and IPv6 listeners, got %d", len(p.listeners))
+	}
+	port := strconv.Itoa(p.listeners[0].Addr().(*net.TCPAddr).Port)
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		conn, err := net.Dial("tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			t.Fatalf("Failed to dial proxy on %s: %v", host, err)
+		}
+		conn.Close()
+		select {
+		case local := <-handled:
+			if want := net.JoinHostPort(host, port); local != want {
+				t.Errorf("Expected connection on %s, got %s", want, local)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Connection over %s was not handled", host)
+		}
+	}
+}
+
+func TestListenSpecs(t *testing.T) {
+	tests := []struct {
+		family, addr string
+		want         []listenSpec
+		wantErr      bool
+	}{
+		{ListenFamilyAny, "127.0.0.1:9890", []listenSpec{{"tcp", "127.0.0.1:9890"}}, false},
+		{ListenFamilyIPv4, "[::]:9890", []listenSpec{{"tcp4", "0.0.0.0:9890"}}, false},
+		{ListenFamilyIPv6, "127.0.0.1:9890", []listenSpec{{"tcp6", "[::1]:9890"}}, false},
+		{ListenFamilyDual, ":9890", []listenSpec{{"tcp4", "0.0.0.0:9890"}, {"tcp6", "[::]:9890"}}, false},
+		{ListenFamilyIPv4, "192.168.1.2:9890", []listenSpec{{"tcp4", "192.168.1.2:9890"}}, false},
+		{ListenFamilyDual, "192.168.1.2:9890", nil, true},
+		{ListenFamilyIPv6, "example.com:9890", nil, true},
+		{"ipv5", "127.0.0.1:9890", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := listenSpecs(tt.family, tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("listenSpecs(%q, %q) error = %v, wantErr %v", tt.family, tt.addr, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("listenSpecs(%q, %q) = %v, want %v", tt.family, tt.addr, got, tt.want)
+		}
+	}
+}
+
+// failingListener fails Accept with err until closed, counting the calls
+type failingListener struct {
+	err    error
+	calls  atomic.Int32
+	closed chan struct{}
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.calls.Add(1)
+	select {
+	case <-l.closed:
+		return nil, net.ErrClosed
+	default:
+		return nil, l.err
+	}
+}
+
+func (l *failingListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func (l *failingListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+func TestTransparentProxy_AcceptErrorBackoff(t *testing.T) {
+	p := NewTransparentProxy("127.0.0.1:0", NewUpstreamClient(config.UpstreamConfig{}))
+	listener := &failingListener{
+		err:    &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)},
+		closed: make(chan struct{}),
+	}
+	p.listeners = []net.Listener{listener}
+	p.wg.Add(1)
+	go p.acceptLoop(listener)
+
+	time.Sleep(200 * time.Millisecond)
+	p.Stop()
+	p.wg.Wait()
+
+	// Backing off from 5ms doubling allows only a handful of retries, a busy loop makes millions
+	calls := listener.calls.Load()
+	if calls < 2 || calls > 10 {
+		t.Errorf("Expected a few backed-off Accept calls in 200ms, got %d", calls)
+	}
+	if got := p.GetStats()["accept_errors"].(uint64); got == 0 {
+		t.Error("Expected accept errors to be counted")
+	}
+}
+
+func TestIsTemporaryAcceptError(t *testing.T) {
+	if !isTemporaryAcceptError(&net.OpError{Op: "accept", Err: os.NewSyscallError("accept", syscall.EMFILE)}) {
+		t.Error("Expected EMFILE to be temporary")
+	}
+	if isTemporaryAcceptError(errors.New("boom")) {
+		t.Error("Expected a plain error not to be temporary")
+	}
+	if got := nextAcceptBackoff(0); got != acceptBackoffMin {
+		t.Errorf("Expected first backoff %v, got %v", acceptBackoffMin, got)
+	}
+	if got := nextAcceptBackoff(acceptBackoffMax); got != acceptBackoffMax {
+		t.Errorf("Expected backoff capped at %v, got %v", acceptBackoffMax, got)
+	}
+}
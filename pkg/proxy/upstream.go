@@ -7,6 +7,8 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/monsterxx03/linko/pkg/config"
 )
@@ -16,66 +18,234 @@ type UpstreamClient struct {
 	config config.UpstreamConfig
 	client net.Conn
 	ctx    context.Context
+	pool   *upstreamPool
+
+	attempts  atomic.Uint64 // Connects through the upstream proxy
+	successes atomic.Uint64
+	failures  atomic.Uint64
+	connectNs atomic.Uint64 // Total latency of successful connects, handshake included
+}
+
+// UpstreamStats are connect counters of the upstream proxy
+type UpstreamStats struct {
+	Addr           string
+	Type           string
+	Attempts       uint64
+	Successes      uint64
+	Failures       uint64
+	SuccessRate    float64 // Percentage of attempts that connected
+	AvgConnectTime time.Duration
+	PoolHits       uint64 // Connects served from a warm connection
+	PoolMisses     uint64
 }
 
 // NewUpstreamClient creates a new upstream client
 func NewUpstreamClient(config config.UpstreamConfig) *UpstreamClient {
-	return &UpstreamClient{
+	u := &UpstreamClient{
 		config: config,
 		ctx:    context.Background(),
 	}
+	// A PROXY protocol header names a single client, so pre-dialed connections can't be used
+	if config.Enable && config.PoolSize > 0 && config.ProxyProtocol == "" {
+		u.pool = newUpstreamPool(config.Addr, config.PoolSize, config.PoolIdleTTL, net.Dial)
+	}
+	return u
+}
+
+// dialUpstream returns a connection to the upstream proxy, from the warm pool when enabled
+func (u *UpstreamClient) dialUpstream() (net.Conn, bool, error) {
+	if u.pool != nil {
+		return u.pool.get()
+	}
+	conn, err := net.Dial("tcp", u.config.Addr)
+	return conn, false, err
+}
+
+// connectVia dials the upstream proxy and runs handshake on it. A pooled connection may have
+// been closed by the upstream while idle, so a failed handshake on it is retried once on a fresh dial.
+func (u *UpstreamClient) connectVia(proxyType string, src net.Addr, handshake func(net.Conn) error) (net.Conn, error) {
+	conn, pooled, err := u.dialUpstream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s proxy: %w", proxyType, err)
+	}
+	if err := u.writeProxyHeader(conn, src); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := handshake(conn); err != nil {
+		conn.Close()
+		if !pooled {
+			return nil, err
+		}
+		if conn, err = u.pool.dial("tcp", u.pool.addr); err != nil {
+			return nil, fmt.Errorf("failed to connect to %s proxy: %w", proxyType, err)
+		}
+		if err := handshake(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// writeProxyHeader sends the PROXY protocol header for src ahead of the proxy handshake
+func (u *UpstreamClient) writeProxyHeader(conn net.Conn, src net.Addr) error {
+	if u.config.ProxyProtocol == "" {
+		return nil
+	}
+	header, err := proxyProtocolHeader(u.config.ProxyProtocol, src, conn.RemoteAddr())
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(header); err != nil {
+		return fmt.Errorf("failed to send PROXY protocol header: %w", err)
+	}
+	return nil
 }
 
 // Connect establishes a connection to target through upstream proxy
 func (u *UpstreamClient) Connect(targetHost string, targetPort int) (net.Conn, error) {
+	return u.ConnectFrom(nil, targetHost, targetPort)
+}
+
+// ConnectFrom is Connect on behalf of the client at src, which is announced to the upstream
+// in a PROXY protocol header when configured
+func (u *UpstreamClient) ConnectFrom(src net.Addr, targetHost string, targetPort int) (net.Conn, error) {
 	if !u.config.Enable {
 		// Direct connection if upstream is disabled
 		return net.Dial("tcp", fmt.Sprintf("%s:%d", targetHost, targetPort))
 	}
 
+	start := time.Now()
+	var conn net.Conn
+	var err error
 	switch u.config.Type {
 	case "socks5":
-		return u.connectSOCKS5(targetHost, targetPort)
+		conn, err = u.connectSOCKS5(src, targetHost, targetPort)
 	case "http":
-		return u.connectHTTP(targetHost, targetPort)
+		conn, err = u.connectHTTP(src, targetHost, targetPort)
 	default:
 		return nil, fmt.Errorf("unsupported upstream proxy type: %s", u.config.Type)
 	}
+	u.recordConnect(time.Since(start), err)
+	return conn, err
 }
 
-// connectSOCKS5 connects through SOCKS5 upstream proxy
-func (u *UpstreamClient) connectSOCKS5(targetHost string, targetPort int) (net.Conn, error) {
-	// Connect to SOCKS5 proxy
-	conn, err := net.Dial("tcp", u.config.Addr)
+// recordConnect counts a connect attempt through the upstream proxy
+func (u *UpstreamClient) recordConnect(latency time.Duration, err error) {
+	u.attempts.Add(1)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to SOCKS5 proxy: %w", err)
+		u.failures.Add(1)
+		return
 	}
+	u.successes.Add(1)
+	u.connectNs.Add(uint64(latency))
+}
 
-	// SOCKS5 handshake
-	if err := u.socks5Handshake(conn, targetHost, targetPort); err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("SOCKS5 handshake failed: %w", err)
+// Stats returns the connect counters of the upstream proxy
+func (u *UpstreamClient) Stats() UpstreamStats {
+	stats := UpstreamStats{
+		Addr:      u.config.Addr,
+		Type:      u.config.Type,
+		Attempts:  u.attempts.Load(),
+		Successes: u.successes.Load(),
+		Failures:  u.failures.Load(),
+	}
+	if stats.Attempts > 0 {
+		stats.SuccessRate = float64(stats.Successes) / float64(stats.Attempts) * 100
 	}
+	if stats.Successes > 0 {
+		stats.AvgConnectTime = time.Duration(u.connectNs.Load() / stats.Successes)
+	}
+	if u.pool != nil {
+		stats.PoolHits = u.pool.hits.Load()
+		stats.PoolMisses = u.pool.misses.Load()
+	}
+	return stats
+}
 
-	return conn, nil
+// connectSOCKS5 connects through SOCKS5 upstream proxy
+func (u *UpstreamClient) connectSOCKS5(src net.Addr, targetHost string, targetPort int) (net.Conn, error) {
+	// Connect to SOCKS5 proxy and perform handshake
+	return u.connectVia("SOCKS5", src, func(conn net.Conn) error {
+		if err := u.socks5Handshake(conn, targetHost, targetPort); err != nil {
+			return fmt.Errorf("SOCKS5 handshake failed: %w", err)
+		}
+		return nil
+	})
 }
 
-// socks5Handshake performs SOCKS5 authentication and connection
-func (u *UpstreamClient) socks5Handshake(conn net.Conn, targetHost string, targetPort int) error {
-	// Send authentication request (no authentication)
-	authReq := []byte{0x05, 0x01, 0x00}
+// SOCKS5 authentication methods (RFC 1928)
+const (
+	socks5MethodNoAuth       = 0x00
+	socks5MethodUserPass     = 0x02
+	socks5MethodNoAcceptable = 0xFF
+)
+
+// socks5Negotiate offers no-auth, plus username/password when credentials are configured,
+// and authenticates with whichever method the proxy selects
+func (u *UpstreamClient) socks5Negotiate(conn net.Conn) error {
+	methods := []byte{socks5MethodNoAuth}
+	if u.config.Username != "" {
+		methods = append(methods, socks5MethodUserPass)
+	}
+	authReq := append([]byte{0x05, byte(len(methods))}, methods...)
 	if _, err := conn.Write(authReq); err != nil {
 		return err
 	}
 
-	// Read authentication response
 	authResp := make([]byte, 2)
 	if _, err := io.ReadFull(conn, authResp); err != nil {
 		return err
 	}
-	if authResp[0] != 0x05 || authResp[1] != 0x00 {
+	if authResp[0] != 0x05 {
+		return fmt.Errorf("unexpected SOCKS version: %d", authResp[0])
+	}
+
+	switch authResp[1] {
+	case socks5MethodNoAuth:
+		return nil
+	case socks5MethodUserPass:
+		if u.config.Username == "" {
+			return fmt.Errorf("SOCKS5 proxy selected username/password authentication, which was not offered")
+		}
+		return u.socks5UserPassAuth(conn)
+	case socks5MethodNoAcceptable:
+		return fmt.Errorf("SOCKS5 proxy accepted none of the offered authentication methods")
+	default:
+		return fmt.Errorf("SOCKS5 proxy selected unsupported authentication method: %d", authResp[1])
+	}
+}
+
+// socks5UserPassAuth performs username/password authentication (RFC 1929)
+func (u *UpstreamClient) socks5UserPassAuth(conn net.Conn) error {
+	user, pass := u.config.Username, u.config.Password
+	if len(user) > 255 || len(pass) > 255 {
+		return fmt.Errorf("SOCKS5 username or password longer than 255 bytes")
+	}
+	req := []byte{0x01, byte(len(user))}
+	req = append(req, user...)
+	req = append(req, byte(len(pass)))
+	req = append(req, pass...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	if resp[1] != 0x00 {
 		return fmt.Errorf("SOCKS5 authentication failed")
 	}
+	return nil
+}
+
+// socks5Handshake performs SOCKS5 authentication and connection
+func (u *UpstreamClient) socks5Handshake(conn net.Conn, targetHost string, targetPort int) error {
+	if err := u.socks5Negotiate(conn); err != nil {
+		return err
+	}
 
 	// Build CONNECT request
 	var addr []byte
@@ -119,36 +289,31 @@ func (u *UpstreamClient) socks5Handshake(conn net.Conn, targetHost string, targe
 }
 
 // connectHTTP connects through HTTP upstream proxy
-func (u *UpstreamClient) connectHTTP(targetHost string, targetPort int) (net.Conn, error) {
-	// Connect to HTTP proxy
-	conn, err := net.Dial("tcp", u.config.Addr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to HTTP proxy: %w", err)
-	}
-
-	// Send CONNECT request
-	connectReq := fmt.Sprintf("CONNECT %s:%d HTTP/1.1\r\nHost: %s:%d\r\n\r\n", targetHost, targetPort, targetHost, targetPort)
-	if _, err := conn.Write([]byte(connectReq)); err != nil {
-		conn.Close()
-		return nil, err
-	}
-
-	// Read CONNECT response
-	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
-	if err != nil {
-		conn.Close()
-		return nil, err
-	}
-	if resp.StatusCode != 200 {
-		conn.Close()
-		return nil, fmt.Errorf("HTTP CONNECT failed: %d", resp.StatusCode)
-	}
+func (u *UpstreamClient) connectHTTP(src net.Addr, targetHost string, targetPort int) (net.Conn, error) {
+	// Connect to HTTP proxy and send CONNECT request
+	return u.connectVia("HTTP", src, func(conn net.Conn) error {
+		connectReq := fmt.Sprintf("CONNECT %s:%d HTTP/1.1\r\nHost: %s:%d\r\n\r\n", targetHost, targetPort, targetHost, targetPort)
+		if _, err := conn.Write([]byte(connectReq)); err != nil {
+			return err
+		}
 
-	return conn, nil
+		// Read CONNECT response
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("HTTP CONNECT failed: %d", resp.StatusCode)
+		}
+		return nil
+	})
 }
 
 // Close closes the upstream client
 func (u *UpstreamClient) Close() error {
+	if u.pool != nil {
+		u.pool.close()
+	}
 	if u.client != nil {
 		return u.client.Close()
 	}
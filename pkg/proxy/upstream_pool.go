@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pooledConn is an idle pre-dialed connection to the upstream proxy
+type pooledConn struct {
+	conn      net.Conn
+	createdAt time.Time
+}
+
+// upstreamPool keeps warm TCP connections to the upstream proxy so Connect skips the dial.
+// SOCKS5/CONNECT tunnels are bound to one target, so connections are never returned to the pool,
+// instead the pool is refilled in the background after each use.
+type upstreamPool struct {
+	addr    string
+	size    int
+	idleTTL time.Duration
+	dial    func(network, addr string) (net.Conn, error)
+
+	mu      sync.Mutex
+	idle    []pooledConn
+	filling bool
+	closed  bool
+
+	hits   atomic.Uint64 // Connect served from a warm connection
+	misses atomic.Uint64 // Connect had to dial inline
+}
+
+// newUpstreamPool creates a pool and starts warming it up
+func newUpstreamPool(addr string, size int, idleTTL time.Duration, dial func(network, addr string) (net.Conn, error)) *upstreamPool {
+	p := &upstreamPool{
+		addr:    addr,
+		size:    size,
+		idleTTL: idleTTL,
+		dial:    dial,
+	}
+	p.refill()
+	return p
+}
+
+// get returns a warm connection if available, otherwise dials a new one
+func (p *upstreamPool) get() (net.Conn, bool, error) {
+	p.mu.Lock()
+	for len(p.idle) > 0 {
+		// Take the newest connection, it's the least likely to be closed by the upstream
+		pc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if p.idleTTL > 0 && time.Since(pc.createdAt) > p.idleTTL {
+			pc.conn.Close()
+			continue
+		}
+		p.mu.Unlock()
+		p.hits.Add(1)
+		p.refill()
+		return pc.conn, true, nil
+	}
+	p.mu.Unlock()
+
+	p.misses.Add(1)
+	p.refill()
+	conn, err := p.dial("tcp", p.addr)
+	return conn, false, err
+}
+
+// refill tops up idle connections in the background, at most one filler runs at a time
+func (p *upstreamPool) refill() {
+	p.mu.Lock()
+	if p.filling || p.closed || len(p.idle) >= p.size {
+		p.mu.Unlock()
+		return
+	}
+	p.filling = true
+	p.mu.Unlock()
+
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			p.filling = false
+			p.mu.Unlock()
+		}()
+
+		for {
+			p.mu.Lock()
+			need := !p.closed && len(p.idle) < p.size
+			p.mu.Unlock()
+			if !need {
+				return
+			}
+
+			conn, err := p.dial("tcp", p.addr)
+			if err != nil {
+				slog.Debug("failed to warm upstream connection", "addr", p.addr, "error", err)
+				return
+			}
+
+			p.mu.Lock()
+			if p.closed || len(p.idle) >= p.size {
+				p.mu.Unlock()
+				conn.Close()
+				return
+			}
+			p.idle = append(p.idle, pooledConn{conn: conn, createdAt: time.Now()})
+			p.mu.Unlock()
+		}
+	}()
+}
+
+// idleCount returns the number of warm connections
+func (p *upstreamPool) idleCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// close closes all idle connections and stops refilling
+func (p *upstreamPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, pc := range p.idle {
+		pc.conn.Close()
+	}
+	p.idle = nil
+}
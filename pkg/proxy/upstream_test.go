@@ -0,0 +1,365 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monsterxx03/linko/pkg/config"
+)
+
+// startSOCKS5Server accepts SOCKS5 CONNECT requests and replies success without dialing the target
+func startSOCKS5Server(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				// auth: VER NMETHODS METHODS
+				buf := make([]byte, 3)
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					return
+				}
+				conn.Write([]byte{0x05, 0x00})
+				// connect: VER CMD RSV ATYP(ipv4) ADDR PORT
+				req := make([]byte, 10)
+				if _, err := io.ReadFull(conn, req); err != nil {
+					return
+				}
+				conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func waitIdle(t *testing.T, pool *upstreamPool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for pool.idleCount() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d warm connections, got %d", n, pool.idleCount())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUpstreamClient_PoolReusesWarmConnections(t *testing.T) {
+	addr := startSOCKS5Server(t)
+
+	var dials atomic.Int32
+	dial := func(network, addr string) (net.Conn, error) {
+		dials.Add(1)
+		return net.Dial(network, addr)
+	}
+
+	client := NewUpstreamClient(config.UpstreamConfig{Enable: true, Type: "socks5", Addr: addr})
+	client.pool = newUpstreamPool(addr, 4, time.Minute, dial)
+	defer client.Close()
+
+	waitIdle(t, client.pool, 4)
+	warmDials := dials.Load()
+
+	for range 3 {
+		conn, err := client.Connect("127.0.0.1", 443)
+		if err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		conn.Close()
+		waitIdle(t, client.pool, 4)
+	}
+
+	if hits := client.pool.hits.Load(); hits != 3 {
+		t.Errorf("Expected 3 pool hits, got %d", hits)
+	}
+	if misses := client.pool.misses.Load(); misses != 0 {
+		t.Errorf("Expected no inline dials, got %d", misses)
+	}
+	// Only the background refill dials, one per consumed connection
+	if got := dials.Load() - warmDials; got != 3 {
+		t.Errorf("Expected 3 refill dials, got %d", got)
+	}
+}
+
+func TestUpstreamPool_ExpiredConnectionsDiscarded(t *testing.T) {
+	addr := startSOCKS5Server(t)
+
+	pool := newUpstreamPool(addr, 1, time.Millisecond, net.Dial)
+	defer pool.close()
+	waitIdle(t, pool, 1)
+	time.Sleep(10 * time.Millisecond)
+
+	conn, pooled, err := pool.get()
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	conn.Close()
+	if pooled {
+		t.Error("Expected expired connection to be discarded and a fresh one dialed")
+	}
+}
+
+func TestNewUpstreamClient_PoolDisabledByDefault(t *testing.T) {
+	client := NewUpstreamClient(config.UpstreamConfig{Enable: true, Type: "socks5", Addr: "127.0.0.1:1"})
+	if client.pool != nil {
+		t.Error("Expected no pool when pool_size is 0")
+	}
+}
+
+func TestSOCKS5Negotiate_NoAcceptableMethods(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		buf := make([]byte, 3)
+		if _, err := io.ReadFull(server, buf); err != nil {
+			return
+		}
+		server.Write([]byte{0x05, 0xFF})
+	}()
+
+	u := NewUpstreamClient(config.UpstreamConfig{Enable: true, Type: "socks5"})
+	err := u.socks5Negotiate(client)
+	if err == nil || !strings.Contains(err.Error(), "none of the offered") {
+		t.Fatalf("Expected no acceptable methods error, got %v", err)
+	}
+}
+
+func TestSOCKS5Negotiate_UserPass(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	offered := make(chan []byte, 1)
+	creds := make(chan string, 1)
+	go func() {
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(server, head); err != nil {
+			return
+		}
+		methods := make([]byte, head[1])
+		if _, err := io.ReadFull(server, methods); err != nil {
+			return
+		}
+		offered <- methods
+		server.Write([]byte{0x05, 0x02})
+
+		// VER ULEN UNAME PLEN PASSWD
+		ver := make([]byte, 2)
+		if _, err := io.ReadFull(server, ver); err != nil {
+			return
+		}
+		user := make([]byte, ver[1])
+		io.ReadFull(server, user)
+		plen := make([]byte, 1)
+		io.ReadFull(server, plen)
+		pass := make([]byte, plen[0])
+		io.ReadFull(server, pass)
+		creds <- string(user) + ":" + string(pass)
+		server.Write([]byte{0x01, 0x00})
+	}()
+
+	u := NewUpstreamClient(config.UpstreamConfig{Enable: true, Type: "socks5", Username: "alice", Password: "secret"})
+	if err := u.socks5Negotiate(client); err != nil {
+		t.Fatalf("Negotiation failed: %v", err)
+	}
+	if got := <-offered; string(got) != string([]byte{0x00, 0x02}) {
+		t.Errorf("Expected no-auth and user/pass offered, got %v", got)
+	}
+	if got := <-creds; got != "alice:secret" {
+		t.Errorf("Expected credentials alice:secret, got %q", got)
+	}
+}
+
+// startProxyProtocolSOCKS5Server expects a PROXY header of headerLen bytes before the SOCKS5 handshake
+// and reports everything the client sent, split into header and application data
+func startProxyProtocolSOCKS5Server(t *testing.T, headerLen int) (string, <-chan [2][]byte) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	got := make(chan [2][]byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		header := make([]byte, headerLen)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		buf := make([]byte, 3)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		conn.Write([]byte{0x05, 0x00})
+		req := make([]byte, 10)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		data, _ := io.ReadAll(conn)
+		got <- [2][]byte{header, data}
+	}()
+	return listener.Addr().String(), got
+}
+
+func TestUpstreamClient_ProxyProtocolHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 5555}
+
+	tests := []struct {
+		name    string
+		version string
+		src     net.Addr
+		want    func(dst *net.TCPAddr) []byte
+	}{
+		{
+			name:    "v1",
+			version: ProxyProtocolV1,
+			src:     src,
+			want: func(dst *net.TCPAddr) []byte {
+				return []byte(fmt.Sprintf("PROXY TCP4 10.1.2.3 127.0.0.1 5555 %d\r\n", dst.Port))
+			},
+		},
+		{
+			name:    "v1 unknown source",
+			version: ProxyProtocolV1,
+			want: func(dst *net.TCPAddr) []byte {
+				return []byte("PROXY UNKNOWN\r\n")
+			},
+		},
+		{
+			name:    "v2",
+			version: ProxyProtocolV2,
+			src:     src,
+			want: func(dst *net.TCPAddr) []byte {
+				want := []byte("\r\n\r\n\x00\r\nQUIT\n")
+				want = append(want, 0x21, 0x11, 0x00, 0x0c, 10, 1, 2, 3, 127, 0, 0, 1, 0x15, 0xb3)
+				return append(want, byte(dst.Port>>8), byte(dst.Port))
+			},
+		},
+		{
+			name:    "v2 unknown source",
+			version: ProxyProtocolV2,
+			want: func(dst *net.TCPAddr) []byte {
+				return append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x20, 0x00, 0x00, 0x00)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Header length only depends on the address family, so a placeholder port sizes it
+			headerLen := len(tt.want(&net.TCPAddr{Port: 10000}))
+			addr, got := startProxyProtocolSOCKS5Server(t, headerLen)
+			dst, err := net.ResolveTCPAddr("tcp", addr)
+			if err != nil {
+				t.Fatalf("ResolveTCPAddr failed: %v", err)
+			}
+			want := tt.want(dst)
+			if len(want) != headerLen {
+				t.Skipf("listener port %d changes v1 header length", dst.Port)
+			}
+
+			client := NewUpstreamClient(config.UpstreamConfig{
+				Enable: true, Type: "socks5", Addr: addr, PoolSize: 4, ProxyProtocol: tt.version,
+			})
+			defer client.Close()
+			if client.pool != nil {
+				t.Fatal("Expected pooling to be disabled with PROXY protocol")
+			}
+
+			conn, err := client.ConnectFrom(tt.src, "93.184.216.34", 443)
+			if err != nil {
+				t.Fatalf("ConnectFrom failed: %v", err)
+			}
+			conn.Write([]byte("hello"))
+			conn.Close()
+
+			select {
+			case sent := <-got:
+				if !bytes.Equal(sent[0], want) {
+					t.Errorf("PROXY header = %q, want %q", sent[0], want)
+				}
+				if string(sent[1]) != "hello" {
+					t.Errorf("Expected application data after handshake, got %q", sent[1])
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("Timed out waiting for upstream to receive data")
+			}
+		})
+	}
+}
+
+func TestValidProxyProtocol(t *testing.T) {
+	for _, v := range []string{"", ProxyProtocolV1, ProxyProtocolV2} {
+		if !ValidProxyProtocol(v) {
+			t.Errorf("Expected %q to be valid", v)
+		}
+	}
+	if ValidProxyProtocol("v3") {
+		t.Error("Expected v3 to be invalid")
+	}
+}
+
+func TestUpstreamClient_Stats(t *testing.T) {
+	addr := startSOCKS5Server(t)
+	client := NewUpstreamClient(config.UpstreamConfig{Enable: true, Type: "socks5", Addr: addr})
+	defer client.Close()
+
+	for range 3 {
+		conn, err := client.Connect("127.0.0.1", 443)
+		if err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		conn.Close()
+	}
+
+	// A closed port fails to dial
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	deadAddr := listener.Addr().String()
+	listener.Close()
+	client.config.Addr = deadAddr
+	if _, err := client.Connect("127.0.0.1", 443); err == nil {
+		t.Fatal("Expected connect through a closed upstream to fail")
+	}
+
+	stats := client.Stats()
+	if stats.Attempts != 4 || stats.Successes != 3 || stats.Failures != 1 {
+		t.Errorf("Expected 4 attempts, 3 successes and 1 failure, got %+v", stats)
+	}
+	if stats.SuccessRate != 75 {
+		t.Errorf("Expected 75%% success rate, got %v", stats.SuccessRate)
+	}
+	if stats.AvgConnectTime <= 0 {
+		t.Errorf("Expected a positive average connect time, got %v", stats.AvgConnectTime)
+	}
+
+	if stats := NewUpstreamClient(config.UpstreamConfig{}).Stats(); stats.Attempts != 0 || stats.SuccessRate != 0 {
+		t.Errorf("Expected empty stats for an unused client, got %+v", stats)
+	}
+}
@@ -190,6 +190,11 @@ export function TrafficItem({ event, bodyExpanded }: TrafficItemProps) {
                   <JsonBody body={request.body} contentType={request.content_type} />
                 </CollapsibleSection>
               )}
+              {request?.trailers && (
+                <CollapsibleSection title="Request Trailers">
+                  <HeadersDisplay headers={request.trailers} />
+                </CollapsibleSection>
+              )}
             </>
           )}
 
@@ -203,6 +208,11 @@ export function TrafficItem({ event, bodyExpanded }: TrafficItemProps) {
                   <JsonBody body={response.body} contentType={response.content_type} />
                 </CollapsibleSection>
               )}
+              {response?.trailers && (
+                <CollapsibleSection title="Response Trailers">
+                  <HeadersDisplay headers={response.trailers} />
+                </CollapsibleSection>
+              )}
             </>
           )}
         </div>
@@ -21,6 +21,7 @@ export interface TrafficEvent {
     headers?: Record<string, string>;
     body?: string;
     content_type?: string;
+    trailers?: Record<string, string>;
   };
   response?: {
     status_code?: number;
@@ -29,6 +30,7 @@ export interface TrafficEvent {
     body?: string;
     content_type?: string;
     latency?: number;
+    trailers?: Record<string, string>;
   };
 }
 
@@ -62,6 +64,18 @@ export interface ToolCall {
   };
 }
 
+export interface ToolOutline {
+  name: string;
+  params?: string[];
+  required?: string[];
+}
+
+export interface ToolSummary {
+  count: number;
+  names: string[];
+  tools: ToolOutline[];
+}
+
 export interface LLMMessageEvent {
   id: string;
   timestamp: string;
@@ -70,10 +84,12 @@ export interface LLMMessageEvent {
   token_count?: number;
   total_tokens?: number;
   model?: string;
+  tools?: ToolSummary;
 }
 
 export interface LLMTokenEvent {
   id: string;
+  type: "message_start" | "message_delta" | "message_end";
   conversation_id: string;
   delta: string;
   thinking?: string;
@@ -95,6 +111,13 @@ export interface ConversationUpdateEvent {
   model?: string;
 }
 
+export interface ConversationDeletedEvent {
+  id: string;
+  timestamp: string;
+  conversation_id?: string;
+  all?: boolean;
+}
+
 // Simple observable for events
 type Subscriber<T> = (event: T) => void;
 
@@ -355,6 +378,11 @@ class LLMConversationStore {
     this.observable.emit(this.getAll());
   }
 
+  remove(conversationId: string): void {
+    if (!this.conversationsMap.delete(conversationId)) return;
+    this.observable.emit(this.getAll());
+  }
+
   setCurrentId(id: string | null): void {
     this.currentId$.emit(id);
   }
@@ -372,12 +400,14 @@ class LLMSSEConnection {
   private messageEvents$: EventObservable<LLMMessageEvent>;
   private tokenEvents$: EventObservable<LLMTokenEvent>;
   private conversationEvents$: EventObservable<ConversationUpdateEvent>;
+  private deletedEvents$: EventObservable<ConversationDeletedEvent>;
 
   constructor() {
     this.connection = new SharedSSEConnection();
     this.messageEvents$ = new EventObservable<LLMMessageEvent>();
     this.tokenEvents$ = new EventObservable<LLMTokenEvent>();
     this.conversationEvents$ = new EventObservable<ConversationUpdateEvent>();
+    this.deletedEvents$ = new EventObservable<ConversationDeletedEvent>();
   }
 
   connect(url: string): void {
@@ -391,6 +421,9 @@ class LLMSSEConnection {
       conversation: (data: unknown) => {
         this.conversationEvents$.emit(data as ConversationUpdateEvent);
       },
+      conversation_deleted: (data: unknown) => {
+        this.deletedEvents$.emit(data as ConversationDeletedEvent);
+      },
       welcome: () => {
         // Welcome is handled by SharedSSEConnection
       },
@@ -410,6 +443,7 @@ class LLMSSEConnection {
       message: this.messageEvents$,
       token: this.tokenEvents$,
       conversation: this.conversationEvents$,
+      deleted: this.deletedEvents$,
     };
   }
 }
@@ -423,6 +457,7 @@ interface SSEContextType {
     message: EventObservable<LLMMessageEvent>;
     token: EventObservable<LLMTokenEvent>;
     conversation: EventObservable<ConversationUpdateEvent>;
+    deleted: EventObservable<ConversationDeletedEvent>;
   };
   llmConversations$: EventObservable<Conversation[]>;
   llmCurrentId$: EventObservable<string | null>;
@@ -670,6 +705,16 @@ export function SSEProvider({ children }: SSEProviderProps) {
       },
     );
 
+    const unsubDeleted = llmEvents$.deleted.subscribe(
+      (event: ConversationDeletedEvent) => {
+        if (event.all) {
+          llmStore.clear();
+        } else if (event.conversation_id) {
+          llmStore.remove(event.conversation_id);
+        }
+      },
+    );
+
     // Update connection status
     const checkConnection = setInterval(() => {
       setIsLLMConnected(llmConnection.getConnectionStatus());
@@ -680,6 +725,7 @@ export function SSEProvider({ children }: SSEProviderProps) {
       unsubMessage();
       unsubToken();
       unsubConversation();
+      unsubDeleted();
       llmConnection.disconnect();
     };
   }, [llmConnection, llmEvents$, llmStore]);
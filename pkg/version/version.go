@@ -1,7 +1,10 @@
 package version
 
 import (
+	"fmt"
+	"runtime"
 	"runtime/debug"
+	"strings"
 )
 
 var (
@@ -14,8 +17,44 @@ var (
 )
 
 func init() {
-	info, _ := debug.ReadBuildInfo()
-	if info.Main.Version != "" {
+	// Fall back to module version (go install) only when ldflags didn't set one
+	if Version != "dev" {
+		return
+	}
+	info, ok := debug.ReadBuildInfo()
+	if ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
 		Version = info.Main.Version
 	}
 }
+
+// Info holds the build metadata of the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build metadata of the running binary
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// String formats build metadata for the version command
+func (i Info) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "linko version %s\n", i.Version)
+	if i.Commit != "" {
+		fmt.Fprintf(&b, "commit: %s\n", i.Commit)
+	}
+	if i.Date != "" {
+		fmt.Fprintf(&b, "built: %s\n", i.Date)
+	}
+	fmt.Fprintf(&b, "go: %s\n", i.GoVersion)
+	return b.String()
+}